@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+func main() {
+	// Credentials of the cloud vendors are read from .env like the server
+	godotenv.Load()
+
+	vendor := flag.String("vendor", "whisper", "Transcription vendor: google, azure, baidu, xunfei, aliyun, tencent, deepgram, speechmatics, soniox, riva, kaldi, vosk, coqui, openai, whisper, mock, exec, grpc")
+	execCmd := flag.String("exec.cmd", os.Getenv("EXEC_CMD"), "Plugin run with sh -c for each stream of --vendor=exec, see docs/EXEC_SETUP.md")
+	punctuate := flag.String("punctuate", os.Getenv("PUNCTUATE_VENDORS"), "Vendors whose results get their punctuation and casing restored, e.g. \"baidu,vosk\"")
+	postprocess := flag.String("postprocess", os.Getenv("POSTPROCESS"), "Processors applied in order to the results: normalize, replace, redact, e.g. \"replace,redact\"")
+	replacements := flag.String("postprocess.replacements", os.Getenv("POSTPROCESS_REPLACEMENTS"), "File of the \"from => to\" replacements of the replace processor")
+	model := flag.String("model", "small", "Whisper model: tiny, base, small, medium, large")
+	language := flag.String("language", "auto", "Source language (e.g., en, cn, auto)")
+	output := flag.String("output", "", "Directory receiving <name>.txt for each file (transcripts are printed when empty)")
+	recursive := flag.Bool("recursive", false, "Transcribe the files of the subdirectories too")
+	ffmpegPath := flag.String("ffmpeg", "ffmpeg", "ffmpeg executable decoding the non WAV files")
+	verbose := flag.Bool("verbose", false, "Log the progress of the transcription services")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <file or directory>...\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Transcribes audio files offline with the vendors of the server.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s --vendor=whisper --language=en *.wav\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --recursive --output=transcripts meetings/\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nThe cloud vendors read the same environment variables as transcribe-server\n")
+		fmt.Fprintf(os.Stderr, "(GOOGLE_CREDENTIALS, AZURE_SPEECH_KEY, ...).\n")
+	}
+	flag.Parse()
+	// The vendors read the plugin command from the environment
+	if *execCmd != "" {
+		os.Setenv("EXEC_CMD", *execCmd)
+	}
+	transcribe.SetPunctuation(strings.Split(*punctuate, ","))
+	chain, err := transcribe.NewProcessors(strings.Split(*postprocess, ","), transcribe.ProcessorOptions{Replacements: *replacements})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --postprocess: %v\n", err)
+		os.Exit(2)
+	}
+	transcribe.SetProcessors(chain)
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(run(flag.Args(), *vendor, *model, *language, *output, *ffmpegPath, *recursive))
+}
+
+// run transcribes the files and returns the exit code
+func run(args []string, vendor, model, language, output, ffmpegPath string, recursive bool) int {
+	files, err := collect(args, recursive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no audio file to transcribe\n")
+		return 1
+	}
+	if output != "" {
+		if err := os.MkdirAll(output, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	// Whisper writes its WAV files to a scratch directory removed on exit
+	scratch, err := ioutil.TempDir("", "transcribe-cli")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(scratch)
+
+	service, err := transcribe.SelectVendor(context.Background(), os.Getenv("GOOGLE_CREDENTIALS"), vendor, model, scratch, language, false, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// SelectVendor falls back to the recorder, which transcribes nothing
+	if _, ok := service.(*transcribe.RecorderTranscriber); ok {
+		fmt.Fprintf(os.Stderr, "Error: vendor %s is not available (run with --verbose for details)\n", vendor)
+		return 1
+	}
+
+	failed := 0
+	for _, file := range files {
+		text, err := transcribeFile(service, file, language, ffmpegPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			failed++
+			continue
+		}
+		if output == "" {
+			if len(files) > 1 {
+				fmt.Printf("==> %s <==\n", file)
+			}
+			fmt.Println(text)
+			continue
+		}
+		path := filepath.Join(output, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))+".txt")
+		if err := ioutil.WriteFile(path, []byte(text+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s -> %s\n", file, path)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed\n", failed, len(files))
+		return 1
+	}
+	return 0
+}
+
+// collect expands the arguments to the audio files to transcribe, files
+// are taken as given and directories are searched for audio files
+func collect(args []string, recursive bool) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != arg && (!recursive || strings.HasPrefix(info.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasPrefix(info.Name(), ".") && audio.IsAudioFile(path) {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// transcribeFile streams the decoded audio of a file through the service
+// and returns its final results, one per line
+func transcribeFile(service transcribe.Service, path, language, ffmpegPath string) (string, error) {
+	pcm, err := audio.DecodeFile(path, ffmpegPath, transcribe.PipelineSampleRate)
+	if err != nil {
+		return "", err
+	}
+	stream, err := service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   language,
+		Transcribe: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription stream: %w", err)
+	}
+	var lines []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range stream.Results() {
+			if text := strings.TrimSpace(result.Text); result.Final && text != "" {
+				lines = append(lines, text)
+			}
+		}
+	}()
+
+	var writeErr error
+	for len(pcm) > 0 {
+		// Write 100ms chunks like a live stream
+		n := 9600
+		if n > len(pcm) {
+			n = len(pcm)
+		}
+		if _, writeErr = stream.Write(pcm[:n]); writeErr != nil {
+			break
+		}
+		pcm = pcm[n:]
+	}
+	closeErr := stream.Close()
+	<-done
+	if writeErr != nil {
+		return "", writeErr
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/alert"
+	"github.com/walterfan/webrtc-transcriber/internal/chat"
+	"github.com/walterfan/webrtc-transcriber/internal/features"
+	"github.com/walterfan/webrtc-transcriber/internal/models"
+	"github.com/walterfan/webrtc-transcriber/internal/quota"
+	"github.com/walterfan/webrtc-transcriber/internal/telegram"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+	"github.com/walterfan/webrtc-transcriber/internal/watch"
+	"github.com/walterfan/webrtc-transcriber/internal/webhook"
+)
+
+// checkConfigOptions are the settings validated by --check-config
+type checkConfigOptions struct {
+	Vendor          string
+	Model           string
+	ModelsDir       string
+	Output          string
+	Language        string
+	Probe           bool // Authenticate with the vendor
+	Features        string
+	AlertThresholds string
+	WebhookTemplate string
+	WatchConfig     string
+	DeadLetter      string
+	Quotas          string
+	ResultsPolicy   string
+}
+
+// checkStatus is the outcome of a check, only failures make the check
+// exit non-zero
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "OK"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+)
+
+type checkResult struct {
+	status checkStatus
+	name   string
+	detail string
+}
+
+// checkReport collects the results of the configuration checks
+type checkReport struct {
+	results []checkResult
+}
+
+func (r *checkReport) add(status checkStatus, name, format string, args ...interface{}) {
+	r.results = append(r.results, checkResult{status: status, name: name, detail: fmt.Sprintf(format, args...)})
+}
+
+// print writes the report and returns the number of failures
+func (r *checkReport) print(out io.Writer) int {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	failures, warnings := 0, 0
+	for _, c := range r.results {
+		fmt.Fprintf(w, "[%s]\t%s\t%s\n", c.status, c.name, c.detail)
+		switch c.status {
+		case checkFail:
+			failures++
+		case checkWarn:
+			warnings++
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d checks, %d failed, %d warnings\n", len(r.results), failures, warnings)
+	return failures
+}
+
+// runCheckConfig validates the configuration without starting the server,
+// prints a report and returns the exit code
+func runCheckConfig(opts checkConfigOptions) int {
+	ctx := context.Background()
+	report := &checkReport{}
+
+	checkVendor(ctx, report, opts)
+	checkDirectory(report, "output directory", opts.Output, checkFail)
+	checkDirectory(report, "dead letter directory", filepath.Dir(opts.DeadLetter), checkFail)
+	if opts.Vendor == "whisper" {
+		checkDirectory(report, "models directory", opts.ModelsDir, checkWarn)
+	}
+
+	if _, err := features.Parse(opts.Features); err != nil {
+		report.add(checkFail, "--features", "%v", err)
+	}
+	if _, err := alert.ParseThresholds(opts.AlertThresholds); err != nil {
+		report.add(checkFail, "--alert.thresholds", "%v", err)
+	}
+	if _, err := quota.ParseLimits(opts.Quotas); err != nil {
+		report.add(checkFail, "--quota.minutes", "%v", err)
+	}
+	if err := (transcribe.Backpressure{Policy: opts.ResultsPolicy}).Validate(); err != nil {
+		report.add(checkFail, "--results.policy", "%v", err)
+	}
+	if urls := splitList(os.Getenv("WEBHOOK_URLS")); len(urls) > 0 || opts.WebhookTemplate != "" {
+		if len(urls) == 0 {
+			urls = []string{"http://localhost"}
+		}
+		if _, err := webhook.NewSinks(webhook.Config{URLs: urls, TemplateFile: opts.WebhookTemplate}); err != nil {
+			report.add(checkFail, "webhooks", "%v", err)
+		} else {
+			report.add(checkOK, "webhooks", "%d URLs", len(splitList(os.Getenv("WEBHOOK_URLS"))))
+		}
+	}
+	if _, err := chat.ParseRoutes(chat.Slack, os.Getenv("SLACK_WEBHOOK_URLS")); err != nil {
+		report.add(checkFail, "SLACK_WEBHOOK_URLS", "%v", err)
+	}
+	if _, err := chat.ParseRoutes(chat.Discord, os.Getenv("DISCORD_WEBHOOK_URLS")); err != nil {
+		report.add(checkFail, "DISCORD_WEBHOOK_URLS", "%v", err)
+	}
+	if _, err := telegram.ParseChats(os.Getenv("TELEGRAM_CHATS")); err != nil {
+		report.add(checkFail, "TELEGRAM_CHATS", "%v", err)
+	}
+	if opts.WatchConfig != "" {
+		folders, err := watch.LoadFolders(opts.WatchConfig)
+		if err != nil {
+			report.add(checkFail, "--watch.config", "%v", err)
+		}
+		for _, f := range folders {
+			checkDirectory(report, "drop folder", f.Path, checkFail)
+		}
+	}
+
+	if report.print(os.Stdout) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkVendor checks the credentials or the installation of the vendor,
+// and authenticates with it when probing
+func checkVendor(ctx context.Context, report *checkReport, opts checkConfigOptions) {
+	if strings.ContainsAny(opts.Vendor, ",>") {
+		for _, name := range strings.FieldsFunc(opts.Vendor, isVendorSeparator) {
+			vendorOpts := opts
+			if vendorOpts.Vendor = strings.TrimSpace(name); vendorOpts.Vendor == "" {
+				continue
+			}
+			checkVendor(ctx, report, vendorOpts)
+		}
+		return
+	}
+
+	var service transcribe.Service
+	var err error
+	switch opts.Vendor {
+	case "google":
+		cred := os.Getenv("GOOGLE_CREDENTIALS")
+		if cred == "" {
+			report.add(checkFail, "google", "GOOGLE_CREDENTIALS is not set")
+			return
+		}
+		if _, err := os.Stat(cred); err != nil {
+			report.add(checkFail, "google", "%v", err)
+			return
+		}
+		service, err = transcribe.NewGoogleSpeech(ctx, cred, os.Getenv("GOOGLE_SPEECH_LOCATION"), os.Getenv("GOOGLE_SPEECH_MODEL"))
+
+	case "azure":
+		key, region := os.Getenv("AZURE_SPEECH_KEY"), os.Getenv("AZURE_SPEECH_REGION")
+		if missing := missingEnv("AZURE_SPEECH_KEY", "AZURE_SPEECH_REGION"); missing != "" {
+			report.add(checkFail, "azure", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewAzureTranscriber(ctx, key, region)
+
+	case "baidu":
+		if missing := missingEnv("BAIDU_APP_ID", "BAIDU_API_KEY", "BAIDU_SECRET_KEY"); missing != "" {
+			report.add(checkFail, "baidu", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewBaiduTranscriber(ctx, os.Getenv("BAIDU_APP_ID"), os.Getenv("BAIDU_API_KEY"), os.Getenv("BAIDU_SECRET_KEY"))
+
+	case "xunfei":
+		if missing := missingEnv("XUNFEI_APP_ID", "XUNFEI_API_KEY", "XUNFEI_API_SECRET"); missing != "" {
+			report.add(checkFail, "xunfei", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewIflyTekTranscriber(ctx, os.Getenv("XUNFEI_APP_ID"), os.Getenv("XUNFEI_API_KEY"), os.Getenv("XUNFEI_API_SECRET"), os.Getenv("XUNFEI_API_URL"))
+
+	case "aliyun":
+		if missing := missingEnv("ALIYUN_ACCESS_KEY_ID", "ALIYUN_ACCESS_KEY_SECRET", "ALIYUN_NLS_APPKEY"); missing != "" {
+			report.add(checkFail, "aliyun", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewAliyunTranscriber(ctx, os.Getenv("ALIYUN_ACCESS_KEY_ID"), os.Getenv("ALIYUN_ACCESS_KEY_SECRET"), os.Getenv("ALIYUN_NLS_APPKEY"), os.Getenv("ALIYUN_NLS_REGION"))
+
+	case "tencent":
+		if missing := missingEnv("TENCENT_APP_ID", "TENCENT_SECRET_ID", "TENCENT_SECRET_KEY"); missing != "" {
+			report.add(checkFail, "tencent", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewTencentTranscriber(ctx, os.Getenv("TENCENT_APP_ID"), os.Getenv("TENCENT_SECRET_ID"), os.Getenv("TENCENT_SECRET_KEY"), os.Getenv("TENCENT_ASR_ENGINE"))
+
+	case "deepgram":
+		if missing := missingEnv("DEEPGRAM_API_KEY"); missing != "" {
+			report.add(checkFail, "deepgram", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewDeepgramTranscriber(ctx, os.Getenv("DEEPGRAM_API_KEY"), os.Getenv("DEEPGRAM_MODEL"))
+
+	case "speechmatics":
+		if missing := missingEnv("SPEECHMATICS_API_KEY"); missing != "" {
+			report.add(checkFail, "speechmatics", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewSpeechmaticsTranscriber(ctx, os.Getenv("SPEECHMATICS_API_KEY"), os.Getenv("SPEECHMATICS_URL"))
+
+	case "soniox":
+		if missing := missingEnv("SONIOX_API_KEY"); missing != "" {
+			report.add(checkFail, "soniox", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewSonioxTranscriber(ctx, os.Getenv("SONIOX_API_KEY"), os.Getenv("SONIOX_MODEL"))
+
+	case "riva":
+		if missing := missingEnv("RIVA_SERVER"); missing != "" {
+			report.add(checkFail, "riva", "%s not set", missing)
+			return
+		}
+		rate, _ := strconv.Atoi(os.Getenv("RIVA_SAMPLE_RATE"))
+		service, err = transcribe.NewRivaTranscriber(ctx, os.Getenv("RIVA_SERVER"), os.Getenv("RIVA_MODEL"), rate, os.Getenv("RIVA_SSL") == "true")
+
+	case "kaldi":
+		if missing := missingEnv("KALDI_SERVER_URL"); missing != "" {
+			report.add(checkFail, "kaldi", "%s not set", missing)
+			return
+		}
+		rate, _ := strconv.Atoi(os.Getenv("KALDI_SAMPLE_RATE"))
+		service, err = transcribe.NewKaldiTranscriber(ctx, os.Getenv("KALDI_SERVER_URL"), rate)
+
+	case "vosk":
+		if os.Getenv("VOSK_MODEL_PATH") == "" && os.Getenv("VOSK_SERVER_URL") == "" {
+			report.add(checkFail, "vosk", "VOSK_MODEL_PATH or VOSK_SERVER_URL not set")
+			return
+		}
+		service, err = transcribe.NewVoskTranscriber(ctx, os.Getenv("VOSK_MODEL_PATH"), os.Getenv("VOSK_SERVER_URL"), os.Getenv("VOSK_SERVER_PATH"))
+
+	case "coqui":
+		if missing := missingEnv("COQUI_MODEL_PATH"); missing != "" {
+			report.add(checkFail, "coqui", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewCoquiTranscriber(ctx, os.Getenv("COQUI_MODEL_PATH"), os.Getenv("COQUI_SCORER_PATH"), os.Getenv("COQUI_STT_PATH"), os.TempDir(), false, false)
+
+	case "openai":
+		if missing := missingEnv("OPENAI_API_KEY"); missing != "" {
+			report.add(checkFail, "openai", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewOpenAITranscriber(ctx, os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_TRANSCRIBE_MODEL"), os.TempDir(), opts.Language, false, false)
+
+	case "whisper":
+		if serverURL := os.Getenv("WHISPER_SERVER_URL"); serverURL != "" {
+			service, err = transcribe.NewWhisperServerTranscriber(ctx, serverURL, os.Getenv("WHISPER_SERVER_API"), os.Getenv("WHISPER_SERVER_KEY"), os.Getenv("WHISPER_SERVER_MODEL"), os.TempDir(), opts.Language, false, false)
+			break
+		}
+		if cppModel := os.Getenv("WHISPER_CPP_MODEL"); cppModel != "" {
+			service, err = transcribe.NewWhisperNativeTranscriber(ctx, cppModel, os.TempDir(), opts.Language, false, false)
+			break
+		}
+		store := models.NewStore(opts.ModelsDir, "")
+		model := opts.Model
+		if path, ok := store.Path(model); ok {
+			model = path
+			report.add(checkOK, "whisper model", "%s", path)
+		} else if _, statErr := os.Stat(model); statErr == nil {
+			report.add(checkOK, "whisper model", "%s", model)
+		} else {
+			report.add(checkWarn, "whisper model", "%s is not in %s, Whisper downloads it on first use (see \"models download\")", model, store.Dir())
+		}
+		service, err = transcribe.NewWhisperTranscriber(ctx, model, os.Getenv("WHISPER_PATH"), os.TempDir(), opts.Language, false, false)
+		if err != nil {
+			// The server falls back to the recorder, which transcribes nothing
+			report.add(checkFail, "whisper", "%v", err)
+			return
+		}
+
+	case "recorder":
+		report.add(checkOK, "recorder", "records without transcribing")
+		return
+
+	case "exec":
+		if missing := missingEnv("EXEC_CMD"); missing != "" {
+			report.add(checkFail, "exec", "%s not set (--exec.cmd)", missing)
+			return
+		}
+		service, err = transcribe.NewExecTranscriber(ctx, os.Getenv("EXEC_CMD"))
+
+	case "grpc":
+		if missing := missingEnv("GRPC_ASR_SERVER"); missing != "" {
+			report.add(checkFail, "grpc", "%s not set", missing)
+			return
+		}
+		service, err = transcribe.NewGRPCTranscriber(ctx, os.Getenv("GRPC_ASR_SERVER"), os.Getenv("GRPC_ASR_TOKEN"), os.Getenv("GRPC_ASR_TLS") == "true")
+
+	case "mock":
+		delay, parseErr := time.ParseDuration(os.Getenv("MOCK_DELAY"))
+		if parseErr != nil && os.Getenv("MOCK_DELAY") != "" {
+			report.add(checkFail, "mock", "invalid MOCK_DELAY: %v", parseErr)
+			return
+		}
+		service, err = transcribe.NewMockTranscriber(ctx, os.Getenv("MOCK_SCRIPT"), delay, os.Getenv("MOCK_CREATE_ERROR"))
+
+	case "worker":
+		if missing := missingEnv("REDIS_URL", "WORKER_TOKEN"); missing != "" {
+			report.add(checkFail, "worker", "%s not set", missing)
+			return
+		}
+		report.add(checkOK, "worker", "records and queues the transcriptions for transcribe-worker")
+		return
+
+	default:
+		report.add(checkFail, "--vendor", "unsupported vendor %q", opts.Vendor)
+		return
+	}
+	if err != nil {
+		report.add(checkFail, opts.Vendor, "%v", err)
+		return
+	}
+
+	detail := "credentials set"
+	if w, ok := service.(*transcribe.WhisperTranscriber); ok {
+		detail = "executable " + w.Executable()
+		if os.Getenv("WHISPER_SERVER_URL") != "" {
+			detail = "Whisper server " + w.Executable()
+		} else if os.Getenv("WHISPER_CPP_MODEL") != "" {
+			detail = "whisper.cpp model " + w.Executable()
+		}
+	}
+	if e, ok := service.(*transcribe.ExecTranscriber); ok {
+		detail = "plugin " + e.Executable()
+	}
+	if _, ok := service.(*transcribe.MockTranscriber); ok {
+		detail = "scripted results, nothing is transcribed"
+	}
+	prober, ok := service.(transcribe.Prober)
+	if !opts.Probe || !ok {
+		report.add(checkOK, opts.Vendor, "%s", detail)
+		return
+	}
+	if err := prober.Probe(ctx); err != nil {
+		report.add(checkFail, opts.Vendor, "%s, probe failed: %v", detail, err)
+		return
+	}
+	report.add(checkOK, opts.Vendor, "%s, probe succeeded", detail)
+}
+
+// checkDirectory checks that files can be created in a directory, or that
+// it can be created
+func checkDirectory(report *checkReport, name, dir string, severity checkStatus) {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		parent := filepath.Dir(dir)
+		for parent != filepath.Dir(parent) {
+			if _, err := os.Stat(parent); err == nil {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		if err := probeWrite(parent); err != nil {
+			report.add(severity, name, "%s does not exist and cannot be created: %v", dir, err)
+			return
+		}
+		report.add(checkOK, name, "%s (created on startup)", dir)
+		return
+	}
+	if err != nil {
+		report.add(severity, name, "%v", err)
+		return
+	}
+	if !info.IsDir() {
+		report.add(severity, name, "%s is not a directory", dir)
+		return
+	}
+	if err := probeWrite(dir); err != nil {
+		report.add(severity, name, "%s is not writable: %v", dir, err)
+		return
+	}
+	report.add(checkOK, name, "%s", dir)
+}
+
+// probeWrite creates and removes a file in the directory
+func probeWrite(dir string) error {
+	file, err := ioutil.TempFile(dir, ".check-config-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// missingEnv returns the first unset environment variable
+func missingEnv(names ...string) string {
+	for _, name := range names {
+		if os.Getenv(name) == "" {
+			return name
+		}
+	}
+	return ""
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -17,23 +20,60 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/walterfan/webrtc-transcriber/internal/alert"
+	"github.com/walterfan/webrtc-transcriber/internal/ask"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/captions"
+	"github.com/walterfan/webrtc-transcriber/internal/catalog"
+	"github.com/walterfan/webrtc-transcriber/internal/chat"
+	"github.com/walterfan/webrtc-transcriber/internal/cluster"
+	"github.com/walterfan/webrtc-transcriber/internal/compare"
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+	"github.com/walterfan/webrtc-transcriber/internal/features"
+	"github.com/walterfan/webrtc-transcriber/internal/feeds"
+	"github.com/walterfan/webrtc-transcriber/internal/graphql"
+	"github.com/walterfan/webrtc-transcriber/internal/grpcapi"
+	"github.com/walterfan/webrtc-transcriber/internal/hls"
+	"github.com/walterfan/webrtc-transcriber/internal/intent"
+	"github.com/walterfan/webrtc-transcriber/internal/jobs"
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+	"github.com/walterfan/webrtc-transcriber/internal/models"
+	"github.com/walterfan/webrtc-transcriber/internal/mqtt"
+	"github.com/walterfan/webrtc-transcriber/internal/quota"
+	"github.com/walterfan/webrtc-transcriber/internal/redis"
 	"github.com/walterfan/webrtc-transcriber/internal/rtc"
+	"github.com/walterfan/webrtc-transcriber/internal/rtmp"
 	"github.com/walterfan/webrtc-transcriber/internal/session"
+	"github.com/walterfan/webrtc-transcriber/internal/sip"
+	"github.com/walterfan/webrtc-transcriber/internal/stats"
+	"github.com/walterfan/webrtc-transcriber/internal/streaming"
+	"github.com/walterfan/webrtc-transcriber/internal/systemd"
+	"github.com/walterfan/webrtc-transcriber/internal/telegram"
+	"github.com/walterfan/webrtc-transcriber/internal/tenant"
 	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+	"github.com/walterfan/webrtc-transcriber/internal/turn"
+	"github.com/walterfan/webrtc-transcriber/internal/twilio"
+	"github.com/walterfan/webrtc-transcriber/internal/watch"
+	"github.com/walterfan/webrtc-transcriber/internal/webhook"
+	"github.com/walterfan/webrtc-transcriber/internal/whip"
+	"github.com/walterfan/webrtc-transcriber/internal/wsaudio"
 )
 
 const (
-	httpDefaultPort      = "9070"
-	defaultStunServer    = "stun:stun.l.google.com:19302"
-	defaultRecordingsDir = "recordings"
-	sessionCookieName    = "session_token"
-	sessionDuration      = 24 * time.Hour
+	httpDefaultPort   = "9070"
+	defaultStunServer = "stun:stun.l.google.com:19302"
+	sessionCookieName = "session_token"
+	sessionDuration   = 24 * time.Hour
+	shutdownTimeout   = 10 * time.Second
 )
 
-// Session management
+// Session management, the sessions are kept in Redis when the replicas
+// share them
 type SessionStore struct {
 	sessions map[string]SessionData
 	mu       sync.RWMutex
+	shared   *cluster.Cluster
 }
 
 type SessionData struct {
@@ -45,12 +85,23 @@ var sessionStore = &SessionStore{
 	sessions: make(map[string]SessionData),
 }
 
-// accounts stores username:password pairs loaded from environment
-var accounts = make(map[string]string)
+// accounts stores username:password pairs loaded from environment,
+// accountsMu guards them and the admins as SIGHUP reloads both
+var (
+	accounts   = make(map[string]string)
+	accountsMu sync.RWMutex
+)
 
 // loadAccounts parses the accounts from environment variable
 // Format: "alice:abc, walter:abd"
 func loadAccounts() {
+	loaded := make(map[string]string)
+	defer func() {
+		accountsMu.Lock()
+		accounts = loaded
+		accountsMu.Unlock()
+	}()
+
 	accountsEnv := os.Getenv("accounts")
 	if accountsEnv == "" {
 		log.Printf("Warning: No accounts configured in .env file (accounts=username:password,...)")
@@ -67,16 +118,90 @@ func loadAccounts() {
 		if len(parts) == 2 {
 			username := strings.TrimSpace(parts[0])
 			password := strings.TrimSpace(parts[1])
-			accounts[username] = password
+			loaded[username] = password
 			log.Printf("Loaded account: %s", username)
 		}
 	}
 
-	if len(accounts) == 0 {
+	if len(loaded) == 0 {
 		log.Printf("Warning: No valid accounts found in accounts environment variable")
 	}
 }
 
+// admins stores the usernames allowed to use the admin endpoints, nobody
+// may use them when it is empty
+var admins = make(map[string]bool)
+
+// loadAdmins parses the admin usernames from environment variable
+// Format: "alice, walter"
+func loadAdmins() {
+	loaded := make(map[string]bool)
+	for _, username := range strings.Split(os.Getenv("admins"), ",") {
+		username = strings.TrimSpace(username)
+		if username != "" {
+			loaded[username] = true
+		}
+	}
+	accountsMu.Lock()
+	admins = loaded
+	accountsMu.Unlock()
+
+	if len(loaded) == 0 {
+		log.Printf("Warning: No admins configured (admins=username,...), the admin endpoints are disabled")
+	}
+}
+
+// tenants holds the organizations sharing the deployment, see --tenants.config
+var tenants = &tenant.Registry{}
+
+// isAdmin checks if a user may access the admin endpoints, the users of a
+// tenant administer at most their tenant
+func isAdmin(username string) bool {
+	if tenants.Of(username) != "" {
+		return false
+	}
+	accountsMu.RLock()
+	defer accountsMu.RUnlock()
+	return admins[username]
+}
+
+// userRole returns the quota role of a user
+func userRole(username string) string {
+	if isAdmin(username) {
+		return quota.RoleAdmin
+	}
+	return quota.RoleUser
+}
+
+// accountNames returns the usernames of the configured accounts
+func accountNames() []string {
+	accountsMu.RLock()
+	defer accountsMu.RUnlock()
+	usernames := make([]string, 0, len(accounts))
+	for username := range accounts {
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// isTenantAdmin checks if a user may see every session of their tenant
+func isTenantAdmin(username string) bool {
+	return isAdmin(username) || tenants.IsAdmin(username)
+}
+
+// tenantUsers exposes the accounts of a tenant to the GraphQL API
+type tenantUsers struct {
+	id string
+}
+
+func (u tenantUsers) Usernames() []string {
+	return tenants.Members(u.id, accountNames())
+}
+
+func (tenantUsers) IsAdmin(username string) bool {
+	return isTenantAdmin(username)
+}
+
 // generateSessionToken creates a random session token
 func generateSessionToken() string {
 	bytes := make([]byte, 32)
@@ -84,21 +209,31 @@ func generateSessionToken() string {
 	return hex.EncodeToString(bytes)
 }
 
-// createSession creates a new session for a user
-func (s *SessionStore) createSession(username string) string {
+// createSession creates a new session for a user, it fails when the
+// session cannot be shared with the other replicas
+func (s *SessionStore) createSession(username string) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	token := generateSessionToken()
+	if s.shared != nil {
+		if err := s.shared.CreateLogin(token, username, sessionDuration); err != nil {
+			return "", err
+		}
+		return token, nil
+	}
 	s.sessions[token] = SessionData{
 		Username:  username,
 		ExpiresAt: time.Now().Add(sessionDuration),
 	}
-	return token
+	return token, nil
 }
 
 // validateSession checks if a session token is valid
 func (s *SessionStore) validateSession(token string) (string, bool) {
+	if s.shared != nil {
+		return s.shared.Login(token)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -114,11 +249,59 @@ func (s *SessionStore) validateSession(token string) (string, bool) {
 
 // deleteSession removes a session
 func (s *SessionStore) deleteSession(token string) {
+	if s.shared != nil {
+		if err := s.shared.DeleteLogin(token); err != nil {
+			log.Printf("Error deleting the shared session: %v", err)
+		}
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.sessions, token)
 }
 
+// revokeRemovedAccounts removes the sessions of the users whose account
+// was removed by a reload
+func (s *SessionStore) revokeRemovedAccounts() {
+	if s.shared != nil {
+		revoked, err := s.shared.RevokeLogins(func(username string) bool {
+			accountsMu.RLock()
+			defer accountsMu.RUnlock()
+			_, ok := accounts[username]
+			return ok
+		})
+		if err != nil {
+			log.Printf("Error revoking the shared sessions: %v", err)
+		}
+		if revoked > 0 {
+			log.Printf("Revoked %d sessions of removed accounts", revoked)
+		}
+		return
+	}
+	accountsMu.RLock()
+	defer accountsMu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.sessions {
+		if _, ok := accounts[session.Username]; !ok {
+			delete(s.sessions, token)
+			log.Printf("Revoked the session of removed account %s", session.Username)
+		}
+	}
+}
+
+// reloadConfig re-reads the .env file and the accounts and admins on
+// SIGHUP, the other settings require a restart
+func reloadConfig() {
+	if err := godotenv.Overload(); err != nil {
+		log.Printf("Warning: Error loading .env file: %v", err)
+	}
+	loadAccounts()
+	loadAdmins()
+	sessionStore.revokeRemovedAccounts()
+	log.Printf("Configuration reloaded")
+}
+
 // authMiddleware wraps handlers to require authentication
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -134,16 +317,46 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		_, valid := sessionStore.validateSession(cookie.Value)
+		username, valid := sessionStore.validateSession(cookie.Value)
 		if !valid {
 			http.Error(w, "Session expired", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), username)))
+	})
+}
+
+// tokenMiddleware wraps handlers of non-browser clients, they authenticate
+// with an "Authorization: Bearer <token>" header, or the session cookie
+func tokenMiddleware(authenticate func(token string) (string, bool), next http.Handler) http.Handler {
+	cookieAuth := authMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			cookieAuth.ServeHTTP(w, r)
+			return
+		}
+		username, valid := authenticate(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if !valid {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), username)))
 	})
 }
 
+// adminMiddleware wraps handlers to require an authenticated admin
+func adminMiddleware(next http.Handler) http.Handler {
+	return authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(auth.UserFromContext(r.Context())) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
 // loginHandler handles login requests
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -161,7 +374,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 
 	// Validate credentials
+	accountsMu.RLock()
 	expectedPassword, exists := accounts[username]
+	accountsMu.RUnlock()
 	if !exists || expectedPassword != password {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
@@ -170,7 +385,14 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create session
-	token := sessionStore.createSession(username)
+	token, err := sessionStore.createSession(username)
+	if err != nil {
+		log.Printf("Error sharing the session of %s: %v", username, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"success": false, "message": "Login unavailable, try again later"}`))
+		return
+	}
 
 	// Set cookie
 	http.SetCookie(w, &http.Cookie{
@@ -226,213 +448,168 @@ func authStatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(fmt.Sprintf(`{"authenticated": true, "username": "%s"}`, username)))
 }
 
-// selectVendor selects the appropriate transcription service based on command line arguments
-// and available credentials. Command line arguments take precedence over environment variables.
-//
-// Priority Order (when --vendor is specified):
-// 1. Command line --vendor flag (highest priority)
-// 2. Google Speech (if --google.cred flag provided)
-// 3. Environment variable based selection (fallback)
-//
-// Supported vendors: google, azure, baidu, xunfei, whisper, recorder
-func selectVendor(ctx context.Context, googleCred, vendor, model, output, language string, keepWav, keepTxt bool) (transcribe.Service, error) {
-	// If vendor is specified via command line, use it directly
-	if vendor != "" {
-		switch vendor {
-		case "google":
-			if googleCred == "" {
-				return nil, fmt.Errorf("--vendor=google requires --google.cred flag")
-			}
-			tr, err := transcribe.NewGoogleSpeech(ctx, googleCred)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Google Speech service: %w", err)
-			}
-			log.Printf("Using Google Speech service (via --vendor flag)")
-			return tr, nil
-
-		case "azure":
-			azureKey := os.Getenv("AZURE_SPEECH_KEY")
-			azureRegion := os.Getenv("AZURE_SPEECH_REGION")
-			if azureKey == "" || azureRegion == "" {
-				return nil, fmt.Errorf("--vendor=azure requires AZURE_SPEECH_KEY and AZURE_SPEECH_REGION environment variables")
-			}
-			tr, err := transcribe.NewAzureTranscriber(ctx, azureKey, azureRegion)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Azure Speech service: %w", err)
-			}
-			log.Printf("Using Azure Speech service (via --vendor flag, region: %s)", azureRegion)
-			return tr, nil
-
-		case "baidu":
-			baiduAppID := os.Getenv("BAIDU_APP_ID")
-			baiduApiKey := os.Getenv("BAIDU_API_KEY")
-			baiduSecretKey := os.Getenv("BAIDU_SECRET_KEY")
-			if baiduAppID == "" || baiduApiKey == "" || baiduSecretKey == "" {
-				return nil, fmt.Errorf("--vendor=baidu requires BAIDU_APP_ID, BAIDU_API_KEY, and BAIDU_SECRET_KEY environment variables")
-			}
-			tr, err := transcribe.NewBaiduTranscriber(ctx, baiduAppID, baiduApiKey, baiduSecretKey)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Baidu Speech service: %w", err)
-			}
-			log.Printf("Using Baidu Speech service (via --vendor flag)")
-			return tr, nil
-
-		case "xunfei":
-			appID := os.Getenv("XUNFEI_APP_ID")
-			apiKey := os.Getenv("XUNFEI_API_KEY")
-			apiSecret := os.Getenv("XUNFEI_API_SECRET")
-			appUrl := os.Getenv("XUNFEI_API_URL")
-			if appID == "" || apiKey == "" || apiSecret == "" {
-				return nil, fmt.Errorf("--vendor=xunfei requires XUNFEI_APP_ID, XUNFEI_API_KEY, and XUNFEI_API_SECRET environment variables")
-			}
-			tr, err := transcribe.NewIflyTekTranscriber(ctx, appID, apiKey, apiSecret, appUrl)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Xunfei service: %w", err)
-			}
-			log.Printf("Using Xunfei (IflyTek) service (via --vendor flag)")
-			return tr, nil
-
-		case "whisper":
-			// Use command line arguments for Whisper
-			whisperModelPath := model
-			whisperPath := os.Getenv("WHISPER_PATH")
-			outputDir := output
-			if outputDir == "" {
-				outputDir = "./recordings"
-			}
-
-			tr, err := transcribe.NewWhisperTranscriber(ctx, whisperModelPath, whisperPath, outputDir, language, keepWav, keepTxt)
-			if err != nil {
-				// If Whisper is not available, fall back to Recorder service
-				log.Printf("Whisper service not available: %v", err)
-				log.Printf("Falling back to Recorder service")
-				recorderTr, recorderErr := transcribe.NewRecorderTranscriber(ctx, outputDir)
-				if recorderErr != nil {
-					return nil, fmt.Errorf("failed to create Whisper service: %w, and failed to fallback to Recorder: %w", err, recorderErr)
-				}
-				log.Printf("Using Recorder service (fallback from Whisper, output: %s)", outputDir)
-				return recorderTr, nil
-			}
-			log.Printf("Using Whisper service (via --vendor flag, model: %s, language: %s, output: %s)", model, language, outputDir)
-			return tr, nil
-
-		case "recorder":
-			outputDir := output
-			if outputDir == "" {
-				outputDir = "./recordings"
-			}
+// vendorName returns the short name of the vendor behind a transcription service,
+// it may differ from the --vendor flag when transcribe.SelectVendor falls back to another service
+func vendorName(tr transcribe.Service) string {
+	switch t := tr.(type) {
+	case *transcribe.GoogleTranscriber:
+		return "google"
+	case *transcribe.AzureTranscriber:
+		return "azure"
+	case *transcribe.BaiduTranscriber:
+		return "baidu"
+	case *transcribe.IflyTekTranscriber:
+		return "xunfei"
+	case *transcribe.AliyunTranscriber:
+		return "aliyun"
+	case *transcribe.TencentTranscriber:
+		return "tencent"
+	case *transcribe.DeepgramTranscriber:
+		return "deepgram"
+	case *transcribe.SpeechmaticsTranscriber:
+		return "speechmatics"
+	case *transcribe.SonioxTranscriber:
+		return "soniox"
+	case *transcribe.RivaTranscriber:
+		return "riva"
+	case *transcribe.KaldiTranscriber:
+		return "kaldi"
+	case *transcribe.VoskTranscriber:
+		return "vosk"
+	case *transcribe.OpenAITranscriber:
+		return "openai"
+	case *transcribe.CoquiTranscriber:
+		return "coqui"
+	case *transcribe.WhisperTranscriber:
+		return "whisper"
+	case *transcribe.RecorderTranscriber:
+		return "recorder"
+	case *transcribe.MockTranscriber:
+		return "mock"
+	case *transcribe.ExecTranscriber:
+		return "exec"
+	case *transcribe.GRPCTranscriber:
+		return "grpc"
+	case *transcribe.TeeTranscriber:
+		return strings.Join(t.Vendors(), "+")
+	case *transcribe.FailoverTranscriber:
+		return strings.Join(t.Vendors(), ">")
+	case *jobs.Service:
+		return "worker"
+	default:
+		return "unknown"
+	}
+}
 
-			tr, err := transcribe.NewRecorderTranscriber(ctx, outputDir)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Recorder service: %w", err)
-			}
-			log.Printf("Using Recorder service (via --vendor flag, output: %s)", outputDir)
-			return tr, nil
+// isVendorSeparator reports whether r separates the vendors of a --vendor list or chain
+func isVendorSeparator(r rune) bool {
+	return r == ',' || r == '>'
+}
 
-		default:
-			return nil, fmt.Errorf("unsupported vendor: %s. Supported vendors: google, azure, baidu, xunfei, whisper, recorder", vendor)
+// usesVendor reports whether the --vendor flag, possibly a list or a chain, includes the vendor
+func usesVendor(flagValue, vendor string) bool {
+	for _, name := range strings.FieldsFunc(flagValue, isVendorSeparator) {
+		if strings.TrimSpace(name) == vendor {
+			return true
 		}
 	}
+	return false
+}
 
-	// Fallback to automatic selection based on environment variables
-	// Check Google Speech first (highest priority)
-	if googleCred != "" {
-		tr, err := transcribe.NewGoogleSpeech(ctx, googleCred)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Google Speech service: %w", err)
-		}
-		log.Printf("Using Google Speech service")
-		return tr, nil
+// defaultReplica returns the name of the replica, the hostname (the pod
+// name on Kubernetes)
+func defaultReplica() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "replica-" + generateSessionToken()[:8]
 	}
+	return hostname
+}
 
-	// Check Azure Speech credentials
-	azureKey := os.Getenv("AZURE_SPEECH_KEY")
-	azureRegion := os.Getenv("AZURE_SPEECH_REGION")
-	if azureKey != "" && azureRegion != "" {
-		tr, err := transcribe.NewAzureTranscriber(ctx, azureKey, azureRegion)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Azure Speech service: %w", err)
+// splitList splits a comma separated environment value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-		log.Printf("Using Azure Speech service (region: %s)", azureRegion)
-		return tr, nil
 	}
+	return items
+}
 
-	// Check Baidu Speech credentials
-	baiduAppID := os.Getenv("BAIDU_APP_ID")
-	baiduApiKey := os.Getenv("BAIDU_API_KEY")
-	baiduSecretKey := os.Getenv("BAIDU_SECRET_KEY")
-	if baiduAppID != "" && baiduApiKey != "" && baiduSecretKey != "" {
-		tr, err := transcribe.NewBaiduTranscriber(ctx, baiduAppID, baiduApiKey, baiduSecretKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Baidu Speech service: %w", err)
+// loadStreamKeys reads the stream keys of the RTMP and WHIP publishers from
+// STREAM_KEYS (user=key,...), or RTMP_KEYS its former name, and returns
+// them indexed by key
+func loadStreamKeys() map[string]string {
+	name, spec := "STREAM_KEYS", os.Getenv("STREAM_KEYS")
+	if spec == "" && os.Getenv("RTMP_KEYS") != "" {
+		name, spec = "RTMP_KEYS", os.Getenv("RTMP_KEYS")
+		log.Printf("Warning: RTMP_KEYS is deprecated, rename it STREAM_KEYS")
+	}
+	keys := make(map[string]string)
+	for _, entry := range splitList(spec) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Printf("Warning: Ignoring invalid %s entry: %s", name, entry)
+			continue
 		}
-		log.Printf("Using Baidu Speech service")
-		return tr, nil
+		keys[parts[1]] = parts[0]
 	}
+	return keys
+}
 
-	// Check Xunfei credentials
-	appID := os.Getenv("XUNFEI_APP_ID")
-	apiKey := os.Getenv("XUNFEI_API_KEY")
-	apiSecret := os.Getenv("XUNFEI_API_SECRET")
-	appUrl := os.Getenv("XUNFEI_API_URL")
-	if appID != "" && apiKey != "" && apiSecret != "" {
-		tr, err := transcribe.NewIflyTekTranscriber(ctx, appID, apiKey, apiSecret, appUrl)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Xunfei service: %w", err)
+// newStreamPublisher creates the Kafka or NATS publisher used to stream
+// transcript segments, brokers are configured in the environment
+func newStreamPublisher(backend string) (streaming.Publisher, error) {
+	switch backend {
+	case "kafka":
+		brokers := splitList(os.Getenv("KAFKA_BROKERS"))
+		if len(brokers) == 0 {
+			return nil, fmt.Errorf("--stream.backend=kafka requires KAFKA_BROKERS environment variable")
 		}
-		log.Printf("Using Xunfei (IflyTek) service")
-		return tr, nil
+		return streaming.NewKafkaPublisher(brokers, "webrtc-transcriber")
+	case "nats":
+		natsURL := os.Getenv("NATS_URL")
+		if natsURL == "" {
+			natsURL = "nats://localhost:4222"
+		}
+		return streaming.NewNATSPublisher(natsURL, "webrtc-transcriber")
+	default:
+		return nil, fmt.Errorf("unsupported stream backend: %s. Supported backends: kafka, nats", backend)
 	}
+}
 
-	// Check if Whisper is available (try auto-detection even without env vars)
-	whisperModelPath := os.Getenv("WHISPER_MODEL_PATH")
-	whisperPath := os.Getenv("WHISPER_PATH")
-	outputDir := output
-	if outputDir == "" {
-		outputDir = os.Getenv("OUTPUT_PATH")
-		if outputDir == "" {
-			currentDir, err := os.Getwd()
-			if err != nil {
-				return nil, fmt.Errorf("failed to get current working directory: %w", err)
-			}
-			outputDir = currentDir + "/" + defaultRecordingsDir
-		}
+// newAlertMonitor creates the error rate monitor, alerts are published to
+// the dispatcher and delivered to the webhook and email recipients
+// configured in the environment
+func newAlertMonitor(window time.Duration, thresholdSpec string, dispatcher *events.Dispatcher) (*alert.Monitor, error) {
+	thresholds, err := alert.ParseThresholds(thresholdSpec)
+	if err != nil {
+		return nil, err
 	}
 
-	// Try to create Whisper service (will auto-detect if env vars are empty)
-	whisperTr, err := transcribe.NewWhisperTranscriber(ctx, whisperModelPath, whisperPath, outputDir, language, keepWav, keepTxt)
-	if err == nil {
-		// Whisper service created successfully
-		modelPath := whisperModelPath
-		execPath := whisperPath
-		if modelPath == "" {
-			modelPath = "auto-detected"
+	if webhookURL := os.Getenv("ALERT_WEBHOOK_URL"); webhookURL != "" {
+		sinks, err := webhook.NewSinks(webhook.Config{
+			URLs:   []string{webhookURL},
+			Events: []string{alert.EventType},
+		})
+		if err != nil {
+			return nil, err
 		}
-		if execPath == "" {
-			execPath = "auto-detected"
+		for _, sink := range sinks {
+			dispatcher.Add(sink)
 		}
-		log.Printf("Using Whisper service (model: %s, executable: %s, language: %s)", modelPath, execPath, language)
-		return whisperTr, nil
 	}
-
-	// If Whisper failed, log the error but continue to next service
-	log.Printf("Whisper service not available: %v", err)
-
-	// Use Recorder service as fallback (no credentials needed)
-	recorderOutputDir := output
-	if recorderOutputDir == "" {
-		recorderOutputDir = os.Getenv("RECORDER_OUTPUT_DIR")
-		if recorderOutputDir == "" {
-			recorderOutputDir = defaultRecordingsDir
-		}
+	smtpAddr := os.Getenv("ALERT_SMTP_ADDR")
+	emailTo := os.Getenv("ALERT_EMAIL_TO")
+	if smtpAddr != "" && emailTo != "" {
+		recipients := splitList(emailTo)
+		dispatcher.Add(alert.NewEmailSink(smtpAddr,
+			os.Getenv("ALERT_SMTP_USERNAME"), os.Getenv("ALERT_SMTP_PASSWORD"),
+			os.Getenv("ALERT_EMAIL_FROM"), recipients))
 	}
 
-	tr, err := transcribe.NewRecorderTranscriber(ctx, recorderOutputDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Recorder service: %w", err)
-	}
-	log.Printf("Using Recorder service (output directory: %s)", outputDir)
-	return tr, nil
+	return alert.NewMonitor(window, thresholds, dispatcher), nil
 }
 
 func main() {
@@ -442,21 +619,178 @@ func main() {
 		log.Printf("Warning: Error loading .env file: %v", err)
 	}
 
+	// Whisper model management runs instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "models" {
+		os.Exit(runModelsCommand(os.Args[2:]))
+	}
+
 	// Load accounts from environment
 	loadAccounts()
+	loadAdmins()
 
 	httpPort := flag.String("http.port", httpDefaultPort, "HTTP listen port")
+	grpcPort := flag.String("grpc.port", "", "gRPC listen port (disabled when empty)")
 	stunServer := flag.String("stun.server", defaultStunServer, "STUN server URL (stun:)")
+	turnServer := flag.String("turn.server", os.Getenv("TURN_SERVER"), "TURN server URL (turn: or turns:) relaying the media of the clients behind restrictive NATs, e.g. turn:turn.example.com:3478?transport=udp")
+	turnUsername := flag.String("turn.username", os.Getenv("TURN_USERNAME"), "Username of the TURN server")
+	turnPassword := flag.String("turn.password", os.Getenv("TURN_PASSWORD"), "Password of the TURN server")
+	turnEmbedded := flag.String("turn.embedded", os.Getenv("TURN_EMBEDDED"), "UDP listen address of the embedded TURN/STUN server the clients relay through, e.g. :3478, with --turn.username and --turn.password (disabled when empty)")
+	turnRelayPorts := flag.String("turn.relay_ports", os.Getenv("TURN_RELAY_PORTS"), "UDP port range of the relayed addresses of the embedded TURN server, e.g. 49152-49252 (any port when empty)")
+	icePorts := flag.String("ice.udp_ports", os.Getenv("ICE_UDP_PORTS"), "UDP port range of the ICE candidates of the server, e.g. 50000-50100 (any port when empty)")
+	natPublicIP := flag.String("nat.public-ip", os.Getenv("NAT_PUBLIC_IP"), "Public IP of a 1:1 NAT in front of the server advertised by its host candidates, e.g. on EC2 or in Docker")
+	icePolicy := flag.String("ice.policy", os.Getenv("ICE_POLICY"), "ICE transport policy of the clients: all (default) or relay, only through --turn.server")
+	sessionGrace := flag.Duration("session.grace", 5*time.Second, "Time without audio after which a WebRTC session is finalized, as auto-completed when its client was lost")
+	sessionIdle := flag.Duration("session.idle", 10*time.Minute, "Time a WebRTC session may stay ICE-disconnected or silent before it is closed, as auto-completed (0 disables it)")
 
 	// New command line arguments
-	vendor := flag.String("vendor", "whisper", "Transcription vendor: google, azure, baidu, xunfei, whisper, recorder")
+	vendor := flag.String("vendor", "whisper", "Transcription vendor: google, azure, baidu, xunfei, aliyun, tencent, deepgram, speechmatics, soniox, riva, kaldi, vosk, coqui, openai, whisper, recorder, mock, exec, grpc, worker, a comma separated list transcribing with all of them, or a chain failing over, e.g. \"azure>whisper>recorder\"")
+	execCmd := flag.String("exec.cmd", os.Getenv("EXEC_CMD"), "Plugin run with sh -c for each stream of --vendor=exec, see docs/EXEC_SETUP.md")
 	model := flag.String("model", "small", "Whisper model: tiny, base, small, medium, large")
 	output := flag.String("output", "recordings", "Output directory for WAV and TXT files")
+	outputFormat := flag.String("output.format", os.Getenv("OUTPUT_FORMAT"), "Format of the finalized recordings: wav (default), mp3 or flac, encoded with --output.ffmpeg")
+	outputFFmpeg := flag.String("output.ffmpeg", "ffmpeg", "ffmpeg executable encoding the recordings to --output.format")
 	language := flag.String("language", "auto", "Source language (e.g., en, cn, auto)")
 
 	// File retention flags
 	keepWav := flag.Bool("keep_wav", true, "Keep generated WAV files (default: true)")
 	keepTxt := flag.Bool("keep_txt", true, "Keep generated TXT files (default: true)")
+	jitterDelay := flag.Duration("rtc.jitter", 60*time.Millisecond, "Wait for the RTP packets arriving out of order before they are concealed as lost, 0 decodes them in their order of arrival")
+	videoRecord := flag.Bool("video.record", false, "Accept a video track in the WebRTC sessions and record it (VP8/VP9 to .ivf) next to the audio, which alone is transcribed")
+	opusRecord := flag.String("opus.record", os.Getenv("OPUS_RECORD"), "Recording of the received Opus packets to .ogg: off (default), alongside (every session), instead (the sessions not transcribed are not decoded)")
+
+	recordingFlush := flag.Duration("recording.flush", time.Second, "Interval of the syncs of the WAV recordings to the disk, a crash loses at most this audio (every write when 0)")
+	recordingRate := flag.Int("recording.sample_rate", 48000, "Sample rate of the recorder WAV files, dividing 48000 (e.g. 8000, 16000)")
+	recordingBits := flag.Int("recording.bits", 16, "Bits per sample of the recorder WAV files: 8, 16, 24 or 32")
+	recordingChannels := flag.Int("recording.channels", 1, "Channels of the recorder WAV files: 1 or 2")
+
+	// Crash recovery flags
+	recoverFiles := flag.Bool("recover", true, "Repair unfinalized WAV files left in the output directory on startup, only when set explicitly with REDIS_URL")
+	recoverAge := flag.Duration("recover.min_age", 10*time.Minute, "With REDIS_URL, the WAV files modified more recently are not repaired, other replicas may be writing them")
+	recoverTranscribe := flag.Bool("recover.transcribe", false, "Transcribe the repaired WAV files in the background")
+
+	// Configuration validation flags
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration, print a report and exit (non-zero on problems)")
+	checkConfigProbe := flag.Bool("check-config.probe", false, "Also authenticate with the vendor when validating the configuration")
+
+	// Multi-tenancy flags
+	tenantsConfig := flag.String("tenants.config", "", "JSON file of the tenants with their vendors, credentials and admins (single tenant when empty)")
+
+	// Quota flags
+	quotaMinutes := flag.String("quota.minutes", os.Getenv("QUOTA_MINUTES"), "Transcription minutes per month of the users and roles, e.g. role:user=600,role:admin=unlimited,alice=1200 (unlimited when empty)")
+
+	// Transcription worker flags
+	workerURL := flag.String("worker.url", "", "URL of this server the transcribe-worker processes reach with --vendor=worker (default http://<hostname>:<http.port>)")
+	workerTimeout := flag.Duration("worker.timeout", 30*time.Minute, "Time a recording waits for a transcribe-worker with --vendor=worker")
+
+	// Cluster flags
+	clusterReplica := flag.String("cluster.replica", defaultReplica(), "Name of this replica in the cluster sharing its state in REDIS_URL")
+	clusterPrefix := flag.String("cluster.prefix", "transcriber:", "Prefix of the Redis keys and channels of the cluster")
+
+	// Whisper model cache flags
+	modelsDir := flag.String("models.dir", modelsDirDefault(), "Directory of the downloaded Whisper models")
+	modelsPull := flag.Bool("models.pull", false, "Download the --model Whisper model on startup when it is not cached")
+
+	// Delivery of the vendor results to the slow readers
+	resultsPolicy := flag.String("results.policy", transcribe.PolicyBlock, "Vendor results that do not fit the stream buffer: block (up to --results.timeout, then drop), drop-oldest, drop-newest")
+	resultsTimeout := flag.Duration("results.timeout", 5*time.Second, "Wait of --results.policy=block before dropping a result, forever when 0")
+	punctuate := flag.String("punctuate", os.Getenv("PUNCTUATE_VENDORS"), "Vendors whose results get their punctuation and casing restored, e.g. \"baidu,vosk\"")
+	postprocess := flag.String("postprocess", os.Getenv("POSTPROCESS"), "Processors applied in order to the results: normalize, replace, redact, e.g. \"replace,redact\"")
+	replacements := flag.String("postprocess.replacements", os.Getenv("POSTPROCESS_REPLACEMENTS"), "File of the \"from => to\" replacements of the replace processor")
+
+	// Rolling window of the Whisper live partial results, gated by the rolling_whisper feature,
+	// or chunks decoded once
+	whisperWindow := flag.Duration("whisper.window", 30*time.Second, "Audio decoded again for the Whisper live partial results")
+	whisperStep := flag.Duration("whisper.step", 3*time.Second, "New audio between two decodings of the Whisper rolling window")
+	whisperSampleRate := flag.Int("whisper.sample_rate", 16000, "Rate of the Whisper recordings: 16000, the rate of the models, or 48000 to keep the decoded audio")
+	whisperChunk := flag.Duration("whisper.chunk", 5*time.Second, "Audio of each chunk of the Whisper chunked partial results, gated by the chunked_whisper feature")
+
+	// Speaker diarization, gated by the diarization feature or asked by the client
+	diarizationCmd := flag.String("diarization.cmd", os.Getenv("DIARIZATION_CMD"), "Command labelling the speakers of the diarized Whisper recordings, e.g. a pyannote script")
+
+	// Segmentation of the streams at their pauses, gated by the vad feature
+	vadThreshold := flag.Float64("vad.threshold", -40, "Level of the speech in dBFS, quieter audio is a pause")
+	vadSilence := flag.Duration("vad.silence", 700*time.Millisecond, "Pause ending an utterance")
+	vadMinSpeech := flag.Duration("vad.min_speech", 300*time.Millisecond, "Speech of an utterance before a pause can end it")
+	vadMaxUtterance := flag.Duration("vad.max_utterance", 30*time.Second, "Utterances are cut after it even without a pause, never when 0")
+
+	// Loudness normalization of the streams
+	agcTarget := flag.Float64("agc.target", 0, "Level the speech is normalized to in dBFS, e.g. -20 (off when 0)")
+	agcMaxGain := flag.Float64("agc.max_gain", 30, "Maximum amplification of the quiet speakers in dB")
+
+	// Noise suppression of the streams, gated by the denoise feature
+	denoiseReduction := flag.Float64("denoise.reduction", 20, "Maximum attenuation of the noise in dB")
+
+	// Pool of the streams transcribed at the same time
+	poolMaxStreams := flag.Int("pool.max_streams", 0, "Streams transcribed at the same time, the next ones wait for a slot, e.g. to bound the Whisper processes (unlimited when 0)")
+	poolTimeout := flag.Duration("pool.timeout", 30*time.Second, "Wait of a new stream for a transcription slot, forever when 0. Whisper recordings always wait")
+
+	// Feature flags gating experimental behavior
+	featureSpec := flag.String("features", os.Getenv("FEATURE_FLAGS"), "Feature flags, e.g. \"vad=on,chunked_whisper=alice|bob,diarization=off\"")
+
+	// Error rate alerting flags
+	alertWindow := flag.Duration("alert.window", time.Minute, "Sliding window used to compute error rates")
+	alertThresholds := flag.String("alert.thresholds", "vendor=5,decode=100,disk=1", "Errors per window that trigger an alert, by kind (vendor, decode, disk)")
+
+	// Completion webhook flags
+	webhookTemplate := flag.String("webhook.template", "", "Go template file rendering the completion webhook JSON payload")
+	webhookRetries := flag.Int("webhook.retries", -1, "Deprecated alias of --events.retries")
+
+	// Event delivery flags
+	eventsRetries := flag.Int("events.retries", 3, "Retries of a failed event delivery to a webhook, chat, MQTT, Kafka/NATS or email sink")
+	eventsDeadLetter := flag.String("events.dead_letter", "", "JSON lines file receiving the undelivered events (<output>/.dead-letter.jsonl when empty)")
+
+	// Public address of the server, used to link transcripts in chat notifications
+	publicURL := flag.String("public_url", os.Getenv("PUBLIC_URL"), "Public base URL of the server, e.g. https://transcriber.example.com")
+
+	// SIP/SIPREC ingestion flags
+	sipAddr := flag.String("sip.addr", "", "SIP UDP listen address for calls and SIPREC sessions, e.g. :5060 (disabled when empty)")
+	sipPublicIP := flag.String("sip.public_ip", "", "IP address announced in SIP answers (detected when empty)")
+	sipRTPPorts := flag.String("sip.rtp_ports", "", "RTP port range for SIP calls, e.g. 10000-20000 (any free port when empty)")
+	sipRegister := flag.String("sip.register", os.Getenv("SIP_REGISTER"), "SIP address registered with a PBX or trunk to receive its calls, e.g. sip:1001@pbx.example.com (password in SIP_PASSWORD)")
+	sipUser := flag.String("sip.user", "", "User owning the SIP calls, who receives their live transcripts (unowned when empty)")
+	sipAllow := flag.String("sip.allow", "", "Comma separated networks allowed to send SIP requests, e.g. 10.0.0.0/8 (any when empty)")
+
+	// RTMP ingestion flags
+	rtmpAddr := flag.String("rtmp.addr", "", "RTMP listen address for live streams, e.g. :1935 (disabled when empty)")
+	rtmpFFmpeg := flag.String("rtmp.ffmpeg", "ffmpeg", "ffmpeg executable decoding the audio of RTMP streams")
+
+	// Transcript Q&A flags
+	askURL := flag.String("ask.url", "", "OpenAI compatible API answering /api/transcripts/ask, e.g. https://api.openai.com/v1 or http://localhost:11434/v1 (disabled when empty)")
+	askModel := flag.String("ask.model", "gpt-4o-mini", "Chat model answering the questions")
+	askEmbeddingModel := flag.String("ask.embedding_model", "text-embedding-3-small", "Model embedding the transcripts")
+
+	// Drop folder flags
+	watchConfig := flag.String("watch.config", "", "JSON file listing the drop folders whose new audio files are transcribed (disabled when empty)")
+	watchInterval := flag.Duration("watch.interval", 10*time.Second, "Interval between two scans of the drop folders")
+	watchFFmpeg := flag.String("watch.ffmpeg", "ffmpeg", "ffmpeg executable decoding the dropped files that are not WAV")
+
+	// Audio feed flags
+	feedsConfig := flag.String("feeds", os.Getenv("FEEDS"), "Comma separated audio feeds pulled at startup, name=url with rtsp://, http(s):// or rtp://<ip>:<port>?pt=&codec= URLs")
+	feedsFFmpeg := flag.String("feeds.ffmpeg", "ffmpeg", "ffmpeg executable pulling the RTSP and HTTP audio feeds of /admin/feeds")
+
+	// Voice assistant flags
+	intentBackend := flag.String("intent.backend", "", "Intent handler of /api/intent: homeassistant, webhook (disabled when empty)")
+
+	// Live caption output flags
+	captionsOBS := flag.String("captions.obs", "", "OBS WebSocket URL receiving the live captions, e.g. ws://localhost:4455 (disabled when empty)")
+	captionsWS := flag.String("captions.ws", "", "WebSocket endpoint receiving the live captions as text messages (disabled when empty)")
+	captionsUser := flag.String("captions.user", "", "Only caption the sessions of this user (all when empty)")
+	captionsChars := flag.Int("captions.chars", 32, "Characters per line of the live, HLS and SRT captions")
+	captionsLines := flag.Int("captions.lines", 2, "Lines per caption of the live, HLS and SRT captions")
+	captionsSentenceCase := flag.Bool("captions.sentence_case", false, "Capitalize the sentences of the captions")
+	captionsRemoveFillers := flag.Bool("captions.remove_fillers", false, "Remove the filler words (um, uh, ...) from the captions")
+	subtitles := flag.String("subtitles", os.Getenv("SUBTITLES"), "Subtitle files written next to the recordings from the word timestamps: srt, vtt (none when empty)")
+
+	// MQTT publishing flags
+	mqttTopic := flag.String("mqtt.topic", "transcriber/{user}/{session}", "MQTT topic template for session events and results")
+	mqttQoS := flag.Int("mqtt.qos", 0, "MQTT QoS level for published messages (0 or 1)")
+	mqttPartials := flag.Bool("mqtt.partials", false, "Publish partial results to MQTT, not only final ones")
+
+	// Kafka/NATS streaming flags
+	streamBackend := flag.String("stream.backend", "", "Stream transcript segments to: kafka, nats (disabled when empty)")
+	streamSubject := flag.String("stream.subject", "transcripts", "Kafka topic or NATS subject, {user} and {session} are replaced")
+	streamFormat := flag.String("stream.format", "json", "Serialization of streamed messages: json, protobuf")
+	streamPartials := flag.Bool("stream.partials", false, "Stream partial segments, not only final ones")
 
 	// Add usage information
 	flag.Usage = func() {
@@ -476,29 +810,631 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s --vendor=recorder --output=./recordings\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # Keep generated files\n")
 		fmt.Fprintf(os.Stderr, "  %s --keep_wav --keep_txt\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Validate the configuration and the vendor credentials\n")
+		fmt.Fprintf(os.Stderr, "  %s --vendor=azure --check-config --check-config.probe\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Serve several tenants, with accounts named user@tenant\n")
+		fmt.Fprintf(os.Stderr, "  %s --tenants.config=tenants.json\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Manage the cached Whisper models\n")
+		fmt.Fprintf(os.Stderr, "  %s models list|download <name>|remove <name>\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Environment Variables:\n")
 		fmt.Fprintf(os.Stderr, "  Environment variables can be set directly or loaded from a .env file\n")
 		fmt.Fprintf(os.Stderr, "  GOOGLE_CREDENTIALS                        - Google Speech credentials file path\n")
+		fmt.Fprintf(os.Stderr, "  GOOGLE_SPEECH_LOCATION, GOOGLE_SPEECH_MODEL - Speech-to-Text v2 location (default global) and model (default long)\n")
 		fmt.Fprintf(os.Stderr, "  AZURE_SPEECH_KEY, AZURE_SPEECH_REGION     - Azure Speech Service credentials\n")
 		fmt.Fprintf(os.Stderr, "  BAIDU_APP_ID, BAIDU_API_KEY, BAIDU_SECRET_KEY - Baidu Speech credentials\n")
 		fmt.Fprintf(os.Stderr, "  XUNFEI_APP_ID, XUNFEI_API_KEY, XUNFEI_API_SECRET, XUNFEI_API_URL - Xunfei credentials and API URL\n")
+		fmt.Fprintf(os.Stderr, "  ALIYUN_ACCESS_KEY_ID, ALIYUN_ACCESS_KEY_SECRET, ALIYUN_NLS_APPKEY, ALIYUN_NLS_REGION - Aliyun NLS credentials\n")
+		fmt.Fprintf(os.Stderr, "  TENCENT_APP_ID, TENCENT_SECRET_ID, TENCENT_SECRET_KEY, TENCENT_ASR_ENGINE - Tencent Cloud ASR credentials and engine\n")
+		fmt.Fprintf(os.Stderr, "  DEEPGRAM_API_KEY, DEEPGRAM_MODEL           - Deepgram API key and model (default nova-3)\n")
+		fmt.Fprintf(os.Stderr, "  VOSK_MODEL_PATH, VOSK_SERVER_PATH         - Vosk model and the vosk-server run on it\n")
+		fmt.Fprintf(os.Stderr, "  SPEECHMATICS_API_KEY, SPEECHMATICS_URL    - Speechmatics API key and realtime endpoint\n")
+		fmt.Fprintf(os.Stderr, "  SONIOX_API_KEY, SONIOX_MODEL              - Soniox API key and realtime model (default stt-rt-v3)\n")
+		fmt.Fprintf(os.Stderr, "  RIVA_SERVER, RIVA_SSL                     - Riva gRPC server (host:port), over TLS when RIVA_SSL=true\n")
+		fmt.Fprintf(os.Stderr, "  RIVA_MODEL, RIVA_SAMPLE_RATE              - Riva model and rate of the PCM sent, 48000 (default) or 16000\n")
+		fmt.Fprintf(os.Stderr, "  KALDI_SERVER_URL, KALDI_SAMPLE_RATE       - kaldi-gstreamer-server speech endpoint and rate of the PCM sent\n")
+		fmt.Fprintf(os.Stderr, "  OPENAI_API_KEY, OPENAI_BASE_URL           - OpenAI API key and base URL of a compatible server\n")
+		fmt.Fprintf(os.Stderr, "  OPENAI_TRANSCRIBE_MODEL                   - OpenAI transcription model (default whisper-1)\n")
+		fmt.Fprintf(os.Stderr, "  COQUI_MODEL_PATH, COQUI_SCORER_PATH       - Coqui STT model and optional scorer\n")
+		fmt.Fprintf(os.Stderr, "  COQUI_STT_PATH                            - Path to the Coqui stt executable\n")
+		fmt.Fprintf(os.Stderr, "  EXEC_CMD                                  - Plugin run for --vendor=exec, like --exec.cmd\n")
+		fmt.Fprintf(os.Stderr, "  GRPC_ASR_SERVER, GRPC_ASR_TLS             - External ASR service of --vendor=grpc (host:port), over TLS when GRPC_ASR_TLS=true\n")
+		fmt.Fprintf(os.Stderr, "  GRPC_ASR_TOKEN                            - Bearer token sent to the external ASR service\n")
+		fmt.Fprintf(os.Stderr, "  MOCK_SCRIPT, MOCK_DELAY                   - Mock vendor script (JSON steps) and delay between the steps\n")
+		fmt.Fprintf(os.Stderr, "  MOCK_CREATE_ERROR                         - Error of the creation of the mock streams\n")
+		fmt.Fprintf(os.Stderr, "  VOSK_SERVER_URL                           - Running vosk-server, instead of VOSK_MODEL_PATH\n")
 		fmt.Fprintf(os.Stderr, "  WHISPER_PATH                              - Path to Whisper executable\n")
+		fmt.Fprintf(os.Stderr, "  WHISPER_SERVER_URL                        - Whisper server keeping the model loaded, instead of WHISPER_PATH\n")
+		fmt.Fprintf(os.Stderr, "  WHISPER_SERVER_API                        - API of the Whisper server: whisper.cpp (default), openai, asr-webservice\n")
+		fmt.Fprintf(os.Stderr, "  WHISPER_SERVER_MODEL, WHISPER_SERVER_KEY  - Model and API key of an openai Whisper server\n")
+		fmt.Fprintf(os.Stderr, "  WHISPER_CPP_MODEL                         - ggml model loaded in the process by the whisper.cpp bindings (-tags whispercpp)\n")
+		fmt.Fprintf(os.Stderr, "  WHISPER_MODELS_DIR                        - Default value of --models.dir\n")
+		fmt.Fprintf(os.Stderr, "  HF_ENDPOINT                               - Hugging Face mirror the models are downloaded from\n")
+		fmt.Fprintf(os.Stderr, "  admins                                    - Usernames allowed to use admin endpoints\n")
+		fmt.Fprintf(os.Stderr, "  FEATURE_FLAGS                             - Default value of --features\n")
+		fmt.Fprintf(os.Stderr, "  QUOTA_MINUTES                             - Default value of --quota.minutes\n")
+		fmt.Fprintf(os.Stderr, "  WEBHOOK_URLS, WEBHOOK_SECRET              - Completion webhooks and their HMAC secret\n")
+		fmt.Fprintf(os.Stderr, "  SLACK_WEBHOOK_URLS, DISCORD_WEBHOOK_URLS  - Chat webhooks notified of finished sessions ([user=]url,...)\n")
+		fmt.Fprintf(os.Stderr, "  TELEGRAM_BOT_TOKEN, TELEGRAM_CHATS        - Telegram bot sending the transcripts to the linked chats (user=chat_id,...)\n")
+		fmt.Fprintf(os.Stderr, "  PUBLIC_URL                                - Default value of --public_url\n")
+		fmt.Fprintf(os.Stderr, "  STREAM_KEYS                               - RTMP, WHIP, /ws/audio and /ws/fork stream keys of the users (user=key,...), formerly RTMP_KEYS\n")
+		fmt.Fprintf(os.Stderr, "  TWILIO_AUTH_TOKEN, TWILIO_WEBHOOK_URLS     - Twilio Media Streams on /twilio/media and their transcript webhooks\n")
+		fmt.Fprintf(os.Stderr, "  HA_URL, HA_TOKEN, HA_AGENT_ID             - Home Assistant conversation API of --intent.backend=homeassistant\n")
+		fmt.Fprintf(os.Stderr, "  INTENT_WEBHOOK_URL                        - Webhook of --intent.backend=webhook\n")
+		fmt.Fprintf(os.Stderr, "  LLM_API_KEY                               - API key of --ask.url\n")
+		fmt.Fprintf(os.Stderr, "  OBS_WEBSOCKET_PASSWORD, CAPTIONS_WS_TOKEN  - Credentials of the --captions.obs and --captions.ws outputs\n")
+		fmt.Fprintf(os.Stderr, "  MQTT_BROKER, MQTT_CLIENT_ID, MQTT_USERNAME, MQTT_PASSWORD - MQTT publishing (e.g. tcp://localhost:1883)\n")
+		fmt.Fprintf(os.Stderr, "  KAFKA_BROKERS, NATS_URL                   - Brokers used by --stream.backend\n")
+		fmt.Fprintf(os.Stderr, "  REDIS_URL                                 - Redis shared by the replicas (e.g. redis://:password@host:6379/0)\n")
+		fmt.Fprintf(os.Stderr, "  WORKER_TOKEN                              - Token of the transcribe-worker processes of --vendor=worker\n")
+		fmt.Fprintf(os.Stderr, "  ALERT_WEBHOOK_URL                         - Webhook receiving error rate alerts\n")
+		fmt.Fprintf(os.Stderr, "  ALERT_SMTP_ADDR, ALERT_SMTP_USERNAME, ALERT_SMTP_PASSWORD, ALERT_EMAIL_FROM, ALERT_EMAIL_TO - Alert emails\n")
 	}
 
 	flag.Parse()
+	// The vendors read the plugin command from the environment
+	if *execCmd != "" {
+		os.Setenv("EXEC_CMD", *execCmd)
+	}
+
+	if *checkConfig {
+		deadLetter := *eventsDeadLetter
+		if deadLetter == "" {
+			deadLetter = filepath.Join(*output, ".dead-letter.jsonl")
+		}
+		os.Exit(runCheckConfig(checkConfigOptions{
+			Vendor:          *vendor,
+			Model:           *model,
+			ModelsDir:       *modelsDir,
+			Output:          *output,
+			Language:        *language,
+			Probe:           *checkConfigProbe,
+			Features:        *featureSpec,
+			AlertThresholds: *alertThresholds,
+			WebhookTemplate: *webhookTemplate,
+			WatchConfig:     *watchConfig,
+			DeadLetter:      deadLetter,
+			Quotas:          *quotaMinutes,
+			ResultsPolicy:   *resultsPolicy,
+		}))
+	}
+
+	// The replicas behind a load balancer share the login sessions, the live
+	// events and the WHIP sessions through Redis
+	var redisClient *redis.Client
+	var shared *cluster.Cluster
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		client, err := redis.NewClient(redisURL)
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		defer client.Close()
+		if _, err := client.Do("PING"); err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		redisClient = client
+		shared = cluster.New(client, *clusterPrefix, *clusterReplica)
+		sessionStore.shared = shared
+		log.Printf("Cluster mode enabled (replica: %s)", *clusterReplica)
+	}
+
+	featureFlags, err := features.Parse(*featureSpec)
+	if err != nil {
+		log.Fatalf("Invalid feature flags: %v", err)
+	}
+
+	if err := transcribe.SetFlushInterval(*recordingFlush); err != nil {
+		log.Fatalf("Invalid --recording.flush: %v", err)
+	}
+	if err := transcribe.SetRecordingFormat(wav.Format{SampleRate: *recordingRate, Channels: *recordingChannels, BitsPerSample: *recordingBits}); err != nil {
+		log.Fatalf("Invalid --recording format: %v", err)
+	}
+	if err := transcribe.SetBackpressure(transcribe.Backpressure{Policy: *resultsPolicy, Timeout: *resultsTimeout}); err != nil {
+		log.Fatalf("Invalid --results.policy: %v", err)
+	}
+	transcribe.SetPunctuation(splitList(*punctuate))
+	chain, err := transcribe.NewProcessors(splitList(*postprocess), transcribe.ProcessorOptions{Replacements: *replacements})
+	if err != nil {
+		log.Fatalf("Invalid --postprocess: %v", err)
+	}
+	transcribe.SetProcessors(chain)
 
 	var tr transcribe.Service
-	var err error
 	ctx := context.Background()
 
+	// Prefer the models of the cache directory to the ones Whisper finds
+	modelStore := models.NewStore(*modelsDir, os.Getenv("HF_ENDPOINT"))
+	if *modelsPull && usesVendor(*vendor, "whisper") {
+		if err := modelStore.Pull(ctx, *model); err != nil {
+			log.Fatalf("Failed to download Whisper model %s: %v", *model, err)
+		}
+	}
+	whisperModel := *model
+	if path, ok := modelStore.Path(*model); ok {
+		whisperModel = path
+	}
+
 	// Select transcription vendor based on available credentials
 	googleCred := os.Getenv("GOOGLE_CREDENTIALS")
-	tr, err = selectVendor(ctx, googleCred, *vendor, *model, *output, *language, *keepWav, *keepTxt)
-	if err != nil {
+	var jobService *jobs.Service
+	if *vendor == "worker" {
+		// Record on this server, transcribe on the transcribe-worker processes
+		if redisClient == nil || os.Getenv("WORKER_TOKEN") == "" {
+			log.Fatalf("--vendor=worker requires REDIS_URL and WORKER_TOKEN environment variables")
+		}
+		recorder, err := transcribe.NewRecorderTranscriber(ctx, *output)
+		if err != nil {
+			log.Fatalf("Failed to create transcription service: %v", err)
+		}
+		if *workerURL == "" {
+			*workerURL = fmt.Sprintf("http://%s:%s", defaultReplica(), *httpPort)
+		}
+		jobService = jobs.NewService(recorder, jobs.NewQueue(redisClient, *clusterPrefix), *workerURL, *workerTimeout)
+		tr = jobService
+		log.Printf("Using transcription workers (jobs served on %s)", *workerURL)
+	} else if tr, err = transcribe.SelectVendor(ctx, googleCred, *vendor, whisperModel, *output, *language, *keepWav, *keepTxt); err != nil {
 		log.Fatalf("Failed to create transcription service: %v", err)
 	}
+	// Whisper sends live partial results to the users of the rolling_whisper
+	// and chunked_whisper features and labels the speakers of the diarized
+	// recordings. The transcribers are kept to switch their model at runtime
+	var whisperMu sync.Mutex
+	var whisperTranscribers []*transcribe.WhisperTranscriber
+	whisperModelName := *model
+	currentWhisperModel := func() string {
+		whisperMu.Lock()
+		defer whisperMu.Unlock()
+		return whisperModel
+	}
+	var configureWhisper func(service transcribe.Service)
+	configureWhisper = func(service transcribe.Service) {
+		switch s := service.(type) {
+		case *transcribe.WhisperTranscriber:
+			whisperMu.Lock()
+			whisperTranscribers = append(whisperTranscribers, s)
+			whisperMu.Unlock()
+			s.SetRollingWindow(*whisperWindow, *whisperStep, func(user string) bool {
+				return featureFlags.Enabled(features.RollingWhisper, user)
+			})
+			s.SetChunks(*whisperChunk, func(user string) bool {
+				return featureFlags.Enabled(features.ChunkedWhisper, user)
+			})
+			s.SetDiarizer(*diarizationCmd)
+			if err := s.SetSampleRate(*whisperSampleRate); err != nil {
+				log.Fatalf("Invalid --whisper.sample_rate: %v", err)
+			}
+		case *transcribe.TeeTranscriber:
+			for _, vendor := range s.Services() {
+				configureWhisper(vendor)
+			}
+		case *transcribe.FailoverTranscriber:
+			for _, vendor := range s.Services() {
+				configureWhisper(vendor)
+			}
+		}
+	}
+	configureWhisper(tr)
+	trVendor := vendorName(tr)
+
+	// The pool bounds the streams transcribed at the same time by the
+	// vendors of every tenant, e.g. the Whisper processes
+	var pool *transcribe.Pool
+	if *poolMaxStreams > 0 {
+		if pool, err = transcribe.NewPool(*poolMaxStreams, *poolTimeout); err != nil {
+			log.Fatalf("Invalid --pool.max_streams: %v", err)
+		}
+		tr = pool.Wrap(tr)
+	}
+
+	// The users of a tenant ("<user>@<tenant>") transcribe with the vendor
+	// and credentials of the tenant, in its own directory
+	if tenants, err = tenant.Load(*tenantsConfig, *output); err != nil {
+		log.Fatalf("Invalid --tenants.config: %v", err)
+	}
+	if tenants.Enabled() {
+		tr = tenant.NewService(tr, tenants, func(t *tenant.Tenant) (transcribe.Service, error) {
+			getenv := func(name string) string {
+				if value, ok := t.Credentials[name]; ok {
+					return value
+				}
+				return os.Getenv(name)
+			}
+			name := t.Vendor
+			if name == "" {
+				name = *vendor
+			}
+			service, err := transcribe.SelectVendorEnv(ctx, getenv, getenv("GOOGLE_CREDENTIALS"), name, currentWhisperModel(), tenants.TenantDir(t.ID), *language, *keepWav, *keepTxt)
+			if err != nil {
+				return nil, err
+			}
+			configureWhisper(service)
+			if pool != nil {
+				service = pool.Wrap(service)
+			}
+			return service, nil
+		})
+		log.Printf("Multi-tenancy enabled for %d tenants", len(tenants.List()))
+	}
+
+	// The streams of the users of the diarization feature label their speakers
+	tr = transcribe.NewDiarizationGate(tr, func(user string) bool {
+		return featureFlags.Enabled(features.Diarization, user)
+	})
+
+	// The streams of the users of the vad feature are transcribed utterance
+	// by utterance, e.g. Whisper sends results during the session
+	tr, err = transcribe.NewVADTranscriber(tr, transcribe.VADConfig{
+		Threshold:    *vadThreshold,
+		Silence:      *vadSilence,
+		MinSpeech:    *vadMinSpeech,
+		MaxUtterance: *vadMaxUtterance,
+	}, func(user string) bool {
+		return featureFlags.Enabled(features.VAD, user)
+	})
+	if err != nil {
+		log.Fatalf("Invalid --vad settings: %v", err)
+	}
+
+	// Normalize the loudness of the denoised audio before the pauses are
+	// detected and the audio is recorded and transcribed
+	if tr, err = transcribe.NewAGCTranscriber(tr, *agcTarget, *agcMaxGain); err != nil {
+		log.Fatalf("Invalid --agc settings: %v", err)
+	}
+
+	// The streams of the users of the denoise feature are denoised before
+	// the pauses are detected and the audio is recorded and transcribed
+	tr, err = transcribe.NewDenoiseTranscriber(tr, *denoiseReduction, func(user string) bool {
+		return featureFlags.Enabled(features.Denoise, user)
+	})
+	if err != nil {
+		log.Fatalf("Invalid --denoise.reduction: %v", err)
+	}
+
+	// Time the results from the start of their session for the clients
+	tr = transcribe.NewTimingTranscriber(tr)
+
+	// Encode the finalized recordings before their results reference them
+	if tr, err = transcribe.NewEncodingTranscriber(ctx, tr, *outputFormat, *outputFFmpeg); err != nil {
+		log.Fatalf("Invalid --output.format: %v", err)
+	}
 
-	webrtc := rtc.NewPionRtcService(*stunServer, tr)
+	// Fan the session events out to the catalog, the completion webhooks,
+	// the chat channels, MQTT and Kafka/NATS, failed deliveries are retried
+	// then dead-lettered
+	if *webhookRetries >= 0 {
+		*eventsRetries = *webhookRetries
+	}
+	if *eventsDeadLetter == "" {
+		*eventsDeadLetter = filepath.Join(*output, ".dead-letter.jsonl")
+	}
+	dispatcher := events.NewDispatcher(events.Config{
+		Retries:        *eventsRetries,
+		DeadLetterFile: *eventsDeadLetter,
+	})
+	catalogs := tenant.NewCatalogs(tenants)
+	dispatcher.Add(catalogs)
+	if webhookURLs := splitList(os.Getenv("WEBHOOK_URLS")); len(webhookURLs) > 0 {
+		sinks, err := webhook.NewSinks(webhook.Config{
+			URLs:         webhookURLs,
+			Secret:       os.Getenv("WEBHOOK_SECRET"),
+			TemplateFile: *webhookTemplate,
+		})
+		if err != nil {
+			log.Fatalf("Invalid webhook configuration: %v", err)
+		}
+		for _, sink := range sinks {
+			dispatcher.Add(sink)
+		}
+		log.Printf("Completion webhooks enabled for %d URLs", len(webhookURLs))
+	}
+	slackRoutes, err := chat.ParseRoutes(chat.Slack, os.Getenv("SLACK_WEBHOOK_URLS"))
+	if err != nil {
+		log.Fatalf("Invalid SLACK_WEBHOOK_URLS: %v", err)
+	}
+	discordRoutes, err := chat.ParseRoutes(chat.Discord, os.Getenv("DISCORD_WEBHOOK_URLS"))
+	if err != nil {
+		log.Fatalf("Invalid DISCORD_WEBHOOK_URLS: %v", err)
+	}
+	if chatRoutes := append(slackRoutes, discordRoutes...); len(chatRoutes) > 0 {
+		for _, sink := range chat.NewSinks(chatRoutes, *publicURL) {
+			dispatcher.Add(sink)
+		}
+		log.Printf("Chat notifications enabled for %d Slack/Discord webhooks", len(chatRoutes))
+	}
+
+	// Send the transcripts to the Telegram chats linked by the users
+	var telegramBot *telegram.Bot
+	var telegramLinks *telegram.Links
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		chats, err := telegram.ParseChats(os.Getenv("TELEGRAM_CHATS"))
+		if err != nil {
+			log.Fatalf("Invalid TELEGRAM_CHATS: %v", err)
+		}
+		telegramLinks, err = telegram.NewLinks(filepath.Join(*output, ".telegram.json"), chats)
+		if err != nil {
+			log.Fatalf("Failed to load Telegram links: %v", err)
+		}
+		telegramBot = telegram.NewBot(token)
+		go telegram.Listen(telegramBot, telegramLinks)
+		dispatcher.Add(telegram.NewSink(telegramBot, telegramLinks, *publicURL))
+		log.Printf("Telegram delivery of transcripts enabled")
+	}
+
+	// Publish session events and results to MQTT
+	if broker := os.Getenv("MQTT_BROKER"); broker != "" {
+		if *mqttQoS < 0 || *mqttQoS > 1 {
+			log.Fatalf("Invalid --mqtt.qos: %d (expected 0 or 1)", *mqttQoS)
+		}
+		clientID := os.Getenv("MQTT_CLIENT_ID")
+		if clientID == "" {
+			clientID = "webrtc-transcriber-" + generateSessionToken()[:8]
+		}
+		client, err := mqtt.NewClient(broker, clientID, os.Getenv("MQTT_USERNAME"), os.Getenv("MQTT_PASSWORD"))
+		if err != nil {
+			log.Fatalf("Invalid MQTT configuration: %v", err)
+		}
+		defer client.Close()
+		dispatcher.Add(mqtt.NewSink(client, mqtt.Config{
+			Topic:    *mqttTopic,
+			QoS:      byte(*mqttQoS),
+			Partials: *mqttPartials,
+		}))
+		log.Printf("MQTT publishing enabled (broker: %s, topic: %s)", broker, *mqttTopic)
+	}
+
+	// Stream transcript segments to Kafka or NATS
+	if *streamBackend != "" {
+		if *streamFormat != streaming.FormatJSON && *streamFormat != streaming.FormatProtobuf {
+			log.Fatalf("Invalid --stream.format: %s (expected json or protobuf)", *streamFormat)
+		}
+		publisher, err := newStreamPublisher(*streamBackend)
+		if err != nil {
+			log.Fatalf("Invalid stream configuration: %v", err)
+		}
+		defer publisher.Close()
+		dispatcher.Add(streaming.NewSink(*streamBackend, publisher, streaming.Config{
+			Subject:  *streamSubject,
+			Format:   *streamFormat,
+			Partials: *streamPartials,
+		}))
+		log.Printf("Streaming transcript segments to %s (subject: %s, format: %s)", *streamBackend, *streamSubject, *streamFormat)
+	}
+	tr = events.NewService(tr, dispatcher)
+
+	// Alert when error rates go over their thresholds
+	monitor, err := newAlertMonitor(*alertWindow, *alertThresholds, dispatcher)
+	if err != nil {
+		log.Fatalf("Invalid alert configuration: %v", err)
+	}
+	alert.SetDefault(monitor)
+	tr = alert.NewService(tr, monitor)
+
+	// Collect session statistics for the dashboard
+	collector := stats.NewCollector(trVendor)
+	if pool != nil {
+		collector.SetPool(pool)
+	}
+	tr = stats.NewService(tr, collector)
+
+	// Limit the transcription minutes of the users per month
+	var quotaTracker *quota.Tracker
+	if *quotaMinutes != "" {
+		limits, err := quota.ParseLimits(*quotaMinutes)
+		if err != nil {
+			log.Fatalf("Invalid --quota.minutes: %v", err)
+		}
+		quotaTracker, err = quota.NewTracker(limits, userRole, filepath.Join(*output, ".quota.json"))
+		if err != nil {
+			log.Fatalf("Failed to load quota usage: %v", err)
+		}
+		tr = quota.NewService(tr, quotaTracker)
+		log.Printf("Transcription quotas enabled: %s", *quotaMinutes)
+	}
+
+	// Repair recordings left behind by a previous crash. The replicas of a
+	// cluster may share the output directory and be writing its recordings,
+	// they only repair the older ones and when asked to
+	recoverMinAge := time.Duration(0)
+	if shared != nil {
+		recoverSet := false
+		flag.Visit(func(f *flag.Flag) {
+			recoverSet = recoverSet || f.Name == "recover"
+		})
+		if *recoverFiles && !recoverSet {
+			*recoverFiles = false
+			log.Printf("Recording recovery disabled with REDIS_URL, enable it with --recover on one replica")
+		}
+		recoverMinAge = *recoverAge
+	}
+	if *recoverFiles {
+		repaired, err := transcribe.RecoverRecordings(*output, recoverMinAge)
+		if err != nil {
+			log.Printf("Warning: recording recovery failed: %v", err)
+		} else if len(repaired) > 0 {
+			log.Printf("Recovered %d orphaned recordings", len(repaired))
+			if *recoverTranscribe {
+				go transcribe.TranscribeFiles(tr, repaired, transcribe.StreamOptions{
+					Language:   *language,
+					Transcribe: true,
+				})
+			}
+		}
+	}
+
+	// Broadcast live transcripts to the SSE, WebSocket and gRPC subscribers
+	hub := live.NewHub(tr)
+	hub.SetRoomAccess(func(owner, user string) bool {
+		return isAdmin(user) || tenants.Of(owner) == tenants.Of(user)
+	})
+	tr = hub
+	if shared != nil {
+		shared.RelayLive(hub)
+	}
+
+	// Serve the captions of the live sessions as HLS WebVTT playlists
+	captionFormat := captions.Format{
+		LineChars:     *captionsChars,
+		Lines:         *captionsLines,
+		SentenceCase:  *captionsSentenceCase,
+		RemoveFillers: *captionsRemoveFillers,
+	}
+	captioner := hls.NewCaptioner(hub, captionFormat)
+	go captioner.Run()
+
+	// Save the subtitles of the sessions whose vendor times the words
+	if formats := splitList(*subtitles); len(formats) > 0 {
+		sink, err := catalog.NewSubtitles(captionFormat, formats)
+		if err != nil {
+			log.Fatalf("Invalid --subtitles: %v", err)
+		}
+		dispatcher.Add(sink)
+		log.Printf("Subtitles enabled (%s)", strings.Join(formats, ", "))
+	}
+
+	// Push the finalized captions to OBS and caption endpoints
+	var captionOutputs []captions.Output
+	if *captionsOBS != "" {
+		captionOutputs = append(captionOutputs, captions.NewOBSOutput(*captionsOBS, os.Getenv("OBS_WEBSOCKET_PASSWORD")))
+	}
+	if *captionsWS != "" {
+		captionOutputs = append(captionOutputs, captions.NewWebSocketOutput(*captionsWS, os.Getenv("CAPTIONS_WS_TOKEN")))
+	}
+	if len(captionOutputs) > 0 {
+		go captions.NewRelay(hub, *captionsUser, captionFormat, captionOutputs...).Run()
+		log.Printf("Live captions enabled for %d outputs", len(captionOutputs))
+	}
+
+	// Pull and transcribe the configured and registered RTSP, HTTP and RTP
+	// audio feeds
+	feedManager, err := feeds.NewManager(tr, *feedsFFmpeg, filepath.Join(*output, ".feeds.json"))
+	if err != nil {
+		log.Fatalf("Failed to load audio feeds: %v", err)
+	}
+	staticFeeds, err := feeds.ParseFeeds(*feedsConfig, *language)
+	if err == nil {
+		err = feedManager.Configure(staticFeeds)
+	}
+	if err != nil {
+		log.Fatalf("Invalid --feeds: %v", err)
+	}
+	feedManager.Start()
+
+	// Transcribe the audio files dropped in the watched folders
+	if *watchConfig != "" {
+		folders, err := watch.LoadFolders(*watchConfig)
+		if err != nil {
+			log.Fatalf("Invalid --watch.config: %v", err)
+		}
+		// The worker resolves the vendors one file at a time, the other
+		// vendors publish their events but skip the live and stats layers
+		vendorServices := map[string]transcribe.Service{"": tr, *vendor: tr}
+		watcher := watch.NewWatcher(watch.Config{
+			Folders:    folders,
+			Interval:   *watchInterval,
+			Language:   *language,
+			FFmpegPath: *watchFFmpeg,
+		}, func(name string) (transcribe.Service, error) {
+			if service, ok := vendorServices[name]; ok {
+				return service, nil
+			}
+			service, err := transcribe.SelectVendor(ctx, googleCred, name, currentWhisperModel(), *output, *language, *keepWav, *keepTxt)
+			if err != nil {
+				return nil, err
+			}
+			service = events.NewService(service, dispatcher)
+			vendorServices[name] = service
+			return service, nil
+		})
+		go watcher.Run()
+	}
+
+	// The vendor comparisons transcribe in a scratch directory, outside of
+	// the catalog, the events and the quotas
+	var compareMu sync.Mutex
+	compareServices := make(map[string]transcribe.Service)
+	comparer := compare.New(func(name string) (transcribe.Service, error) {
+		compareMu.Lock()
+		defer compareMu.Unlock()
+		if service, ok := compareServices[name]; ok {
+			return service, nil
+		}
+		service, err := transcribe.SelectVendor(ctx, googleCred, name, currentWhisperModel(), filepath.Join(os.TempDir(), "transcribe-compare"), *language, false, false)
+		if err != nil {
+			return nil, err
+		}
+		// SelectVendor falls back to the recorder, which transcribes nothing
+		if _, ok := service.(*transcribe.RecorderTranscriber); ok {
+			return nil, fmt.Errorf("vendor %s is not available", name)
+		}
+		compareServices[name] = service
+		return service, nil
+	}, *watchFFmpeg)
+
+	webrtc := rtc.NewPionRtcService(*stunServer, tr, *sessionGrace)
+	icePortMin, icePortMax, err := rtc.ParsePortRange(*icePorts)
+	if err != nil {
+		log.Fatalf("Invalid --ice.udp_ports: %v", err)
+	}
+	var turnEmbeddedServer *turn.Server
+	if *turnEmbedded != "" {
+		if *turnServer != "" {
+			log.Fatalf("--turn.embedded and --turn.server are exclusive")
+		}
+		relayMin, relayMax, err := rtc.ParsePortRange(*turnRelayPorts)
+		if err != nil {
+			log.Fatalf("Invalid --turn.relay_ports: %v", err)
+		}
+		if *turnUsername == "" {
+			*turnUsername = "transcriber"
+		}
+		if *turnPassword == "" {
+			// Handed to the clients with /api/ice, a random one does as well
+			secret := make([]byte, 12)
+			rand.Read(secret)
+			*turnPassword = hex.EncodeToString(secret)
+		}
+		turnEmbeddedServer = turn.NewServer(turn.Config{
+			Addr:     *turnEmbedded,
+			PublicIP: net.ParseIP(*natPublicIP),
+			Username: *turnUsername,
+			Password: *turnPassword,
+			PortMin:  int(relayMin),
+			PortMax:  int(relayMax),
+			// The clients relay to the ICE candidates of the server alone
+			PeerPortMin: int(icePortMin),
+			PeerPortMax: int(icePortMax),
+		})
+		if *turnServer, err = turnEmbeddedServer.URL(); err != nil {
+			log.Fatalf("Invalid --turn.embedded: %v", err)
+		}
+	}
+	iceConfig := rtc.ICEConfig{ICEServers: []rtc.ICEServer{}}
+	if pion, ok := webrtc.(*rtc.PionRtcService); ok {
+		if err := pion.SetTURNServer(*turnServer, *turnUsername, *turnPassword); err != nil {
+			log.Fatalf("Invalid --turn.server: %v", err)
+		}
+		if err := pion.SetTransportPolicy(*icePolicy); err != nil {
+			log.Fatalf("Invalid --ice.policy: %v", err)
+		}
+		if err := pion.SetPublicIP(*natPublicIP); err != nil {
+			log.Fatalf("Invalid --nat.public-ip: %v", err)
+		}
+		if err := pion.SetPortRange(icePortMin, icePortMax); err != nil {
+			log.Fatalf("Invalid --ice.udp_ports: %v", err)
+		}
+		iceConfig = pion.ICEConfig()
+		if *videoRecord {
+			pion.SetVideoRecording(tenants.Dir)
+		}
+		if err := pion.SetOpusRecording(*opusRecord, tenants.Dir); err != nil {
+			log.Fatalf("Invalid --opus.record: %v", err)
+		}
+		if err := pion.SetIdleTimeout(*sessionIdle); err != nil {
+			log.Fatalf("Invalid --session.idle: %v", err)
+		}
+		if err := pion.SetJitterBuffer(*jitterDelay); err != nil {
+			log.Fatalf("Invalid --rtc.jitter: %v", err)
+		}
+	}
 	// webrtc = rtc.NewLoggingService(webrtc)
 
 	// Create a new mux for all routes
@@ -513,8 +1449,158 @@ func main() {
 	mux.Handle("/", http.FileServer(http.Dir("./frontend/dist")))
 
 	// Protected routes (auth required)
-	mux.Handle("/session", authMiddleware(session.MakeHandler(webrtc)))
-	mux.Handle("/recordings/", authMiddleware(http.StripPrefix("/recordings", http.FileServer(http.Dir(*output)))))
+	sessionHandler := session.MakeHandler(webrtc, hub.CanJoin)
+	if quotaTracker != nil {
+		sessionHandler = quota.Require(quotaTracker, sessionHandler)
+	}
+	mux.Handle("/session", authMiddleware(sessionHandler))
+
+	// WHIP publishers and raw audio clients authenticate with a stream key
+	// or a login session token
+	streamKeys := loadStreamKeys()
+	authenticateToken := func(token string) (string, bool) {
+		if username, ok := streamKeys[token]; ok {
+			return username, true
+		}
+		return sessionStore.validateSession(token)
+	}
+	whipHandler := whip.MakeHandler("/whip", webrtc, authenticateToken)
+	if shared != nil {
+		whipHandler.SetDirectory(shared.WHIPDirectory(whipHandler.End))
+		mux.Handle("/admin/cluster", adminMiddleware(cluster.MakeAdminHandler(shared)))
+		go shared.Run()
+	}
+	mux.Handle("/whip", whipHandler)
+	mux.Handle("/whip/", whipHandler)
+	wsaudioHandler := wsaudio.MakeHandler(tr)
+	if quotaTracker != nil {
+		wsaudioHandler = quota.Require(quotaTracker, wsaudioHandler)
+	}
+	if jobService != nil {
+		mux.Handle("/worker/jobs/", jobs.MakeHandler(jobService, os.Getenv("WORKER_TOKEN")))
+	}
+	mux.Handle("/ws/audio", tokenMiddleware(authenticateToken, wsaudioHandler))
+	forkHandler := wsaudio.MakeForkHandler(tr, *language)
+	if quotaTracker != nil {
+		forkHandler = quota.Require(quotaTracker, forkHandler)
+	}
+	mux.Handle("/ws/fork", wsaudio.RequireKey(authenticateToken, forkHandler))
+
+	// Voice commands are forwarded to Home Assistant or a webhook
+	switch *intentBackend {
+	case "":
+	case "homeassistant":
+		haURL, haToken := os.Getenv("HA_URL"), os.Getenv("HA_TOKEN")
+		if haURL == "" || haToken == "" {
+			log.Fatalf("--intent.backend=homeassistant requires HA_URL and HA_TOKEN")
+		}
+		intentHandler := intent.NewHomeAssistant(haURL, haToken, os.Getenv("HA_AGENT_ID"))
+		mux.Handle("/api/intent", tokenMiddleware(authenticateToken, intent.MakeHandler(tr, hub, intentHandler)))
+	case "webhook":
+		intentURL := os.Getenv("INTENT_WEBHOOK_URL")
+		if intentURL == "" {
+			log.Fatalf("--intent.backend=webhook requires INTENT_WEBHOOK_URL")
+		}
+		intentHandler := intent.NewWebhook(intentURL, os.Getenv("WEBHOOK_SECRET"))
+		mux.Handle("/api/intent", tokenMiddleware(authenticateToken, intent.MakeHandler(tr, hub, intentHandler)))
+	default:
+		log.Fatalf("Unknown intent backend: %s", *intentBackend)
+	}
+
+	// Twilio Media Streams are authenticated by the request signature
+	if twilioToken := os.Getenv("TWILIO_AUTH_TOKEN"); twilioToken != "" {
+		if *publicURL == "" {
+			log.Fatalf("TWILIO_AUTH_TOKEN requires --public_url to validate the Twilio signatures")
+		}
+		twilioConfig := twilio.Config{
+			AuthToken:    twilioToken,
+			PublicURL:    *publicURL,
+			Language:     *language,
+			Authenticate: authenticateToken,
+		}
+		if twilioURLs := splitList(os.Getenv("TWILIO_WEBHOOK_URLS")); len(twilioURLs) > 0 {
+			sinks, err := webhook.NewSinks(webhook.Config{
+				URLs:   twilioURLs,
+				Secret: os.Getenv("WEBHOOK_SECRET"),
+				Events: []string{"twilio.transcript", "twilio.completed"},
+			})
+			if err != nil {
+				log.Fatalf("Invalid Twilio webhook configuration: %v", err)
+			}
+			for _, sink := range sinks {
+				dispatcher.Add(sink)
+			}
+			twilioConfig.Events = dispatcher
+		}
+		mux.Handle("/twilio/media", twilio.MakeHandler(tr, twilioConfig))
+		log.Printf("Twilio Media Streams enabled on /twilio/media")
+	}
+
+	// The statistics cover the sessions of every tenant
+	mux.Handle("/api/stats", adminMiddleware(stats.MakeHandler(collector, *output)))
+	mux.Handle("/api/transcripts/events", authMiddleware(live.MakeSSEHandler(hub)))
+	mux.Handle("/api/transcripts/ws", authMiddleware(live.MakeWebSocketHandler(hub)))
+	mux.Handle("/api/transcripts/markers", authMiddleware(live.MakeMarkerHandler(hub)))
+	mux.Handle("/api/rooms/", authMiddleware(live.MakeRoomHandler("/api/rooms/", hub)))
+	mux.Handle("/api/transcripts/", authMiddleware(tenants.Handler(func(id string) http.Handler {
+		return catalog.MakeHandler(catalogs.For(id), isTenantAdmin, captionFormat)
+	})))
+	if pion, ok := webrtc.(*rtc.PionRtcService); ok {
+		mux.Handle("/sessions/", authMiddleware(rtc.MakeStatsHandler("/sessions/", pion, func(owner, user string) bool {
+			return tenants.Visible(owner, user, isAdmin)
+		})))
+	}
+	mux.Handle("/hls/", authMiddleware(hls.MakeHandler("/hls/", captioner, func(owner, user string) bool {
+		return tenants.Visible(owner, user, isAdmin)
+	})))
+	if *askURL != "" {
+		backend := ask.NewOpenAI(*askURL, os.Getenv("LLM_API_KEY"), *askModel, *askEmbeddingModel)
+		mux.Handle("/api/transcripts/ask", authMiddleware(tenants.Handler(func(id string) http.Handler {
+			index := ask.NewIndex(tenants.TenantDir(id), catalogs.For(id), backend)
+			return ask.MakeHandler(catalogs.For(id), index, backend, isTenantAdmin)
+		})))
+		log.Printf("Transcript Q&A enabled (model: %s, embeddings: %s)", *askModel, *askEmbeddingModel)
+	}
+	mux.Handle("/graphql", authMiddleware(tenants.Handler(func(id string) http.Handler {
+		return graphql.MakeHandler(graphql.NewSchema(catalogs.For(id), tenantUsers{id: id}))
+	})))
+	mux.Handle("/api/tenant", authMiddleware(tenant.MakeHandler(tenants, accountNames)))
+	mux.Handle("/api/features", authMiddleware(features.MakeHandler(featureFlags)))
+	mux.Handle("/api/ice", authMiddleware(rtc.MakeICEHandler(iceConfig)))
+	if telegramBot != nil {
+		mux.Handle("/api/telegram/link", authMiddleware(telegram.MakeHandler(telegramBot, telegramLinks)))
+	}
+	mux.Handle("/admin/features", adminMiddleware(features.MakeAdminHandler(featureFlags)))
+	mux.Handle("/admin/feeds", adminMiddleware(feeds.MakeAdminHandler(feedManager)))
+	mux.Handle("/admin/models", adminMiddleware(models.MakeAdminHandler(modelStore)))
+	mux.Handle("/admin/whisper/model", adminMiddleware(models.MakeSelectHandler(modelStore, func() string {
+		whisperMu.Lock()
+		defer whisperMu.Unlock()
+		return whisperModelName
+	}, func(name, path string) error {
+		whisperMu.Lock()
+		defer whisperMu.Unlock()
+		switched := 0
+		for _, w := range whisperTranscribers {
+			if w.SetModel(path) == nil {
+				switched++
+			}
+		}
+		if switched == 0 {
+			return fmt.Errorf("no vendor runs a local Whisper model")
+		}
+		whisperModel, whisperModelName = path, name
+		return nil
+	})))
+	mux.Handle("/admin/compare", adminMiddleware(compare.MakeAdminHandler(comparer, tenants.Dir, *language)))
+	mux.Handle("/admin/tenants", adminMiddleware(tenant.MakeAdminHandler(tenants, accountNames)))
+	if quotaTracker != nil {
+		mux.Handle("/api/quota", authMiddleware(quota.MakeHandler(quotaTracker)))
+		mux.Handle("/admin/quotas", adminMiddleware(quota.MakeAdminHandler(quotaTracker, accountNames)))
+	}
+	mux.Handle("/recordings/", authMiddleware(tenants.Handler(func(id string) http.Handler {
+		return http.StripPrefix("/recordings", tenants.FileServer(id))
+	})))
 
 	// Endpoint to list files in the recordings directory (protected)
 	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
@@ -524,34 +1610,54 @@ func main() {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		_, valid := sessionStore.validateSession(cookie.Value)
+		username, valid := sessionStore.validateSession(cookie.Value)
 		if !valid {
 			http.Error(w, "Session expired", http.StatusUnauthorized)
 			return
 		}
 
-		files, err := os.ReadDir(*output)
+		files, err := os.ReadDir(tenants.Dir(username))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// Tag the files with the language of their session, ?language=
+		// keeps the sessions requested or detected in the language
+		sessions := make(map[string]*catalog.Session)
+		if all, err := catalogs.For(tenants.Of(username)).Sessions(); err == nil {
+			for _, s := range all {
+				sessions[s.ID] = s
+			}
+		}
+		languageFilter := r.URL.Query().Get("language")
+
 		// Collect file info with modification time
 		type fileInfo struct {
-			Name    string
-			ModTime int64
+			Name     string
+			ModTime  int64
+			Language string
+			Detected string
 		}
 		var fileInfoList []fileInfo
 		for _, file := range files {
-			if !file.IsDir() {
+			if !file.IsDir() && !tenant.Hidden(file.Name()) {
 				info, err := file.Info()
 				if err != nil {
 					continue
 				}
-				fileInfoList = append(fileInfoList, fileInfo{
+				session := sessions[strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))]
+				if languageFilter != "" && (session == nil || !session.HasLanguage(languageFilter)) {
+					continue
+				}
+				f := fileInfo{
 					Name:    file.Name(),
 					ModTime: info.ModTime().UnixMilli(),
-				})
+				}
+				if session != nil {
+					f.Language, f.Detected = session.Language, session.Detected
+				}
+				fileInfoList = append(fileInfoList, f)
 			}
 		}
 
@@ -560,14 +1666,19 @@ func main() {
 			return fileInfoList[i].ModTime > fileInfoList[j].ModTime
 		})
 
-		// Return JSON response with file info
+		// Return JSON response with file info, the languages come from the
+		// clients and are escaped
+		quote := func(s string) string {
+			b, _ := json.Marshal(s)
+			return string(b)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte("["))
 		for i, f := range fileInfoList {
 			if i > 0 {
 				w.Write([]byte(","))
 			}
-			w.Write([]byte(fmt.Sprintf(`{"name":"%s","modTime":%d}`, f.Name, f.ModTime)))
+			w.Write([]byte(fmt.Sprintf(`{"name":"%s","modTime":%d,"language":%s,"detectedLanguage":%s}`, f.Name, f.ModTime, quote(f.Language), quote(f.Detected))))
 		}
 		w.Write([]byte("]"))
 	})
@@ -580,7 +1691,7 @@ func main() {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		_, valid := sessionStore.validateSession(cookie.Value)
+		username, valid := sessionStore.validateSession(cookie.Value)
 		if !valid {
 			http.Error(w, "Session expired", http.StatusUnauthorized)
 			return
@@ -605,10 +1716,10 @@ func main() {
 		filename = strings.ReplaceAll(filename, "\\", "")
 
 		// Build full path
-		filePath := fmt.Sprintf("%s/%s", *output, filename)
+		filePath := fmt.Sprintf("%s/%s", tenants.Dir(username), filename)
 
-		// Check if file exists
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		// Check if file exists, the dot files hold the state of the server
+		if _, err := os.Stat(filePath); os.IsNotExist(err) || tenant.Hidden(filename) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte(`{"success": false, "message": "File not found"}`))
@@ -629,18 +1740,137 @@ func main() {
 		w.Write([]byte(`{"success": true}`))
 	})
 
-	errors := make(chan error, 2)
+	// Under systemd socket activation the HTTP and gRPC sockets are passed
+	// by the service manager, by FileDescriptorName= (the first unnamed one
+	// is the HTTP socket)
+	activated, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("Invalid socket activation: %v", err)
+	}
+	httpListener := activated["http"]
+	if httpListener == nil {
+		httpListener = activated["unknown"]
+	}
+	if httpListener == nil {
+		if httpListener, err = net.Listen("tcp", fmt.Sprintf(":%s", *httpPort)); err != nil {
+			log.Fatalf("Failed to listen on port %s: %v", *httpPort, err)
+		}
+	}
+
+	errors := make(chan error, 5)
+	httpServer := &http.Server{Handler: mux}
 	go func() {
-		log.Printf("Starting signaling server on port %s", *httpPort)
-		errors <- http.ListenAndServe(fmt.Sprintf(":%s", *httpPort), mux)
+		log.Printf("Starting signaling server on %s", httpListener.Addr())
+		errors <- httpServer.Serve(httpListener)
 	}()
 
+	if *sipAddr != "" {
+		portMin, portMax, err := sip.ParsePortRange(*sipRTPPorts)
+		if err != nil {
+			log.Fatalf("Invalid --sip.rtp_ports: %v", err)
+		}
+		allow, err := sip.ParseNetworks(*sipAllow)
+		if err != nil {
+			log.Fatalf("Invalid --sip.allow: %v", err)
+		}
+		var registration *sip.Registration
+		if *sipRegister != "" {
+			if registration, err = sip.ParseRegistration(*sipRegister, os.Getenv("SIP_PASSWORD")); err != nil {
+				log.Fatalf("Invalid --sip.register: %v", err)
+			}
+		}
+		sipServer := sip.NewServer(tr, sip.Config{
+			Addr:      *sipAddr,
+			PublicIP:  *sipPublicIP,
+			PortMin:   portMin,
+			PortMax:   portMax,
+			Allow:     allow,
+			Language:  *language,
+			OutputDir: *output,
+			User:      *sipUser,
+			Register:  registration,
+		})
+		go func() {
+			log.Printf("Starting SIP server on %s", *sipAddr)
+			errors <- sipServer.ListenAndServe()
+		}()
+	}
+
+	if turnEmbeddedServer != nil {
+		go func() {
+			log.Printf("Starting TURN server on %s (%s)", *turnEmbedded, *turnServer)
+			errors <- turnEmbeddedServer.ListenAndServe()
+		}()
+	}
+
+	if *rtmpAddr != "" {
+		if len(streamKeys) == 0 {
+			log.Printf("Warning: No stream keys configured (STREAM_KEYS=user=key,...), every RTMP stream key is accepted")
+		}
+		rtmpServer := rtmp.NewServer(tr, rtmp.Config{
+			Addr:       *rtmpAddr,
+			FFmpegPath: *rtmpFFmpeg,
+			Language:   *language,
+			Keys:       streamKeys,
+		})
+		go func() {
+			log.Printf("Starting RTMP server on %s", *rtmpAddr)
+			errors <- rtmpServer.ListenAndServe()
+		}()
+	}
+
+	if *grpcPort != "" || activated["grpc"] != nil {
+		go func() {
+			listener := activated["grpc"]
+			if listener == nil {
+				var err error
+				if listener, err = net.Listen("tcp", fmt.Sprintf(":%s", *grpcPort)); err != nil {
+					errors <- err
+					return
+				}
+			}
+			log.Printf("Starting gRPC server on %s", listener.Addr())
+			errors <- grpcapi.NewServer(webrtc, hub, tenants.Dir, sessionStore.validateSession).Serve(listener)
+		}()
+	}
+
 	go func() {
-		interrupt := make(chan os.Signal, 1)
-		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
-		errors <- fmt.Errorf("received %v signal", <-interrupt)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				systemd.Notify("RELOADING=1")
+				reloadConfig()
+				systemd.Notify("READY=1")
+				continue
+			}
+			errors <- fmt.Errorf("received %v signal", sig)
+			return
+		}
 	}()
 
+	if ok, err := systemd.Notify("READY=1\nSTATUS=Listening on " + httpListener.Addr().String()); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	} else if ok {
+		log.Printf("Notified systemd of the readiness")
+	}
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				systemd.Notify("WATCHDOG=1")
+			}
+		}()
+	}
+
 	err = <-errors
 	log.Printf("%s, exiting.", err)
+	systemd.Notify("STOPPING=1")
+
+	// Let the in-flight HTTP requests finish, the WebSocket and WebRTC
+	// sessions are cut when the process exits
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdown); err != nil {
+		log.Printf("Error shutting down the HTTP server: %v", err)
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/walterfan/webrtc-transcriber/internal/models"
+)
+
+// modelsDirDefault returns the default directory of the Whisper models
+func modelsDirDefault() string {
+	if dir := os.Getenv("WHISPER_MODELS_DIR"); dir != "" {
+		return dir
+	}
+	return models.DefaultDir()
+}
+
+// runModelsCommand runs "models list|download|remove" and returns the
+// exit code
+func runModelsCommand(args []string) int {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	dir := fs.String("dir", modelsDirDefault(), "Directory of the downloaded Whisper models")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s models [options] <command>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  list              List the downloaded models with their sizes and checksums\n")
+		fmt.Fprintf(os.Stderr, "  download <name>   Download a model: %s\n", strings.Join(models.Names(), ", "))
+		fmt.Fprintf(os.Stderr, "  remove <name>     Remove a downloaded model\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	store := models.NewStore(*dir, os.Getenv("HF_ENDPOINT"))
+	switch fs.Arg(0) {
+	case "list":
+		list, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if len(list) == 0 {
+			fmt.Printf("No model in %s\n", store.Dir())
+			return 0
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "NAME\tSIZE\tFILE\tSHA256\n")
+		for _, m := range list {
+			fmt.Fprintf(w, "%s\t%s\t\t\n", m.Name, formatSize(m.Size))
+			for _, f := range m.Files {
+				fmt.Fprintf(w, "\t%s\t%s\t%s\n", formatSize(f.Size), f.Name, f.SHA256)
+			}
+		}
+		w.Flush()
+		return 0
+
+	case "download", "pull":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		failed := false
+		for _, name := range fs.Args()[1:] {
+			if path, ok := store.Path(name); ok {
+				fmt.Printf("%s is already downloaded to %s\n", name, path)
+				continue
+			}
+			if err := store.Pull(context.Background(), name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = true
+			}
+		}
+		if failed {
+			return 1
+		}
+		return 0
+
+	case "remove", "rm":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		for _, name := range fs.Args()[1:] {
+			found, err := store.Remove(name)
+			if !found {
+				fmt.Fprintf(os.Stderr, "Error: model %s is not downloaded\n", name)
+				return 1
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Removed %s\n", name)
+		}
+		return 0
+
+	default:
+		fs.Usage()
+		return 2
+	}
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/jobs"
+	"github.com/walterfan/webrtc-transcriber/internal/redis"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// popWait is how long a worker waits for a job before polling again
+const popWait = 5 * time.Second
+
+// worker transcribes the jobs of the queue
+type worker struct {
+	name       string
+	token      string
+	language   string
+	ffmpegPath string
+	service    transcribe.Service
+	client     *http.Client
+}
+
+func main() {
+	// Credentials of the cloud vendors are read from .env like the server
+	godotenv.Load()
+
+	hostname, _ := os.Hostname()
+	vendor := flag.String("vendor", "whisper", "Transcription vendor: google, azure, baidu, xunfei, aliyun, tencent, deepgram, speechmatics, soniox, riva, kaldi, vosk, coqui, openai, whisper, mock, exec, grpc")
+	execCmd := flag.String("exec.cmd", os.Getenv("EXEC_CMD"), "Plugin run with sh -c for each stream of --vendor=exec, see docs/EXEC_SETUP.md")
+	punctuate := flag.String("punctuate", os.Getenv("PUNCTUATE_VENDORS"), "Vendors whose results get their punctuation and casing restored, e.g. \"baidu,vosk\"")
+	postprocess := flag.String("postprocess", os.Getenv("POSTPROCESS"), "Processors applied in order to the results: normalize, replace, redact, e.g. \"replace,redact\"")
+	replacements := flag.String("postprocess.replacements", os.Getenv("POSTPROCESS_REPLACEMENTS"), "File of the \"from => to\" replacements of the replace processor")
+	model := flag.String("model", "small", "Whisper model: tiny, base, small, medium, large")
+	language := flag.String("language", "auto", "Language of the jobs recorded without one (e.g., en, cn, auto)")
+	ffmpegPath := flag.String("ffmpeg", "ffmpeg", "ffmpeg executable decoding the non WAV files")
+	concurrency := flag.Int("concurrency", 1, "Number of jobs transcribed at the same time")
+	prefix := flag.String("cluster.prefix", "transcriber:", "Prefix of the Redis keys, the same as the servers")
+	name := flag.String("name", hostname, "Name of the worker reported with the results")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Transcribes the recordings queued by transcribe-server --vendor=worker.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
+		fmt.Fprintf(os.Stderr, "  REDIS_URL     - Redis of the servers (redis://[:password@]host:port[/db])\n")
+		fmt.Fprintf(os.Stderr, "  WORKER_TOKEN  - Token of the workers, the same as the servers\n")
+		fmt.Fprintf(os.Stderr, "\nThe cloud vendors read the same environment variables as transcribe-server\n")
+		fmt.Fprintf(os.Stderr, "(GOOGLE_CREDENTIALS, AZURE_SPEECH_KEY, ...).\n")
+	}
+	flag.Parse()
+	// The vendors read the plugin command from the environment
+	if *execCmd != "" {
+		os.Setenv("EXEC_CMD", *execCmd)
+	}
+	transcribe.SetPunctuation(strings.Split(*punctuate, ","))
+	chain, err := transcribe.NewProcessors(strings.Split(*postprocess, ","), transcribe.ProcessorOptions{Replacements: *replacements})
+	if err != nil {
+		log.Fatalf("Invalid --postprocess: %v", err)
+	}
+	transcribe.SetProcessors(chain)
+
+	redisURL := os.Getenv("REDIS_URL")
+	token := os.Getenv("WORKER_TOKEN")
+	if redisURL == "" || token == "" {
+		log.Fatalf("REDIS_URL and WORKER_TOKEN environment variables are required")
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	// Whisper writes its WAV files to a scratch directory removed on exit
+	scratch, err := ioutil.TempDir("", "transcribe-worker")
+	if err != nil {
+		log.Fatalf("Failed to create scratch directory: %v", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	service, err := transcribe.SelectVendor(context.Background(), os.Getenv("GOOGLE_CREDENTIALS"), *vendor, *model, scratch, *language, false, false)
+	if err != nil {
+		log.Fatalf("Failed to create transcription service: %v", err)
+	}
+	// SelectVendor falls back to the recorder, which transcribes nothing
+	if _, ok := service.(*transcribe.RecorderTranscriber); ok {
+		log.Fatalf("Vendor %s is not available", *vendor)
+	}
+
+	w := &worker{
+		name:       *name,
+		token:      token,
+		language:   *language,
+		ffmpegPath: *ffmpegPath,
+		service:    service,
+		client:     &http.Client{Timeout: 10 * time.Minute},
+	}
+	log.Printf("Worker %s transcribing %d job(s) at a time with %s", w.name, *concurrency, *vendor)
+
+	done := make(chan struct{})
+	for i := 0; i < *concurrency; i++ {
+		// Each loop has its own connection, BRPOP blocks it while waiting
+		client, err := redis.NewClient(redisURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		go func(queue *jobs.Queue) {
+			defer func() { done <- struct{}{} }()
+			w.run(queue)
+		}(jobs.NewQueue(client, *prefix))
+	}
+	for i := 0; i < *concurrency; i++ {
+		<-done
+	}
+}
+
+// run pops and transcribes the jobs of the queue forever
+func (w *worker) run(queue *jobs.Queue) {
+	for {
+		job, err := queue.Pop(popWait)
+		if err != nil {
+			log.Printf("Error popping a job: %v", err)
+			time.Sleep(popWait)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		w.process(job)
+	}
+}
+
+// process transcribes a job and posts its result
+func (w *worker) process(job *jobs.Job) {
+	start := time.Now()
+	log.Printf("Transcribing job %s of %s", job.ID, job.User)
+	result := jobs.Result{Worker: w.name}
+	text, err := w.transcribe(job)
+	if err != nil {
+		log.Printf("Job %s failed: %v", job.ID, err)
+		result.Error = err.Error()
+	} else {
+		result.Text = text
+	}
+	if err := w.post(job.ResultURL, result); err != nil {
+		log.Printf("Error posting the result of job %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Completed job %s in %s", job.ID, time.Since(start).Round(time.Millisecond))
+}
+
+// transcribe downloads the audio of a job and transcribes it
+func (w *worker) transcribe(job *jobs.Job) (string, error) {
+	file, err := ioutil.TempFile("", "job-*.wav")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	req, err := http.NewRequest(http.MethodGet, job.AudioURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+w.token)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download the audio: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download the audio: %s", resp.Status)
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to download the audio: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	language := job.Language
+	if language == "" {
+		language = w.language
+	}
+	return transcribeFile(w.service, file.Name(), language, w.ffmpegPath)
+}
+
+// post sends the result of a job to the server
+func (w *worker) post(url string, result jobs.Result) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+w.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server replied %s", resp.Status)
+	}
+	return nil
+}
+
+// transcribeFile streams the decoded audio of a file through the service
+// and returns its final results, one per line
+func transcribeFile(service transcribe.Service, path, language, ffmpegPath string) (string, error) {
+	pcm, err := audio.DecodeFile(path, ffmpegPath, transcribe.PipelineSampleRate)
+	if err != nil {
+		return "", err
+	}
+	stream, err := service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   language,
+		Transcribe: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription stream: %w", err)
+	}
+	var lines []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range stream.Results() {
+			if text := strings.TrimSpace(result.Text); result.Final && text != "" {
+				lines = append(lines, text)
+			}
+		}
+	}()
+
+	var writeErr error
+	for len(pcm) > 0 {
+		// Write 100ms chunks like a live stream
+		n := 9600
+		if n > len(pcm) {
+			n = len(pcm)
+		}
+		if _, writeErr = stream.Write(pcm[:n]); writeErr != nil {
+			break
+		}
+		pcm = pcm[n:]
+	}
+	closeErr := stream.Close()
+	<-done
+	if writeErr != nil {
+		return "", writeErr
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	return strings.Join(lines, "\n"), nil
+}
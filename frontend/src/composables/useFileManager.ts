@@ -8,6 +8,7 @@ export interface FileGroup {
   text_file?: string
   text?: string
   confidence?: number
+  language?: string
 }
 
 export function useFileManager() {
@@ -32,6 +33,12 @@ export function useFileManager() {
           groups[baseName] = { baseName, modTime, text: baseName }
         }
         
+        // The detected language, or the requested one unless automatic
+        const language = fileInfo.detectedLanguage || (fileInfo.language !== 'auto' ? fileInfo.language : '')
+        if (language) {
+          groups[baseName].language = language
+        }
+
         if (modTime > groups[baseName].modTime) {
           groups[baseName].modTime = modTime
         }
@@ -57,6 +57,23 @@ export function useWebRTC(options?: WebRTCOptions) {
     return msg.answer
   }
 
+  // ICE servers and transport policy of the server, the public STUN server
+  // when unavailable
+  const fetchIceConfig = async (): Promise<RTCConfiguration> => {
+    const fallback = [{ urls: 'stun:stun.l.google.com:19302' }]
+    try {
+      const res = await fetch('/api/ice')
+      if (!res.ok) return { iceServers: fallback }
+      const config = await res.json()
+      return {
+        iceServers: config.iceServers?.length ? config.iceServers : fallback,
+        iceTransportPolicy: config.iceTransportPolicy || 'all'
+      }
+    } catch {
+      return { iceServers: fallback }
+    }
+  }
+
   const decodeDataChannelPayload = async (data: any): Promise<string> => {
     if (data instanceof ArrayBuffer) {
       return new TextDecoder('utf-8').decode(data)
@@ -81,9 +98,7 @@ export function useWebRTC(options?: WebRTCOptions) {
       const constraints = deviceId ? { audio: { deviceId: { exact: deviceId } } } : { audio: true }
       stream = await navigator.mediaDevices.getUserMedia(constraints)
       
-      pc = new RTCPeerConnection({
-        iceServers: [{ urls: 'stun:stun.l.google.com:19302' }]
-      })
+      pc = new RTCPeerConnection(await fetchIceConfig())
 
       const resChan = pc.createDataChannel('results', { ordered: true, protocol: 'tcp' })
       
@@ -0,0 +1,64 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// EmailSink sends the alerts by email through an SMTP server
+type EmailSink struct {
+	addr     string // host:port of the SMTP server
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// NewEmailSink creates a new events.Sink sending mails through the SMTP
+// server at addr, authentication is skipped when username is empty
+func NewEmailSink(addr, username, password, from string, to []string) *EmailSink {
+	return &EmailSink{
+		addr:     addr,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+	}
+}
+
+// Name identifies the sink in the logs and dead letters
+func (n *EmailSink) Name() string {
+	return "alert email"
+}
+
+// Send mails the alert events, the other events are ignored
+func (n *EmailSink) Send(e events.Event) error {
+	if e.Type != EventType {
+		return nil
+	}
+	var a Alert
+	if err := json.Unmarshal(e.Payload, &a); err != nil {
+		return events.Permanent(fmt.Errorf("invalid alert: %w", err))
+	}
+
+	var smtpAuth smtp.Auth
+	if n.username != "" {
+		host, _, err := net.SplitHostPort(n.addr)
+		if err != nil {
+			return events.Permanent(fmt.Errorf("invalid SMTP address: %w", err))
+		}
+		smtpAuth = smtp.PlainAuth("", n.username, n.password, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [webrtc-transcriber] %s error rate alert\r\n\r\n%s\r\n",
+		n.from, strings.Join(n.to, ", "), a.Kind, a)
+	if err := smtp.SendMail(n.addr, smtpAuth, n.from, n.to, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send alert email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// Kinds of errors watched by the Monitor
+const (
+	VendorErrors = "vendor"
+	DecodeErrors = "decode"
+	DiskErrors   = "disk"
+)
+
+// Alert describes an error rate that went over its threshold
+type Alert struct {
+	Kind      string    `json:"kind"`
+	Count     int       `json:"count"`
+	Threshold int       `json:"threshold"`
+	Window    string    `json:"window"`
+	LastError string    `json:"last_error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%d %s errors in the last %s (threshold %d), last error: %s",
+		a.Count, a.Kind, a.Window, a.Threshold, a.LastError)
+}
+
+// EventType is the type of the events published for the alerts, their
+// payload is the JSON encoded Alert
+const EventType = "alert"
+
+// Monitor counts errors per kind over a sliding window and fires the
+// notifiers when a threshold is breached, it then stays quiet for one
+// window before alerting again for the same kind
+type Monitor struct {
+	window     time.Duration
+	thresholds map[string]int
+	publisher  events.Publisher
+	mu         sync.Mutex
+	events     map[string][]time.Time
+	lastErrors map[string]string
+	firedAt    map[string]time.Time
+}
+
+// NewMonitor creates a new Monitor publishing the alerts, a kind without
+// threshold is never alerted
+func NewMonitor(window time.Duration, thresholds map[string]int, publisher events.Publisher) *Monitor {
+	return &Monitor{
+		window:     window,
+		thresholds: thresholds,
+		publisher:  publisher,
+		events:     make(map[string][]time.Time),
+		lastErrors: make(map[string]string),
+		firedAt:    make(map[string]time.Time),
+	}
+}
+
+// ParseThresholds parses a spec such as "vendor=5, decode=100, disk=1"
+func ParseThresholds(spec string) (map[string]int, error) {
+	thresholds := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid threshold %q, expected kind=count", entry)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid threshold count %q", parts[1])
+		}
+		thresholds[strings.TrimSpace(parts[0])] = count
+	}
+	return thresholds, nil
+}
+
+// Record counts one error of the given kind
+func (m *Monitor) Record(kind string, err error) {
+	threshold, watched := m.thresholds[kind]
+	if !watched {
+		return
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	recent := append(m.events[kind], now)
+	// Forget the events that left the window
+	start := 0
+	for start < len(recent) && now.Sub(recent[start]) > m.window {
+		start++
+	}
+	recent = recent[start:]
+	m.events[kind] = recent
+	if err != nil {
+		m.lastErrors[kind] = err.Error()
+	}
+
+	if len(recent) < threshold || now.Sub(m.firedAt[kind]) < m.window {
+		m.mu.Unlock()
+		return
+	}
+	m.firedAt[kind] = now
+	a := Alert{
+		Kind:      kind,
+		Count:     len(recent),
+		Threshold: threshold,
+		Window:    m.window.String(),
+		LastError: m.lastErrors[kind],
+		Time:      now,
+	}
+	m.mu.Unlock()
+
+	log.Printf("Alert: %s", a)
+	payload, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("Error encoding alert: %v", err)
+		return
+	}
+	// Publishing never blocks, errors are recorded on hot paths
+	m.publisher.Publish(events.Event{Type: EventType, Time: now, Payload: payload})
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultMonitor *Monitor
+)
+
+// SetDefault sets the Monitor used by the package level Record function
+func SetDefault(m *Monitor) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultMonitor = m
+}
+
+// Record counts one error of the given kind on the default Monitor,
+// it does nothing when no default Monitor was set
+func Record(kind string, err error) {
+	defaultMu.RLock()
+	m := defaultMonitor
+	defaultMu.RUnlock()
+	if m != nil {
+		m.Record(kind, err)
+	}
+}
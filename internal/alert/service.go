@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Service wraps a transcribe.Service and records the errors of the
+// streams it creates into a Monitor
+type Service struct {
+	next    transcribe.Service
+	monitor *Monitor
+}
+
+type stream struct {
+	transcribe.Stream
+	monitor *Monitor
+}
+
+// NewService creates a new transcribe.Service that reports vendor and
+// disk errors to the monitor
+func NewService(next transcribe.Service, monitor *Monitor) transcribe.Service {
+	return &Service{
+		next:    next,
+		monitor: monitor,
+	}
+}
+
+// CreateStream creates a new monitored stream
+func (s *Service) CreateStream() (transcribe.Stream, error) {
+	return s.wrap(s.next.CreateStream())
+}
+
+// CreateStreamWithOptions creates a new monitored stream with the specified options
+func (s *Service) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	return s.wrap(s.next.CreateStreamWithOptions(opts))
+}
+
+func (s *Service) wrap(next transcribe.Stream, err error) (transcribe.Stream, error) {
+	if err != nil {
+		s.monitor.Record(kindOf(err), err)
+		return nil, err
+	}
+	return &stream{Stream: next, monitor: s.monitor}, nil
+}
+
+// Write passes audio to the underlying stream
+func (st *stream) Write(buffer []byte) (int, error) {
+	n, err := st.Stream.Write(buffer)
+	if err != nil {
+		st.monitor.Record(kindOf(err), err)
+	}
+	return n, err
+}
+
+// Close closes the underlying stream
+func (st *stream) Close() error {
+	err := st.Stream.Close()
+	if err != nil {
+		st.monitor.Record(kindOf(err), err)
+	}
+	return err
+}
+
+// kindOf classifies a stream error, running out of disk space is reported
+// separately from the vendor failures
+func kindOf(err error) string {
+	if errors.Is(err, syscall.ENOSPC) {
+		return DiskErrors
+	}
+	return VendorErrors
+}
@@ -0,0 +1,137 @@
+package ask
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseSize limits the size of the backend responses
+const maxResponseSize = 10 << 20
+
+// Backend embeds texts and answers prompts
+type Backend interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Complete(ctx context.Context, system, prompt string) (string, error)
+	EmbeddingModel() string
+}
+
+// OpenAI is a Backend speaking the OpenAI API, which Ollama, vLLM and
+// most model servers also implement under /v1
+type OpenAI struct {
+	baseURL        string
+	apiKey         string
+	chatModel      string
+	embeddingModel string
+	client         *http.Client
+}
+
+// NewOpenAI creates a new OpenAI backend, e.g. with the base URL
+// https://api.openai.com/v1 or http://localhost:11434/v1 for Ollama, the
+// API key may be empty for local servers
+func NewOpenAI(baseURL, apiKey, chatModel, embeddingModel string) *OpenAI {
+	return &OpenAI{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		apiKey:         apiKey,
+		chatModel:      chatModel,
+		embeddingModel: embeddingModel,
+		client:         &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// EmbeddingModel returns the model of the embeddings, cached embeddings
+// of another model are recomputed
+func (o *OpenAI) EmbeddingModel() string {
+	return o.embeddingModel
+}
+
+// Embed returns the embeddings of the texts, in order
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := o.post(ctx, "/embeddings", map[string]interface{}{
+		"model": o.embeddingModel,
+		"input": texts,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+	embeddings := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("invalid embedding index %d", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
+
+// Complete returns the answer of the chat model to the prompt
+func (o *OpenAI) Complete(ctx context.Context, system, prompt string) (string, error) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err := o.post(ctx, "/chat/completions", map[string]interface{}{
+		"model": o.chatModel,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+		"temperature": 0.2,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("the model returned no answer")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+func (o *OpenAI) post(ctx context.Context, path string, body interface{}, result interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, o.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	resp, err := o.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		if len(data) > 200 {
+			data = data[:200]
+		}
+		return fmt.Errorf("%s returned HTTP status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,159 @@
+package ask
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/catalog"
+)
+
+const (
+	defaultLimit = 8
+	maxLimit     = 20
+	// maxSessions bounds the transcripts searched by a question, the most
+	// recent ones are used when no session is given
+	maxSessions = 200
+)
+
+// systemPrompt instructs the model to answer from the excerpts only and to
+// cite them by number
+const systemPrompt = `You answer questions about meeting and call transcripts.
+Use only the numbered excerpts given with the question. Cite the excerpts you
+rely on with their number in brackets, e.g. [2]. If the excerpts do not
+contain the answer, say so.`
+
+// request is the JSON body of a question
+type request struct {
+	Question string   `json:"question"`
+	Sessions []string `json:"sessions,omitempty"` // Restricts the search, all visible sessions when empty
+	Language string   `json:"language,omitempty"` // Restricts the search to the sessions in the language
+	Limit    int      `json:"limit,omitempty"`    // Number of excerpts given to the model
+}
+
+// Citation is an excerpt the answer was based on
+type Citation struct {
+	Number    int        `json:"number"`
+	Session   string     `json:"session"`
+	Segment   int        `json:"segment"`
+	Offset    float64    `json:"offset_seconds"` // Estimated from the transcript position
+	Timestamp string     `json:"timestamp"`      // Offset as [h:]mm:ss
+	Time      *time.Time `json:"time,omitempty"` // Start of the session plus the offset
+	Text      string     `json:"text"`
+	Score     float64    `json:"score"`
+}
+
+// response is the answer to a question
+type response struct {
+	Answer    string     `json:"answer"`
+	Citations []Citation `json:"citations"`
+}
+
+// MakeHandler returns an HTTP handler answering a question (POST) from the
+// transcripts visible to the user, users see their sessions and the ones
+// without owner, admins see every session
+func MakeHandler(c *catalog.Catalog, index *Index, backend Backend, isAdmin func(string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		req.Question = strings.TrimSpace(req.Question)
+		if req.Question == "" {
+			http.Error(w, "question is required", http.StatusBadRequest)
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = defaultLimit
+		}
+		if req.Limit > maxLimit {
+			req.Limit = maxLimit
+		}
+
+		user := auth.UserFromContext(r.Context())
+		all, err := c.Sessions()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wanted := make(map[string]bool)
+		for _, id := range req.Sessions {
+			wanted[id] = true
+		}
+		var sessions []*catalog.Session
+		for _, s := range all {
+			if s.Transcript == nil || (s.User != "" && s.User != user && !isAdmin(user)) {
+				continue
+			}
+			if len(wanted) > 0 && !wanted[s.ID] || req.Language != "" && !s.HasLanguage(req.Language) {
+				continue
+			}
+			sessions = append(sessions, s)
+			if len(sessions) == maxSessions {
+				break
+			}
+		}
+		if len(sessions) == 0 {
+			http.Error(w, "No transcript to search", http.StatusNotFound)
+			return
+		}
+
+		matches, err := index.search(r.Context(), sessions, req.Question, req.Limit)
+		if err != nil {
+			log.Printf("Error searching transcripts: %v", err)
+			http.Error(w, "Search failed", http.StatusBadGateway)
+			return
+		}
+		citations := make([]Citation, 0, len(matches))
+		var prompt strings.Builder
+		for i, m := range matches {
+			citation := Citation{
+				Number:    i + 1,
+				Session:   m.session.ID,
+				Segment:   m.chunk.Segment,
+				Offset:    m.chunk.Offset,
+				Text:      m.chunk.Text,
+				Score:     m.score,
+				Timestamp: formatOffset(m.chunk.Offset),
+			}
+			if !m.session.StartedAt.IsZero() {
+				t := m.session.StartedAt.Add(time.Duration(m.chunk.Offset) * time.Second)
+				citation.Time = &t
+			}
+			citations = append(citations, citation)
+			fmt.Fprintf(&prompt, "[%d] Session %s at %s", citation.Number, citation.Session, citation.Timestamp)
+			if citation.Time != nil {
+				fmt.Fprintf(&prompt, " (%s)", citation.Time.Format("2006-01-02 15:04"))
+			}
+			fmt.Fprintf(&prompt, ":\n%s\n\n", citation.Text)
+		}
+		fmt.Fprintf(&prompt, "Question: %s", req.Question)
+
+		answer, err := backend.Complete(r.Context(), systemPrompt, prompt.String())
+		if err != nil {
+			log.Printf("Error answering question: %v", err)
+			http.Error(w, "The language model failed to answer", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response{Answer: answer, Citations: citations})
+	})
+}
+
+// formatOffset formats seconds as mm:ss, or h:mm:ss past an hour
+func formatOffset(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
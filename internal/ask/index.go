@@ -0,0 +1,218 @@
+package ask
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/catalog"
+)
+
+const (
+	// chunkSize is the approximate number of characters of the retrieved
+	// chunks, consecutive segments are grouped up to this size
+	chunkSize = 600
+	// embedBatchSize is the number of chunks embedded per request
+	embedBatchSize = 64
+	// indexDir holds the cached embeddings, next to the session metadata
+	indexDir = ".meta/embeddings"
+)
+
+// Chunk is a group of consecutive segments of a transcript
+type Chunk struct {
+	Segment   int       `json:"segment"`        // Index of the first segment, from 1
+	Offset    float64   `json:"offset_seconds"` // Estimated position in the recording
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding,omitempty"` // Normalized
+}
+
+// sessionIndex is the cached embeddings file of a transcript
+type sessionIndex struct {
+	Model   string    `json:"model"`
+	Size    int64     `json:"size"`     // Size of the transcript when it was embedded
+	ModTime time.Time `json:"mod_time"` // Modification of the transcript when it was embedded
+	Chunks  []Chunk   `json:"chunks"`
+}
+
+// Index embeds the transcripts of the catalog on demand and caches the
+// embeddings in the output directory
+type Index struct {
+	dir     string
+	catalog *catalog.Catalog
+	backend Backend
+	mu      sync.Mutex
+}
+
+// NewIndex creates a new Index of the transcripts of the output directory
+func NewIndex(dir string, c *catalog.Catalog, backend Backend) *Index {
+	return &Index{
+		dir:     dir,
+		catalog: c,
+		backend: backend,
+	}
+}
+
+// chunks returns the embedded chunks of a session, embedding its
+// transcript when it changed since it was cached
+func (x *Index) chunks(ctx context.Context, session *catalog.Session) ([]Chunk, error) {
+	if session.Transcript == nil {
+		return nil, nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	path := filepath.Join(x.dir, indexDir, session.ID+".json")
+	var cached sessionIndex
+	if data, err := ioutil.ReadFile(path); err == nil && json.Unmarshal(data, &cached) == nil {
+		if cached.Model == x.backend.EmbeddingModel() && cached.Size == session.Transcript.Size &&
+			cached.ModTime.Equal(session.Transcript.ModTime) {
+			return cached.Chunks, nil
+		}
+	}
+
+	text, err := x.catalog.Text(session)
+	if err != nil {
+		return nil, err
+	}
+	chunks := split(catalog.Segments(text), session.Duration)
+	for start := 0; start < len(chunks); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		texts := make([]string, 0, end-start)
+		for _, c := range chunks[start:end] {
+			texts = append(texts, c.Text)
+		}
+		embeddings, err := x.backend.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed transcript %s: %w", session.ID, err)
+		}
+		for i, e := range embeddings {
+			chunks[start+i].Embedding = normalize(e)
+		}
+	}
+
+	data, err := json.Marshal(sessionIndex{
+		Model:   x.backend.EmbeddingModel(),
+		Size:    session.Transcript.Size,
+		ModTime: session.Transcript.ModTime,
+		Chunks:  chunks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to cache embeddings: %w", err)
+	}
+	return chunks, nil
+}
+
+// match is a chunk retrieved for a question
+type match struct {
+	session *catalog.Session
+	chunk   Chunk
+	score   float64
+}
+
+// search returns the limit chunks of the sessions closest to the question
+func (x *Index) search(ctx context.Context, sessions []*catalog.Session, question string, limit int) ([]match, error) {
+	embeddings, err := x.backend.Embed(ctx, []string{question})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed the question: %w", err)
+	}
+	query := normalize(embeddings[0])
+
+	var matches []match
+	for _, session := range sessions {
+		chunks, err := x.chunks(ctx, session)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			matches = append(matches, match{
+				session: session,
+				chunk:   chunk,
+				score:   dot(query, chunk.Embedding),
+			})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// split groups the segments in chunks of about chunkSize characters. The
+// transcripts carry no timing, the offsets are estimated from the position
+// of the text and the duration of the recording
+func split(segments []string, duration float64) []Chunk {
+	total := 0
+	for _, s := range segments {
+		total += len(s) + 1
+	}
+	var chunks []Chunk
+	var current []string
+	start, position, length, currentLength := 0, 0, 0, 0
+	for i, s := range segments {
+		if len(current) == 0 {
+			start = i
+			position = length
+			currentLength = 0
+		}
+		current = append(current, s)
+		length += len(s) + 1
+		currentLength += len(s) + 1
+		if currentLength >= chunkSize || i == len(segments)-1 {
+			chunk := Chunk{Segment: start + 1, Text: strings.Join(current, "\n")}
+			if total > 0 {
+				chunk.Offset = math.Round(duration * float64(position) / float64(total))
+			}
+			chunks = append(chunks, chunk)
+			current = nil
+		}
+	}
+	return chunks
+}
+
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return v
+	}
+	norm = math.Sqrt(norm)
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+// dot is the cosine similarity of normalized vectors
+func dot(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
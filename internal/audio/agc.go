@@ -0,0 +1,104 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Settings of the AGC
+const (
+	agcBlock   = 10 * time.Millisecond  // Audio of a level measurement
+	agcGate    = -50                    // dBFS of the quietest speech, the quieter blocks keep the gain
+	agcLimit   = 0.9                    // Peak of the amplified samples, relative to full scale
+	agcLevel   = 300 * time.Millisecond // Time constant of the speech level
+	agcAttack  = 10 * time.Millisecond  // Time constant of the gain reductions
+	agcRelease = time.Second            // Time constant of the gain increases
+)
+
+// AGC normalizes the loudness of little-endian 16-bit mono PCM: the level of
+// the speech is measured over blocks of 10ms and the gain brings it to the
+// target level, quickly down and slowly up so that the pauses are not
+// amplified. A limiter keeps the peaks from clipping. The output has as many
+// samples as the input, without latency
+type AGC struct {
+	block   int     // Samples per block
+	target  float64 // RMS of the normalized speech, relative to full scale
+	maxGain float64
+	gate    float64 // Mean square of the quietest speech
+
+	levelCoef   float64 // Weight of a block in the speech level
+	attackCoef  float64 // Weight per sample of the lower gains
+	releaseCoef float64 // Weight per sample of the higher gains
+
+	pending []byte  // Incomplete sample
+	sum     float64 // Energy of the current block
+	count   int     // Samples of the current block
+	level   float64 // Mean square of the speech, 0 before the first words
+	desired float64 // Gain bringing the speech to the target
+	gain    float64 // Gain applied to the samples
+}
+
+// NewAGC creates an AGC of the PCM at sampleRate normalizing the speech to
+// targetDB (dBFS, e.g. -20), amplifying it by up to maxGainDB
+func NewAGC(sampleRate int, targetDB, maxGainDB float64) *AGC {
+	coef := func(d time.Duration) float64 {
+		return 1 - math.Exp(-1/(d.Seconds()*float64(sampleRate)))
+	}
+	return &AGC{
+		block:       int(int64(sampleRate) * int64(agcBlock) / int64(time.Second)),
+		target:      math.Pow(10, targetDB/20),
+		maxGain:     math.Pow(10, maxGainDB/20),
+		gate:        math.Pow(10, agcGate/10.0),
+		levelCoef:   1 - math.Exp(-agcBlock.Seconds()/agcLevel.Seconds()),
+		attackCoef:  coef(agcAttack),
+		releaseCoef: coef(agcRelease),
+		desired:     1,
+		gain:        1,
+	}
+}
+
+// Process returns the normalized PCM of the complete samples
+func (a *AGC) Process(pcm []byte) []byte {
+	data := append(a.pending, pcm...)
+	n := len(data) / 2
+	out := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		x := float64(int16(binary.LittleEndian.Uint16(data[2*i:]))) / 32768
+
+		if a.desired < a.gain {
+			a.gain += a.attackCoef * (a.desired - a.gain)
+		} else {
+			a.gain += a.releaseCoef * (a.desired - a.gain)
+		}
+		y := x * a.gain
+		if math.Abs(y) > agcLimit {
+			a.gain = agcLimit / math.Abs(x)
+			y = math.Copysign(agcLimit, x)
+		}
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(int16(math.Round(y*32768))))
+
+		a.sum += x * x
+		a.count++
+		if a.count == a.block {
+			a.measure(a.sum / float64(a.count))
+			a.sum, a.count = 0, 0
+		}
+	}
+	a.pending = append([]byte(nil), data[2*n:]...)
+	return out
+}
+
+// measure updates the speech level and the desired gain with the mean
+// square of a block
+func (a *AGC) measure(power float64) {
+	if power < a.gate {
+		return
+	}
+	if a.level == 0 {
+		a.level = power
+	} else {
+		a.level += a.levelCoef * (power - a.level)
+	}
+	a.desired = math.Min(a.target/math.Sqrt(a.level), a.maxGain)
+}
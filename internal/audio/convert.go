@@ -0,0 +1,70 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Converter converts little-endian 16-bit mono PCM to another sample rate,
+// dividing the input rate, bit depth and channel count, e.g. to record the
+// audio of the pipeline in the format of the archive
+type Converter struct {
+	downsampler *Downsampler
+	bits        int
+	channels    int
+}
+
+// NewConverter creates a Converter of the PCM at inRate to outRate, 8, 16,
+// 24 or 32-bit samples, on 1 or 2 channels. The 8-bit samples are unsigned,
+// the mono audio is copied to both channels
+func NewConverter(inRate, outRate, bits, channels int) (*Converter, error) {
+	if outRate <= 0 || outRate > inRate || inRate%outRate != 0 {
+		return nil, fmt.Errorf("unsupported sample rate %d, it must divide %d", outRate, inRate)
+	}
+	switch bits {
+	case 8, 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("unsupported bit depth %d, expected 8, 16, 24 or 32", bits)
+	}
+	if channels != 1 && channels != 2 {
+		return nil, fmt.Errorf("unsupported channel count %d, expected 1 or 2", channels)
+	}
+	c := &Converter{bits: bits, channels: channels}
+	if outRate != inRate {
+		c.downsampler = NewDownsampler(inRate, outRate)
+	}
+	return c, nil
+}
+
+// Process converts the PCM, the incomplete samples are kept for the next
+// call
+func (c *Converter) Process(pcm []byte) []byte {
+	if c.downsampler != nil {
+		pcm = c.downsampler.Process(pcm)
+	}
+	if c.bits == 16 && c.channels == 1 {
+		return pcm
+	}
+	n := len(pcm) / 2
+	width := c.bits / 8
+	out := make([]byte, n*width*c.channels)
+	ix := 0
+	for i := 0; i < n; i++ {
+		s := int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+		for ch := 0; ch < c.channels; ch++ {
+			switch c.bits {
+			case 8:
+				out[ix] = byte(int(s)>>8 + 128)
+			case 16:
+				binary.LittleEndian.PutUint16(out[ix:], uint16(s))
+			case 24:
+				v := int32(s) << 8
+				out[ix], out[ix+1], out[ix+2] = byte(v), byte(v>>8), byte(v>>16)
+			case 32:
+				binary.LittleEndian.PutUint32(out[ix:], uint32(int32(s)<<16))
+			}
+			ix += width
+		}
+	}
+	return out
+}
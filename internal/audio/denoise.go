@@ -0,0 +1,209 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+)
+
+// Settings of the noise estimation of the Denoiser
+const (
+	denoiseInitFrames = 10    // Frames averaged into the first noise estimate
+	denoiseSpeech     = 4.0   // Power over the noise estimate of the speech bins
+	denoiseAdapt      = 0.05  // Weight of the new power of the noise bins
+	denoiseRise       = 1.005 // Growth per frame of the noise under the speech bins
+	denoiseSmoothing  = 0.98  // Weight of the previous frame in the a priori SNR
+)
+
+// Denoiser attenuates the stationary noise of little-endian 16-bit mono PCM,
+// e.g. the fan and the hiss of laptop microphones, with a Wiener filter on
+// the spectrum of overlapping frames of about 20ms. The noise of each
+// frequency is estimated from the frames without speech. The output has as
+// many samples as the input, Flush returns the last ones
+type Denoiser struct {
+	size   int       // Samples per frame, a power of 2
+	hop    int       // Samples between two frames
+	window []float64 // Square root of a periodic Hann window
+	floor  float64   // Minimum gain of a frequency
+
+	pending []byte    // Incomplete sample
+	input   []float64 // Samples not processed yet, with the overlap
+	output  []float64 // Overlap-add of the processed frames
+	skip    int       // Output samples of the leading padding to drop
+	in, out int64     // Samples received and returned
+
+	frames int
+	noise  []float64 // Noise power per frequency
+	gain   []float64 // Gain of the last frame per frequency
+	snr    []float64 // A posteriori SNR of the last frame per frequency
+}
+
+// NewDenoiser creates a Denoiser of the PCM at sampleRate, attenuating the
+// noise by up to reductionDB (e.g. 20)
+func NewDenoiser(sampleRate int, reductionDB float64) *Denoiser {
+	size := 1
+	for size < sampleRate/50 {
+		size <<= 1
+	}
+	hop := size / 2
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size)))
+	}
+	bins := size/2 + 1
+	gain := make([]float64, bins)
+	for i := range gain {
+		gain[i] = 1
+	}
+	return &Denoiser{
+		size:   size,
+		hop:    hop,
+		window: window,
+		floor:  math.Pow(10, -reductionDB/20),
+		// The leading padding aligns the output with the input
+		input:  make([]float64, size-hop),
+		output: make([]float64, size),
+		skip:   size - hop,
+		noise:  make([]float64, bins),
+		gain:   gain,
+		snr:    make([]float64, bins),
+	}
+}
+
+// Process returns the denoised PCM of the frames completed by pcm
+func (d *Denoiser) Process(pcm []byte) []byte {
+	data := append(d.pending, pcm...)
+	n := len(data) / 2
+	for i := 0; i < n; i++ {
+		d.input = append(d.input, float64(int16(binary.LittleEndian.Uint16(data[2*i:])))/32768)
+	}
+	d.pending = append([]byte(nil), data[2*n:]...)
+	d.in += int64(n)
+
+	var out []byte
+	for len(d.input) >= d.size {
+		out = d.frame(out)
+	}
+	return out
+}
+
+// Flush returns the denoised PCM of the samples left, padded with silence to
+// complete their frames
+func (d *Denoiser) Flush() []byte {
+	var out []byte
+	for d.out < d.in {
+		for len(d.input) < d.size {
+			d.input = append(d.input, 0)
+		}
+		out = d.frame(out)
+	}
+	return out
+}
+
+// frame filters the first frame of the input and appends the samples it
+// completes to out
+func (d *Denoiser) frame(out []byte) []byte {
+	spectrum := make([]complex128, d.size)
+	for i := range spectrum {
+		spectrum[i] = complex(d.input[i]*d.window[i], 0)
+	}
+	fft(spectrum, false)
+	d.filter(spectrum)
+	fft(spectrum, true)
+
+	for i := range spectrum {
+		d.output[i] += real(spectrum[i]) * d.window[i]
+	}
+	for i := 0; i < d.hop; i++ {
+		if d.skip > 0 {
+			d.skip--
+			continue
+		}
+		if d.out >= d.in {
+			break
+		}
+		s := d.output[i] * 32768
+		if s > math.MaxInt16 {
+			s = math.MaxInt16
+		} else if s < math.MinInt16 {
+			s = math.MinInt16
+		}
+		var sample [2]byte
+		binary.LittleEndian.PutUint16(sample[:], uint16(int16(math.Round(s))))
+		out = append(out, sample[:]...)
+		d.out++
+	}
+	copy(d.output, d.output[d.hop:])
+	for i := d.size - d.hop; i < d.size; i++ {
+		d.output[i] = 0
+	}
+	d.input = append(d.input[:0], d.input[d.hop:]...)
+	return out
+}
+
+// filter updates the noise estimate with the spectrum of a frame and applies
+// the gains of the decision-directed Wiener filter to it
+func (d *Denoiser) filter(spectrum []complex128) {
+	d.frames++
+	for k := range d.noise {
+		power := real(spectrum[k])*real(spectrum[k]) + imag(spectrum[k])*imag(spectrum[k])
+		switch {
+		case d.frames <= denoiseInitFrames:
+			d.noise[k] += (power - d.noise[k]) / float64(d.frames)
+		case power < denoiseSpeech*d.noise[k]:
+			d.noise[k] += denoiseAdapt * (power - d.noise[k])
+		default:
+			d.noise[k] *= denoiseRise
+		}
+		if d.noise[k] <= 0 {
+			continue
+		}
+
+		snr := power / d.noise[k]
+		prior := denoiseSmoothing*d.gain[k]*d.gain[k]*d.snr[k] + (1-denoiseSmoothing)*math.Max(snr-1, 0)
+		gain := math.Max(prior/(1+prior), d.floor)
+		d.gain[k], d.snr[k] = gain, snr
+
+		spectrum[k] *= complex(gain, 0)
+		if k > 0 && k < d.size/2 {
+			// The negative frequencies mirror the positive ones
+			spectrum[d.size-k] = cmplx.Conj(spectrum[k])
+		}
+	}
+}
+
+// fft transforms the samples in place, their number is a power of 2. The
+// inverse transform is scaled by 1/n
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for length := 2; length <= n; length <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(length))
+		for start := 0; start < n; start += length {
+			w := complex(1, 0)
+			for i := 0; i < length/2; i++ {
+				a, b := x[start+i], x[start+i+length/2]*w
+				x[start+i], x[start+i+length/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// fileExtensions are the audio files DecodeFile is expected to read
+var fileExtensions = map[string]bool{
+	".wav": true, ".mp3": true, ".m4a": true, ".aac": true, ".ogg": true,
+	".opus": true, ".flac": true, ".webm": true, ".mp4": true,
+}
+
+// IsAudioFile reports whether the name has the extension of an audio file
+func IsAudioFile(name string) bool {
+	return fileExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// DecodeFile returns the audio of a file as 16-bit mono PCM at the sample
+// rate. WAV files are decoded natively when their rate divides it, anything
+// else is decoded with the ffmpeg executable
+func DecodeFile(path, ffmpegPath string, sampleRate int) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		samples, rate, err := DecodeWAV(data)
+		if err == nil && rate > 0 && rate <= sampleRate && sampleRate%rate == 0 {
+			return NewUpsampler(rate, sampleRate).Process(samples), nil
+		}
+	}
+
+	cmd := exec.Command(ffmpegPath,
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-i", path,
+		"-vn", "-f", "s16le", "-acodec", "pcm_s16le",
+		"-ac", "1", "-ar", fmt.Sprint(sampleRate),
+		"pipe:1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffmpeg failed to decode the file: %s", msg)
+		}
+		return nil, fmt.Errorf("ffmpeg failed to decode the file: %w", err)
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("the file has no audio")
+	}
+	return stdout.Bytes(), nil
+}
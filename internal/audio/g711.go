@@ -0,0 +1,50 @@
+package audio
+
+// G.711 decoding to 16-bit linear PCM (ITU-T G.711)
+
+// DecodeUlaw decodes μ-law (PCMU) samples
+func DecodeUlaw(payload []byte) []int16 {
+	samples := make([]int16, len(payload))
+	for i, b := range payload {
+		samples[i] = ulawToLinear(b)
+	}
+	return samples
+}
+
+// DecodeAlaw decodes A-law (PCMA) samples
+func DecodeAlaw(payload []byte) []int16 {
+	samples := make([]int16, len(payload))
+	for i, b := range payload {
+		samples[i] = alawToLinear(b)
+	}
+	return samples
+}
+
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0f) << 3) + 0x84
+	t <<= uint(u&0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0f) << 4
+	seg := uint(a&0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
@@ -0,0 +1,208 @@
+// Package ogg writes Opus packets to Ogg files as they are received, without
+// decoding them (RFC 3533 and RFC 7845)
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// SampleRate is the rate of the granule positions of the Opus streams
+const SampleRate = 48000
+
+// pageSize is the audio buffered in a page before it is written, about a
+// second of speech, so that the players can seek in the file
+const pageSize = 4096
+
+// maxSegments is the size of the segment table of a page
+const maxSegments = 255
+
+// Flags of the pages
+const (
+	flagBeginning = 0x02
+	flagEnd       = 0x04
+)
+
+// crcTable is the table of the CRC-32 of the pages, polynomial 0x04c11db7
+// without reflection
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func checksum(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// Writer writes the Opus packets of a stream to an Ogg file. The packets are
+// buffered in pages of about a second, Close writes the last one
+type Writer struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+
+	segments []byte // Segment table of the buffered page
+	data     []byte // Packets of the buffered page
+	granule  uint64 // Position at the end of the last buffered packet
+	packets  int64
+	closed   bool
+}
+
+// NewWriter writes the Opus headers of a stream of channels to w and
+// returns a Writer appending the packets after them
+func NewWriter(w io.Writer, channels int) (*Writer, error) {
+	if channels < 1 || channels > 2 {
+		return nil, fmt.Errorf("invalid channel count: %d", channels)
+	}
+	wr := &Writer{w: w, serial: rand.Uint32()}
+
+	head := make([]byte, 19)
+	copy(head[0:8], "OpusHead")
+	head[8] = 1 // Version
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:12], 0) // Pre-skip, the stream joins the encoder midway
+	binary.LittleEndian.PutUint32(head[12:16], SampleRate)
+	binary.LittleEndian.PutUint16(head[16:18], 0) // Output gain
+	head[18] = 0                                  // Mono or stereo mapping
+
+	vendor := "webrtc-transcriber"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags[0:8], "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:12], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	// No user comment
+
+	// The headers are on their own pages with a granule position of 0
+	if err := wr.writePage(flagBeginning, 0, lacing(len(head)), head); err != nil {
+		return nil, fmt.Errorf("failed to write the Opus header: %w", err)
+	}
+	if err := wr.writePage(0, 0, lacing(len(tags)), tags); err != nil {
+		return nil, fmt.Errorf("failed to write the Opus tags: %w", err)
+	}
+	return wr, nil
+}
+
+// Packets returns the number of packets written so far
+func (wr *Writer) Packets() int64 {
+	return wr.packets
+}
+
+// WritePacket appends an Opus packet ending at granule, the number of 48kHz
+// samples from the start of the stream
+func (wr *Writer) WritePacket(packet []byte, granule uint64) error {
+	if wr.closed {
+		return errors.New("Ogg stream is closed")
+	}
+	if len(packet) == 0 {
+		return nil
+	}
+	segments := lacing(len(packet))
+	if len(wr.segments)+len(segments) > maxSegments {
+		if err := wr.flush(0); err != nil {
+			return err
+		}
+	}
+	wr.segments = append(wr.segments, segments...)
+	wr.data = append(wr.data, packet...)
+	if granule > wr.granule {
+		wr.granule = granule
+	}
+	wr.packets++
+	if len(wr.data) >= pageSize {
+		return wr.flush(0)
+	}
+	return nil
+}
+
+// Close writes the buffered packets on the last page of the stream. The
+// underlying writer is left open
+func (wr *Writer) Close() error {
+	if wr.closed {
+		return nil
+	}
+	wr.closed = true
+	return wr.flush(flagEnd)
+}
+
+// flush writes the buffered packets on a page
+func (wr *Writer) flush(flags byte) error {
+	if len(wr.segments) == 0 && flags&flagEnd == 0 {
+		return nil
+	}
+	err := wr.writePage(flags, wr.granule, wr.segments, wr.data)
+	wr.segments = wr.segments[:0]
+	wr.data = wr.data[:0]
+	return err
+}
+
+func (wr *Writer) writePage(flags byte, granule uint64, segments, data []byte) error {
+	page := make([]byte, 27+len(segments)+len(data))
+	copy(page[0:4], "OggS")
+	page[4] = 0 // Version
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:14], granule)
+	binary.LittleEndian.PutUint32(page[14:18], wr.serial)
+	binary.LittleEndian.PutUint32(page[18:22], wr.sequence)
+	page[26] = byte(len(segments))
+	copy(page[27:], segments)
+	copy(page[27+len(segments):], data)
+	binary.LittleEndian.PutUint32(page[22:26], checksum(page))
+	wr.sequence++
+	_, err := wr.w.Write(page)
+	return err
+}
+
+// lacing returns the segment table of a packet of size bytes
+func lacing(size int) []byte {
+	segments := make([]byte, 0, size/255+1)
+	for ; size >= 255; size -= 255 {
+		segments = append(segments, 255)
+	}
+	return append(segments, byte(size))
+}
+
+// Samples returns the duration of an Opus packet in 48kHz samples, from its
+// TOC byte (RFC 6716 section 3.1), 0 when the packet is invalid
+func Samples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := packet[0] >> 3
+	var frame int
+	switch {
+	case config < 12: // SILK 10, 20, 40 or 60 ms
+		frame = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid 10 or 20 ms
+		frame = []int{480, 960}[config%2]
+	default: // CELT 2.5, 5, 10 or 20 ms
+		frame = []int{120, 240, 480, 960}[config%4]
+	}
+	switch packet[0] & 0x03 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	}
+	if len(packet) < 2 {
+		return 0
+	}
+	return int(packet[1]&0x3f) * frame
+}
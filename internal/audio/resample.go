@@ -0,0 +1,69 @@
+package audio
+
+import (
+	"encoding/binary"
+)
+
+// Upsampler converts mono PCM to a higher rate that is an integer multiple
+// of the input rate using linear interpolation, it keeps the last sample
+// between calls so that consecutive packets join smoothly
+type Upsampler struct {
+	factor int
+	last   int16
+}
+
+// NewUpsampler creates an Upsampler from inRate to outRate
+func NewUpsampler(inRate, outRate int) *Upsampler {
+	factor := outRate / inRate
+	if factor < 1 {
+		factor = 1
+	}
+	return &Upsampler{factor: factor}
+}
+
+// Process upsamples the samples and returns them as little-endian 16-bit PCM
+func (u *Upsampler) Process(samples []int16) []byte {
+	out := make([]byte, 0, len(samples)*u.factor*2)
+	for _, s := range samples {
+		for i := 1; i <= u.factor; i++ {
+			v := int(u.last) + (int(s)-int(u.last))*i/u.factor
+			out = binary.LittleEndian.AppendUint16(out, uint16(int16(v)))
+		}
+		u.last = s
+	}
+	return out
+}
+
+// Downsampler converts little-endian 16-bit mono PCM to a lower rate that
+// divides the input rate by averaging the samples, it keeps the incomplete
+// groups of samples between calls
+type Downsampler struct {
+	factor  int
+	pending []byte
+}
+
+// NewDownsampler creates a Downsampler from inRate to outRate
+func NewDownsampler(inRate, outRate int) *Downsampler {
+	factor := inRate / outRate
+	if factor < 1 {
+		factor = 1
+	}
+	return &Downsampler{factor: factor}
+}
+
+// Process downsamples the PCM and returns it as little-endian 16-bit PCM
+func (d *Downsampler) Process(pcm []byte) []byte {
+	data := append(d.pending, pcm...)
+	group := d.factor * 2
+	n := len(data) / group
+	out := make([]byte, 0, n*2)
+	for i := 0; i < n; i++ {
+		sum := 0
+		for j := 0; j < d.factor; j++ {
+			sum += int(int16(binary.LittleEndian.Uint16(data[i*group+j*2:])))
+		}
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(sum/d.factor)))
+	}
+	d.pending = append([]byte(nil), data[n*group:]...)
+	return out
+}
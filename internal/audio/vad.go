@@ -0,0 +1,66 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// VADFrame is the duration of the frames the VAD classifies
+const VADFrame = 20 * time.Millisecond
+
+// VAD detects the speech of little-endian 16-bit mono PCM from the energy of
+// its frames, it keeps the incomplete frames between calls
+type VAD struct {
+	frame     int     // Bytes per frame
+	threshold float64 // RMS of the speech frames, relative to full scale
+	pending   []byte
+}
+
+// NewVAD creates a VAD for the PCM at sampleRate, the frames louder than
+// thresholdDB (dBFS, e.g. -40) are speech
+func NewVAD(sampleRate int, thresholdDB float64) *VAD {
+	return &VAD{
+		frame:     int(int64(sampleRate)*int64(VADFrame)/int64(time.Second)) * 2,
+		threshold: math.Pow(10, thresholdDB/20),
+	}
+}
+
+// Process returns whether each complete frame of the PCM is speech
+func (v *VAD) Process(pcm []byte) []bool {
+	v.pending = append(v.pending, pcm...)
+	var frames []bool
+	for len(v.pending) >= v.frame {
+		frames = append(frames, rms(v.pending[:v.frame]) >= v.threshold)
+		v.pending = v.pending[v.frame:]
+	}
+	// Do not keep the whole history alive through the slice
+	v.pending = append([]byte(nil), v.pending...)
+	return frames
+}
+
+// rms returns the root mean square of the PCM, relative to full scale
+func rms(pcm []byte) float64 {
+	var sum float64
+	n := len(pcm) / 2
+	for i := 0; i < n; i++ {
+		s := float64(int16(binary.LittleEndian.Uint16(pcm[2*i:]))) / 32768
+		sum += s * s
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Sqrt(sum / float64(n))
+}
+
+// Levels returns the root mean square and the peak of the PCM, relative to
+// full scale
+func Levels(pcm []byte) (float64, float64) {
+	var peak float64
+	for i := 0; i+1 < len(pcm); i += 2 {
+		if s := math.Abs(float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) / 32768); s > peak {
+			peak = s
+		}
+	}
+	return rms(pcm), peak
+}
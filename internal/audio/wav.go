@@ -0,0 +1,67 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// DecodeWAV decodes a 16-bit PCM WAV file, stereo is downmixed to mono.
+// It returns the samples and their rate
+func DecodeWAV(data []byte) ([]int16, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var channels, bitsPerSample, sampleRate int
+	var format uint16
+	data = data[12:]
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) || size < 0 {
+			// Streamed files may leave the size of the last chunk unset
+			size = len(data)
+		}
+		chunk := data[:size]
+
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, errors.New("invalid WAV fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			// WAVE_FORMAT_EXTENSIBLE carries the format in its sub format
+			if format == 0xfffe && len(chunk) >= 26 {
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+		case "data":
+			if channels == 0 {
+				return nil, 0, errors.New("WAV data before the fmt chunk")
+			}
+			if format != 1 || bitsPerSample != 16 || channels > 2 {
+				return nil, 0, fmt.Errorf("unsupported WAV format %d with %d bits and %d channels, 16-bit PCM is required", format, bitsPerSample, channels)
+			}
+			frameSize := 2 * channels
+			samples := make([]int16, len(chunk)/frameSize)
+			for i := range samples {
+				sample := int(int16(binary.LittleEndian.Uint16(chunk[i*frameSize:])))
+				if channels == 2 {
+					sample = (sample + int(int16(binary.LittleEndian.Uint16(chunk[i*frameSize+2:])))) / 2
+				}
+				samples[i] = int16(sample)
+			}
+			return samples, sampleRate, nil
+		}
+		// Chunks are padded to an even size
+		data = data[size:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:]
+		}
+	}
+	return nil, 0, errors.New("WAV file without data chunk")
+}
@@ -0,0 +1,90 @@
+package wav
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+)
+
+// bufferSize holds about a second of 48kHz 16-bit mono audio
+const bufferSize = 96 * 1024
+
+// File buffers the audio written to a WAV file and flushes it to the disk
+// every interval instead of on every write. An interrupted file loses at
+// most the audio of the last interval, its header keeps the empty sizes
+// until the file is finalized so that it can be repaired
+type File struct {
+	file     *os.File
+	buf      *bufio.Writer
+	interval time.Duration
+	synced   time.Time
+}
+
+// NewFile wraps a file created for writing, the buffered audio is synced to
+// the disk every interval, on every write when 0
+func NewFile(file *os.File, interval time.Duration) *File {
+	return &File{
+		file:     file,
+		buf:      bufio.NewWriterSize(file, bufferSize),
+		interval: interval,
+		synced:   time.Now(),
+	}
+}
+
+// Write buffers p, the buffer is synced once the interval elapsed
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.buf.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if time.Since(f.synced) >= f.interval {
+		err = f.Sync()
+	}
+	return n, err
+}
+
+// Seek flushes the buffer and sets the offset of the next write
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.buf.Flush(); err != nil {
+		return 0, err
+	}
+	return f.file.Seek(offset, whence)
+}
+
+// Sync writes the buffered audio and commits the file to the disk
+func (f *File) Sync() error {
+	if err := f.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush the audio: %w", err)
+	}
+	f.synced = time.Now()
+	return f.file.Sync()
+}
+
+// Finalize completes the header of the Writer once the audio is on the
+// disk, so that a crash leaves either empty sizes, which the recovery
+// repairs, or the final ones, then closes the file
+func (f *File) Finalize(wr *Writer) error {
+	if err := f.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := wr.Finalize(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("failed to sync the header: %w", err)
+	}
+	return f.file.Close()
+}
+
+// Close writes the buffered audio and closes the file without syncing it
+func (f *File) Close() error {
+	err := f.buf.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
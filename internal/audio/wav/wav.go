@@ -0,0 +1,167 @@
+// Package wav writes PCM audio to WAV files as it is received, the sizes of
+// the header are filled in when the stream is finalized
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// HeaderSize is the size of the canonical header written by the Writer,
+// the audio data starts right after it
+const HeaderSize = 44
+
+// Offsets of the size fields of the canonical header
+const (
+	riffSizeOffset = 4
+	dataSizeOffset = 40
+)
+
+// Format describes the PCM samples of a file
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// BlockAlign returns the size of a frame, one sample of every channel
+func (f Format) BlockAlign() int {
+	return f.Channels * f.BitsPerSample / 8
+}
+
+// ByteRate returns the number of bytes of a second of audio
+func (f Format) ByteRate() int {
+	return f.SampleRate * f.BlockAlign()
+}
+
+// Validate checks that the format can be written
+func (f Format) Validate() error {
+	if f.SampleRate <= 0 {
+		return fmt.Errorf("invalid sample rate: %d", f.SampleRate)
+	}
+	if f.Channels <= 0 || f.Channels > math.MaxUint16 {
+		return fmt.Errorf("invalid channel count: %d", f.Channels)
+	}
+	if f.BitsPerSample <= 0 || f.BitsPerSample%8 != 0 {
+		return fmt.Errorf("invalid bits per sample: %d", f.BitsPerSample)
+	}
+	return nil
+}
+
+// Header returns the canonical header of a file holding dataSize bytes of
+// audio in the format
+func Header(format Format, dataSize uint32) []byte {
+	header := make([]byte, HeaderSize)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], dataSize+HeaderSize-8)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16) // Size of the PCM fmt chunk
+	binary.LittleEndian.PutUint16(header[20:22], 1)  // PCM
+	binary.LittleEndian.PutUint16(header[22:24], uint16(format.Channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(format.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(format.ByteRate()))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(format.BlockAlign()))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(format.BitsPerSample))
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+	return header
+}
+
+// ReadHeader reads a canonical header and returns the format and the data
+// size it declares
+func ReadHeader(r io.Reader) (Format, uint32, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Format{}, 0, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return Format{}, 0, errors.New("not a WAV file")
+	}
+	if string(header[12:16]) != "fmt " || string(header[36:40]) != "data" {
+		return Format{}, 0, errors.New("not a canonical WAV header")
+	}
+	if audioFormat := binary.LittleEndian.Uint16(header[20:22]); audioFormat != 1 {
+		return Format{}, 0, fmt.Errorf("invalid audio format: %d (expected 1 for PCM)", audioFormat)
+	}
+	format := Format{
+		Channels:      int(binary.LittleEndian.Uint16(header[22:24])),
+		SampleRate:    int(binary.LittleEndian.Uint32(header[24:28])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(header[34:36])),
+	}
+	return format, binary.LittleEndian.Uint32(header[40:44]), nil
+}
+
+// Writer writes the audio of a WAV file. The header is written with empty
+// sizes first, so that an interrupted file can still be repaired, and is
+// completed by Finalize
+type Writer struct {
+	w         io.WriteSeeker
+	format    Format
+	size      int64
+	finalized bool
+}
+
+// NewWriter writes the header of the format to w and returns a Writer
+// appending the audio after it
+func NewWriter(w io.WriteSeeker, format Format) (*Writer, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(Header(format, 0)); err != nil {
+		return nil, fmt.Errorf("failed to write WAV header: %w", err)
+	}
+	return &Writer{w: w, format: format}, nil
+}
+
+// Format returns the format of the file
+func (wr *Writer) Format() Format {
+	return wr.format
+}
+
+// Size returns the number of bytes of audio written so far
+func (wr *Writer) Size() int64 {
+	return wr.size
+}
+
+// Write appends PCM audio in the format of the file
+func (wr *Writer) Write(p []byte) (int, error) {
+	if wr.finalized {
+		return 0, errors.New("WAV file is finalized")
+	}
+	if wr.size+int64(len(p)) > math.MaxUint32-HeaderSize {
+		return 0, errors.New("WAV file exceeds 4 GB")
+	}
+	n, err := wr.w.Write(p)
+	wr.size += int64(n)
+	return n, err
+}
+
+// Finalize writes the sizes of the header. The audio is not written to
+// the underlying writer anymore, which is left at its end
+func (wr *Writer) Finalize() error {
+	if wr.finalized {
+		return nil
+	}
+	wr.finalized = true
+	header := Header(wr.format, uint32(wr.size))
+	if _, err := wr.w.Seek(riffSizeOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to the RIFF size: %w", err)
+	}
+	if _, err := wr.w.Write(header[riffSizeOffset : riffSizeOffset+4]); err != nil {
+		return fmt.Errorf("failed to update the RIFF size: %w", err)
+	}
+	if _, err := wr.w.Seek(dataSizeOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to the data size: %w", err)
+	}
+	if _, err := wr.w.Write(header[dataSizeOffset : dataSizeOffset+4]); err != nil {
+		return fmt.Errorf("failed to update the data size: %w", err)
+	}
+	if _, err := wr.w.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to the end: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var pcm16k = Format{SampleRate: 16000, Channels: 1, BitsPerSample: 16}
+
+// seekBuffer is an in-memory io.WriteSeeker, it accepts at most limit
+// bytes in total when limit is positive
+type seekBuffer struct {
+	data  []byte
+	pos   int
+	limit int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	var err error
+	if b.limit > 0 && b.pos+len(p) > b.limit {
+		p, err = p[:b.limit-b.pos], errors.New("disk full")
+	}
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	b.pos += copy(b.data[b.pos:], p)
+	return len(p), err
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.pos = int(offset)
+	case io.SeekCurrent:
+		b.pos += int(offset)
+	case io.SeekEnd:
+		b.pos = len(b.data) + int(offset)
+	}
+	return int64(b.pos), nil
+}
+
+func sizes(data []byte) (riff, audio uint32) {
+	return binary.LittleEndian.Uint32(data[riffSizeOffset:]), binary.LittleEndian.Uint32(data[dataSizeOffset:])
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	buf := &seekBuffer{}
+	wr, err := NewWriter(buf, pcm16k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	for i := 0; i < len(audio); i += 1000 {
+		if n, err := wr.Write(audio[i : i+1000]); err != nil || n != 1000 {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	if wr.Size() != int64(len(audio)) {
+		t.Errorf("Size = %d, want %d", wr.Size(), len(audio))
+	}
+	if err := wr.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.pos != len(buf.data) {
+		t.Errorf("Finalize left the writer at %d of %d", buf.pos, len(buf.data))
+	}
+
+	format, dataSize, err := ReadHeader(bytes.NewReader(buf.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != pcm16k || dataSize != uint32(len(audio)) {
+		t.Errorf("ReadHeader = %+v, %d", format, dataSize)
+	}
+	if riff, _ := sizes(buf.data); riff != uint32(len(audio))+HeaderSize-8 {
+		t.Errorf("RIFF size = %d", riff)
+	}
+	if !bytes.Equal(buf.data[HeaderSize:], audio) {
+		t.Error("the audio was not written after the header")
+	}
+	if !bytes.Equal(buf.data[:HeaderSize], Header(pcm16k, uint32(len(audio)))) {
+		t.Error("the finalized header is not the canonical one")
+	}
+}
+
+func TestWriterSizesAfterPartialWrite(t *testing.T) {
+	buf := &seekBuffer{limit: HeaderSize + 300}
+	wr, err := NewWriter(buf, pcm16k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr.Write(make([]byte, 200)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Until the file is finalized the sizes stay empty, as left by a crash
+	if riff, audio := sizes(buf.data); riff != HeaderSize-8 || audio != 0 {
+		t.Errorf("sizes before Finalize = %d, %d", riff, audio)
+	}
+
+	n, err := wr.Write(make([]byte, 200))
+	if err == nil || n != 100 {
+		t.Fatalf("Write = %d, %v, want a partial write", n, err)
+	}
+	if wr.Size() != 300 {
+		t.Errorf("Size = %d, want the 300 bytes written", wr.Size())
+	}
+	buf.limit = 0
+	if err := wr.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if riff, audio := sizes(buf.data); riff != 300+HeaderSize-8 || audio != 300 {
+		t.Errorf("sizes after Finalize = %d, %d", riff, audio)
+	}
+}
+
+func TestWriterFinalize(t *testing.T) {
+	buf := &seekBuffer{}
+	wr, err := NewWriter(buf, pcm16k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Finalize(); err != nil {
+		t.Errorf("second Finalize: %v", err)
+	}
+	if _, err := wr.Write([]byte{0, 0}); err == nil {
+		t.Error("Write after Finalize succeeded")
+	}
+	if _, dataSize, err := ReadHeader(bytes.NewReader(buf.data)); err != nil || dataSize != 0 {
+		t.Errorf("ReadHeader of an empty file = %d, %v", dataSize, err)
+	}
+}
+
+func TestNewWriterRejectsInvalidFormats(t *testing.T) {
+	for _, format := range []Format{
+		{SampleRate: 0, Channels: 1, BitsPerSample: 16},
+		{SampleRate: 16000, Channels: 0, BitsPerSample: 16},
+		{SampleRate: 16000, Channels: 1 << 16, BitsPerSample: 16},
+		{SampleRate: 16000, Channels: 1, BitsPerSample: 12},
+	} {
+		buf := &seekBuffer{}
+		if _, err := NewWriter(buf, format); err == nil {
+			t.Errorf("NewWriter(%+v) succeeded", format)
+		}
+		if len(buf.data) != 0 {
+			t.Errorf("NewWriter(%+v) wrote a header", format)
+		}
+	}
+}
+
+func TestReadHeaderInvalid(t *testing.T) {
+	valid := Header(pcm16k, 100)
+	corrupt := func(offset int, value string) []byte {
+		header := append([]byte(nil), valid...)
+		copy(header[offset:], value)
+		return header
+	}
+	for name, data := range map[string][]byte{
+		"empty":        {},
+		"truncated":    valid[:HeaderSize-1],
+		"only RIFF":    valid[:12],
+		"not RIFF":     corrupt(0, "RIFX"),
+		"not WAVE":     corrupt(8, "AVI "),
+		"no fmt chunk": corrupt(12, "LIST"),
+		"no data":      corrupt(36, "fact"),
+		"not PCM":      corrupt(20, "\x03\x00"),
+	} {
+		if _, _, err := ReadHeader(bytes.NewReader(data)); err == nil {
+			t.Errorf("ReadHeader of %s header succeeded", name)
+		}
+	}
+	if _, _, err := ReadHeader(bytes.NewReader(valid[:10])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated header error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestFileFinalize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFile(file, time.Hour)
+	wr, err := NewWriter(f, pcm16k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr.Write(make([]byte, 640)); err != nil {
+		t.Fatal(err)
+	}
+	// The audio stays buffered until the interval elapses
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != 0 {
+		t.Errorf("%d bytes on the disk before the sync", info.Size())
+	}
+	if err := f.Finalize(wr); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != HeaderSize+640 {
+		t.Fatalf("file of %d bytes", len(data))
+	}
+	if _, dataSize, err := ReadHeader(bytes.NewReader(data)); err != nil || dataSize != 640 {
+		t.Errorf("ReadHeader = %d, %v", dataSize, err)
+	}
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const userKey contextKey = 0
+
+// WithUser returns a copy of ctx carrying the authenticated username
+func WithUser(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, userKey, username)
+}
+
+// UserFromContext returns the authenticated username stored in ctx,
+// or an empty string for anonymous requests
+func UserFromContext(ctx context.Context) string {
+	username, _ := ctx.Value(userKey).(string)
+	return username
+}
@@ -0,0 +1,167 @@
+package captions
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fillers are the hesitations dropped by Format.RemoveFillers
+var fillers = map[string]bool{
+	"ah": true, "eh": true, "er": true, "erm": true, "hm": true, "hmm": true,
+	"mm": true, "mhm": true, "uh": true, "uhm": true, "um": true, "umm": true,
+}
+
+// Format shapes the text of the captions to meet the accessibility
+// guidelines of the target, e.g. the EIA-608 limits of the broadcasts
+type Format struct {
+	LineChars     int  // Characters per line
+	Lines         int  // Lines per caption
+	SentenceCase  bool // Capitalize the sentences, vendors may return all lowercase or uppercase text
+	RemoveFillers bool // Drop the hesitations like "um" and "uh"
+}
+
+// DefaultFormat is two lines of 32 characters, the EIA-608 limits of the
+// roll-up captions
+var DefaultFormat = Format{LineChars: rowWidth, Lines: rowsPerCaption}
+
+// Cue is a caption timed relative to the start of its session
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Clean removes the fillers and fixes the casing of the text as configured
+func (f Format) Clean(text string) string {
+	words := strings.Fields(text)
+	if f.RemoveFillers {
+		kept := words[:0]
+		for _, word := range words {
+			if !fillers[strings.ToLower(strings.TrimFunc(word, unicode.IsPunct))] {
+				kept = append(kept, word)
+				continue
+			}
+			// A filler ending a sentence passes its punctuation on
+			if end := strings.TrimLeftFunc(word, unicode.IsLetter); strings.ContainsAny(end, ".!?") && len(kept) > 0 {
+				kept[len(kept)-1] = strings.TrimRightFunc(kept[len(kept)-1], unicode.IsPunct) + end
+			}
+		}
+		words = kept
+	}
+	text = strings.Join(words, " ")
+	if f.SentenceCase {
+		text = sentenceCase(text)
+	}
+	return text
+}
+
+// Captions cleans the text and splits it into captions of at most Lines
+// lines of LineChars characters, lines are separated by a newline
+func (f Format) Captions(text string) []string {
+	width, lines := f.LineChars, f.Lines
+	if width <= 0 {
+		width = rowWidth
+	}
+	if lines <= 0 {
+		lines = rowsPerCaption
+	}
+	rows := wrapRows(f.Clean(text), width)
+
+	var captions []string
+	for i := 0; i < len(rows); i += lines {
+		end := i + lines
+		if end > len(rows) {
+			end = len(rows)
+		}
+		captions = append(captions, strings.Join(rows[i:end], "\n"))
+	}
+	return captions
+}
+
+// Cues splits the text shown from start to end into the cues of its
+// captions, each lasting in proportion to its length
+func (f Format) Cues(text string, start, end time.Duration) []Cue {
+	captions := f.Captions(text)
+	total := 0
+	for _, caption := range captions {
+		total += utf8.RuneCountInString(caption)
+	}
+	var cues []Cue
+	at, seen := start, 0
+	for i, caption := range captions {
+		seen += utf8.RuneCountInString(caption)
+		next := start + time.Duration(int64(end-start)*int64(seen)/int64(total))
+		if i == len(captions)-1 {
+			next = end
+		}
+		cues = append(cues, Cue{Start: at, End: next, Text: caption})
+		at = next
+	}
+	return cues
+}
+
+// Wrap splits the text into captions of the DefaultFormat
+func Wrap(text string) []string {
+	return DefaultFormat.Captions(text)
+}
+
+// wrapRows splits the text into rows of at most width characters
+func wrapRows(text string, width int) []string {
+	var rows []string
+	row := ""
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > width {
+			// Words longer than a row are cut
+			if row != "" {
+				rows = append(rows, row)
+				row = ""
+			}
+			runes := []rune(word)
+			rows = append(rows, string(runes[:width]))
+			word = string(runes[width:])
+		}
+		switch {
+		case row == "":
+			row = word
+		case utf8.RuneCountInString(row)+1+utf8.RuneCountInString(word) <= width:
+			row += " " + word
+		default:
+			rows = append(rows, row)
+			row = word
+		}
+	}
+	if row != "" {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// sentenceCase capitalizes the first letter of the sentences and the
+// pronoun "I", uppercase text is lowered first
+func sentenceCase(text string) string {
+	if strings.ToUpper(text) == text {
+		text = strings.ToLower(text)
+	}
+	words := strings.Fields(text)
+	start := true
+	for i, word := range words {
+		if strings.TrimFunc(word, unicode.IsPunct) == "i" || strings.HasPrefix(word, "i'") {
+			word = "I" + word[1:]
+		}
+		if start {
+			runes := []rune(word)
+			for j, r := range runes {
+				if unicode.IsLetter(r) {
+					runes[j] = unicode.ToUpper(r)
+					break
+				}
+			}
+			word = string(runes)
+		}
+		words[i] = word
+		start = strings.ContainsAny(word[len(word)-1:], ".!?")
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,152 @@
+package captions
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// OBS WebSocket (v5) opcodes
+const (
+	obsOpHello           = 0
+	obsOpIdentify        = 1
+	obsOpIdentified      = 2
+	obsOpRequest         = 6
+	obsOpRequestResponse = 7
+)
+
+// reconnectDelay limits the connection attempts to an unreachable output
+const reconnectDelay = 5 * time.Second
+
+type obsMessage struct {
+	Op int                    `json:"op"`
+	D  map[string]interface{} `json:"d"`
+}
+
+// OBSOutput sends the captions to OBS Studio with the SendStreamCaption
+// request of its WebSocket server, OBS embeds them as CEA-608 captions in
+// the stream
+type OBSOutput struct {
+	url         string
+	password    string
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	lastAttempt time.Time
+	requestID   int
+}
+
+// NewOBSOutput creates a new OBSOutput for the OBS WebSocket server at
+// url (e.g. ws://localhost:4455), the password may be empty
+func NewOBSOutput(url, password string) *OBSOutput {
+	return &OBSOutput{
+		url:      url,
+		password: password,
+	}
+}
+
+// Name identifies the output in the logs
+func (o *OBSOutput) Name() string {
+	return "obs"
+}
+
+// SendCaption sends a caption, connecting first if needed
+func (o *OBSOutput) SendCaption(text string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		if time.Since(o.lastAttempt) < reconnectDelay {
+			return fmt.Errorf("not connected")
+		}
+		o.lastAttempt = time.Now()
+		conn, err := o.connect()
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+
+	o.requestID++
+	err := o.request("SendStreamCaption", strconv.Itoa(o.requestID), map[string]interface{}{
+		"captionText": text,
+	})
+	if err != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+	return err
+}
+
+// connect opens the connection and identifies, the events of OBS are not
+// subscribed to
+func (o *OBSOutput) connect() (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var hello obsMessage
+	if err := conn.ReadJSON(&hello); err != nil || hello.Op != obsOpHello {
+		conn.Close()
+		return nil, fmt.Errorf("no Hello from OBS: %v", err)
+	}
+
+	identify := map[string]interface{}{
+		"rpcVersion":         1,
+		"eventSubscriptions": 0,
+	}
+	if authentication, ok := hello.D["authentication"].(map[string]interface{}); ok {
+		challenge, _ := authentication["challenge"].(string)
+		salt, _ := authentication["salt"].(string)
+		identify["authentication"] = obsAuthentication(o.password, salt, challenge)
+	}
+	if err := conn.WriteJSON(&obsMessage{Op: obsOpIdentify, D: identify}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var identified obsMessage
+	if err := conn.ReadJSON(&identified); err != nil || identified.Op != obsOpIdentified {
+		conn.Close()
+		return nil, fmt.Errorf("OBS identification failed, check the password: %v", err)
+	}
+	return conn, nil
+}
+
+// request sends a request and checks its response
+func (o *OBSOutput) request(requestType, requestID string, data map[string]interface{}) error {
+	o.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	err := o.conn.WriteJSON(&obsMessage{Op: obsOpRequest, D: map[string]interface{}{
+		"requestType": requestType,
+		"requestId":   requestID,
+		"requestData": data,
+	}})
+	if err != nil {
+		return err
+	}
+	o.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var response obsMessage
+		if err := o.conn.ReadJSON(&response); err != nil {
+			return err
+		}
+		if response.Op != obsOpRequestResponse || response.D["requestId"] != requestID {
+			continue
+		}
+		status, _ := response.D["requestStatus"].(map[string]interface{})
+		if result, _ := status["result"].(bool); !result {
+			return fmt.Errorf("%s failed: %v", requestType, status["comment"])
+		}
+		return nil
+	}
+}
+
+// obsAuthentication computes base64(sha256(base64(sha256(password + salt)) + challenge))
+func obsAuthentication(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
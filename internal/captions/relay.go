@@ -0,0 +1,87 @@
+package captions
+
+import (
+	"log"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+)
+
+// logSampler rate limits the errors of unreachable outputs
+var logSampler = logging.NewSampler(30 * time.Second)
+
+// EIA-608 captions are at most 32 characters wide, roll-up captions show
+// two rows at a time
+const (
+	rowWidth       = 32
+	rowsPerCaption = 2
+)
+
+// minDisplay and maxDisplay bound the time a caption of a longer segment
+// stays on screen before the next one replaces it
+const (
+	minDisplay = 1500 * time.Millisecond
+	maxDisplay = 4 * time.Second
+)
+
+// Output receives the captions, it reconnects by itself after failures
+type Output interface {
+	Name() string
+	SendCaption(text string) error
+}
+
+// Relay pushes the final segments of the live streams to the outputs
+type Relay struct {
+	hub     *live.Hub
+	user    string
+	format  Format
+	outputs []Output
+}
+
+// NewRelay creates a new Relay for the sessions of user, or of every user
+// when empty, shaping the captions with format
+func NewRelay(hub *live.Hub, user string, format Format, outputs ...Output) *Relay {
+	return &Relay{
+		hub:     hub,
+		user:    user,
+		format:  format,
+		outputs: outputs,
+	}
+}
+
+// Run forwards the captions until the process exits
+func (r *Relay) Run() {
+	sub := r.hub.Subscribe(r.user, false)
+	defer r.hub.Unsubscribe(sub)
+
+	for event := range sub.Events {
+		if event.Type != "segment" || !event.Final {
+			continue
+		}
+		captions := r.format.Captions(event.Text)
+		for i, caption := range captions {
+			if i > 0 {
+				time.Sleep(displayTime(captions[i-1]))
+			}
+			for _, output := range r.outputs {
+				if err := output.SendCaption(caption); err != nil {
+					logSampler.Printf("captions:"+output.Name(), "Error sending caption to %s: %v", output.Name(), err)
+				}
+			}
+		}
+	}
+	log.Printf("Caption relay stopped")
+}
+
+// displayTime gives about 20 characters per second to read a caption
+func displayTime(caption string) time.Duration {
+	d := time.Duration(len(caption)) * 50 * time.Millisecond
+	if d < minDisplay {
+		return minDisplay
+	}
+	if d > maxDisplay {
+		return maxDisplay
+	}
+	return d
+}
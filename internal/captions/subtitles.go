@@ -0,0 +1,106 @@
+package captions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// maxCuePause is the pause between two words starting a new cue
+	maxCuePause = time.Second
+	// maxCueDuration is the time after which a cue ends, even when it could
+	// hold more words
+	maxCueDuration = 7 * time.Second
+)
+
+// TimedWord is a word of a transcript timed relative to the start of its
+// session
+type TimedWord struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// WordCues groups the words into the cues of one caption, a pause or a cue
+// lasting too long starts a new one
+func (f Format) WordCues(words []TimedWord) []Cue {
+	var cues []Cue
+	var current []TimedWord
+	flush := func() {
+		if len(current) > 0 {
+			cues = append(cues, f.Cues(joinWords(current), current[0].Start, current[len(current)-1].End)...)
+			current = nil
+		}
+	}
+	for _, word := range words {
+		word.Text = strings.TrimSpace(word.Text)
+		if word.Text == "" {
+			continue
+		}
+		if len(current) > 0 {
+			last := current[len(current)-1]
+			if word.Start-last.End > maxCuePause || word.End-current[0].Start > maxCueDuration ||
+				len(f.Captions(joinWords(append(current, word)))) > 1 {
+				flush()
+			}
+		}
+		current = append(current, word)
+	}
+	flush()
+	return cues
+}
+
+// joinWords joins the words with spaces, but between the CJK characters
+func joinWords(words []TimedWord) string {
+	var b strings.Builder
+	for i, word := range words {
+		if i > 0 && !(isCJK(lastRune(words[i-1].Text)) && isCJK([]rune(word.Text)[0])) {
+			b.WriteString(" ")
+		}
+		b.WriteString(word.Text)
+	}
+	return b.String()
+}
+
+func lastRune(s string) rune {
+	runes := []rune(s)
+	return runes[len(runes)-1]
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// SRT renders the cues as a SubRip document
+func SRT(cues []Cue) string {
+	var b strings.Builder
+	for i, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(cue.Start), srtTime(cue.End), cue.Text)
+	}
+	return b.String()
+}
+
+// WebVTT renders the cues as a WebVTT document
+func WebVTT(cues []Cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		text := strings.Replace(cue.Text, "-->", "->", -1)
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", vttTime(cue.Start), vttTime(cue.End), text)
+	}
+	return b.String()
+}
+
+// srtTime formats a duration as the HH:MM:SS,mmm timestamps of SubRip
+func srtTime(d time.Duration) string {
+	ms := int64((d + time.Millisecond/2) / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// vttTime formats a duration as the HH:MM:SS.mmm timestamps of WebVTT
+func vttTime(d time.Duration) string {
+	ms := int64((d + time.Millisecond/2) / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
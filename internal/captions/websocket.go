@@ -0,0 +1,72 @@
+package captions
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketOutput sends every caption as a text message to a WebSocket
+// endpoint, such as a caption encoder or a browser source overlay relay
+type WebSocketOutput struct {
+	url         string
+	token       string
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	lastAttempt time.Time
+}
+
+// NewWebSocketOutput creates a new WebSocketOutput, the token is sent as
+// an "Authorization: Bearer" header when not empty
+func NewWebSocketOutput(url, token string) *WebSocketOutput {
+	return &WebSocketOutput{
+		url:   url,
+		token: token,
+	}
+}
+
+// Name identifies the output in the logs
+func (o *WebSocketOutput) Name() string {
+	return "websocket"
+}
+
+// SendCaption sends a caption, connecting first if needed
+func (o *WebSocketOutput) SendCaption(text string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		if time.Since(o.lastAttempt) < reconnectDelay {
+			return fmt.Errorf("not connected")
+		}
+		o.lastAttempt = time.Now()
+		header := http.Header{}
+		if o.token != "" {
+			header.Set("Authorization", "Bearer "+o.token)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(o.url, header)
+		if err != nil {
+			return err
+		}
+		// Messages of the endpoint are ignored, reading answers the pings
+		// and detects the close
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		o.conn = conn
+	}
+
+	o.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	err := o.conn.WriteMessage(websocket.TextMessage, []byte(text))
+	if err != nil {
+		o.conn.Close()
+		o.conn = nil
+	}
+	return err
+}
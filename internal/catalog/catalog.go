@@ -0,0 +1,276 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// metadataDir is the directory of the output directory holding the session
+// metadata, directories are hidden from the recordings file list
+const metadataDir = ".meta"
+
+// Metadata is the information about a session that its files do not hold
+type Metadata struct {
+	User       string              `json:"user,omitempty"`
+	Language   string              `json:"language,omitempty"`          // Requested, e.g. "en" or "auto"
+	Detected   string              `json:"detected_language,omitempty"` // Detected by the vendor
+	StartedAt  time.Time           `json:"started_at"`
+	EndedAt    time.Time           `json:"ended_at"`
+	Duration   float64             `json:"duration_seconds"` // Seconds of audio received
+	Tags       []string            `json:"tags,omitempty"`
+	Markers    []transcribe.Marker `json:"markers,omitempty"`
+	Completion string              `json:"completion,omitempty"` // e.g. "auto-completed (client lost)"
+}
+
+// File is a file of the output directory
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Session groups the recordings and transcript sharing the same base name
+type Session struct {
+	ID string // File name without extension
+	Metadata
+	Recording  *File // WAV, MP3 or FLAC file, nil when not kept
+	Archive    *File // Ogg/Opus file, nil when the Opus packets were not recorded
+	Video      *File // IVF file, nil when the video was not recorded
+	Transcript *File // TXT file, nil when not kept
+}
+
+// Catalog indexes the sessions of the output directory
+type Catalog struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// New creates a new Catalog of the output directory
+func New(dir string) *Catalog {
+	return &Catalog{dir: dir}
+}
+
+// Sessions returns the sessions of the output directory, newest first
+func (c *Catalog) Sessions() ([]*Session, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
+	}
+
+	byID := make(map[string]*Session)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext != ".wav" && ext != ".mp3" && ext != ".flac" && ext != ".ogg" && ext != ".ivf" && ext != ".txt" {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		session, ok := byID[id]
+		if !ok {
+			session = &Session{ID: id}
+			byID[id] = session
+		}
+		file := &File{Name: entry.Name(), Size: entry.Size(), ModTime: entry.ModTime()}
+		switch ext {
+		case ".wav", ".mp3", ".flac":
+			session.Recording = file
+		case ".ogg":
+			session.Archive = file
+		case ".ivf":
+			session.Video = file
+		default:
+			session.Transcript = file
+		}
+	}
+
+	sessions := make([]*Session, 0, len(byID))
+	for _, session := range byID {
+		c.loadMetadata(session)
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].modTime().After(sessions[j].modTime())
+	})
+	return sessions, nil
+}
+
+// Session returns the session with the id, nil when it does not exist
+func (c *Catalog) Session(id string) (*Session, error) {
+	sessions, err := c.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+// Text returns the content of the session transcript
+func (c *Catalog) Text(session *Session) (string, error) {
+	if session.Transcript == nil {
+		return "", nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(c.dir, session.Transcript.Name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read transcript: %w", err)
+	}
+	return string(content), nil
+}
+
+// SetTags replaces the tags of a session
+func (c *Catalog) SetTags(id string, tags []string) (*Session, error) {
+	session, err := c.Session(id)
+	if err != nil || session == nil {
+		return session, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadMetadata(session)
+	session.Tags = normalizeTags(tags)
+	if err := c.saveMetadata(session.ID, session.Metadata); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Name identifies the catalog as an events.Sink
+func (c *Catalog) Name() string {
+	return "catalog"
+}
+
+// Send records the metadata and the timed segments of a completed session,
+// it implements events.Sink
+func (c *Catalog) Send(e events.Event) error {
+	s, ok := e.Completed()
+	if !ok {
+		return nil
+	}
+	file := s.AudioFile
+	if file == "" {
+		file = s.TextFile
+	}
+	if file == "" {
+		return nil
+	}
+	id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.saveMetadata(id, Metadata{
+		User:       s.User,
+		Language:   s.Language,
+		Detected:   s.Detected,
+		StartedAt:  s.StartedAt,
+		EndedAt:    s.EndedAt,
+		Duration:   s.Duration,
+		Markers:    s.Markers,
+		Completion: s.Completion,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save metadata of session %s: %w", id, err)
+	}
+	if err := c.saveSegments(id, timedSegments(s)); err != nil {
+		return fmt.Errorf("failed to save segments of session %s: %w", id, err)
+	}
+	return nil
+}
+
+// Segments splits a transcript into its lines, Whisper writes one
+// segment per line
+func Segments(text string) []string {
+	var segments []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			segments = append(segments, line)
+		}
+	}
+	return segments
+}
+
+func (c *Catalog) loadMetadata(session *Session) {
+	content, err := ioutil.ReadFile(c.metadataPath(session.ID))
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(content, &session.Metadata); err != nil {
+		log.Printf("Error reading metadata of session %s: %v", session.ID, err)
+	}
+}
+
+// saveMetadata must be called with mu held
+func (c *Catalog) saveMetadata(id string, metadata Metadata) error {
+	if err := os.MkdirAll(filepath.Join(c.dir, metadataDir), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.metadataPath(id), content, 0644)
+}
+
+func (c *Catalog) metadataPath(id string) string {
+	return filepath.Join(c.dir, metadataDir, id+".json")
+}
+
+// HasLanguage reports whether the session was requested or detected in the
+// language, "en" matches the regional variants like "en-US"
+func (s *Session) HasLanguage(language string) bool {
+	language = strings.ToLower(strings.TrimSpace(language))
+	for _, l := range []string{s.Language, s.Detected} {
+		l = strings.ToLower(l)
+		if l != "" && (l == language || strings.HasPrefix(l, language+"-")) {
+			return true
+		}
+	}
+	return false
+}
+
+// modTime returns the last modification of the session files
+func (s *Session) modTime() time.Time {
+	var t time.Time
+	if s.Recording != nil {
+		t = s.Recording.ModTime
+	}
+	if s.Archive != nil && s.Archive.ModTime.After(t) {
+		t = s.Archive.ModTime
+	}
+	if s.Video != nil && s.Video.ModTime.After(t) {
+		t = s.Video.ModTime
+	}
+	if s.Transcript != nil && s.Transcript.ModTime.After(t) {
+		t = s.Transcript.ModTime
+	}
+	return t
+}
+
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
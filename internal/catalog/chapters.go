@@ -0,0 +1,50 @@
+package catalog
+
+import (
+	"sort"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/captions"
+)
+
+// Chapter is the part of a session starting at a marker and ending at the
+// next one, or at the end of the session
+type Chapter struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start_seconds"`
+	End   float64 `json:"end_seconds"`
+}
+
+// Chapters returns the chapters of the session markers
+func (s *Session) Chapters() []Chapter {
+	markers := append(s.Markers[:0:0], s.Markers...)
+	sort.SliceStable(markers, func(i, j int) bool {
+		return markers[i].Offset < markers[j].Offset
+	})
+	chapters := make([]Chapter, 0, len(markers))
+	for i, marker := range markers {
+		end := s.Duration
+		if i+1 < len(markers) {
+			end = markers[i+1].Offset
+		}
+		if end < marker.Offset {
+			end = marker.Offset
+		}
+		chapters = append(chapters, Chapter{Title: marker.Name, Start: marker.Offset, End: end})
+	}
+	return chapters
+}
+
+// SRT formats the chapters as SubRip cues shaped by format, a chapter
+// title longer than a caption spans several cues
+func SRT(chapters []Chapter, format captions.Format) string {
+	var cues []captions.Cue
+	for _, chapter := range chapters {
+		cues = append(cues, format.Cues(chapter.Title, seconds(chapter.Start), seconds(chapter.End))...)
+	}
+	return captions.SRT(cues)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s*float64(time.Second) + 0.5)
+}
@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/captions"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// summary describes a session in the transcript listing
+type summary struct {
+	ID         string     `json:"id"`
+	User       string     `json:"user,omitempty"`
+	Language   string     `json:"language,omitempty"`
+	Detected   string     `json:"detected_language,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	Duration   float64    `json:"duration_seconds"`
+	Completion string     `json:"completion,omitempty"`
+	Tags       []string   `json:"tags"`
+}
+
+// transcript is the JSON document of a session transcript
+type transcript struct {
+	summary
+	Text          string              `json:"text"`
+	Segments      []string            `json:"segments"`
+	TimedSegments []TimedSegment      `json:"timed_segments"` // Empty for the sessions recorded before they were saved
+	Markers       []transcribe.Marker `json:"markers"`
+	Chapters      []Chapter           `json:"chapters"`
+}
+
+// MakeHandler returns an HTTP handler listing the transcripts of the
+// sessions on /api/transcripts/, filtered by ?language=, and serving them
+// on /api/transcripts/<id>, as JSON with their timed segments or with
+// ?format=srt as the SubRip cues of their chapters shaped by format. Users
+// see their own sessions and the sessions without a user, admins every
+// session
+func MakeHandler(c *Catalog, isAdmin func(user string) bool, format captions.Format) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/transcripts/")
+		user := auth.UserFromContext(r.Context())
+		visible := func(s *Session) bool {
+			return s.User == "" || s.User == user || isAdmin(user)
+		}
+		if id == "" {
+			sessions, err := c.Sessions()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			language := r.URL.Query().Get("language")
+			list := []summary{}
+			for _, s := range sessions {
+				if s.Transcript != nil && visible(s) && (language == "" || s.HasLanguage(language)) {
+					list = append(list, summarize(s))
+				}
+			}
+			writeJSON(w, list)
+			return
+		}
+		session, err := c.Session(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if session == nil || !visible(session) {
+			http.Error(w, "Transcript not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.URL.Query().Get("format") {
+		case "srt":
+			w.Header().Set("Content-Type", "application/x-subrip; charset=utf-8")
+			w.Write([]byte(SRT(session.Chapters(), format)))
+		case "", "json":
+			text, err := c.Text(session)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			timed, err := c.TimedSegments(session)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, transcript{
+				summary:       summarize(session),
+				Text:          text,
+				Segments:      append([]string{}, Segments(text)...),
+				TimedSegments: append([]TimedSegment{}, timed...),
+				Markers:       append([]transcribe.Marker{}, session.Markers...),
+				Chapters:      session.Chapters(),
+			})
+		default:
+			http.Error(w, "Unsupported format", http.StatusBadRequest)
+		}
+	})
+}
+
+func summarize(s *Session) summary {
+	doc := summary{
+		ID:         s.ID,
+		User:       s.User,
+		Language:   s.Language,
+		Detected:   s.Detected,
+		Duration:   s.Duration,
+		Completion: s.Completion,
+		Tags:       append([]string{}, s.Tags...),
+	}
+	if !s.StartedAt.IsZero() {
+		doc.StartedAt = &s.StartedAt
+	}
+	return doc
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,178 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// segmentsExt is the extension of the structured transcript saved next to
+// the recording of a session
+const segmentsExt = ".json"
+
+// TimedSegment is a final segment of a session transcript, timed in seconds
+// from the start of the session
+type TimedSegment struct {
+	Start      float64 `json:"start_seconds"`
+	End        float64 `json:"end_seconds"`
+	Text       string  `json:"text"`
+	Confidence float32 `json:"confidence"`
+	Speaker    string  `json:"speaker,omitempty"`
+	Language   string  `json:"language,omitempty"`
+	Vendor     string  `json:"vendor,omitempty"`
+}
+
+// TimedSegments returns the structured transcript of the session, nil for
+// the sessions recorded before it was saved
+func (c *Catalog) TimedSegments(session *Session) ([]TimedSegment, error) {
+	content, err := ioutil.ReadFile(c.segmentsPath(session.ID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the segments: %w", err)
+	}
+	var segments []TimedSegment
+	if err := json.Unmarshal(content, &segments); err != nil {
+		return nil, fmt.Errorf("failed to parse the segments: %w", err)
+	}
+	return segments, nil
+}
+
+// saveSegments must be called with mu held
+func (c *Catalog) saveSegments(id string, segments []TimedSegment) error {
+	content, err := json.MarshalIndent(segments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.segmentsPath(id), content, 0644)
+}
+
+func (c *Catalog) segmentsPath(id string) string {
+	return filepath.Join(c.dir, id+segmentsExt)
+}
+
+// timedSegments returns the segments of the final results of a session. The
+// segments are timed by the words of their result, the results without
+// words by their offsets or from the end of the previous one to the audio
+// received when they arrived
+func timedSegments(session events.Session) []TimedSegment {
+	segments := []TimedSegment{}
+	start := 0.0
+	for i, result := range session.Results {
+		if result.Code != "" || strings.TrimSpace(result.Text) == "" {
+			continue
+		}
+		end := start
+		if i < len(session.Received) && session.Received[i] > start {
+			end = session.Received[i]
+		}
+		if result.End > 0 {
+			// Timed by the TimingTranscriber
+			start, end = result.Start, result.End
+		}
+		for _, segment := range splitResult(result, start, end) {
+			segments = append(segments, segment)
+			if segment.End > end {
+				end = segment.End
+			}
+		}
+		start = end
+	}
+	return segments
+}
+
+// splitResult returns a segment per line of the result, Whisper returns its
+// segments as lines. The words are assigned to the lines in order, the
+// lines without words share the time from start to end by their length
+func splitResult(result transcribe.Result, start, end float64) []TimedSegment {
+	lines := Segments(result.Text)
+	segments := make([]TimedSegment, len(lines))
+	total := 0
+	for _, line := range lines {
+		total += utf8.RuneCountInString(line)
+	}
+	words := result.Words
+	seen := 0
+	for i, line := range lines {
+		segment := TimedSegment{
+			Text:       line,
+			Confidence: result.Confidence,
+			Speaker:    result.Speaker,
+			Language:   result.Language,
+			Vendor:     result.Vendor,
+		}
+		var own []transcribe.Word
+		for letters := countLetters(line); len(words) > 0 && letters > 0; words = words[1:] {
+			letters -= countLetters(words[0].Word)
+			own = append(own, words[0])
+		}
+		if i == len(lines)-1 {
+			own = append(own, words...)
+		}
+
+		if len(own) > 0 {
+			segment.Start, segment.End = own[0].Start, own[len(own)-1].End
+			segment.Confidence = meanConfidence(own, result.Confidence)
+			if len(lines) > 1 {
+				segment.Speaker = mainSpeaker(own, result.Speaker)
+			}
+		} else {
+			segment.Start = start + (end-start)*float64(seen)/float64(total)
+			segment.End = start + (end-start)*float64(seen+utf8.RuneCountInString(line))/float64(total)
+		}
+		seen += utf8.RuneCountInString(line)
+		segments[i] = segment
+	}
+	return segments
+}
+
+// countLetters counts the letters and digits of the text, the words of the
+// vendors may not carry the punctuation of the text
+func countLetters(text string) int {
+	n := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// meanConfidence returns the mean confidence of the words, fallback when
+// the vendor does not score them
+func meanConfidence(words []transcribe.Word, fallback float32) float32 {
+	var sum float32
+	for _, word := range words {
+		sum += word.Confidence
+	}
+	if sum == 0 {
+		return fallback
+	}
+	return sum / float32(len(words))
+}
+
+// mainSpeaker returns the speaker of most of the words, fallback when none
+// is labelled
+func mainSpeaker(words []transcribe.Word, fallback string) string {
+	counts := make(map[string]int)
+	speaker := fallback
+	for _, word := range words {
+		if word.Speaker == "" {
+			continue
+		}
+		counts[word.Speaker]++
+		if counts[word.Speaker] > counts[speaker] {
+			speaker = word.Speaker
+		}
+	}
+	return speaker
+}
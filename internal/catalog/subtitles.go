@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/walterfan/webrtc-transcriber/internal/captions"
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// Subtitle formats written by Subtitles
+const (
+	SubtitlesSRT = "srt"
+	SubtitlesVTT = "vtt"
+)
+
+// Subtitles writes the subtitles of the completed sessions next to their
+// recording, from the timing of the words of their final results. The
+// sessions of the vendors without word timing get no subtitles
+type Subtitles struct {
+	format  captions.Format
+	formats []string
+}
+
+// NewSubtitles creates a new events.Sink writing the subtitles in the
+// formats, "srt" and "vtt", shaped by format
+func NewSubtitles(format captions.Format, formats []string) (*Subtitles, error) {
+	for _, f := range formats {
+		if f != SubtitlesSRT && f != SubtitlesVTT {
+			return nil, fmt.Errorf("unknown subtitle format %q (expected srt or vtt)", f)
+		}
+	}
+	return &Subtitles{format: format, formats: formats}, nil
+}
+
+// Name identifies the sink in the logs and dead letters
+func (s *Subtitles) Name() string {
+	return "subtitles"
+}
+
+// Send writes the subtitles of a completed session as <session>.srt and
+// <session>.vtt, it implements events.Sink
+func (s *Subtitles) Send(e events.Event) error {
+	session, ok := e.Completed()
+	if !ok {
+		return nil
+	}
+	file := session.AudioFile
+	if file == "" {
+		file = session.TextFile
+	}
+	if file == "" {
+		return nil
+	}
+
+	var words []captions.TimedWord
+	for _, result := range session.Results {
+		for _, word := range result.Words {
+			words = append(words, captions.TimedWord{Text: word.Word, Start: seconds(word.Start), End: seconds(word.End)})
+		}
+	}
+	cues := s.format.WordCues(words)
+	if len(cues) == 0 {
+		return nil
+	}
+
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, f := range s.formats {
+		content := captions.SRT(cues)
+		if f == SubtitlesVTT {
+			content = captions.WebVTT(cues)
+		}
+		if err := ioutil.WriteFile(base+"."+f, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write the subtitles of session %s: %w", filepath.Base(base), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// Maximum length of the transcript excerpt included in a message
+const summaryLength = 280
+
+// Platform is a chat service receiving messages through incoming webhooks
+type Platform string
+
+// Supported platforms
+const (
+	Slack   Platform = "slack"
+	Discord Platform = "discord"
+)
+
+// Route is an incoming webhook receiving the sessions of one user, or of
+// every user when User is empty
+type Route struct {
+	Platform Platform
+	User     string
+	URL      string
+}
+
+// ParseRoutes parses a comma separated list of webhook URLs, each one
+// optionally prefixed by the user it is restricted to, e.g.
+// "https://hooks.slack.com/services/T/B/X,alice=https://hooks.slack.com/services/T/B/Y"
+func ParseRoutes(platform Platform, spec string) ([]Route, error) {
+	var routes []Route
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route := Route{Platform: platform, URL: entry}
+		if !strings.HasPrefix(entry, "http://") && !strings.HasPrefix(entry, "https://") {
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("invalid %s webhook: %q", platform, entry)
+			}
+			route.User = strings.TrimSpace(parts[0])
+			route.URL = strings.TrimSpace(parts[1])
+		}
+		if _, err := url.ParseRequestURI(route.URL); err != nil {
+			return nil, fmt.Errorf("invalid %s webhook URL %q: %w", platform, route.URL, err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Sink posts a summary of the finished sessions to a Slack or Discord
+// incoming webhook
+type Sink struct {
+	route   Route
+	baseURL string
+	client  *http.Client
+}
+
+// NewSinks creates one Sink per route, baseURL is the public address of
+// the server used to link the transcripts, no link is posted when it is
+// empty
+func NewSinks(routes []Route, baseURL string) []events.Sink {
+	client := &http.Client{Timeout: 15 * time.Second}
+	var sinks []events.Sink
+	for _, route := range routes {
+		sinks = append(sinks, &Sink{
+			route:   route,
+			baseURL: strings.TrimRight(baseURL, "/"),
+			client:  client,
+		})
+	}
+	return sinks
+}
+
+// Name identifies the sink in the logs and dead letters, the URL is left
+// out as it carries the webhook credentials
+func (n *Sink) Name() string {
+	if n.route.User != "" {
+		return fmt.Sprintf("%s webhook of %s", n.route.Platform, n.route.User)
+	}
+	return fmt.Sprintf("%s webhook", n.route.Platform)
+}
+
+// Send posts the summary of a completed session of the route user, or of
+// any user for a global route
+func (n *Sink) Send(e events.Event) error {
+	session, ok := e.Completed()
+	if !ok || (n.route.User != "" && n.route.User != session.User) {
+		return nil
+	}
+	payload, err := json.Marshal(n.message(n.route.Platform, session))
+	if err != nil {
+		return events.Permanent(fmt.Errorf("failed to encode %s message: %w", n.route.Platform, err))
+	}
+	return n.post(payload)
+}
+
+// message builds the platform specific payload of an incoming webhook
+func (n *Sink) message(platform Platform, session events.Session) map[string]string {
+	user := session.User
+	if user == "" {
+		user = "anonymous"
+	}
+	link := n.transcriptLink(session)
+
+	var text strings.Builder
+	switch platform {
+	case Discord:
+		fmt.Fprintf(&text, "**Transcription finished** for %s (%s", user, formatDuration(session.Duration))
+	default:
+		fmt.Fprintf(&text, "*Transcription finished* for %s (%s", user, formatDuration(session.Duration))
+	}
+	if session.Language != "" {
+		fmt.Fprintf(&text, ", %s", session.Language)
+	}
+	text.WriteString(")\n")
+	if summary := summarize(session.Text); summary != "" {
+		fmt.Fprintf(&text, "> %s\n", summary)
+	}
+	if link != "" {
+		switch platform {
+		case Discord:
+			fmt.Fprintf(&text, "[View transcript](%s)", link)
+		default:
+			fmt.Fprintf(&text, "<%s|View transcript>", link)
+		}
+	}
+
+	if platform == Discord {
+		return map[string]string{"content": text.String()}
+	}
+	return map[string]string{"text": text.String()}
+}
+
+// transcriptLink returns the URL of the transcript, or of the recording
+// when the vendor did not write a text file
+func (n *Sink) transcriptLink(session events.Session) string {
+	file := session.TextFile
+	if file == "" {
+		file = session.AudioFile
+	}
+	if n.baseURL == "" || file == "" {
+		return ""
+	}
+	return n.baseURL + "/recordings/" + url.PathEscape(filepath.Base(file))
+}
+
+// post sends the payload, Slack and Discord answer 429 when rate limited
+func (n *Sink) post(payload []byte) error {
+	resp, err := n.client.Post(n.route.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("HTTP status: %d", resp.StatusCode)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return err
+		}
+		return events.Permanent(err)
+	}
+	return nil
+}
+
+// summarize shortens the transcript to summaryLength characters, cutting
+// at a word boundary
+func summarize(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= summaryLength {
+		return text
+	}
+	cut := string(runes[:summaryLength])
+	if i := strings.LastIndex(cut, " "); i > summaryLength/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
+}
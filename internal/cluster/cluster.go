@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+	"github.com/walterfan/webrtc-transcriber/internal/redis"
+)
+
+// heartbeatInterval is the interval of the announcements of a replica, it
+// is considered gone after three missed ones
+const heartbeatInterval = 5 * time.Second
+
+// Channels of the messages exchanged by the replicas
+const (
+	liveChannel    = "live"
+	controlChannel = "control"
+)
+
+// Cluster shares the state of the server with the other replicas through
+// Redis: the login sessions, the live transcript events, the registry of
+// the active sessions and the WHIP sessions
+type Cluster struct {
+	client  *redis.Client
+	prefix  string
+	replica string
+	started int64
+
+	hub     *live.Hub
+	events  chan *live.Event
+	endWHIP func(id, user string) bool
+}
+
+// New creates a new Cluster, the keys and channels are prefixed with
+// prefix and replica names this server
+func New(client *redis.Client, prefix, replica string) *Cluster {
+	return &Cluster{
+		client:  client,
+		prefix:  prefix,
+		replica: replica,
+		started: time.Now().UnixMilli(),
+	}
+}
+
+// Replica returns the name of this replica
+func (c *Cluster) Replica() string {
+	return c.replica
+}
+
+// key returns a key of the cluster
+func (c *Cluster) key(parts ...string) string {
+	return c.prefix + strings.Join(parts, ":")
+}
+
+// Run announces the replica and receives the messages of the other
+// replicas until the client is closed, it is called once the hub and the
+// WHIP directory are set
+func (c *Cluster) Run() {
+	go c.heartbeat()
+	c.client.Subscribe(c.receive, c.key(liveChannel), c.key(controlChannel))
+}
+
+// heartbeat refreshes the key announcing the replica
+func (c *Cluster) heartbeat() {
+	ttl := strconv.FormatInt(int64(3*heartbeatInterval/time.Millisecond), 10)
+	for {
+		if _, err := c.client.Do("SET", c.key("replica", c.replica), strconv.FormatInt(c.started, 10), "PX", ttl); err != nil {
+			log.Printf("Error announcing replica %s: %v", c.replica, err)
+		}
+		time.Sleep(heartbeatInterval)
+	}
+}
+
+// Replicas returns the names of the running replicas
+func (c *Cluster) Replicas() ([]string, error) {
+	keys, err := c.client.Scan(c.key("replica", "*"))
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]string, 0, len(keys))
+	for _, key := range keys {
+		replicas = append(replicas, strings.TrimPrefix(key, c.key("replica", "")))
+	}
+	return replicas, nil
+}
+
+// message is a message published by a replica
+type message struct {
+	Type    string      `json:"type"`
+	Replica string      `json:"replica"` // Sender, or recipient of the controls
+	Event   *live.Event `json:"event,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	User    string      `json:"user,omitempty"`
+}
+
+// publish sends a message to the other replicas
+func (c *Cluster) publish(channel string, msg message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Do("PUBLISH", c.key(channel), string(payload))
+	return err
+}
+
+// receive handles a message of a replica
+func (c *Cluster) receive(channel string, payload []byte) {
+	var msg message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		log.Printf("Invalid cluster message on %s: %v", channel, err)
+		return
+	}
+	switch {
+	case channel == c.key(liveChannel) && msg.Replica != c.replica && msg.Event != nil && c.hub != nil:
+		c.hub.Deliver(msg.Event)
+	case channel == c.key(controlChannel) && msg.Replica == c.replica && msg.Type == "whip.end" && c.endWHIP != nil:
+		c.endWHIP(msg.ID, msg.User)
+	}
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// status describes the cluster
+type status struct {
+	Replica  string          `json:"replica"`
+	Replicas []string        `json:"replicas"`
+	Sessions []ActiveSession `json:"sessions"`
+}
+
+// MakeAdminHandler returns an HTTP handler listing the running replicas and
+// their live sessions
+func MakeAdminHandler(c *Cluster) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		replicas, err := c.Replicas()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		sessions, err := c.ActiveSessions()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, status{Replica: c.replica, Replicas: replicas, Sessions: sessions})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+)
+
+// logSampler rate limits the warnings about the relayed events
+var logSampler = logging.NewSampler(30 * time.Second)
+
+// ActiveSession is a live session of a replica
+type ActiveSession struct {
+	Session   string `json:"session"`
+	User      string `json:"user,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Replica   string `json:"replica"`
+	StartedMs int64  `json:"started_ms"`
+}
+
+// RelayLive publishes the events of the streams of the hub to the other
+// replicas, delivers theirs to its subscribers and records the active
+// sessions
+func (c *Cluster) RelayLive(hub *live.Hub) {
+	c.hub = hub
+	c.events = make(chan *live.Event, 256)
+	hub.SetRelay(func(event *live.Event) {
+		select {
+		case c.events <- event:
+		default:
+			logSampler.Printf("cluster:relay", "Cluster relay is too slow, dropping %s event", event.Type)
+		}
+	})
+	go c.relayEvents()
+}
+
+func (c *Cluster) relayEvents() {
+	for event := range c.events {
+		switch event.Type {
+		case "session.started":
+			session, _ := json.Marshal(ActiveSession{
+				Session:   event.Session,
+				User:      event.User,
+				Language:  event.Language,
+				Replica:   c.replica,
+				StartedMs: event.TimeMs,
+			})
+			if _, err := c.client.Do("HSET", c.key("sessions"), event.Session, string(session)); err != nil {
+				log.Printf("Error registering session %s: %v", event.Session, err)
+			}
+		case "session.ended":
+			if _, err := c.client.Do("HDEL", c.key("sessions"), event.Session); err != nil {
+				log.Printf("Error unregistering session %s: %v", event.Session, err)
+			}
+		}
+		if err := c.publish(liveChannel, message{Type: "live", Replica: c.replica, Event: event}); err != nil {
+			logSampler.Printf("cluster:publish", "Error publishing live event: %v", err)
+		}
+	}
+}
+
+// ActiveSessions returns the live sessions of the running replicas sorted
+// by start, those of the replicas gone are forgotten
+func (c *Cluster) ActiveSessions() ([]ActiveSession, error) {
+	replicas, err := c.Replicas()
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]bool)
+	for _, replica := range replicas {
+		running[replica] = true
+	}
+	fields, err := c.client.Strings("HGETALL", c.key("sessions"))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []ActiveSession{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		var session ActiveSession
+		if json.Unmarshal([]byte(fields[i+1]), &session) != nil || !running[session.Replica] {
+			c.client.Do("HDEL", c.key("sessions"), fields[i])
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedMs < sessions[j].StartedMs
+	})
+	return sessions, nil
+}
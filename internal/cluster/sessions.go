@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// CreateLogin stores the login session of a token for ttl
+func (c *Cluster) CreateLogin(token, user string, ttl time.Duration) error {
+	_, err := c.client.Do("SET", c.key("login", token), user, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	return err
+}
+
+// Login returns the user of a login session token
+func (c *Cluster) Login(token string) (string, bool) {
+	user, ok, err := c.client.String("GET", c.key("login", token))
+	if err != nil {
+		log.Printf("Error reading login session: %v", err)
+		return "", false
+	}
+	return user, ok
+}
+
+// DeleteLogin removes a login session
+func (c *Cluster) DeleteLogin(token string) error {
+	_, err := c.client.Do("DEL", c.key("login", token))
+	return err
+}
+
+// RevokeLogins removes the login sessions of the users for which keep
+// reports false and returns their number
+func (c *Cluster) RevokeLogins(keep func(user string) bool) (int, error) {
+	keys, err := c.client.Scan(c.key("login", "*"))
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, key := range keys {
+		user, ok, err := c.client.String("GET", key)
+		if err != nil {
+			return revoked, err
+		}
+		if !ok || keep(user) {
+			continue
+		}
+		if _, err := c.client.Do("DEL", key); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"log"
+
+	"github.com/walterfan/webrtc-transcriber/internal/whip"
+)
+
+// whipDirectory records the replica of the WHIP sessions, the deletions
+// reaching another replica are forwarded through the control channel
+type whipDirectory struct {
+	cluster *Cluster
+}
+
+// WHIPDirectory returns the whip.Directory of the cluster, end ends the
+// sessions of this replica whose deletion was forwarded
+func (c *Cluster) WHIPDirectory(end func(id, user string) bool) whip.Directory {
+	c.endWHIP = end
+	return &whipDirectory{cluster: c}
+}
+
+func (d *whipDirectory) Register(id, user string) {
+	if _, err := d.cluster.client.Do("HSET", d.cluster.key("whip"), id, d.cluster.replica); err != nil {
+		log.Printf("Error registering WHIP session %s: %v", id, err)
+	}
+}
+
+func (d *whipDirectory) Unregister(id string) {
+	if _, err := d.cluster.client.Do("HDEL", d.cluster.key("whip"), id); err != nil {
+		log.Printf("Error unregistering WHIP session %s: %v", id, err)
+	}
+}
+
+func (d *whipDirectory) Forward(id, user string) bool {
+	replica, ok, err := d.cluster.client.String("HGET", d.cluster.key("whip"), id)
+	if err != nil {
+		log.Printf("Error locating WHIP session %s: %v", id, err)
+		return false
+	}
+	if !ok || replica == d.cluster.replica {
+		return false
+	}
+	if err := d.cluster.publish(controlChannel, message{Type: "whip.end", Replica: replica, ID: id, User: user}); err != nil {
+		log.Printf("Error forwarding the end of WHIP session %s: %v", id, err)
+		return false
+	}
+	log.Printf("Forwarded the end of WHIP session %s to replica %s", id, replica)
+	return true
+}
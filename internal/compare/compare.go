@@ -0,0 +1,152 @@
+// Package compare transcribes a stored recording with several vendors and
+// aligns their transcripts, to choose the engine suiting an audio domain
+package compare
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// VendorFunc returns the service transcribing with a vendor
+type VendorFunc func(name string) (transcribe.Service, error)
+
+// Transcript is the transcript of the recording by a vendor
+type Transcript struct {
+	Vendor  string  `json:"vendor"`
+	Text    string  `json:"text"`
+	Elapsed float64 `json:"elapsed_seconds"`
+	Error   string  `json:"error,omitempty"`
+	Stats   *Stats  `json:"reference_stats,omitempty"` // Against the reference text when given
+}
+
+// Comparison is the transcripts of a recording by two vendors, the diff
+// and the statistics of the second against the first
+type Comparison struct {
+	Recording   string       `json:"recording"`
+	Language    string       `json:"language,omitempty"`
+	Transcripts []Transcript `json:"transcripts"`
+	Diff        []Op         `json:"diff"`
+	Stats       *Stats       `json:"stats,omitempty"`
+	Note        string       `json:"note,omitempty"` // Why the diff is missing
+}
+
+// Comparer transcribes recordings with the vendors it resolves
+type Comparer struct {
+	vendors    VendorFunc
+	ffmpegPath string
+}
+
+// New creates a new Comparer, ffmpegPath decodes the non WAV recordings
+func New(vendors VendorFunc, ffmpegPath string) *Comparer {
+	return &Comparer{vendors: vendors, ffmpegPath: ffmpegPath}
+}
+
+// Compare transcribes the recording with both vendors at the same time.
+// When reference is not empty, it is the exact text of the recording and
+// each transcript is scored against it
+func (c *Comparer) Compare(path, language, first, second, reference string) (*Comparison, error) {
+	pcm, err := audio.DecodeFile(path, c.ffmpegPath, transcribe.PipelineSampleRate)
+	if err != nil {
+		return nil, err
+	}
+	services := make([]transcribe.Service, 2)
+	for i, name := range []string{first, second} {
+		if services[i], err = c.vendors(name); err != nil {
+			return nil, err
+		}
+	}
+
+	transcripts := []Transcript{{Vendor: first}, {Vendor: second}}
+	var wg sync.WaitGroup
+	for i := range transcripts {
+		wg.Add(1)
+		go func(t *Transcript, service transcribe.Service) {
+			defer wg.Done()
+			start := time.Now()
+			text, err := transcribeAudio(service, pcm, language)
+			t.Elapsed = time.Since(start).Seconds()
+			if err != nil {
+				t.Error = err.Error()
+				return
+			}
+			t.Text = text
+		}(&transcripts[i], services[i])
+	}
+	wg.Wait()
+
+	comparison := &Comparison{Language: language, Transcripts: transcripts, Diff: []Op{}}
+	words := make([][]string, len(transcripts))
+	for i := range transcripts {
+		words[i] = Words(transcripts[i].Text)
+	}
+	if reference != "" {
+		referenceWords := Words(reference)
+		for i := range transcripts {
+			if transcripts[i].Error != "" || len(referenceWords) > maxAlignWords || len(words[i]) > maxAlignWords {
+				continue
+			}
+			_, stats := Align(referenceWords, words[i])
+			transcripts[i].Stats = &stats
+		}
+	}
+	switch {
+	case transcripts[0].Error != "" || transcripts[1].Error != "":
+		comparison.Note = "a vendor failed to transcribe the recording"
+	case len(words[0]) > maxAlignWords || len(words[1]) > maxAlignWords:
+		comparison.Note = fmt.Sprintf("transcripts longer than %d words are not aligned", maxAlignWords)
+	default:
+		diff, stats := Align(words[0], words[1])
+		comparison.Diff = append(comparison.Diff, diff...)
+		comparison.Stats = &stats
+	}
+	return comparison, nil
+}
+
+// transcribeAudio streams the audio through the service and returns its
+// final results, one per line
+func transcribeAudio(service transcribe.Service, pcm []byte, language string) (string, error) {
+	stream, err := service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   language,
+		Transcribe: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription stream: %w", err)
+	}
+	var lines []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range stream.Results() {
+			if text := strings.TrimSpace(result.Text); result.Final && text != "" {
+				lines = append(lines, text)
+			}
+		}
+	}()
+
+	var writeErr error
+	for len(pcm) > 0 {
+		// Write 100ms chunks like a live stream
+		n := 9600
+		if n > len(pcm) {
+			n = len(pcm)
+		}
+		if _, writeErr = stream.Write(pcm[:n]); writeErr != nil {
+			break
+		}
+		pcm = pcm[n:]
+	}
+	closeErr := stream.Close()
+	<-done
+	if writeErr != nil {
+		return "", writeErr
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,151 @@
+package compare
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Operations of the alignment of two transcripts
+const (
+	OpEqual      = "equal"
+	OpSubstitute = "substitute"
+	OpDelete     = "delete" // Word of the reference missing from the hypothesis
+	OpInsert     = "insert" // Word of the hypothesis missing from the reference
+)
+
+// maxAlignWords bounds the words of each transcript aligned, the alignment
+// uses a byte per pair of words
+const maxAlignWords = 6000
+
+// Op is a run of words aligned with the same operation
+type Op struct {
+	Op         string `json:"op"`
+	Reference  string `json:"reference,omitempty"`
+	Hypothesis string `json:"hypothesis,omitempty"`
+}
+
+// Stats are the word error statistics of a hypothesis against a reference
+type Stats struct {
+	ReferenceWords  int     `json:"reference_words"`
+	HypothesisWords int     `json:"hypothesis_words"`
+	Matches         int     `json:"matches"`
+	Substitutions   int     `json:"substitutions"`
+	Deletions       int     `json:"deletions"`
+	Insertions      int     `json:"insertions"`
+	WER             float64 `json:"wer"` // (substitutions + deletions + insertions) / reference words
+}
+
+// Words splits a transcript into the lowercase words compared, ignoring
+// the punctuation. Chinese and Japanese characters are compared one by
+// one as their words are not separated by spaces
+func Words(text string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+			flush()
+			words = append(words, string(r))
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '\'':
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// Align returns the minimal edit alignment of the hypothesis words against
+// the reference words and its statistics
+func Align(reference, hypothesis []string) ([]Op, Stats) {
+	n, m := len(reference), len(hypothesis)
+	// moves[i][j] is the last operation of the best alignment of the
+	// first i reference and j hypothesis words
+	moves := make([][]byte, n+1)
+	previous := make([]int, m+1)
+	current := make([]int, m+1)
+	for j := 0; j <= m; j++ {
+		previous[j] = j
+	}
+	moves[0] = make([]byte, m+1)
+	for j := 1; j <= m; j++ {
+		moves[0][j] = 'i'
+	}
+	for i := 1; i <= n; i++ {
+		moves[i] = make([]byte, m+1)
+		current[0] = i
+		moves[i][0] = 'd'
+		for j := 1; j <= m; j++ {
+			cost, move := previous[j-1], byte('e')
+			if reference[i-1] != hypothesis[j-1] {
+				cost, move = cost+1, 's'
+			}
+			if previous[j]+1 < cost {
+				cost, move = previous[j]+1, 'd'
+			}
+			if current[j-1]+1 < cost {
+				cost, move = current[j-1]+1, 'i'
+			}
+			current[j], moves[i][j] = cost, move
+		}
+		previous, current = current, previous
+	}
+
+	stats := Stats{ReferenceWords: n, HypothesisWords: m}
+	var ops []Op
+	add := func(op, ref, hyp string) {
+		// Merge the runs of the same operation, built backwards
+		if len(ops) > 0 && ops[len(ops)-1].Op == op {
+			last := &ops[len(ops)-1]
+			last.Reference = join(ref, last.Reference)
+			last.Hypothesis = join(hyp, last.Hypothesis)
+			return
+		}
+		ops = append(ops, Op{Op: op, Reference: ref, Hypothesis: hyp})
+	}
+	for i, j := n, m; i > 0 || j > 0; {
+		switch moves[i][j] {
+		case 'e':
+			stats.Matches++
+			add(OpEqual, reference[i-1], hypothesis[j-1])
+			i, j = i-1, j-1
+		case 's':
+			stats.Substitutions++
+			add(OpSubstitute, reference[i-1], hypothesis[j-1])
+			i, j = i-1, j-1
+		case 'd':
+			stats.Deletions++
+			add(OpDelete, reference[i-1], "")
+			i--
+		default:
+			stats.Insertions++
+			add(OpInsert, "", hypothesis[j-1])
+			j--
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	errors := stats.Substitutions + stats.Deletions + stats.Insertions
+	if n > 0 {
+		stats.WER = float64(errors) / float64(n)
+	} else if errors > 0 {
+		stats.WER = 1
+	}
+	return ops, stats
+}
+
+func join(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + " " + b
+}
@@ -0,0 +1,74 @@
+package compare
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// request is the JSON body of a comparison
+type request struct {
+	Recording string   `json:"recording"`           // File name of the recordings directory
+	Vendors   []string `json:"vendors"`             // The two vendors compared, the first is the reference of the diff
+	Language  string   `json:"language,omitempty"`  // e.g. "en", the default language when empty
+	Reference string   `json:"reference,omitempty"` // Exact text of the recording scoring both vendors
+}
+
+// MakeAdminHandler returns an HTTP handler transcribing a recording of the
+// directory of the admin (dir) with two vendors (POST) and returning their
+// transcripts with the word diff and the word error statistics
+func MakeAdminHandler(c *Comparer, dir func(user string) string, language string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		if req.Recording == "" || req.Recording != filepath.Base(req.Recording) || strings.HasPrefix(req.Recording, ".") {
+			http.Error(w, "Invalid recording", http.StatusBadRequest)
+			return
+		}
+		if len(req.Vendors) != 2 || req.Vendors[0] == "" || req.Vendors[1] == "" {
+			http.Error(w, "Two vendors are required", http.StatusBadRequest)
+			return
+		}
+		if req.Language == "" {
+			req.Language = language
+		}
+
+		user := auth.UserFromContext(r.Context())
+		path := filepath.Join(dir(user), req.Recording)
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			http.Error(w, "Recording not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Comparison of %s with %s requested by %s", req.Recording, strings.Join(req.Vendors, " and "), user)
+		comparison, err := c.Compare(path, req.Language, req.Vendors[0], req.Vendors[1], req.Reference)
+		if err != nil {
+			log.Printf("Error comparing vendors on %s: %v", req.Recording, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		comparison.Recording = req.Recording
+		writeJSON(w, comparison)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+)
+
+// logSampler rate limits the delivery errors while a sink is unreachable
+var logSampler = logging.NewSampler(30 * time.Second)
+
+const (
+	// queueSize is the number of events waiting for a sink before new
+	// ones are dropped, a slow sink never delays the transcription
+	queueSize = 1024
+	// initialBackoff is the delay before the first retry, it doubles on
+	// every attempt
+	initialBackoff = time.Second
+)
+
+// Config holds the delivery configuration
+type Config struct {
+	Retries        int    // Extra attempts after a failed delivery
+	DeadLetterFile string // JSON lines file receiving the undelivered events, disabled when empty
+}
+
+// Dispatcher fans the published events out to the sinks. Every sink has
+// its own queue and worker so the events reach it in order, failed
+// deliveries are retried with an exponential backoff then dead-lettered
+type Dispatcher struct {
+	config  Config
+	mu      sync.RWMutex
+	workers []*worker
+
+	deadLetterMu sync.Mutex
+}
+
+type worker struct {
+	sink  Sink
+	queue chan Event
+}
+
+// DeadLetter is a line of the dead letter file
+type DeadLetter struct {
+	Sink     string    `json:"sink"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+	Event    Event     `json:"event"`
+}
+
+// NewDispatcher creates a new Dispatcher without sinks
+func NewDispatcher(config Config) *Dispatcher {
+	return &Dispatcher{config: config}
+}
+
+// Add registers a sink, it receives the events published from now on
+func (d *Dispatcher) Add(sink Sink) {
+	w := &worker{
+		sink:  sink,
+		queue: make(chan Event, queueSize),
+	}
+	d.mu.Lock()
+	d.workers = append(d.workers, w)
+	d.mu.Unlock()
+	go d.run(w)
+}
+
+// Publish queues the event for every sink without blocking
+func (d *Dispatcher) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, w := range d.workers {
+		select {
+		case w.queue <- e:
+		default:
+			logSampler.Printf("events:queue-full:"+w.sink.Name(), "Event queue of %s is full, dropping %s event", w.sink.Name(), e.Type)
+		}
+	}
+}
+
+func (d *Dispatcher) run(w *worker) {
+	for e := range w.queue {
+		d.deliver(w.sink, e)
+	}
+}
+
+// deliver sends the event to the sink, retrying the failures that are
+// not permanent
+func (d *Dispatcher) deliver(sink Sink, e Event) {
+	backoff := initialBackoff
+	attempt := 0
+	for {
+		attempt++
+		err := sink.Send(e)
+		if err == nil {
+			return
+		}
+		if IsPermanent(err) || attempt > d.config.Retries {
+			logSampler.Printf("events:failed:"+sink.Name(), "Delivery of %s event to %s failed after %d attempts: %v", e.Type, sink.Name(), attempt, err)
+			d.deadLetter(sink, e, err, attempt)
+			return
+		}
+		logSampler.Printf("events:retry:"+sink.Name(), "Delivery of %s event to %s failed (attempt %d/%d): %v",
+			e.Type, sink.Name(), attempt, d.config.Retries+1, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// deadLetter appends the undelivered event to the dead letter file
+func (d *Dispatcher) deadLetter(sink Sink, e Event, err error, attempts int) {
+	if d.config.DeadLetterFile == "" {
+		return
+	}
+	line, marshalErr := json.Marshal(DeadLetter{
+		Sink:     sink.Name(),
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+		Event:    e,
+	})
+	if marshalErr != nil {
+		log.Printf("Error encoding dead letter: %v", marshalErr)
+		return
+	}
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+	if writeErr := appendLine(d.config.DeadLetterFile, line); writeErr != nil {
+		logSampler.Printf("events:dead-letter", "Error writing dead letter: %v", writeErr)
+	}
+}
+
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to append to %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Types of the events published for the transcription streams
+const (
+	SessionStarted = "session.started"
+	Segment        = "segment"       // A partial or final result
+	SessionEnded   = "session.ended" // Carries the Session summary
+)
+
+// Event is a notification fanned out to the sinks. The integrations
+// publishing their own events (alerts, Twilio calls, ...) set Payload to
+// the JSON document the sinks deliver as is
+type Event struct {
+	Type     string             `json:"type"`
+	Session  string             `json:"session,omitempty"`
+	User     string             `json:"user,omitempty"`
+	Language string             `json:"language,omitempty"`
+	Time     time.Time          `json:"time"`
+	Sequence int                `json:"sequence,omitempty"` // Index of the segment, partials share the index of the final segment they revise
+	Result   *transcribe.Result `json:"result,omitempty"`
+	Summary  *Session           `json:"summary,omitempty"`
+	Payload  json.RawMessage    `json:"payload,omitempty"`
+}
+
+// Session summarizes a finished transcription stream
+type Session struct {
+	ID         string
+	User       string
+	Language   string
+	Detected   string // Language detected by the vendor, "" when unknown
+	StartedAt  time.Time
+	EndedAt    time.Time
+	Duration   float64 // Seconds of audio received
+	Text       string  // Text of the final results
+	AudioFile  string
+	TextFile   string
+	Results    []transcribe.Result
+	Received   []float64           // Seconds of audio received when each result arrived
+	Markers    []transcribe.Marker // Named markers inserted during the session
+	Completion string              // How the session ended when abnormal, e.g. transcribe.CompletionClientLost
+}
+
+// Completed returns the summary of a session.ended event that produced
+// final results, the completion notifications are skipped otherwise
+func (e Event) Completed() (Session, bool) {
+	if e.Type != SessionEnded || e.Summary == nil || len(e.Summary.Results) == 0 {
+		return Session{}, false
+	}
+	return *e.Summary, true
+}
+
+// Streamed reports whether the message broker sinks send the event: the
+// session events and the final segments, the partial ones with partials
+func (e Event) Streamed(partials bool) bool {
+	switch e.Type {
+	case SessionStarted, SessionEnded:
+		return true
+	case Segment:
+		return e.Result.Final || partials
+	}
+	return false
+}
+
+// Topic replaces the {user} and {session} of a topic or subject template,
+// the user being anonymous for the streams without one. level, when set,
+// turns the names into a single level of the topic
+func (e Event) Topic(template string, level func(string) string) string {
+	user, session := e.User, e.Session
+	if user == "" {
+		user = "anonymous"
+	}
+	if level != nil {
+		user, session = level(user), level(session)
+	}
+	return strings.NewReplacer("{user}", user, "{session}", session).Replace(template)
+}
+
+// Sink delivers events to an external system. Sinks ignore the events
+// they are not interested in by returning nil
+type Sink interface {
+	Name() string
+	Send(e Event) error
+}
+
+// Publisher accepts events, the Dispatcher is the Publisher of the server
+type Publisher interface {
+	Publish(e Event)
+}
+
+// permanentError is a delivery failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a Send error as not worth retrying, e.g. a 4xx
+// response, the event is dead-lettered right away
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked by Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Service wraps a transcribe.Service and publishes the lifecycle and the
+// results of its streams
+type Service struct {
+	next      transcribe.Service
+	publisher Publisher
+}
+
+type stream struct {
+	next       transcribe.Stream
+	publisher  Publisher
+	results    <-chan transcribe.Result
+	mu         sync.Mutex
+	session    Session
+	audioBytes int
+	markers    *transcribe.Markers
+	completion *transcribe.Completion
+	texts      []string // Of the final results, owned by the forwarder
+}
+
+// NewService creates a new transcribe.Service publishing a
+// session.started event, a segment event per result and a session.ended
+// event with the summary of every stream
+func NewService(next transcribe.Service, publisher Publisher) transcribe.Service {
+	return &Service{
+		next:      next,
+		publisher: publisher,
+	}
+}
+
+// CreateStream creates a new published stream
+func (s *Service) CreateStream() (transcribe.Stream, error) {
+	next, err := s.next.CreateStream()
+	return s.wrap(next, err, transcribe.StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new published stream with the specified options
+func (s *Service) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	next, err := s.next.CreateStreamWithOptions(opts)
+	return s.wrap(next, err, opts)
+}
+
+func (s *Service) wrap(next transcribe.Stream, err error, opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	if err != nil {
+		return nil, err
+	}
+	id := opts.SessionID
+	if id == "" {
+		id = transcribe.NewSessionID()
+	}
+	st := &stream{
+		next:       next,
+		publisher:  s.publisher,
+		markers:    opts.Markers,
+		completion: opts.Completion,
+		session: Session{
+			ID:        id,
+			User:      opts.User,
+			Language:  opts.Language,
+			StartedAt: time.Now(),
+		},
+	}
+	st.publish(Event{Type: SessionStarted})
+	st.results = transcribe.ForwardResults(next, st.segment, st.end)
+	return st, nil
+}
+
+// segment publishes a result and adds the final ones to the summary
+func (st *stream) segment(result transcribe.Result) {
+	r := result
+	if !result.Final {
+		st.publish(Event{Type: Segment, Sequence: len(st.session.Results) + 1, Result: &r})
+		return
+	}
+	st.mu.Lock()
+	received := float64(st.audioBytes) / transcribe.PCMBytesPerSecond
+	st.mu.Unlock()
+	st.session.Results = append(st.session.Results, result)
+	st.session.Received = append(st.session.Received, received)
+	st.texts = append(st.texts, strings.TrimSpace(result.Text))
+	if result.AudioFile != "" {
+		st.session.AudioFile = result.AudioFile
+	}
+	if result.TextFile != "" {
+		st.session.TextFile = result.TextFile
+	}
+	if result.Language != "" && st.session.Detected == "" {
+		st.session.Detected = result.Language
+	}
+	st.publish(Event{Type: Segment, Sequence: len(st.session.Results), Result: &r})
+}
+
+// end publishes the summary once the results are forwarded
+func (st *stream) end() {
+	st.mu.Lock()
+	st.session.EndedAt = time.Now()
+	st.session.Duration = float64(st.audioBytes) / transcribe.PCMBytesPerSecond
+	st.session.Text = strings.Join(st.texts, " ")
+	st.session.Markers = st.markers.List()
+	st.session.Completion = st.completion.Reason()
+	summary := st.session
+	st.mu.Unlock()
+	st.publish(Event{Type: SessionEnded, Sequence: len(summary.Results), Summary: &summary})
+}
+
+func (st *stream) publish(e Event) {
+	e.Session = st.session.ID
+	e.User = st.session.User
+	e.Language = st.session.Language
+	e.Time = time.Now()
+	st.publisher.Publish(e)
+}
+
+// Results returns the results of the underlying stream
+func (st *stream) Results() <-chan transcribe.Result {
+	return st.results
+}
+
+// Write passes audio to the underlying stream
+func (st *stream) Write(buffer []byte) (int, error) {
+	n, err := st.next.Write(buffer)
+	st.mu.Lock()
+	st.audioBytes += n
+	st.mu.Unlock()
+	return n, err
+}
+
+// Close closes the underlying stream
+func (st *stream) Close() error {
+	return st.next.Close()
+}
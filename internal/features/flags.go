@@ -0,0 +1,135 @@
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Names of the experimental features that can be gated
+const (
+	ChunkedWhisper = "chunked_whisper"
+	VAD            = "vad"
+	Diarization    = "diarization"
+	RollingWhisper = "rolling_whisper"
+	Denoise        = "denoise"
+)
+
+// Known lists the feature flags understood by this build
+var Known = []string{ChunkedWhisper, VAD, Diarization, RollingWhisper, Denoise}
+
+// Flag is the state of a single feature flag, a flag is on for a user
+// when it is enabled for everyone or the user is listed in Users
+type Flag struct {
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Users   []string `json:"users,omitempty"`
+}
+
+// Set holds the feature flags of the deployment, it is safe for
+// concurrent use and can be updated at runtime
+type Set struct {
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// NewSet creates a new Set with every known flag disabled
+func NewSet() *Set {
+	flags := make(map[string]Flag)
+	for _, name := range Known {
+		flags[name] = Flag{Name: name}
+	}
+	return &Set{flags: flags}
+}
+
+// Parse creates a new Set from a spec such as
+// "vad=on, chunked_whisper=alice|walter, diarization=off"
+// where the value is on/off or a "|" separated list of users
+func Parse(spec string) (*Set, error) {
+	set := NewSet()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		flag := Flag{Name: strings.TrimSpace(parts[0]), Enabled: true}
+		if len(parts) == 2 {
+			value := strings.TrimSpace(parts[1])
+			switch strings.ToLower(value) {
+			case "on", "true", "1":
+				flag.Enabled = true
+			case "off", "false", "0":
+				flag.Enabled = false
+			default:
+				flag.Enabled = false
+				for _, user := range strings.Split(value, "|") {
+					if user = strings.TrimSpace(user); user != "" {
+						flag.Users = append(flag.Users, user)
+					}
+				}
+			}
+		}
+		if err := set.Update(flag); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Enabled reports whether the named feature is on for the user
+func (s *Set) Enabled(name, user string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	flag, exists := s.flags[name]
+	if !exists {
+		return false
+	}
+	if flag.Enabled {
+		return true
+	}
+	for _, u := range flag.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Update replaces the state of a known flag
+func (s *Set) Update(flag Flag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.flags[flag.Name]; !exists {
+		return fmt.Errorf("unknown feature flag: %s. Known flags: %s", flag.Name, strings.Join(Known, ", "))
+	}
+	s.flags[flag.Name] = flag
+	return nil
+}
+
+// List returns all flags sorted by name
+func (s *Set) List() []Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Flag, 0, len(s.flags))
+	for _, flag := range s.flags {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// ForUser returns the effective value of every flag for the user
+func (s *Set) ForUser(user string) map[string]bool {
+	values := make(map[string]bool)
+	for _, flag := range s.List() {
+		values[flag.Name] = s.Enabled(flag.Name, user)
+	}
+	return values
+}
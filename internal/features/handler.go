@@ -0,0 +1,59 @@
+package features
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// MakeHandler returns an HTTP handler serving the effective feature
+// flags of the authenticated user
+func MakeHandler(set *Set) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, set.ForUser(auth.UserFromContext(r.Context())))
+	})
+}
+
+// MakeAdminHandler returns an HTTP handler to inspect (GET) and change
+// (PUT or POST with a Flag body) the feature flags at runtime
+func MakeAdminHandler(set *Set) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, set.List())
+
+		case http.MethodPut, http.MethodPost:
+			flag := Flag{}
+			if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err := set.Update(flag); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Feature flag %s updated by %s (enabled: %v, users: %v)",
+				flag.Name, auth.UserFromContext(r.Context()), flag.Enabled, flag.Users)
+			writeJSON(w, set.List())
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
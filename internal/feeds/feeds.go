@@ -0,0 +1,240 @@
+package feeds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// defaultSegment is the length of the recordings and transcripts of a
+// feed when none is given
+const defaultSegment = 5 * time.Minute
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ErrStatic is returned when removing a feed configured by the flags
+var ErrStatic = errors.New("feed is configured by --feeds")
+
+// Feed is a registered audio URL transcribed continuously
+type Feed struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"` // rtsp://, rtsps://, http://, https:// (Icecast, HLS, ...) or rtp://<ip>:<port>, see parseRTP
+	Language  string    `json:"language,omitempty"`
+	User      string    `json:"user,omitempty"`            // Owner of the recordings
+	Segment   int       `json:"segment_seconds,omitempty"` // Length of the recordings
+	CreatedAt time.Time `json:"created_at"`
+	Static    bool      `json:"static,omitempty"` // Configured by --feeds, neither saved nor removable
+}
+
+// Status is a feed with the state of its connection
+type Status struct {
+	Feed
+	State     string    `json:"state"` // connecting, running, retrying
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Validate checks the feed and fills the defaults
+func (f *Feed) Validate() error {
+	if !validName.MatchString(f.Name) {
+		return fmt.Errorf("invalid feed name %q, use letters, digits, - and _", f.Name)
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return fmt.Errorf("invalid feed URL: %v", err)
+	}
+	switch u.Scheme {
+	case "rtsp", "rtsps", "http", "https":
+	case "rtp":
+		if _, err := parseRTP(u); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported feed URL scheme %q", u.Scheme)
+	}
+	if f.Language == "" {
+		f.Language = "auto"
+	}
+	if f.Segment <= 0 {
+		f.Segment = int(defaultSegment / time.Second)
+	}
+	if f.Segment < 30 {
+		return fmt.Errorf("segments must last at least 30 seconds")
+	}
+	return nil
+}
+
+// Manager runs the registered feeds and persists them in a JSON file
+type Manager struct {
+	service    transcribe.Service
+	ffmpegPath string
+	stateFile  string
+	mu         sync.Mutex
+	runners    map[string]*runner
+}
+
+// NewManager creates a new Manager and loads the feeds of stateFile, they
+// are started by Start
+func NewManager(service transcribe.Service, ffmpegPath, stateFile string) (*Manager, error) {
+	m := &Manager{
+		service:    service,
+		ffmpegPath: ffmpegPath,
+		stateFile:  stateFile,
+		runners:    make(map[string]*runner),
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var feeds []Feed
+	if err := json.Unmarshal(data, &feeds); err != nil {
+		return nil, fmt.Errorf("invalid feeds file %s: %w", stateFile, err)
+	}
+	for _, feed := range feeds {
+		m.runners[feed.Name] = newRunner(feed, m.service, m.ffmpegPath)
+	}
+	return m, nil
+}
+
+// Start connects to the loaded feeds
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.runners {
+		go r.run()
+	}
+	if len(m.runners) > 0 {
+		log.Printf("Started %d audio feeds", len(m.runners))
+	}
+}
+
+// Configure registers the feeds configured with the flags, they are
+// started by Start but neither saved nor removable with the admin API
+func (m *Manager) Configure(feeds []Feed) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, feed := range feeds {
+		if err := feed.Validate(); err != nil {
+			return err
+		}
+		if _, exists := m.runners[feed.Name]; exists {
+			return fmt.Errorf("feed %s already exists", feed.Name)
+		}
+		feed.Static = true
+		feed.CreatedAt = time.Now()
+		m.runners[feed.Name] = newRunner(feed, m.service, m.ffmpegPath)
+	}
+	return nil
+}
+
+// ParseFeeds parses the name=url,... feeds of the flags, in the language
+func ParseFeeds(spec, language string) ([]Feed, error) {
+	feeds := []Feed{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid feed %q, expected name=url", entry)
+		}
+		feeds = append(feeds, Feed{Name: parts[0], URL: parts[1], Language: language})
+	}
+	return feeds, nil
+}
+
+// Add registers and starts a feed
+func (m *Manager) Add(feed Feed) error {
+	if err := feed.Validate(); err != nil {
+		return err
+	}
+	feed.CreatedAt = time.Now()
+	feed.Static = false
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.runners[feed.Name]; exists {
+		return fmt.Errorf("feed %s already exists", feed.Name)
+	}
+	r := newRunner(feed, m.service, m.ffmpegPath)
+	m.runners[feed.Name] = r
+	if err := m.save(); err != nil {
+		delete(m.runners, feed.Name)
+		return err
+	}
+	go r.run()
+	log.Printf("Added audio feed %s: %s", feed.Name, feed.URL)
+	return nil
+}
+
+// Remove stops and unregisters a feed, it returns false when not found
+func (m *Manager) Remove(name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.runners[name]
+	if !ok {
+		return false, nil
+	}
+	if r.feed.Static {
+		return true, ErrStatic
+	}
+	delete(m.runners, name)
+	r.stop()
+	log.Printf("Removed audio feed %s", name)
+	return true, m.save()
+}
+
+// List returns the status of the feeds sorted by name
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := []Status{}
+	for _, r := range m.runners {
+		statuses = append(statuses, r.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// save writes the feeds to the state file, the lock must be held
+func (m *Manager) save() error {
+	feeds := []Feed{}
+	for _, r := range m.runners {
+		if !r.feed.Static {
+			feeds = append(feeds, r.feed)
+		}
+	}
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].Name < feeds[j].Name
+	})
+	data, err := json.MarshalIndent(feeds, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to save feeds: %w", err)
+	}
+	tmp := m.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save feeds: %w", err)
+	}
+	return os.Rename(tmp, m.stateFile)
+}
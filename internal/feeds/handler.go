@@ -0,0 +1,69 @@
+package feeds
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// MakeAdminHandler returns an HTTP handler to list (GET), register (POST
+// with a Feed body) and remove (DELETE ?name=) the audio feeds
+func MakeAdminHandler(m *Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, m.List())
+
+		case http.MethodPost:
+			feed := Feed{}
+			if err := json.NewDecoder(r.Body).Decode(&feed); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if feed.User == "" {
+				feed.User = auth.UserFromContext(r.Context())
+			}
+			if err := m.Add(feed); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Audio feed %s registered by %s", feed.Name, auth.UserFromContext(r.Context()))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, m.List())
+
+		case http.MethodDelete:
+			name := r.URL.Query().Get("name")
+			found, err := m.Remove(name)
+			if !found {
+				http.Error(w, "Feed not found", http.StatusNotFound)
+				return
+			}
+			if err == ErrStatic {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Audio feed %s removed by %s", name, auth.UserFromContext(r.Context()))
+			writeJSON(w, m.List())
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,100 @@
+package feeds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultDynamicType is the payload type of the RTP feeds with a codec and
+// no pt, the first dynamic type
+const defaultDynamicType = 96
+
+// validCodec matches the encodings of an SDP rtpmap, e.g. opus/48000/2
+var validCodec = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[0-9]+(/[0-9]+)?$`)
+
+// rtpInput is the RTP stream received by a feed, described to ffmpeg by
+// an SDP since RTP carries no codec
+type rtpInput struct {
+	ip          net.IP
+	port        int
+	payloadType int
+	codec       string // rtpmap encoding, empty for the static payload types
+}
+
+// parseRTP parses the rtp://<ip>:<port>?pt=<type>&codec=<encoding> URL of
+// a feed: the local or multicast address the audio is received on, the
+// payload type (0, PCMU, by default) and for the dynamic payload types the
+// encoding, e.g. opus/48000/2 or L16/16000/1
+func parseRTP(u *url.URL) (*rtpInput, error) {
+	input := &rtpInput{ip: net.IPv4zero}
+	if host := u.Hostname(); host != "" && host != "@" {
+		if input.ip = net.ParseIP(host); input.ip == nil {
+			return nil, fmt.Errorf("RTP feeds listen on an IP address, not %q", host)
+		}
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("RTP feeds need a port, e.g. rtp://0.0.0.0:5004")
+	}
+	input.port = port
+
+	query := u.Query()
+	input.codec = query.Get("codec")
+	if input.codec != "" && !validCodec.MatchString(input.codec) {
+		return nil, fmt.Errorf("invalid RTP codec %q, e.g. opus/48000/2", input.codec)
+	}
+	input.payloadType = 0
+	if input.codec != "" {
+		input.payloadType = defaultDynamicType
+	}
+	if pt := query.Get("pt"); pt != "" {
+		if input.payloadType, err = strconv.Atoi(pt); err != nil || input.payloadType < 0 || input.payloadType > 127 {
+			return nil, fmt.Errorf("invalid RTP payload type %q", pt)
+		}
+	}
+	if input.payloadType >= defaultDynamicType && input.codec == "" {
+		return nil, fmt.Errorf("the dynamic RTP payload type %d needs a codec", input.payloadType)
+	}
+	return input, nil
+}
+
+// sdp returns the session description of the input
+func (in *rtpInput) sdp() string {
+	family := "IP4"
+	if in.ip.To4() == nil {
+		family = "IP6"
+	}
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- 0 0 IN %s %s", family, in.ip),
+		"s=feed",
+		fmt.Sprintf("c=IN %s %s", family, in.ip),
+		"t=0 0",
+		fmt.Sprintf("m=audio %d RTP/AVP %d", in.port, in.payloadType),
+	}
+	if in.codec != "" {
+		lines = append(lines, fmt.Sprintf("a=rtpmap:%d %s", in.payloadType, in.codec))
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// writeSDP writes the session description to a temporary file, removed by
+// the caller
+func (in *rtpInput) writeSDP() (string, error) {
+	file, err := ioutil.TempFile("", "feed-*.sdp")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(in.sdp()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,249 @@
+package feeds
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the errors of unreachable feeds
+var logSampler = logging.NewSampler(time.Minute)
+
+const (
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 2 * time.Minute
+	// stableAfter resets the retry delay of a connection that lasted
+	stableAfter = time.Minute
+)
+
+// runner pulls a feed through ffmpeg and transcribes it in segments
+type runner struct {
+	feed       Feed
+	service    transcribe.Service
+	ffmpegPath string
+	done       chan struct{}
+	stopOnce   sync.Once
+
+	mu        sync.Mutex
+	state     string
+	since     time.Time
+	lastError string
+	cmd       *exec.Cmd
+}
+
+func newRunner(feed Feed, service transcribe.Service, ffmpegPath string) *runner {
+	return &runner{
+		feed:       feed,
+		service:    service,
+		ffmpegPath: ffmpegPath,
+		done:       make(chan struct{}),
+		state:      "connecting",
+		since:      time.Now(),
+	}
+}
+
+func (r *runner) status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Status{
+		Feed:      r.feed,
+		State:     r.state,
+		Since:     r.since,
+		LastError: r.lastError,
+	}
+}
+
+func (r *runner) setState(state, lastError string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != state {
+		r.state = state
+		r.since = time.Now()
+	}
+	if lastError != "" {
+		r.lastError = lastError
+	}
+}
+
+// stop ends the connection and the retries
+func (r *runner) stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		r.mu.Lock()
+		if r.cmd != nil && r.cmd.Process != nil {
+			r.cmd.Process.Kill()
+		}
+		r.mu.Unlock()
+	})
+}
+
+// run connects to the feed until it is stopped, reconnecting with an
+// exponential backoff
+func (r *runner) run() {
+	delay := minRetryDelay
+	for {
+		started := time.Now()
+		r.setState("connecting", "")
+		err := r.pull()
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		if err == nil {
+			err = fmt.Errorf("feed ended")
+		}
+		logSampler.Printf("feeds:"+r.feed.Name, "Audio feed %s disconnected: %v", r.feed.Name, err)
+		r.setState("retrying", err.Error())
+
+		if time.Since(started) > stableAfter {
+			delay = minRetryDelay
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// pull runs ffmpeg until the feed ends or fails
+func (r *runner) pull() error {
+	input := r.feed.URL
+	if u, err := url.Parse(r.feed.URL); err == nil && u.Scheme == "rtp" {
+		rtp, err := parseRTP(u)
+		if err != nil {
+			return err
+		}
+		if input, err = rtp.writeSDP(); err != nil {
+			return fmt.Errorf("failed to write the SDP of the feed: %w", err)
+		}
+		defer os.Remove(input)
+	}
+	cmd := exec.Command(r.ffmpegPath, r.ffmpegArgs(input)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	select {
+	case <-r.done:
+		r.mu.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	r.cmd = cmd
+	r.mu.Unlock()
+
+	readErr := r.transcribe(stdout)
+	if readErr != nil {
+		// ffmpeg would block on the unread output
+		cmd.Process.Kill()
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return readErr
+}
+
+// ffmpegArgs returns the arguments of ffmpeg decoding input, the URL of
+// the feed or the SDP file of an RTP feed
+func (r *runner) ffmpegArgs(input string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	switch {
+	case strings.HasPrefix(r.feed.URL, "rtsp"):
+		args = append(args, "-rtsp_transport", "tcp", "-rw_timeout", "15000000")
+	case strings.HasPrefix(r.feed.URL, "rtp"):
+		// Intercoms only send while in use, the port is not timed out
+		args = append(args, "-protocol_whitelist", "file,udp,rtp")
+	default:
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "10", "-rw_timeout", "15000000")
+	}
+	return append(args,
+		"-i", input,
+		"-vn", "-f", "s16le", "-acodec", "pcm_s16le",
+		"-ac", "1", "-ar", fmt.Sprint(transcribe.PipelineSampleRate),
+		"pipe:1")
+}
+
+// transcribe writes the decoded audio to a new stream every segment
+func (r *runner) transcribe(pcm io.Reader) error {
+	segment := time.Duration(r.feed.Segment) * time.Second
+	buffer := make([]byte, 9600) // 100ms of audio
+	var stream transcribe.Stream
+	var segmentEnd time.Time
+	defer func() {
+		if stream != nil {
+			go closeStream(stream)
+		}
+	}()
+
+	for {
+		n, err := io.ReadFull(pcm, buffer)
+		if n > 0 {
+			if stream == nil || time.Now().After(segmentEnd) {
+				if stream != nil {
+					// Closing may wait for a batch transcription
+					go closeStream(stream)
+				}
+				var createErr error
+				stream, createErr = r.service.CreateStreamWithOptions(transcribe.StreamOptions{
+					Language:   r.feed.Language,
+					Transcribe: true,
+					User:       r.feed.User,
+				})
+				if createErr != nil {
+					return fmt.Errorf("failed to create transcription stream: %w", createErr)
+				}
+				go drainResults(stream)
+				segmentEnd = time.Now().Add(segment)
+				r.setState("running", "")
+			}
+			if _, err := stream.Write(buffer[:n]); err != nil {
+				logSampler.Printf("feeds:write", "Error writing feed %s to transcription stream: %v", r.feed.Name, err)
+			}
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func closeStream(stream transcribe.Stream) {
+	if err := stream.Close(); err != nil {
+		log.Printf("Error closing feed stream: %v", err)
+	}
+}
+
+// drainResults consumes the results, they reach the transcripts, webhooks
+// and live subscribers through the service
+func drainResults(stream transcribe.Stream) {
+	for range stream.Results() {
+	}
+}
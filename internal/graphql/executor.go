@@ -0,0 +1,312 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Resolver computes the value of a field from its parent value
+type Resolver func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// Field is a field of an object type, Type names the object type of the
+// value (or of the list elements) and is empty for scalars
+type Field struct {
+	Type    string
+	Resolve Resolver
+}
+
+// Object is an object type of the schema
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Schema holds the object types, Query and Mutation are the root types
+type Schema struct {
+	Types map[string]*Object
+}
+
+// Request is the body of a GraphQL HTTP request
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a request or field error
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Execute parses and executes the request against the schema
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	root, ok := s.Types[rootTypes[op.kind]]
+	if !ok {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("schema does not support %s operations", op.kind)}}}
+	}
+
+	variables := make(map[string]interface{})
+	for _, def := range op.variables {
+		if v, ok := req.Variables[def.name]; ok {
+			variables[def.name] = v
+		} else if def.defaultValue != nil {
+			variables[def.name] = def.defaultValue
+		}
+	}
+
+	e := &executor{schema: s, doc: doc, variables: variables}
+	data := e.selectionSet(ctx, root, nil, op.selections, nil)
+	return &Response{Data: data, Errors: e.errors}
+}
+
+var rootTypes = map[string]string{
+	"query":    "Query",
+	"mutation": "Mutation",
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("operationName is required for documents with several operations")
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type executor struct {
+	schema    *Schema
+	doc       *document
+	variables map[string]interface{}
+	errors    []*Error
+}
+
+func (e *executor) fail(path []interface{}, format string, args ...interface{}) {
+	e.errors = append(e.errors, &Error{
+		Message: fmt.Sprintf(format, args...),
+		Path:    append([]interface{}{}, path...),
+	})
+}
+
+// selectionSet resolves the selections on a value of the object type
+func (e *executor) selectionSet(ctx context.Context, object *Object, source interface{}, selections []selection, path []interface{}) *orderedMap {
+	result := &orderedMap{}
+	groups := &fieldGroups{}
+	e.collectFields(object, selections, groups, make(map[string]bool))
+	for _, key := range groups.keys {
+		fields := groups.fields[key]
+		f := fields[0]
+		fieldPath := append(path, key)
+
+		if f.name == "__typename" {
+			result.set(key, object.Name)
+			continue
+		}
+		def, ok := object.Fields[f.name]
+		if !ok {
+			e.fail(fieldPath, "cannot query field %q on type %q", f.name, object.Name)
+			result.set(key, nil)
+			continue
+		}
+		args := make(map[string]interface{}, len(f.arguments))
+		for name, v := range f.arguments {
+			args[name] = e.resolveValue(v)
+		}
+		v, err := def.Resolve(ctx, source, args)
+		if err != nil {
+			e.fail(fieldPath, "%v", err)
+			result.set(key, nil)
+			continue
+		}
+		result.set(key, e.complete(ctx, def, fields, v, fieldPath))
+	}
+	return result
+}
+
+// fieldGroups holds the fields of a selection set by response key, in the
+// order of their first selection
+type fieldGroups struct {
+	keys   []string
+	fields map[string][]*field
+}
+
+func (g *fieldGroups) add(f *field) {
+	key := f.name
+	if f.alias != "" {
+		key = f.alias
+	}
+	if g.fields == nil {
+		g.fields = make(map[string][]*field)
+	}
+	if _, ok := g.fields[key]; !ok {
+		g.keys = append(g.keys, key)
+	}
+	g.fields[key] = append(g.fields[key], f)
+}
+
+// collectFields groups the fields by response key, flattening the fragments
+// and applying @skip and @include. As in the CollectFields algorithm of the
+// specification, a fragment is spread once per selection set
+func (e *executor) collectFields(object *Object, selections []selection, groups *fieldGroups, visited map[string]bool) {
+	for _, sel := range selections {
+		switch s := sel.(type) {
+		case *field:
+			if e.included(s.directives) {
+				groups.add(s)
+			}
+		case *inlineFragment:
+			if e.included(s.directives) && (s.typeCondition == "" || s.typeCondition == object.Name) {
+				e.collectFields(object, s.selections, groups, visited)
+			}
+		case *fragmentSpread:
+			if visited[s.name] || !e.included(s.directives) {
+				continue
+			}
+			visited[s.name] = true
+			if f, ok := e.doc.fragments[s.name]; ok && f.typeCondition == object.Name {
+				e.collectFields(object, f.selections, groups, visited)
+			}
+		}
+	}
+}
+
+// subselections merges the selection sets of the fields of a response key
+func subselections(fields []*field) []selection {
+	var selections []selection
+	for _, f := range fields {
+		selections = append(selections, f.selections...)
+	}
+	return selections
+}
+
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		condition, _ := e.resolveValue(d.arguments["if"]).(bool)
+		if d.name == "skip" && condition || d.name == "include" && !condition {
+			return false
+		}
+	}
+	return true
+}
+
+// complete converts a resolved value into its response value, fields are
+// the fields of the response key
+func (e *executor) complete(ctx context.Context, def *Field, fields []*field, v interface{}, path []interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.IsNil() {
+		if rv.Kind() == reflect.Slice {
+			return []interface{}{}
+		}
+		return nil
+	}
+	if rv.Kind() == reflect.Slice && def.Type != "" {
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = e.complete(ctx, def, fields, rv.Index(i).Interface(), append(path, i))
+		}
+		return list
+	}
+	selections := subselections(fields)
+	if def.Type == "" {
+		if len(selections) > 0 {
+			e.fail(path, "field %q is a scalar and has no subfields", fields[0].name)
+			return nil
+		}
+		return v
+	}
+
+	object, ok := e.schema.Types[def.Type]
+	if !ok {
+		e.fail(path, "unknown type %q", def.Type)
+		return nil
+	}
+	if len(selections) == 0 {
+		e.fail(path, "field %q of type %q must have a selection of subfields", fields[0].name, def.Type)
+		return nil
+	}
+	return e.selectionSet(ctx, object, v, selections, path)
+}
+
+// resolveValue replaces the variable references of an argument value
+func (e *executor) resolveValue(v value) interface{} {
+	switch v := v.(type) {
+	case variableRef:
+		return e.variables[string(v)]
+	case enumValue:
+		return string(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.resolveValue(item)
+		}
+		return list
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			object[name] = e.resolveValue(item)
+		}
+		return object
+	}
+	return v
+}
+
+// orderedMap is a JSON object keeping the order of the selections
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, v interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	m.keys = append(m.keys, key)
+	m.values[key] = v
+}
+
+// MarshalJSON encodes the map with its keys in insertion order
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,202 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSchema is a small schema with a nested object and a list
+func testSchema() *Schema {
+	type book struct {
+		title  string
+		author string
+	}
+	books := []book{{"Dune", "Herbert"}, {"Emma", "Austen"}}
+	constant := func(v interface{}) Resolver {
+		return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return v, nil
+		}
+	}
+	return &Schema{Types: map[string]*Object{
+		"Query": {Name: "Query", Fields: map[string]*Field{
+			"books": {Type: "Book", Resolve: constant(books)},
+			"echo": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return args["value"], nil
+			}},
+		}},
+		"Book": {Name: "Book", Fields: map[string]*Field{
+			"title": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(book).title, nil
+			}},
+			"author": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(book).author, nil
+			}},
+		}},
+	}}
+}
+
+func execute(t *testing.T, query string, variables map[string]interface{}) (string, []*Error) {
+	t.Helper()
+	resp := testSchema().Execute(context.Background(), Request{Query: query, Variables: variables})
+	if resp.Data == nil {
+		return "", resp.Errors
+	}
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), resp.Errors
+}
+
+func TestParse(t *testing.T) {
+	doc, err := parse(`
+		# A comment
+		query Books($limit: [Int!]! = [1, 2]) @cached {
+			first: books(filter: {title: "Dune", tags: [A, B]}) { title }
+			... on Query { echo(value: $limit) }
+			...Titles
+		}
+		fragment Titles on Query { books { title } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 1 || len(doc.fragments) != 1 {
+		t.Fatalf("got %d operations and %d fragments", len(doc.operations), len(doc.fragments))
+	}
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Books" || len(op.variables) != 1 || len(op.selections) != 3 {
+		t.Fatalf("unexpected operation %+v", op)
+	}
+	if list, ok := op.variables[0].defaultValue.([]interface{}); !ok || len(list) != 2 {
+		t.Errorf("default value = %v", op.variables[0].defaultValue)
+	}
+	first := op.selections[0].(*field)
+	if first.alias != "first" || first.name != "books" {
+		t.Errorf("alias %q, name %q", first.alias, first.name)
+	}
+	filter := first.arguments["filter"].(map[string]interface{})
+	if filter["title"] != "Dune" || len(filter["tags"].([]interface{})) != 2 {
+		t.Errorf("filter = %v", filter)
+	}
+	if _, ok := op.selections[1].(*inlineFragment); !ok {
+		t.Errorf("second selection is %T", op.selections[1])
+	}
+	if spread, ok := op.selections[2].(*fragmentSpread); !ok || spread.name != "Titles" {
+		t.Errorf("third selection is %#v", op.selections[2])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`{`,
+		`{ }`,
+		`{ books { title }`,
+		`query { echo(value: "unterminated) }`,
+		`{ ...Missing }`,
+		`{ books { ...A } } fragment A on Book { title } fragment A on Book { author }`,
+	} {
+		if _, err := parse(query); err == nil {
+			t.Errorf("parse(%q) succeeded", query)
+		}
+	}
+}
+
+func TestParseRejectsFragmentCycles(t *testing.T) {
+	for _, query := range []string{
+		`query { ...A } fragment A on Query { ...A }`,
+		`query { ...A } fragment A on Query { books { ...B } } fragment B on Book { ... on Book { ...A } }`,
+		// Cycles are rejected even in the fragments not used by the operation
+		`query { books { title } } fragment A on Query { ...B } fragment B on Query { ...A }`,
+	} {
+		_, err := parse(query)
+		if err == nil || !strings.Contains(err.Error(), "spreads itself") {
+			t.Errorf("parse(%q) = %v, want a fragment cycle error", query, err)
+		}
+	}
+	if _, errs := execute(t, `query { ...A } fragment A on Query { ...A }`, nil); len(errs) != 1 {
+		t.Errorf("got errors %v", errs)
+	}
+}
+
+func TestParseRejectsDeepNesting(t *testing.T) {
+	for _, query := range []string{
+		`{ echo(value: ` + strings.Repeat("[", 8<<20) + `) }`,
+		`{ echo(value: ` + strings.Repeat("{a: ", 1000) + `1` + strings.Repeat("}", 1000) + `) }`,
+		strings.Repeat("{ books ", 1000) + strings.Repeat("}", 1000),
+		`query ($v: ` + strings.Repeat("[", 1000) + `Int` + strings.Repeat("]", 1000) + `) { echo(value: $v) }`,
+	} {
+		_, err := parse(query)
+		if err == nil || !strings.Contains(err.Error(), "nesting deeper") {
+			t.Errorf("parse of %d bytes = %v, want a nesting error", len(query), err)
+		}
+	}
+	if _, err := parse(`{ echo(value: ` + strings.Repeat("[", maxDepth-1) + strings.Repeat("]", maxDepth-1) + `) }`); err != nil {
+		t.Errorf("nesting of %d levels: %v", maxDepth, err)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	data, errs := execute(t, `query ($v: String) {
+		all: books { title }
+		echo(value: $v)
+		__typename
+	}`, map[string]interface{}{"v": "hello"})
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs[0].Message)
+	}
+	want := `{"all":[{"title":"Dune"},{"title":"Emma"}],"echo":"hello","__typename":"Query"}`
+	if data != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestExecuteMergesResponseKeys(t *testing.T) {
+	data, errs := execute(t, `{
+		books { title }
+		...Authors
+		books @skip(if: true) { title author }
+	}
+	fragment Authors on Query { books { author } }`, nil)
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs[0].Message)
+	}
+	want := `{"books":[{"title":"Dune","author":"Herbert"},{"title":"Emma","author":"Austen"}]}`
+	if data != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestExecuteFieldErrors(t *testing.T) {
+	data, errs := execute(t, `{ books { title missing } echo(value: 1) { x } }`, nil)
+	if len(errs) != 3 {
+		t.Fatalf("got %d errors, want 3", len(errs))
+	}
+	if errs[0].Path[0] != "books" || errs[0].Path[1] != 0 || errs[0].Path[2] != "missing" {
+		t.Errorf("path = %v", errs[0].Path)
+	}
+	want := `{"books":[{"title":"Dune","missing":null},{"title":"Emma","missing":null}],"echo":null}`
+	if data != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestHandlerLimitsBody(t *testing.T) {
+	handler := MakeHandler(testSchema())
+	body := `{"query":"{ echo(value: \"` + strings.Repeat("x", maxRequestSize) + `\") }"}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ echo(value: 1) }"}`)))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"data":{"echo":1}}` {
+		t.Errorf("status %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,51 @@
+package graphql
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// maxRequestSize bounds the body of the POST requests
+const maxRequestSize = 1 << 20
+
+// MakeHandler returns an HTTP handler executing GraphQL requests, queries
+// are accepted with GET and POST, mutations only with POST
+func MakeHandler(schema *Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		switch r.Method {
+		case http.MethodGet:
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if variables := r.URL.Query().Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					http.Error(w, "Invalid variables", http.StatusBadRequest)
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		doc, err := parse(req.Query)
+		if err == nil && r.Method == http.MethodGet {
+			if op, err := selectOperation(doc, req.OperationName); err == nil && op.kind == "mutation" {
+				http.Error(w, "Mutations require POST", http.StatusMethodNotAllowed)
+				return
+			}
+		}
+
+		resp := schema.Execute(r.Context(), req)
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Data == nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,527 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed GraphQL request document
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query or mutation
+	name       string
+	variables  []*variableDefinition
+	selections []selection
+}
+
+type variableDefinition struct {
+	name         string
+	defaultValue value
+}
+
+type fragment struct {
+	typeCondition string
+	selections    []selection
+}
+
+// selection is a *field, *fragmentSpread or *inlineFragment
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	arguments  map[string]value
+	directives []*directive
+	selections []selection
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+type inlineFragment struct {
+	typeCondition string
+	directives    []*directive
+	selections    []selection
+}
+
+type directive struct {
+	name      string
+	arguments map[string]value
+}
+
+// maxDepth bounds the nesting of the selection sets, values and types of a
+// document, the parser recurses on each level
+const maxDepth = 64
+
+// value is a literal, a variable reference, a list or an object
+type value interface{}
+
+type variableRef string
+
+type enumValue string
+
+// parse parses a request document, only executable definitions are supported
+func parse(source string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if syntaxErr, ok := r.(syntaxError); ok {
+				doc, err = nil, syntaxErr
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	p := &parser{lexer: lexer{source: strings.TrimPrefix(source, "\ufeff")}}
+	p.advance()
+	doc = &document{fragments: make(map[string]*fragment)}
+	for p.token.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunct, "{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: p.selectionSet()})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peek(tokenName, "fragment"):
+			p.advance()
+			name := p.expect(tokenName, "").text
+			p.expect(tokenName, "on")
+			f := &fragment{typeCondition: p.expect(tokenName, "").text}
+			p.directives()
+			f.selections = p.selectionSet()
+			if _, ok := doc.fragments[name]; ok {
+				p.fail("fragment %q is defined twice", name)
+			}
+			doc.fragments[name] = f
+		default:
+			p.fail("unexpected %q", p.token.text)
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail("document has no operation")
+	}
+	if err := validateFragments(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// validateFragments rejects the spreads of unknown fragments and the
+// fragments spreading themselves, directly or through other fragments
+func validateFragments(doc *document) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(selections []selection) error
+	visit = func(selections []selection) error {
+		for _, sel := range selections {
+			var err error
+			switch s := sel.(type) {
+			case *field:
+				err = visit(s.selections)
+			case *inlineFragment:
+				err = visit(s.selections)
+			case *fragmentSpread:
+				f, ok := doc.fragments[s.name]
+				switch {
+				case !ok:
+					return fmt.Errorf("unknown fragment %q", s.name)
+				case state[s.name] == visiting:
+					return fmt.Errorf("fragment %q spreads itself", s.name)
+				case state[s.name] == visited:
+					continue
+				}
+				state[s.name] = visiting
+				err = visit(f.selections)
+				state[s.name] = visited
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, op := range doc.operations {
+		if err := visit(op.selections); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(doc.fragments))
+	for name := range doc.fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit([]selection{&fragmentSpread{name: name}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type syntaxError struct {
+	message string
+}
+
+func (e syntaxError) Error() string {
+	return e.message
+}
+
+type parser struct {
+	lexer lexer
+	token token
+	depth int
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(syntaxError{fmt.Sprintf("syntax error at %d: %s", p.token.pos, fmt.Sprintf(format, args...))})
+}
+
+// nest enters a nested selection set, value or type, it fails deeper than
+// maxDepth. The caller leaves it with unnest
+func (p *parser) nest() {
+	if p.depth++; p.depth > maxDepth {
+		p.fail("nesting deeper than %d levels", maxDepth)
+	}
+}
+
+func (p *parser) unnest() {
+	p.depth--
+}
+
+func (p *parser) advance() {
+	t, err := p.lexer.next()
+	if err != nil {
+		panic(syntaxError{err.Error()})
+	}
+	p.token = t
+}
+
+func (p *parser) peek(kind tokenKind, text string) bool {
+	return p.token.kind == kind && (text == "" || p.token.text == text)
+}
+
+func (p *parser) skip(kind tokenKind, text string) bool {
+	if p.peek(kind, text) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) token {
+	t := p.token
+	if !p.peek(kind, text) {
+		if text == "" {
+			text = kind.String()
+		}
+		p.fail("expected %s, found %q", text, t.text)
+	}
+	p.advance()
+	return t
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.expect(tokenName, "").text}
+	if p.peek(tokenName, "") {
+		op.name = p.expect(tokenName, "").text
+	}
+	if p.skip(tokenPunct, "(") {
+		for !p.skip(tokenPunct, ")") {
+			p.expect(tokenPunct, "$")
+			def := &variableDefinition{name: p.expect(tokenName, "").text}
+			p.expect(tokenPunct, ":")
+			p.typeRef()
+			if p.skip(tokenPunct, "=") {
+				def.defaultValue = p.value(true)
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+// typeRef skips a variable type, values are coerced by the resolvers
+func (p *parser) typeRef() {
+	p.nest()
+	defer p.unnest()
+	if p.skip(tokenPunct, "[") {
+		p.typeRef()
+		p.expect(tokenPunct, "]")
+	} else {
+		p.expect(tokenName, "")
+	}
+	p.skip(tokenPunct, "!")
+}
+
+func (p *parser) selectionSet() []selection {
+	p.nest()
+	defer p.unnest()
+	p.expect(tokenPunct, "{")
+	var selections []selection
+	for !p.skip(tokenPunct, "}") {
+		if p.skip(tokenPunct, "...") {
+			if p.peek(tokenName, "") && p.token.text != "on" {
+				spread := &fragmentSpread{name: p.expect(tokenName, "").text}
+				spread.directives = p.directives()
+				selections = append(selections, spread)
+				continue
+			}
+			inline := &inlineFragment{}
+			if p.skip(tokenName, "on") {
+				inline.typeCondition = p.expect(tokenName, "").text
+			}
+			inline.directives = p.directives()
+			inline.selections = p.selectionSet()
+			selections = append(selections, inline)
+			continue
+		}
+
+		f := &field{name: p.expect(tokenName, "").text}
+		if p.skip(tokenPunct, ":") {
+			f.alias = f.name
+			f.name = p.expect(tokenName, "").text
+		}
+		f.arguments = p.arguments()
+		f.directives = p.directives()
+		if p.peek(tokenPunct, "{") {
+			f.selections = p.selectionSet()
+		}
+		selections = append(selections, f)
+	}
+	if len(selections) == 0 {
+		p.fail("empty selection set")
+	}
+	return selections
+}
+
+func (p *parser) arguments() map[string]value {
+	args := make(map[string]value)
+	if p.skip(tokenPunct, "(") {
+		for !p.skip(tokenPunct, ")") {
+			name := p.expect(tokenName, "").text
+			p.expect(tokenPunct, ":")
+			args[name] = p.value(false)
+		}
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var directives []*directive
+	for p.skip(tokenPunct, "@") {
+		d := &directive{name: p.expect(tokenName, "").text}
+		d.arguments = p.arguments()
+		directives = append(directives, d)
+	}
+	return directives
+}
+
+func (p *parser) value(constant bool) value {
+	p.nest()
+	defer p.unnest()
+	t := p.token
+	switch {
+	case !constant && p.skip(tokenPunct, "$"):
+		return variableRef(p.expect(tokenName, "").text)
+	case p.skip(tokenPunct, "["):
+		list := []interface{}{}
+		for !p.skip(tokenPunct, "]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip(tokenPunct, "{"):
+		object := make(map[string]interface{})
+		for !p.skip(tokenPunct, "}") {
+			name := p.expect(tokenName, "").text
+			p.expect(tokenPunct, ":")
+			object[name] = p.value(constant)
+		}
+		return object
+	case p.skip(tokenString, ""):
+		return t.text
+	case p.skip(tokenInt, ""):
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			p.fail("invalid integer %s", t.text)
+		}
+		return n
+	case p.skip(tokenFloat, ""):
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.fail("invalid float %s", t.text)
+		}
+		return f
+	case p.skip(tokenName, ""):
+		switch t.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(t.text)
+	}
+	p.fail("unexpected %q", t.text)
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenPunct:
+		return "punctuator"
+	case tokenName:
+		return "name"
+	case tokenInt, tokenFloat:
+		return "number"
+	case tokenString:
+		return "string"
+	}
+	return "end of document"
+}
+
+type token struct {
+	kind tokenKind
+	text string // String tokens hold the unescaped value
+	pos  int
+}
+
+type lexer struct {
+	source string
+	pos    int
+}
+
+func (l *lexer) next() (token, error) {
+	// Skip white space, commas and comments
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+		if c == '#' {
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.pos++
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+		} else {
+			break
+		}
+	}
+	start := l.pos
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+
+	c := l.source[l.pos]
+	switch {
+	case strings.HasPrefix(l.source[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunct, text: "...", pos: start}, nil
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, text: string(c), pos: start}, nil
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for l.pos < len(l.source) && isNameChar(l.source[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, text: l.source[start:l.pos], pos: start}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		kind := tokenInt
+		l.pos++
+		for l.pos < len(l.source) {
+			d := l.source[l.pos]
+			if d == '.' || d == 'e' || d == 'E' || (d == '+' || d == '-') && kind == tokenFloat {
+				kind = tokenFloat
+			} else if d < '0' || d > '9' {
+				break
+			}
+			l.pos++
+		}
+		return token{kind: kind, text: l.source[start:l.pos], pos: start}, nil
+	case strings.HasPrefix(l.source[l.pos:], `"""`):
+		end := strings.Index(l.source[l.pos+3:], `"""`)
+		if end < 0 {
+			return token{}, fmt.Errorf("syntax error at %d: unterminated block string", start)
+		}
+		l.pos += 3 + end + 3
+		return token{kind: tokenString, text: strings.TrimSpace(l.source[start+3 : l.pos-3]), pos: start}, nil
+	case c == '"':
+		return l.string()
+	}
+	return token{}, fmt.Errorf("syntax error at %d: unexpected character %q", start, c)
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, text: b.String(), pos: start}, nil
+		case c == '\n':
+			return token{}, fmt.Errorf("syntax error at %d: unterminated string", start)
+		case c == '\\' && l.pos+1 < len(l.source):
+			escape := l.source[l.pos+1]
+			l.pos += 2
+			switch escape {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				if l.pos+4 > len(l.source) {
+					return token{}, fmt.Errorf("syntax error at %d: invalid unicode escape", l.pos)
+				}
+				r, err := strconv.ParseUint(l.source[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, fmt.Errorf("syntax error at %d: invalid unicode escape", l.pos)
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				b.WriteByte(escape)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.source[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+	return token{}, fmt.Errorf("syntax error at %d: unterminated string", start)
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
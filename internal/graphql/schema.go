@@ -0,0 +1,330 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/catalog"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Users lists the configured accounts and tells which ones are admins
+type Users interface {
+	Usernames() []string
+	IsAdmin(username string) bool
+}
+
+// user is the value of the User type
+type user struct {
+	name  string
+	admin bool
+}
+
+// file is the value of the Recording and Transcript types
+type file struct {
+	*catalog.File
+	session *catalog.Session
+}
+
+// segment is the value of the Segment type
+type segment struct {
+	index int
+	text  string
+}
+
+// resolver resolves the transcriber schema from the catalog, non-admin users
+// only see their own sessions and the sessions without owner
+type resolver struct {
+	catalog *catalog.Catalog
+	users   Users
+}
+
+// NewSchema creates the schema described in schema.graphql
+func NewSchema(c *catalog.Catalog, users Users) *Schema {
+	r := &resolver{catalog: c, users: users}
+	return &Schema{Types: map[string]*Object{
+		"Query": {Name: "Query", Fields: map[string]*Field{
+			"me":          {Type: "User", Resolve: r.me},
+			"users":       {Type: "User", Resolve: r.allUsers},
+			"sessions":    {Type: "Session", Resolve: r.sessions},
+			"session":     {Type: "Session", Resolve: r.session},
+			"recordings":  {Type: "Recording", Resolve: r.recordings},
+			"transcripts": {Type: "Transcript", Resolve: r.transcripts},
+			"tags":        {Resolve: r.tags},
+		}},
+		"Mutation": {Name: "Mutation", Fields: map[string]*Field{
+			"setTags": {Type: "Session", Resolve: r.setTags},
+		}},
+		"User": {Name: "User", Fields: map[string]*Field{
+			"name": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*user).name, nil
+			}},
+			"admin": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*user).admin, nil
+			}},
+			"sessions": {Type: "Session", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				args["user"] = source.(*user).name
+				return r.sessions(ctx, nil, args)
+			}},
+		}},
+		"Session": {Name: "Session", Fields: map[string]*Field{
+			"id":               {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.ID })},
+			"user":             {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.User })},
+			"language":         {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.Language })},
+			"detectedLanguage": {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.Detected })},
+			"startedAt":        {Resolve: sessionField(func(s *catalog.Session) interface{} { return formatTime(s.StartedAt) })},
+			"endedAt":          {Resolve: sessionField(func(s *catalog.Session) interface{} { return formatTime(s.EndedAt) })},
+			"durationSeconds":  {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.Duration })},
+			"tags":             {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.Tags })},
+			"completion":       {Resolve: sessionField(func(s *catalog.Session) interface{} { return s.Completion })},
+			"recording":        {Type: "Recording", Resolve: sessionField(func(s *catalog.Session) interface{} { return sessionFile(s.Recording, s) })},
+			"transcript":       {Type: "Transcript", Resolve: sessionField(func(s *catalog.Session) interface{} { return sessionFile(s.Transcript, s) })},
+			"markers":          {Type: "Marker", Resolve: sessionField(func(s *catalog.Session) interface{} { return append([]transcribe.Marker{}, s.Markers...) })},
+			"chapters":         {Type: "Chapter", Resolve: sessionField(func(s *catalog.Session) interface{} { return s.Chapters() })},
+		}},
+		"Marker": {Name: "Marker", Fields: map[string]*Field{
+			"name":          {Resolve: markerField(func(m transcribe.Marker) interface{} { return m.Name })},
+			"offsetSeconds": {Resolve: markerField(func(m transcribe.Marker) interface{} { return m.Offset })},
+			"time":          {Resolve: markerField(func(m transcribe.Marker) interface{} { return formatTime(m.Time) })},
+		}},
+		"Chapter": {Name: "Chapter", Fields: map[string]*Field{
+			"title":        {Resolve: chapterField(func(c catalog.Chapter) interface{} { return c.Title })},
+			"startSeconds": {Resolve: chapterField(func(c catalog.Chapter) interface{} { return c.Start })},
+			"endSeconds":   {Resolve: chapterField(func(c catalog.Chapter) interface{} { return c.End })},
+		}},
+		"Recording":  {Name: "Recording", Fields: r.fileFields(false)},
+		"Transcript": {Name: "Transcript", Fields: r.fileFields(true)},
+		"Segment": {Name: "Segment", Fields: map[string]*Field{
+			"index": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*segment).index, nil
+			}},
+			"text": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return source.(*segment).text, nil
+			}},
+		}},
+	}}
+}
+
+func (r *resolver) fileFields(transcript bool) map[string]*Field {
+	fields := map[string]*Field{
+		"name":    {Resolve: fileField(func(f *file) interface{} { return f.Name })},
+		"size":    {Resolve: fileField(func(f *file) interface{} { return f.Size })},
+		"modTime": {Resolve: fileField(func(f *file) interface{} { return formatTime(f.ModTime) })},
+		"url":     {Resolve: fileField(func(f *file) interface{} { return "/recordings/" + url.PathEscape(f.Name) })},
+		"session": {Type: "Session", Resolve: fileField(func(f *file) interface{} { return f.session })},
+	}
+	if transcript {
+		fields["text"] = &Field{Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return r.catalog.Text(source.(*file).session)
+		}}
+		fields["segments"] = &Field{Type: "Segment", Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			text, err := r.catalog.Text(source.(*file).session)
+			if err != nil {
+				return nil, err
+			}
+			var segments []*segment
+			for i, line := range catalog.Segments(text) {
+				segments = append(segments, &segment{index: i, text: line})
+			}
+			return segments, nil
+		}}
+	}
+	return fields
+}
+
+func (r *resolver) me(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	name := auth.UserFromContext(ctx)
+	return &user{name: name, admin: r.users.IsAdmin(name)}, nil
+}
+
+func (r *resolver) allUsers(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	if !r.users.IsAdmin(auth.UserFromContext(ctx)) {
+		return nil, fmt.Errorf("forbidden")
+	}
+	names := r.users.Usernames()
+	sort.Strings(names)
+	users := make([]*user, 0, len(names))
+	for _, name := range names {
+		users = append(users, &user{name: name, admin: r.users.IsAdmin(name)})
+	}
+	return users, nil
+}
+
+// sessions lists the visible sessions, filtered by the optional user,
+// tag and language arguments and truncated to limit
+func (r *resolver) sessions(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	all, err := r.catalog.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	userFilter, _ := args["user"].(string)
+	tagFilter, _ := args["tag"].(string)
+	languageFilter, _ := args["language"].(string)
+	limit, err := intArg(args, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*catalog.Session
+	for _, s := range all {
+		if !r.visible(ctx, s) || userFilter != "" && s.User != userFilter || languageFilter != "" && !s.HasLanguage(languageFilter) {
+			continue
+		}
+		if tagFilter != "" && !hasTag(s, tagFilter) {
+			continue
+		}
+		sessions = append(sessions, s)
+		if limit > 0 && len(sessions) == limit {
+			break
+		}
+	}
+	return sessions, nil
+}
+
+func (r *resolver) session(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	id, _ := args["id"].(string)
+	s, err := r.catalog.Session(id)
+	if err != nil || s == nil || !r.visible(ctx, s) {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *resolver) recordings(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.files(ctx, func(s *catalog.Session) *catalog.File { return s.Recording })
+}
+
+func (r *resolver) transcripts(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.files(ctx, func(s *catalog.Session) *catalog.File { return s.Transcript })
+}
+
+func (r *resolver) files(ctx context.Context, get func(*catalog.Session) *catalog.File) (interface{}, error) {
+	sessions, err := r.catalog.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	var files []*file
+	for _, s := range sessions {
+		if f := get(s); f != nil && r.visible(ctx, s) {
+			files = append(files, &file{File: f, session: s})
+		}
+	}
+	return files, nil
+}
+
+// tags lists the tags of the visible sessions
+func (r *resolver) tags(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	sessions, err := r.catalog.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, s := range sessions {
+		if !r.visible(ctx, s) {
+			continue
+		}
+		for _, tag := range s.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (r *resolver) setTags(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+	id, _ := args["session"].(string)
+	s, err := r.catalog.Session(id)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || !r.visible(ctx, s) {
+		return nil, fmt.Errorf("session %q not found", id)
+	}
+	list, _ := args["tags"].([]interface{})
+	tags := make([]string, 0, len(list))
+	for _, item := range list {
+		tag, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("tags must be strings")
+		}
+		tags = append(tags, tag)
+	}
+	return r.catalog.SetTags(id, tags)
+}
+
+func (r *resolver) visible(ctx context.Context, s *catalog.Session) bool {
+	name := auth.UserFromContext(ctx)
+	return s.User == "" || s.User == name || r.users.IsAdmin(name)
+}
+
+func sessionField(get func(*catalog.Session) interface{}) Resolver {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(source.(*catalog.Session)), nil
+	}
+}
+
+func markerField(get func(transcribe.Marker) interface{}) Resolver {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(source.(transcribe.Marker)), nil
+	}
+}
+
+func chapterField(get func(catalog.Chapter) interface{}) Resolver {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(source.(catalog.Chapter)), nil
+	}
+}
+
+func fileField(get func(*file) interface{}) Resolver {
+	return func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(source.(*file)), nil
+	}
+}
+
+// sessionFile returns nil rather than a typed nil pointer for missing files
+func sessionFile(f *catalog.File, s *catalog.Session) interface{} {
+	if f == nil {
+		return nil
+	}
+	return &file{File: f, session: s}
+}
+
+func hasTag(s *catalog.Session, tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTime encodes times as RFC 3339, zero times as null
+func formatTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// intArg reads an Int argument, variables decoded from JSON are float64
+func intArg(args map[string]interface{}, name string) (int, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
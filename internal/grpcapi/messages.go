@@ -0,0 +1,123 @@
+package grpcapi
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// The messages of transcriber.proto, they are encoded by golang/protobuf from
+// the struct tags and kept in sync with the .proto file by hand
+
+// CreateSessionRequest is the SDP offer of a new WebRTC session
+type CreateSessionRequest struct {
+	Offer      string `protobuf:"bytes,1,opt,name=offer,proto3" json:"offer,omitempty"`
+	Language   string `protobuf:"bytes,2,opt,name=language,proto3" json:"language,omitempty"`
+	RecordOnly bool   `protobuf:"varint,3,opt,name=record_only,json=recordOnly,proto3" json:"record_only,omitempty"`
+}
+
+func (m *CreateSessionRequest) Reset()         { *m = CreateSessionRequest{} }
+func (m *CreateSessionRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateSessionRequest) ProtoMessage()    {}
+
+// CreateSessionResponse carries the SDP answer
+type CreateSessionResponse struct {
+	Answer string `protobuf:"bytes,1,opt,name=answer,proto3" json:"answer,omitempty"`
+}
+
+func (m *CreateSessionResponse) Reset()         { *m = CreateSessionResponse{} }
+func (m *CreateSessionResponse) String() string { return proto.CompactTextString(m) }
+func (*CreateSessionResponse) ProtoMessage()    {}
+
+// SubscribeTranscriptsRequest selects the events streamed to a subscriber
+type SubscribeTranscriptsRequest struct {
+	Partials bool `protobuf:"varint,1,opt,name=partials,proto3" json:"partials,omitempty"`
+}
+
+func (m *SubscribeTranscriptsRequest) Reset()         { *m = SubscribeTranscriptsRequest{} }
+func (m *SubscribeTranscriptsRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeTranscriptsRequest) ProtoMessage()    {}
+
+// TranscriptEvent is a session event or transcript segment
+type TranscriptEvent struct {
+	Type       string  `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Session    string  `protobuf:"bytes,2,opt,name=session,proto3" json:"session,omitempty"`
+	User       string  `protobuf:"bytes,3,opt,name=user,proto3" json:"user,omitempty"`
+	Language   string  `protobuf:"bytes,4,opt,name=language,proto3" json:"language,omitempty"`
+	Text       string  `protobuf:"bytes,5,opt,name=text,proto3" json:"text,omitempty"`
+	Confidence float32 `protobuf:"fixed32,6,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	Final      bool    `protobuf:"varint,7,opt,name=final,proto3" json:"final,omitempty"`
+	AudioFile  string  `protobuf:"bytes,8,opt,name=audio_file,json=audioFile,proto3" json:"audio_file,omitempty"`
+	TextFile   string  `protobuf:"bytes,9,opt,name=text_file,json=textFile,proto3" json:"text_file,omitempty"`
+	TimeMs     int64   `protobuf:"varint,10,opt,name=time_ms,json=timeMs,proto3" json:"time_ms,omitempty"`
+	Offset     float64 `protobuf:"fixed64,11,opt,name=offset_seconds,json=offsetSeconds,proto3" json:"offset_seconds,omitempty"`
+	Segment    int32   `protobuf:"varint,12,opt,name=segment,proto3" json:"segment,omitempty"`
+	Code       string  `protobuf:"bytes,13,opt,name=code,proto3" json:"code,omitempty"`
+}
+
+func (m *TranscriptEvent) Reset()         { *m = TranscriptEvent{} }
+func (m *TranscriptEvent) String() string { return proto.CompactTextString(m) }
+func (*TranscriptEvent) ProtoMessage()    {}
+
+// Transcript is a transcript file of the output directory
+type Transcript struct {
+	Name      string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Text      string `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	Size      int64  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	ModTimeMs int64  `protobuf:"varint,4,opt,name=mod_time_ms,json=modTimeMs,proto3" json:"mod_time_ms,omitempty"`
+}
+
+func (m *Transcript) Reset()         { *m = Transcript{} }
+func (m *Transcript) String() string { return proto.CompactTextString(m) }
+func (*Transcript) ProtoMessage()    {}
+
+// ListTranscriptsRequest lists every transcript
+type ListTranscriptsRequest struct {
+}
+
+func (m *ListTranscriptsRequest) Reset()         { *m = ListTranscriptsRequest{} }
+func (m *ListTranscriptsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListTranscriptsRequest) ProtoMessage()    {}
+
+// ListTranscriptsResponse holds the transcripts, newest first
+type ListTranscriptsResponse struct {
+	Transcripts []*Transcript `protobuf:"bytes,1,rep,name=transcripts,proto3" json:"transcripts,omitempty"`
+}
+
+func (m *ListTranscriptsResponse) Reset()         { *m = ListTranscriptsResponse{} }
+func (m *ListTranscriptsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListTranscriptsResponse) ProtoMessage()    {}
+
+// GetTranscriptRequest reads one transcript
+type GetTranscriptRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *GetTranscriptRequest) Reset()         { *m = GetTranscriptRequest{} }
+func (m *GetTranscriptRequest) String() string { return proto.CompactTextString(m) }
+func (*GetTranscriptRequest) ProtoMessage()    {}
+
+// WriteTranscriptRequest creates or replaces a transcript
+type WriteTranscriptRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Text string `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+}
+
+func (m *WriteTranscriptRequest) Reset()         { *m = WriteTranscriptRequest{} }
+func (m *WriteTranscriptRequest) String() string { return proto.CompactTextString(m) }
+func (*WriteTranscriptRequest) ProtoMessage()    {}
+
+// DeleteTranscriptRequest deletes one transcript
+type DeleteTranscriptRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *DeleteTranscriptRequest) Reset()         { *m = DeleteTranscriptRequest{} }
+func (m *DeleteTranscriptRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteTranscriptRequest) ProtoMessage()    {}
+
+// DeleteTranscriptResponse is returned once a transcript is deleted
+type DeleteTranscriptResponse struct {
+}
+
+func (m *DeleteTranscriptResponse) Reset()         { *m = DeleteTranscriptResponse{} }
+func (m *DeleteTranscriptResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteTranscriptResponse) ProtoMessage()    {}
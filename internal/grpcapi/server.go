@@ -0,0 +1,267 @@
+package grpcapi
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+	"github.com/walterfan/webrtc-transcriber/internal/rtc"
+)
+
+// transcriptExt is the extension of the files managed by the transcript RPCs
+const transcriptExt = ".txt"
+
+// Authenticator returns the user owning a session token
+type Authenticator func(token string) (string, bool)
+
+// OutputDir returns the directory of the transcripts of a user
+type OutputDir func(user string) string
+
+// server implements TranscriberServer on top of the WebRTC service and
+// the output directory
+type server struct {
+	webrtc    rtc.Service
+	hub       *live.Hub
+	outputDir OutputDir
+}
+
+// NewServer creates a gRPC server exposing the transcriber service, every
+// call must carry the token returned by /login in an
+// "authorization: Bearer <token>" metadata entry
+func NewServer(webrtc rtc.Service, hub *live.Hub, outputDir OutputDir, authenticate Authenticator) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := authenticateContext(ctx, authenticate)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := authenticateContext(ss.Context(), authenticate)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+		}),
+	)
+	RegisterTranscriberServer(s, &server{
+		webrtc:    webrtc,
+		hub:       hub,
+		outputDir: outputDir,
+	})
+	return s
+}
+
+// authenticateContext validates the bearer token of the call and adds its
+// user to the context
+func authenticateContext(ctx context.Context, authenticate Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimSpace(strings.TrimPrefix(value, "Bearer "))
+		if username, valid := authenticate(token); valid {
+			return auth.WithUser(ctx, username), nil
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing or expired session token")
+}
+
+// authenticatedStream overrides the context of a stream with the
+// authenticated one
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// CreateSession creates a peer connection and answers the SDP offer
+func (s *server) CreateSession(ctx context.Context, req *CreateSessionRequest) (*CreateSessionResponse, error) {
+	if req.Offer == "" {
+		return nil, status.Error(codes.InvalidArgument, "offer is required")
+	}
+	language := req.Language
+	if language == "" {
+		language = "auto"
+	}
+	log.Printf("Creating gRPC peer connection with language: %s, transcribe: %v", language, !req.RecordOnly)
+
+	peer, err := s.webrtc.CreatePeerConnectionWithOptions(rtc.PeerConnectionOptions{
+		Language:   language,
+		Transcribe: !req.RecordOnly,
+		User:       auth.UserFromContext(ctx),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create peer connection: %v", err)
+	}
+	answer, err := peer.ProcessOffer(req.Offer)
+	if err != nil {
+		peer.Close()
+		return nil, status.Errorf(codes.InvalidArgument, "failed to process offer: %v", err)
+	}
+	return &CreateSessionResponse{Answer: answer}, nil
+}
+
+// SubscribeTranscripts streams the events of the sessions of the caller
+// until the client cancels the call
+func (s *server) SubscribeTranscripts(req *SubscribeTranscriptsRequest, stream Transcriber_SubscribeTranscriptsServer) error {
+	user := auth.UserFromContext(stream.Context())
+	sub := s.hub.Subscribe(user, req.Partials)
+	defer s.hub.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-sub.Events:
+			if err := stream.Send(&TranscriptEvent{
+				Type:       event.Type,
+				Session:    event.Session,
+				User:       event.User,
+				Language:   event.Language,
+				Text:       event.Text,
+				Confidence: event.Confidence,
+				Final:      event.Final,
+				AudioFile:  event.AudioFile,
+				TextFile:   event.TextFile,
+				TimeMs:     event.TimeMs,
+				Offset:     event.Offset,
+				Segment:    int32(event.Segment),
+				Code:       event.Code,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ListTranscripts lists the transcripts of the output directory of the
+// user, newest first
+func (s *server) ListTranscripts(ctx context.Context, req *ListTranscriptsRequest) (*ListTranscriptsResponse, error) {
+	files, err := ioutil.ReadDir(s.outputDir(auth.UserFromContext(ctx)))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list transcripts: %v", err)
+	}
+	resp := &ListTranscriptsResponse{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != transcriptExt {
+			continue
+		}
+		resp.Transcripts = append(resp.Transcripts, transcriptInfo(file))
+	}
+	sort.Slice(resp.Transcripts, func(i, j int) bool {
+		return resp.Transcripts[i].ModTimeMs > resp.Transcripts[j].ModTimeMs
+	})
+	return resp, nil
+}
+
+// GetTranscript returns a transcript with its text
+func (s *server) GetTranscript(ctx context.Context, req *GetTranscriptRequest) (*Transcript, error) {
+	path, err := s.transcriptPath(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return readTranscript(path)
+}
+
+// CreateTranscript writes a new transcript, it fails if the file exists
+func (s *server) CreateTranscript(ctx context.Context, req *WriteTranscriptRequest) (*Transcript, error) {
+	path, err := s.transcriptPath(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, status.Errorf(codes.AlreadyExists, "transcript %s already exists", req.Name)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create transcript: %v", err)
+	}
+	_, err = file.WriteString(req.Text)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to write transcript: %v", err)
+	}
+	log.Printf("Created transcript: %s", path)
+	return readTranscript(path)
+}
+
+// UpdateTranscript replaces the text of an existing transcript
+func (s *server) UpdateTranscript(ctx context.Context, req *WriteTranscriptRequest) (*Transcript, error) {
+	path, err := s.transcriptPath(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "transcript %s not found", req.Name)
+	}
+	if err := ioutil.WriteFile(path, []byte(req.Text), 0644); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to write transcript: %v", err)
+	}
+	log.Printf("Updated transcript: %s", path)
+	return readTranscript(path)
+}
+
+// DeleteTranscript removes a transcript
+func (s *server) DeleteTranscript(ctx context.Context, req *DeleteTranscriptRequest) (*DeleteTranscriptResponse, error) {
+	path, err := s.transcriptPath(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "transcript %s not found", req.Name)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete transcript: %v", err)
+	}
+	log.Printf("Deleted transcript: %s", path)
+	return &DeleteTranscriptResponse{}, nil
+}
+
+// transcriptPath validates a transcript name and returns its path in the
+// output directory of the user
+func (s *server) transcriptPath(ctx context.Context, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || filepath.Ext(name) != transcriptExt {
+		return "", status.Errorf(codes.InvalidArgument, "invalid transcript name: %q", name)
+	}
+	return filepath.Join(s.outputDir(auth.UserFromContext(ctx)), name), nil
+}
+
+func readTranscript(path string) (*Transcript, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "transcript %s not found", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read transcript: %v", err)
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read transcript: %v", err)
+	}
+	transcript := transcriptInfo(info)
+	transcript.Text = string(text)
+	return transcript, nil
+}
+
+func transcriptInfo(info os.FileInfo) *Transcript {
+	return &Transcript{
+		Name:      info.Name(),
+		Size:      info.Size(),
+		ModTimeMs: info.ModTime().UnixMilli(),
+	}
+}
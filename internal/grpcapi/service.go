@@ -0,0 +1,129 @@
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// TranscriberServer is the server API of the transcriber.Transcriber service
+type TranscriberServer interface {
+	CreateSession(context.Context, *CreateSessionRequest) (*CreateSessionResponse, error)
+	SubscribeTranscripts(*SubscribeTranscriptsRequest, Transcriber_SubscribeTranscriptsServer) error
+	ListTranscripts(context.Context, *ListTranscriptsRequest) (*ListTranscriptsResponse, error)
+	GetTranscript(context.Context, *GetTranscriptRequest) (*Transcript, error)
+	CreateTranscript(context.Context, *WriteTranscriptRequest) (*Transcript, error)
+	UpdateTranscript(context.Context, *WriteTranscriptRequest) (*Transcript, error)
+	DeleteTranscript(context.Context, *DeleteTranscriptRequest) (*DeleteTranscriptResponse, error)
+}
+
+// RegisterTranscriberServer registers the service implementation on s
+func RegisterTranscriberServer(s *grpc.Server, srv TranscriberServer) {
+	s.RegisterService(&transcriberServiceDesc, srv)
+}
+
+// Transcriber_SubscribeTranscriptsServer sends the events of a subscription
+type Transcriber_SubscribeTranscriptsServer interface {
+	Send(*TranscriptEvent) error
+	grpc.ServerStream
+}
+
+type transcriberSubscribeTranscriptsServer struct {
+	grpc.ServerStream
+}
+
+func (x *transcriberSubscribeTranscriptsServer) Send(m *TranscriptEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// unaryHandler adapts a typed unary method to a grpc.MethodDesc handler
+func unaryHandler(method string, newRequest func() interface{}, call func(TranscriberServer, context.Context, interface{}) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := newRequest()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(TranscriberServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/transcriber.Transcriber/" + method,
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(TranscriberServer), ctx, req)
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+func subscribeTranscriptsHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeTranscriptsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TranscriberServer).SubscribeTranscripts(m, &transcriberSubscribeTranscriptsServer{stream})
+}
+
+var transcriberServiceDesc = grpc.ServiceDesc{
+	ServiceName: "transcriber.Transcriber",
+	HandlerType: (*TranscriberServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSession",
+			Handler: unaryHandler("CreateSession",
+				func() interface{} { return new(CreateSessionRequest) },
+				func(s TranscriberServer, ctx context.Context, in interface{}) (interface{}, error) {
+					return s.CreateSession(ctx, in.(*CreateSessionRequest))
+				}),
+		},
+		{
+			MethodName: "ListTranscripts",
+			Handler: unaryHandler("ListTranscripts",
+				func() interface{} { return new(ListTranscriptsRequest) },
+				func(s TranscriberServer, ctx context.Context, in interface{}) (interface{}, error) {
+					return s.ListTranscripts(ctx, in.(*ListTranscriptsRequest))
+				}),
+		},
+		{
+			MethodName: "GetTranscript",
+			Handler: unaryHandler("GetTranscript",
+				func() interface{} { return new(GetTranscriptRequest) },
+				func(s TranscriberServer, ctx context.Context, in interface{}) (interface{}, error) {
+					return s.GetTranscript(ctx, in.(*GetTranscriptRequest))
+				}),
+		},
+		{
+			MethodName: "CreateTranscript",
+			Handler: unaryHandler("CreateTranscript",
+				func() interface{} { return new(WriteTranscriptRequest) },
+				func(s TranscriberServer, ctx context.Context, in interface{}) (interface{}, error) {
+					return s.CreateTranscript(ctx, in.(*WriteTranscriptRequest))
+				}),
+		},
+		{
+			MethodName: "UpdateTranscript",
+			Handler: unaryHandler("UpdateTranscript",
+				func() interface{} { return new(WriteTranscriptRequest) },
+				func(s TranscriberServer, ctx context.Context, in interface{}) (interface{}, error) {
+					return s.UpdateTranscript(ctx, in.(*WriteTranscriptRequest))
+				}),
+		},
+		{
+			MethodName: "DeleteTranscript",
+			Handler: unaryHandler("DeleteTranscript",
+				func() interface{} { return new(DeleteTranscriptRequest) },
+				func(s TranscriberServer, ctx context.Context, in interface{}) (interface{}, error) {
+					return s.DeleteTranscript(ctx, in.(*DeleteTranscriptRequest))
+				}),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeTranscripts",
+			Handler:       subscribeTranscriptsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "transcriber.proto",
+}
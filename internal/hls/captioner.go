@@ -0,0 +1,195 @@
+package hls
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/captions"
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+)
+
+const (
+	// segmentDuration is the length of the WebVTT segments
+	segmentDuration = 6 * time.Second
+	// windowSegments is the number of segments of the live playlists
+	windowSegments = 10
+	// retention keeps the captions of ended sessions available
+	retention = 10 * time.Minute
+	// maxCueDuration bounds the estimated duration of a cue
+	maxCueDuration = 8 * time.Second
+)
+
+// cue is a caption timed relative to the start of its session
+type cue struct {
+	start time.Duration
+	end   time.Duration
+	text  string
+}
+
+// Session holds the captions of a live session
+type Session struct {
+	ID        string
+	User      string
+	Language  string
+	StartedAt time.Time
+	EndedAt   time.Time // Zero while the session is live
+	cues      []cue
+}
+
+// Captioner collects the final segments of the live sessions as timed
+// cues for the HLS subtitle playlists
+type Captioner struct {
+	hub      *live.Hub
+	format   captions.Format
+	mu       sync.Mutex
+	sessions map[string]*Session
+	now      func() time.Time
+}
+
+// NewCaptioner creates a new Captioner fed by the hub, shaping the cues
+// with format
+func NewCaptioner(hub *live.Hub, format captions.Format) *Captioner {
+	return &Captioner{
+		hub:      hub,
+		format:   format,
+		sessions: make(map[string]*Session),
+		now:      time.Now,
+	}
+}
+
+// Run collects the captions until the process exits
+func (c *Captioner) Run() {
+	sub := c.hub.Subscribe("", false)
+	defer c.hub.Unsubscribe(sub)
+
+	cleanup := time.NewTicker(time.Minute)
+	defer cleanup.Stop()
+	for {
+		select {
+		case event := <-sub.Events:
+			c.handle(event)
+		case <-cleanup.C:
+			c.expire()
+		}
+	}
+}
+
+func (c *Captioner) handle(event *live.Event) {
+	at := time.Unix(0, event.TimeMs*int64(time.Millisecond))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[event.Session]
+	if !ok {
+		if event.Type != "session.started" {
+			return
+		}
+		s = &Session{
+			ID:        event.Session,
+			User:      event.User,
+			Language:  event.Language,
+			StartedAt: at,
+		}
+		c.sessions[event.Session] = s
+		return
+	}
+
+	switch event.Type {
+	case "segment":
+		text := strings.TrimSpace(event.Text)
+		if text == "" {
+			return
+		}
+		// Results carry no timing, the cue ends when the segment arrives
+		// and lasts about the time needed to say it
+		end := at.Sub(s.StartedAt)
+		estimate := time.Duration(len([]rune(text))) * 60 * time.Millisecond
+		if estimate < time.Second {
+			estimate = time.Second
+		}
+		if estimate > maxCueDuration {
+			estimate = maxCueDuration
+		}
+		start := end - estimate
+		if n := len(s.cues); n > 0 && start < s.cues[n-1].end {
+			start = s.cues[n-1].end
+		}
+		if start < 0 {
+			start = 0
+		}
+		if end <= start {
+			end = start + time.Second
+		}
+		// Long segments are split into the cues of their captions
+		for _, c := range c.format.Cues(text, start, end) {
+			s.cues = append(s.cues, cue{start: c.Start, end: c.End, text: c.Text})
+		}
+	case "session.ended":
+		s.EndedAt = at
+	}
+}
+
+// expire forgets the sessions ended for longer than the retention
+func (c *Captioner) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, s := range c.sessions {
+		if !s.EndedAt.IsZero() && c.now().Sub(s.EndedAt) > retention {
+			delete(c.sessions, id)
+		}
+	}
+}
+
+// Sessions returns a copy of the sessions without their cues, newest first
+func (c *Captioner) Sessions() []Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sessions []Session
+	for _, s := range c.sessions {
+		session := *s
+		session.cues = nil
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.After(sessions[j].StartedAt)
+	})
+	return sessions
+}
+
+// snapshot returns a copy of a session with its cues
+func (c *Captioner) snapshot(id string) (*Session, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	session := *s
+	session.cues = append([]cue(nil), s.cues...)
+	return &session, true
+}
+
+// completeSegments returns the number of segments whose time range is
+// over, the last partial one is included once the session ended
+func (s *Session) completeSegments(now time.Time) int {
+	if !s.EndedAt.IsZero() {
+		elapsed := s.EndedAt.Sub(s.StartedAt)
+		return int((elapsed + segmentDuration - 1) / segmentDuration)
+	}
+	return int(now.Sub(s.StartedAt) / segmentDuration)
+}
+
+// segmentCues returns the cues overlapping a segment
+func (s *Session) segmentCues(index int) []cue {
+	from := time.Duration(index) * segmentDuration
+	to := from + segmentDuration
+	var cues []cue
+	for _, c := range s.cues {
+		if c.start < to && c.end > from {
+			cues = append(cues, c)
+		}
+	}
+	return cues
+}
@@ -0,0 +1,133 @@
+package hls
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// sessionInfo is an entry of the session list
+type sessionInfo struct {
+	Session   string `json:"session"`
+	User      string `json:"user,omitempty"`
+	Language  string `json:"language,omitempty"`
+	StartedAt string `json:"started_at"`
+	Live      bool   `json:"live"`
+	Playlist  string `json:"playlist"`
+}
+
+// MakeHandler returns an HTTP handler serving the caption playlists under
+// prefix (e.g. /hls/):
+//
+//	<prefix>                           - JSON list of the sessions
+//	<prefix><session>/captions.m3u8    - HLS subtitle media playlist
+//	<prefix><session>/<n>.vtt          - WebVTT segment
+//
+// visible reports whether a user may see the sessions of their owner
+func MakeHandler(prefix string, captioner *Captioner, visible func(owner, user string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		user := auth.UserFromContext(r.Context())
+
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		if path == "" {
+			sessions := []sessionInfo{}
+			for _, s := range captioner.Sessions() {
+				if !visible(s.User, user) {
+					continue
+				}
+				sessions = append(sessions, sessionInfo{
+					Session:   s.ID,
+					User:      s.User,
+					Language:  s.Language,
+					StartedAt: s.StartedAt.UTC().Format(time.RFC3339),
+					Live:      s.EndedAt.IsZero(),
+					Playlist:  prefix + s.ID + "/captions.m3u8",
+				})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sessions)
+			return
+		}
+
+		parts := strings.Split(path, "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		s, ok := captioner.snapshot(parts[0])
+		if !ok || !visible(s.User, user) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+
+		complete := s.completeSegments(captioner.now())
+		if parts[1] == "captions.m3u8" {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte(playlist(s, complete)))
+			return
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(parts[1], ".vtt"))
+		if err != nil || !strings.HasSuffix(parts[1], ".vtt") || index < 0 || index >= complete {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Write([]byte(segment(s, index)))
+	})
+}
+
+// playlist renders the sliding window of the complete segments, every
+// segment is listed and closed with an ENDLIST once the session ended
+func playlist(s *Session, complete int) string {
+	first := complete - windowSegments
+	if first < 0 || !s.EndedAt.IsZero() {
+		first = 0
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(segmentDuration/time.Second))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	for i := first; i < complete; i++ {
+		duration := segmentDuration
+		if !s.EndedAt.IsZero() && i == complete-1 {
+			if rest := s.EndedAt.Sub(s.StartedAt) - time.Duration(i)*segmentDuration; rest > 0 {
+				duration = rest
+			}
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.vtt\n", duration.Seconds(), i)
+	}
+	if !s.EndedAt.IsZero() {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+// segment renders a WebVTT segment, cue times are relative to the start of
+// the session which is mapped to the MPEG-TS time 0
+func segment(s *Session, index int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	b.WriteString("X-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n\n")
+	for _, c := range s.segmentCues(index) {
+		text := strings.Replace(c.text, "-->", "->", -1)
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", vttTime(c.start), vttTime(c.end), text)
+	}
+	return b.String()
+}
+
+// vttTime formats a duration as hh:mm:ss.ttt
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,195 @@
+package intent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/live"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+const (
+	// maxClipSize limits the uploaded clips to about a minute of 48 kHz audio
+	maxClipSize = 6 << 20
+	// defaultListenTimeout is the time waited for the next utterance of a
+	// live session
+	defaultListenTimeout = 30 * time.Second
+	maxListenTimeout     = 120 * time.Second
+)
+
+// jsonRequest is the JSON body of an intent request, with either the text
+// of the utterance or a live session to listen to
+type jsonRequest struct {
+	Text     string `json:"text,omitempty"`
+	Session  string `json:"session,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"` // Seconds waited for the utterance of the session
+	Language string `json:"language,omitempty"`
+}
+
+// jsonResponse is the response of the intent endpoint
+type jsonResponse struct {
+	Text     string          `json:"text"`
+	Speech   string          `json:"speech,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// MakeHandler returns an HTTP handler transcribing an utterance and
+// forwarding it to the intent handler. The POST body is either a WAV clip
+// (audio/wav, ?language= selects the language) or a JSON request with the
+// text or the id of a live session whose next final segment is used
+func MakeHandler(service transcribe.Service, hub *live.Hub, handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		user := auth.UserFromContext(r.Context())
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxClipSize))
+		if err != nil {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		var text, language string
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var req jsonRequest
+			if err := json.Unmarshal(body, &req); err != nil {
+				http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+				return
+			}
+			language = req.Language
+			switch {
+			case req.Text != "":
+				text = req.Text
+			case req.Session != "":
+				timeout := defaultListenTimeout
+				if req.Timeout > 0 {
+					timeout = time.Duration(req.Timeout) * time.Second
+				}
+				if timeout > maxListenTimeout {
+					timeout = maxListenTimeout
+				}
+				var status int
+				text, language, status = listen(r.Context(), hub, user, req.Session, timeout)
+				if status != http.StatusOK {
+					http.Error(w, http.StatusText(status), status)
+					return
+				}
+			default:
+				http.Error(w, "text or session is required", http.StatusBadRequest)
+				return
+			}
+		} else {
+			language = r.URL.Query().Get("language")
+			if language == "" {
+				language = "auto"
+			}
+			text, err = transcribeClip(service, body, language, user)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		text = strings.TrimSpace(text)
+		if text == "" {
+			http.Error(w, "No speech recognized", http.StatusUnprocessableEntity)
+			return
+		}
+		resp, err := handler.Handle(r.Context(), Request{Text: text, Language: language, User: user})
+		if err != nil {
+			log.Printf("Intent handler failed: %v", err)
+			http.Error(w, "Intent handler failed", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jsonResponse{
+			Text:     text,
+			Speech:   resp.Speech,
+			Response: resp.Raw,
+		})
+	})
+}
+
+// transcribeClip transcribes a WAV clip and returns the text of its final
+// results
+func transcribeClip(service transcribe.Service, clip []byte, language, user string) (string, error) {
+	samples, sampleRate, err := audio.DecodeWAV(clip)
+	if err != nil {
+		return "", err
+	}
+	if sampleRate <= 0 || sampleRate > transcribe.PipelineSampleRate || transcribe.PipelineSampleRate%sampleRate != 0 {
+		return "", fmt.Errorf("unsupported sample rate %d, use a divisor of %d", sampleRate, transcribe.PipelineSampleRate)
+	}
+
+	stream, err := service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   language,
+		Transcribe: true,
+		User:       user,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription stream: %w", err)
+	}
+	var texts []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range stream.Results() {
+			if result.Final && strings.TrimSpace(result.Text) != "" {
+				texts = append(texts, strings.TrimSpace(result.Text))
+			}
+		}
+	}()
+
+	pcm := audio.NewUpsampler(sampleRate, transcribe.PipelineSampleRate).Process(samples)
+	for len(pcm) > 0 {
+		// Write 100ms chunks like a live stream
+		n := 9600
+		if n > len(pcm) {
+			n = len(pcm)
+		}
+		if _, err := stream.Write(pcm[:n]); err != nil {
+			break
+		}
+		pcm = pcm[n:]
+	}
+	stream.Close()
+	<-done
+	return strings.Join(texts, " "), nil
+}
+
+// listen waits for the next final segment of a live session of the user,
+// it returns its text and language or the HTTP status of the failure
+func listen(ctx context.Context, hub *live.Hub, user, session string, timeout time.Duration) (string, string, int) {
+	sub := hub.Subscribe(user, false)
+	defer hub.Unsubscribe(sub)
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", "", http.StatusRequestTimeout
+		case <-deadline.C:
+			return "", "", http.StatusRequestTimeout
+		case event := <-sub.Events:
+			if event.Session != session {
+				continue
+			}
+			if event.Type == "session.ended" {
+				return "", "", http.StatusGone
+			}
+			if event.Type == "segment" && event.Final && strings.TrimSpace(event.Text) != "" {
+				return event.Text, event.Language, http.StatusOK
+			}
+		}
+	}
+}
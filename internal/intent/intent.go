@@ -0,0 +1,159 @@
+package intent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/webhook"
+)
+
+// maxResponseSize limits the size of the intent handler responses
+const maxResponseSize = 1 << 20
+
+// Request is an utterance to interpret
+type Request struct {
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+	User     string `json:"user,omitempty"`
+}
+
+// Response is the answer of an intent handler
+type Response struct {
+	Speech string          // Text to say back to the user, may be empty
+	Raw    json.RawMessage // Response of the handler as received
+}
+
+// Handler interprets utterances, e.g. to control devices
+type Handler interface {
+	Handle(ctx context.Context, req Request) (*Response, error)
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// HomeAssistant forwards the utterances to the conversation API of Home
+// Assistant
+type HomeAssistant struct {
+	url     string
+	token   string
+	agentID string
+}
+
+// NewHomeAssistant creates a new HomeAssistant handler for the instance at
+// baseURL, authenticated with a long-lived access token
+func NewHomeAssistant(baseURL, token, agentID string) *HomeAssistant {
+	return &HomeAssistant{
+		url:     strings.TrimSuffix(baseURL, "/") + "/api/conversation/process",
+		token:   token,
+		agentID: agentID,
+	}
+}
+
+// Handle processes the utterance with the conversation agent
+func (h *HomeAssistant) Handle(ctx context.Context, req Request) (*Response, error) {
+	body := map[string]string{"text": req.Text}
+	if req.Language != "" && req.Language != "auto" {
+		body["language"] = req.Language
+	}
+	if h.agentID != "" {
+		body["agent_id"] = h.agentID
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+h.token)
+	raw, err := do(ctx, httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Response struct {
+			Speech struct {
+				Plain struct {
+					Speech string `json:"speech"`
+				} `json:"plain"`
+			} `json:"speech"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("invalid Home Assistant response: %w", err)
+	}
+	return &Response{Speech: result.Response.Speech.Plain.Speech, Raw: raw}, nil
+}
+
+// Webhook posts the utterances as JSON to a URL, signed like the
+// completion webhooks, and returns its JSON response
+type Webhook struct {
+	url    string
+	secret []byte
+}
+
+// NewWebhook creates a new Webhook handler, requests are not signed when
+// the secret is empty
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{
+		url:    url,
+		secret: []byte(secret),
+	}
+}
+
+// Handle posts the utterance, the "speech" field of the response is said
+// back to the user
+func (h *Webhook) Handle(ctx context.Context, req Request) (*Response, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if len(h.secret) > 0 {
+		webhook.SignRequest(httpReq, h.secret, payload)
+	}
+	raw, err := do(ctx, httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Speech string `json:"speech"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("invalid intent webhook response: %w", err)
+	}
+	return &Response{Speech: result.Speech, Raw: raw}, nil
+}
+
+// do sends a JSON request and returns the JSON body of a 2xx response
+func do(ctx context.Context, req *http.Request) (json.RawMessage, error) {
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("response is not JSON")
+	}
+	return body, nil
+}
@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// maxResultSize limits the size of the results posted by the workers
+const maxResultSize = 16 << 20
+
+// MakeHandler returns the HTTP handler of the workers, mounted on
+// /worker/jobs/:
+//
+//	GET  /worker/jobs/<id>/audio   - WAV recording of a pending job
+//	POST /worker/jobs/<id>/result  - Result of the job
+//
+// Workers authenticate with an "Authorization: Bearer <token>" header
+func MakeHandler(s *Service, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/worker/jobs/"), "/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		id, action := parts[0], parts[1]
+		switch {
+		case action == "audio" && r.Method == http.MethodGet:
+			job, ok := s.job(id)
+			if !ok {
+				http.Error(w, "Job not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "audio/wav")
+			http.ServeFile(w, r, job.audioFile)
+
+		case action == "result" && r.Method == http.MethodPost:
+			var result Result
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxResultSize)).Decode(&result); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if !s.complete(id, result) {
+				http.Error(w, "Job not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
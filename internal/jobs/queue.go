@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/redis"
+)
+
+// Job is the transcription of a recording by a worker, it fetches the
+// audio from AudioURL and posts its Result to ResultURL
+type Job struct {
+	ID        string `json:"id"`
+	User      string `json:"user,omitempty"`
+	Language  string `json:"language,omitempty"`
+	AudioURL  string `json:"audio_url"`
+	ResultURL string `json:"result_url"`
+	CreatedMs int64  `json:"created_ms"`
+}
+
+// Result is the outcome of a job
+type Result struct {
+	Text   string `json:"text"`
+	Error  string `json:"error,omitempty"`
+	Worker string `json:"worker,omitempty"`
+}
+
+// Queue is the list of pending jobs in Redis, shared by the servers
+// pushing the jobs and the workers popping them
+type Queue struct {
+	client *redis.Client
+	key    string
+}
+
+// NewQueue creates a new Queue, the key of the list is prefix + "jobs"
+func NewQueue(client *redis.Client, prefix string) *Queue {
+	return &Queue{client: client, key: prefix + "jobs"}
+}
+
+// Push appends a job to the queue
+func (q *Queue) Push(job Job) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.Do("LPUSH", q.key, string(payload))
+	return err
+}
+
+// Pop removes the oldest job of the queue, waiting up to wait for one. It
+// returns nil when the queue stayed empty
+func (q *Queue) Pop(wait time.Duration) (*Job, error) {
+	reply, err := q.client.Do("BRPOP", q.key, strconv.Itoa(int(wait/time.Second)))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return nil, fmt.Errorf("invalid BRPOP reply")
+	}
+	payload, _ := items[1].(string)
+	job := &Job{}
+	if err := json.Unmarshal([]byte(payload), job); err != nil {
+		return nil, fmt.Errorf("invalid job: %w", err)
+	}
+	return job, nil
+}
+
+// Len returns the number of pending jobs
+func (q *Queue) Len() (int64, error) {
+	reply, err := q.client.Do("LLEN", q.key)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return n, nil
+}
@@ -0,0 +1,196 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Service is a transcribe.Service recording the streams, then queueing
+// their transcription for the workers. The results are emitted once a
+// worker posts them, or with an error after the timeout
+type Service struct {
+	recorder transcribe.Service
+	queue    *Queue
+	baseURL  string
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingJob
+}
+
+// pendingJob is a job waiting for its result
+type pendingJob struct {
+	audioFile string
+	result    chan Result
+}
+
+// stream records the audio, its results are sent after the transcription
+type stream struct {
+	transcribe.Stream
+	service    *Service
+	user       string
+	language   string
+	locale     string // Language of the error messages
+	transcribe bool
+	results    chan transcribe.Result
+}
+
+// NewService creates a new Service recording with recorder, baseURL is
+// the URL of this server the workers fetch the audio from and post the
+// results to
+func NewService(recorder transcribe.Service, queue *Queue, baseURL string, timeout time.Duration) *Service {
+	return &Service{
+		recorder: recorder,
+		queue:    queue,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		timeout:  timeout,
+		pending:  make(map[string]*pendingJob),
+	}
+}
+
+// CreateStream creates a new stream transcribed in the default language
+func (s *Service) CreateStream() (transcribe.Stream, error) {
+	return s.CreateStreamWithOptions(transcribe.StreamOptions{Transcribe: true})
+}
+
+// CreateStreamWithOptions creates a new recording stream queued for
+// transcription on close
+func (s *Service) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	next, err := s.recorder.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{
+		Stream:     next,
+		service:    s,
+		user:       opts.User,
+		language:   opts.Language,
+		locale:     transcribe.StreamLocale(opts, opts.Language),
+		transcribe: opts.Transcribe,
+		results:    make(chan transcribe.Result, 1),
+	}, nil
+}
+
+// Results returns the result of the transcription
+func (st *stream) Results() <-chan transcribe.Result {
+	return st.results
+}
+
+// Close finishes the recording and queues its transcription
+func (st *stream) Close() error {
+	if err := st.Stream.Close(); err != nil {
+		close(st.results)
+		return err
+	}
+	var recording transcribe.Result
+	for result := range st.Stream.Results() {
+		recording = result
+	}
+	if !st.transcribe || recording.AudioFile == "" {
+		if recording.AudioFile != "" {
+			st.results <- recording
+		}
+		close(st.results)
+		return nil
+	}
+	go st.service.transcribe(st, recording.AudioFile)
+	return nil
+}
+
+// transcribe queues the job of a recording and sends its result
+func (s *Service) transcribe(st *stream, audioFile string) {
+	defer close(st.results)
+	id := newJobID()
+	job := &pendingJob{audioFile: audioFile, result: make(chan Result, 1)}
+	s.mu.Lock()
+	s.pending[id] = job
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	err := s.queue.Push(Job{
+		ID:        id,
+		User:      st.user,
+		Language:  st.language,
+		AudioURL:  s.baseURL + "/worker/jobs/" + id + "/audio",
+		ResultURL: s.baseURL + "/worker/jobs/" + id + "/result",
+		CreatedMs: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Printf("Error queueing the transcription of %s: %v", audioFile, err)
+		st.results <- st.errorResult(audioFile, err)
+		return
+	}
+	log.Printf("Queued the transcription of %s (job %s)", audioFile, id)
+
+	select {
+	case result := <-job.result:
+		if result.Error != "" {
+			log.Printf("Worker %s failed job %s: %s", result.Worker, id, result.Error)
+			st.results <- st.errorResult(audioFile, fmt.Errorf("%s", result.Error))
+			return
+		}
+		textFile := strings.TrimSuffix(audioFile, ".wav") + ".txt"
+		if err := ioutil.WriteFile(textFile, []byte(result.Text), 0644); err != nil {
+			log.Printf("Error saving the transcript of job %s: %v", id, err)
+			textFile = ""
+		}
+		log.Printf("Worker %s completed job %s", result.Worker, id)
+		st.results <- transcribe.Result{
+			Text:       result.Text,
+			Confidence: 0.9,
+			Final:      true,
+			AudioFile:  audioFile,
+			TextFile:   textFile,
+		}
+	case <-time.After(s.timeout):
+		log.Printf("No worker completed job %s in %s", id, s.timeout)
+		st.results <- st.errorResult(audioFile, fmt.Errorf("no worker completed the transcription in %s", s.timeout))
+	}
+}
+
+// job returns a pending job
+func (s *Service) job(id string) (*pendingJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.pending[id]
+	return job, ok
+}
+
+// complete delivers the result of a pending job, it reports false when the
+// job is unknown or already completed
+func (s *Service) complete(id string, result Result) bool {
+	job, ok := s.job(id)
+	if !ok {
+		return false
+	}
+	select {
+	case job.result <- result:
+		return true
+	default:
+		return false
+	}
+}
+
+func (st *stream) errorResult(audioFile string, err error) transcribe.Result {
+	result := transcribe.ErrorResult(st.locale, err)
+	result.AudioFile = audioFile
+	return result
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,194 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// keepAliveInterval keeps idle connections open through proxies
+const keepAliveInterval = 30 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// MakeSSEHandler returns an HTTP handler streaming the live transcript
+// events of the current user as Server-Sent Events, ?partials=true also
+// streams the non-final segments
+func MakeSSEHandler(hub *Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		sub := hub.Subscribe(auth.UserFromContext(r.Context()), r.URL.Query().Get("partials") == "true")
+		defer hub.Unsubscribe(sub)
+		serveSSE(w, r, sub)
+	})
+}
+
+// serveSSE streams the events of the subscription as Server-Sent Events
+// until the client disconnects
+func serveSSE(w http.ResponseWriter, r *http.Request, sub *Subscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-sub.Events:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+		}
+		flusher.Flush()
+	}
+}
+
+// MakeRoomHandler returns an HTTP handler of the rooms under prefix:
+// GET <prefix><id> returns the merged transcript of the room as JSON, or
+// as "[15:04:05] Speaker: text" lines with ?format=text, and
+// GET <prefix><id>/events streams the events of its participants as
+// Server-Sent Events, ?partials=true also streams the non-final segments
+func MakeRoomHandler(prefix string, hub *Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		user := auth.UserFromContext(r.Context())
+		id := strings.TrimPrefix(r.URL.Path, prefix)
+		if strings.HasSuffix(id, "/events") {
+			id = strings.TrimSuffix(id, "/events")
+			if !ValidRoomID(id) || !hub.CanJoin(id, user) {
+				http.Error(w, "Room not found", http.StatusNotFound)
+				return
+			}
+			sub := hub.SubscribeRoom(id, user, r.URL.Query().Get("partials") == "true")
+			defer hub.Unsubscribe(sub)
+			serveSSE(w, r, sub)
+			return
+		}
+		transcript, ok := hub.Room(id, user)
+		if !ValidRoomID(id) || !ok {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, entry := range transcript.Entries {
+				start := time.Unix(0, entry.StartMs*int64(time.Millisecond))
+				fmt.Fprintf(w, "[%s] %s: %s\n", start.Format("15:04:05"), entry.Speaker, entry.Text)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transcript)
+	})
+}
+
+// MakeWebSocketHandler returns an HTTP handler sending the live transcript
+// events of the current user as JSON WebSocket messages, ?partials=true
+// also sends the non-final segments
+func MakeWebSocketHandler(hub *Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Error upgrading transcript WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		sub := hub.Subscribe(auth.UserFromContext(r.Context()), r.URL.Query().Get("partials") == "true")
+		defer hub.Unsubscribe(sub)
+
+		// Client messages are ignored, reading detects the close
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-keepAlive.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			case event := <-sub.Events:
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// markerRequest is the body of a marker request
+type markerRequest struct {
+	Session string `json:"session"`
+	Name    string `json:"name"`
+}
+
+// MakeMarkerHandler returns an HTTP handler inserting a named marker, e.g.
+// "decision", at the current position of a live session of the user. The
+// POST body is {"session": "<id of the live events>", "name": "decision"}
+func MakeMarkerHandler(hub *Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req markerRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		marker, err := hub.Mark(req.Session, auth.UserFromContext(r.Context()), req.Name)
+		if err == ErrSessionNotFound {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(marker)
+	})
+}
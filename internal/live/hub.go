@@ -0,0 +1,298 @@
+package live
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the warnings about slow subscribers
+var logSampler = logging.NewSampler(30 * time.Second)
+
+// maxMarkerName limits the length of the marker names
+const maxMarkerName = 100
+
+// ErrSessionNotFound is returned when marking a session that is not live
+// on this server or not owned by the user
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrRoomForbidden is returned when joining a room of another tenant
+var ErrRoomForbidden = errors.New("room belongs to another tenant")
+
+// Event is a session event or transcript segment of a live stream
+type Event struct {
+	Type       string  `json:"type"` // session.started, segment, marker, session.ended
+	Session    string  `json:"session"`
+	User       string  `json:"user,omitempty"`
+	Language   string  `json:"language,omitempty"`
+	Text       string  `json:"text,omitempty"`
+	Confidence float32 `json:"confidence,omitempty"`
+	Final      bool    `json:"final,omitempty"`
+	AudioFile  string  `json:"audio_file,omitempty"`
+	TextFile   string  `json:"text_file,omitempty"`
+	Offset     float64 `json:"offset_seconds,omitempty"` // Position of a marker
+	Segment    int     `json:"segment,omitempty"`        // Live segment revised by a partial
+	Code       string  `json:"code,omitempty"`           // Code of a server message, see transcribe.Result
+	Room       string  `json:"room,omitempty"`           // Room of the session, see transcribe.StreamOptions
+	Speaker    string  `json:"speaker,omitempty"`        // Name of the participant of the room
+	StartMs    int64   `json:"start_ms,omitempty"`       // Unix time in milliseconds the speech of a segment started
+	TimeMs     int64   `json:"time_ms"`                  // Unix time in milliseconds
+}
+
+// Hub wraps a transcribe.Service and broadcasts the events of its streams
+// to the live transcript subscribers
+type Hub struct {
+	next        transcribe.Service
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	streams     map[string]*hubStream
+	rooms       map[string]*room
+	roomAccess  func(owner, user string) bool
+	relay       func(event *Event)
+}
+
+// Subscription receives the events of the streams of a user or of a room
+type Subscription struct {
+	Events   chan *Event
+	user     string // Only events of this user are delivered, all when empty
+	room     string // Only events of this room are delivered, that user may read
+	partials bool
+}
+
+type hubStream struct {
+	transcribe.Stream
+	hub        *Hub
+	session    string
+	user       string
+	language   string
+	room       string
+	speaker    string
+	started    time.Time
+	results    <-chan transcribe.Result
+	markers    *transcribe.Markers
+	completion *transcribe.Completion
+
+	mu         sync.Mutex
+	audioBytes int
+}
+
+// NewHub creates a new Hub in front of the service
+func NewHub(next transcribe.Service) *Hub {
+	return &Hub{
+		next:        next,
+		subscribers: make(map[*Subscription]struct{}),
+		streams:     make(map[string]*hubStream),
+		rooms:       make(map[string]*room),
+	}
+}
+
+// CreateStream creates a new broadcast stream
+func (h *Hub) CreateStream() (transcribe.Stream, error) {
+	next, err := h.next.CreateStream()
+	return h.wrap(next, err, transcribe.StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new broadcast stream with the specified options
+func (h *Hub) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	// The markers reach the layers below, which save them with the session
+	if opts.Markers == nil {
+		opts.Markers = &transcribe.Markers{}
+	}
+	if opts.Room != "" && !h.CanJoin(opts.Room, opts.User) {
+		return nil, ErrRoomForbidden
+	}
+	next, err := h.next.CreateStreamWithOptions(opts)
+	return h.wrap(next, err, opts)
+}
+
+func (h *Hub) wrap(next transcribe.Stream, err error, opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	if err != nil {
+		return nil, err
+	}
+	// The segments of a session share its ID, they are one live session
+	session := opts.SessionID
+	if session == "" {
+		session = transcribe.NewSessionID()
+	}
+	st := &hubStream{
+		Stream:     next,
+		hub:        h,
+		session:    session,
+		user:       opts.User,
+		language:   opts.Language,
+		room:       opts.Room,
+		speaker:    opts.Speaker,
+		started:    time.Now(),
+		markers:    opts.Markers,
+		completion: opts.Completion,
+	}
+	if st.markers == nil {
+		st.markers = &transcribe.Markers{}
+	}
+	h.mu.Lock()
+	_, continued := h.streams[st.session]
+	h.streams[st.session] = st
+	h.mu.Unlock()
+	if !continued {
+		st.broadcast(&Event{Type: "session.started"})
+	}
+	st.results = transcribe.ForwardResults(next, st.segment, st.end)
+	return st, nil
+}
+
+// Subscribe registers a subscription to the events of the user, or of
+// every user when empty; it must be cancelled with Unsubscribe
+func (h *Hub) Subscribe(user string, partials bool) *Subscription {
+	sub := &Subscription{
+		Events:   make(chan *Event, 64),
+		user:     user,
+		partials: partials,
+	}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// SetRelay registers a function receiving the events of the local streams,
+// e.g. to publish them to the other replicas of a cluster. It must not
+// block and is set before the first stream
+func (h *Hub) SetRelay(relay func(event *Event)) {
+	h.relay = relay
+}
+
+// Mark inserts a marker at the current position of a live session of the
+// user, the marker is broadcast to the subscribers and saved with the session
+func (h *Hub) Mark(session, user, name string) (transcribe.Marker, error) {
+	h.mu.Lock()
+	st, ok := h.streams[session]
+	h.mu.Unlock()
+	if !ok || st.user != user {
+		return transcribe.Marker{}, ErrSessionNotFound
+	}
+	return st.Mark(name)
+}
+
+// Unsubscribe stops the delivery of events to the subscription
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// segment broadcasts a result of the stream
+func (st *hubStream) segment(result transcribe.Result) {
+	// Orders the segments of the participants of a room, the time of
+	// their transcript when the vendor does not time them
+	start := time.Now()
+	if result.Start > 0 || result.End > 0 {
+		start = st.started.Add(time.Duration(result.Start * float64(time.Second)))
+	}
+	st.broadcast(&Event{
+		Type:       "segment",
+		Text:       result.Text,
+		Confidence: result.Confidence,
+		Final:      result.Final,
+		AudioFile:  result.AudioFile,
+		TextFile:   result.TextFile,
+		Segment:    result.Segment,
+		Code:       result.Code,
+		StartMs:    start.UnixMilli(),
+	})
+}
+
+// end forgets the stream once its results are forwarded, the session goes
+// on when a later segment of it replaced the stream
+func (st *hubStream) end() {
+	st.hub.mu.Lock()
+	current := st.hub.streams[st.session] == st
+	if current {
+		delete(st.hub.streams, st.session)
+	}
+	st.hub.mu.Unlock()
+	if !current {
+		return
+	}
+	// The text of session.ended is the completion of the abnormal ends
+	st.broadcast(&Event{Type: "session.ended", Text: st.completion.Reason()})
+}
+
+// Write passes audio to the underlying stream
+func (st *hubStream) Write(buffer []byte) (int, error) {
+	n, err := st.Stream.Write(buffer)
+	st.mu.Lock()
+	st.audioBytes += n
+	st.mu.Unlock()
+	return n, err
+}
+
+// Mark inserts a marker at the current position of the stream, it
+// implements transcribe.MarkingStream
+func (st *hubStream) Mark(name string) (transcribe.Marker, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return transcribe.Marker{}, errors.New("marker name is required")
+	}
+	if len(name) > maxMarkerName {
+		return transcribe.Marker{}, errors.New("marker name is too long")
+	}
+	st.mu.Lock()
+	marker := transcribe.Marker{
+		Name:   name,
+		Offset: float64(st.audioBytes) / transcribe.PCMBytesPerSecond,
+		Time:   time.Now(),
+	}
+	st.mu.Unlock()
+	st.markers.Add(marker)
+	st.broadcast(&Event{Type: "marker", Text: marker.Name, Offset: marker.Offset})
+	return marker, nil
+}
+
+// Results returns the results of the underlying stream
+func (st *hubStream) Results() <-chan transcribe.Result {
+	return st.results
+}
+
+// broadcast completes the event with the stream details and delivers it
+func (st *hubStream) broadcast(event *Event) {
+	event.Session = st.session
+	event.User = st.user
+	event.Language = st.language
+	event.Room = st.room
+	event.Speaker = st.speaker
+	event.TimeMs = time.Now().UnixMilli()
+	if st.hub.relay != nil {
+		st.hub.relay(event)
+	}
+	st.hub.Deliver(event)
+}
+
+// Deliver sends an event to the matching subscribers, e.g. the events of
+// the streams of another replica, and adds it to the transcript of its
+// room. Events are dropped for subscribers that do not keep up
+func (h *Hub) Deliver(event *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rm := h.record(event)
+	for sub := range h.subscribers {
+		if sub.room != "" {
+			if sub.room != event.Room || !h.allowed(rm, sub.user) {
+				continue
+			}
+		} else if sub.user != "" && sub.user != event.User {
+			continue
+		}
+		if event.Type == "segment" && !event.Final && !sub.partials {
+			continue
+		}
+		select {
+		case sub.Events <- event:
+		default:
+			logSampler.Printf("live:slow-subscriber", "Transcript subscriber is too slow, dropping %s event", event.Type)
+		}
+	}
+}
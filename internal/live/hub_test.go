@@ -0,0 +1,97 @@
+package live
+
+import (
+	"testing"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// fakeService creates streams whose results are sent by the test
+type fakeService struct {
+	streams chan *fakeStream
+}
+
+type fakeStream struct {
+	results chan transcribe.Result
+}
+
+func (s *fakeService) CreateStream() (transcribe.Stream, error) {
+	return s.CreateStreamWithOptions(transcribe.StreamOptions{})
+}
+
+func (s *fakeService) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	st := &fakeStream{results: make(chan transcribe.Result, 10)}
+	s.streams <- st
+	return st, nil
+}
+
+func (st *fakeStream) Write(p []byte) (int, error)       { return len(p), nil }
+func (st *fakeStream) Results() <-chan transcribe.Result { return st.results }
+
+func (st *fakeStream) Close() error {
+	close(st.results)
+	return nil
+}
+
+// next returns the next event of the subscription
+func next(t *testing.T, sub *Subscription) *Event {
+	t.Helper()
+	select {
+	case event := <-sub.Events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+func TestHubSessionID(t *testing.T) {
+	service := &fakeService{streams: make(chan *fakeStream, 2)}
+	hub := NewHub(service)
+	sub := hub.Subscribe("", true)
+	defer hub.Unsubscribe(sub)
+
+	first, err := hub.CreateStreamWithOptions(transcribe.StreamOptions{SessionID: "3f2a9c", User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, sub); event.Type != "session.started" || event.Session != "3f2a9c" {
+		t.Errorf("first event %+v, want session.started of 3f2a9c", event)
+	}
+	fake := <-service.streams
+	fake.results <- transcribe.Result{Text: "hello", Final: true}
+	<-first.Results()
+	if event := next(t, sub); event.Type != "segment" || event.Session != "3f2a9c" {
+		t.Errorf("segment event %+v", event)
+	}
+
+	// A new segment continues the session, which ends with its last stream
+	second, err := hub.CreateStreamWithOptions(transcribe.StreamOptions{SessionID: "3f2a9c", User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	for range first.Results() {
+	}
+	if _, err := hub.Mark("3f2a9c", "alice", "chapter"); err != nil {
+		t.Errorf("Mark of the continued session: %v", err)
+	}
+	if event := next(t, sub); event.Type != "marker" || event.Session != "3f2a9c" {
+		t.Errorf("event after the first segment %+v, want the marker", event)
+	}
+	second.Close()
+	for range second.Results() {
+	}
+	if event := next(t, sub); event.Type != "session.ended" || event.Session != "3f2a9c" {
+		t.Errorf("last event %+v, want session.ended of 3f2a9c", event)
+	}
+
+	// The streams without a session ID get a random one
+	if _, err := hub.CreateStream(); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, sub); event.Type != "session.started" || event.Session == "" || event.Session == "3f2a9c" {
+		t.Errorf("event %+v, want a generated session ID", event)
+	}
+}
@@ -0,0 +1,187 @@
+package live
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// roomRetention is how long the transcript of a room is kept after its
+// last participant left, e.g. to download it after the meeting
+const roomRetention = time.Hour
+
+// maxRoomEntries bounds the transcript kept in memory for a room
+const maxRoomEntries = 10000
+
+// maxSpeakerName limits the length of the participant names
+const maxSpeakerName = 64
+
+var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidRoomID reports whether id may name a room: 1 to 64 letters,
+// digits, '-' or '_'
+func ValidRoomID(id string) bool {
+	return roomIDPattern.MatchString(id)
+}
+
+// ValidSpeaker reports whether name may name a participant of a room
+func ValidSpeaker(name string) bool {
+	return len([]rune(name)) <= maxSpeakerName
+}
+
+// RoomEntry is a final segment of the merged transcript of a room
+type RoomEntry struct {
+	Speaker  string `json:"speaker"`
+	User     string `json:"user,omitempty"`
+	Session  string `json:"session"`
+	Language string `json:"language,omitempty"`
+	Text     string `json:"text"`
+	StartMs  int64  `json:"start_ms"` // Unix time in milliseconds the speech started
+}
+
+// RoomTranscript is the merged transcript of the participants of a room,
+// ordered by the start of their speech
+type RoomTranscript struct {
+	ID           string      `json:"id"`
+	Participants []string    `json:"participants"` // Speakers connected now
+	Entries      []RoomEntry `json:"entries"`
+}
+
+// room is the state of a room kept by the hub
+type room struct {
+	owner   string            // First participant, the other ones must be of the same tenant
+	members map[string]string // Speaker by live stream
+	entries []RoomEntry
+	emptied time.Time // When the last participant left, zero while one is connected
+}
+
+// SetRoomAccess registers the function checking that a user may join or
+// read a room created by owner, e.g. that both are of the same tenant.
+// Every user may when it is not set
+func (h *Hub) SetRoomAccess(access func(owner, user string) bool) {
+	h.roomAccess = access
+}
+
+// CanJoin reports whether the user may join the room, any user may join a
+// room that does not exist yet
+func (h *Hub) CanJoin(id, user string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireRooms(time.Now())
+	rm, ok := h.rooms[id]
+	return !ok || h.allowed(rm, user)
+}
+
+// Room returns the merged transcript of a room the user may read, false
+// when it does not exist or belongs to another tenant
+func (h *Hub) Room(id, user string) (RoomTranscript, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireRooms(time.Now())
+	rm, ok := h.rooms[id]
+	if !ok || !h.allowed(rm, user) {
+		return RoomTranscript{}, false
+	}
+	transcript := RoomTranscript{
+		ID:           id,
+		Participants: rm.participants(),
+		Entries:      make([]RoomEntry, len(rm.entries)),
+	}
+	copy(transcript.Entries, rm.entries)
+	return transcript, true
+}
+
+// SubscribeRoom registers a subscription to the events of the participants
+// of a room that the user may read; it must be cancelled with Unsubscribe
+func (h *Hub) SubscribeRoom(id, user string, partials bool) *Subscription {
+	sub := &Subscription{
+		Events:   make(chan *Event, 64),
+		user:     user,
+		room:     id,
+		partials: partials,
+	}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// allowed reports whether the user may access the room
+func (h *Hub) allowed(rm *room, user string) bool {
+	return h.roomAccess == nil || h.roomAccess(rm.owner, user)
+}
+
+// record updates the room of the event, h.mu must be held. It returns the
+// room, nil when the event is not of a room
+func (h *Hub) record(event *Event) *room {
+	if event.Room == "" {
+		return nil
+	}
+	now := time.Now()
+	h.expireRooms(now)
+	rm, ok := h.rooms[event.Room]
+	if !ok {
+		rm = &room{owner: event.User, members: make(map[string]string)}
+		h.rooms[event.Room] = rm
+	}
+	switch event.Type {
+	case "session.started":
+		rm.members[event.Session] = event.Speaker
+		rm.emptied = time.Time{}
+	case "session.ended":
+		delete(rm.members, event.Session)
+		if len(rm.members) == 0 {
+			rm.emptied = now
+		}
+	case "segment":
+		if event.Final && event.Code == "" && event.Text != "" {
+			rm.add(RoomEntry{
+				Speaker:  event.Speaker,
+				User:     event.User,
+				Session:  event.Session,
+				Language: event.Language,
+				Text:     event.Text,
+				StartMs:  event.StartMs,
+			})
+		}
+	}
+	return rm
+}
+
+// expireRooms forgets the rooms empty for roomRetention, h.mu must be held
+func (h *Hub) expireRooms(now time.Time) {
+	for id, rm := range h.rooms {
+		if len(rm.members) == 0 && !rm.emptied.IsZero() && now.Sub(rm.emptied) >= roomRetention {
+			delete(h.rooms, id)
+		}
+	}
+}
+
+// add inserts the entry in the order of the start of the speech, the
+// segments of the participants arrive when their transcription completes
+func (rm *room) add(entry RoomEntry) {
+	i := len(rm.entries)
+	for i > 0 && rm.entries[i-1].StartMs > entry.StartMs {
+		i--
+	}
+	rm.entries = append(rm.entries, RoomEntry{})
+	copy(rm.entries[i+1:], rm.entries[i:])
+	rm.entries[i] = entry
+	if len(rm.entries) > maxRoomEntries {
+		rm.entries = rm.entries[len(rm.entries)-maxRoomEntries:]
+	}
+}
+
+// participants returns the sorted names of the connected speakers
+func (rm *room) participants() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, speaker := range rm.members {
+		if !seen[speaker] {
+			seen[speaker] = true
+			names = append(names, speaker)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxSamplerKeys bounds the number of keys a Sampler remembers before
+// it forgets the ones that have been quiet for a whole interval
+const maxSamplerKeys = 1024
+
+// Sampler rate limits repeated log messages on hot paths, at most one
+// message per key is written every interval and the rest are counted
+// and reported with the next message that gets through
+type Sampler struct {
+	interval time.Duration
+	mu       sync.Mutex
+	entries  map[string]*samplerEntry
+}
+
+type samplerEntry struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// NewSampler creates a new Sampler that lets one message per key
+// through every interval
+func NewSampler(interval time.Duration) *Sampler {
+	return &Sampler{
+		interval: interval,
+		entries:  make(map[string]*samplerEntry),
+	}
+}
+
+// Printf logs the message unless another message with the same key was
+// logged less than one interval ago
+func (s *Sampler) Printf(key string, format string, args ...interface{}) {
+	suppressed, ok := s.allow(key)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (suppressed %d similar messages)", msg, suppressed)
+	}
+	log.Print(msg)
+}
+
+// allow reports whether a message for key may be logged now, and how many
+// messages were suppressed since the last one
+func (s *Sampler) allow(key string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, exists := s.entries[key]
+	if !exists {
+		if len(s.entries) >= maxSamplerKeys {
+			s.prune(now)
+		}
+		s.entries[key] = &samplerEntry{lastLogged: now}
+		return 0, true
+	}
+
+	if now.Sub(entry.lastLogged) < s.interval {
+		entry.suppressed++
+		return 0, false
+	}
+
+	suppressed := entry.suppressed
+	entry.lastLogged = now
+	entry.suppressed = 0
+	return suppressed, true
+}
+
+// prune drops the keys that have been quiet for longer than the interval,
+// must be called with mu held
+func (s *Sampler) prune(now time.Time) {
+	for key, entry := range s.entries {
+		if now.Sub(entry.lastLogged) >= s.interval {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Download is the progress of a model being downloaded
+type Download struct {
+	Name      string    `json:"name"`
+	Bytes     int64     `json:"bytes"`
+	Total     int64     `json:"total"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// treeEntry is a file of a Hugging Face repository, the large files are
+// stored with LFS and carry their SHA-256
+type treeEntry struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	LFS  *struct {
+		OID string `json:"oid"`
+	} `json:"lfs"`
+}
+
+// Downloads returns the downloads in progress
+func (s *Store) Downloads() []Download {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	downloads := make([]Download, 0, len(s.downloads))
+	for _, d := range s.downloads {
+		downloads = append(downloads, Download{
+			Name:      d.Name,
+			Bytes:     atomic.LoadInt64(&d.Bytes),
+			Total:     d.Total,
+			StartedAt: d.StartedAt,
+		})
+	}
+	sort.Slice(downloads, func(i, j int) bool {
+		return downloads[i].Name < downloads[j].Name
+	})
+	return downloads
+}
+
+// Pull downloads a model unless it is already cached. The files are
+// verified against their published SHA-256 and the model only appears in
+// the directory once complete
+func (s *Store) Pull(ctx context.Context, name string) error {
+	repo, ok := repositories[name]
+	if !ok {
+		return fmt.Errorf("unknown model %q, available models: %s", name, strings.Join(Names(), ", "))
+	}
+	if _, ok := s.Path(name); ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	if _, ok := s.downloads[name]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("model %s is already being downloaded", name)
+	}
+	download := &Download{Name: name, StartedAt: time.Now()}
+	s.downloads[name] = download
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.downloads, name)
+		s.mu.Unlock()
+	}()
+
+	entries, err := s.tree(ctx, repo)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	s.mu.Lock()
+	download.Total = total
+	s.mu.Unlock()
+	log.Printf("Downloading Whisper model %s from %s (%d MB)", name, repo, total>>20)
+
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	var sums strings.Builder
+	progress := &progressWriter{download: download, total: total}
+	for _, e := range entries {
+		sum, err := s.fetch(ctx, repo, e, filepath.Join(tmp, e.Path), progress)
+		if err != nil {
+			os.RemoveAll(tmp)
+			return fmt.Errorf("failed to download %s: %w", e.Path, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, e.Path)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, checksumsFile), []byte(sums.String()), 0644); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	log.Printf("Downloaded Whisper model %s to %s in %s", name, filepath.Join(s.dir, name), time.Since(download.StartedAt).Round(time.Second))
+	return nil
+}
+
+// tree returns the files of the model in a repository
+func (s *Store) tree(ctx context.Context, repo string) ([]treeEntry, error) {
+	resp, err := s.get(ctx, s.endpoint+"/api/models/"+repo+"/tree/main")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var all []treeEntry
+	if err := json.NewDecoder(resp.Body).Decode(&all); err != nil {
+		return nil, fmt.Errorf("invalid file list of %s: %w", repo, err)
+	}
+	var entries []treeEntry
+	for _, e := range all {
+		if e.Type != "file" || strings.HasPrefix(e.Path, ".") || strings.Contains(e.Path, "/") || strings.EqualFold(e.Path, "README.md") {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("repository %s has no model files", repo)
+	}
+	return entries, nil
+}
+
+// fetch downloads a file of the repository and returns its SHA-256
+func (s *Store) fetch(ctx context.Context, repo string, e treeEntry, path string, progress io.Writer) (string, error) {
+	resp, err := s.get(ctx, s.endpoint+"/"+repo+"/resolve/main/"+e.Path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash, progress), resp.Body); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if e.LFS != nil && e.LFS.OID != "" && !strings.EqualFold(e.LFS.OID, sum) {
+		return "", fmt.Errorf("checksum mismatch, expected %s, got %s", e.LFS.OID, sum)
+	}
+	return sum, nil
+}
+
+func (s *Store) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned HTTP status %d", url, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// progressWriter counts the downloaded bytes and logs every 10%
+type progressWriter struct {
+	download *Download
+	total    int64
+	logged   int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	bytes := atomic.AddInt64(&p.download.Bytes, int64(len(b)))
+	if p.total > 0 {
+		if percent := bytes * 100 / p.total; percent/10 > p.logged/10 {
+			p.logged = percent
+			log.Printf("Downloading Whisper model %s: %d%%", p.download.Name, percent)
+		}
+	}
+	return len(b), nil
+}
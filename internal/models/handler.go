@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// status is the state of the models directory
+type status struct {
+	Dir       string     `json:"dir"`
+	Models    []Model    `json:"models"`
+	Downloads []Download `json:"downloads"`
+	Available []string   `json:"available"`
+}
+
+// MakeAdminHandler returns an HTTP handler to list the cached models and
+// the downloads in progress (GET), download a model in the background
+// (POST {"name": "small"}) and remove one (DELETE ?name=)
+func MakeAdminHandler(s *Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			models, err := s.List()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, status{Dir: s.Dir(), Models: models, Downloads: s.Downloads(), Available: Names()})
+
+		case http.MethodPost:
+			var req struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if _, ok := repositories[req.Name]; !ok {
+				http.Error(w, "Unknown model", http.StatusBadRequest)
+				return
+			}
+			if _, ok := s.Path(req.Name); ok {
+				writeJSON(w, req)
+				return
+			}
+			log.Printf("Download of Whisper model %s requested by %s", req.Name, auth.UserFromContext(r.Context()))
+			go func() {
+				if err := s.Pull(context.Background(), req.Name); err != nil {
+					log.Printf("Error downloading Whisper model %s: %v", req.Name, err)
+				}
+			}()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(req)
+
+		case http.MethodDelete:
+			name := r.URL.Query().Get("name")
+			found, err := s.Remove(name)
+			if !found {
+				http.Error(w, "Model not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Printf("Whisper model %s removed by %s", name, auth.UserFromContext(r.Context()))
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// selection is the Whisper model of the new streams
+type selection struct {
+	Model string `json:"model"`
+	Path  string `json:"path,omitempty"` // Directory of the cached model, empty when Whisper finds it
+}
+
+// MakeSelectHandler returns an HTTP handler to show (GET) and switch (POST
+// {"model": "medium"}) the Whisper model of the new streams without a
+// restart, the streams in progress keep theirs. The models of the cache
+// directory are used from there, Whisper finds or downloads the others.
+// current returns the name of the model, set switches the transcribers to
+// the model at path
+func MakeSelectHandler(s *Store, current func() string, set func(name, path string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			name := current()
+			path, _ := s.Path(name)
+			writeJSON(w, selection{Model: name, Path: path})
+
+		case http.MethodPost:
+			var req selection
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			path, cached := s.Path(req.Model)
+			if _, ok := repositories[req.Model]; !ok && !cached {
+				http.Error(w, "Unknown model", http.StatusBadRequest)
+				return
+			}
+			if !cached {
+				path = req.Model
+			}
+			if err := set(req.Model, path); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Printf("Whisper model switched to %s by %s", req.Model, auth.UserFromContext(r.Context()))
+			if !cached {
+				log.Printf("Warning: Whisper model %s is not in %s, the first stream downloads it", req.Model, s.Dir())
+				path = ""
+			}
+			writeJSON(w, selection{Model: req.Model, Path: path})
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
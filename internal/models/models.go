@@ -0,0 +1,211 @@
+package models
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checksumsFile lists the SHA-256 of the files of a downloaded model, in
+// the format of sha256sum
+const checksumsFile = "SHA256SUMS"
+
+// repositories maps the Whisper model names to their CTranslate2
+// conversion on Hugging Face, the format used by whisper-ctranslate2
+var repositories = map[string]string{
+	"tiny.en":   "Systran/faster-whisper-tiny.en",
+	"tiny":      "Systran/faster-whisper-tiny",
+	"base.en":   "Systran/faster-whisper-base.en",
+	"base":      "Systran/faster-whisper-base",
+	"small.en":  "Systran/faster-whisper-small.en",
+	"small":     "Systran/faster-whisper-small",
+	"medium.en": "Systran/faster-whisper-medium.en",
+	"medium":    "Systran/faster-whisper-medium",
+	"large-v1":  "Systran/faster-whisper-large-v1",
+	"large-v2":  "Systran/faster-whisper-large-v2",
+	"large-v3":  "Systran/faster-whisper-large-v3",
+}
+
+// Names returns the names of the models that can be downloaded
+func Names() []string {
+	names := make([]string, 0, len(repositories))
+	for name := range repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultDir returns the directory searched first for Whisper models,
+// ~/.cache/whisper
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".cache", "whisper")
+	}
+	return filepath.Join(home, ".cache", "whisper")
+}
+
+// File is a file of a model
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Model is a model cached in the models directory
+type Model struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files []File `json:"files"`
+}
+
+// Store manages the Whisper models of a directory, each model is a
+// subdirectory named after it
+type Store struct {
+	dir      string
+	endpoint string
+
+	mu        sync.Mutex
+	downloads map[string]*Download
+}
+
+// NewStore creates a new Store of the models directory, endpoint is the
+// Hugging Face server, https://huggingface.co when empty
+func NewStore(dir, endpoint string) *Store {
+	if endpoint == "" {
+		endpoint = "https://huggingface.co"
+	}
+	return &Store{
+		dir:       dir,
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		downloads: make(map[string]*Download),
+	}
+}
+
+// Dir returns the models directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Path returns the directory of a cached model
+func (s *Store) Path(name string) (string, bool) {
+	if !validName(name) {
+		return "", false
+	}
+	path := filepath.Join(s.dir, name)
+	if _, err := os.Stat(filepath.Join(path, "model.bin")); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// List returns the cached models. The checksums recorded at download are
+// used, the other files are hashed
+func (s *Store) List() ([]Model, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []Model{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	models := []Model{}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path, ok := s.Path(entry.Name())
+		if !ok {
+			continue
+		}
+		model, err := describe(entry.Name(), path)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// Remove deletes a cached model, it reports whether the model existed
+func (s *Store) Remove(name string) (bool, error) {
+	path, ok := s.Path(name)
+	if !ok {
+		return false, nil
+	}
+	s.mu.Lock()
+	_, downloading := s.downloads[name]
+	s.mu.Unlock()
+	if downloading {
+		return true, fmt.Errorf("model %s is being downloaded", name)
+	}
+	return true, os.RemoveAll(path)
+}
+
+func describe(name, path string) (Model, error) {
+	model := Model{Name: name, Path: path, Files: []File{}}
+	recorded := readChecksums(filepath.Join(path, checksumsFile))
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return model, err
+	}
+	for _, f := range files {
+		if f.IsDir() || f.Name() == checksumsFile || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		sum, ok := recorded[f.Name()]
+		if !ok {
+			if sum, err = hashFile(filepath.Join(path, f.Name())); err != nil {
+				return model, err
+			}
+		}
+		model.Files = append(model.Files, File{Name: f.Name(), Size: f.Size(), SHA256: sum})
+		model.Size += f.Size()
+	}
+	return model, nil
+}
+
+func readChecksums(path string) map[string]string {
+	sums := make(map[string]string)
+	file, err := os.Open(path)
+	if err != nil {
+		return sums
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+		}
+	}
+	return sums
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// validName rejects the names escaping the models directory
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
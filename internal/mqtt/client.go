@@ -0,0 +1,367 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+const keepAlive = 60 * time.Second
+
+// ackTimeout bounds the wait for the PUBACK of a QoS 1 message
+const ackTimeout = 10 * time.Second
+
+// Client is a minimal MQTT 3.1.1 client that can only publish, it connects
+// lazily and reconnects on the next publish after the connection drops
+type Client struct {
+	broker   *url.URL
+	clientID string
+	username string
+	password string
+	mu       sync.Mutex
+	conn     net.Conn
+	lost     chan struct{} // Closed when conn drops
+	packetID uint16
+	inflight map[uint16]chan struct{} // QoS 1 messages waiting for their PUBACK
+}
+
+// NewClient creates a new Client for a broker URL such as tcp://host:1883
+// or ssl://host:8883
+func NewClient(broker, clientID, username, password string) (*Client, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker URL: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker scheme: %s", u.Scheme)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("MQTT broker URL requires a port: %s", broker)
+	}
+	return &Client{
+		broker:   u,
+		clientID: clientID,
+		username: username,
+		password: password,
+		inflight: make(map[uint16]chan struct{}),
+	}, nil
+}
+
+// Publish sends the payload to the topic with QoS 0 or 1. It retries once
+// on a fresh connection if the write fails or, with QoS 1, if the PUBACK
+// does not arrive, the message is then resent with the DUP flag
+func (c *Client) Publish(topic string, qos byte, retain bool, payload []byte) error {
+	var id uint16
+	var acked chan struct{}
+	if qos > 0 {
+		id, acked = c.track()
+		defer c.untrack(id)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn net.Conn
+		var lost chan struct{}
+		if conn, lost, err = c.send(topic, qos, retain, attempt > 0, id, payload); err != nil {
+			continue
+		}
+		if qos == 0 {
+			return nil
+		}
+		timer := time.NewTimer(ackTimeout)
+		select {
+		case <-acked:
+			timer.Stop()
+			return nil
+		case <-lost:
+			timer.Stop()
+			err = fmt.Errorf("MQTT connection lost before the PUBACK")
+		case <-timer.C:
+			err = fmt.Errorf("no PUBACK from the MQTT broker within %v", ackTimeout)
+			c.drop(conn, err)
+		}
+	}
+	return err
+}
+
+// send writes a PUBLISH on the connection, connecting first when needed,
+// and returns the connection with its lost channel
+func (c *Client) send(topic string, qos byte, retain, dup bool, id uint16, payload []byte) (net.Conn, chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, nil, err
+		}
+	}
+	conn, lost := c.conn, c.lost
+	if err := c.writePublish(topic, qos, retain, dup, id, payload); err != nil {
+		c.disconnect()
+		return nil, nil, err
+	}
+	return conn, lost, nil
+}
+
+// track allocates the packet identifier of a QoS 1 message, the returned
+// channel receives its PUBACK
+func (c *Client) track() (uint16, chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		c.packetID++
+		if _, used := c.inflight[c.packetID]; c.packetID != 0 && !used {
+			break
+		}
+	}
+	acked := make(chan struct{}, 1)
+	c.inflight[c.packetID] = acked
+	return c.packetID, acked
+}
+
+func (c *Client) untrack(id uint16) {
+	c.mu.Lock()
+	delete(c.inflight, id)
+	c.mu.Unlock()
+}
+
+// acknowledge delivers the PUBACK of a message
+func (c *Client) acknowledge(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if acked, ok := c.inflight[id]; ok {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.Write([]byte{packetDisconnect << 4, 0})
+	return c.disconnect()
+}
+
+// disconnect closes the connection and signals the publishes waiting for
+// a PUBACK, must be called with mu held
+func (c *Client) disconnect() error {
+	err := c.conn.Close()
+	close(c.lost)
+	c.conn = nil
+	return err
+}
+
+// connect dials the broker and performs the CONNECT/CONNACK exchange,
+// must be called with mu held
+func (c *Client) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch c.broker.Scheme {
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.broker.Host, &tls.Config{ServerName: c.broker.Hostname()})
+	default:
+		conn, err = dialer.Dial("tcp", c.broker.Host)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4) // Protocol level 3.1.1
+	flags := byte(0x02)    // Clean session
+	if c.username != "" {
+		flags |= 0x80
+		if c.password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	seconds := uint16(keepAlive / time.Second)
+	body = append(body, byte(seconds>>8), byte(seconds))
+	body = appendString(body, c.clientID)
+	if c.username != "" {
+		body = appendString(body, c.username)
+		if c.password != "" {
+			body = appendString(body, c.password)
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(packet(packetConnect<<4, body)); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	header, ack, err := readPacket(reader)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+	if header>>4 != packetConnack || len(ack) != 2 {
+		conn.Close()
+		return fmt.Errorf("unexpected MQTT packet type: %d", header>>4)
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		return fmt.Errorf("MQTT broker refused connection (return code: %d)", ack[1])
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.conn = conn
+	c.lost = make(chan struct{})
+	go c.readLoop(conn, reader)
+	go c.pingLoop(conn)
+	log.Printf("Connected to MQTT broker %s", c.broker.Host)
+	return nil
+}
+
+// writePublish must be called with mu held, id is the packet identifier of
+// a QoS 1 message
+func (c *Client) writePublish(topic string, qos byte, retain, dup bool, id uint16, payload []byte) error {
+	header := byte(packetPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	if dup && qos > 0 {
+		header |= 0x08
+	}
+	body := appendString(nil, topic)
+	if qos > 0 {
+		body = append(body, byte(id>>8), byte(id))
+	}
+	body = append(body, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(packet(header, body))
+	return err
+}
+
+// readLoop consumes the packets sent by the broker (PUBACK, PINGRESP)
+// until the connection fails
+func (c *Client) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		header, body, err := readPacket(reader)
+		if err != nil {
+			c.drop(conn, err)
+			return
+		}
+		switch header >> 4 {
+		case packetPuback:
+			if len(body) == 2 {
+				c.acknowledge(binary.BigEndian.Uint16(body))
+			}
+		case packetPingresp:
+		default:
+			log.Printf("Ignoring MQTT packet type: %d", header>>4)
+		}
+	}
+}
+
+func (c *Client) pingLoop(conn net.Conn) {
+	ticker := time.NewTicker(keepAlive / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		if c.conn != conn {
+			c.mu.Unlock()
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write([]byte{packetPingreq << 4, 0})
+		c.mu.Unlock()
+		if err != nil {
+			c.drop(conn, err)
+			return
+		}
+	}
+}
+
+// drop forgets a broken connection so the next publish reconnects
+func (c *Client) drop(conn net.Conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		return
+	}
+	if err != io.EOF {
+		log.Printf("MQTT connection lost: %v", err)
+	}
+	c.disconnect()
+}
+
+// packet frames a control packet with its remaining length
+func packet(header byte, body []byte) []byte {
+	buf := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(buf, body...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(s)))
+	buf = append(buf, length[:]...)
+	return append(buf, s...)
+}
+
+func readPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,160 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// publish is a PUBLISH received by the fake broker
+type publish struct {
+	topic   string
+	qos     byte
+	dup     bool
+	id      uint16
+	payload string
+}
+
+// fakeBroker accepts the connections of a client and passes their
+// publishes to handle, which reports whether to acknowledge them
+func fakeBroker(t *testing.T, handle func(p publish) bool) (string, chan publish) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan publish, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if header, _, err := readPacket(reader); err != nil || header>>4 != packetConnect {
+					return
+				}
+				conn.Write([]byte{packetConnack << 4, 2, 0, 0})
+				for {
+					header, body, err := readPacket(reader)
+					if err != nil || header>>4 != packetPublish {
+						return
+					}
+					p := publish{qos: header >> 1 & 3, dup: header&0x08 != 0}
+					n := int(binary.BigEndian.Uint16(body))
+					p.topic, body = string(body[2:2+n]), body[2+n:]
+					if p.qos > 0 {
+						p.id, body = binary.BigEndian.Uint16(body), body[2:]
+					}
+					p.payload = string(body)
+					received <- p
+					if p.qos > 0 && handle(p) {
+						conn.Write([]byte{packetPuback << 4, 2, byte(p.id >> 8), byte(p.id)})
+					}
+				}
+			}()
+		}
+	}()
+	return "tcp://" + listener.Addr().String(), received
+}
+
+func TestPublishQoS0(t *testing.T) {
+	broker, received := fakeBroker(t, func(publish) bool { return true })
+	c, err := NewClient(broker, "test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Publish("a/b", 0, false, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-received; p.topic != "a/b" || p.qos != 0 || p.payload != "hello" {
+		t.Errorf("received %+v", p)
+	}
+}
+
+func TestPublishQoS1WaitsForPuback(t *testing.T) {
+	broker, received := fakeBroker(t, func(publish) bool { return true })
+	c, err := NewClient(broker, "test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 1; i <= 3; i++ {
+		if err := c.Publish("a/b", 1, false, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if p := <-received; p.qos != 1 || p.id != uint16(i) || p.dup {
+			t.Errorf("received %+v", p)
+		}
+	}
+	if len(c.inflight) != 0 {
+		t.Errorf("%d messages still in flight", len(c.inflight))
+	}
+}
+
+func TestPublishQoS1ResendsOnReconnect(t *testing.T) {
+	// The PUBACK of the first publish is lost with its connection
+	broker, received := fakeBroker(t, func(p publish) bool {
+		return p.dup
+	})
+	c, err := NewClient(broker, "test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Publish("a/b", 1, false, []byte("hello"))
+	}()
+	p := <-received
+	if p.dup {
+		t.Errorf("first publish has the DUP flag")
+	}
+	c.mu.Lock()
+	c.conn.Close() // The read loop drops the connection
+	c.mu.Unlock()
+
+	select {
+	case resent := <-received:
+		if !resent.dup || resent.id != p.id || resent.payload != "hello" {
+			t.Errorf("resent %+v, want a DUP of %+v", resent, p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not resent")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Publish: %v", err)
+	}
+}
+
+func TestSinkSanitizesTopic(t *testing.T) {
+	broker, received := fakeBroker(t, func(publish) bool { return true })
+	c, err := NewClient(broker, "test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sink := NewSink(c, Config{Topic: "transcriber/{user}/{session}"})
+	err = sink.Send(events.Event{
+		Type:    events.Segment,
+		User:    "eve/+/#",
+		Session: "a/b",
+		Result:  &transcribe.Result{Text: "hi", Final: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := <-received; p.topic != "transcriber/eve____/a_b" {
+		t.Errorf("topic %q", p.topic)
+	}
+}
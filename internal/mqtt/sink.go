@@ -0,0 +1,81 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Event is the JSON message published for session lifecycle changes
+// and transcription results
+type Event struct {
+	Type    string             `json:"type"` // session.started, result, session.ended
+	Session string             `json:"session"`
+	User    string             `json:"user,omitempty"`
+	Time    time.Time          `json:"time"`
+	Result  *transcribe.Result `json:"result,omitempty"`
+}
+
+// Config holds the publishing configuration
+type Config struct {
+	Topic    string // Topic template, {user} and {session} are replaced by a topic level
+	QoS      byte
+	Partials bool // Publish non-final results too
+}
+
+// Sink publishes the session events and results to an MQTT broker
+type Sink struct {
+	client *Client
+	config Config
+}
+
+// NewSink creates a new events.Sink publishing with the client
+func NewSink(client *Client, config Config) *Sink {
+	if config.Topic == "" {
+		config.Topic = "transcriber/{user}/{session}"
+	}
+	return &Sink{
+		client: client,
+		config: config,
+	}
+}
+
+// levelReplacer replaces the separator and the wildcards of the MQTT topics
+var levelReplacer = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// topicLevel turns a name into a single topic level, so that a user or a
+// session cannot publish to the topics of another one
+func topicLevel(name string) string {
+	return levelReplacer.Replace(name)
+}
+
+// Name identifies the sink in the logs and dead letters
+func (s *Sink) Name() string {
+	return "mqtt"
+}
+
+// Send publishes the stream events, segments are published as results
+func (s *Sink) Send(e events.Event) error {
+	if !e.Streamed(s.config.Partials) {
+		return nil
+	}
+	event := Event{
+		Type:    e.Type,
+		Session: e.Session,
+		User:    e.User,
+		Time:    e.Time,
+	}
+	if e.Type == events.Segment {
+		event.Type = "result"
+		event.Result = e.Result
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return events.Permanent(err)
+	}
+	return s.client.Publish(e.Topic(s.config.Topic, topicLevel), s.config.QoS, false, payload)
+}
@@ -0,0 +1,71 @@
+package quota
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// MakeHandler returns an HTTP handler serving the usage of the
+// authenticated user in the current month
+func MakeHandler(t *Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, t.Usage(auth.UserFromContext(r.Context())))
+	})
+}
+
+// MakeAdminHandler returns an HTTP handler listing the usage of the users
+// (GET) and resetting the usage of a user in the current month
+// (DELETE ?user=)
+func MakeAdminHandler(t *Tracker, usernames func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, t.List(usernames()))
+
+		case http.MethodDelete:
+			user := r.URL.Query().Get("user")
+			if user == "" {
+				http.Error(w, "User required", http.StatusBadRequest)
+				return
+			}
+			if err := t.Reset(user); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Quota usage of %s reset by %s", user, auth.UserFromContext(r.Context()))
+			writeJSON(w, t.Usage(user))
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Require wraps handlers creating sessions, they answer 429 Too Many
+// Requests to the users out of minutes
+func Require(t *Tracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := auth.UserFromContext(r.Context()); user != "" && !t.Allowed(user) {
+			http.Error(w, "Monthly transcription quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
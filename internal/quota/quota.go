@@ -0,0 +1,253 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles of the users, a user limit takes precedence over the limit of its
+// role
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// rolePrefix marks the role limits of a spec
+const rolePrefix = "role:"
+
+// unlimited is the limit of the users without a quota
+const unlimited = -1
+
+// ErrExceeded is returned when a user has used the minutes of the month
+var ErrExceeded = errors.New("monthly transcription quota exceeded")
+
+// Limits are the transcription minutes per month of the users and roles
+type Limits struct {
+	users map[string]int
+	roles map[string]int
+}
+
+// ParseLimits parses a spec such as
+// "role:user=600, role:admin=unlimited, alice=1200"
+// where the values are minutes per month. The users without a limit or a
+// role limit are not limited
+func ParseLimits(spec string) (*Limits, error) {
+	limits := &Limits{users: make(map[string]int), roles: make(map[string]int)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid quota %q (expected user=minutes or role:<role>=minutes)", entry)
+		}
+		value := strings.TrimSpace(parts[1])
+		minutes := unlimited
+		if value != "unlimited" {
+			var err error
+			if minutes, err = strconv.Atoi(value); err != nil || minutes < 0 {
+				return nil, fmt.Errorf("invalid minutes of quota %s: %q", name, value)
+			}
+		}
+		if strings.HasPrefix(name, rolePrefix) {
+			role := strings.TrimPrefix(name, rolePrefix)
+			if role != RoleUser && role != RoleAdmin {
+				return nil, fmt.Errorf("unknown role %q (expected %s or %s)", role, RoleUser, RoleAdmin)
+			}
+			limits.roles[role] = minutes
+			continue
+		}
+		limits.users[name] = minutes
+	}
+	return limits, nil
+}
+
+// minutes returns the limit of a user, unlimited when none applies
+func (l *Limits) minutes(user, role string) int {
+	if minutes, ok := l.users[user]; ok {
+		return minutes
+	}
+	if minutes, ok := l.roles[role]; ok {
+		return minutes
+	}
+	return unlimited
+}
+
+// Usage is the transcription time of a user in the current month
+type Usage struct {
+	User             string  `json:"user"`
+	Month            string  `json:"month"`
+	UsedMinutes      float64 `json:"used_minutes"`
+	Unlimited        bool    `json:"unlimited"`
+	LimitMinutes     int     `json:"limit_minutes"`
+	RemainingMinutes float64 `json:"remaining_minutes"`
+}
+
+// state is the content of the state file
+type state struct {
+	Month string             `json:"month"`
+	Used  map[string]float64 `json:"used"` // Seconds by user
+}
+
+// Tracker counts the transcription time of the users per calendar month
+// (UTC) and persists it in a state file, it is safe for concurrent use
+type Tracker struct {
+	limits    *Limits
+	role      func(user string) string
+	stateFile string
+
+	mu    sync.Mutex
+	state state
+}
+
+// NewTracker creates a new Tracker restoring the usage of the current
+// month from the state file, role returns the role of a user
+func NewTracker(limits *Limits, role func(user string) string, stateFile string) (*Tracker, error) {
+	t := &Tracker{
+		limits:    limits,
+		role:      role,
+		stateFile: stateFile,
+		state:     state{Month: month(), Used: make(map[string]float64)},
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	saved := state{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid quota state file %s: %w", stateFile, err)
+	}
+	if saved.Month == t.state.Month && saved.Used != nil {
+		t.state = saved
+	}
+	return t, nil
+}
+
+// month returns the current month, the usage is reset when it changes
+func month() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// rollMonth resets the usage at the beginning of a month, the lock must be
+// held
+func (t *Tracker) rollMonth() {
+	if current := month(); current != t.state.Month {
+		t.state = state{Month: current, Used: make(map[string]float64)}
+	}
+}
+
+// Allowed reports whether the user has minutes left this month
+func (t *Tracker) Allowed(user string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollMonth()
+	usage := t.usage(user)
+	return usage.Unlimited || usage.RemainingMinutes > 0
+}
+
+// Add counts seconds of transcription of a user and reports whether the
+// user is still within the quota
+func (t *Tracker) Add(user string, seconds float64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollMonth()
+	t.state.Used[user] += seconds
+	usage := t.usage(user)
+	return usage.Unlimited || usage.RemainingMinutes > 0
+}
+
+// Usage returns the usage of a user in the current month
+func (t *Tracker) Usage(user string) Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollMonth()
+	return t.usage(user)
+}
+
+// List returns the usage of the users, those with a usage this month and
+// the given ones, sorted by user
+func (t *Tracker) List(users []string) []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollMonth()
+	names := make(map[string]bool)
+	for _, user := range users {
+		names[user] = true
+	}
+	for user := range t.state.Used {
+		names[user] = true
+	}
+	list := []Usage{}
+	for user := range names {
+		list = append(list, t.usage(user))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].User < list[j].User
+	})
+	return list
+}
+
+// usage returns the usage of a user, the lock must be held
+func (t *Tracker) usage(user string) Usage {
+	used := t.state.Used[user] / 60
+	usage := Usage{
+		User:        user,
+		Month:       t.state.Month,
+		UsedMinutes: math.Round(used*100) / 100,
+	}
+	limit := t.limits.minutes(user, t.role(user))
+	if limit == unlimited {
+		usage.Unlimited = true
+		return usage
+	}
+	usage.LimitMinutes = limit
+	usage.RemainingMinutes = math.Max(0, math.Round((float64(limit)-used)*100)/100)
+	return usage
+}
+
+// Reset clears the usage of a user in the current month
+func (t *Tracker) Reset(user string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollMonth()
+	delete(t.state.Used, user)
+	return t.save()
+}
+
+// Save writes the usage to the state file
+func (t *Tracker) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.save()
+}
+
+// save writes the usage to the state file, the lock must be held
+func (t *Tracker) save() error {
+	data, err := json.MarshalIndent(t.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to save quota usage: %w", err)
+	}
+	tmp := t.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save quota usage: %w", err)
+	}
+	return os.Rename(tmp, t.stateFile)
+}
@@ -0,0 +1,71 @@
+package quota
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Service wraps a transcribe.Service, refusing the streams of the users
+// out of minutes and ending them once the quota is used up. The streams
+// without a user are not counted
+type Service struct {
+	next    transcribe.Service
+	tracker *Tracker
+}
+
+// stream wraps a transcribe.Stream, counting the audio written to it
+type stream struct {
+	transcribe.Stream
+	user    string
+	tracker *Tracker
+}
+
+// NewService creates a new transcribe.Service enforcing the quotas
+func NewService(next transcribe.Service, tracker *Tracker) transcribe.Service {
+	return &Service{
+		next:    next,
+		tracker: tracker,
+	}
+}
+
+// CreateStream creates a new stream, it has no user
+func (s *Service) CreateStream() (transcribe.Stream, error) {
+	return s.next.CreateStream()
+}
+
+// CreateStreamWithOptions creates a new stream counted in the quota of
+// its user
+func (s *Service) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	if opts.User == "" {
+		return s.next.CreateStreamWithOptions(opts)
+	}
+	if !s.tracker.Allowed(opts.User) {
+		return nil, fmt.Errorf("%w: %s", ErrExceeded, opts.User)
+	}
+	next, err := s.next.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{Stream: next, user: opts.User, tracker: s.tracker}, nil
+}
+
+// Write passes audio to the underlying stream and fails once the user has
+// used the minutes of the month
+func (st *stream) Write(buffer []byte) (int, error) {
+	n, err := st.Stream.Write(buffer)
+	if !st.tracker.Add(st.user, float64(n)/transcribe.PCMBytesPerSecond) && err == nil {
+		log.Printf("Ending the stream of %s, the monthly transcription quota is used up", st.user)
+		err = fmt.Errorf("%w: %s", ErrExceeded, st.user)
+	}
+	return n, err
+}
+
+// Close closes the underlying stream and saves the usage
+func (st *stream) Close() error {
+	if err := st.tracker.Save(); err != nil {
+		log.Printf("Error saving the quota usage: %v", err)
+	}
+	return st.Stream.Close()
+}
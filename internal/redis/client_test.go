@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	got := string(encode([]string{"SET", "key", "a\r\nb", ""}))
+	want := "*4\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\n$0\r\n\r\n"
+	if got != want {
+		t.Errorf("encode = %q, want %q", got, want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	for _, test := range []struct {
+		reply string
+		want  interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{":-1\r\n", int64(-1)},
+		{"$5\r\nhello\r\n", "hello"},
+		{"$6\r\nwith\r\n\r\n", "with\r\n"},
+		{"$0\r\n\r\n", ""},
+		{"$-1\r\n", nil},
+		{"*-1\r\n", nil},
+		{"*0\r\n", []interface{}{}},
+		{"*3\r\n$1\r\na\r\n:2\r\n$-1\r\n", []interface{}{"a", int64(2), nil}},
+		{"*2\r\n$1\r\n0\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n", []interface{}{"0", []interface{}{"x", "y"}}},
+		{"*2\r\n-ERR first\r\n+OK\r\n", []interface{}{Error("ERR first"), "OK"}},
+	} {
+		got, err := readReply(bufio.NewReader(strings.NewReader(test.reply)))
+		if err != nil {
+			t.Errorf("readReply(%q): %v", test.reply, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("readReply(%q) = %#v, want %#v", test.reply, got, test.want)
+		}
+	}
+}
+
+func TestReadReplyErrors(t *testing.T) {
+	reply, err := readReply(bufio.NewReader(strings.NewReader("-WRONGTYPE Operation\r\n")))
+	if e, ok := err.(Error); !ok || string(e) != "WRONGTYPE Operation" || reply != nil {
+		t.Errorf("error reply = %v, %v", reply, err)
+	}
+	for _, reply := range []string{
+		"",
+		"\r\n",
+		"?what\r\n",
+		"$x\r\n",
+		"*y\r\n",
+		"$5\r\nhel",
+		":12a\r\n",
+		"*2\r\n+OK\r\n",
+	} {
+		if _, err := readReply(bufio.NewReader(strings.NewReader(reply))); err == nil {
+			t.Errorf("readReply(%q) succeeded", reply)
+		}
+	}
+}
+
+// fakeServer answers the commands with handle and returns its address, it
+// serves one connection at a time until the test ends
+func fakeServer(t *testing.T, handle func(args []string) string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for {
+				request, err := readReply(reader)
+				if err != nil {
+					break
+				}
+				var args []string
+				for _, arg := range request.([]interface{}) {
+					args = append(args, arg.(string))
+				}
+				if _, err := conn.Write([]byte(handle(args))); err != nil {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClientAuthenticatesAndSelects(t *testing.T) {
+	var commands []string
+	server := fakeServer(t, func(args []string) string {
+		commands = append(commands, strings.Join(args, " "))
+		if args[0] == "GET" {
+			return "$5\r\nvalue\r\n"
+		}
+		return "+OK\r\n"
+	})
+	c, err := NewClient("redis://:secret@" + server + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	value, ok, err := c.String("GET", "key")
+	if err != nil || !ok || value != "value" {
+		t.Fatalf("GET = %q, %v, %v", value, ok, err)
+	}
+	want := []string{"AUTH secret", "SELECT 2", "GET key"}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
+
+func TestClientScan(t *testing.T) {
+	pages := map[string]string{
+		"0":  "*2\r\n$2\r\n17\r\n*2\r\n$7\r\nlogin:a\r\n$7\r\nlogin:b\r\n",
+		"17": "*2\r\n$2\r\n42\r\n*0\r\n",
+		"42": "*2\r\n$1\r\n0\r\n*1\r\n$7\r\nlogin:c\r\n",
+	}
+	server := fakeServer(t, func(args []string) string {
+		if args[0] != "SCAN" || args[2] != "MATCH" || args[3] != "login:*" {
+			return "-ERR unexpected " + strings.Join(args, " ") + "\r\n"
+		}
+		return pages[args[1]]
+	})
+	c, err := NewClient("redis://" + server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	keys, err := c.Scan("login:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"login:a", "login:b", "login:c"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Scan = %q, want %q", keys, want)
+	}
+}
+
+func TestClientErrorReplyKeepsConnection(t *testing.T) {
+	server := fakeServer(t, func(args []string) string {
+		if args[0] == "PING" {
+			return "+PONG\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+	c, err := NewClient("redis://" + server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Do("NOPE"); err == nil {
+		t.Fatal("error reply not returned")
+	} else if _, ok := err.(Error); !ok {
+		t.Fatalf("error %v is not a reply", err)
+	}
+	conn := c.conn
+	if reply, err := c.Do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("PING = %v, %v", reply, err)
+	}
+	if c.conn != conn {
+		t.Error("the connection was replaced after an error reply")
+	}
+}
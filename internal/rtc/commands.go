@@ -0,0 +1,185 @@
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Commands of the client on the DataChannel
+const (
+	commandMarker   = "marker"   // Inserts the marker Name at the current position
+	commandPause    = "pause"    // Replaces the audio by silence until resume
+	commandResume   = "resume"   // Transcribes the audio again
+	commandLanguage = "language" // Starts a new segment transcribed in Language
+	commandSegment  = "segment"  // Ends the current segment and starts a new one
+	commandPong     = "pong"     // Answers the Ping of a stats message
+)
+
+// maxLanguageLength bounds the language codes of the commands, e.g. "zh-CN"
+const maxLanguageLength = 16
+
+const (
+	// minSegmentDuration limits the rate of the segment and language
+	// commands, each of them starts a transcription stream
+	minSegmentDuration = time.Second
+	// maxFinalizingSegments bounds the ended segments of a session being
+	// finalized at the same time
+	maxFinalizingSegments = 4
+)
+
+// command is a message sent by the client on the DataChannel
+type command struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"` // Echoed by the reply
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	Ping     int64  `json:"ping,omitempty"`
+}
+
+// commandReply answers a command on the DataChannel with the state of the
+// session after it
+type commandReply struct {
+	Type     string `json:"type"` // Always "command"
+	ID       string `json:"id,omitempty"`
+	Command  string `json:"command"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Paused   bool   `json:"paused"`
+	Language string `json:"language"`
+	Segment  int    `json:"segment"` // Number of the current segment, from 1
+}
+
+// parseCommand decodes a message of the client
+func parseCommand(data []byte) (command, error) {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return cmd, fmt.Errorf("invalid DataChannel command: %w", err)
+	}
+	return cmd, nil
+}
+
+// controlledStream is the transcription stream of a track with the state
+// the commands of the client change. It is only used by the loop of
+// handleAudioTrack. A segment is a transcription stream of the session:
+// ending it finalizes its recording and transcript, delivered right away,
+// and the audio continues in a new stream, which is also how the language
+// changes since the vendors fix it for a stream
+type controlledStream struct {
+	transcribe.Stream
+	create   func(language string) (transcribe.Stream, error)
+	finished func(stream transcribe.Stream) // Closes an ended segment and delivers its results
+
+	language   string
+	paused     bool
+	segment    int
+	started    time.Time // Start of the current segment
+	silence    []byte
+	segments   sync.WaitGroup // Ended segments being finalized
+	finalizing int32          // Number of them, atomic
+}
+
+func newControlledStream(language string, create func(language string) (transcribe.Stream, error), finished func(stream transcribe.Stream)) (*controlledStream, error) {
+	stream, err := create(language)
+	if err != nil {
+		return nil, err
+	}
+	return &controlledStream{
+		Stream:   stream,
+		create:   create,
+		finished: finished,
+		language: language,
+		segment:  1,
+		started:  time.Now(),
+	}, nil
+}
+
+// Write transcribes the PCM, replaced by silence while the stream is paused
+// so that the vendors keep their connection and the session its timing
+func (c *controlledStream) Write(pcm []byte) (int, error) {
+	if !c.paused {
+		return c.Stream.Write(pcm)
+	}
+	if len(c.silence) < len(pcm) {
+		c.silence = make([]byte, len(pcm))
+	}
+	return c.Stream.Write(c.silence[:len(pcm)])
+}
+
+// execute runs a command of the client and returns its reply
+func (c *controlledStream) execute(cmd command) commandReply {
+	var err error
+	switch cmd.Type {
+	case commandMarker:
+		marker, ok := c.Stream.(transcribe.MarkingStream)
+		if !ok {
+			err = fmt.Errorf("markers are not supported by the transcription stream")
+			break
+		}
+		_, err = marker.Mark(cmd.Name)
+	case commandPause:
+		c.paused = true
+	case commandResume:
+		c.paused = false
+	case commandLanguage:
+		switch {
+		case cmd.Language == "" || len(cmd.Language) > maxLanguageLength:
+			err = fmt.Errorf("the language must have 1 to %d characters", maxLanguageLength)
+		case cmd.Language != c.language:
+			err = c.restart(cmd.Language)
+		}
+	case commandSegment:
+		err = c.restart(c.language)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd.Type)
+	}
+
+	reply := commandReply{
+		Type:     "command",
+		ID:       cmd.ID,
+		Command:  cmd.Type,
+		OK:       err == nil,
+		Paused:   c.paused,
+		Language: c.language,
+		Segment:  c.segment,
+	}
+	if err != nil {
+		log.Printf("DataChannel command %s failed: %v", cmd.Type, err)
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// restart ends the current segment and continues the session in a new one
+// transcribed in language, the current one is kept when it cannot start or
+// the segments are restarted too often
+func (c *controlledStream) restart(language string) error {
+	if time.Since(c.started) < minSegmentDuration {
+		return fmt.Errorf("segments must last at least %v", minSegmentDuration)
+	}
+	if atomic.LoadInt32(&c.finalizing) >= maxFinalizingSegments {
+		return fmt.Errorf("%d segments are still being finalized", maxFinalizingSegments)
+	}
+	stream, err := c.create(language)
+	if err != nil {
+		return fmt.Errorf("failed to start a new segment: %w", err)
+	}
+	ended := c.Stream
+	c.Stream = stream
+	c.language = language
+	c.segment++
+	c.started = time.Now()
+	c.segments.Add(1)
+	atomic.AddInt32(&c.finalizing, 1)
+	go func() {
+		defer c.segments.Done()
+		defer atomic.AddInt32(&c.finalizing, -1)
+		c.finished(ended)
+	}()
+	return nil
+}
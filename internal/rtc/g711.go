@@ -0,0 +1,95 @@
+package rtc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// Codecs of the G.711 tracks, with their static RTP payload types
+const (
+	PCMU = "PCMU"
+	PCMA = "PCMA"
+
+	payloadTypePCMU = 0
+	payloadTypePCMA = 8
+	g711ClockRate   = 8000
+)
+
+// trackDecoder decodes the packets of a track to 48 kHz mono 16-bit
+// little-endian PCM, the format of the transcription streams. The returned
+// buffer may be reused by the next call
+type trackDecoder interface {
+	Decode(encoded []byte) ([]byte, error)
+}
+
+// newTrackDecoder returns the decoder of the codec of a track and the clock
+// rate of its RTP timestamps
+func newTrackDecoder(codec *webrtc.RTPCodec) (trackDecoder, int, error) {
+	switch {
+	case codec == nil:
+		return nil, 0, fmt.Errorf("track has no codec")
+	case strings.EqualFold(codec.Name, webrtc.Opus):
+		decoder, err := NewOpusDecoder()
+		return decoder, decodedRate, err
+	case strings.EqualFold(codec.Name, PCMU), strings.EqualFold(codec.Name, PCMA):
+		decoder, err := NewG711Decoder(codec.Name)
+		return decoder, g711ClockRate, err
+	}
+	return nil, 0, fmt.Errorf("unsupported audio codec %s", codec.Name)
+}
+
+// supportedCodec returns whether the audio tracks of the codec are decoded
+func supportedCodec(codec *webrtc.RTPCodec) bool {
+	if codec == nil {
+		return false
+	}
+	for _, name := range []string{webrtc.Opus, PCMU, PCMA} {
+		if strings.EqualFold(codec.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// newMediaEngine returns the codecs negotiated with the peers: the default
+// ones, Opus first, then G.711 for the SIP-originated and legacy clients
+func newMediaEngine() webrtc.MediaEngine {
+	m := webrtc.MediaEngine{}
+	m.RegisterDefaultCodecs()
+	// The payloaders are only used to send, the tracks are only received
+	m.RegisterCodec(webrtc.NewRTPCodec(webrtc.RTPCodecTypeAudio, PCMU, g711ClockRate, 0, "", payloadTypePCMU, nil))
+	m.RegisterCodec(webrtc.NewRTPCodec(webrtc.RTPCodecTypeAudio, PCMA, g711ClockRate, 0, "", payloadTypePCMA, nil))
+	return m
+}
+
+// G711Decoder decodes PCMU or PCMA packets to 48 kHz mono 16-bit
+// little-endian PCM
+type G711Decoder struct {
+	decode    func(payload []byte) []int16
+	upsampler *audio.Upsampler
+}
+
+// NewG711Decoder creates a decoder of the PCMU or PCMA codec
+func NewG711Decoder(codec string) (*G711Decoder, error) {
+	d := &G711Decoder{upsampler: audio.NewUpsampler(g711ClockRate, decodedRate)}
+	switch strings.ToUpper(codec) {
+	case PCMU:
+		d.decode = audio.DecodeUlaw
+	case PCMA:
+		d.decode = audio.DecodeAlaw
+	default:
+		return nil, fmt.Errorf("unknown G.711 codec %s, expected %s or %s", codec, PCMU, PCMA)
+	}
+	return d, nil
+}
+
+// Decode decodes a packet
+func (d *G711Decoder) Decode(encoded []byte) ([]byte, error) {
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("empty G.711 packet")
+	}
+	return d.upsampler.Process(d.decode(encoded)), nil
+}
@@ -0,0 +1,220 @@
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// ICE transport policies of the clients, see SetTransportPolicy
+const (
+	ICEPolicyAll   = "all"
+	ICEPolicyRelay = "relay" // The clients only use their TURN relay candidates
+)
+
+// ICEServer is a STUN or TURN server of the peer connections, in the format
+// of the RTCIceServer of the browsers
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// SetTURNServer adds a TURN server (turn: or turns: URL) to the ICE servers
+// of the connections, relaying the media of the peers that cannot reach the
+// server directly, e.g. behind a symmetric NAT or a corporate firewall. The
+// clients gather the relay candidates, the ICE agent of pion v2.0 ignores
+// the TURN servers. It must be called before the connections are created,
+// an empty url removes it
+func (pi *PionRtcService) SetTURNServer(url, username, password string) error {
+	if url == "" {
+		pi.turn = nil
+		return nil
+	}
+	if !strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:") {
+		return fmt.Errorf("invalid TURN server %q, expected a turn: or turns: URL", url)
+	}
+	if username == "" || password == "" {
+		return fmt.Errorf("the TURN server %s needs a username and a password", url)
+	}
+	pi.turn = &ICEServer{URLs: []string{url}, Username: username, Credential: password}
+	return nil
+}
+
+// SetPortRange restricts the UDP ports of the ICE candidates of the server
+// to [min, max], e.g. the range a firewall opens. Each connection takes a
+// port per local address, and another per address for its STUN candidate;
+// the connections fail once the range is used up. 0, 0 uses any ephemeral
+// port. It must be called before the connections are created
+func (pi *PionRtcService) SetPortRange(min, max uint16) error {
+	var settings webrtc.SettingEngine
+	if err := settings.SetEphemeralUDPPortRange(min, max); err != nil {
+		return fmt.Errorf("invalid UDP port range %d-%d: %w", min, max, err)
+	}
+	if (min == 0) != (max == 0) {
+		return fmt.Errorf("invalid UDP port range %d-%d", min, max)
+	}
+	pi.portMin, pi.portMax = min, max
+	return nil
+}
+
+// ParsePortRange parses a port range, e.g. "50000-50100", empty for any port
+func ParsePortRange(value string) (uint16, uint16, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q, expected <min>-<max>", value)
+	}
+	min, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", value, err)
+	}
+	max, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", value, err)
+	}
+	if min == 0 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", value)
+	}
+	return uint16(min), uint16(max), nil
+}
+
+// SetTransportPolicy sets the ICE transport policy of the clients: with
+// ICEPolicyRelay they only send their media through the TURN server, so the
+// server only exchanges packets with it, e.g. behind a firewall opening its
+// ports to the TURN server alone. It needs a TURN server, see SetTURNServer.
+// The server keeps its host and STUN candidates since pion v2.0 gathers no
+// relay candidates
+func (pi *PionRtcService) SetTransportPolicy(policy string) error {
+	switch policy {
+	case "", ICEPolicyAll:
+		pi.policy = ""
+		return nil
+	case ICEPolicyRelay:
+		if pi.turn == nil {
+			return fmt.Errorf("the %s ICE transport policy needs a TURN server", policy)
+		}
+		pi.policy = policy
+		return nil
+	}
+	return fmt.Errorf("unknown ICE transport policy %q, expected %s or %s", policy, ICEPolicyAll, ICEPolicyRelay)
+}
+
+// SetPublicIP sets the public IP address of a 1:1 NAT in front of the
+// server, e.g. the elastic IP of an EC2 instance or the host of a Docker
+// container, that the host candidates of the same IP family advertise in
+// place of the local addresses. The NAT must forward the UDP ports unchanged,
+// see SetPortRange. It must be called before the connections are created, an
+// empty ip removes it
+func (pi *PionRtcService) SetPublicIP(ip string) error {
+	if ip == "" {
+		pi.publicIP = nil
+		return nil
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsUnspecified() || parsed.IsLoopback() {
+		return fmt.Errorf("invalid public IP address %q", ip)
+	}
+	pi.publicIP = parsed
+	return nil
+}
+
+// mapHostCandidates replaces the addresses of the host candidates of sdp by
+// the public IP of the NAT, pion v2.0 having no NAT 1:1 setting. The NAT
+// forwards the connectivity checks of the clients to the local addresses, so
+// the ICE agent finds the candidates by their sockets as usual
+func mapHostCandidates(sdp string, public net.IP) string {
+	if public == nil {
+		return sdp
+	}
+	ipv4 := public.To4() != nil
+	lines := strings.Split(sdp, "\r\n")
+	for i, line := range lines {
+		// a=candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 8 || fields[6] != "typ" || fields[7] != "host" {
+			continue
+		}
+		local := net.ParseIP(fields[4])
+		if local == nil || (local.To4() != nil) != ipv4 {
+			continue
+		}
+		fields[4] = public.String()
+		lines[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// settingEngine returns the settings of the connections
+func (pi *PionRtcService) settingEngine() webrtc.SettingEngine {
+	var settings webrtc.SettingEngine
+	if pi.portMin != 0 {
+		settings.SetEphemeralUDPPortRange(pi.portMin, pi.portMax)
+	}
+	return settings
+}
+
+// ICEConfig is the configuration of the peer connections of the clients, in
+// the format of the RTCConfiguration of the browsers
+type ICEConfig struct {
+	ICEServers         []ICEServer `json:"iceServers"`
+	ICETransportPolicy string      `json:"iceTransportPolicy,omitempty"`
+}
+
+// ICEConfig returns the configuration the clients should use
+func (pi *PionRtcService) ICEConfig() ICEConfig {
+	return ICEConfig{ICEServers: pi.ICEServers(), ICETransportPolicy: pi.policy}
+}
+
+// ICEServers returns the STUN and TURN servers of the connections, which the
+// clients should use too
+func (pi *PionRtcService) ICEServers() []ICEServer {
+	servers := []ICEServer{}
+	if pi.stunServer != "" {
+		servers = append(servers, ICEServer{URLs: []string{pi.stunServer}})
+	}
+	if pi.turn != nil {
+		servers = append(servers, *pi.turn)
+	}
+	return servers
+}
+
+// iceServers returns the ICE servers in the configuration of pion
+func (pi *PionRtcService) iceServers() []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, server := range pi.ICEServers() {
+		config := webrtc.ICEServer{URLs: server.URLs}
+		if server.Username != "" {
+			config.Username = server.Username
+			config.Credential = server.Credential
+			config.CredentialType = webrtc.ICECredentialTypePassword
+		}
+		servers = append(servers, config)
+	}
+	return servers
+}
+
+// MakeICEHandler returns an HTTP handler serving the ICE configuration the
+// clients configure their peer connections with, {"iceServers": [...]}
+func MakeICEHandler(config ICEConfig) http.Handler {
+	payload, _ := json.Marshal(config)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(payload)
+	})
+}
@@ -0,0 +1,104 @@
+package rtc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Settings of the jitter buffer
+const (
+	jitterPacket   = 20 * time.Millisecond // Audio of a WebRTC Opus packet
+	jitterMaxJump  = 1000                  // Sequence numbers ahead of a restarted sender rather than of lost packets
+	defaultJitter  = 60 * time.Millisecond // Default delay of SetJitterBuffer
+	maxJitterDelay = time.Second
+)
+
+// SetJitterBuffer sets the delay the sessions wait for the packets arriving
+// out of order, the packets are decoded in the order of their sequence
+// numbers. The packets missing after it are lost and concealed. It must be
+// called before the connections are created, 0 decodes the packets in their
+// order of arrival
+func (pi *PionRtcService) SetJitterBuffer(delay time.Duration) error {
+	if delay < 0 || delay > maxJitterDelay {
+		return fmt.Errorf("the jitter buffer delay must be between 0 and %v, got %v", maxJitterDelay, delay)
+	}
+	pi.jitterDelay = delay
+	return nil
+}
+
+// jitterBuffer reorders the packets of a track by their RTP sequence number,
+// holding up to depth packets after a missing one before skipping it
+type jitterBuffer struct {
+	depth   int
+	packets map[uint16]rtpPacket
+	started bool
+	next    uint16 // Sequence number of the next packet to release
+	highest uint16 // Highest sequence number received
+
+	reordered, late, lost int
+}
+
+func newJitterBuffer(delay time.Duration) *jitterBuffer {
+	return &jitterBuffer{
+		depth:   int(delay / jitterPacket),
+		packets: make(map[uint16]rtpPacket),
+	}
+}
+
+// push adds a packet and returns the packets ready to be decoded, in order
+func (jb *jitterBuffer) push(packet rtpPacket) []rtpPacket {
+	if !jb.started {
+		jb.started = true
+		jb.next, jb.highest = packet.sequence, packet.sequence
+	}
+	diff := int16(packet.sequence - jb.next)
+	if diff >= jitterMaxJump || diff <= -jitterMaxJump {
+		// The sender restarted its sequence, the held packets are released
+		ready := jb.flush()
+		jb.next, jb.highest = packet.sequence, packet.sequence
+		jb.packets[packet.sequence] = packet
+		return append(ready, jb.release()...)
+	}
+	if _, ok := jb.packets[packet.sequence]; ok || diff < 0 {
+		// Duplicate, or arrived after its position was released or skipped
+		jb.late++
+		return nil
+	}
+	if int16(packet.sequence-jb.highest) < 0 {
+		jb.reordered++
+	} else {
+		jb.highest = packet.sequence
+	}
+	jb.packets[packet.sequence] = packet
+	return jb.release()
+}
+
+// release returns the packets in order from the next one, skipping the
+// missing ones once depth packets are held after them
+func (jb *jitterBuffer) release() []rtpPacket {
+	var ready []rtpPacket
+	for len(jb.packets) > 0 {
+		packet, ok := jb.packets[jb.next]
+		if !ok {
+			if len(jb.packets) <= jb.depth {
+				break
+			}
+			jb.lost++
+			jb.next++
+			continue
+		}
+		delete(jb.packets, jb.next)
+		ready = append(ready, packet)
+		jb.next++
+	}
+	return ready
+}
+
+// flush returns the packets held, in order, when the track ends
+func (jb *jitterBuffer) flush() []rtpPacket {
+	depth := jb.depth
+	jb.depth = 0
+	ready := jb.release()
+	jb.depth = depth
+	return ready
+}
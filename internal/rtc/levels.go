@@ -0,0 +1,74 @@
+package rtc
+
+import (
+	"math"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// Settings of the level meter of the sessions
+const (
+	levelInterval = 100 * time.Millisecond // Audio of a level message
+	levelFloor    = -100                   // dBFS of the digital silence
+	mutedLevel    = -80                    // Peak in dBFS under which the microphone sends silence
+	mutedAfter    = 2 * time.Second        // Silence of a muted microphone
+)
+
+// levelMessage is sent on the DataChannel of the sessions created with
+// levels, e.g. {"type": "level", "rms": -32.5, "peak": -12.1}, for the
+// clients to show a VU meter
+type levelMessage struct {
+	Type  string  `json:"type"`            // level
+	RMS   float64 `json:"rms"`             // dBFS
+	Peak  float64 `json:"peak"`            // dBFS
+	Muted bool    `json:"muted,omitempty"` // Whether the microphone only sent silence for 2s
+}
+
+// levelMeter measures the levels of the decoded audio
+type levelMeter struct {
+	interval int // Samples per message
+	samples  int
+	sum      float64 // Energy of the samples
+	peak     float64
+	silence  int // Samples of silence in a row
+}
+
+func newLevelMeter() *levelMeter {
+	return &levelMeter{interval: int(int64(decodedRate) * int64(levelInterval) / int64(time.Second))}
+}
+
+// write measures the decoded PCM and returns the level message of the
+// interval it completes, nil otherwise
+func (m *levelMeter) write(pcm []byte) *levelMessage {
+	n := len(pcm) / 2
+	rms, peak := audio.Levels(pcm)
+	m.samples += n
+	m.sum += rms * rms * float64(n)
+	m.peak = math.Max(m.peak, peak)
+	if dBFS(peak) < mutedLevel {
+		m.silence += n
+	} else {
+		m.silence = 0
+	}
+	if m.samples < m.interval {
+		return nil
+	}
+
+	msg := &levelMessage{
+		Type:  "level",
+		RMS:   dBFS(math.Sqrt(m.sum / float64(m.samples))),
+		Peak:  dBFS(m.peak),
+		Muted: m.silence >= int(decodedRate*mutedAfter.Seconds()),
+	}
+	m.samples, m.sum, m.peak = 0, 0, 0
+	return msg
+}
+
+// dBFS converts a level relative to full scale to dBFS, rounded to 0.1dB
+func dBFS(level float64) float64 {
+	if level <= 0 {
+		return levelFloor
+	}
+	return math.Max(math.Round(200*math.Log10(level))/10, levelFloor)
+}
@@ -4,25 +4,32 @@ import (
 	"gopkg.in/hraban/opus.v2"
 )
 
-type opusDecoder struct {
+// maxOpusFrameSamples is the size of the longest Opus frame (120ms) at 48 kHz
+const maxOpusFrameSamples = 5760
+
+// OpusDecoder decodes Opus packets to 48 kHz mono 16-bit little-endian PCM,
+// the format of the transcription streams
+type OpusDecoder struct {
 	opusd *opus.Decoder
 	buffer  []byte
 	samples []int16
 }
 
-func newDecoder() (*opusDecoder, error) {
+// NewOpusDecoder creates a new OpusDecoder
+func NewOpusDecoder() (*OpusDecoder, error) {
 	opusd, err := opus.NewDecoder(48000, 1)
 	if err != nil {
 		return nil, err
 	}
-	return &opusDecoder{
+	return &OpusDecoder{
 		opusd: opusd,
-		buffer:  make([]byte, 2000),
-		samples: make([]int16, 1000),
+		buffer:  make([]byte, 2*maxOpusFrameSamples),
+		samples: make([]int16, maxOpusFrameSamples),
 	}, nil
 }
 
-func (d *opusDecoder) decode(encoded []byte) ([]byte, error) {
+// Decode decodes a packet, the returned buffer is reused by the next call
+func (d *OpusDecoder) Decode(encoded []byte) ([]byte, error) {
 	nsamples, err := d.opusd.Decode(encoded, d.samples)
 	if err != nil {
 		return nil, err
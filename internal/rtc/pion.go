@@ -6,35 +6,115 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v2"
+	"github.com/walterfan/webrtc-transcriber/internal/alert"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
 	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
 )
 
+// logSampler rate limits the per-packet log messages of the audio pipeline
+var logSampler = logging.NewSampler(10 * time.Second)
+
+// defaultGracePeriod is the time without audio after which a session is
+// finalized when the grace period is not set
+const defaultGracePeriod = 5 * time.Second
+
 // PionPeerConnection is a webrtc.PeerConnection wrapper that implements the
 // PeerConnection interface
 type PionPeerConnection struct {
-	pc *webrtc.PeerConnection
+	pc       *webrtc.PeerConnection
+	stats    *connectionStats
+	publicIP net.IP // Advertised by the host candidates, see SetPublicIP
 }
 
 // PionRtcService is our implementation of the rtc.Service
 type PionRtcService struct {
 	stunServer  string
+	turn        *ICEServer // See SetTURNServer
+	policy      string     // See SetTransportPolicy
+	portMin     uint16     // See SetPortRange
+	portMax     uint16
+	publicIP    net.IP // See SetPublicIP
 	transcriber transcribe.Service
+	gracePeriod time.Duration
+
+	// Recording of the Opus packets, see SetOpusRecording
+	opusRecording string
+	opusDir       func(user string) string
+
+	jitterDelay time.Duration // See SetJitterBuffer
+
+	videoDir func(user string) string // See SetVideoRecording
+
+	sessions sessionRegistry // Stats of the sessions, see Stats
+
+	idleTimeout time.Duration // See SetIdleTimeout
+	reaper      sync.Once
 }
 
 // streamOptions holds per-connection options for audio processing
 type streamOptions struct {
-	language   string
-	transcribe bool
+	language    string
+	transcribe  bool
+	user        string
+	locale      string
+	ingest      bool
+	sessionID   string // See PionPeerConnection.ID
+	diarization bool
+	task        string
+	prompt      string
+	extra       map[string]string
+	room        string
+	speaker     string
+	levels      bool
+	video       *videoRecording // Recording of the video track, nil when not recorded
+	stats       *connectionStats
+	activity    *sessionActivity // Of the connection for the reaper, nil without SetIdleTimeout
+	sendStats   bool             // Whether to send the stats on the DataChannel
+	completion  *transcribe.Completion
+	onLost      func() // Called when the client disappeared without closing
+}
+
+// segmentResults reads the results of a transcription stream of a session
+// while it runs
+type segmentResults struct {
+	done      chan struct{} // Closed once the results are read
+	audioFile string        // Of the final results, set when done is closed
+}
+
+// readResults reads the results of the stream until it closes them,
+// passing each one to deliver
+func readResults(stream transcribe.Stream, deliver func(result transcribe.Result)) *segmentResults {
+	r := &segmentResults{done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		for result := range stream.Results() {
+			if result.AudioFile != "" {
+				r.audioFile = result.AudioFile
+			}
+			deliver(result)
+		}
+	}()
+	return r
 }
 
-// NewPionRtcService creates a new instances of PionRtcService
-func NewPionRtcService(stun string, transcriber transcribe.Service) Service {
+// NewPionRtcService creates a new instances of PionRtcService, the
+// sessions receiving no audio for gracePeriod are finalized as lost
+func NewPionRtcService(stun string, transcriber transcribe.Service, gracePeriod time.Duration) Service {
+	if gracePeriod <= 0 {
+		gracePeriod = defaultGracePeriod
+	}
 	return &PionRtcService{
 		stunServer:  stun,
 		transcriber: transcriber,
+		gracePeriod: gracePeriod,
+		jitterDelay: defaultJitter,
 	}
 }
 
@@ -58,7 +138,13 @@ func (p *PionPeerConnection) ProcessOffer(offer string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return answer.SDP, nil
+	return mapHostCandidates(answer.SDP, p.publicIP), nil
+}
+
+// ID returns the identifier of the session, in its recording file names and
+// logs, see PionRtcService.Stats
+func (p *PionPeerConnection) ID() string {
+	return p.stats.stats.ID
 }
 
 // Close just closes the underlying peer connection
@@ -71,50 +157,170 @@ func (pi *PionRtcService) handleAudioTrack(track *webrtc.Track, dc *webrtc.DataC
 	if track == nil {
 		return fmt.Errorf("track is nil")
 	}
-	if dc == nil {
+	if dc == nil && !opts.ingest {
 		return fmt.Errorf("dataChannel is nil")
 	}
 	if pi.transcriber == nil {
 		return fmt.Errorf("transcriber service is nil")
 	}
+	opus := strings.EqualFold(track.Codec().Name, webrtc.Opus)
+	if opus && pi.archives(opts) {
+		return pi.archiveAudioTrack(track, dc, opts)
+	}
 
-	decoder, err := newDecoder()
+	decoder, clockRate, err := newTrackDecoder(track.Codec())
 	if err != nil {
 		return err
 	}
+	opts.stats.track(track.Codec().Name, clockRate)
+	jitter := newJitterBuffer(pi.jitterDelay)
+	plc := newConcealer(decoder, clockRate)
+	var recording *opusRecording
+	if opus {
+		// The G.711 tracks are only recorded to WAV
+		recording, err = pi.newOpusRecording(opts)
+		if err != nil {
+			log.Printf("Error recording the Opus packets of track %s: %v", track.ID(), err)
+		}
+	}
 
-	// Create stream with options
-	trStream, err := pi.transcriber.CreateStreamWithOptions(transcribe.StreamOptions{
-		Language:   opts.language,
-		Transcribe: opts.transcribe,
-	})
-	if err != nil {
-		return err
+	var meter *levelMeter
+	if opts.levels && dc != nil {
+		meter = newLevelMeter()
 	}
-	defer func() {
-		err := trStream.Close()
+
+	var dcClosed int32
+	// send writes a message to the client while its DataChannel is open
+	send := func(v interface{}) {
+		if dc == nil || atomic.LoadInt32(&dcClosed) == 1 {
+			return
+		}
+		msg, err := json.Marshal(v)
 		if err != nil {
-			log.Printf("Error closing stream %v", err)
 			return
 		}
-		for result := range trStream.Results() {
+		if err := dc.Send(msg); err != nil {
+			logSampler.Printf("dc-send", "Error sending on the DataChannel: %v", err)
+		}
+	}
+
+	// The results of each segment are read while it runs and sent to the
+	// client as they arrive, the partial ones included, the subscribers of
+	// the service get them at the same time
+	var readersMu sync.Mutex
+	readers := make(map[transcribe.Stream]*segmentResults)
+	deliver := func(result transcribe.Result) {
+		if result.Final {
 			log.Printf("Result: %v", result)
-			msg, err := json.Marshal(result)
+		}
+		send(result)
+	}
+
+	// Create stream with options, a new one for each segment of the session
+	newStream := func(language string) (transcribe.Stream, error) {
+		stream, err := pi.transcriber.CreateStreamWithOptions(transcribe.StreamOptions{
+			Language:    language,
+			Transcribe:  opts.transcribe,
+			User:        opts.user,
+			Completion:  opts.completion,
+			Locale:      opts.locale,
+			Diarization: opts.diarization,
+			Task:        opts.task,
+			Prompt:      opts.prompt,
+			Extra:       opts.extra,
+			SessionID:   opts.sessionID,
+			Room:        opts.room,
+			Speaker:     opts.speaker,
+		})
+		if err != nil {
+			return nil, err
+		}
+		readersMu.Lock()
+		readers[stream] = readResults(stream, deliver)
+		readersMu.Unlock()
+		return stream, nil
+	}
+	// closeSegment closes a stream of the session and returns the audio file
+	// of its final results once they are delivered
+	closeSegment := func(stream transcribe.Stream) (string, error) {
+		readersMu.Lock()
+		r := readers[stream]
+		delete(readers, stream)
+		readersMu.Unlock()
+		if err := stream.Close(); err != nil {
+			return "", err
+		}
+		<-r.done
+		return r.audioFile, nil
+	}
+	finished := func(stream transcribe.Stream) {
+		if _, err := closeSegment(stream); err != nil {
+			log.Printf("Error closing stream %v", err)
+		}
+	}
+	trStream, err := newControlledStream(opts.language, newStream, finished)
+	if err != nil {
+		return err
+	}
+	commands := make(chan command, 16)
+	if dc != nil {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			cmd, err := parseCommand(msg.Data)
 			if err != nil {
-				continue
+				logSampler.Printf("dc-command", "%v", err)
+				return
 			}
-			err = dc.Send(msg)
-			if err != nil {
-				fmt.Printf("DataChannel error: %v", err)
+			if cmd.Type == commandPong {
+				opts.stats.pong(cmd.Ping)
+				return
+			}
+			select {
+			case commands <- cmd:
+			default:
+				logSampler.Printf("dc-command", "Too many DataChannel commands, %s dropped", cmd.Type)
 			}
+		})
+		dc.OnClose(func() {
+			atomic.StoreInt32(&dcClosed, 1)
+		})
+	}
+	var statsTicker <-chan time.Time
+	if opts.sendStats && dc != nil {
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		statsTicker = ticker.C
+	}
+	defer func() {
+		stats := opts.stats.snapshot()
+		log.Printf("Stats of session %s: %d packets received, %d lost (%.1f%%), jitter %.1fms, RTT %.0fms", stats.ID, stats.PacketsReceived, stats.PacketsLost, stats.FractionLost*100, stats.Jitter, stats.RTT)
+		if jitter.reordered > 0 || jitter.late > 0 || jitter.lost > 0 {
+			log.Printf("Jitter buffer of track %s: %d packets reordered, %d late or duplicate, %d lost", track.ID(), jitter.reordered, jitter.late, jitter.lost)
+		}
+		if concealed := plc.duration(); concealed > 0 {
+			log.Printf("Concealed %v of lost or DTX audio on track %s", concealed.Round(time.Millisecond), track.ID())
+		}
+		if recording != nil {
+			recording.close()
+		}
+		trStream.segments.Wait()
+		audioFile, err := closeSegment(trStream.Stream)
+		if err != nil {
+			log.Printf("Error closing stream %v", err)
+			return
+		}
+		if recording != nil {
+			recording.rename(audioFile)
+		}
+		opts.video.rename(audioFile)
+		if dc != nil {
+			dc.Close()
 		}
-		dc.Close()
 	}()
 
 	errs := make(chan error, 2)
-	audioStream := make(chan []byte, 100)   // Buffered channel to avoid blocking
-	response := make(chan bool, 100)        // Buffered channel to avoid blocking
-	timer := time.NewTimer(5 * time.Second) // 5 second timeout for normal operation
+	audioStream := make(chan rtpPacket, 100) // Buffered channel to avoid blocking
+	response := make(chan bool, 100)         // Buffered channel to avoid blocking
+	timer := time.NewTimer(pi.gracePeriod)   // Finalize the session when the client stops sending audio
 	defer timer.Stop()
 
 	// Context for graceful shutdown
@@ -134,16 +340,17 @@ func (pi *PionRtcService) handleAudioTrack(track *webrtc.Track, dc *webrtc.DataC
 						log.Printf("Track ended for %s", track.ID())
 						return
 					}
-					log.Printf("Error reading RTP packet: %v", err)
+					logSampler.Printf("rtp-read", "Error reading RTP packet: %v", err)
 					errs <- err
 					return
 				}
 
 				// Reset timer on successful read
-				timer.Reset(5 * time.Second)
+				timer.Reset(pi.gracePeriod)
+				opts.stats.received(packet.SequenceNumber, packet.Timestamp, len(packet.Payload), time.Now())
 
 				select {
-				case audioStream <- packet.Payload:
+				case audioStream <- rtpPacket{payload: packet.Payload, sequence: packet.SequenceNumber, timestamp: packet.Timestamp}:
 					// Wait for response before continuing
 					select {
 					case <-response:
@@ -158,6 +365,45 @@ func (pi *PionRtcService) handleAudioTrack(track *webrtc.Track, dc *webrtc.DataC
 		}
 	}()
 
+	// handle records and decodes a packet released by the jitter buffer
+	handle := func(audioChunk rtpPacket) error {
+		if recording != nil {
+			if err := recording.write(audioChunk); err != nil {
+				logSampler.Printf("ogg:"+track.ID(), "Error writing the Ogg file: %v", err)
+			}
+		}
+
+		// The gaps of the timestamps are filled so the audio keeps its duration
+		payload, err := plc.decode(audioChunk)
+		if err != nil {
+			logSampler.Printf("decode:"+track.ID(), "Error decoding audio: %v", err)
+			alert.Record(alert.DecodeErrors, err)
+			return nil // Concealed with the gap before the next chunk
+		}
+		if len(payload) == 0 {
+			return nil // Late or duplicate chunk
+		}
+
+		if opts.activity != nil {
+			opts.activity.listen(payload)
+		}
+		if meter != nil {
+			if level := meter.write(payload); level != nil && atomic.LoadInt32(&dcClosed) == 0 {
+				if msg, err := json.Marshal(level); err == nil {
+					if err := dc.Send(msg); err != nil {
+						logSampler.Printf("level:"+track.ID(), "Error sending the audio level: %v", err)
+					}
+				}
+			}
+		}
+
+		if _, err := trStream.Write(payload); err != nil {
+			log.Printf("Error writing to transcriber: %v", err)
+			return err
+		}
+		return nil
+	}
+
 	err = nil
 	for {
 		select {
@@ -165,15 +411,14 @@ func (pi *PionRtcService) handleAudioTrack(track *webrtc.Track, dc *webrtc.DataC
 			if !ok {
 				// Channel closed, stream ended
 				log.Printf("Audio stream ended for track %s", track.ID())
+				for _, chunk := range jitter.flush() {
+					if err := handle(chunk); err != nil {
+						return err
+					}
+				}
 				return nil
 			}
 
-			payload, err := decoder.decode(audioChunk)
-			if err != nil {
-				log.Printf("Error decoding audio: %v", err)
-				continue // Skip this chunk but continue processing
-			}
-
 			// Send response to unblock the reader
 			select {
 			case response <- true:
@@ -181,15 +426,37 @@ func (pi *PionRtcService) handleAudioTrack(track *webrtc.Track, dc *webrtc.DataC
 				// Response channel is full, skip
 			}
 
-			_, err = trStream.Write(payload)
-			if err != nil {
-				log.Printf("Error writing to transcriber: %v", err)
-				return err
+			// The chunks are decoded in the order of their sequence numbers
+			for _, chunk := range jitter.push(audioChunk) {
+				if err := handle(chunk); err != nil {
+					return err
+				}
 			}
+			opts.stats.pipeline(jitter, plc.duration())
+
+		case cmd := <-commands:
+			send(trStream.execute(cmd))
+
+		case <-statsTicker:
+			send(opts.stats.message())
 
 		case <-timer.C:
-			log.Printf("Read operation timed out for track %s, closing stream", track.ID())
 			cancel() // Signal shutdown
+			for _, chunk := range jitter.flush() {
+				if err := handle(chunk); err != nil {
+					return err
+				}
+			}
+			if dc != nil && atomic.LoadInt32(&dcClosed) == 1 {
+				log.Printf("Read operation timed out for track %s after its DataChannel closed, closing stream", track.ID())
+				return nil
+			}
+			// The client disappeared without closing, e.g. a browser crash
+			log.Printf("No audio from track %s for %s, finalizing the session of the lost client", track.ID(), pi.gracePeriod)
+			opts.completion.Set(transcribe.CompletionClientLost)
+			if opts.onLost != nil {
+				opts.onLost()
+			}
 			return nil
 
 		case err = <-errs:
@@ -213,22 +480,41 @@ func (pi *PionRtcService) CreatePeerConnection() (PeerConnection, error) {
 // CreatePeerConnectionWithOptions creates a peer connection with specified options
 func (pi *PionRtcService) CreatePeerConnectionWithOptions(opts PeerConnectionOptions) (PeerConnection, error) {
 	pcconf := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			webrtc.ICEServer{
-				URLs: []string{pi.stunServer},
-			},
-		},
+		ICEServers:   pi.iceServers(),
 		SDPSemantics: webrtc.SDPSemanticsUnifiedPlanWithFallback,
 	}
-	pc, err := webrtc.NewPeerConnection(pcconf)
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(newMediaEngine()), webrtc.WithSettingEngine(pi.settingEngine()))
+	pc, err := api.NewPeerConnection(pcconf)
 	if err != nil {
 		return nil, err
 	}
 
 	// Store options for use in audio processing
 	streamOpts := streamOptions{
-		language:   opts.Language,
-		transcribe: opts.Transcribe,
+		language:    opts.Language,
+		transcribe:  opts.Transcribe,
+		user:        opts.User,
+		locale:      opts.Locale,
+		ingest:      opts.Ingest,
+		diarization: opts.Diarization,
+		task:        opts.Task,
+		prompt:      opts.Prompt,
+		extra:       opts.Extra,
+		room:        opts.Room,
+		speaker:     opts.Speaker,
+		levels:      opts.Levels,
+		stats:       newConnectionStats(opts.User),
+		sendStats:   opts.Stats,
+		completion:  &transcribe.Completion{},
+		onLost: func() {
+			// Unblocks the reader of the track
+			go pc.Close()
+		},
+	}
+	streamOpts.sessionID = streamOpts.stats.stats.ID
+	streamOpts.video = pi.newVideoRecording(opts.User, streamOpts.sessionID, pc)
+	if pi.idleTimeout > 0 {
+		streamOpts.activity = newSessionActivity(streamOpts.completion, func() { pc.Close() })
 	}
 
 	// Use a buffered channel to avoid blocking
@@ -267,9 +553,25 @@ func (pi *PionRtcService) CreatePeerConnectionWithOptions(opts PeerConnectionOpt
 	})
 
 	pc.OnTrack(func(track *webrtc.Track, r *webrtc.RTPReceiver) {
-		if track.Codec().Name == "opus" {
-			//log.Printf("Received audio (%s) track, id = %s\n", track.Codec().Name, track.ID())
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			if streamOpts.video != nil {
+				log.Printf("Received video (%s) track, id = %s", track.Codec().Name, track.ID())
+				go streamOpts.video.record(track)
+			}
+			return
+		}
+		if supportedCodec(track.Codec()) {
+			log.Printf("Received audio (%s) track, id = %s", track.Codec().Name, track.ID())
 			audioTrack = track
+			if opts.Ingest {
+				log.Printf("Starting audio processing for ingested track %s", track.ID())
+				go func() {
+					if err := pi.handleAudioTrack(track, nil, streamOpts); err != nil {
+						log.Printf("Error reading track (%s): %v\n", track.ID(), err)
+					}
+				}()
+				return
+			}
 			// Only start audio processing if we have both components
 			if audioTrack != nil && dataChannel != nil {
 				startAudioProcessing()
@@ -277,8 +579,19 @@ func (pi *PionRtcService) CreatePeerConnectionWithOptions(opts PeerConnectionOpt
 		}
 	})
 
+	var closeOnce sync.Once
 	pc.OnICEConnectionStateChange(func(connState webrtc.ICEConnectionState) {
-		log.Printf("Connection state: %s \n", connState.String())
+		log.Printf("Connection state of session %s: %s", streamOpts.sessionID, connState.String())
+		streamOpts.stats.state(connState.String(), connState == webrtc.ICEConnectionStateFailed || connState == webrtc.ICEConnectionStateClosed)
+		if streamOpts.activity != nil {
+			streamOpts.activity.state(connState)
+		}
+		if connState == webrtc.ICEConnectionStateFailed {
+			streamOpts.completion.Set(transcribe.CompletionClientLost)
+		}
+		if opts.OnClosed != nil && (connState == webrtc.ICEConnectionStateFailed || connState == webrtc.ICEConnectionStateClosed) {
+			closeOnce.Do(opts.OnClosed)
+		}
 	})
 
 	_, err = pc.AddTransceiver(webrtc.RTPCodecTypeAudio, webrtc.RtpTransceiverInit{
@@ -288,8 +601,21 @@ func (pi *PionRtcService) CreatePeerConnectionWithOptions(opts PeerConnectionOpt
 		log.Printf("Can't add transceiver: %s", err)
 		return nil, err
 	}
+	if streamOpts.video != nil {
+		// Without it the video of the offers is rejected
+		_, err = pc.AddTransceiver(webrtc.RTPCodecTypeVideo, webrtc.RtpTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		})
+		if err != nil {
+			log.Printf("Can't add video transceiver: %s", err)
+			return nil, err
+		}
+	}
 
+	pi.sessions.add(&session{stats: streamOpts.stats, activity: streamOpts.activity})
 	return &PionPeerConnection{
-		pc: pc,
+		pc:       pc,
+		stats:    streamOpts.stats,
+		publicIP: pi.publicIP,
 	}, nil
 }
@@ -0,0 +1,121 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// Settings of the concealment of the missing audio
+const (
+	plcGranularity = 120              // Samples of the shortest Opus frame (2.5ms), the unit of the FEC
+	plcMaxGap      = 10 * time.Second // Longer timestamp jumps are discontinuities, not missing audio
+	comfortNoise   = -50              // Maximum level of the comfort noise in dBFS
+	decodedRate    = 48000            // Rate of the decoded PCM, and the RTP clock rate of Opus
+	plcGapSamples  = int64(decodedRate) * int64(plcMaxGap) / int64(time.Second)
+)
+
+// concealer decodes the packets of a track in the order of their RTP
+// timestamps and fills the gaps, the lost packets and the DTX silences, so
+// that the PCM keeps the duration of the session: with Opus the audio right
+// before a packet is recovered from its in-band FEC, or concealed by the
+// decoder when it has none, the rest of the gap is comfort noise at the
+// level of the last audio
+type concealer struct {
+	decoder   trackDecoder
+	clockRate int // Rate of the RTP timestamps
+
+	started   bool
+	next      uint32 // RTP timestamp expected of the next packet
+	level     float64
+	concealed int64 // Decoded samples filled
+	buffer    []byte
+	fec       []int16
+}
+
+func newConcealer(decoder trackDecoder, clockRate int) *concealer {
+	return &concealer{
+		decoder:   decoder,
+		clockRate: clockRate,
+		fec:       make([]int16, maxOpusFrameSamples),
+	}
+}
+
+// decode returns the PCM of the gap before the packet followed by the audio
+// of the packet, empty for the late and duplicate packets. The buffer is
+// reused by the next call. A packet failing to decode is concealed with the
+// gap before the next one
+func (c *concealer) decode(packet rtpPacket) ([]byte, error) {
+	out := c.buffer[:0]
+	if c.started {
+		gap := int64(int32(packet.timestamp-c.next)) * decodedRate / int64(c.clockRate)
+		switch {
+		case gap < 0:
+			// Late or duplicate, its audio was already concealed
+			return out, nil
+		case gap > 0 && gap <= plcGapSamples:
+			out = c.conceal(out, int(gap), packet.payload)
+		}
+	}
+
+	pcm, err := c.decoder.Decode(packet.payload)
+	if err != nil {
+		c.buffer = out
+		return nil, err
+	}
+	c.started = true
+	c.next = packet.timestamp + uint32(int64(len(pcm)/2)*int64(c.clockRate)/decodedRate)
+	rms, _ := audio.Levels(pcm)
+	c.level = math.Min(rms, math.Pow(10, comfortNoise/20.0))
+
+	out = append(out, pcm...)
+	c.buffer = out
+	return out, nil
+}
+
+// conceal appends gap samples of audio to out, with Opus the last frames
+// from the packet following the gap
+func (c *concealer) conceal(out []byte, gap int, next []byte) []byte {
+	opus, ok := c.decoder.(*OpusDecoder)
+	if !ok {
+		c.concealed += int64(gap)
+		return c.noise(out, gap)
+	}
+	fec := gap
+	if fec > maxOpusFrameSamples {
+		fec = maxOpusFrameSamples
+	}
+	fec -= fec % plcGranularity
+	out = c.noise(out, gap-fec)
+	if fec > 0 {
+		// The decoder expects exactly the duration of the missing audio
+		if err := opus.opusd.DecodeFEC(next, c.fec[:fec:fec]); err != nil {
+			out = c.noise(out, fec)
+		} else {
+			for _, sample := range c.fec[:fec] {
+				out = append(out, byte(sample), byte(sample>>8))
+			}
+		}
+	}
+	c.concealed += int64(gap)
+	return out
+}
+
+// noise appends samples of comfort noise to out
+func (c *concealer) noise(out []byte, samples int) []byte {
+	var sample [2]byte
+	for i := 0; i < samples; i++ {
+		s := math.Max(-1, math.Min(1, rand.NormFloat64()*c.level))
+		binary.LittleEndian.PutUint16(sample[:], uint16(int16(s*32767)))
+		out = append(out, sample[:]...)
+	}
+	return out
+}
+
+// duration returns the audio filled so far
+func (c *concealer) duration() time.Duration {
+	return time.Duration(c.concealed) * time.Second / decodedRate
+}
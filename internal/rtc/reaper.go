@@ -0,0 +1,129 @@
+package rtc
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// maxReapInterval bounds the interval of the checks of the reaper
+const maxReapInterval = 10 * time.Second
+
+// SetIdleTimeout makes a reaper close the sessions whose ICE connection is
+// not connected, or whose microphone only sent silence, for timeout, e.g.
+// the abandoned tabs of muted microphones, releasing their transcription
+// streams and recordings. Unlike --session.grace it covers the connections
+// that never connected or keep sending audio. 0 disables it, it must be
+// called before the connections are created
+func (pi *PionRtcService) SetIdleTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("invalid idle timeout %v", timeout)
+	}
+	pi.idleTimeout = timeout
+	if timeout > 0 {
+		pi.reaper.Do(func() { go pi.reap(timeout) })
+	}
+	return nil
+}
+
+// reap closes the idle sessions every interval
+func (pi *PionRtcService) reap(timeout time.Duration) {
+	interval := timeout / 4
+	if interval > maxReapInterval {
+		interval = maxReapInterval
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for id, s := range pi.sessions.live() {
+			if s.activity == nil {
+				continue // Created before the reaper
+			}
+			if reason := s.activity.idle(now, timeout); reason != "" {
+				log.Printf("Session %s %s for %v, closing it", id, reason, timeout)
+				s.activity.reap()
+			}
+		}
+	}
+}
+
+// sessionActivity tracks the activity of a peer connection for the reaper
+type sessionActivity struct {
+	mu         sync.Mutex
+	connected  bool      // Whether the ICE connection is connected
+	changed    time.Time // Of the last ICE connection state change
+	audible    time.Time // Of the last audio over mutedLevel
+	closed     bool
+	completion *transcribe.Completion
+	close      func()
+}
+
+func newSessionActivity(completion *transcribe.Completion, close func()) *sessionActivity {
+	now := time.Now()
+	return &sessionActivity{changed: now, audible: now, completion: completion, close: close}
+}
+
+// state records the ICE connection state
+func (a *sessionActivity) state(state webrtc.ICEConnectionState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	connected := state == webrtc.ICEConnectionStateConnected || state == webrtc.ICEConnectionStateCompleted
+	if connected != a.connected {
+		a.connected = connected
+		a.changed = time.Now()
+	}
+	if state == webrtc.ICEConnectionStateClosed || state == webrtc.ICEConnectionStateFailed {
+		a.closed = true
+	}
+}
+
+// heard records the arrival of audio over mutedLevel
+func (a *sessionActivity) heard() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.audible = time.Now()
+}
+
+// listen records the decoded PCM when it is audible
+func (a *sessionActivity) listen(pcm []byte) {
+	if _, peak := audio.Levels(pcm); dBFS(peak) >= mutedLevel {
+		a.heard()
+	}
+}
+
+// idle returns why the session has been idle for timeout at now, empty
+// when it has not
+func (a *sessionActivity) idle(now time.Time, timeout time.Duration) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.closed:
+		return ""
+	case !a.connected && now.Sub(a.changed) >= timeout:
+		return "not connected"
+	case now.Sub(a.audible) >= timeout:
+		return "silent"
+	}
+	return ""
+}
+
+// reap closes the idle session, finalized as auto-completed
+func (a *sessionActivity) reap() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	a.mu.Unlock()
+	a.completion.Set(transcribe.CompletionIdle)
+	go a.close()
+}
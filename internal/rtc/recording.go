@@ -0,0 +1,240 @@
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/ogg"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Modes of the recording of the Opus packets, see SetOpusRecording
+const (
+	OpusRecordingOff       = "off"
+	OpusRecordingAlongside = "alongside" // Every session is also recorded to Ogg
+	OpusRecordingInstead   = "instead"   // The sessions not transcribed are only recorded to Ogg
+)
+
+// rtpPacket is the payload of an RTP packet of a track with its sequence
+// number and timestamp, and for video its marker, set on the last packet of
+// a frame
+type rtpPacket struct {
+	payload   []byte
+	sequence  uint16
+	timestamp uint32
+	marker    bool
+}
+
+// SetOpusRecording records the Opus packets received by the sessions to
+// Ogg files in the directory of their user, without decoding them. With
+// OpusRecordingAlongside every session gets an .ogg next to its WAV file,
+// with OpusRecordingInstead the sessions created without transcription are
+// not decoded at all and only get the .ogg, which takes about a tenth of
+// the CPU and the storage. It must be called before the connections are
+// created
+func (pi *PionRtcService) SetOpusRecording(mode string, dir func(user string) string) error {
+	switch mode {
+	case "", OpusRecordingOff:
+		pi.opusRecording = ""
+		return nil
+	case OpusRecordingAlongside, OpusRecordingInstead:
+		pi.opusRecording = mode
+		pi.opusDir = dir
+		return nil
+	}
+	return fmt.Errorf("unknown Opus recording mode %q, expected %s, %s or %s", mode, OpusRecordingOff, OpusRecordingAlongside, OpusRecordingInstead)
+}
+
+// archives returns whether the session is only recorded to Ogg
+func (pi *PionRtcService) archives(opts streamOptions) bool {
+	return pi.opusRecording == OpusRecordingInstead && !opts.transcribe
+}
+
+// opusRecording writes the Opus packets of a track to an Ogg file
+type opusRecording struct {
+	path   string
+	file   *os.File
+	writer *ogg.Writer
+
+	started  bool
+	last     uint32 // RTP timestamp of the last packet
+	position uint64 // Samples before the last packet
+}
+
+// newOpusRecording creates the Ogg file of the session of a user, nil when
+// the packets are not recorded
+func (pi *PionRtcService) newOpusRecording(opts streamOptions) (*opusRecording, error) {
+	if pi.opusRecording != OpusRecordingAlongside && !pi.archives(opts) {
+		return nil, nil
+	}
+	dir := pi.opusDir(opts.user)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the recordings directory: %w", err)
+	}
+	name := fmt.Sprintf("opus_%s_%s.ogg", time.Now().Format("20060102_150405"), opts.sessionID)
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Ogg file: %w", err)
+	}
+	writer, err := ogg.NewWriter(file, 1)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &opusRecording{path: path, file: file, writer: writer}, nil
+}
+
+// write appends a packet, positioned by its RTP timestamp so that the gaps
+// of the stream are kept
+func (r *opusRecording) write(packet rtpPacket) error {
+	if !r.started {
+		r.started = true
+		r.last = packet.timestamp
+	} else if delta := int32(packet.timestamp - r.last); delta > 0 {
+		r.position += uint64(delta)
+		r.last = packet.timestamp
+	}
+	return r.writer.WritePacket(packet.payload, r.position+uint64(ogg.Samples(packet.payload)))
+}
+
+// close ends the Ogg stream, the file is removed when no packet was received
+func (r *opusRecording) close() {
+	err := r.writer.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Error writing the Ogg file %s: %v", r.path, err)
+	}
+	if r.writer.Packets() == 0 {
+		os.Remove(r.path)
+		r.path = ""
+		return
+	}
+	log.Printf("Opus recording completed: %s (%d packets)", filepath.Base(r.path), r.writer.Packets())
+}
+
+// rename moves the Ogg file next to the recording of the session, with the
+// same base name
+func (r *opusRecording) rename(audioFile string) {
+	if r.path == "" || audioFile == "" {
+		return
+	}
+	path := strings.TrimSuffix(audioFile, filepath.Ext(audioFile)) + ".ogg"
+	if err := os.Rename(r.path, path); err != nil {
+		log.Printf("Error renaming the Ogg file %s: %v", r.path, err)
+		return
+	}
+	r.path = path
+}
+
+// archiveAudioTrack records the Opus packets of the track without decoding
+// them, the client gets the path of the Ogg file when the session ends
+func (pi *PionRtcService) archiveAudioTrack(track *webrtc.Track, dc *webrtc.DataChannel, opts streamOptions) error {
+	if track == nil {
+		return fmt.Errorf("track is nil")
+	}
+	recording, err := pi.newOpusRecording(opts)
+	if err != nil {
+		return err
+	}
+	opts.stats.track(track.Codec().Name, decodedRate)
+	var dcClosed int32
+	if dc != nil {
+		dc.OnClose(func() {
+			atomic.StoreInt32(&dcClosed, 1)
+		})
+	}
+	defer func() {
+		recording.close()
+		opts.video.rename(recording.path)
+		if dc == nil {
+			return
+		}
+		result := transcribe.MessageResult(opts.locale, transcribe.MsgTranscriptionDisabled)
+		result.AudioFile = recording.path
+		if msg, err := json.Marshal(result); err == nil {
+			if err := dc.Send(msg); err != nil {
+				log.Printf("DataChannel error: %v", err)
+			}
+		}
+		dc.Close()
+	}()
+
+	packets := make(chan rtpPacket, 100)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(packets)
+		for {
+			packet, err := track.ReadRTP()
+			if err != nil {
+				if err != io.EOF {
+					errs <- err
+				}
+				return
+			}
+			opts.stats.received(packet.SequenceNumber, packet.Timestamp, len(packet.Payload), time.Now())
+			select {
+			case packets <- rtpPacket{payload: packet.Payload, sequence: packet.SequenceNumber, timestamp: packet.Timestamp}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	jitter := newJitterBuffer(pi.jitterDelay)
+	timer := time.NewTimer(pi.gracePeriod) // Finalize the session when the client stops sending audio
+	defer timer.Stop()
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				log.Printf("Track ended for %s", track.ID())
+				for _, packet := range jitter.flush() {
+					if err := recording.write(packet); err != nil {
+						return fmt.Errorf("failed to write the Ogg file: %w", err)
+					}
+				}
+				return nil
+			}
+			timer.Reset(pi.gracePeriod)
+			if opts.activity != nil {
+				// The archived packets are not decoded, any audio counts
+				opts.activity.heard()
+			}
+			for _, packet := range jitter.push(packet) {
+				if err := recording.write(packet); err != nil {
+					return fmt.Errorf("failed to write the Ogg file: %w", err)
+				}
+			}
+			opts.stats.pipeline(jitter, 0)
+
+		case <-timer.C:
+			if dc == nil || atomic.LoadInt32(&dcClosed) == 0 {
+				log.Printf("No audio from track %s for %s, finalizing the session of the lost client", track.ID(), pi.gracePeriod)
+				opts.completion.Set(transcribe.CompletionClientLost)
+			}
+			// Unblocks the reader of the track
+			if opts.onLost != nil {
+				opts.onLost()
+			}
+			return nil
+
+		case err := <-errs:
+			log.Printf("Unexpected error reading track %s: %v", track.ID(), err)
+			return err
+		}
+	}
+}
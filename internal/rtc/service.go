@@ -6,14 +6,27 @@ import (
 
 // PeerConnectionOptions contains options for creating a peer connection
 type PeerConnectionOptions struct {
-	Language   string // Language code for transcription (e.g., "en", "zh", "auto")
-	Transcribe bool   // Whether to transcribe audio (default: true)
+	Language    string            // Language code for transcription (e.g., "en", "zh", "auto")
+	Transcribe  bool              // Whether to transcribe audio (default: true)
+	User        string            // Authenticated user owning the session
+	Locale      string            // Language of the server messages, e.g. "fr"
+	Ingest      bool              // Audio only client without DataChannel (e.g. WHIP), results are not sent back to the peer
+	Diarization bool              // Whether to label the speakers of the results
+	Task        string            // Whisper task, transcribe (default) or translate to English
+	Prompt      string            // Initial prompt of Whisper
+	Extra       map[string]string // Vendor settings, see transcribe.VendorOptions
+	Levels      bool              // Whether to send the audio levels on the DataChannel
+	Stats       bool              // Whether to send the connection stats on the DataChannel
+	Room        string            // Room whose merged transcript the session joins
+	Speaker     string            // Name of the participant in the room transcript
+	OnClosed    func()            // Called once when the connection fails or is closed
 }
 
 // PeerConnection Represents a WebRTC connection to a single peer
 type PeerConnection interface {
 	io.Closer
 	ProcessOffer(offer string) (string, error)
+	ID() string // Identifier of the session
 }
 
 // Service WebRTC service
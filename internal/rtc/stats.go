@@ -0,0 +1,251 @@
+package rtc
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Settings of the statistics of the sessions
+const (
+	statsInterval  = 5 * time.Second  // Interval of the stats messages
+	statsRetention = 10 * time.Minute // Time the stats of the ended sessions are kept
+)
+
+// SessionStats are the network statistics of the audio of a session, so
+// that a bad transcription can be told from a bad connection
+type SessionStats struct {
+	ID        string     `json:"id"`
+	User      string     `json:"user,omitempty"`
+	State     string     `json:"state"` // ICE connection state, e.g. connected or closed
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Codec     string     `json:"codec,omitempty"`
+
+	PacketsReceived int64   `json:"packets_received"`
+	BytesReceived   int64   `json:"bytes_received"` // RTP payloads
+	PacketsLost     int64   `json:"packets_lost"`   // Expected from the sequence numbers and not received
+	FractionLost    float64 `json:"fraction_lost"`  // Of the expected packets
+	Jitter          float64 `json:"jitter_ms"`      // Interarrival jitter (RFC 3550)
+	RTT             float64 `json:"rtt_ms,omitempty"`
+
+	Reordered int64   `json:"packets_reordered"` // Put back in order by the jitter buffer
+	Late      int64   `json:"packets_late"`      // Arrived after the jitter buffer released their position, or duplicates
+	Concealed float64 `json:"concealed_ms"`      // Lost or DTX audio filled
+}
+
+// statsMessage is sent on the DataChannel of the sessions created with
+// stats. The client may answer {"type": "pong", "ping": <ping>} right away
+// so that the server measures the round trip time
+type statsMessage struct {
+	Type string `json:"type"` // stats
+	Ping int64  `json:"ping"`
+	SessionStats
+}
+
+// connectionStats collects the statistics of a connection, updated by the
+// reader of its audio track
+type connectionStats struct {
+	mu    sync.Mutex
+	stats SessionStats
+
+	clockRate  int
+	started    bool
+	base       uint32 // Extended sequence number of the first packet
+	highest    uint32 // Highest extended sequence number
+	transit    float64
+	jitter     float64 // In RTP timestamp units
+	pingOrigin time.Time
+}
+
+func newConnectionStats(user string) *connectionStats {
+	now := time.Now()
+	return &connectionStats{
+		stats:      SessionStats{ID: transcribe.NewSessionID(), User: user, State: "new", StartedAt: now},
+		pingOrigin: now,
+	}
+}
+
+// track records the codec of the audio track
+func (s *connectionStats) track(codec string, clockRate int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Codec = codec
+	s.clockRate = clockRate
+}
+
+// received counts an RTP packet arrived at arrival
+func (s *connectionStats) received(sequence uint16, timestamp uint32, size int, arrival time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.PacketsReceived++
+	s.stats.BytesReceived += int64(size)
+
+	if !s.started {
+		s.started = true
+		s.base, s.highest = uint32(sequence), uint32(sequence)
+	} else if diff := int16(sequence - uint16(s.highest)); diff > 0 {
+		s.highest += uint32(diff)
+	}
+	expected := int64(s.highest-s.base) + 1
+	lost := expected - s.stats.PacketsReceived
+	if lost < 0 {
+		lost = 0 // Duplicates
+	}
+	s.stats.PacketsLost = lost
+	s.stats.FractionLost = float64(lost) / float64(expected)
+
+	if s.clockRate > 0 {
+		// RFC 3550 A.8, the transit times in RTP timestamp units
+		transit := arrival.Sub(s.pingOrigin).Seconds()*float64(s.clockRate) - float64(timestamp)
+		if s.stats.PacketsReceived > 1 {
+			d := math.Abs(transit - s.transit)
+			if d < float64(s.clockRate) { // Not a timestamp jump
+				s.jitter += (d - s.jitter) / 16
+			}
+		}
+		s.transit = transit
+		s.stats.Jitter = s.jitter * 1000 / float64(s.clockRate)
+	}
+}
+
+// pipeline records the counters of the jitter buffer and the concealment
+func (s *connectionStats) pipeline(jitter *jitterBuffer, concealed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Reordered = int64(jitter.reordered)
+	s.stats.Late = int64(jitter.late)
+	s.stats.Concealed = float64(concealed) / float64(time.Millisecond)
+}
+
+// state records the ICE connection state, the session ends when it is
+// closed or failed
+func (s *connectionStats) state(state string, ended bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.State = state
+	if ended && s.stats.EndedAt == nil {
+		now := time.Now()
+		s.stats.EndedAt = &now
+	}
+}
+
+// message returns the stats message with a ping of the current time
+func (s *connectionStats) message() statsMessage {
+	return statsMessage{
+		Type:         "stats",
+		Ping:         int64(time.Since(s.pingOrigin) / time.Millisecond),
+		SessionStats: s.snapshot(),
+	}
+}
+
+// pong records the round trip time of the ping of a stats message
+func (s *connectionStats) pong(ping int64) {
+	rtt := time.Since(s.pingOrigin) - time.Duration(ping)*time.Millisecond
+	if rtt < 0 || rtt > time.Minute {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.RTT = float64(rtt) / float64(time.Millisecond)
+}
+
+func (s *connectionStats) snapshot() SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// session is a peer connection of the registry
+type session struct {
+	stats    *connectionStats
+	activity *sessionActivity
+}
+
+// sessionRegistry indexes the live and recently ended sessions
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func (r *sessionRegistry) add(s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[string]*session)
+	}
+	r.expire()
+	r.sessions[s.stats.stats.ID] = s
+}
+
+func (r *sessionRegistry) get(id string) (*session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+// live returns the sessions not ended yet by identifier
+func (r *sessionRegistry) live() map[string]*session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+	live := make(map[string]*session)
+	for id, s := range r.sessions {
+		if s.stats.snapshot().EndedAt == nil {
+			live[id] = s
+		}
+	}
+	return live
+}
+
+// expire removes the sessions ended for longer than statsRetention
+func (r *sessionRegistry) expire() {
+	for id, session := range r.sessions {
+		if s := session.stats.snapshot(); s.EndedAt != nil && time.Since(*s.EndedAt) > statsRetention {
+			delete(r.sessions, id)
+		}
+	}
+}
+
+// Stats returns the statistics of a live session, or of a session ended
+// for less than 10 minutes
+func (pi *PionRtcService) Stats(id string) (SessionStats, bool) {
+	s, ok := pi.sessions.get(id)
+	if !ok {
+		return SessionStats{}, false
+	}
+	return s.stats.snapshot(), true
+}
+
+// MakeStatsHandler returns an HTTP handler serving the statistics of the
+// sessions on <prefix><id>/stats, visible reports whether a user may see
+// the sessions of their owner
+func MakeStatsHandler(prefix string, service *PionRtcService, visible func(owner, user string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if len(parts) != 2 || parts[1] != "stats" {
+			http.NotFound(w, r)
+			return
+		}
+		stats, ok := service.Stats(parts[0])
+		if !ok || !visible(stats.User, auth.UserFromContext(r.Context())) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
@@ -0,0 +1,418 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v2"
+	"github.com/walterfan/webrtc-transcriber/internal/video/ivf"
+)
+
+// Settings of the video recordings
+const (
+	videoJitterPackets = 50          // Packets held after a missing one, the packets of a few frames
+	keyframeInterval   = time.Second // Minimum interval of the keyframe requests
+)
+
+var errShortPayload = errors.New("short video payload")
+
+// SetVideoRecording accepts a video track in the sessions and records it to
+// an IVF file in the directory of their user, renamed after the WAV file of
+// the session once it is transcribed. Only the audio is transcribed, VP8 and
+// VP9 are recorded, the other codecs are ignored. It must be called before
+// the connections are created, without it the video tracks are rejected
+func (pi *PionRtcService) SetVideoRecording(dir func(user string) string) {
+	pi.videoDir = dir
+}
+
+// videoRecording records the first video track of a connection
+type videoRecording struct {
+	dir      string
+	session  string            // Identifier of the session, in the file name
+	keyframe func(ssrc uint32) // Requests a keyframe from the sender
+
+	mu        sync.Mutex
+	recording bool
+	path      string
+	file      *os.File
+	writer    *ivf.Writer
+}
+
+// newVideoRecording returns the recording of the video of the connection
+// of the session of the user, nil when the video is not recorded
+func (pi *PionRtcService) newVideoRecording(user, session string, pc *webrtc.PeerConnection) *videoRecording {
+	if pi.videoDir == nil {
+		return nil
+	}
+	return &videoRecording{
+		dir:     pi.videoDir(user),
+		session: session,
+		keyframe: func(ssrc uint32) {
+			if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}); err != nil {
+				logSampler.Printf("pli", "Error requesting a keyframe: %v", err)
+			}
+		},
+	}
+}
+
+// record writes the frames of the track until it ends
+func (v *videoRecording) record(track *webrtc.Track) {
+	var depacketize func(payload []byte) (videoPayload, error)
+	var fourcc string
+	switch strings.ToUpper(track.Codec().Name) {
+	case strings.ToUpper(webrtc.VP8):
+		depacketize, fourcc = depacketizeVP8, ivf.VP8
+	case strings.ToUpper(webrtc.VP9):
+		depacketize, fourcc = depacketizeVP9, ivf.VP9
+	default:
+		log.Printf("Video track %s not recorded, unsupported codec %s", track.ID(), track.Codec().Name)
+		return
+	}
+	if err := v.create(fourcc); err != nil {
+		log.Printf("Video track %s not recorded: %v", track.ID(), err)
+		return
+	}
+	defer v.close()
+
+	jitter := &jitterBuffer{depth: videoJitterPackets, packets: make(map[uint16]rtpPacket)}
+	frames := &frameAssembler{depacketize: depacketize, waitKeyframe: true}
+	var requested time.Time
+	handle := func(packet rtpPacket) {
+		frame, ok := frames.push(packet)
+		if frames.waitKeyframe && time.Since(requested) >= keyframeInterval {
+			// Lost packets, the next frames cannot be decoded until a keyframe
+			requested = time.Now()
+			v.keyframe(track.SSRC())
+		}
+		if ok {
+			if err := v.write(frame); err != nil {
+				logSampler.Printf("ivf:"+track.ID(), "Error writing the IVF file: %v", err)
+			}
+		}
+	}
+	for {
+		packet, err := track.ReadRTP()
+		if err != nil {
+			break
+		}
+		for _, ready := range jitter.push(rtpPacket{payload: packet.Payload, sequence: packet.SequenceNumber, timestamp: packet.Timestamp, marker: packet.Marker}) {
+			handle(ready)
+		}
+	}
+	for _, ready := range jitter.flush() {
+		handle(ready)
+	}
+	if jitter.lost > 0 || frames.dropped > 0 {
+		log.Printf("Video track %s: %d packets lost, %d frames dropped", track.ID(), jitter.lost, frames.dropped)
+	}
+}
+
+// create opens the IVF file of the first video track, the others fail
+func (v *videoRecording) create(fourcc string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.recording {
+		return fmt.Errorf("the connection already records a video track")
+	}
+	if err := os.MkdirAll(v.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create the recordings directory: %w", err)
+	}
+	name := fmt.Sprintf("video_%s_%s.ivf", time.Now().Format("20060102_150405"), v.session)
+	path := filepath.Join(v.dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create the IVF file: %w", err)
+	}
+	writer, err := ivf.NewWriter(file, fourcc)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	v.recording = true
+	v.path, v.file, v.writer = path, file, writer
+	return nil
+}
+
+func (v *videoRecording) write(frame videoFrame) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if frame.width > 0 && frame.height > 0 {
+		v.writer.SetSize(frame.width, frame.height)
+	}
+	return v.writer.WriteFrame(frame.data, frame.pts)
+}
+
+// close completes the IVF file, it is removed when no frame was recorded
+func (v *videoRecording) close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	err := v.writer.Close()
+	if closeErr := v.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Error writing the IVF file %s: %v", v.path, err)
+	}
+	if v.writer.Frames() == 0 {
+		os.Remove(v.path)
+		v.path = ""
+		return
+	}
+	log.Printf("Video recording completed: %s (%d frames)", filepath.Base(v.path), v.writer.Frames())
+}
+
+// rename moves the IVF file next to the recording of the audio of the
+// session, with the same base name
+func (v *videoRecording) rename(audioFile string) {
+	if v == nil || audioFile == "" {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.path == "" {
+		return
+	}
+	path := strings.TrimSuffix(audioFile, filepath.Ext(audioFile)) + ".ivf"
+	if err := os.Rename(v.path, path); err != nil {
+		log.Printf("Error renaming the IVF file %s: %v", v.path, err)
+		return
+	}
+	v.path = path
+}
+
+// videoPayload is the part of a frame carried by an RTP packet
+type videoPayload struct {
+	data          []byte
+	start         bool // First packet of the frame
+	keyframe      bool
+	width, height int // Dimensions of the video, when the packet has them
+}
+
+// videoFrame is a complete frame with its presentation time in ivf.ClockRate
+// units
+type videoFrame struct {
+	data          []byte
+	pts           uint64
+	width, height int
+}
+
+// frameAssembler rebuilds the frames from the packets released by the
+// jitter buffer. The frames missing packets are dropped, and the following
+// ones until the next keyframe since they depend on them
+type frameAssembler struct {
+	depacketize func(payload []byte) (videoPayload, error)
+
+	sequenced bool
+	next      uint16 // Sequence number of the next packet
+
+	started       bool // The first packet of the frame was received
+	frame         videoFrame
+	timestamp     uint32
+	keyframe      bool
+	waitKeyframe  bool
+	width, height int
+
+	clockStarted bool
+	last         uint32 // RTP timestamp of the last frame
+	pts          uint64
+
+	dropped int
+}
+
+// push adds a packet, and returns the frame it completes
+func (a *frameAssembler) push(packet rtpPacket) (videoFrame, bool) {
+	if a.sequenced && packet.sequence != a.next {
+		a.drop()
+	}
+	a.sequenced = true
+	a.next = packet.sequence + 1
+
+	payload, err := a.depacketize(packet.payload)
+	if err != nil {
+		a.drop()
+		return videoFrame{}, false
+	}
+	if a.started && packet.timestamp != a.timestamp {
+		// The marker of the previous frame was lost
+		a.drop()
+	}
+	if payload.start && !a.started {
+		a.started = true
+		a.timestamp = packet.timestamp
+		a.keyframe = payload.keyframe
+		a.frame.data = a.frame.data[:0]
+	}
+	if payload.width > 0 && payload.height > 0 {
+		a.width, a.height = payload.width, payload.height
+	}
+	if !a.started {
+		return videoFrame{}, false // Rest of a dropped frame
+	}
+	a.frame.data = append(a.frame.data, payload.data...)
+	if !packet.marker {
+		return videoFrame{}, false
+	}
+
+	a.started = false
+	if a.waitKeyframe && !a.keyframe {
+		a.dropped++
+		return videoFrame{}, false
+	}
+	a.waitKeyframe = false
+	if !a.clockStarted {
+		a.clockStarted = true
+		a.last = a.timestamp
+	} else if delta := int32(a.timestamp - a.last); delta > 0 {
+		a.pts += uint64(delta)
+		a.last = a.timestamp
+	}
+	frame := videoFrame{
+		data:   append([]byte(nil), a.frame.data...),
+		pts:    a.pts,
+		width:  a.width,
+		height: a.height,
+	}
+	return frame, true
+}
+
+// drop discards the frame in progress after a lost packet
+func (a *frameAssembler) drop() {
+	if a.started {
+		a.started = false
+		a.dropped++
+	}
+	a.waitKeyframe = true
+}
+
+// depacketizeVP8 parses the VP8 payload descriptor (RFC 7741)
+func depacketizeVP8(payload []byte) (videoPayload, error) {
+	if len(payload) < 1 {
+		return videoPayload{}, errShortPayload
+	}
+	p := videoPayload{start: payload[0]&0x10 != 0 && payload[0]&0x07 == 0}
+	i := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return videoPayload{}, errShortPayload
+		}
+		ext := payload[1]
+		i = 2
+		if ext&0x80 != 0 { // PictureID, 7 or 15 bits
+			if len(payload) <= i {
+				return videoPayload{}, errShortPayload
+			}
+			if payload[i]&0x80 != 0 {
+				i += 2
+			} else {
+				i++
+			}
+		}
+		if ext&0x40 != 0 { // TL0PICIDX
+			i++
+		}
+		if ext&0x30 != 0 { // TID and KEYIDX
+			i++
+		}
+	}
+	if len(payload) <= i {
+		return videoPayload{}, errShortPayload
+	}
+	p.data = payload[i:]
+	p.keyframe = p.start && p.data[0]&0x01 == 0
+	if p.keyframe && len(p.data) >= 10 && p.data[3] == 0x9d && p.data[4] == 0x01 && p.data[5] == 0x2a {
+		p.width = int(binary.LittleEndian.Uint16(p.data[6:]) & 0x3fff)
+		p.height = int(binary.LittleEndian.Uint16(p.data[8:]) & 0x3fff)
+	}
+	return p, nil
+}
+
+// depacketizeVP9 parses the VP9 payload descriptor (RFC 9628)
+func depacketizeVP9(payload []byte) (videoPayload, error) {
+	if len(payload) < 1 {
+		return videoPayload{}, errShortPayload
+	}
+	b := payload[0]
+	interPicture, layers, flexible := b&0x40 != 0, b&0x20 != 0, b&0x10 != 0
+	p := videoPayload{start: b&0x08 != 0}
+	p.keyframe = p.start && !interPicture
+	i := 1
+	need := func(n int) error {
+		if len(payload) < i+n {
+			return errShortPayload
+		}
+		return nil
+	}
+	if b&0x80 != 0 { // PictureID, 7 or 15 bits
+		if err := need(1); err != nil {
+			return videoPayload{}, err
+		}
+		if payload[i]&0x80 != 0 {
+			i += 2
+		} else {
+			i++
+		}
+	}
+	if layers {
+		i++
+		if !flexible {
+			i++ // TL0PICIDX
+		}
+	}
+	if flexible && interPicture {
+		for n := 0; n < 3; n++ { // Reference indices
+			if err := need(1); err != nil {
+				return videoPayload{}, err
+			}
+			more := payload[i]&0x01 != 0
+			i++
+			if !more {
+				break
+			}
+		}
+	}
+	if b&0x02 != 0 { // Scalability structure
+		if err := need(1); err != nil {
+			return videoPayload{}, err
+		}
+		spatial := int(payload[i]>>5) + 1
+		sizes, groups := payload[i]&0x10 != 0, payload[i]&0x08 != 0
+		i++
+		if sizes {
+			if err := need(4 * spatial); err != nil {
+				return videoPayload{}, err
+			}
+			// The highest spatial layer, the resolution of the recording
+			last := i + 4*(spatial-1)
+			p.width = int(binary.BigEndian.Uint16(payload[last:]))
+			p.height = int(binary.BigEndian.Uint16(payload[last+2:]))
+			i += 4 * spatial
+		}
+		if groups {
+			if err := need(1); err != nil {
+				return videoPayload{}, err
+			}
+			count := int(payload[i])
+			i++
+			for n := 0; n < count; n++ {
+				if err := need(1); err != nil {
+					return videoPayload{}, err
+				}
+				i += 1 + int(payload[i]>>2&0x03)
+			}
+		}
+	}
+	if err := need(1); err != nil {
+		return videoPayload{}, err
+	}
+	p.data = payload[i:]
+	return p, nil
+}
@@ -0,0 +1,195 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// AMF0 type markers
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// maxAMFDepth bounds the nesting of the objects and arrays, the commands
+// are decoded before the client is authenticated
+const maxAMFDepth = 32
+
+var errAMFTruncated = errors.New("truncated AMF0 value")
+
+// amfObjectValue is an AMF0 object or ECMA array, properties are encoded in
+// the order of keys
+type amfObjectValue struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newAMFObject(pairs ...interface{}) *amfObjectValue {
+	o := &amfObjectValue{values: make(map[string]interface{})}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key := pairs[i].(string)
+		o.keys = append(o.keys, key)
+		o.values[key] = pairs[i+1]
+	}
+	return o
+}
+
+// decodeAMF decodes all the AMF0 values of a command message
+func decodeAMF(data []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(data) > 0 {
+		v, rest, err := decodeAMFValue(data, 0)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+		data = rest
+	}
+	return values, nil
+}
+
+func decodeAMFValue(data []byte, depth int) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errAMFTruncated
+	}
+	if depth >= maxAMFDepth {
+		return nil, nil, fmt.Errorf("AMF0 values nested deeper than %d", maxAMFDepth)
+	}
+	marker, data := data[0], data[1:]
+	switch marker {
+	case amfNumber:
+		if len(data) < 8 {
+			return nil, nil, errAMFTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	case amfBoolean:
+		if len(data) < 1 {
+			return nil, nil, errAMFTruncated
+		}
+		return data[0] != 0, data[1:], nil
+	case amfString:
+		return decodeAMFString(data, 2)
+	case amfLongString:
+		return decodeAMFString(data, 4)
+	case amfNull, amfUndefined:
+		return nil, data, nil
+	case amfObject:
+		return decodeAMFObject(data, depth+1)
+	case amfECMAArray:
+		if len(data) < 4 {
+			return nil, nil, errAMFTruncated
+		}
+		return decodeAMFObject(data[4:], depth+1)
+	case amfStrictArray:
+		if len(data) < 4 {
+			return nil, nil, errAMFTruncated
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		var list []interface{}
+		for i := uint32(0); i < n; i++ {
+			v, rest, err := decodeAMFValue(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, v)
+			data = rest
+		}
+		return list, data, nil
+	case amfDate:
+		if len(data) < 10 {
+			return nil, nil, errAMFTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[10:], nil
+	}
+	return nil, nil, fmt.Errorf("unsupported AMF0 marker: 0x%02x", marker)
+}
+
+func decodeAMFString(data []byte, lengthSize int) (interface{}, []byte, error) {
+	if len(data) < lengthSize {
+		return nil, nil, errAMFTruncated
+	}
+	var n int
+	if lengthSize == 2 {
+		n = int(binary.BigEndian.Uint16(data))
+	} else {
+		n = int(binary.BigEndian.Uint32(data))
+	}
+	data = data[lengthSize:]
+	if n < 0 || len(data) < n {
+		return nil, nil, errAMFTruncated
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func decodeAMFObject(data []byte, depth int) (interface{}, []byte, error) {
+	o := newAMFObject()
+	for {
+		if len(data) < 3 {
+			return nil, nil, errAMFTruncated
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if n == 0 && data[2] == amfObjectEnd {
+			return o, data[3:], nil
+		}
+		if len(data) < 2+n {
+			return nil, nil, errAMFTruncated
+		}
+		key := string(data[2 : 2+n])
+		v, rest, err := decodeAMFValue(data[2+n:], depth)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.keys = append(o.keys, key)
+		o.values[key] = v
+		data = rest
+	}
+}
+
+// encodeAMF encodes numbers, booleans, strings, objects and nil
+func encodeAMF(values ...interface{}) []byte {
+	var b []byte
+	for _, v := range values {
+		b = appendAMF(b, v)
+	}
+	return b
+}
+
+func appendAMF(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case float64:
+		b = append(b, amfNumber)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+	case int:
+		return appendAMF(b, float64(v))
+	case bool:
+		if v {
+			return append(b, amfBoolean, 1)
+		}
+		return append(b, amfBoolean, 0)
+	case string:
+		b = append(b, amfString)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		return append(b, v...)
+	case *amfObjectValue:
+		b = append(b, amfObject)
+		for _, key := range v.keys {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(key)))
+			b = append(b, key...)
+			b = appendAMF(b, v.values[key])
+		}
+		return append(b, 0, 0, amfObjectEnd)
+	}
+	return append(b, amfNull)
+}
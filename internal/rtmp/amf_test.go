@@ -0,0 +1,94 @@
+package rtmp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAMFRoundTrip(t *testing.T) {
+	object := newAMFObject("app", "live", "tcUrl", "rtmp://localhost/live", "audioCodecs", 3191.0, "nested", newAMFObject("fpad", false))
+	data := encodeAMF("connect", 1, object, nil, true, -2.5)
+	values, err := decodeAMF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"connect", 1.0, object, nil, true, -2.5}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("decodeAMF = %#v, want %#v", values, want)
+	}
+	if keys := values[2].(*amfObjectValue).keys; !reflect.DeepEqual(keys, object.keys) {
+		t.Errorf("keys in the order %q, want %q", keys, object.keys)
+	}
+}
+
+func TestDecodeAMFTypes(t *testing.T) {
+	data := []byte{
+		amfLongString, 0, 0, 0, 2, 'h', 'i',
+		amfECMAArray, 0, 0, 0, 1, 0, 1, 'k', amfBoolean, 1, 0, 0, amfObjectEnd,
+		amfStrictArray, 0, 0, 0, 2, amfNull, amfString, 0, 1, 'x',
+		amfDate, 0x40, 0x59, 0, 0, 0, 0, 0, 0, 0, 0, // 100 ms, no time zone
+		amfUndefined,
+	}
+	values, err := decodeAMF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"hi", newAMFObject("k", true), []interface{}{nil, "x"}, 100.0, nil}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("decodeAMF = %#v, want %#v", values, want)
+	}
+}
+
+func TestDecodeAMFTruncated(t *testing.T) {
+	for _, value := range []interface{}{1.0, true, "connect", newAMFObject("app", "live")} {
+		data := encodeAMF(value)
+		for i := 1; i < len(data); i++ {
+			if _, err := decodeAMF(data[:i]); err == nil {
+				t.Errorf("decodeAMF of %d bytes of %v succeeded", i, value)
+			}
+		}
+	}
+	for _, data := range [][]byte{
+		{amfLongString, 0xff, 0xff, 0xff, 0xff, 'a'},
+		{amfStrictArray, 0xff, 0xff, 0xff, 0xff, amfNull},
+		{amfECMAArray, 0, 0},
+		{amfDate, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if _, err := decodeAMF(data); err == nil {
+			t.Errorf("decodeAMF(%x) succeeded", data)
+		}
+	}
+}
+
+func TestDecodeAMFUnsupported(t *testing.T) {
+	// 0x11 switches to AMF3, 0x07 is a reference
+	for _, marker := range []byte{0x07, 0x11, 0xff} {
+		_, err := decodeAMF([]byte{marker})
+		if err == nil || !strings.Contains(err.Error(), "unsupported") {
+			t.Errorf("marker 0x%02x: %v", marker, err)
+		}
+	}
+}
+
+func TestDecodeAMFNesting(t *testing.T) {
+	// Objects nested in empty keys and arrays nested in arrays, filling a
+	// message of the largest size
+	objects := append([]byte{amfObject}, bytes.Repeat([]byte{0, 0, amfObject}, maxMessageSize/3)...)
+	arrays := bytes.Repeat([]byte{amfStrictArray, 0, 0, 0, 1}, maxMessageSize/5)
+	for _, data := range [][]byte{objects, arrays} {
+		_, err := decodeAMF(data)
+		if err == nil || !strings.Contains(err.Error(), "nested deeper") {
+			t.Errorf("decodeAMF of %d bytes = %v, want a nesting error", len(data), err)
+		}
+	}
+
+	var nested interface{} = "leaf"
+	for i := 1; i < maxAMFDepth; i++ {
+		nested = newAMFObject("child", nested)
+	}
+	if _, err := decodeAMF(encodeAMF(nested)); err != nil {
+		t.Errorf("%d nested objects: %v", maxAMFDepth-1, err)
+	}
+}
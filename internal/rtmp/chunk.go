@@ -0,0 +1,222 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Message type IDs
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAcknowledgement  = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+const (
+	handshakeSize    = 1536
+	defaultChunkSize = 128
+	maxMessageSize   = 8 << 20
+)
+
+// message is a reassembled RTMP message
+type message struct {
+	typeID    byte
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// chunkStream holds the header state of a chunk stream id
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    byte
+	streamID  uint32
+	extended  bool
+	payload   []byte
+}
+
+// chunkReader reassembles the messages of the chunk streams
+type chunkReader struct {
+	r         *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+}
+
+func newChunkReader(r *bufio.Reader) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+// readMessage reads chunks until a message is complete
+func (c *chunkReader) readMessage() (*message, error) {
+	for {
+		m, err := c.readChunk()
+		if err != nil || m != nil {
+			return m, err
+		}
+	}
+}
+
+func (c *chunkReader) readChunk() (*message, error) {
+	first, err := c.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	format := first >> 6
+	csid := uint32(first & 0x3f)
+	switch csid {
+	case 0:
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(b)
+	case 1:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(b[0]) + uint32(b[1])*256
+	}
+
+	cs, ok := c.streams[csid]
+	if !ok {
+		if format != 0 {
+			return nil, fmt.Errorf("chunk stream %d starts without a full header", csid)
+		}
+		cs = &chunkStream{}
+		c.streams[csid] = cs
+	}
+
+	headerSizes := [4]int{11, 7, 3, 0}
+	header := make([]byte, headerSizes[format])
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, err
+	}
+	if format < 3 {
+		timestamp := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+		cs.extended = timestamp == 0xffffff
+		if format < 2 {
+			cs.length = uint32(header[3])<<16 | uint32(header[4])<<8 | uint32(header[5])
+			cs.typeID = header[6]
+		}
+		if format == 0 {
+			cs.streamID = binary.LittleEndian.Uint32(header[7:11])
+		}
+		if cs.extended {
+			var ext [4]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return nil, err
+			}
+			timestamp = binary.BigEndian.Uint32(ext[:])
+		}
+		if format == 0 {
+			cs.timestamp = timestamp
+			cs.delta = 0
+		} else {
+			cs.delta = timestamp
+		}
+	} else if cs.extended {
+		var ext [4]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return nil, err
+		}
+	}
+	if len(cs.payload) == 0 && format != 0 {
+		// First chunk of a new message on this stream
+		cs.timestamp += cs.delta
+	}
+	if cs.length > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is too large", cs.length)
+	}
+
+	n := cs.length - uint32(len(cs.payload))
+	if n > c.chunkSize {
+		n = c.chunkSize
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	cs.payload = append(cs.payload, data...)
+	if uint32(len(cs.payload)) < cs.length {
+		return nil, nil
+	}
+
+	m := &message{
+		typeID:    cs.typeID,
+		streamID:  cs.streamID,
+		timestamp: cs.timestamp,
+		payload:   cs.payload,
+	}
+	cs.payload = nil
+	return m, nil
+}
+
+// writeMessage writes a message on the chunk stream id with a full header
+// followed by continuation chunks
+func writeMessage(w io.Writer, csid byte, m *message, chunkSize int) error {
+	var b []byte
+	b = append(b, csid&0x3f)
+	b = append(b, byte(m.timestamp>>16), byte(m.timestamp>>8), byte(m.timestamp))
+	n := len(m.payload)
+	b = append(b, byte(n>>16), byte(n>>8), byte(n))
+	b = append(b, m.typeID)
+	b = binary.LittleEndian.AppendUint32(b, m.streamID)
+	for i := 0; i < n; i += chunkSize {
+		if i > 0 {
+			b = append(b, 0xc0|(csid&0x3f))
+		}
+		end := i + chunkSize
+		if end > n {
+			end = n
+		}
+		b = append(b, m.payload[i:end]...)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// serverHandshake performs the simple (unsigned) RTMP handshake
+func serverHandshake(rw *bufio.ReadWriter) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(rw, c0c1); err != nil {
+		return fmt.Errorf("failed to read C0/C1: %w", err)
+	}
+	if c0c1[0] != 3 {
+		return fmt.Errorf("unsupported RTMP version: %d", c0c1[0])
+	}
+
+	// S1 is a zero time and version followed by random bytes
+	s1 := make([]byte, handshakeSize)
+	rand.Read(s1[8:])
+	rw.WriteByte(3)
+	rw.Write(s1)
+	rw.Write(c0c1[1:]) // S2 echoes C1
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+
+	c2 := make([]byte, handshakeSize)
+	if _, err := io.ReadFull(rw, c2); err != nil {
+		return fmt.Errorf("failed to read C2: %w", err)
+	}
+	return nil
+}
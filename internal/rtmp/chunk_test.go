@@ -0,0 +1,174 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func reader(data ...[]byte) *chunkReader {
+	return newChunkReader(bufio.NewReader(bytes.NewReader(bytes.Join(data, nil))))
+}
+
+func TestWriteReadMessage(t *testing.T) {
+	payload := make([]byte, 300)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	want := &message{typeID: msgCommandAMF0, streamID: 1, timestamp: 1234, payload: payload}
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, 3, want, defaultChunkSize); err != nil {
+		t.Fatal(err)
+	}
+	// A full header, then two continuation chunks of one byte
+	if buf.Len() != 12+300+2 {
+		t.Errorf("%d bytes written", buf.Len())
+	}
+	got, err := reader(buf.Bytes()).readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readMessage = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadCompressedHeaders(t *testing.T) {
+	c := reader(
+		// Type 0: timestamp 1000, 2 bytes of audio on stream 1
+		[]byte{0x04, 0, 0x03, 0xe8, 0, 0, 2, msgAudio, 1, 0, 0, 0, 'a', 'b'},
+		// Type 1: delta 20, 3 bytes of video
+		[]byte{0x44, 0, 0, 20, 0, 0, 3, msgVideo, 'c', 'd', 'e'},
+		// Type 2: delta 30, same length and type
+		[]byte{0x84, 0, 0, 30, 'f', 'g', 'h'},
+		// Type 3: the next message with the same header and delta
+		[]byte{0xc4, 'i', 'j', 'k'},
+	)
+	for _, want := range []message{
+		{msgAudio, 1, 1000, []byte("ab")},
+		{msgVideo, 1, 1020, []byte("cde")},
+		{msgVideo, 1, 1050, []byte("fgh")},
+		{msgVideo, 1, 1080, []byte("ijk")},
+	} {
+		m, err := c.readMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*m, want) {
+			t.Errorf("readMessage = %+v, want %+v", *m, want)
+		}
+	}
+	if _, err := c.readMessage(); err != io.EOF {
+		t.Errorf("readMessage at the end = %v", err)
+	}
+}
+
+func TestReadExtendedTimestamp(t *testing.T) {
+	c := reader(
+		[]byte{0x05, 0xff, 0xff, 0xff, 0, 0, 5, msgAudio, 1, 0, 0, 0},
+		[]byte{0x01, 0x00, 0x00, 0x00}, // 0x1000000
+		[]byte{'a', 'b', 'c'},
+		// The continuation chunk repeats the extended timestamp
+		[]byte{0xc5, 0x01, 0x00, 0x00, 0x00, 'd', 'e'},
+	)
+	c.chunkSize = 3
+	m, err := c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.timestamp != 0x1000000 || string(m.payload) != "abcde" {
+		t.Errorf("readMessage = %+v", m)
+	}
+}
+
+func TestReadChunkStreamIDs(t *testing.T) {
+	header := []byte{0, 0, 0, 0, 0, 1, msgAudio, 0, 0, 0, 0, 'x'}
+	for _, test := range []struct {
+		basic []byte
+		csid  uint32
+	}{
+		{[]byte{0x3f}, 63},
+		{[]byte{0x00, 0x00}, 64},
+		{[]byte{0x00, 0xff}, 319},
+		{[]byte{0x01, 0x00, 0x01}, 320},
+		{[]byte{0x01, 0xff, 0xff}, 65599},
+	} {
+		c := reader(test.basic, header)
+		if _, err := c.readMessage(); err != nil {
+			t.Errorf("basic header %x: %v", test.basic, err)
+			continue
+		}
+		if _, ok := c.streams[test.csid]; !ok {
+			t.Errorf("basic header %x is not chunk stream %d", test.basic, test.csid)
+		}
+	}
+}
+
+func TestReadInterleavedMessages(t *testing.T) {
+	c := reader(
+		[]byte{0x04, 0, 0, 0, 0, 0, 4, msgAudio, 1, 0, 0, 0, 'a', 'b'},
+		[]byte{0x05, 0, 0, 0, 0, 0, 2, msgCommandAMF0, 0, 0, 0, 0, 'x', 'y'},
+		[]byte{0xc4, 'c', 'd'},
+	)
+	c.chunkSize = 2
+	first, err := c.readMessage()
+	if err != nil || string(first.payload) != "xy" {
+		t.Fatalf("first message %+v, %v", first, err)
+	}
+	second, err := c.readMessage()
+	if err != nil || string(second.payload) != "abcd" || second.typeID != msgAudio {
+		t.Fatalf("second message %+v, %v", second, err)
+	}
+}
+
+func TestReadChunkErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"no full header": {0x43, 0, 0, 0, 0, 0, 1, msgAudio, 'x'},
+		"too large":      {0x03, 0, 0, 0, 0x80, 0, 1, msgAudio, 0, 0, 0, 0},
+		"truncated":      {0x03, 0, 0, 0, 0, 0, 4, msgAudio, 0, 0, 0, 0, 'a'},
+		"no extended":    {0x03, 0xff, 0xff, 0xff, 0, 0, 1, msgAudio, 0, 0, 0, 0, 0x01},
+	} {
+		if _, err := reader(data).readMessage(); err == nil || err == io.EOF {
+			t.Errorf("%s: readMessage = %v", name, err)
+		}
+	}
+}
+
+// handshake runs the server handshake on a pipe and returns the client
+// end with the channel receiving its result
+func handshake(t *testing.T) (net.Conn, chan error) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		done <- serverHandshake(bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)))
+	}()
+	return client, done
+}
+
+func TestServerHandshake(t *testing.T) {
+	client, done := handshake(t)
+	c1 := bytes.Repeat([]byte{0x5a}, handshakeSize)
+	go client.Write(append([]byte{3}, c1...))
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	if _, err := io.ReadFull(client, s0s1s2); err != nil {
+		t.Fatal(err)
+	}
+	if s0s1s2[0] != 3 || !bytes.Equal(s0s1s2[1+handshakeSize:], c1) {
+		t.Error("S0 is not version 3 or S2 does not echo C1")
+	}
+	client.Write(s0s1s2[1 : 1+handshakeSize]) // C2 echoes S1
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	client, done = handshake(t)
+	client.Write(append([]byte{6}, c1...))
+	if err := <-done; err == nil {
+		t.Error("handshake of RTMP version 6 succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// flvHeader starts an audio only FLV stream, followed by PreviousTagSize0
+var flvHeader = []byte{'F', 'L', 'V', 1, 0x04, 0, 0, 0, 9, 0, 0, 0, 0}
+
+// decoder converts the RTMP audio messages (AAC, MP3, ...) to 48 kHz mono
+// PCM by piping them as FLV through ffmpeg
+type decoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr strings.Builder
+}
+
+// newDecoder starts ffmpeg, the decoded PCM is read from pcm()
+func newDecoder(ffmpegPath string, sampleRate int) (*decoder, error) {
+	d := &decoder{}
+	d.cmd = exec.Command(ffmpegPath,
+		"-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-probesize", "4096",
+		"-f", "flv", "-i", "pipe:0",
+		"-vn", "-f", "s16le", "-acodec", "pcm_s16le",
+		"-ac", "1", "-ar", fmt.Sprint(sampleRate),
+		"pipe:1")
+	d.cmd.Stderr = &d.stderr
+
+	var err error
+	if d.stdin, err = d.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if d.stdout, err = d.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := d.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	if _, err := d.stdin.Write(flvHeader); err != nil {
+		d.closeInput()
+		d.wait()
+		return nil, err
+	}
+	return d, nil
+}
+
+// writeAudio writes the payload of an RTMP audio message as an FLV tag
+func (d *decoder) writeAudio(timestamp uint32, payload []byte) error {
+	tag := make([]byte, 0, 11+len(payload)+4)
+	tag = append(tag, msgAudio)
+	tag = append(tag, byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)))
+	tag = append(tag, byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24))
+	tag = append(tag, 0, 0, 0) // Stream ID
+	tag = append(tag, payload...)
+	tag = binary.BigEndian.AppendUint32(tag, uint32(11+len(payload)))
+	_, err := d.stdin.Write(tag)
+	return err
+}
+
+// pcm returns the reader of the decoded audio, it reaches EOF once the
+// input is closed and the remaining audio has been decoded
+func (d *decoder) pcm() io.Reader {
+	return bufio.NewReaderSize(d.stdout, 9600)
+}
+
+// closeInput ends the FLV stream, ffmpeg exits after flushing its output
+func (d *decoder) closeInput() {
+	d.stdin.Close()
+}
+
+// wait waits for ffmpeg to exit, the output must have been read to EOF
+func (d *decoder) wait() error {
+	if err := d.cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(d.stderr.String()))
+	}
+	return nil
+}
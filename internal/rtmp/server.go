@@ -0,0 +1,328 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the errors of misbehaving publishers
+var logSampler = logging.NewSampler(10 * time.Second)
+
+const (
+	serverChunkSize = 4096
+	windowAckSize   = 2500000
+	idleTimeout     = 60 * time.Second
+	publishStreamID = 1
+)
+
+// Config holds the RTMP server configuration
+type Config struct {
+	Addr       string            // TCP listen address, e.g. :1935
+	FFmpegPath string            // ffmpeg binary decoding the audio track
+	Language   string            // Default language, overridden by ?language= in the stream key
+	Keys       map[string]string // Stream key to user, any key is accepted when empty
+}
+
+// Server accepts RTMP publishers (OBS, ffmpeg, ...) and transcribes the
+// audio track of their streams
+type Server struct {
+	config  Config
+	service transcribe.Service
+}
+
+// NewServer creates a new RTMP server feeding the service
+func NewServer(service transcribe.Service, config Config) *Server {
+	if config.FFmpegPath == "" {
+		config.FFmpegPath = "ffmpeg"
+	}
+	return &Server{
+		config:  config,
+		service: service,
+	}
+}
+
+// ListenAndServe accepts RTMP connections until the listener fails
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// conn is an RTMP connection with at most one published stream
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	rw      *bufio.ReadWriter
+	reader  *chunkReader
+	publish *publishSession
+}
+
+// publishSession decodes the audio of a published stream into a
+// transcription stream
+type publishSession struct {
+	name    string
+	user    string
+	decoder *decoder
+	stream  transcribe.Stream
+	done    chan struct{}
+}
+
+func (s *Server) serve(netConn net.Conn) {
+	defer netConn.Close()
+	c := &conn{
+		server:  s,
+		netConn: netConn,
+		rw:      bufio.NewReadWriter(bufio.NewReader(netConn), bufio.NewWriter(netConn)),
+	}
+	defer c.endPublish()
+
+	netConn.SetDeadline(time.Now().Add(idleTimeout))
+	if err := serverHandshake(c.rw); err != nil {
+		logSampler.Printf("rtmp:handshake", "RTMP handshake with %s failed: %v", netConn.RemoteAddr(), err)
+		return
+	}
+	c.reader = newChunkReader(c.rw.Reader)
+
+	for {
+		netConn.SetDeadline(time.Now().Add(idleTimeout))
+		m, err := c.reader.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				logSampler.Printf("rtmp:read", "RTMP connection from %s closed: %v", netConn.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := c.handle(m); err != nil {
+			logSampler.Printf("rtmp:command", "Closing RTMP connection from %s: %v", netConn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// handle processes a message, an error closes the connection
+func (c *conn) handle(m *message) error {
+	switch m.typeID {
+	case msgSetChunkSize:
+		if len(m.payload) < 4 {
+			return fmt.Errorf("invalid chunk size message")
+		}
+		size := binary.BigEndian.Uint32(m.payload) & 0x7fffffff
+		if size == 0 {
+			return fmt.Errorf("invalid chunk size 0")
+		}
+		c.reader.chunkSize = size
+	case msgCommandAMF0, msgCommandAMF3:
+		payload := m.payload
+		if m.typeID == msgCommandAMF3 && len(payload) > 0 {
+			// AMF3 commands start with a format byte followed by AMF0 values
+			payload = payload[1:]
+		}
+		values, err := decodeAMF(payload)
+		if err != nil {
+			return fmt.Errorf("invalid command: %w", err)
+		}
+		return c.command(values)
+	case msgAudio:
+		if c.publish == nil || len(m.payload) == 0 {
+			return nil
+		}
+		if err := c.publish.decoder.writeAudio(m.timestamp, m.payload); err != nil {
+			return fmt.Errorf("failed to decode audio: %w", err)
+		}
+	}
+	// Video, metadata and acknowledgements are ignored
+	return nil
+}
+
+// command answers the NetConnection and NetStream commands
+func (c *conn) command(values []interface{}) error {
+	if len(values) < 2 {
+		return fmt.Errorf("command without name and transaction id")
+	}
+	name, _ := values[0].(string)
+	txn, _ := values[1].(float64)
+	var args []interface{}
+	if len(values) > 3 {
+		args = values[3:]
+	}
+
+	switch name {
+	case "connect":
+		c.sendControl(msgWindowAckSize, binary.BigEndian.AppendUint32(nil, windowAckSize))
+		// Dynamic limit type
+		c.sendControl(msgSetPeerBandwidth, append(binary.BigEndian.AppendUint32(nil, windowAckSize), 2))
+		c.sendControl(msgSetChunkSize, binary.BigEndian.AppendUint32(nil, serverChunkSize))
+		return c.sendCommand(0, "_result", txn,
+			newAMFObject("fmsVer", "FMS/3,0,1,123", "capabilities", 31),
+			newAMFObject(
+				"level", "status",
+				"code", "NetConnection.Connect.Success",
+				"description", "Connection succeeded.",
+				"objectEncoding", 0,
+			))
+	case "releaseStream", "FCPublish":
+		return c.sendCommand(0, "_result", txn, nil)
+	case "createStream":
+		return c.sendCommand(0, "_result", txn, nil, publishStreamID)
+	case "publish":
+		key := ""
+		if len(args) > 0 {
+			key, _ = args[0].(string)
+		}
+		return c.startPublish(key)
+	case "FCUnpublish", "deleteStream", "closeStream":
+		c.endPublish()
+	}
+	return nil
+}
+
+// startPublish validates the stream key and starts transcribing
+func (c *conn) startPublish(key string) error {
+	if c.publish != nil {
+		return fmt.Errorf("stream %s is already published", c.publish.name)
+	}
+	name, language := key, c.server.config.Language
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		name = key[:i]
+		if query, err := url.ParseQuery(key[i+1:]); err == nil && query.Get("language") != "" {
+			language = query.Get("language")
+		}
+	}
+	if language == "" {
+		language = "auto"
+	}
+
+	user, valid := c.server.authorize(name)
+	if !valid {
+		c.sendStatus("error", "NetStream.Publish.BadName", "Invalid stream key.")
+		return fmt.Errorf("invalid stream key")
+	}
+
+	decoder, err := newDecoder(c.server.config.FFmpegPath, transcribe.PipelineSampleRate)
+	if err != nil {
+		c.sendStatus("error", "NetStream.Publish.Failed", "Failed to start the audio decoder.")
+		return err
+	}
+	stream, err := c.server.service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   language,
+		Transcribe: true,
+		User:       user,
+	})
+	if err != nil {
+		decoder.closeInput()
+		io.Copy(ioutil.Discard, decoder.pcm())
+		decoder.wait()
+		c.sendStatus("error", "NetStream.Publish.Failed", "Failed to create the transcription stream.")
+		return err
+	}
+
+	p := &publishSession{
+		name:    name,
+		user:    user,
+		decoder: decoder,
+		stream:  stream,
+		done:    make(chan struct{}),
+	}
+	c.publish = p
+	go p.forwardAudio()
+	go p.drainResults()
+	log.Printf("RTMP publish started from %s for user %q with language: %s", c.netConn.RemoteAddr(), user, language)
+
+	return c.sendStatus("status", "NetStream.Publish.Start", "Publishing stream.")
+}
+
+// endPublish stops the decoder, the stream is closed once the decoded
+// audio has been written
+func (c *conn) endPublish() {
+	if c.publish == nil {
+		return
+	}
+	p := c.publish
+	c.publish = nil
+	p.decoder.closeInput()
+	<-p.done
+	if err := p.decoder.wait(); err != nil {
+		log.Printf("Error decoding RTMP audio: %v", err)
+	}
+	log.Printf("RTMP publish ended from %s", c.netConn.RemoteAddr())
+}
+
+// authorize returns the user of a stream key
+func (s *Server) authorize(name string) (string, bool) {
+	if len(s.config.Keys) == 0 {
+		return "", true
+	}
+	user, ok := s.config.Keys[name]
+	return user, ok
+}
+
+// forwardAudio writes the decoded PCM to the transcription stream until
+// ffmpeg exits
+func (p *publishSession) forwardAudio() {
+	defer close(p.done)
+	defer p.stream.Close()
+	buffer := make([]byte, 9600) // 100ms of audio
+	pcm := p.decoder.pcm()
+	for {
+		n, err := io.ReadFull(pcm, buffer)
+		if n > 0 {
+			if _, err := p.stream.Write(buffer[:n]); err != nil {
+				logSampler.Printf("rtmp:write", "Error writing to transcription stream: %v", err)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// drainResults consumes the results, subscribers of the live transcript
+// endpoints receive them through the service
+func (p *publishSession) drainResults() {
+	for range p.stream.Results() {
+	}
+}
+
+func (c *conn) sendControl(typeID byte, payload []byte) error {
+	return c.send(2, &message{typeID: typeID, payload: payload})
+}
+
+func (c *conn) sendCommand(streamID uint32, values ...interface{}) error {
+	return c.send(3, &message{typeID: msgCommandAMF0, streamID: streamID, payload: encodeAMF(values...)})
+}
+
+func (c *conn) sendStatus(level, code, description string) error {
+	return c.sendCommand(publishStreamID, "onStatus", 0, nil, newAMFObject(
+		"level", level,
+		"code", code,
+		"description", description,
+	))
+}
+
+func (c *conn) send(csid byte, m *message) error {
+	if err := writeMessage(c.rw, csid, m, serverChunkSize); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
@@ -2,14 +2,20 @@ package session
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/live"
 	"github.com/walterfan/webrtc-transcriber/internal/rtc"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
 )
 
-// MakeHandler returns an HTTP handler for the session service
-func MakeHandler(webrtcService rtc.Service) http.Handler {
+// MakeHandler returns an HTTP handler for the session service, joinable
+// checks that a user may join a room
+func MakeHandler(webrtcService rtc.Service, joinable func(room, user string) bool) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -24,12 +30,51 @@ func MakeHandler(webrtcService rtc.Service) http.Handler {
 			return
 		}
 
+		if req.Task != "" && req.Task != transcribe.TaskTranscribe && req.Task != transcribe.TaskTranslate {
+			http.Error(w, "task must be transcribe or translate", http.StatusBadRequest)
+			return
+		}
+		if len([]rune(req.Prompt)) > transcribe.MaxPromptLength {
+			http.Error(w, fmt.Sprintf("prompt must have at most %d characters", transcribe.MaxPromptLength), http.StatusBadRequest)
+			return
+		}
+		if err := transcribe.ValidateExtra(req.Options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		user := auth.UserFromContext(r.Context())
+		speaker := ""
+		if req.Room != "" {
+			if !live.ValidRoomID(req.Room) {
+				http.Error(w, "room must have 1 to 64 letters, digits, - or _", http.StatusBadRequest)
+				return
+			}
+			if !joinable(req.Room, user) {
+				http.Error(w, "room belongs to another tenant", http.StatusForbidden)
+				return
+			}
+			speaker = strings.TrimSpace(req.Name)
+			if speaker == "" {
+				speaker = user
+			}
+			if !live.ValidSpeaker(speaker) {
+				http.Error(w, "name is too long", http.StatusBadRequest)
+				return
+			}
+		}
+
 		// Log the language selection
 		language := req.Language
 		if language == "" {
 			language = "auto"
 		}
 
+		// Server messages are in the locale of the client
+		locale := req.Locale
+		if locale == "" {
+			locale = transcribe.ParseLocale(r.Header.Get("Accept-Language"))
+		}
+
 		// Default transcribe to true if not specified
 		transcribe := true
 		if req.Transcribe != nil {
@@ -39,8 +84,18 @@ func MakeHandler(webrtcService rtc.Service) http.Handler {
 
 		// Create peer connection with options
 		peer, err := webrtcService.CreatePeerConnectionWithOptions(rtc.PeerConnectionOptions{
-			Language:   language,
-			Transcribe: transcribe,
+			Language:    language,
+			Transcribe:  transcribe,
+			User:        user,
+			Locale:      locale,
+			Diarization: req.Diarization,
+			Task:        req.Task,
+			Prompt:      req.Prompt,
+			Extra:       req.Options,
+			Levels:      req.Levels,
+			Stats:       req.Stats,
+			Room:        req.Room,
+			Speaker:     speaker,
 		})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -55,7 +110,8 @@ func MakeHandler(webrtcService rtc.Service) http.Handler {
 		}
 
 		payload, err := json.Marshal(newSessionResponse{
-			Answer: answer,
+			Answer:    answer,
+			SessionID: peer.ID(),
 		})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
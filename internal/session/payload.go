@@ -1,11 +1,21 @@
 package session
 
 type newSessionRequest struct {
-	Offer      string `json:"offer"`
-	Language   string `json:"language,omitempty"`   // Language code for transcription (e.g., "en", "zh", "auto")
-	Transcribe *bool  `json:"transcribe,omitempty"` // Whether to transcribe (default: true)
+	Offer       string            `json:"offer"`
+	Language    string            `json:"language,omitempty"`       // Language code for transcription (e.g., "en", "zh", "auto")
+	Transcribe  *bool             `json:"transcribe,omitempty"`     // Whether to transcribe (default: true)
+	Locale      string            `json:"locale,omitempty"`         // Language of the server messages, Accept-Language when empty
+	Diarization bool              `json:"diarization,omitempty"`    // Whether to label the speakers of the results
+	Task        string            `json:"task,omitempty"`           // transcribe (default) or translate to English, Whisper only
+	Prompt      string            `json:"prompt,omitempty"`         // Initial prompt of Whisper, e.g. the names and jargon of the meeting
+	Options     map[string]string `json:"vendor_options,omitempty"` // Vendor settings, e.g. {"deepgram.model": "nova-3"}
+	Levels      bool              `json:"levels,omitempty"`         // Whether to send the audio levels on the DataChannel
+	Stats       bool              `json:"stats,omitempty"`          // Whether to send the connection stats on the DataChannel
+	Room        string            `json:"room,omitempty"`           // Room whose merged transcript the session joins, see /api/rooms/<id>
+	Name        string            `json:"name,omitempty"`           // Name of the participant in the room, the username when empty
 }
 
 type newSessionResponse struct {
-	Answer string `json:"answer"`
+	Answer    string `json:"answer"`
+	SessionID string `json:"session_id"` // Identifier of the stats of the session, /sessions/<id>/stats
 }
@@ -0,0 +1,197 @@
+package sip
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compactHeaders maps the compact header forms of RFC 3261 to their names
+var compactHeaders = map[string]string{
+	"v": "Via",
+	"f": "From",
+	"t": "To",
+	"i": "Call-ID",
+	"m": "Contact",
+	"l": "Content-Length",
+	"c": "Content-Type",
+	"k": "Supported",
+	"s": "Subject",
+}
+
+// header is a header field, the order of the fields is preserved
+type header struct {
+	name  string
+	value string
+}
+
+// message is a SIP request or response
+type message struct {
+	method  string // Request method, empty for responses
+	uri     string
+	status  int
+	reason  string
+	headers []header
+	body    []byte
+}
+
+// parseMessage parses a datagram holding a single SIP message
+func parseMessage(data []byte) (*message, error) {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, fmt.Errorf("missing end of headers")
+	}
+	lines := strings.Split(string(data[:end]), "\r\n")
+	m := &message{body: data[end+4:]}
+
+	start := strings.SplitN(lines[0], " ", 3)
+	if len(start) != 3 {
+		return nil, fmt.Errorf("invalid start line: %q", lines[0])
+	}
+	if strings.HasPrefix(start[0], "SIP/") {
+		status, err := strconv.Atoi(start[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code: %q", start[1])
+		}
+		m.status = status
+		m.reason = start[2]
+	} else {
+		if start[2] != "SIP/2.0" {
+			return nil, fmt.Errorf("unsupported SIP version: %q", start[2])
+		}
+		m.method = start[0]
+		m.uri = start[1]
+	}
+
+	for _, line := range lines[1:] {
+		// Folded continuation lines
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(m.headers) > 0 {
+			m.headers[len(m.headers)-1].value += " " + strings.TrimSpace(line)
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid header: %q", line)
+		}
+		name := strings.TrimSpace(line[:colon])
+		if full, ok := compactHeaders[strings.ToLower(name)]; ok {
+			name = full
+		}
+		m.headers = append(m.headers, header{name: name, value: strings.TrimSpace(line[colon+1:])})
+	}
+
+	if length := m.get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > len(m.body) {
+			return nil, fmt.Errorf("invalid Content-Length: %q", length)
+		}
+		m.body = m.body[:n]
+	}
+	return m, nil
+}
+
+// get returns the first value of the header
+func (m *message) get(name string) string {
+	for _, h := range m.headers {
+		if strings.EqualFold(h.name, name) {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// all returns every value of the header, comma separated values included
+func (m *message) all(name string) []string {
+	var values []string
+	for _, h := range m.headers {
+		if strings.EqualFold(h.name, name) {
+			values = append(values, h.value)
+		}
+	}
+	return values
+}
+
+func (m *message) add(name, value string) {
+	m.headers = append(m.headers, header{name: name, value: value})
+}
+
+// cseq returns the sequence number and method of the CSeq header
+func (m *message) cseq() (int, string) {
+	parts := strings.Fields(m.get("CSeq"))
+	if len(parts) != 2 {
+		return 0, ""
+	}
+	n, _ := strconv.Atoi(parts[0])
+	return n, parts[1]
+}
+
+// bytes encodes the message, Content-Length is computed from the body
+func (m *message) bytes() []byte {
+	var b bytes.Buffer
+	if m.method != "" {
+		fmt.Fprintf(&b, "%s %s SIP/2.0\r\n", m.method, m.uri)
+	} else {
+		fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", m.status, m.reason)
+	}
+	for _, h := range m.headers {
+		if strings.EqualFold(h.name, "Content-Length") {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", h.name, h.value)
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(m.body))
+	b.Write(m.body)
+	return b.Bytes()
+}
+
+// response creates a response to the request, copying the headers that
+// identify the transaction and dialog
+func (m *message) response(status int, reason, toTag string) *message {
+	resp := &message{status: status, reason: reason}
+	for _, via := range m.all("Via") {
+		resp.add("Via", via)
+	}
+	resp.add("From", m.get("From"))
+	to := m.get("To")
+	if toTag != "" && headerParam(to, "tag") == "" {
+		to += ";tag=" + toTag
+	}
+	resp.add("To", to)
+	resp.add("Call-ID", m.get("Call-ID"))
+	resp.add("CSeq", m.get("CSeq"))
+	return resp
+}
+
+// headerParam returns a parameter of a header value such as the tag of
+// From and To, parameters inside <> belong to the URI and are ignored
+func headerParam(value, name string) string {
+	if i := strings.LastIndexByte(value, '>'); i >= 0 {
+		value = value[i+1:]
+	}
+	for _, param := range strings.Split(value, ";")[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if strings.EqualFold(kv[0], name) {
+			if len(kv) == 2 {
+				return kv[1]
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// uriUser returns the user part of the URI in a From or To header
+func uriUser(value string) string {
+	if i := strings.IndexByte(value, '<'); i >= 0 {
+		value = value[i+1:]
+		if j := strings.IndexByte(value, '>'); j >= 0 {
+			value = value[:j]
+		}
+	}
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "sips:"), "sip:")
+	if at := strings.IndexByte(value, '@'); at >= 0 {
+		return value[:at]
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package sip
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	data := "INVITE sip:transcriber@192.0.2.10 SIP/2.0\r\n" +
+		"v: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n" +
+		"f: \"Alice\" <sip:alice@example.com;transport=udp>;tag=1928301774\r\n" +
+		"To: <sip:transcriber@192.0.2.10>\r\n" +
+		"i: a84b4c76e66710\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"Subject: a subject\r\n" +
+		" folded\r\n" +
+		"l: 4\r\n" +
+		"\r\n" +
+		"v=0\r\ntrailing"
+	m, err := parseMessage([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.method != "INVITE" || m.uri != "sip:transcriber@192.0.2.10" || string(m.body) != "v=0\r" {
+		t.Errorf("parseMessage = %+v", m)
+	}
+	if m.get("call-id") != "a84b4c76e66710" || m.get("Subject") != "a subject folded" {
+		t.Errorf("headers %+v", m.headers)
+	}
+	if seq, method := m.cseq(); seq != 314159 || method != "INVITE" {
+		t.Errorf("cseq = %d %s", seq, method)
+	}
+	from := m.get("From")
+	if headerParam(from, "tag") != "1928301774" || headerParam(from, "transport") != "" || uriUser(from) != "alice" {
+		t.Errorf("From %q", from)
+	}
+
+	// The response copies the dialog headers and round trips
+	resp, err := parseMessage(m.response(200, "OK", "abc").bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.status != 200 || resp.reason != "OK" || headerParam(resp.get("To"), "tag") != "abc" {
+		t.Errorf("response %+v", resp)
+	}
+	if !reflect.DeepEqual(resp.all("Via"), m.all("Via")) || resp.get("CSeq") != "314159 INVITE" {
+		t.Errorf("response headers %+v", resp.headers)
+	}
+
+	for _, data := range []string{
+		"INVITE sip:transcriber@192.0.2.10 SIP/2.0\r\nCSeq: 1 INVITE\r\n",
+		"INVITE sip:transcriber@192.0.2.10\r\n\r\n",
+		"INVITE sip:transcriber@192.0.2.10 SIP/3.0\r\n\r\n",
+		"SIP/2.0 OK fine\r\n\r\n",
+		"INVITE sip:transcriber@192.0.2.10 SIP/2.0\r\nno colon\r\n\r\n",
+		"INVITE sip:transcriber@192.0.2.10 SIP/2.0\r\nContent-Length: 10\r\n\r\nv=0",
+		"INVITE sip:transcriber@192.0.2.10 SIP/2.0\r\nContent-Length: -1\r\n\r\n",
+	} {
+		if _, err := parseMessage([]byte(data)); err == nil {
+			t.Errorf("parseMessage(%q) succeeded", data)
+		}
+	}
+}
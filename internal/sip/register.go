@@ -0,0 +1,302 @@
+package sip
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultExpires is the registration lifetime requested from the registrar
+	defaultExpires = 5 * time.Minute
+	// minRefresh bounds the refresh interval of short registrations
+	minRefresh = 30 * time.Second
+	// maxRegisterRetry bounds the delay between failed registrations
+	maxRegisterRetry = 5 * time.Minute
+)
+
+// Registration is the account the server registers with a PBX or SIP
+// trunk, which then routes the calls of the account to the server
+type Registration struct {
+	Registrar string // host:port of the registrar, 5060 when no port
+	Domain    string // Domain of the address of record, the registrar host when empty
+	User      string
+	Password  string
+	Expires   time.Duration // Requested lifetime, defaultExpires when zero
+}
+
+// ParseRegistration parses an address of record such as
+// sip:1001@pbx.example.com:5060 into a Registration with the password
+func ParseRegistration(aor, password string) (*Registration, error) {
+	u, err := url.Parse(aor)
+	if err != nil || u.Scheme != "sip" || u.Opaque == "" {
+		return nil, fmt.Errorf("invalid SIP address %q, e.g. sip:1001@pbx.example.com", aor)
+	}
+	at := strings.LastIndexByte(u.Opaque, '@')
+	if at <= 0 {
+		return nil, fmt.Errorf("SIP address %q has no user", aor)
+	}
+	host := u.Opaque[at+1:]
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "5060")
+	}
+	domain, _, _ := net.SplitHostPort(host)
+	return &Registration{
+		Registrar: host,
+		Domain:    domain,
+		User:      u.Opaque[:at],
+		Password:  password,
+	}, nil
+}
+
+// registrar keeps the registration of the server, the responses to its
+// REGISTER requests are routed to it by Call-ID
+type registrar struct {
+	server       *Server
+	registration Registration
+	callID       string
+	fromTag      string
+	responses    chan *message
+
+	mu         sync.Mutex
+	cseq       int
+	registered bool // Logs the first registration and those after a failure
+}
+
+func newRegistrar(s *Server, registration Registration) *registrar {
+	if registration.Expires <= 0 {
+		registration.Expires = defaultExpires
+	}
+	if registration.Domain == "" {
+		registration.Domain, _, _ = net.SplitHostPort(registration.Registrar)
+	}
+	return &registrar{
+		server:       s,
+		registration: registration,
+		callID:       newTag() + newTag() + "@transcriber",
+		fromTag:      newTag(),
+		responses:    make(chan *message, 4),
+	}
+}
+
+// run registers and refreshes the registration until the server stops,
+// retrying the failures with a backoff
+func (r *registrar) run() {
+	retry := minRefresh
+	for {
+		expires, err := r.register()
+		if err != nil {
+			r.registered = false
+			logSampler.Printf("sip:register", "SIP registration of %s@%s failed: %v, retrying in %v", r.registration.User, r.registration.Domain, err, retry)
+			time.Sleep(retry)
+			if retry *= 2; retry > maxRegisterRetry {
+				retry = maxRegisterRetry
+			}
+			continue
+		}
+		retry = minRefresh
+		refresh := expires / 2
+		if refresh < minRefresh {
+			refresh = minRefresh
+		}
+		time.Sleep(refresh)
+	}
+}
+
+// register sends a REGISTER, authenticated when challenged, and returns
+// the lifetime granted by the registrar
+func (r *registrar) register() (time.Duration, error) {
+	remote, err := net.ResolveUDPAddr("udp", r.registration.Registrar)
+	if err != nil {
+		return 0, err
+	}
+	authorization := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := r.transact(r.request(remote, authorization), remote)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case resp.status >= 200 && resp.status < 300:
+			expires := r.registration.Expires
+			if value := headerParam(resp.get("Contact"), "expires"); value != "" {
+				if seconds, err := strconv.Atoi(value); err == nil {
+					expires = time.Duration(seconds) * time.Second
+				}
+			} else if seconds, err := strconv.Atoi(resp.get("Expires")); err == nil {
+				expires = time.Duration(seconds) * time.Second
+			}
+			if !r.registered {
+				r.registered = true
+				log.Printf("Registered as %s@%s with %s for %v", r.registration.User, r.registration.Domain, r.registration.Registrar, expires)
+			}
+			return expires, nil
+		case resp.status == 401 || resp.status == 407:
+			header, challenge := "Authorization", resp.get("WWW-Authenticate")
+			if resp.status == 407 {
+				header, challenge = "Proxy-Authorization", resp.get("Proxy-Authenticate")
+			}
+			credentials, err := r.authorize(challenge)
+			if err != nil {
+				return 0, err
+			}
+			authorization = header + ": " + credentials
+		default:
+			return 0, fmt.Errorf("%d %s", resp.status, resp.reason)
+		}
+	}
+	return 0, fmt.Errorf("credentials rejected")
+}
+
+// request creates a new REGISTER request, with the authorization header
+// line when not empty
+func (r *registrar) request(remote *net.UDPAddr, authorization string) *message {
+	r.mu.Lock()
+	r.cseq++
+	cseq := r.cseq
+	r.mu.Unlock()
+
+	local := net.JoinHostPort(r.server.localIP(remote).String(), fmt.Sprint(r.server.conn.LocalAddr().(*net.UDPAddr).Port))
+	aor := fmt.Sprintf("<sip:%s@%s>", r.registration.User, r.registration.Domain)
+	m := &message{method: "REGISTER", uri: "sip:" + r.registration.Domain}
+	m.add("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=z9hG4bK%s;rport", local, newTag()))
+	m.add("Max-Forwards", "70")
+	m.add("From", aor+";tag="+r.fromTag)
+	m.add("To", aor)
+	m.add("Call-ID", r.callID)
+	m.add("CSeq", fmt.Sprintf("%d REGISTER", cseq))
+	m.add("Contact", fmt.Sprintf("<sip:%s@%s>", r.registration.User, local))
+	m.add("Expires", fmt.Sprint(int(r.registration.Expires/time.Second)))
+	m.add("User-Agent", "webrtc-transcriber")
+	if authorization != "" {
+		parts := strings.SplitN(authorization, ": ", 2)
+		m.add(parts[0], parts[1])
+	}
+	return m
+}
+
+// transact sends the request until its final response arrives, with the
+// retransmissions of timer A of RFC 3261
+func (r *registrar) transact(m *message, remote *net.UDPAddr) (*message, error) {
+	cseq, method := m.cseq()
+	data := m.bytes()
+	interval := timerT1
+	deadline := time.After(64 * timerT1)
+	for {
+		if _, err := r.server.conn.WriteToUDP(data, remote); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(interval)
+	wait:
+		for {
+			select {
+			case resp := <-r.responses:
+				if n, respMethod := resp.cseq(); n != cseq || respMethod != method || resp.status < 200 {
+					continue // Provisional or stale response
+				}
+				timer.Stop()
+				return resp, nil
+			case <-timer.C:
+				break wait
+			case <-deadline:
+				timer.Stop()
+				return nil, fmt.Errorf("no response from %s", remote)
+			}
+		}
+		if interval *= 2; interval > timerT2 {
+			interval = timerT2
+		}
+	}
+}
+
+// authorize answers a Digest challenge (RFC 2617, MD5 with or without
+// qop=auth)
+func (r *registrar) authorize(challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+		return "", fmt.Errorf("unsupported authentication %q", challenge)
+	}
+	params := parseAuthParams(challenge[len("digest "):])
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+	uri := "sip:" + r.registration.Domain
+	ha1 := md5Hex(r.registration.User + ":" + params["realm"] + ":" + r.registration.Password)
+	ha2 := md5Hex("REGISTER:" + uri)
+	credentials := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5`,
+		r.registration.User, params["realm"], params["nonce"], uri)
+	qop := ""
+	for _, value := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(value) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		cnonce := newTag()
+		const nc = "00000001"
+		credentials += fmt.Sprintf(`, response="%s", qop=auth, nc=%s, cnonce="%s"`,
+			md5Hex(ha1+":"+params["nonce"]+":"+nc+":"+cnonce+":auth:"+ha2), nc, cnonce)
+	} else {
+		credentials += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+params["nonce"]+":"+ha2))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		credentials += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return credentials, nil
+}
+
+// deliver routes a response to the registrar, false when it belongs to
+// another dialog
+func (r *registrar) deliver(resp *message) bool {
+	if resp.get("Call-ID") != r.callID {
+		return false
+	}
+	select {
+	case r.responses <- resp:
+	default:
+	}
+	return true
+}
+
+// parseAuthParams parses the comma separated name=value parameters of a
+// challenge, the values may be quoted and contain commas
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"') + 1
+			if end == 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end], s[end+1:]
+			}
+			s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), strings.TrimPrefix(s[end:], ",")
+		}
+		params[name] = value
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,104 @@
+package sip
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the errors of malformed or unexpected RTP packets
+var logSampler = logging.NewSampler(10 * time.Second)
+
+// rtpTimeout ends a call whose media stopped without a BYE
+const rtpTimeout = 60 * time.Second
+
+// leg receives one RTP stream of a call and writes it to a transcription
+// stream
+type leg struct {
+	label    string
+	conn     *net.UDPConn
+	codec    int
+	stream   transcribe.Stream
+	resample *audio.Upsampler
+}
+
+// listenRTP opens the UDP port of a leg, in the port range when one is
+// configured
+func listenRTP(portMin, portMax int) (*net.UDPConn, error) {
+	if portMin == 0 {
+		return net.ListenUDP("udp", &net.UDPAddr{})
+	}
+	// RTP ports are even by convention, the next odd port is left to RTCP
+	for port := portMin + portMin%2; port <= portMax; port += 2 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no free RTP port in %d-%d", portMin, portMax)
+}
+
+// receive writes the audio of the RTP packets to the stream until the
+// connection is closed or the media times out
+func (l *leg) receive() {
+	buffer := make([]byte, 1500)
+	for {
+		l.conn.SetReadDeadline(time.Now().Add(rtpTimeout))
+		n, err := l.conn.Read(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				logSampler.Printf("sip:rtp-timeout", "No RTP received for %v on leg %s, ending it", rtpTimeout, l.label)
+			}
+			return
+		}
+
+		payloadType, payload, err := parseRTP(buffer[:n])
+		if err != nil {
+			logSampler.Printf("sip:rtp-invalid", "Dropping RTP packet: %v", err)
+			continue
+		}
+		if payloadType != l.codec {
+			// Comfort noise and telephone events are not transcribed
+			continue
+		}
+
+		var samples []int16
+		if l.codec == payloadPCMA {
+			samples = audio.DecodeAlaw(payload)
+		} else {
+			samples = audio.DecodeUlaw(payload)
+		}
+		if _, err := l.stream.Write(l.resample.Process(samples)); err != nil {
+			logSampler.Printf("sip:write", "Error writing to transcription stream: %v", err)
+		}
+	}
+}
+
+// parseRTP returns the payload type and payload of an RTP packet (RFC 3550)
+func parseRTP(packet []byte) (int, []byte, error) {
+	if len(packet) < 12 {
+		return 0, nil, fmt.Errorf("packet too short: %d bytes", len(packet))
+	}
+	if packet[0]>>6 != 2 {
+		return 0, nil, fmt.Errorf("unsupported RTP version: %d", packet[0]>>6)
+	}
+	offset := 12 + 4*int(packet[0]&0x0f)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < offset+4 {
+			return 0, nil, fmt.Errorf("truncated header extension")
+		}
+		offset += 4 + 4*(int(packet[offset+2])<<8|int(packet[offset+3]))
+	}
+	end := len(packet)
+	if packet[0]&0x20 != 0 && end > 0 {
+		end -= int(packet[end-1])
+	}
+	if offset > end {
+		return 0, nil, fmt.Errorf("invalid RTP header length")
+	}
+	return int(packet[1] & 0x7f), packet[offset:end], nil
+}
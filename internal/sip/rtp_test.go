@@ -0,0 +1,46 @@
+package sip
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRTP(t *testing.T) {
+	header := []byte{0x80, 0x80 | payloadPCMA, 0, 1, 0, 0, 0, 160, 0xde, 0xad, 0xbe, 0xef}
+	payload := []byte{1, 2, 3, 4}
+	for name, test := range map[string]struct {
+		packet  []byte
+		payload []byte
+	}{
+		"plain": {append(append([]byte{}, header...), payload...), payload},
+		// Two CSRCs
+		"csrc": {bytes.Join([][]byte{{0x82}, header[1:], make([]byte, 8), payload}, nil), payload},
+		// A header extension of one word
+		"extension": {bytes.Join([][]byte{{0x90}, header[1:], {0xbe, 0xde, 0, 1, 9, 9, 9, 9}, payload}, nil), payload},
+		// Three bytes of padding, counting the last one
+		"padding": {bytes.Join([][]byte{{0xa0}, header[1:], payload, {0, 0, 3}}, nil), payload},
+		"empty":   {header, []byte{}},
+	} {
+		pt, got, err := parseRTP(test.packet)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if pt != payloadPCMA || !bytes.Equal(got, test.payload) {
+			t.Errorf("%s: payload type %d, payload %v", name, pt, got)
+		}
+	}
+
+	for name, packet := range map[string][]byte{
+		"short":        header[:11],
+		"version 1":    append([]byte{0x40}, header[1:]...),
+		"csrc":         append([]byte{0x83}, header[1:]...),
+		"no extension": append([]byte{0x90}, header[1:]...),
+		"extension":    bytes.Join([][]byte{{0x90}, header[1:], {0xbe, 0xde, 0, 2, 9, 9, 9, 9}}, nil),
+		"padding":      bytes.Join([][]byte{{0xa0}, header[1:], {0, 20}}, nil),
+	} {
+		if _, _, err := parseRTP(packet); err == nil {
+			t.Errorf("parseRTP of the %s packet succeeded", name)
+		}
+	}
+}
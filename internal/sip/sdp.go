@@ -0,0 +1,140 @@
+package sip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Static RTP payload types of G.711 (RFC 3551)
+const (
+	payloadPCMU = 0
+	payloadPCMA = 8
+)
+
+// media is an m= section of an SDP offer
+type media struct {
+	kind    string // audio, video, ...
+	port    int
+	proto   string
+	formats []string
+	label   string         // a=label, identifies SIPREC streams
+	rtpmap  map[int]string // payload type -> encoding name
+}
+
+// sessionDescription is the part of an SDP offer needed to answer it
+type sessionDescription struct {
+	media []*media
+}
+
+// parseSDP parses an SDP offer
+func parseSDP(body string) (*sessionDescription, error) {
+	sd := &sessionDescription{}
+	var current *media
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'm':
+			fields := strings.Fields(value)
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("invalid media line: %q", line)
+			}
+			port, err := strconv.Atoi(strings.SplitN(fields[1], "/", 2)[0])
+			if err != nil || port < 0 || port > 65535 {
+				return nil, fmt.Errorf("invalid media port: %q", line)
+			}
+			current = &media{
+				kind:    fields[0],
+				port:    port,
+				proto:   fields[2],
+				formats: fields[3:],
+				rtpmap:  make(map[int]string),
+			}
+			sd.media = append(sd.media, current)
+		case 'a':
+			if current == nil {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(value, "rtpmap:"):
+				fields := strings.Fields(strings.TrimPrefix(value, "rtpmap:"))
+				if len(fields) == 2 {
+					pt, err := strconv.Atoi(fields[0])
+					if err == nil {
+						current.rtpmap[pt] = strings.ToUpper(strings.SplitN(fields[1], "/", 2)[0])
+					}
+				}
+			case strings.HasPrefix(value, "label:"):
+				current.label = strings.TrimPrefix(value, "label:")
+			}
+		}
+	}
+	if len(sd.media) == 0 {
+		return nil, fmt.Errorf("SDP has no media")
+	}
+	return sd, nil
+}
+
+// codec picks the G.711 payload type to receive, -1 when the media offers
+// no supported codec
+func (m *media) codec() int {
+	if m.kind != "audio" || m.port == 0 || !strings.HasPrefix(m.proto, "RTP/AVP") {
+		return -1
+	}
+	for _, format := range m.formats {
+		pt, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+		if name := m.encoding(pt); name == "PCMU" || name == "PCMA" {
+			return pt
+		}
+	}
+	return -1
+}
+
+// encoding returns the encoding name of a payload type, static payload
+// types do not need an rtpmap attribute
+func (m *media) encoding(pt int) string {
+	if name, ok := m.rtpmap[pt]; ok {
+		return name
+	}
+	switch pt {
+	case payloadPCMU:
+		return "PCMU"
+	case payloadPCMA:
+		return "PCMA"
+	}
+	return ""
+}
+
+// answerSDP builds the answer receiving each accepted media on its port,
+// media with a zero port are rejected
+func answerSDP(offer *sessionDescription, ip string, ports []int, codecs []int) string {
+	version := time.Now().Unix()
+	var b strings.Builder
+	b.WriteString("v=0\r\n")
+	fmt.Fprintf(&b, "o=webrtc-transcriber %d %d IN IP4 %s\r\n", version, version, ip)
+	b.WriteString("s=webrtc-transcriber\r\n")
+	fmt.Fprintf(&b, "c=IN IP4 %s\r\n", ip)
+	b.WriteString("t=0 0\r\n")
+	for i, m := range offer.media {
+		if ports[i] == 0 {
+			fmt.Fprintf(&b, "m=%s 0 %s %s\r\n", m.kind, m.proto, strings.Join(m.formats, " "))
+			continue
+		}
+		pt := codecs[i]
+		fmt.Fprintf(&b, "m=audio %d %s %d\r\n", ports[i], m.proto, pt)
+		fmt.Fprintf(&b, "a=rtpmap:%d %s/8000\r\n", pt, m.encoding(pt))
+		if m.label != "" {
+			fmt.Fprintf(&b, "a=label:%s\r\n", m.label)
+		}
+		b.WriteString("a=recvonly\r\n")
+	}
+	return b.String()
+}
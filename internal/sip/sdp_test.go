@@ -0,0 +1,168 @@
+package sip
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOffer = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.0.2.1\r\n" +
+	"t=0 0\r\n" +
+	"a=sendrecv\r\n" +
+	"m=audio 49170 RTP/AVP 18 8 101\r\n" +
+	"a=rtpmap:18 G729/8000\r\n" +
+	"a=rtpmap:101 telephone-event/8000\r\n" +
+	"a=label:1\r\n" +
+	"m=video 51372 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"m=audio 49172/2 RTP/AVP 96 0\r\n" +
+	"a=rtpmap:96 pcmu/8000\r\n" +
+	"a=label:2\r\n"
+
+func TestParseSDP(t *testing.T) {
+	sd, err := parseSDP(testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*media{
+		{"audio", 49170, "RTP/AVP", []string{"18", "8", "101"}, "1", map[int]string{18: "G729", 101: "TELEPHONE-EVENT"}},
+		{"video", 51372, "RTP/AVP", []string{"96"}, "", map[int]string{96: "H264"}},
+		{"audio", 49172, "RTP/AVP", []string{"96", "0"}, "2", map[int]string{96: "PCMU"}},
+	}
+	if !reflect.DeepEqual(sd.media, want) {
+		t.Errorf("parseSDP = %+v, want %+v", sd.media, want)
+	}
+
+	// Bare line feeds are accepted
+	if sd, err := parseSDP(strings.Replace(testOffer, "\r\n", "\n", -1)); err != nil || len(sd.media) != 3 {
+		t.Errorf("parseSDP with line feeds: %v", err)
+	}
+
+	for _, body := range []string{
+		"v=0\r\ns=-\r\n",
+		"m=audio 49170 RTP/AVP\r\n",
+		"m=audio port RTP/AVP 0\r\n",
+		"m=audio -1 RTP/AVP 0\r\n",
+		"m=audio 65536 RTP/AVP 0\r\n",
+	} {
+		if _, err := parseSDP(body); err == nil {
+			t.Errorf("parseSDP(%q) succeeded", body)
+		}
+	}
+}
+
+func TestMediaCodec(t *testing.T) {
+	for _, test := range []struct {
+		media media
+		codec int
+	}{
+		// The first G.711 payload type in the order of preference
+		{media{kind: "audio", port: 4000, proto: "RTP/AVP", formats: []string{"18", "8", "0"}}, 8},
+		{media{kind: "audio", port: 4000, proto: "RTP/AVP", formats: []string{"0", "8"}}, 0},
+		// Static payload types can be remapped by rtpmap
+		{media{kind: "audio", port: 4000, proto: "RTP/AVP", formats: []string{"0", "97"}, rtpmap: map[int]string{0: "OPUS", 97: "PCMA"}}, 97},
+		{media{kind: "audio", port: 4000, proto: "RTP/AVPF", formats: []string{"0"}}, 0},
+		{media{kind: "audio", port: 4000, proto: "RTP/AVP", formats: []string{"18", "101"}}, -1},
+		{media{kind: "audio", port: 0, proto: "RTP/AVP", formats: []string{"0"}}, -1},
+		{media{kind: "audio", port: 4000, proto: "RTP/SAVP", formats: []string{"0"}}, -1},
+		{media{kind: "video", port: 4000, proto: "RTP/AVP", formats: []string{"0"}}, -1},
+	} {
+		if codec := test.media.codec(); codec != test.codec {
+			t.Errorf("codec of %+v = %d, want %d", test.media, codec, test.codec)
+		}
+	}
+}
+
+func TestAnswerSDP(t *testing.T) {
+	offer, err := parseSDP(testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := answerSDP(offer, "198.51.100.7", []int{30000, 0, 30002}, []int{8, -1, 96})
+	if !strings.HasPrefix(answer, "v=0\r\no=webrtc-transcriber ") || !strings.Contains(answer, "c=IN IP4 198.51.100.7\r\n") {
+		t.Errorf("answer session lines:\n%s", answer)
+	}
+	media := answer[strings.Index(answer, "m="):]
+	want := "m=audio 30000 RTP/AVP 8\r\n" +
+		"a=rtpmap:8 PCMA/8000\r\n" +
+		"a=label:1\r\n" +
+		"a=recvonly\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"m=audio 30002 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 PCMU/8000\r\n" +
+		"a=label:2\r\n" +
+		"a=recvonly\r\n"
+	if media != want {
+		t.Errorf("answer media:\n%s\nwant:\n%s", media, want)
+	}
+
+	// The answer is a valid offer with the same number of media
+	if sd, err := parseSDP(answer); err != nil || len(sd.media) != len(offer.media) {
+		t.Errorf("parseSDP of the answer: %v", err)
+	}
+}
+
+func TestParseOffer(t *testing.T) {
+	sd, names, err := parseOffer("application/sdp", []byte(testOffer))
+	if err != nil || len(sd.media) != 3 || names != nil {
+		t.Errorf("parseOffer of an SDP body = %v, %v, %v", sd, names, err)
+	}
+
+	metadata := `<?xml version="1.0" encoding="UTF-8"?>
+<recording xmlns="urn:ietf:params:xml:ns:recording:1">
+  <participant participant_id="p1">
+    <nameID aor="sip:alice@example.com"><name>Alice Smith</name></nameID>
+  </participant>
+  <participant participant_id="p2">
+    <nameID aor="sip:bob@example.com"/>
+  </participant>
+  <participant participant_id="p3">
+    <nameID aor="tel:+15551234"/>
+  </participant>
+  <stream stream_id="s1"><label>1</label></stream>
+  <stream stream_id="s2"><label>2</label></stream>
+  <stream stream_id="s3"><label>3</label></stream>
+  <participantstreamassoc participant_id="p1"><send>s1</send></participantstreamassoc>
+  <participantstreamassoc participant_id="p2"><send>s2</send><send>s4</send></participantstreamassoc>
+  <participantstreamassoc participant_id="p3"><send> s3 </send></participantstreamassoc>
+</recording>`
+	body := "--boundary\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		testOffer + "\r\n" +
+		"--boundary\r\n" +
+		"Content-Type: application/rs-metadata+xml\r\n" +
+		"Content-Disposition: recording-session\r\n\r\n" +
+		metadata + "\r\n" +
+		"--boundary--\r\n"
+	sd, names, err = parseOffer(`multipart/mixed; boundary="boundary"`, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sd.media) != 3 || sd.media[2].label != "2" {
+		t.Errorf("SIPREC offer media %+v", sd.media)
+	}
+	want := map[string]string{"1": "Alice Smith", "2": "bob", "3": "tel:+15551234"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("participant names %v, want %v", names, want)
+	}
+
+	// Invalid metadata only loses the names
+	invalid := strings.Replace(body, "</recording>", "", 1)
+	if sd, names, err := parseOffer("multipart/mixed; boundary=boundary", []byte(invalid)); err != nil || sd == nil || names != nil {
+		t.Errorf("parseOffer with invalid metadata = %v, %v, %v", sd, names, err)
+	}
+
+	for contentType, body := range map[string]string{
+		"text/plain":                         testOffer,
+		"application/sdp; charset":           testOffer,
+		"application/sdp":                    "v=0\r\n",
+		"multipart/mixed; boundary=boundary": "--boundary\r\nContent-Type: text/plain\r\n\r\nhello\r\n--boundary--\r\n",
+	} {
+		if _, _, err := parseOffer(contentType, []byte(body)); err == nil {
+			t.Errorf("parseOffer of %s succeeded", contentType)
+		}
+	}
+}
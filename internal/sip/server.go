@@ -0,0 +1,481 @@
+package sip
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Timer T1 of RFC 3261, the 2xx response to an INVITE is retransmitted
+// with a doubling interval until the ACK arrives or 64*T1 elapsed
+const (
+	timerT1 = 500 * time.Millisecond
+	timerT2 = 4 * time.Second
+)
+
+// Config holds the SIP server configuration
+type Config struct {
+	Addr      string       // UDP listen address, e.g. :5060
+	PublicIP  string       // Address announced in SDP and Contact, detected when empty
+	PortMin   int          // First port of the RTP range, any free port when zero
+	PortMax   int          // Last port of the RTP range
+	Allow     []*net.IPNet // Networks allowed to send requests, any when empty
+	Language  string
+	OutputDir string        // Directory of the per-call transcripts
+	User      string        // Owner of the calls, whose live transcripts show them
+	Register  *Registration // Account registered with a PBX or SIP trunk, none when nil
+}
+
+// Server is a SIP user agent server accepting calls and SIPREC recording
+// sessions over UDP and transcribing their G.711 audio, optionally
+// registered with a PBX or SIP trunk as an extension
+type Server struct {
+	config    Config
+	service   transcribe.Service
+	conn      *net.UDPConn
+	mu        sync.Mutex
+	calls     map[string]*call
+	registrar *registrar
+}
+
+// call is an accepted INVITE dialog
+type call struct {
+	id       string
+	toTag    string
+	started  time.Time
+	legs     []*leg
+	cseq     int    // CSeq of the INVITE
+	response []byte // Final response to the INVITE, resent on retransmissions
+	acked    chan struct{}
+	mu       sync.Mutex
+	lines    []string // Transcript lines of all legs in arrival order
+	pending  sync.WaitGroup
+	ended    bool
+}
+
+// NewServer creates a new SIP server feeding the service
+func NewServer(service transcribe.Service, config Config) *Server {
+	return &Server{
+		config:  config,
+		service: service,
+		calls:   make(map[string]*call),
+	}
+}
+
+// ListenAndServe receives SIP requests until the connection fails
+func (s *Server) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SIP address: %w", err)
+	}
+	s.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer s.conn.Close()
+
+	if s.config.Register != nil {
+		s.registrar = newRegistrar(s, *s.config.Register)
+		go s.registrar.run()
+	}
+
+	buffer := make([]byte, 65535)
+	for {
+		n, remote, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		go s.handle(data, remote)
+	}
+}
+
+func (s *Server) handle(data []byte, remote *net.UDPAddr) {
+	// Keep-alives of some PBXs are bare CRLFs
+	if strings.TrimSpace(string(data)) == "" {
+		return
+	}
+	req, err := parseMessage(data)
+	if err != nil {
+		logSampler.Printf("sip:parse", "Dropping invalid SIP message from %s: %v", remote, err)
+		return
+	}
+	if req.method == "" {
+		// The only requests sent by the server are its registrations
+		if s.registrar != nil {
+			s.registrar.deliver(req)
+		}
+		return
+	}
+	if !s.allowed(remote.IP) {
+		logSampler.Printf("sip:forbidden", "Rejecting SIP %s from %s", req.method, remote)
+		s.send(req.response(403, "Forbidden", ""), remote)
+		return
+	}
+
+	switch req.method {
+	case "INVITE":
+		s.handleInvite(req, remote)
+	case "ACK":
+		if c := s.call(req.get("Call-ID")); c != nil {
+			c.ack()
+		}
+	case "BYE":
+		c := s.call(req.get("Call-ID"))
+		if c == nil {
+			s.send(req.response(481, "Call/Transaction Does Not Exist", ""), remote)
+			return
+		}
+		s.send(req.response(200, "OK", c.toTag), remote)
+		s.endCall(c)
+	case "CANCEL":
+		// Calls are answered at once, a CANCEL only ever matches an answered call
+		if c := s.call(req.get("Call-ID")); c != nil {
+			s.send(req.response(200, "OK", c.toTag), remote)
+			return
+		}
+		s.send(req.response(481, "Call/Transaction Does Not Exist", ""), remote)
+	case "OPTIONS":
+		resp := req.response(200, "OK", newTag())
+		resp.add("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS")
+		resp.add("Accept", "application/sdp, multipart/mixed")
+		s.send(resp, remote)
+	default:
+		resp := req.response(405, "Method Not Allowed", newTag())
+		resp.add("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS")
+		s.send(resp, remote)
+	}
+}
+
+func (s *Server) handleInvite(req *message, remote *net.UDPAddr) {
+	callID := req.get("Call-ID")
+	cseq, _ := req.cseq()
+	c := &call{
+		id:      callID,
+		toTag:   newTag(),
+		started: time.Now(),
+		cseq:    cseq,
+		acked:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	existing := s.calls[callID]
+	if existing == nil {
+		s.calls[callID] = c
+	}
+	s.mu.Unlock()
+
+	if existing != nil {
+		// Retransmitted INVITE or re-INVITE, the media is not renegotiated
+		c := existing
+		c.mu.Lock()
+		response := c.response
+		if response == nil {
+			// Still creating the streams, 100 Trying was already sent
+			c.mu.Unlock()
+			return
+		}
+		if cseq != c.cseq {
+			resp := req.response(200, "OK", c.toTag)
+			parsed, _ := parseMessage(response)
+			for _, h := range parsed.headers {
+				if h.name == "Contact" || h.name == "Content-Type" {
+					resp.add(h.name, h.value)
+				}
+			}
+			resp.body = parsed.body
+			response = resp.bytes()
+		}
+		c.mu.Unlock()
+		s.conn.WriteToUDP(response, remote)
+		return
+	}
+	s.send(req.response(100, "Trying", ""), remote)
+
+	offer, names, err := parseOffer(req.get("Content-Type"), req.body)
+	if err != nil {
+		log.Printf("Rejecting SIP call %s: %v", callID, err)
+		s.reject(c, req, remote)
+		return
+	}
+
+	ip := s.localIP(remote)
+	caller := uriUser(req.get("From"))
+	if caller == "" {
+		caller = "caller"
+	}
+
+	ports := make([]int, len(offer.media))
+	codecs := make([]int, len(offer.media))
+	for i, m := range offer.media {
+		codec := m.codec()
+		if codec < 0 {
+			continue
+		}
+		label := caller
+		if len(offer.media) > 1 || m.label != "" {
+			label = names[m.label]
+			if label == "" {
+				label = "stream " + m.label
+			}
+		}
+		l, err := s.newLeg(callID, label, codec)
+		if err != nil {
+			log.Printf("Error creating leg %s of SIP call %s: %v", label, callID, err)
+			continue
+		}
+		ports[i] = l.conn.LocalAddr().(*net.UDPAddr).Port
+		codecs[i] = codec
+		c.legs = append(c.legs, l)
+	}
+	if len(c.legs) == 0 {
+		log.Printf("Rejecting SIP call %s: no G.711 audio offered", callID)
+		s.reject(c, req, remote)
+		return
+	}
+
+	resp := req.response(200, "OK", c.toTag)
+	resp.add("Contact", fmt.Sprintf("<sip:transcriber@%s>", net.JoinHostPort(ip.String(), fmt.Sprint(s.conn.LocalAddr().(*net.UDPAddr).Port))))
+	resp.add("Content-Type", "application/sdp")
+	resp.body = []byte(answerSDP(offer, ip.String(), ports, codecs))
+	c.mu.Lock()
+	c.response = resp.bytes()
+	c.mu.Unlock()
+
+	log.Printf("Accepted SIP call %s from %s with %d audio legs", callID, req.get("From"), len(c.legs))
+	var receiving sync.WaitGroup
+	for _, l := range c.legs {
+		c.pending.Add(1)
+		receiving.Add(1)
+		go c.collect(l)
+		go func(l *leg) {
+			defer receiving.Done()
+			l.receive()
+		}(l)
+	}
+	// The call also ends when the media of all legs timed out without a BYE
+	go func() {
+		receiving.Wait()
+		s.endCall(c)
+	}()
+	go s.retransmit(c, remote)
+}
+
+// reject answers the INVITE with 488 and forgets the call
+func (s *Server) reject(c *call, req *message, remote *net.UDPAddr) {
+	s.mu.Lock()
+	delete(s.calls, c.id)
+	s.mu.Unlock()
+	s.send(req.response(488, "Not Acceptable Here", c.toTag), remote)
+}
+
+// newLeg opens the RTP port and the transcription stream of a leg
+func (s *Server) newLeg(callID, label string, codec int) (*leg, error) {
+	conn, err := listenRTP(s.config.PortMin, s.config.PortMax)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   s.config.Language,
+		Transcribe: true,
+		User:       s.config.User,
+		SessionID:  callID,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &leg{
+		label:    label,
+		conn:     conn,
+		codec:    codec,
+		stream:   stream,
+		resample: audio.NewUpsampler(8000, transcribe.PipelineSampleRate),
+	}, nil
+}
+
+// retransmit resends the 2xx response until the ACK arrives
+func (s *Server) retransmit(c *call, remote *net.UDPAddr) {
+	s.conn.WriteToUDP(c.response, remote)
+	interval := timerT1
+	deadline := time.After(64 * timerT1)
+	for {
+		select {
+		case <-c.acked:
+			return
+		case <-deadline:
+			log.Printf("No ACK received for SIP call %s", c.id)
+			return
+		case <-time.After(interval):
+			s.conn.WriteToUDP(c.response, remote)
+			if interval *= 2; interval > timerT2 {
+				interval = timerT2
+			}
+		}
+	}
+}
+
+// endCall closes the legs of the call, the transcript is written once the
+// last results arrived
+func (s *Server) endCall(c *call) {
+	c.mu.Lock()
+	if c.ended {
+		c.mu.Unlock()
+		return
+	}
+	c.ended = true
+	c.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.calls, c.id)
+	s.mu.Unlock()
+
+	c.ack()
+	for _, l := range c.legs {
+		l.conn.Close()
+		l.stream.Close()
+	}
+	log.Printf("SIP call %s ended after %v", c.id, time.Since(c.started).Round(time.Second))
+
+	go func() {
+		c.pending.Wait()
+		s.writeTranscript(c)
+	}()
+}
+
+// collect gathers the final results of a leg into the call transcript
+func (c *call) collect(l *leg) {
+	defer c.pending.Done()
+	for result := range l.stream.Results() {
+		if !result.Final || strings.TrimSpace(result.Text) == "" {
+			continue
+		}
+		c.mu.Lock()
+		c.lines = append(c.lines, fmt.Sprintf("[%s] %s", l.label, strings.TrimSpace(result.Text)))
+		c.mu.Unlock()
+	}
+}
+
+func (c *call) ack() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.acked:
+	default:
+		close(c.acked)
+	}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// writeTranscript writes the merged transcript of all legs of the call
+func (s *Server) writeTranscript(c *call) {
+	c.mu.Lock()
+	lines := c.lines
+	c.mu.Unlock()
+	if len(lines) == 0 || s.config.OutputDir == "" {
+		return
+	}
+	id := unsafeFileChars.ReplaceAllString(c.id, "_")
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	path := filepath.Join(s.config.OutputDir, fmt.Sprintf("sip_%s_%s.txt", c.started.Format("20060102_150405"), id))
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		log.Printf("Error writing transcript of SIP call %s: %v", c.id, err)
+		return
+	}
+	log.Printf("Saved transcript of SIP call %s: %s", c.id, path)
+}
+
+func (s *Server) call(id string) *call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[id]
+}
+
+func (s *Server) send(m *message, remote *net.UDPAddr) {
+	if _, err := s.conn.WriteToUDP(m.bytes(), remote); err != nil {
+		logSampler.Printf("sip:send", "Error sending SIP response to %s: %v", remote, err)
+	}
+}
+
+func (s *Server) allowed(ip net.IP) bool {
+	if len(s.config.Allow) == 0 {
+		return true
+	}
+	for _, network := range s.config.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// localIP returns the address announced to the remote, the public IP
+// when configured or the local address routing to the remote
+func (s *Server) localIP(remote *net.UDPAddr) net.IP {
+	if ip := net.ParseIP(s.config.PublicIP); ip != nil {
+		return ip
+	}
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+func newTag() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParsePortRange parses a port range such as "10000-20000", an empty range
+// selects any free port
+func ParsePortRange(spec string) (int, int, error) {
+	if spec == "" {
+		return 0, 0, nil
+	}
+	var min, max int
+	if _, err := fmt.Sscanf(spec, "%d-%d", &min, &max); err != nil || min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range: %q", spec)
+	}
+	return min, max, nil
+}
+
+// ParseNetworks parses a comma separated list of CIDRs or IP addresses
+func ParseNetworks(spec string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,111 @@
+package sip
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+// recordingMetadata is the part of the SIPREC metadata (RFC 7865) used to
+// name the streams after the participants sending them
+type recordingMetadata struct {
+	Participants []struct {
+		ID     string `xml:"participant_id,attr"`
+		NameID struct {
+			AOR  string `xml:"aor,attr"`
+			Name string `xml:"name"`
+		} `xml:"nameID"`
+	} `xml:"participant"`
+	Streams []struct {
+		ID    string `xml:"stream_id,attr"`
+		Label string `xml:"label"`
+	} `xml:"stream"`
+	Associations []struct {
+		ParticipantID string   `xml:"participant_id,attr"`
+		Send          []string `xml:"send"`
+	} `xml:"participantstreamassoc"`
+}
+
+// parseOffer extracts the SDP offer of an INVITE body, for SIPREC
+// recording sessions it also returns the participant name of each
+// media label
+func parseOffer(contentType string, body []byte) (*sessionDescription, map[string]string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Content-Type: %w", err)
+	}
+	switch mediaType {
+	case "application/sdp":
+		sd, err := parseSDP(string(body))
+		return sd, nil, err
+	case "multipart/mixed":
+	default:
+		return nil, nil, fmt.Errorf("unsupported Content-Type: %s", mediaType)
+	}
+
+	var sd *sessionDescription
+	var names map[string]string
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "application/sdp":
+			if sd, err = parseSDP(string(content)); err != nil {
+				return nil, nil, err
+			}
+		case "application/rs-metadata+xml":
+			names = participantNames(content)
+		}
+	}
+	if sd == nil {
+		return nil, nil, fmt.Errorf("multipart body has no SDP")
+	}
+	return sd, names, nil
+}
+
+// participantNames maps the stream labels to the name of the participant
+// sending them, invalid metadata is ignored as it is informative only
+func participantNames(content []byte) map[string]string {
+	var metadata recordingMetadata
+	if err := xml.Unmarshal(content, &metadata); err != nil {
+		return nil
+	}
+
+	participants := make(map[string]string)
+	for _, p := range metadata.Participants {
+		name := strings.TrimSpace(p.NameID.Name)
+		if name == "" {
+			name = uriUser("<" + p.NameID.AOR + ">")
+		}
+		if name == "" {
+			name = p.NameID.AOR
+		}
+		participants[p.ID] = name
+	}
+	labels := make(map[string]string)
+	for _, s := range metadata.Streams {
+		labels[s.ID] = strings.TrimSpace(s.Label)
+	}
+
+	names := make(map[string]string)
+	for _, assoc := range metadata.Associations {
+		for _, streamID := range assoc.Send {
+			if label, ok := labels[strings.TrimSpace(streamID)]; ok && participants[assoc.ParticipantID] != "" {
+				names[label] = participants[assoc.ParticipantID]
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Collector keeps in-memory counters describing the server activity,
+// it is safe for concurrent use
+type Collector struct {
+	mu            sync.Mutex
+	vendor        string
+	startedAt     time.Time
+	active        int
+	pending       int
+	day           string
+	sessionsToday int
+	sessionsTotal int
+	audioBytes    int64
+	vendorErrors  int
+	lastError     string
+	lastErrorAt   time.Time
+	lastSuccessAt time.Time
+	pool          *transcribe.Pool // Pool of the transcription slots, nil when unlimited
+}
+
+// VendorHealth describes how the transcription vendor has behaved recently
+type VendorHealth struct {
+	Name          string `json:"name"`
+	Status        string `json:"status"` // "ok", "degraded" or "unknown"
+	Errors        int    `json:"errors"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorAt   int64  `json:"last_error_at,omitempty"`
+	LastSuccessAt int64  `json:"last_success_at,omitempty"`
+}
+
+// StorageUsage describes the disk space used by the recordings directory
+type StorageUsage struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Snapshot is the point-in-time view of the statistics served to the dashboard
+type Snapshot struct {
+	ActiveSessions     int          `json:"active_sessions"`
+	SessionsToday      int          `json:"sessions_today"`
+	SessionsTotal      int          `json:"sessions_total"`
+	TranscribedMinutes float64      `json:"transcribed_minutes"`
+	QueueDepth         int          `json:"queue_depth"`
+	DroppedResults     int64        `json:"dropped_results"` // Results the vendors dropped for slow readers
+	UptimeSeconds      int64        `json:"uptime_seconds"`
+	Vendor             VendorHealth `json:"vendor"`
+	Storage            StorageUsage `json:"storage"`
+	Pool               *PoolUsage   `json:"pool,omitempty"`
+}
+
+// PoolUsage describes the slots of the streams transcribed at the same time
+type PoolUsage struct {
+	Slots   int `json:"slots"`
+	Active  int `json:"active"`
+	Waiting int `json:"waiting"` // Streams waiting for a slot
+}
+
+// NewCollector creates a new Collector for the given vendor name
+func NewCollector(vendor string) *Collector {
+	return &Collector{
+		vendor:    vendor,
+		startedAt: time.Now(),
+		day:       today(),
+	}
+}
+
+// SetPool reports the usage of the pool of the transcription slots in the snapshots
+func (c *Collector) SetPool(pool *transcribe.Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pool = pool
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// rollDay resets the daily counters when the date changes, must be called with mu held
+func (c *Collector) rollDay() {
+	if d := today(); d != c.day {
+		c.day = d
+		c.sessionsToday = 0
+	}
+}
+
+func (c *Collector) streamOpened() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollDay()
+	c.active++
+	c.sessionsToday++
+	c.sessionsTotal++
+	c.lastSuccessAt = time.Now()
+}
+
+// streamClosed moves a stream from the active set to the queue of streams
+// whose results are still being produced
+func (c *Collector) streamClosed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.pending++
+}
+
+func (c *Collector) streamDrained() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending--
+}
+
+func (c *Collector) audioWritten(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.audioBytes += int64(n)
+}
+
+func (c *Collector) vendorFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vendorErrors++
+	c.lastError = err.Error()
+	c.lastErrorAt = time.Now()
+}
+
+// Snapshot returns the current statistics, storage usage is computed by
+// walking outputDir
+func (c *Collector) Snapshot(outputDir string) Snapshot {
+	c.mu.Lock()
+	c.rollDay()
+	snap := Snapshot{
+		ActiveSessions:     c.active,
+		SessionsToday:      c.sessionsToday,
+		SessionsTotal:      c.sessionsTotal,
+		TranscribedMinutes: float64(c.audioBytes) / transcribe.PCMBytesPerSecond / 60,
+		QueueDepth:         c.pending,
+		DroppedResults:     transcribe.DroppedResults(),
+		UptimeSeconds:      int64(time.Since(c.startedAt).Seconds()),
+		Vendor: VendorHealth{
+			Name:   c.vendor,
+			Status: "unknown",
+			Errors: c.vendorErrors,
+		},
+	}
+	if !c.lastSuccessAt.IsZero() {
+		snap.Vendor.Status = "ok"
+		snap.Vendor.LastSuccessAt = c.lastSuccessAt.UnixMilli()
+	}
+	if !c.lastErrorAt.IsZero() {
+		snap.Vendor.LastError = c.lastError
+		snap.Vendor.LastErrorAt = c.lastErrorAt.UnixMilli()
+		if c.lastErrorAt.After(c.lastSuccessAt) {
+			snap.Vendor.Status = "degraded"
+		}
+	}
+	pool := c.pool
+	c.mu.Unlock()
+
+	if pool != nil {
+		usage := PoolUsage{}
+		usage.Active, usage.Waiting, usage.Slots = pool.Stats()
+		snap.Pool = &usage
+	}
+	snap.Storage = storageUsage(outputDir)
+	return snap
+}
+
+// storageUsage sums the size of all regular files below dir
+func storageUsage(dir string) StorageUsage {
+	usage := StorageUsage{Path: dir}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip unreadable entries
+		}
+		if info.Mode().IsRegular() {
+			usage.Files++
+			usage.Bytes += info.Size()
+		}
+		return nil
+	})
+	return usage
+}
@@ -0,0 +1,26 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// MakeHandler returns an HTTP handler serving the dashboard statistics,
+// outputDir is the recordings directory used to report storage usage
+func MakeHandler(collector *Collector, outputDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		payload, err := json.Marshal(collector.Snapshot(outputDir))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+	})
+}
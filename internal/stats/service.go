@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"sync"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// Service wraps a transcribe.Service and records the activity of
+// every stream it creates into a Collector
+type Service struct {
+	next      transcribe.Service
+	collector *Collector
+}
+
+// stream wraps a transcribe.Stream, forwarding its results so the
+// collector knows when the vendor has finished producing them
+type stream struct {
+	next      transcribe.Stream
+	collector *Collector
+	results   <-chan transcribe.Result
+	closed    sync.Once // Moves the stream out of the active ones once
+}
+
+// NewService creates a new transcribe.Service that reports to the collector
+func NewService(next transcribe.Service, collector *Collector) transcribe.Service {
+	return &Service{
+		next:      next,
+		collector: collector,
+	}
+}
+
+// CreateStream creates a new monitored stream
+func (s *Service) CreateStream() (transcribe.Stream, error) {
+	return s.wrap(s.next.CreateStream())
+}
+
+// CreateStreamWithOptions creates a new monitored stream with the specified options
+func (s *Service) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	return s.wrap(s.next.CreateStreamWithOptions(opts))
+}
+
+func (s *Service) wrap(next transcribe.Stream, err error) (transcribe.Stream, error) {
+	if err != nil {
+		s.collector.vendorFailed(err)
+		return nil, err
+	}
+	s.collector.streamOpened()
+
+	return &stream{
+		next:      next,
+		collector: s.collector,
+		results:   transcribe.ForwardResults(next, nil, s.collector.streamDrained),
+	}, nil
+}
+
+// Results returns the results of the underlying stream
+func (st *stream) Results() <-chan transcribe.Result {
+	return st.results
+}
+
+// Write passes audio to the underlying stream and counts it
+func (st *stream) Write(buffer []byte) (int, error) {
+	n, err := st.next.Write(buffer)
+	if err != nil {
+		st.collector.vendorFailed(err)
+	}
+	st.collector.audioWritten(n)
+	return n, err
+}
+
+// Close closes the underlying stream, its results are still counted
+// as queued until the vendor closes the results channel. The wrappers
+// and the segment restarts may close a stream more than once
+func (st *stream) Close() error {
+	st.closed.Do(st.collector.streamClosed)
+	err := st.next.Close()
+	if err != nil {
+		st.collector.vendorFailed(err)
+	}
+	return err
+}
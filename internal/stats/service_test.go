@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// fakeService creates streams closing their results when closed
+type fakeService struct{}
+
+type fakeStream struct {
+	results chan transcribe.Result
+	closed  bool
+}
+
+func (fakeService) CreateStream() (transcribe.Stream, error) {
+	return &fakeStream{results: make(chan transcribe.Result)}, nil
+}
+
+func (s fakeService) CreateStreamWithOptions(transcribe.StreamOptions) (transcribe.Stream, error) {
+	return s.CreateStream()
+}
+
+func (st *fakeStream) Write(p []byte) (int, error)       { return len(p), nil }
+func (st *fakeStream) Results() <-chan transcribe.Result { return st.results }
+
+func (st *fakeStream) Close() error {
+	if !st.closed {
+		st.closed = true
+		close(st.results)
+	}
+	return nil
+}
+
+func TestStreamClosedTwice(t *testing.T) {
+	collector := NewCollector("fake")
+	service := NewService(fakeService{}, collector)
+	stream, err := service.CreateStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write(make([]byte, transcribe.PCMBytesPerSecond*60)); err != nil {
+		t.Fatal(err)
+	}
+	if snap := collector.Snapshot(""); snap.ActiveSessions != 1 || snap.TranscribedMinutes != 1 {
+		t.Errorf("ActiveSessions = %d, TranscribedMinutes = %v", snap.ActiveSessions, snap.TranscribedMinutes)
+	}
+
+	stream.Close()
+	stream.Close()
+	for range stream.Results() {
+	}
+	snap := collector.Snapshot("")
+	if snap.ActiveSessions != 0 || snap.SessionsTotal != 1 {
+		t.Errorf("after closing twice: ActiveSessions = %d, SessionsTotal = %d", snap.ActiveSessions, snap.SessionsTotal)
+	}
+}
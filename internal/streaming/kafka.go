@@ -0,0 +1,393 @@
+package streaming
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka API keys and the versions used by this producer, Produce v3 is the
+// oldest version that uses the v2 record batch format still accepted by Kafka 4
+const (
+	kafkaAPIProduce        = 0
+	kafkaAPIMetadata       = 3
+	kafkaProduceVersion    = 3
+	kafkaMetadataVersion   = 4
+	kafkaRequestTimeoutMs  = 10000
+	kafkaAcksLeader        = 1
+	kafkaRecordBatchMagic  = 2
+	kafkaNoProducerID      = -1
+	kafkaNoPartitionLeader = -1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaPublisher is a minimal Kafka producer, it sends every message as a
+// single record batch to the leader of the partition chosen from the key
+type KafkaPublisher struct {
+	brokers       []string
+	clientID      string
+	mu            sync.Mutex
+	conns         map[string]*kafkaConn
+	leaders       map[string]map[int32]string // topic -> partition -> leader address
+	correlationID int32
+}
+
+type kafkaConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewKafkaPublisher creates a new producer bootstrapping from the brokers
+// given as host:port
+func NewKafkaPublisher(brokers []string, clientID string) (*KafkaPublisher, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("at least one Kafka broker is required")
+	}
+	return &KafkaPublisher{
+		brokers:  brokers,
+		clientID: clientID,
+		conns:    make(map[string]*kafkaConn),
+		leaders:  make(map[string]map[int32]string),
+	}, nil
+}
+
+// Publish produces the payload to the topic, messages with the same key go
+// to the same partition; metadata and connections are refreshed once on failure
+func (p *KafkaPublisher) Publish(topic, key string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = p.produce(topic, key, payload); err == nil {
+			return nil
+		}
+		delete(p.leaders, topic)
+	}
+	return err
+}
+
+// Close closes all broker connections
+func (p *KafkaPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, c := range p.conns {
+		c.conn.Close()
+		delete(p.conns, addr)
+	}
+	return nil
+}
+
+// produce must be called with mu held
+func (p *KafkaPublisher) produce(topic, key string, payload []byte) error {
+	leaders, err := p.topicLeaders(topic)
+	if err != nil {
+		return err
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	partition := int32(h.Sum32() % uint32(len(leaders)))
+	addr, ok := leaders[partition]
+	if !ok {
+		return fmt.Errorf("no leader for partition %d of topic %s", partition, topic)
+	}
+
+	var req kafkaEncoder
+	req.nullableString(nil) // transactional_id
+	req.int16(kafkaAcksLeader)
+	req.int32(kafkaRequestTimeoutMs)
+	req.int32(1) // topics
+	req.string(topic)
+	req.int32(1) // partitions
+	req.int32(partition)
+	batch := recordBatch([]byte(key), payload, time.Now())
+	req.int32(int32(len(batch)))
+	req.buf = append(req.buf, batch...)
+
+	resp, err := p.roundTrip(addr, kafkaAPIProduce, kafkaProduceVersion, req.buf)
+	if err != nil {
+		return err
+	}
+
+	// responses: [name, [index, error_code, base_offset, log_append_time]], throttle
+	d := kafkaDecoder{buf: resp}
+	for i, topics := 0, d.int32(); i < int(topics) && d.err == nil; i++ {
+		d.string()
+		for j, partitions := 0, d.int32(); j < int(partitions) && d.err == nil; j++ {
+			d.int32()
+			code := d.int16()
+			d.int64()
+			d.int64()
+			if code != 0 {
+				return fmt.Errorf("kafka produce to %s failed (error code: %d)", topic, code)
+			}
+		}
+	}
+	return d.err
+}
+
+// topicLeaders returns the leader address of each partition of the topic,
+// must be called with mu held
+func (p *KafkaPublisher) topicLeaders(topic string) (map[int32]string, error) {
+	if leaders, ok := p.leaders[topic]; ok {
+		return leaders, nil
+	}
+
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(topic)
+	req.int8(1) // allow_auto_topic_creation
+
+	var lastErr error
+	for _, broker := range p.brokers {
+		resp, err := p.roundTrip(broker, kafkaAPIMetadata, kafkaMetadataVersion, req.buf)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		leaders, err := parseMetadata(resp, topic)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p.leaders[topic] = leaders
+		return leaders, nil
+	}
+	return nil, fmt.Errorf("failed to fetch Kafka metadata for %s: %w", topic, lastErr)
+}
+
+func parseMetadata(resp []byte, topic string) (map[int32]string, error) {
+	d := kafkaDecoder{buf: resp}
+	d.int32() // throttle_time_ms
+
+	brokers := make(map[int32]string)
+	for i, n := 0, d.int32(); i < int(n) && d.err == nil; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.nullableString() // cluster_id
+	d.int32()          // controller_id
+
+	leaders := make(map[int32]string)
+	for i, n := 0, d.int32(); i < int(n) && d.err == nil; i++ {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+		for j, partitions := 0, d.int32(); j < int(partitions) && d.err == nil; j++ {
+			d.int16() // partition error_code
+			index := d.int32()
+			leader := d.int32()
+			d.int32Array() // replica_nodes
+			d.int32Array() // isr_nodes
+			if name == topic && leader != kafkaNoPartitionLeader {
+				leaders[index] = brokers[leader]
+			}
+		}
+		if name == topic && code != 0 {
+			return nil, fmt.Errorf("kafka metadata error for %s (error code: %d)", topic, code)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka topic %s has no partitions", topic)
+	}
+	return leaders, nil
+}
+
+// roundTrip sends one request to the broker and returns the response body
+// without its header, must be called with mu held
+func (p *KafkaPublisher) roundTrip(addr string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	c, err := p.connection(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p.correlationID++
+	var req kafkaEncoder
+	req.int32(0) // Size, set below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(p.correlationID)
+	req.string(p.clientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(15 * time.Second))
+	resp, err := c.exchange(req.buf)
+	if err != nil {
+		c.conn.Close()
+		delete(p.conns, addr)
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != p.correlationID {
+		c.conn.Close()
+		delete(p.conns, addr)
+		return nil, fmt.Errorf("unexpected Kafka correlation id from %s", addr)
+	}
+	return resp[4:], nil
+}
+
+func (p *KafkaPublisher) connection(addr string) (*kafkaConn, error) {
+	if c, ok := p.conns[addr]; ok {
+		return c, nil
+	}
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka broker %s: %w", addr, err)
+	}
+	log.Printf("Connected to Kafka broker %s", addr)
+	c := &kafkaConn{conn: conn, reader: bufio.NewReader(conn)}
+	p.conns[addr] = c
+	return c, nil
+}
+
+func (c *kafkaConn) exchange(req []byte) ([]byte, error) {
+	if _, err := c.conn.Write(req); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.reader, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// recordBatch encodes a v2 record batch holding a single record
+func recordBatch(key, value []byte, now time.Time) []byte {
+	var record []byte
+	record = append(record, 0)              // attributes
+	record = binary.AppendVarint(record, 0) // timestamp delta
+	record = binary.AppendVarint(record, 0) // offset delta
+	record = binary.AppendVarint(record, int64(len(key)))
+	record = append(record, key...)
+	record = binary.AppendVarint(record, int64(len(value)))
+	record = append(record, value...)
+	record = binary.AppendVarint(record, 0) // headers
+
+	var tail kafkaEncoder
+	tail.int16(0) // attributes
+	tail.int32(0) // last offset delta
+	tail.int64(now.UnixMilli())
+	tail.int64(now.UnixMilli())
+	tail.int64(kafkaNoProducerID)
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(1)  // records
+	tail.buf = binary.AppendVarint(tail.buf, int64(len(record)))
+	tail.buf = append(tail.buf, record...)
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + len(tail.buf)))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(kafkaRecordBatchMagic)
+	batch.int32(int32(crc32.Checksum(tail.buf, castagnoli)))
+	batch.buf = append(batch.buf, tail.buf...)
+	return batch.buf
+}
+
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+var errKafkaShortResponse = errors.New("kafka response is truncated")
+
+// kafkaDecoder reads a response, the first read past the end sets err
+// and every following read returns zero values
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.buf) < n {
+		d.err = errKafkaShortResponse
+		return make([]byte, n&0xffff)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	return int8(d.next(1)[0])
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	return int16(binary.BigEndian.Uint16(d.next(2)))
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	return int32(binary.BigEndian.Uint32(d.next(4)))
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	return int64(binary.BigEndian.Uint64(d.next(8)))
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) int32Array() {
+	for i, n := 0, d.int32(); i < int(n) && d.err == nil; i++ {
+		d.int32()
+	}
+}
@@ -0,0 +1,296 @@
+package streaming
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// kafkaRequest is a request received by the fake broker
+type kafkaRequest struct {
+	apiKey        int16
+	apiVersion    int16
+	correlationID int32
+	clientID      string
+	body          kafkaDecoder
+	broker        string // Address of the fake broker
+}
+
+// fakeKafka serves the requests of a producer with handle, which returns
+// the response body, and returns its address
+func fakeKafka(t *testing.T, handle func(r *kafkaRequest) []byte) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					var size [4]byte
+					if _, err := io.ReadFull(reader, size[:]); err != nil {
+						return
+					}
+					buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+					if _, err := io.ReadFull(reader, buf); err != nil {
+						return
+					}
+					r := &kafkaRequest{body: kafkaDecoder{buf: buf}, broker: listener.Addr().String()}
+					r.apiKey = r.body.int16()
+					r.apiVersion = r.body.int16()
+					r.correlationID = r.body.int32()
+					r.clientID = r.body.string()
+					var resp kafkaEncoder
+					resp.int32(0)
+					resp.int32(r.correlationID)
+					resp.buf = append(resp.buf, handle(r)...)
+					binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+					if _, err := conn.Write(resp.buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// metadataResponse is a Metadata v4 response: broker 1 at addr leads the
+// partitions of the topic
+func metadataResponse(addr, topic string, partitions int, code int16) []byte {
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	var e kafkaEncoder
+	e.int32(0) // throttle_time_ms
+	e.int32(1) // brokers
+	e.int32(1)
+	e.string(host)
+	e.int32(int32(portNumber))
+	e.nullableString(nil) // rack
+	e.nullableString(nil) // cluster_id
+	e.int32(1)            // controller_id
+	e.int32(1)            // topics
+	e.int16(code)
+	e.string(topic)
+	e.int8(0) // is_internal
+	e.int32(int32(partitions))
+	for i := 0; i < partitions; i++ {
+		e.int16(0)
+		e.int32(int32(i))
+		e.int32(1) // leader
+		e.int32(1) // replica_nodes
+		e.int32(1)
+		e.int32(1) // isr_nodes
+		e.int32(1)
+	}
+	return e.buf
+}
+
+// produceResponse is a Produce v3 response for one partition
+func produceResponse(topic string, partition int32, code int16) []byte {
+	var e kafkaEncoder
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int16(code)
+	e.int64(42) // base_offset
+	e.int64(-1) // log_append_time
+	e.int32(0)  // throttle_time_ms
+	return e.buf
+}
+
+// produced is the record of a Produce request
+type produced struct {
+	topic      string
+	partition  int32
+	key, value string
+}
+
+// decodeProduce decodes a Produce v3 request holding one record batch
+func decodeProduce(t *testing.T, d *kafkaDecoder) produced {
+	t.Helper()
+	if d.int16() != -1 { // transactional_id
+		t.Error("transactional_id is not null")
+	}
+	if acks := d.int16(); acks != kafkaAcksLeader {
+		t.Errorf("acks = %d", acks)
+	}
+	d.int32() // timeout_ms
+	var p produced
+	if d.int32() != 1 {
+		t.Fatal("not one topic")
+	}
+	p.topic = d.string()
+	if d.int32() != 1 {
+		t.Fatal("not one partition")
+	}
+	p.partition = d.int32()
+	batch := d.next(int(d.int32()))
+	if d.err != nil || len(d.buf) != 0 {
+		t.Fatalf("invalid Produce request: %v, %d bytes left", d.err, len(d.buf))
+	}
+	p.key, p.value = decodeBatch(t, batch)
+	return p
+}
+
+// decodeBatch checks a v2 record batch of one record and returns it
+func decodeBatch(t *testing.T, batch []byte) (key, value string) {
+	t.Helper()
+	d := kafkaDecoder{buf: batch}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(batch)-12 {
+		t.Errorf("batch length %d of %d bytes", length, len(batch))
+	}
+	d.int32() // partition leader epoch
+	if magic := d.int8(); magic != kafkaRecordBatchMagic {
+		t.Errorf("magic %d", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.buf, castagnoli) {
+		t.Error("invalid CRC-32C")
+	}
+	d.next(2 + 4 + 8 + 8 + 8 + 2 + 4) // attributes to base sequence
+	if records := d.int32(); records != 1 {
+		t.Fatalf("%d records", records)
+	}
+	varint := func() int64 {
+		v, n := binary.Varint(d.buf)
+		if n <= 0 {
+			t.Fatal("invalid varint")
+		}
+		d.buf = d.buf[n:]
+		return v
+	}
+	if length := varint(); int(length) != len(d.buf) {
+		t.Errorf("record length %d of %d bytes", length, len(d.buf))
+	}
+	d.int8() // attributes
+	varint() // timestamp delta
+	varint() // offset delta
+	key = string(d.next(int(varint())))
+	value = string(d.next(int(varint())))
+	if headers := varint(); headers != 0 || d.err != nil || len(d.buf) != 0 {
+		t.Errorf("invalid record: %d headers, %v, %d bytes left", headers, d.err, len(d.buf))
+	}
+	return key, value
+}
+
+func TestRecordBatch(t *testing.T) {
+	key, value := decodeBatch(t, recordBatch([]byte("session"), []byte("payload"), time.Now()))
+	if key != "session" || value != "payload" {
+		t.Errorf("record %q: %q", key, value)
+	}
+}
+
+func TestKafkaPublish(t *testing.T) {
+	received := make(chan kafkaDecoder, 1)
+	addr := fakeKafka(t, func(r *kafkaRequest) []byte {
+		if r.clientID != "transcriber" {
+			t.Errorf("client id %q", r.clientID)
+		}
+		switch r.apiKey {
+		case kafkaAPIMetadata:
+			if r.apiVersion != kafkaMetadataVersion {
+				t.Errorf("Metadata v%d", r.apiVersion)
+			}
+			if r.body.int32() != 1 || r.body.string() != "transcripts" || r.body.int8() != 1 {
+				t.Error("invalid Metadata request")
+			}
+			return metadataResponse(r.broker, "transcripts", 4, 0)
+		case kafkaAPIProduce:
+			if r.apiVersion != kafkaProduceVersion {
+				t.Errorf("Produce v%d", r.apiVersion)
+			}
+			received <- r.body
+			return produceResponse("transcripts", 0, 0)
+		}
+		t.Errorf("unexpected API key %d", r.apiKey)
+		return nil
+	})
+	p, err := NewKafkaPublisher([]string{addr}, "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := p.Publish("transcripts", "session-1", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		body := <-received
+		got := decodeProduce(t, &body)
+		h := fnv.New32a()
+		h.Write([]byte("session-1"))
+		want := produced{"transcripts", int32(h.Sum32() % 4), "session-1", "hello"}
+		if got != want {
+			t.Errorf("produced %+v, want %+v", got, want)
+		}
+	}
+	if len(p.leaders) != 1 || len(p.conns) != 1 {
+		t.Errorf("%d topics and %d connections cached", len(p.leaders), len(p.conns))
+	}
+}
+
+func TestKafkaPublishErrors(t *testing.T) {
+	var produces int32
+	addr := fakeKafka(t, func(r *kafkaRequest) []byte {
+		if r.apiKey == kafkaAPIMetadata {
+			return metadataResponse(r.broker, "transcripts", 1, 0)
+		}
+		atomic.AddInt32(&produces, 1)
+		return produceResponse("transcripts", 0, 6) // NOT_LEADER_OR_FOLLOWER
+	})
+	p, err := NewKafkaPublisher([]string{addr}, "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish("transcripts", "key", []byte("hello")); err == nil {
+		t.Error("Publish succeeded with an error code")
+	}
+	if n := atomic.LoadInt32(&produces); n != 2 {
+		t.Errorf("%d attempts, want 2 with the metadata refreshed", n)
+	}
+
+	if _, err := NewKafkaPublisher(nil, "transcriber"); err == nil {
+		t.Error("NewKafkaPublisher without brokers succeeded")
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	leaders, err := parseMetadata(metadataResponse("10.0.0.1:9092", "transcripts", 2, 0), "transcripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaders) != 2 || leaders[0] != "10.0.0.1:9092" || leaders[1] != "10.0.0.1:9092" {
+		t.Errorf("leaders = %v", leaders)
+	}
+
+	valid := metadataResponse("10.0.0.1:9092", "transcripts", 2, 0)
+	for name, resp := range map[string][]byte{
+		"truncated":      valid[:len(valid)-3],
+		"topic error":    metadataResponse("10.0.0.1:9092", "transcripts", 1, 3),
+		"no partitions":  metadataResponse("10.0.0.1:9092", "transcripts", 0, 0),
+		"other topic":    metadataResponse("10.0.0.1:9092", "other", 1, 0),
+		"negative array": {0, 0, 0, 0, 0x80, 0, 0, 0},
+	} {
+		if _, err := parseMetadata(resp, "transcripts"); err == nil {
+			t.Errorf("parseMetadata of the %s response succeeded", name)
+		}
+	}
+}
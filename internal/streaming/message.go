@@ -0,0 +1,84 @@
+package streaming
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Supported serialization formats
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Message is a structured transcript segment or session event,
+// the protobuf encoding follows transcript.proto
+type Message struct {
+	Type       string    `json:"type"` // session.started, segment, session.ended
+	Session    string    `json:"session"`
+	User       string    `json:"user,omitempty"`
+	Language   string    `json:"language,omitempty"`
+	Sequence   int       `json:"sequence"`
+	Text       string    `json:"text,omitempty"`
+	Confidence float32   `json:"confidence,omitempty"`
+	Final      bool      `json:"final,omitempty"`
+	AudioFile  string    `json:"audio_file,omitempty"`
+	TextFile   string    `json:"text_file,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Encode serializes the message in the given format
+func Encode(m Message, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return json.Marshal(m)
+	case FormatProtobuf:
+		return encodeProtobuf(m), nil
+	default:
+		return nil, fmt.Errorf("unsupported serialization format: %s", format)
+	}
+}
+
+// encodeProtobuf writes the protobuf wire format of the TranscriptMessage
+// defined in transcript.proto, zero values are omitted as in proto3
+func encodeProtobuf(m Message) []byte {
+	var buf []byte
+	buf = appendProtoString(buf, 1, m.Type)
+	buf = appendProtoString(buf, 2, m.Session)
+	buf = appendProtoString(buf, 3, m.User)
+	buf = appendProtoString(buf, 4, m.Language)
+	if m.Sequence != 0 {
+		buf = appendProtoVarint(buf, 5, uint64(m.Sequence))
+	}
+	buf = appendProtoString(buf, 6, m.Text)
+	if m.Confidence != 0 {
+		buf = binary.AppendUvarint(buf, 7<<3|5) // fixed32
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(m.Confidence))
+	}
+	if m.Final {
+		buf = appendProtoVarint(buf, 8, 1)
+	}
+	buf = appendProtoString(buf, 9, m.AudioFile)
+	buf = appendProtoString(buf, 10, m.TextFile)
+	if !m.Time.IsZero() {
+		buf = appendProtoVarint(buf, 11, uint64(m.Time.UnixMilli()))
+	}
+	return buf
+}
+
+func appendProtoVarint(buf []byte, field int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendProtoString(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
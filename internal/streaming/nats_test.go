@@ -0,0 +1,212 @@
+package streaming
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// natsConn is a client connection of the fake NATS server
+type natsConn struct {
+	connect  map[string]interface{} // Options of the CONNECT
+	messages chan string            // "<subject> <payload>" of the PUBs
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// fakeNATS accepts the connections of a publisher, sending them to conns
+// once the handshake is done, or refuses them with refuse when not empty
+func fakeNATS(t *testing.T, refuse string) (string, chan *natsConn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	conns := make(chan *natsConn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveNATS(t, conn, refuse, conns)
+		}
+	}()
+	return listener.Addr().String(), conns
+}
+
+func serveNATS(t *testing.T, conn net.Conn, refuse string, conns chan *natsConn) {
+	defer conn.Close()
+	c := &natsConn{messages: make(chan string, 4), conn: conn, reader: bufio.NewReader(conn)}
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	line, err := c.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "CONNECT ") {
+		t.Errorf("expected CONNECT, got %q, %v", line, err)
+		return
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &c.connect); err != nil {
+		t.Errorf("invalid CONNECT options: %v", err)
+	}
+	if line, _ := c.reader.ReadString('\n'); line != "PING\r\n" {
+		t.Errorf("expected PING, got %q", line)
+		return
+	}
+	if refuse != "" {
+		fmt.Fprintf(conn, "-ERR '%s'\r\n", refuse)
+		return
+	}
+	conn.Write([]byte("PONG\r\n"))
+	conns <- c
+	defer close(c.messages)
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PONG":
+			c.messages <- "PONG"
+		case "PUB":
+			if len(fields) != 3 {
+				t.Errorf("invalid PUB %q", line)
+				return
+			}
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(c.reader, payload); err != nil || string(payload[size:]) != "\r\n" {
+				t.Errorf("invalid payload %q: %v", payload, err)
+				return
+			}
+			c.messages <- fields[1] + " " + string(payload[:size])
+		default:
+			t.Errorf("unexpected %q", line)
+		}
+	}
+}
+
+// receive returns the next message of the connection
+func (c *natsConn) receive(t *testing.T) string {
+	t.Helper()
+	select {
+	case m := <-c.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return ""
+	}
+}
+
+func TestNewNATSPublisher(t *testing.T) {
+	p, err := NewNATSPublisher("nats://localhost", "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.server.Host != "localhost:4222" {
+		t.Errorf("host %s, want the default port", p.server.Host)
+	}
+	for _, server := range []string{"http://localhost:4222", "nats://[::1"} {
+		if _, err := NewNATSPublisher(server, "transcriber"); err == nil {
+			t.Errorf("NewNATSPublisher(%q) succeeded", server)
+		}
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	addr, conns := fakeNATS(t, "")
+	p, err := NewNATSPublisher("nats://alice:secret@"+addr, "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish("transcripts.alice", "key", []byte("hello\r\nworld")); err != nil {
+		t.Fatal(err)
+	}
+	c := <-conns
+	if c.connect["user"] != "alice" || c.connect["pass"] != "secret" || c.connect["name"] != "transcriber" || c.connect["verbose"] != false {
+		t.Errorf("CONNECT options %v", c.connect)
+	}
+	if m := c.receive(t); m != "transcripts.alice hello\r\nworld" {
+		t.Errorf("received %q", m)
+	}
+
+	// The PINGs of the server are answered
+	c.conn.Write([]byte("PING\r\n"))
+	if m := c.receive(t); m != "PONG" {
+		t.Errorf("received %q, want PONG", m)
+	}
+}
+
+func TestNATSToken(t *testing.T) {
+	addr, conns := fakeNATS(t, "")
+	p, err := NewNATSPublisher("nats://s3cr3t@"+addr, "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish("transcripts", "", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if c := <-conns; c.connect["auth_token"] != "s3cr3t" || c.connect["user"] != nil {
+		t.Errorf("CONNECT options %v", c.connect)
+	}
+}
+
+func TestNATSReconnects(t *testing.T) {
+	addr, conns := fakeNATS(t, "")
+	p, err := NewNATSPublisher("nats://"+addr, "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish("transcripts", "", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first := <-conns
+	first.receive(t)
+	first.conn.Close()
+
+	// The read loop drops the lost connection, the next publish reconnects
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		lost := p.conn == nil
+		p.mu.Unlock()
+		if lost {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the lost connection was kept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.Publish("transcripts", "", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if m := (<-conns).receive(t); m != "transcripts second" {
+		t.Errorf("received %q", m)
+	}
+}
+
+func TestNATSRefused(t *testing.T) {
+	addr, _ := fakeNATS(t, "Authorization Violation")
+	p, err := NewNATSPublisher("nats://alice:wrong@"+addr, "transcriber")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	err = p.Publish("transcripts", "", []byte("hello"))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Publish = %v, want the refusal", err)
+	}
+}
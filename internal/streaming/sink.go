@@ -0,0 +1,72 @@
+package streaming
+
+import (
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// Publisher sends messages to a Kafka topic or NATS subject
+type Publisher interface {
+	Publish(subject, key string, payload []byte) error
+	Close() error
+}
+
+// Config holds the streaming configuration
+type Config struct {
+	Subject  string // Topic or subject template, {user} and {session} are replaced
+	Format   string // json or protobuf
+	Partials bool   // Publish non-final segments too
+}
+
+// Sink streams the segments and session events to a Publisher, keyed by
+// session so the messages of a session stay in one partition
+type Sink struct {
+	name      string
+	publisher Publisher
+	config    Config
+}
+
+// NewSink creates a new events.Sink streaming to the publisher, name is
+// the backend used in the logs
+func NewSink(name string, publisher Publisher, config Config) *Sink {
+	if config.Subject == "" {
+		config.Subject = "transcripts"
+	}
+	return &Sink{
+		name:      name,
+		publisher: publisher,
+		config:    config,
+	}
+}
+
+// Name identifies the sink in the logs and dead letters
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Send encodes and publishes the stream events
+func (s *Sink) Send(e events.Event) error {
+	if !e.Streamed(s.config.Partials) {
+		return nil
+	}
+	m := Message{
+		Type:     e.Type,
+		Session:  e.Session,
+		User:     e.User,
+		Language: e.Language,
+		Time:     e.Time,
+		Sequence: e.Sequence,
+	}
+	if e.Type == events.Segment {
+		m.Text = e.Result.Text
+		m.Confidence = e.Result.Confidence
+		m.Final = e.Result.Final
+		m.AudioFile = e.Result.AudioFile
+		m.TextFile = e.Result.TextFile
+	}
+
+	payload, err := Encode(m, s.config.Format)
+	if err != nil {
+		return events.Permanent(err)
+	}
+	return s.publisher.Publish(e.Topic(s.config.Subject, nil), e.Session, payload)
+}
@@ -0,0 +1,92 @@
+// Package systemd implements the parts of the systemd service protocol the
+// server uses without linking libsystemd: readiness and watchdog
+// notifications (sd_notify) and socket activation (sd_listen_fds)
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// Notify sends a state such as "READY=1" or "STOPPING=1" to the service
+// manager. It reports false without error when the process is not run by
+// systemd with Type=notify
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// Abstract sockets are given with a leading @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval of the WATCHDOG=1 notifications,
+// half of WatchdogSec, or zero when the watchdog is disabled
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// Listeners returns the stream sockets passed by socket activation, by
+// their FileDescriptorName= (the unnamed ones are named "unknown" by
+// systemd). The environment is cleared so child processes do not inherit
+// them
+func Listeners() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener)
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// FileListener duplicates the descriptor, the original is closed
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d (%s) is not a stream socket: %w", fd, name, err)
+		}
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("several sockets are named %s, set FileDescriptorName=", name)
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
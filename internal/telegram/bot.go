@@ -0,0 +1,154 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// defaultAPIURL is the Telegram Bot API server
+const defaultAPIURL = "https://api.telegram.org"
+
+// pollTimeout is the long polling timeout of getUpdates
+const pollTimeout = 50 * time.Second
+
+// Bot is a minimal client of the Telegram Bot API
+type Bot struct {
+	token    string
+	apiURL   string
+	client   *http.Client
+	mu       sync.Mutex
+	username string
+}
+
+// message is the part of a Telegram message used by the bot
+type message struct {
+	Text string `json:"text"`
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+}
+
+// update is an incoming update of getUpdates
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+// apiError is the error of a failed Bot API call
+type apiError struct {
+	status      int
+	description string
+	retryAfter  int
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("Telegram API error %d: %s", e.status, e.description)
+}
+
+// NewBot creates a new Bot with the token given by @BotFather
+func NewBot(token string) *Bot {
+	return &Bot{
+		token:  token,
+		apiURL: defaultAPIURL,
+		client: &http.Client{Timeout: pollTimeout + 10*time.Second},
+	}
+}
+
+// Username returns the username of the bot, without the @, it is fetched
+// on first use
+func (b *Bot) Username() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.username != "" {
+		return b.username, nil
+	}
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := b.call("getMe", nil, &me); err != nil {
+		return "", err
+	}
+	b.username = me.Username
+	return b.username, nil
+}
+
+// SendMessage sends a plain text message to a chat
+func (b *Bot) SendMessage(chatID int64, text string) error {
+	return b.call("sendMessage", map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+// getUpdates long polls the updates following offset
+func (b *Bot) getUpdates(offset int64) ([]update, error) {
+	var updates []update
+	err := b.call("getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(pollTimeout / time.Second),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// call invokes a Bot API method and decodes its result
+func (b *Bot) call(method string, params interface{}, result interface{}) error {
+	body := []byte("{}")
+	if params != nil {
+		var err error
+		if body, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	resp, err := b.client.Post(b.apiURL+"/bot"+b.token+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The error carries the URL, and so the token
+		return fmt.Errorf("Telegram %s request failed", method)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&reply); err != nil {
+		return fmt.Errorf("invalid Telegram %s response (HTTP status: %d)", method, resp.StatusCode)
+	}
+	if !reply.OK {
+		return &apiError{
+			status:      resp.StatusCode,
+			description: reply.Description,
+			retryAfter:  reply.Parameters.RetryAfter,
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// deliveryError marks the API errors retrying cannot fix, e.g. a chat
+// that blocked the bot, as permanent
+func deliveryError(err error) error {
+	if apiErr, ok := err.(*apiError); ok {
+		if apiErr.status != http.StatusTooManyRequests && apiErr.status < 500 {
+			return events.Permanent(err)
+		}
+		if apiErr.retryAfter > 0 {
+			time.Sleep(time.Duration(apiErr.retryAfter) * time.Second)
+		}
+	}
+	return err
+}
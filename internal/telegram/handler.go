@@ -0,0 +1,137 @@
+package telegram
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+)
+
+// logSampler rate limits the polling errors while Telegram is unreachable
+var logSampler = logging.NewSampler(time.Minute)
+
+// linkStatus is the response of the link endpoint
+type linkStatus struct {
+	Linked    bool       `json:"linked"`
+	Code      string     `json:"code,omitempty"`
+	URL       string     `json:"url,omitempty"` // Opens the bot with the code
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// MakeHandler returns an HTTP handler to link the Telegram chat of the
+// authenticated user: GET returns whether a chat is linked, POST creates a
+// code to send to the bot and DELETE unlinks the chat
+func MakeHandler(bot *Bot, links *Links) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.UserFromContext(r.Context())
+		switch r.Method {
+		case http.MethodGet:
+			_, linked := links.Chat(user)
+			writeJSON(w, linkStatus{Linked: linked})
+
+		case http.MethodPost:
+			username, err := bot.Username()
+			if err != nil {
+				log.Printf("Error fetching the Telegram bot name: %v", err)
+				http.Error(w, "Telegram is unreachable", http.StatusBadGateway)
+				return
+			}
+			code, expires := links.NewCode(user)
+			_, linked := links.Chat(user)
+			writeJSON(w, linkStatus{
+				Linked:    linked,
+				Code:      code,
+				URL:       "https://t.me/" + username + "?start=" + code,
+				ExpiresAt: &expires,
+			})
+
+		case http.MethodDelete:
+			found, err := links.Unlink(user)
+			if !found {
+				http.Error(w, "No linked Telegram chat", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Telegram chat of %s unlinked", user)
+			writeJSON(w, linkStatus{})
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Listen polls the messages sent to the bot, "/start <code>" links the
+// chat to the user of the code and "/stop" unlinks it, it never returns
+func Listen(bot *Bot, links *Links) {
+	var offset int64
+	for {
+		updates, err := bot.getUpdates(offset)
+		if err != nil {
+			logSampler.Printf("telegram:poll", "Error polling Telegram updates: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				handleCommand(bot, links, u.Message)
+			}
+		}
+	}
+}
+
+func handleCommand(bot *Bot, links *Links, m *message) {
+	fields := strings.Fields(m.Text)
+	if len(fields) == 0 {
+		return
+	}
+	var reply string
+	switch strings.SplitN(fields[0], "@", 2)[0] {
+	case "/start":
+		if len(fields) < 2 {
+			reply = "Open the link of the transcriber settings page to receive your transcripts here."
+			break
+		}
+		user, err := links.Redeem(fields[1], m.Chat.ID)
+		if err != nil {
+			reply = "This link has expired, create a new one from the transcriber."
+			break
+		}
+		log.Printf("Telegram chat linked by %s", user)
+		reply = "Linked to " + user + ", your transcripts will be sent here. Send /stop to unlink."
+	case "/stop":
+		users, err := links.UnlinkChat(m.Chat.ID)
+		if err != nil {
+			log.Printf("Error unlinking Telegram chat: %v", err)
+		}
+		if len(users) == 0 {
+			reply = "This chat is not linked."
+			break
+		}
+		log.Printf("Telegram chat unlinked by %s", strings.Join(users, ", "))
+		reply = "Unlinked, transcripts will no longer be sent here."
+	default:
+		return
+	}
+	if err := bot.SendMessage(m.Chat.ID, reply); err != nil {
+		logSampler.Printf("telegram:reply", "Error replying to Telegram chat: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,168 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkCodeTTL is the time a user has to send the link code to the bot
+const linkCodeTTL = 10 * time.Minute
+
+// pendingLink is a link code waiting for the /start message of the user
+type pendingLink struct {
+	user    string
+	expires time.Time
+}
+
+// Links maps the users to the Telegram chats receiving their transcripts.
+// Users link their chat by sending a one time code to the bot, the links
+// are persisted in a JSON file
+type Links struct {
+	file    string
+	mu      sync.Mutex
+	chats   map[string]int64 // Linked through the bot
+	static  map[string]int64 // Configured by the operator
+	pending map[string]pendingLink
+}
+
+// ParseChats parses the chats configured by the operator, e.g.
+// "alice=123456789,bob=-1001234567890" (group ids are negative)
+func ParseChats(spec string) (map[string]int64, error) {
+	chats := make(map[string]int64)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid Telegram chat %q, expected user=chat_id", entry)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Telegram chat id %q", parts[1])
+		}
+		chats[strings.TrimSpace(parts[0])] = id
+	}
+	return chats, nil
+}
+
+// NewLinks creates a new Links with the static chats and loads the links
+// saved in file
+func NewLinks(file string, static map[string]int64) (*Links, error) {
+	l := &Links{
+		file:    file,
+		chats:   make(map[string]int64),
+		static:  static,
+		pending: make(map[string]pendingLink),
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.chats); err != nil {
+		return nil, fmt.Errorf("invalid Telegram links file %s: %w", file, err)
+	}
+	return l, nil
+}
+
+// Chat returns the chat of a user, a chat linked through the bot takes
+// precedence over the configured one
+func (l *Links) Chat(user string) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if id, ok := l.chats[user]; ok {
+		return id, true
+	}
+	id, ok := l.static[user]
+	return id, ok
+}
+
+// NewCode creates a link code for the user, it expires after linkCodeTTL
+func (l *Links) NewCode(user string) (string, time.Time) {
+	b := make([]byte, 12)
+	rand.Read(b)
+	code := hex.EncodeToString(b)
+	expires := time.Now().Add(linkCodeTTL)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for c, p := range l.pending {
+		if now.After(p.expires) || p.user == user {
+			delete(l.pending, c)
+		}
+	}
+	l.pending[code] = pendingLink{user: user, expires: expires}
+	return code, expires
+}
+
+// Redeem links the chat to the user of a valid code and returns the user
+func (l *Links) Redeem(code string, chatID int64) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.pending[code]
+	if !ok || time.Now().After(p.expires) {
+		return "", fmt.Errorf("invalid or expired link code")
+	}
+	delete(l.pending, code)
+	l.chats[p.user] = chatID
+	return p.user, l.save()
+}
+
+// Unlink removes the chat linked by a user, it returns false when the
+// user had no linked chat
+func (l *Links) Unlink(user string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.chats[user]; !ok {
+		return false, nil
+	}
+	delete(l.chats, user)
+	return true, l.save()
+}
+
+// UnlinkChat removes the links of a chat and returns the unlinked users
+func (l *Links) UnlinkChat(chatID int64) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var users []string
+	for user, id := range l.chats {
+		if id == chatID {
+			delete(l.chats, user)
+			users = append(users, user)
+		}
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return users, l.save()
+}
+
+// save writes the links to the file, the lock must be held
+func (l *Links) save() error {
+	data, err := json.MarshalIndent(l.chats, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.file), 0755); err != nil {
+		return fmt.Errorf("failed to save Telegram links: %w", err)
+	}
+	tmp := l.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save Telegram links: %w", err)
+	}
+	return os.Rename(tmp, l.file)
+}
@@ -0,0 +1,119 @@
+package telegram
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// maxMessageLength stays under the 4096 characters limit of a Telegram
+// message, longer transcripts are split
+const maxMessageLength = 4000
+
+// Sink sends the transcript of the completed sessions to the Telegram
+// chat linked by their user
+type Sink struct {
+	bot     *Bot
+	links   *Links
+	baseURL string
+}
+
+// NewSink creates a new events.Sink, baseURL is the public address of the
+// server used to link the transcripts, no link is sent when it is empty
+func NewSink(bot *Bot, links *Links, baseURL string) *Sink {
+	return &Sink{
+		bot:     bot,
+		links:   links,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Name identifies the sink in the logs and dead letters
+func (s *Sink) Name() string {
+	return "telegram"
+}
+
+// Send sends the transcript of a completed session to the chat of its
+// user, the sessions of users without chat are skipped
+func (s *Sink) Send(e events.Event) error {
+	session, ok := e.Completed()
+	if !ok || session.User == "" {
+		return nil
+	}
+	chatID, ok := s.links.Chat(session.User)
+	if !ok {
+		return nil
+	}
+
+	header := fmt.Sprintf("Transcript of %s (%s", session.StartedAt.Format("2006-01-02 15:04"), formatDuration(session.Duration))
+	if session.Language != "" && session.Language != "auto" {
+		header += ", " + session.Language
+	}
+	header += ")"
+	text := header + "\n\n" + strings.TrimSpace(session.Text)
+	if link := s.transcriptLink(session); link != "" {
+		text += "\n\n" + link
+	}
+	for _, part := range split(text, maxMessageLength) {
+		if err := s.bot.SendMessage(chatID, part); err != nil {
+			return deliveryError(err)
+		}
+	}
+	return nil
+}
+
+// transcriptLink returns the URL of the transcript, or of the recording
+// when the vendor did not write a text file
+func (s *Sink) transcriptLink(session events.Session) string {
+	file := session.TextFile
+	if file == "" {
+		file = session.AudioFile
+	}
+	if s.baseURL == "" || file == "" {
+		return ""
+	}
+	return s.baseURL + "/recordings/" + url.PathEscape(filepath.Base(file))
+}
+
+// split cuts the text in parts of at most max characters, at a line or
+// word boundary when possible
+func split(text string, max int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > max {
+		cut := lastIndex(runes[:max], '\n')
+		if cut < max/2 {
+			cut = lastIndex(runes[:max], ' ')
+		}
+		if cut < max/2 {
+			cut = max
+		}
+		parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+		runes = runes[cut:]
+	}
+	if rest := strings.TrimSpace(string(runes)); rest != "" {
+		parts = append(parts, rest)
+	}
+	return parts
+}
+
+func lastIndex(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
+}
@@ -0,0 +1,47 @@
+package tenant
+
+import (
+	"sync"
+
+	"github.com/walterfan/webrtc-transcriber/internal/catalog"
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// Catalogs holds the session catalog of each tenant directory, as an
+// events.Sink it records the sessions in the catalog of their user
+type Catalogs struct {
+	registry *Registry
+	mu       sync.Mutex
+	catalogs map[string]*catalog.Catalog
+}
+
+// NewCatalogs creates new Catalogs of the tenants of the registry
+func NewCatalogs(registry *Registry) *Catalogs {
+	return &Catalogs{
+		registry: registry,
+		catalogs: make(map[string]*catalog.Catalog),
+	}
+}
+
+// For returns the catalog of a tenant, "" for the default tenant
+func (c *Catalogs) For(id string) *catalog.Catalog {
+	dir := c.registry.TenantDir(id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cat, ok := c.catalogs[dir]; ok {
+		return cat
+	}
+	cat := catalog.New(dir)
+	c.catalogs[dir] = cat
+	return cat
+}
+
+// Name returns the name of the sink
+func (c *Catalogs) Name() string {
+	return "catalog"
+}
+
+// Send records a finished session in the catalog of its user
+func (c *Catalogs) Send(e events.Event) error {
+	return c.For(c.registry.Of(e.User)).Send(e)
+}
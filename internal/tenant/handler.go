@@ -0,0 +1,160 @@
+package tenant
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+)
+
+// info describes a tenant without its credentials
+type info struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name,omitempty"`
+	Vendor   string   `json:"vendor,omitempty"`
+	Language string   `json:"language,omitempty"`
+	Admin    bool     `json:"admin,omitempty"`
+	Admins   []string `json:"admins,omitempty"`
+	Members  []string `json:"members,omitempty"`
+}
+
+func (r *Registry) info(t *Tenant, usernames []string) info {
+	return info{
+		ID:       t.ID,
+		Name:     t.Name,
+		Vendor:   t.Vendor,
+		Language: t.Language,
+		Admins:   t.Admins,
+		Members:  r.Members(t.ID, usernames),
+	}
+}
+
+// Handler returns an HTTP handler passing the requests to the handler of
+// the tenant of the authenticated user, build is called once per tenant
+// and once for the default tenant ("")
+func (r *Registry) Handler(build func(id string) http.Handler) http.Handler {
+	handlers := map[string]http.Handler{"": build("")}
+	for id := range r.tenants {
+		handlers[id] = build(id)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handlers[r.Of(auth.UserFromContext(req.Context()))].ServeHTTP(w, req)
+	})
+}
+
+// FileServer returns an HTTP handler serving the files of a tenant, the
+// default tenant does not see the directories of the others. The dot files
+// and directories, e.g. the catalog and the state of the server, are never
+// served
+func (r *Registry) FileServer(id string) http.Handler {
+	files := http.FileServer(visibleFiles{http.Dir(r.TenantDir(id))})
+	if id != "" {
+		return files
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p := path.Clean("/" + req.URL.Path)
+		if p == "/"+tenantsDir || strings.HasPrefix(p, "/"+tenantsDir+"/") {
+			http.NotFound(w, req)
+			return
+		}
+		files.ServeHTTP(w, req)
+	})
+}
+
+// Hidden reports whether a file or a directory of its path starts with a
+// dot, those are not served to the users
+func Hidden(name string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleFiles is a file system refusing the hidden files and leaving them
+// out of the directory listings
+type visibleFiles struct {
+	http.FileSystem
+}
+
+func (fs visibleFiles) Open(name string) (http.File, error) {
+	if Hidden(name) {
+		return nil, os.ErrNotExist
+	}
+	file, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return visibleFile{file}, nil
+}
+
+type visibleFile struct {
+	http.File
+}
+
+func (f visibleFile) Readdir(n int) ([]os.FileInfo, error) {
+	files, err := f.File.Readdir(n)
+	visible := files[:0]
+	for _, file := range files {
+		if !Hidden(file.Name()) {
+			visible = append(visible, file)
+		}
+	}
+	return visible, err
+}
+
+// MakeHandler returns an HTTP handler describing the tenant of the
+// authenticated user, with its members when the user administers it. The
+// ID is empty in the default tenant
+func MakeHandler(r *Registry, usernames func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		user := auth.UserFromContext(req.Context())
+		t := r.Get(r.Of(user))
+		if t == nil {
+			writeJSON(w, info{})
+			return
+		}
+		i := info{ID: t.ID, Name: t.Name, Vendor: t.Vendor, Language: t.Language}
+		if r.IsAdmin(user) {
+			i = r.info(t, usernames())
+			i.Admin = true
+		}
+		writeJSON(w, i)
+	})
+}
+
+// MakeAdminHandler returns an HTTP handler listing the tenants with their
+// admins and members
+func MakeAdminHandler(r *Registry, usernames func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		names := usernames()
+		tenants := []info{}
+		for _, t := range r.List() {
+			tenants = append(tenants, r.info(t, names))
+		}
+		writeJSON(w, tenants)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,69 @@
+package tenant
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// VendorFunc creates the transcription service of a tenant
+type VendorFunc func(t *Tenant) (transcribe.Service, error)
+
+// Service is a transcribe.Service routing the streams of the users of a
+// tenant to the vendor of the tenant, the others to the default service
+type Service struct {
+	next     transcribe.Service
+	registry *Registry
+	vendor   VendorFunc
+
+	mu       sync.Mutex
+	services map[string]transcribe.Service
+}
+
+// NewService creates a new Service, the service of a tenant is created on
+// its first stream
+func NewService(next transcribe.Service, registry *Registry, vendor VendorFunc) *Service {
+	return &Service{
+		next:     next,
+		registry: registry,
+		vendor:   vendor,
+		services: make(map[string]transcribe.Service),
+	}
+}
+
+// CreateStream creates a new stream of the default service
+func (s *Service) CreateStream() (transcribe.Stream, error) {
+	return s.next.CreateStream()
+}
+
+// CreateStreamWithOptions creates a new stream with the service of the
+// tenant of the user, in the language of the tenant when none is given
+func (s *Service) CreateStreamWithOptions(opts transcribe.StreamOptions) (transcribe.Stream, error) {
+	t := s.registry.Get(s.registry.Of(opts.User))
+	if t == nil {
+		return s.next.CreateStreamWithOptions(opts)
+	}
+	service, err := s.service(t)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Language == "" && t.Language != "" {
+		opts.Language = t.Language
+	}
+	return service.CreateStreamWithOptions(opts)
+}
+
+func (s *Service) service(t *Tenant) (transcribe.Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if service, ok := s.services[t.ID]; ok {
+		return service, nil
+	}
+	service, err := s.vendor(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the transcription service of tenant %s: %w", t.ID, err)
+	}
+	s.services[t.ID] = service
+	return service, nil
+}
@@ -0,0 +1,161 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tenantsDir is the directory of the output directory holding the files
+// of the tenants, one subdirectory per tenant
+const tenantsDir = "tenants"
+
+// validID restricts the tenant IDs to names safe in paths and usernames
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Tenant is an organization sharing the deployment, its users are named
+// "<user>@<tenant id>"
+type Tenant struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Vendor   string `json:"vendor,omitempty"`   // --vendor when empty
+	Language string `json:"language,omitempty"` // Default language of the streams
+	// Credentials override the environment variables of the vendor, e.g.
+	// AZURE_SPEECH_KEY, GOOGLE_CREDENTIALS or WHISPER_PATH
+	Credentials map[string]string `json:"credentials,omitempty"`
+	Admins      []string          `json:"admins,omitempty"` // Users administering the tenant, without the @<tenant id>
+}
+
+// Registry holds the tenants of the deployment. The users without a known
+// tenant belong to the default tenant, which keeps the output directory
+type Registry struct {
+	outputDir string
+	tenants   map[string]*Tenant
+}
+
+// Load reads the JSON list of tenants of a config file and creates their
+// directories in the output directory, every user belongs to the default
+// tenant when file is empty
+func Load(file, outputDir string) (*Registry, error) {
+	r := &Registry{outputDir: outputDir, tenants: make(map[string]*Tenant)}
+	if file == "" {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", file, err)
+	}
+	for i, t := range tenants {
+		if !validID.MatchString(t.ID) {
+			return nil, fmt.Errorf("tenant %d has an invalid id %q (lowercase letters, digits, - and _)", i+1, t.ID)
+		}
+		if _, ok := r.tenants[t.ID]; ok {
+			return nil, fmt.Errorf("tenant %s is defined twice", t.ID)
+		}
+		if err := os.MkdirAll(r.TenantDir(t.ID), 0755); err != nil {
+			return nil, err
+		}
+		r.tenants[t.ID] = t
+	}
+	return r, nil
+}
+
+// Of returns the tenant ID of a user, empty for the default tenant. A
+// suffix that is not a tenant ID is part of the username (e.g. an email)
+func (r *Registry) Of(user string) string {
+	i := strings.LastIndex(user, "@")
+	if i < 0 {
+		return ""
+	}
+	if _, ok := r.tenants[user[i+1:]]; !ok {
+		return ""
+	}
+	return user[i+1:]
+}
+
+// Get returns a tenant, nil for the default tenant or an unknown ID
+func (r *Registry) Get(id string) *Tenant {
+	return r.tenants[id]
+}
+
+// List returns the tenants sorted by ID
+func (r *Registry) List() []*Tenant {
+	tenants := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+	return tenants
+}
+
+// Dir returns the directory of the files of the tenant of a user, the
+// output directory for the default tenant
+func (r *Registry) Dir(user string) string {
+	return r.TenantDir(r.Of(user))
+}
+
+// TenantDir returns the directory of the files of a tenant, the output
+// directory for the default tenant
+func (r *Registry) TenantDir(id string) string {
+	if id == "" {
+		return r.outputDir
+	}
+	return filepath.Join(r.outputDir, tenantsDir, id)
+}
+
+// IsAdmin reports whether the user administers its tenant
+func (r *Registry) IsAdmin(user string) bool {
+	t := r.Get(r.Of(user))
+	if t == nil {
+		return false
+	}
+	name := strings.TrimSuffix(user, "@"+t.ID)
+	for _, admin := range t.Admins {
+		if admin == name || admin == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled reports whether tenants are configured
+func (r *Registry) Enabled() bool {
+	return len(r.tenants) > 0
+}
+
+// Members returns the users of a tenant among the usernames
+func (r *Registry) Members(id string, usernames []string) []string {
+	members := []string{}
+	for _, user := range usernames {
+		if r.Of(user) == id {
+			members = append(members, user)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Visible reports whether the viewer may see what the owner created:
+// deployment admins see everything, the other users only what belongs to
+// their tenant, either their own, unowned ones in the default tenant, or
+// everything when they administer the tenant
+func (r *Registry) Visible(owner, viewer string, isAdmin func(string) bool) bool {
+	if isAdmin(viewer) {
+		return true
+	}
+	if r.Of(owner) != r.Of(viewer) {
+		return false
+	}
+	return owner == "" || owner == viewer || r.IsAdmin(viewer)
+}
@@ -0,0 +1,83 @@
+package transcribe
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// AGCTranscriber is the implementation of the transcribe.Service that
+// normalizes the loudness of the streams before the audio reaches the
+// service it wraps, so that the quiet speakers are transcribed and recorded
+// at the same level as the others
+type AGCTranscriber struct {
+	next    Service
+	target  float64
+	maxGain float64
+}
+
+// AGCStream implements the transcribe.Stream interface,
+// it writes the normalized audio to the stream it wraps
+type AGCStream struct {
+	next Stream
+
+	mu  sync.Mutex
+	agc *audio.AGC
+}
+
+// CreateStream creates a new transcription stream
+func (t *AGCTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new normalized stream of the wrapped
+// service
+func (t *AGCTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	next, err := t.next.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &AGCStream{
+		next: next,
+		agc:  audio.NewAGC(recordingFormat.SampleRate, t.target, t.maxGain),
+	}, nil
+}
+
+// Results returns the results of the wrapped stream
+func (as *AGCStream) Results() <-chan Result {
+	return as.next.Results()
+}
+
+// Write normalizes the audio and writes it to the wrapped stream
+func (as *AGCStream) Write(buffer []byte) (int, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if pcm := as.agc.Process(buffer); len(pcm) > 0 {
+		if _, err := as.next.Write(pcm); err != nil {
+			return 0, err
+		}
+	}
+	return len(buffer), nil
+}
+
+// Close closes the wrapped stream
+func (as *AGCStream) Close() error {
+	return as.next.Close()
+}
+
+// NewAGCTranscriber creates a new instance of the transcribe.Service that
+// normalizes the speech of the streams of next to targetDB (dBFS, e.g. -20),
+// amplifying it by up to maxGainDB. It returns next when targetDB is 0
+func NewAGCTranscriber(next Service, targetDB, maxGainDB float64) (Service, error) {
+	if targetDB == 0 {
+		return next, nil
+	}
+	if targetDB < -40 || targetDB > -3 {
+		return nil, fmt.Errorf("the target level must be between -40 and -3 dBFS, got %v", targetDB)
+	}
+	if maxGainDB < 0 || maxGainDB > 60 {
+		return nil, fmt.Errorf("the maximum gain must be between 0 and 60 dB, got %v", maxGainDB)
+	}
+	return &AGCTranscriber{next: next, target: targetDB, maxGain: maxGainDB}, nil
+}
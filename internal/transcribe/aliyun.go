@@ -0,0 +1,357 @@
+package transcribe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// aliyunSampleRate is the rate of the PCM sent to NLS, which accepts 8kHz and 16kHz only
+const aliyunSampleRate = 16000
+
+// aliyunTokenMargin is how long before its expiry the token is refreshed
+const aliyunTokenMargin = 10 * time.Minute
+
+// aliyunFlushTimeout bounds the wait for the last results once the stream is closed
+const aliyunFlushTimeout = 10 * time.Second
+
+// AliyunTranscriber is the implementation of the transcribe.Service,
+// using the realtime transcription of Alibaba Cloud Intelligent Speech (NLS)
+type AliyunTranscriber struct {
+	accessKeyID     string
+	accessKeySecret string
+	appKey          string
+	region          string
+	ctx             context.Context
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// AliyunStream implements the transcribe.Stream interface,
+// it streams the PCM resampled to 16kHz to one NLS connection
+type AliyunStream struct {
+	conn      *websocket.Conn
+	results   chan Result
+	ctx       context.Context
+	appKey    string
+	taskID    string
+	resampler *audio.Downsampler
+	mu        sync.Mutex // Serializes the writes to conn
+	closed    bool
+	done      chan struct{} // Closed when the listener returns
+}
+
+// aliyunHeader is the header of the NLS messages
+type aliyunHeader struct {
+	MessageID  string `json:"message_id"`
+	TaskID     string `json:"task_id"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	AppKey     string `json:"appkey,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	StatusText string `json:"status_text,omitempty"`
+}
+
+// aliyunEvent is a message of NLS to the client
+type aliyunEvent struct {
+	Header  aliyunHeader `json:"header"`
+	Payload struct {
+		Index      int     `json:"index"`
+		Result     string  `json:"result"`
+		Confidence float32 `json:"confidence"`
+		Words      []struct {
+			Text      string `json:"text"`
+			StartTime int64  `json:"startTime"` // Milliseconds
+			EndTime   int64  `json:"endTime"`
+		} `json:"words"`
+	} `json:"payload"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *AliyunTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream (the language is the one of the appkey)
+func (t *AliyunTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	token, err := t.getToken()
+	if err != nil {
+		return nil, err
+	}
+
+	wsURL := fmt.Sprintf("wss://nls-gateway-%s.aliyuncs.com/ws/v1", t.region)
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-NLS-Token": {token}})
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to Aliyun NLS: HTTP status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to Aliyun NLS: %w", err)
+	}
+
+	stream := &AliyunStream{
+		conn:      conn,
+		results:   make(chan Result, resultsBuffer),
+		ctx:       t.ctx,
+		appKey:    t.appKey,
+		taskID:    hexID(),
+		resampler: audio.NewDownsampler(48000, aliyunSampleRate),
+		done:      make(chan struct{}),
+	}
+	start := map[string]interface{}{
+		"header": stream.header("StartTranscription"),
+		"payload": map[string]interface{}{
+			"format":                            "pcm",
+			"sample_rate":                       aliyunSampleRate,
+			"enable_intermediate_result":        true,
+			"enable_punctuation_prediction":     true,
+			"enable_inverse_text_normalization": true,
+			"enable_words":                      true,
+		},
+	}
+	if err := conn.WriteJSON(start); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start the transcription: %w", err)
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// header returns the header of the message name of the stream
+func (as *AliyunStream) header(name string) aliyunHeader {
+	return aliyunHeader{
+		MessageID: hexID(),
+		TaskID:    as.taskID,
+		Namespace: "SpeechTranscriber",
+		Name:      name,
+		AppKey:    as.appKey,
+	}
+}
+
+// Results returns a channel that will receive the transcription results
+func (as *AliyunStream) Results() <-chan Result {
+	return as.results
+}
+
+// Write sends the PCM resampled to 16kHz to NLS
+func (as *AliyunStream) Write(buffer []byte) (int, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.closed {
+		return 0, fmt.Errorf("aliyun stream is closed")
+	}
+	pcm := as.resampler.Process(buffer)
+	if len(pcm) == 0 {
+		return len(buffer), nil
+	}
+	if err := as.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close stops the transcription, waits for the last results and closes the connection
+func (as *AliyunStream) Close() error {
+	as.mu.Lock()
+	if as.closed {
+		as.mu.Unlock()
+		return nil
+	}
+	as.closed = true
+	if err := as.conn.WriteJSON(map[string]interface{}{"header": as.header("StopTranscription")}); err != nil {
+		log.Printf("Warning: failed to stop the Aliyun transcription: %v", err)
+	}
+	as.mu.Unlock()
+
+	select {
+	case <-as.done:
+	case <-time.After(aliyunFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Aliyun results")
+	}
+	return as.conn.Close()
+}
+
+// listenForResults maps the NLS events to results until the transcription
+// completes or fails, then closes the results channel
+func (as *AliyunStream) listenForResults() {
+	defer close(as.done)
+	defer close(as.results)
+
+	for {
+		_, message, err := as.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Aliyun WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var event aliyunEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			logSampler.Printf("aliyun:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+
+		var result Result
+		switch event.Header.Name {
+		case "TranscriptionResultChanged":
+			result = Result{Text: event.Payload.Result, Confidence: 0.5}
+
+		case "SentenceEnd":
+			result = Result{Text: event.Payload.Result, Confidence: event.Payload.Confidence, Final: true}
+			for _, w := range event.Payload.Words {
+				result.Words = append(result.Words, Word{
+					Word:       w.Text,
+					Start:      float64(w.StartTime) / 1000,
+					End:        float64(w.EndTime) / 1000,
+					Confidence: event.Payload.Confidence,
+				})
+			}
+
+		case "TranscriptionCompleted":
+			return
+
+		case "TaskFailed":
+			log.Printf("Aliyun NLS error: %d %s", event.Header.Status, event.Header.StatusText)
+			return
+		}
+		if strings.TrimSpace(result.Text) == "" {
+			continue
+		}
+		if !sendResult(as.ctx, as.results, result, "aliyun") && as.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// getToken returns the NLS token, created again when it is about to expire
+func (t *AliyunTranscriber) getToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Add(aliyunTokenMargin).Before(t.tokenExpiry) {
+		return t.token, nil
+	}
+
+	token, expiry, err := t.createToken()
+	if err != nil {
+		return "", err
+	}
+	t.token, t.tokenExpiry = token, expiry
+	log.Printf("Aliyun NLS token refreshed, expires at %s", expiry.Format(time.RFC3339))
+	return token, nil
+}
+
+// createToken calls the CreateToken action of the NLS meta API, signed
+// with the access key
+func (t *AliyunTranscriber) createToken() (string, time.Time, error) {
+	params := map[string]string{
+		"AccessKeyId":      t.accessKeyID,
+		"Action":           "CreateToken",
+		"Format":           "JSON",
+		"RegionId":         t.region,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   hexID(),
+		"SignatureVersion": "1.0",
+		"Timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		"Version":          "2019-02-28",
+	}
+	query := aliyunCanonicalQuery(params)
+	mac := hmac.New(sha1.New, []byte(t.accessKeySecret+"&"))
+	mac.Write([]byte("GET&" + aliyunEncode("/") + "&" + aliyunEncode(query)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	tokenURL := fmt.Sprintf("https://nls-meta.%s.aliyuncs.com/?Signature=%s&%s", t.region, aliyunEncode(signature), query)
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ctx, cancel := context.WithTimeout(t.ctx, probeTimeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request the NLS token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		Token struct {
+			ID         string `json:"Id"`
+			ExpireTime int64  `json:"ExpireTime"`
+		} `json:"Token"`
+		Code    string `json:"Code"`
+		Message string `json:"Message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokenResp.Token.ID == "" {
+		return "", time.Time{}, fmt.Errorf("Aliyun API error: HTTP status %d %s - %s", resp.StatusCode, tokenResp.Code, tokenResp.Message)
+	}
+	return tokenResp.Token.ID, time.Unix(tokenResp.Token.ExpireTime, 0), nil
+}
+
+// aliyunCanonicalQuery returns the parameters sorted and encoded as signed by the POP API
+func aliyunCanonicalQuery(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = aliyunEncode(k) + "=" + aliyunEncode(params[k])
+	}
+	return strings.Join(pairs, "&")
+}
+
+// aliyunEncode percent-encodes s as required by the POP API signature
+func aliyunEncode(s string) string {
+	encoded := url.QueryEscape(s)
+	encoded = strings.Replace(encoded, "+", "%20", -1)
+	encoded = strings.Replace(encoded, "*", "%2A", -1)
+	return strings.Replace(encoded, "%7E", "~", -1)
+}
+
+// hexID returns a random 32 hexadecimal digits id, the format of the
+// message and task ids of NLS and of the voice ids of Tencent Cloud
+func hexID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// NewAliyunTranscriber creates a new instance of the transcribe.Service that uses
+// Alibaba Cloud NLS in region (cn-shanghai when empty)
+func NewAliyunTranscriber(ctx context.Context, accessKeyID, accessKeySecret, appKey, region string) (Service, error) {
+	if accessKeyID == "" || accessKeySecret == "" || appKey == "" {
+		return nil, fmt.Errorf("accessKeyID, accessKeySecret and appKey are required")
+	}
+	if region == "" {
+		region = "cn-shanghai"
+	}
+
+	return &AliyunTranscriber{
+		accessKeyID:     accessKeyID,
+		accessKeySecret: accessKeySecret,
+		appKey:          appKey,
+		region:          region,
+		ctx:             ctx,
+	}, nil
+}
@@ -1,58 +1,79 @@
 package transcribe
 
 import (
+	"bytes"
 	"context"
-	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
 )
 
+// azureTokenTTL is how long the tokens issued with the subscription key are
+// used, they expire after 10 minutes
+const azureTokenTTL = 9 * time.Minute
+
+// azureFlushTimeout bounds the wait for the end of the turn once the stream is closed
+const azureFlushTimeout = 10 * time.Second
+
+// azureTicks is the number of ticks of the offsets and durations in a second
+const azureTicks = 1e7
+
 // AzureTranscriber is the implementation of the transcribe.Service,
-// using Microsoft Azure Speech Service for speech recognition
+// using the Speech Service protocol (USP) of Microsoft Azure Speech over WebSocket
 type AzureTranscriber struct {
 	subscriptionKey string
-	region          string
+	endpoint        string // Conversation recognition WebSocket URL of the region
+	tokenEndpoint   string // Token issuing URL of the region
 	ctx             context.Context
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
 }
 
 // AzureStream implements the transcribe.Stream interface,
-// it handles the WebSocket connection to Azure Speech Service
+// it streams the PCM as the audio messages of one recognition turn
 type AzureStream struct {
-	conn    *websocket.Conn
-	results chan Result
-	ctx     context.Context
-}
-
-// Azure Speech Service message structures
-type azureSpeechConfig struct {
-	System struct {
-		Name    string `json:"name"`
-		Version string `json:"version"`
-	} `json:"system"`
+	conn      *websocket.Conn
+	results   chan Result
+	ctx       context.Context
+	requestID string
+	mu        sync.Mutex // Serializes the writes to conn
+	started   bool       // Whether the audio with the WAV header was sent
+	closed    bool
+	done      chan struct{} // Closed when the listener returns
 }
 
-type azureSpeechRequest struct {
-	Context azureSpeechConfig `json:"context"`
-	Audio   struct {
-		ContentType string `json:"contentType"`
-		Data        string `json:"data"`
-	} `json:"audio"`
+// azureHypothesis is the body of the speech.hypothesis messages
+type azureHypothesis struct {
+	Text   string `json:"Text"`
+	Offset int64  `json:"Offset"`
 }
 
-type azureSpeechResponse struct {
-	Type        string `json:"type"`
-	ID          string `json:"id"`
-	Timestamp   string `json:"timestamp"`
-	Recognition struct {
-		DisplayText string  `json:"displayText"`
-		Offset      int64   `json:"offset"`
-		Duration    int64   `json:"duration"`
-		Confidence  float64 `json:"confidence"`
-	} `json:"recognition"`
-	Status string `json:"status"`
+// azurePhrase is the body of the speech.phrase messages in the detailed format
+type azurePhrase struct {
+	RecognitionStatus string `json:"RecognitionStatus"`
+	DisplayText       string `json:"DisplayText"`
+	Offset            int64  `json:"Offset"`
+	NBest             []struct {
+		Confidence float32 `json:"Confidence"`
+		Display    string  `json:"Display"`
+		Words      []struct {
+			Word     string `json:"Word"`
+			Offset   int64  `json:"Offset"`
+			Duration int64  `json:"Duration"`
+		} `json:"Words"`
+	} `json:"NBest"`
 }
 
 // CreateStream creates a new transcription stream
@@ -60,173 +81,271 @@ func (a *AzureTranscriber) CreateStream() (Stream, error) {
 	return a.CreateStreamWithOptions(StreamOptions{})
 }
 
-// CreateStreamWithOptions creates a new transcription stream (options are ignored for Azure)
+// CreateStreamWithOptions creates a new transcription stream in the locale of the language
+// of the options, American English when it is empty or "auto"
 func (a *AzureTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
-	// Generate WebSocket URL for Azure Speech Service
-	wsURL := fmt.Sprintf("wss://%s.stt.speech.microsoft.com/speech/recognition/conversation/cognitiveservices/v1?api-version=2021-08-01-preview", a.region)
-
-	// Create WebSocket connection
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, map[string][]string{
-		"Ocp-Apim-Subscription-Key": {a.subscriptionKey},
-		"Content-Type":              {"application/json"},
-	})
+	token, err := a.getToken()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Azure Speech Service: %w", err)
+		return nil, err
 	}
 
-	// Send initial configuration
-	config := azureSpeechConfig{}
-	config.System.Name = "webrtc-transcriber"
-	config.System.Version = "1.0.0"
-
-	configMsg := map[string]interface{}{
-		"type":    "speech.config",
-		"context": config,
+	query := url.Values{}
+	query.Set("language", languageLocale(opts.Language))
+	query.Set("format", "detailed")
+	query.Set("wordLevelTimestamps", "true")
+	header := http.Header{
+		"Authorization":  {"Bearer " + token},
+		"X-ConnectionId": {hexID()},
 	}
-
-	configBytes, err := json.Marshal(configMsg)
+	conn, resp, err := websocket.DefaultDialer.Dial(a.endpoint+"?"+query.Encode(), header)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to marshal config: %w", err)
-	}
-
-	if err := conn.WriteMessage(websocket.TextMessage, configBytes); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to send config: %w", err)
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to Azure Speech Service: HTTP status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to Azure Speech Service: %w", err)
 	}
 
 	stream := &AzureStream{
-		conn:    conn,
-		results: make(chan Result, 10),
-		ctx:     a.ctx,
+		conn:      conn,
+		results:   make(chan Result, resultsBuffer),
+		ctx:       a.ctx,
+		requestID: hexID(),
+		done:      make(chan struct{}),
+	}
+	config := map[string]interface{}{
+		"context": map[string]interface{}{
+			"system": map[string]string{"name": "webrtc-transcriber", "version": "1.0.0", "build": "Go"},
+			"os":     map[string]string{"platform": "Linux", "name": "webrtc-transcriber", "version": "1.0.0"},
+		},
+	}
+	if err := stream.writeText("speech.config", config); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send speech.config: %w", err)
 	}
-
-	// Start listening for responses
 	go stream.listenForResults()
 
 	return stream, nil
 }
 
-// Results returns a channel that will receive the transcription results
-func (as *AzureStream) Results() <-chan Result {
-	return as.results
-}
-
-// Close sends an end-of-stream marker and closes the WebSocket connection
-func (as *AzureStream) Close() error {
-	// Send end-of-stream marker
-	endMsg := map[string]interface{}{
-		"type": "audio.end",
+// uspHeaders returns the headers of a message of the path in the turn
+func (as *AzureStream) uspHeaders(path, contentType string) string {
+	headers := "Path: " + path + "\r\n" +
+		"X-RequestId: " + as.requestID + "\r\n" +
+		"X-Timestamp: " + time.Now().UTC().Format("2006-01-02T15:04:05.000Z") + "\r\n"
+	if contentType != "" {
+		headers += "Content-Type: " + contentType + "\r\n"
 	}
+	return headers
+}
 
-	endBytes, err := json.Marshal(endMsg)
+// writeText sends the body as the JSON of a text message, the caller
+// serializes the writes
+func (as *AzureStream) writeText(path string, body interface{}) error {
+	data, err := json.Marshal(body)
 	if err != nil {
-		log.Printf("Warning: failed to marshal end message: %v", err)
-	} else {
-		if err := as.conn.WriteMessage(websocket.TextMessage, endBytes); err != nil {
-			log.Printf("Warning: failed to send end message: %v", err)
-		}
+		return err
 	}
+	message := as.uspHeaders(path, "application/json") + "\r\n" + string(data)
+	return as.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
 
-	// Close WebSocket connection
-	if err := as.conn.Close(); err != nil {
-		log.Printf("Warning: failed to close WebSocket: %v", err)
+// writeAudio sends the audio as a binary message, prefixed by the big-endian
+// size of its headers. The caller serializes the writes
+func (as *AzureStream) writeAudio(data []byte) error {
+	contentType := ""
+	if !as.started {
+		contentType = "audio/x-wav"
 	}
+	headers := as.uspHeaders("audio", contentType)
+	message := make([]byte, 2, 2+len(headers)+len(data))
+	binary.BigEndian.PutUint16(message, uint16(len(headers)))
+	message = append(message, headers...)
+	message = append(message, data...)
+	return as.conn.WriteMessage(websocket.BinaryMessage, message)
+}
 
-	// Close results channel
-	close(as.results)
-
-	return nil
+// Results returns a channel that will receive the transcription results
+func (as *AzureStream) Results() <-chan Result {
+	return as.results
 }
 
-// Write sends audio data to the Azure Speech Service
+// Write sends the PCM as audio messages, the first one carrying the WAV
+// header describing the format
 func (as *AzureStream) Write(buffer []byte) (int, error) {
-	// Encode audio data as base64
-	audioData := base64.StdEncoding.EncodeToString(buffer)
-
-	// Create speech request
-	request := azureSpeechRequest{}
-	request.Context.System.Name = "webrtc-transcriber"
-	request.Context.System.Version = "1.0.0"
-	request.Audio.ContentType = "audio/wav;codecs=audio/pcm;rate=48000"
-	request.Audio.Data = audioData
-
-	// Marshal request
-	requestBytes, err := json.Marshal(request)
-	if err != nil {
-		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.closed {
+		return 0, fmt.Errorf("azure stream is closed")
 	}
-
-	// Send audio data
-	if err := as.conn.WriteMessage(websocket.TextMessage, requestBytes); err != nil {
+	data := buffer
+	if !as.started {
+		data = append(wav.Header(recordingFormat, 0), buffer...)
+	}
+	if err := as.writeAudio(data); err != nil {
 		return 0, fmt.Errorf("failed to send audio data: %w", err)
 	}
-
+	as.started = true
 	return len(buffer), nil
 }
 
-// listenForResults listens for WebSocket messages and processes transcription results
+// Close sends the empty audio message ending the audio, waits for the end
+// of the turn and closes the connection
+func (as *AzureStream) Close() error {
+	as.mu.Lock()
+	if as.closed {
+		as.mu.Unlock()
+		return nil
+	}
+	as.closed = true
+	if err := as.writeAudio(nil); err != nil {
+		log.Printf("Warning: failed to end the Azure audio: %v", err)
+	}
+	as.mu.Unlock()
+
+	select {
+	case <-as.done:
+	case <-time.After(azureFlushTimeout):
+		log.Printf("Warning: timed out waiting for the end of the Azure turn")
+	}
+	return as.conn.Close()
+}
+
+// listenForResults maps the hypotheses and phrases of the turn to results
+// until turn.end, then closes the results channel
 func (as *AzureStream) listenForResults() {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in Azure stream listener: %v", r)
-		}
-	}()
+	defer close(as.done)
+	defer close(as.results)
 
 	for {
-		select {
-		case <-as.ctx.Done():
-			return
-		default:
-			// Read message
-			_, message, err := as.conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
-				}
-				return
+		_, message, err := as.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Azure WebSocket error: %v", err)
 			}
+			return
+		}
 
-			// Parse response
-			var response azureSpeechResponse
-			if err := json.Unmarshal(message, &response); err != nil {
-				log.Printf("Failed to unmarshal response: %v", err)
+		path, body := parseUSPMessage(message)
+		var result Result
+		switch path {
+		case "speech.hypothesis":
+			var hypothesis azureHypothesis
+			if err := json.Unmarshal(body, &hypothesis); err != nil {
+				logSampler.Printf("azure:unmarshal", "Failed to unmarshal response: %v", err)
 				continue
 			}
+			result = Result{Text: hypothesis.Text, Confidence: 0.5}
 
-			// Process different response types
-			switch response.Type {
-			case "recognition":
-				if response.Recognition.DisplayText != "" {
-					// Send result
-					result := Result{
-						Text:       response.Recognition.DisplayText,
-						Confidence: float32(response.Recognition.Confidence),
-						Final:      response.Status == "success",
-					}
-
-					select {
-					case as.results <- result:
-						// Result sent successfully
-					case <-as.ctx.Done():
-						return
-					default:
-						// Channel is full, skip this result
-						log.Printf("Results channel is full, skipping result")
-					}
+		case "speech.phrase":
+			var phrase azurePhrase
+			if err := json.Unmarshal(body, &phrase); err != nil {
+				logSampler.Printf("azure:unmarshal", "Failed to unmarshal response: %v", err)
+				continue
+			}
+			if phrase.RecognitionStatus != "Success" {
+				if phrase.RecognitionStatus != "InitialSilenceTimeout" && phrase.RecognitionStatus != "EndOfDictation" {
+					logSampler.Printf("azure:status", "Azure recognition status: %s", phrase.RecognitionStatus)
 				}
+				continue
+			}
+			result = phrase.result()
 
-			case "error":
-				log.Printf("Azure Speech Service error: %s", response.Status)
-
-			case "end":
-				log.Printf("Azure Speech Service stream ended")
-				return
+		case "turn.end":
+			as.mu.Lock()
+			telemetry := map[string]interface{}{"ReceivedMessages": map[string]interface{}{}, "Metrics": []interface{}{}}
+			if err := as.writeText("telemetry", telemetry); err != nil {
+				logSampler.Printf("azure:telemetry", "Failed to send telemetry: %v", err)
 			}
+			as.mu.Unlock()
+			return
+		}
+		if strings.TrimSpace(result.Text) == "" {
+			continue
+		}
+		if !sendResult(as.ctx, as.results, result, "azure") && as.ctx.Err() != nil {
+			return
 		}
 	}
 }
 
+// result maps the best alternative of a recognized phrase
+func (p *azurePhrase) result() Result {
+	result := Result{Text: p.DisplayText, Confidence: 0.9, Final: true}
+	if len(p.NBest) == 0 {
+		return result
+	}
+	best := p.NBest[0]
+	if best.Display != "" {
+		result.Text = best.Display
+	}
+	result.Confidence = best.Confidence
+	for _, w := range best.Words {
+		result.Words = append(result.Words, Word{
+			Word:       w.Word,
+			Start:      float64(w.Offset) / azureTicks,
+			End:        float64(w.Offset+w.Duration) / azureTicks,
+			Confidence: best.Confidence,
+		})
+	}
+	return result
+}
+
+// parseUSPMessage returns the path and the body of a text message of the
+// service, headers and body being separated by an empty line
+func parseUSPMessage(message []byte) (string, []byte) {
+	headers, body := message, []byte(nil)
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		headers, body = message[:i], message[i+4:]
+	}
+	for _, line := range strings.Split(string(headers), "\r\n") {
+		if i := strings.Index(line, ":"); i >= 0 && strings.EqualFold(strings.TrimSpace(line[:i]), "Path") {
+			return strings.TrimSpace(line[i+1:]), body
+		}
+	}
+	return "", body
+}
+
+// getToken returns a token issued with the subscription key, issued again
+// when it is about to expire
+func (a *AzureTranscriber) getToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Now().Before(a.tokenExpiry) {
+		return a.token, nil
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx, probeTimeout)
+	defer cancel()
+	token, err := a.issueToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.tokenExpiry = token, time.Now().Add(azureTokenTTL)
+	return token, nil
+}
+
+// issueToken exchanges the subscription key for a token
+func (a *AzureTranscriber) issueToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, a.tokenEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", a.subscriptionKey)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to request the Azure token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Azure token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned HTTP status %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
 // NewAzureTranscriber creates a new instance of the transcribe.Service that uses Azure Speech Service
 func NewAzureTranscriber(ctx context.Context, subscriptionKey, region string) (Service, error) {
 	if subscriptionKey == "" || region == "" {
@@ -235,7 +354,8 @@ func NewAzureTranscriber(ctx context.Context, subscriptionKey, region string) (S
 
 	return &AzureTranscriber{
 		subscriptionKey: subscriptionKey,
-		region:          region,
+		endpoint:        fmt.Sprintf("wss://%s.stt.speech.microsoft.com/speech/recognition/conversation/cognitiveservices/v1", region),
+		tokenEndpoint:   fmt.Sprintf("https://%s.api.cognitive.microsoft.com/sts/v1.0/issueToken", region),
 		ctx:             ctx,
 	}, nil
 }
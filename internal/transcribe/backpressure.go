@@ -0,0 +1,118 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policies of the results channels of the vendor streams when their reader
+// does not keep up
+const (
+	PolicyBlock      = "block"       // Wait for room up to the timeout, then drop the result
+	PolicyDropOldest = "drop-oldest" // Drop the oldest buffered result to make room
+	PolicyDropNewest = "drop-newest" // Drop the result that does not fit
+)
+
+// resultsBuffer is the number of results the vendor streams buffer
+const resultsBuffer = 10
+
+// Backpressure configures how the vendor streams deliver their results
+type Backpressure struct {
+	Policy  string
+	Timeout time.Duration // Wait of PolicyBlock, forever when not positive
+}
+
+var (
+	backpressureMu sync.RWMutex
+	backpressure   = Backpressure{Policy: PolicyBlock, Timeout: 5 * time.Second}
+	droppedResults int64
+)
+
+// Validate checks the policy
+func (b Backpressure) Validate() error {
+	switch b.Policy {
+	case PolicyBlock, PolicyDropOldest, PolicyDropNewest:
+		return nil
+	}
+	return fmt.Errorf("unknown results policy %q, expected %s, %s or %s", b.Policy, PolicyBlock, PolicyDropOldest, PolicyDropNewest)
+}
+
+// SetBackpressure changes the policy of the results channels of every
+// vendor, it applies to the results sent afterwards
+func SetBackpressure(b Backpressure) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	backpressureMu.Lock()
+	backpressure = b
+	backpressureMu.Unlock()
+	return nil
+}
+
+// DroppedResults returns the number of results the vendor streams dropped
+// since the start because their reader did not keep up
+func DroppedResults() int64 {
+	return atomic.LoadInt64(&droppedResults)
+}
+
+// sendResult delivers a result of a vendor stream with the backpressure
+// policy, it returns false when the result was dropped or ctx is done. The
+// transcripts are tagged with the vendor, their punctuation is restored
+// first when enabled for the vendor, then the processors are applied
+func sendResult(ctx context.Context, results chan Result, result Result, vendor string) bool {
+	if result.Vendor == "" && result.Code == "" {
+		result.Vendor = vendor
+	}
+	result = process(punctuate(result, vendor))
+	backpressureMu.RLock()
+	b := backpressure
+	backpressureMu.RUnlock()
+
+	select {
+	case results <- result:
+		return true
+	default:
+	}
+
+	switch b.Policy {
+	case PolicyDropOldest:
+		// The reader may empty the channel meanwhile, try again until the
+		// result fits
+		for {
+			select {
+			case results <- result:
+				return true
+			default:
+			}
+			select {
+			case <-results:
+				dropResult(vendor, "oldest")
+			default:
+			}
+		}
+	case PolicyBlock:
+		var timeout <-chan time.Time
+		if b.Timeout > 0 {
+			timer := time.NewTimer(b.Timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case results <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-timeout:
+		}
+	}
+	dropResult(vendor, "newest")
+	return false
+}
+
+func dropResult(vendor, which string) {
+	atomic.AddInt64(&droppedResults, 1)
+	logSampler.Printf(vendor+":results-full", "Results of %s are not read fast enough, dropping the %s result", vendor, which)
+}
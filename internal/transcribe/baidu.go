@@ -2,7 +2,7 @@ package transcribe
 
 import (
 	"context"
-	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -12,8 +12,13 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
 )
 
+// baiduSampleRate is the rate of the PCM of the Baidu real-time API
+const baiduSampleRate = 16000
+
 // BaiduTranscriber is the implementation of the transcribe.Service,
 // using Baidu Speech Recognition API for speech recognition
 type BaiduTranscriber struct {
@@ -24,11 +29,13 @@ type BaiduTranscriber struct {
 }
 
 // BaiduStream implements the transcribe.Stream interface,
-// it handles the WebSocket connection to Baidu Speech API
+// it streams the PCM resampled to 16kHz to the WebSocket connection of
+// Baidu Speech API
 type BaiduStream struct {
-	conn    *websocket.Conn
-	results chan Result
-	ctx     context.Context
+	conn      *websocket.Conn
+	results   chan Result
+	ctx       context.Context
+	resampler *audio.Downsampler
 }
 
 // Baidu Speech API message structures
@@ -76,9 +83,10 @@ func (b *BaiduTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream,
 	}
 
 	stream := &BaiduStream{
-		conn:    conn,
-		results: make(chan Result, 10),
-		ctx:     b.ctx,
+		conn:      conn,
+		results:   make(chan Result, resultsBuffer),
+		ctx:       b.ctx,
+		resampler: audio.NewDownsampler(recordingFormat.SampleRate, baiduSampleRate),
 	}
 
 	// Start listening for responses
@@ -119,10 +127,14 @@ func (bs *BaiduStream) Close() error {
 	return nil
 }
 
-// Write sends audio data to the Baidu Speech API
+// Write sends the PCM resampled to 16kHz to the Baidu Speech API
 func (bs *BaiduStream) Write(buffer []byte) (int, error) {
+	pcm := bs.resampler.Process(buffer)
+	if len(pcm) == 0 {
+		return len(buffer), nil
+	}
 	// Encode audio data as base64
-	audioData := fmt.Sprintf("%x", md5.Sum(buffer)) // Baidu expects hex format
+	audioData := base64.StdEncoding.EncodeToString(pcm)
 
 	// Create speech request
 	request := baiduSpeechRequest{
@@ -130,7 +142,7 @@ func (bs *BaiduStream) Write(buffer []byte) (int, error) {
 	}
 	request.Data.Audio = audioData
 	request.Data.Format = "pcm"
-	request.Data.Rate = 16000
+	request.Data.Rate = baiduSampleRate
 	request.Data.Channel = 1
 	request.Data.Cuid = "webrtc_transcriber"
 	request.Data.Token = ""    // Will be set by the API
@@ -175,7 +187,7 @@ func (bs *BaiduStream) listenForResults() {
 			// Parse response
 			var response baiduSpeechResponse
 			if err := json.Unmarshal(message, &response); err != nil {
-				log.Printf("Failed to unmarshal response: %v", err)
+				logSampler.Printf("baidu:unmarshal", "Failed to unmarshal response: %v", err)
 				continue
 			}
 
@@ -190,14 +202,8 @@ func (bs *BaiduStream) listenForResults() {
 						Final:      true,
 					}
 
-					select {
-					case bs.results <- result:
-						// Result sent successfully
-					case <-bs.ctx.Done():
+					if !sendResult(bs.ctx, bs.results, result, "baidu") && bs.ctx.Err() != nil {
 						return
-					default:
-						// Channel is full, skip this result
-						log.Printf("Results channel is full, skipping result")
 					}
 				}
 
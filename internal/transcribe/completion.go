@@ -0,0 +1,37 @@
+package transcribe
+
+import "sync"
+
+// CompletionClientLost is the completion of the streams finalized by the
+// server after their client disappeared without closing them
+const CompletionClientLost = "auto-completed (client lost)"
+
+// CompletionIdle is the completion of the streams finalized by the server
+// after their connection was idle, e.g. an abandoned tab with a muted
+// microphone
+const CompletionIdle = "auto-completed (idle)"
+
+// Completion records how a session ended when it did not end normally. It is
+// set by the ingestion of the stream and shared with the layers saving the
+// session through StreamOptions
+type Completion struct {
+	mu     sync.Mutex
+	reason string
+}
+
+// Set records the reason of the completion
+func (c *Completion) Set(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reason = reason
+}
+
+// Reason returns the reason of the completion, empty for a normal end
+func (c *Completion) Reason() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason
+}
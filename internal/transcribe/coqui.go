@@ -0,0 +1,142 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
+)
+
+// coquiFormat is the format of the audio of the Coqui STT models
+var coquiFormat = wav.Format{SampleRate: 16000, Channels: 1, BitsPerSample: 16}
+
+// CoquiTranscriber is the implementation of the transcribe.Service,
+// running the Coqui STT (DeepSpeech) command line client on the
+// recordings of the streams when they are closed, fully locally
+type CoquiTranscriber struct {
+	*WhisperTranscriber
+}
+
+// coquiConfig is the Coqui STT client a WhisperTranscriber runs instead of Whisper
+type coquiConfig struct {
+	sttPath    string
+	modelPath  string // Acoustic model, .tflite or .pbmm
+	scorerPath string // Optional language model
+}
+
+// runCoqui runs the stt client on the audio file resampled to 16kHz and
+// returns the path of the output like run. Coqui only outputs text, it has
+// no initial prompt
+func (w *WhisperTranscriber) runCoqui(ctx context.Context, audioPath, language, task, prompt, format string) (string, []byte, error) {
+	if format != "txt" {
+		return "", nil, fmt.Errorf("coqui stt does not output %s", format)
+	}
+	if task != TaskTranscribe {
+		return "", nil, fmt.Errorf("coqui stt does not %s", task)
+	}
+
+	resampled, err := w.resampleCoqui(audioPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(resampled)
+
+	args := []string{"--model", w.coqui.modelPath, "--audio", resampled}
+	if w.coqui.scorerPath != "" {
+		args = append(args, "--scorer", w.coqui.scorerPath)
+	}
+	cmd := exec.CommandContext(ctx, w.coqui.sttPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// The transcript is printed on stdout, the logs of the model on stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return "", stderr.Bytes(), fmt.Errorf("coqui stt execution failed: %w, output: %s", err, stderr.String())
+	}
+	return w.writeOutput(audioPath, format, []byte(strings.TrimSpace(string(content))+"\n"), stderr.Bytes())
+}
+
+// resampleCoqui writes the audio file resampled to 16kHz next to it and
+// returns its path
+func (w *WhisperTranscriber) resampleCoqui(audioPath string) (string, error) {
+	data, err := ioutil.ReadFile(audioPath)
+	if err != nil {
+		return "", err
+	}
+	format, _, err := wav.ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid recording %s: %w", audioPath, err)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))
+	path := filepath.Join(w.tempDir, base+"_16k.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create the resampled audio: %w", err)
+	}
+	writer, err := wav.NewWriter(file, coquiFormat)
+	if err == nil {
+		pcm := audio.NewDownsampler(format.SampleRate, coquiFormat.SampleRate).Process(data[wav.HeaderSize:])
+		_, err = writer.Write(pcm)
+	}
+	if err == nil {
+		err = writer.Finalize()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write the resampled audio: %w", err)
+	}
+	return path, nil
+}
+
+// NewCoquiTranscriber creates a new instance of the transcribe.Service that runs the
+// Coqui STT client sttPath ("stt" in PATH when empty) with the model and the optional
+// scorer. The streams are recorded in tempDir like the ones of Whisper, the language
+// is the one of the model
+func NewCoquiTranscriber(ctx context.Context, modelPath, scorerPath, sttPath, tempDir string, keepWav, keepTxt bool) (Service, error) {
+	if modelPath == "" {
+		return nil, fmt.Errorf("modelPath is required")
+	}
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("coqui model not found: %w", err)
+	}
+	if sttPath == "" {
+		path, err := exec.LookPath("stt")
+		if err != nil {
+			return nil, fmt.Errorf("coqui stt executable not found, please install it (pip install stt) or set COQUI_STT_PATH")
+		}
+		sttPath = path
+	}
+	if tempDir == "" {
+		tempDir = "./output"
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	log.Printf("Coqui STT transcriber initialized with model: %s, executable: %s", modelPath, sttPath)
+
+	return &CoquiTranscriber{WhisperTranscriber: &WhisperTranscriber{
+		coqui: &coquiConfig{
+			sttPath:    sttPath,
+			modelPath:  modelPath,
+			scorerPath: scorerPath,
+		},
+		tempDir:  tempDir,
+		language: "auto",
+		ctx:      ctx,
+		keepWav:  keepWav,
+		keepTxt:  keepTxt,
+	}}, nil
+}
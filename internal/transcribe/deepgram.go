@@ -0,0 +1,260 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// deepgramURL is the endpoint of Deepgram's realtime API
+const deepgramURL = "wss://api.deepgram.com/v1/listen"
+
+// deepgramDefaultModel is used when DEEPGRAM_MODEL is not set
+const deepgramDefaultModel = "nova-3"
+
+// deepgramFlushTimeout bounds the wait for the last results once the
+// stream is closed
+const deepgramFlushTimeout = 5 * time.Second
+
+// DeepgramTranscriber is the implementation of the transcribe.Service,
+// using Deepgram's realtime WebSocket API for speech recognition
+type DeepgramTranscriber struct {
+	apiKey string
+	model  string
+	ctx    context.Context
+}
+
+// DeepgramStream implements the transcribe.Stream interface,
+// it streams the raw PCM to one Deepgram WebSocket connection
+type DeepgramStream struct {
+	conn    *websocket.Conn
+	results chan Result
+	ctx     context.Context
+	mu      sync.Mutex // Serializes the writes to conn
+	closed  bool
+	done    chan struct{} // Closed when the listener returns
+}
+
+// deepgramResponse is a message of the realtime API, only the "Results"
+// messages carry a transcript
+type deepgramResponse struct {
+	Type    string `json:"type"`
+	IsFinal bool   `json:"is_final"`
+	Channel struct {
+		Alternatives []struct {
+			Transcript string  `json:"transcript"`
+			Confidence float32 `json:"confidence"`
+			Words      []struct {
+				Word           string  `json:"word"`
+				PunctuatedWord string  `json:"punctuated_word"`
+				Start          float64 `json:"start"`
+				End            float64 `json:"end"`
+				Confidence     float32 `json:"confidence"`
+				Speaker        *int    `json:"speaker"` // From 0, when diarized
+			} `json:"words"`
+			Languages []string `json:"languages"`
+		} `json:"alternatives"`
+		DetectedLanguage string `json:"detected_language"`
+	} `json:"channel"`
+	Description string `json:"description"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *DeepgramTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream in the language of the options
+func (t *DeepgramTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Token "+t.apiKey)
+	conn, resp, err := websocket.DefaultDialer.Dial(t.streamURL(opts), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to Deepgram: HTTP status %d %s", resp.StatusCode, resp.Header.Get("dg-error"))
+		}
+		return nil, fmt.Errorf("failed to connect to Deepgram: %w", err)
+	}
+
+	stream := &DeepgramStream{
+		conn:    conn,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		done:    make(chan struct{}),
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// streamURL returns the URL of the realtime API for the 48kHz mono PCM
+// of the sessions, Deepgram detects the language when it is "auto" and
+// numbers the speakers of the words of the diarized streams. The
+// deepgram.model and deepgram.tier vendor options override the defaults
+func (t *DeepgramTranscriber) streamURL(opts StreamOptions) string {
+	query := url.Values{}
+	query.Set("encoding", "linear16")
+	query.Set("sample_rate", "48000")
+	query.Set("channels", "1")
+	query.Set("model", opts.vendorOption("deepgram", "model", t.model))
+	if tier := opts.vendorOption("deepgram", "tier", ""); tier != "" {
+		query.Set("tier", tier)
+	}
+	query.Set("interim_results", "true")
+	query.Set("punctuate", "true")
+	query.Set("smart_format", "true")
+	if opts.Diarization {
+		query.Set("diarize", "true")
+	}
+	switch language := opts.Language; language {
+	case "", "auto":
+		query.Set("language", "multi")
+	default:
+		query.Set("language", language)
+	}
+	return deepgramURL + "?" + query.Encode()
+}
+
+// Results returns a channel that will receive the transcription results
+func (ds *DeepgramStream) Results() <-chan Result {
+	return ds.results
+}
+
+// Write sends the raw PCM to Deepgram
+func (ds *DeepgramStream) Write(buffer []byte) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return 0, fmt.Errorf("deepgram stream is closed")
+	}
+	if err := ds.conn.WriteMessage(websocket.BinaryMessage, buffer); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close asks Deepgram to flush the last results, waits for them and
+// closes the connection
+func (ds *DeepgramStream) Close() error {
+	ds.mu.Lock()
+	if ds.closed {
+		ds.mu.Unlock()
+		return nil
+	}
+	ds.closed = true
+	if err := ds.conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "CloseStream"}`)); err != nil {
+		log.Printf("Warning: failed to send CloseStream to Deepgram: %v", err)
+	}
+	ds.mu.Unlock()
+
+	select {
+	case <-ds.done:
+	case <-time.After(deepgramFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Deepgram results")
+	}
+	return ds.conn.Close()
+}
+
+// listenForResults maps the Deepgram messages to results until the
+// connection is closed, then closes the results channel
+func (ds *DeepgramStream) listenForResults() {
+	defer close(ds.done)
+	defer close(ds.results)
+
+	for {
+		_, message, err := ds.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Deepgram WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var response deepgramResponse
+		if err := json.Unmarshal(message, &response); err != nil {
+			logSampler.Printf("deepgram:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+
+		switch response.Type {
+		case "Results":
+			result, ok := response.result()
+			if !ok {
+				continue
+			}
+			if !sendResult(ds.ctx, ds.results, result, "deepgram") && ds.ctx.Err() != nil {
+				return
+			}
+
+		case "Error":
+			log.Printf("Deepgram error: %s", response.Description)
+		}
+	}
+}
+
+// result maps the best alternative of a "Results" message, false when
+// it has no transcript
+func (r *deepgramResponse) result() (Result, bool) {
+	if len(r.Channel.Alternatives) == 0 {
+		return Result{}, false
+	}
+	alternative := r.Channel.Alternatives[0]
+	text := strings.TrimSpace(alternative.Transcript)
+	if text == "" {
+		return Result{}, false
+	}
+
+	result := Result{
+		Text:       text,
+		Confidence: alternative.Confidence,
+		Final:      r.IsFinal,
+		Language:   r.Channel.DetectedLanguage,
+	}
+	if result.Language == "" && len(alternative.Languages) > 0 {
+		result.Language = alternative.Languages[0]
+	}
+	for _, w := range alternative.Words {
+		word := w.PunctuatedWord
+		if word == "" {
+			word = w.Word
+		}
+		var speaker string
+		if w.Speaker != nil {
+			speaker = fmt.Sprintf("S%d", *w.Speaker+1)
+		}
+		result.Words = append(result.Words, Word{
+			Word:       word,
+			Start:      w.Start,
+			End:        w.End,
+			Confidence: w.Confidence,
+			Speaker:    speaker,
+		})
+	}
+	result.Speaker = mainSpeaker(result.Words)
+	return result, true
+}
+
+// NewDeepgramTranscriber creates a new instance of the transcribe.Service that uses Deepgram,
+// with the default model when model is empty
+func NewDeepgramTranscriber(ctx context.Context, apiKey, model string) (Service, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("apiKey is required")
+	}
+	if model == "" {
+		model = deepgramDefaultModel
+	}
+
+	return &DeepgramTranscriber{
+		apiKey: apiKey,
+		model:  model,
+		ctx:    ctx,
+	}, nil
+}
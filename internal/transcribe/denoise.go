@@ -0,0 +1,97 @@
+package transcribe
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// DenoiseTranscriber is the implementation of the transcribe.Service that
+// attenuates the stationary noise of the streams of the users it is enabled
+// for, e.g. the fan and the hiss of laptop microphones, before the audio
+// reaches the service it wraps. The streams keep their duration
+type DenoiseTranscriber struct {
+	next      Service
+	reduction float64
+	enabled   func(user string) bool
+}
+
+// DenoiseStream implements the transcribe.Stream interface,
+// it writes the denoised audio to the stream it wraps
+type DenoiseStream struct {
+	next Stream
+
+	mu       sync.Mutex
+	denoiser *audio.Denoiser
+	closed   bool
+}
+
+// CreateStream creates a new transcription stream
+func (t *DenoiseTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new denoised stream, the streams of the
+// users not enabled are the ones of the wrapped service
+func (t *DenoiseTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	next, err := t.next.CreateStreamWithOptions(opts)
+	if err != nil || !t.enabled(opts.User) {
+		return next, err
+	}
+	return &DenoiseStream{
+		next:     next,
+		denoiser: audio.NewDenoiser(recordingFormat.SampleRate, t.reduction),
+	}, nil
+}
+
+// Results returns the results of the wrapped stream
+func (ds *DenoiseStream) Results() <-chan Result {
+	return ds.next.Results()
+}
+
+// Write denoises the audio and writes the completed frames to the wrapped
+// stream, about 10ms of audio is held back until the next write
+func (ds *DenoiseStream) Write(buffer []byte) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return 0, fmt.Errorf("stream is closed")
+	}
+	if pcm := ds.denoiser.Process(buffer); len(pcm) > 0 {
+		if _, err := ds.next.Write(pcm); err != nil {
+			return 0, err
+		}
+	}
+	return len(buffer), nil
+}
+
+// Close writes the audio held back and closes the wrapped stream
+func (ds *DenoiseStream) Close() error {
+	ds.mu.Lock()
+	if ds.closed {
+		ds.mu.Unlock()
+		return nil
+	}
+	ds.closed = true
+	pcm := ds.denoiser.Flush()
+	ds.mu.Unlock()
+
+	if len(pcm) > 0 {
+		if _, err := ds.next.Write(pcm); err != nil {
+			ds.next.Close()
+			return err
+		}
+	}
+	return ds.next.Close()
+}
+
+// NewDenoiseTranscriber creates a new instance of the transcribe.Service
+// that denoises the streams of next when enabled returns true for their
+// user, attenuating the noise by up to reductionDB
+func NewDenoiseTranscriber(next Service, reductionDB float64, enabled func(user string) bool) (Service, error) {
+	if reductionDB <= 0 || reductionDB > 60 {
+		return nil, fmt.Errorf("the noise reduction must be between 0 and 60 dB, got %v", reductionDB)
+	}
+	return &DenoiseTranscriber{next: next, reduction: reductionDB, enabled: enabled}, nil
+}
@@ -0,0 +1,133 @@
+package transcribe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DiarizationGate is the implementation of the transcribe.Service that
+// diarizes the streams of the users it is enabled for, e.g. by the
+// diarization feature flag, along with the ones asking for it
+type DiarizationGate struct {
+	next    Service
+	enabled func(user string) bool
+}
+
+// diarizationTurn is a turn of a speaker printed by the local diarizer
+type diarizationTurn struct {
+	Speaker string  `json:"speaker"`
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+}
+
+// CreateStream creates a new transcription stream
+func (g *DiarizationGate) CreateStream() (Stream, error) {
+	return g.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates the stream of the wrapped service, diarized
+// when the user is enabled
+func (g *DiarizationGate) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	if !opts.Diarization && g.enabled(opts.User) {
+		opts.Diarization = true
+	}
+	return g.next.CreateStreamWithOptions(opts)
+}
+
+// NewDiarizationGate creates a new instance of the transcribe.Service that diarizes
+// the streams of next for the users enabled
+func NewDiarizationGate(next Service, enabled func(user string) bool) Service {
+	return &DiarizationGate{next: next, enabled: enabled}
+}
+
+// SetDiarizer sets the command labelling the speakers of the words of the
+// diarized streams, run with sh -c on the recording in its
+// TRANSCRIBER_AUDIO_FILE environment variable. It prints a JSON turn per
+// line, e.g. {"speaker": "SPEAKER_00", "start": 0.5, "end": 3.2} in seconds
+func (w *WhisperTranscriber) SetDiarizer(command string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.diarizer = strings.TrimSpace(command)
+}
+
+// runDiarizer runs the diarizer command (with sh -c) on the audio file, in
+// its TRANSCRIBER_AUDIO_FILE environment variable, and returns the turns it
+// prints on its stdout, one JSON object per line
+func runDiarizer(ctx context.Context, command, audioPath string) ([]diarizationTurn, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), "TRANSCRIBER_AUDIO_FILE="+audioPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("diarizer failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var turns []diarizationTurn
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var turn diarizationTurn
+		if err := json.Unmarshal(line, &turn); err != nil {
+			return nil, fmt.Errorf("invalid diarizer output %q: %w", line, err)
+		}
+		if turn.Speaker != "" && turn.End > turn.Start {
+			turns = append(turns, turn)
+		}
+	}
+	return turns, nil
+}
+
+// labelSpeakers labels the words with the speaker of the turn overlapping
+// them the most, the words no turn overlaps keep no speaker
+func labelSpeakers(words []Word, turns []diarizationTurn) {
+	for i := range words {
+		var best float64
+		for _, turn := range turns {
+			overlap := minFloat(words[i].End, turn.End) - maxFloat(words[i].Start, turn.Start)
+			if overlap > best || (best == 0 && words[i].Start >= turn.Start && words[i].Start < turn.End) {
+				best = overlap
+				words[i].Speaker = turn.Speaker
+			}
+		}
+	}
+}
+
+// mainSpeaker returns the speaker of most of the words, empty when none has one
+func mainSpeaker(words []Word) string {
+	counts := make(map[string]int)
+	var speaker string
+	for _, w := range words {
+		if w.Speaker == "" {
+			continue
+		}
+		counts[w.Speaker]++
+		if counts[w.Speaker] > counts[speaker] {
+			speaker = w.Speaker
+		}
+	}
+	return speaker
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
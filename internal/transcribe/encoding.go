@@ -0,0 +1,167 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of the recordings, see NewEncodingTranscriber
+const (
+	OutputWAV  = "wav"
+	OutputMP3  = "mp3"
+	OutputFLAC = "flac"
+)
+
+// encoderArgs are the ffmpeg arguments of the formats, MP3 at a bitrate
+// transparent for speech and lossless FLAC
+var encoderArgs = map[string][]string{
+	OutputMP3:  {"-codec:a", "libmp3lame", "-b:a", "64k", "-f", "mp3"},
+	OutputFLAC: {"-codec:a", "flac", "-compression_level", "8", "-f", "flac"},
+}
+
+// EncodingTranscriber is the implementation of the transcribe.Service that
+// encodes the WAV recordings of the service it wraps to MP3 or FLAC once
+// they are finalized, before the results referencing them are sent. The WAV
+// files are removed, the results reference the encoded files
+type EncodingTranscriber struct {
+	next   Service
+	format string
+	ffmpeg string
+	ctx    context.Context
+}
+
+// EncodingStream implements the transcribe.Stream interface,
+// it encodes the recordings of the results of the stream it wraps
+type EncodingStream struct {
+	next        Stream
+	transcriber *EncodingTranscriber
+	results     chan Result
+
+	// Owned by the forwarder
+	encoded map[string]string // Encoded file of the WAV files
+}
+
+// CreateStream creates a new encoding stream
+func (t *EncodingTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new encoding stream of the wrapped service
+func (t *EncodingTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	next, err := t.next.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	es := &EncodingStream{
+		next:        next,
+		transcriber: t,
+		results:     make(chan Result, resultsBuffer),
+		encoded:     make(map[string]string),
+	}
+	go es.forwardResults()
+	return es, nil
+}
+
+func (es *EncodingStream) forwardResults() {
+	defer close(es.results)
+	for result := range es.next.Results() {
+		if result.AudioFile != "" {
+			encoded := es.encode(result.AudioFile)
+			if result.Code != "" {
+				// The server messages name the recording, e.g. "Recording saved: <file>"
+				result.Text = strings.Replace(result.Text, filepath.Base(result.AudioFile), filepath.Base(encoded), -1)
+			}
+			result.AudioFile = encoded
+		}
+		es.results <- result
+	}
+}
+
+// encode returns the encoded file of a recording, the recording itself when
+// it is not a WAV file, was removed or fails to be encoded
+func (es *EncodingStream) encode(audioFile string) string {
+	if encoded, ok := es.encoded[audioFile]; ok {
+		return encoded
+	}
+	encoded := audioFile
+	if strings.EqualFold(filepath.Ext(audioFile), ".wav") {
+		if _, err := os.Stat(audioFile); err == nil {
+			path, err := es.transcriber.encode(audioFile)
+			if err != nil {
+				log.Printf("Error encoding %s to %s: %v", audioFile, es.transcriber.format, err)
+			} else {
+				encoded = path
+			}
+		}
+	}
+	es.encoded[audioFile] = encoded
+	return encoded
+}
+
+// Results returns a channel that will receive the results referencing the
+// encoded recordings
+func (es *EncodingStream) Results() <-chan Result {
+	return es.results
+}
+
+// Write writes the audio to the wrapped stream
+func (es *EncodingStream) Write(buffer []byte) (int, error) {
+	return es.next.Write(buffer)
+}
+
+// Close closes the wrapped stream
+func (es *EncodingStream) Close() error {
+	return es.next.Close()
+}
+
+// encode writes the WAV file in the format next to it, with the same base
+// name, and removes it
+func (t *EncodingTranscriber) encode(audioFile string) (string, error) {
+	path := strings.TrimSuffix(audioFile, filepath.Ext(audioFile)) + "." + t.format
+	partial := path + ".part"
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", audioFile}
+	args = append(args, encoderArgs[t.format]...)
+	args = append(args, partial)
+
+	cmd := exec.CommandContext(t.ctx, t.ffmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(partial)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("ffmpeg failed: %s", msg)
+		}
+		return "", fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Remove(audioFile); err != nil {
+		log.Printf("Warning: failed to remove the encoded recording %s: %v", audioFile, err)
+	}
+	return path, nil
+}
+
+// NewEncodingTranscriber creates a new instance of the transcribe.Service
+// that encodes the WAV recordings of next to format, "mp3" or "flac", with
+// the ffmpeg executable. It returns next for "wav"
+func NewEncodingTranscriber(ctx context.Context, next Service, format, ffmpeg string) (Service, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" || format == OutputWAV {
+		return next, nil
+	}
+	if _, ok := encoderArgs[format]; !ok {
+		return nil, fmt.Errorf("unknown output format %q, expected %s, %s or %s", format, OutputWAV, OutputMP3, OutputFLAC)
+	}
+	if _, err := exec.LookPath(ffmpeg); err != nil {
+		return nil, fmt.Errorf("the %s output format needs ffmpeg: %w", format, err)
+	}
+	return &EncodingTranscriber{next: next, format: format, ffmpeg: ffmpeg, ctx: ctx}, nil
+}
@@ -0,0 +1,255 @@
+package transcribe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// execFlushTimeout bounds the wait for the plugin to exit once the audio ended
+const execFlushTimeout = 30 * time.Second
+
+// ExecTranscriber is the implementation of the transcribe.Service that runs
+// an external plugin per stream: the PCM is written to its stdin in frames
+// prefixed by their big-endian uint32 length, a frame of length 0 ending the
+// audio, and it prints a JSON result per line on its stdout
+type ExecTranscriber struct {
+	command string // Run with sh -c
+	ctx     context.Context
+}
+
+// ExecStream implements the transcribe.Stream interface,
+// it writes the audio to one process of the plugin
+type ExecStream struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	results chan Result
+	ctx     context.Context
+	locale  string
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{} // Closed when the listener returns
+	exited  chan struct{} // Closed when the process exited
+	err     error         // Exit error of the process, set before exited is closed
+}
+
+// execResult is a result printed by the plugin
+type execResult struct {
+	Text       string  `json:"text"`
+	Final      bool    `json:"final"`
+	Confidence float32 `json:"confidence"`
+	Language   string  `json:"language"`
+	Segment    int     `json:"segment"`
+	Words      []Word  `json:"words"`
+	Error      string  `json:"error"` // Reports a transcription error instead of a result
+}
+
+// CreateStream creates a new transcription stream
+func (t *ExecTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions starts a process of the plugin, the format of the audio
+// and the language of the options are in its TRANSCRIBER_* environment variables
+func (t *ExecTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	language := opts.Language
+	if language == "" {
+		language = "auto"
+	}
+	cmd := exec.Command("sh", "-c", t.command)
+	cmd.Env = append(os.Environ(),
+		"TRANSCRIBER_SAMPLE_RATE="+strconv.Itoa(recordingFormat.SampleRate),
+		"TRANSCRIBER_CHANNELS="+strconv.Itoa(recordingFormat.Channels),
+		"TRANSCRIBER_FORMAT=s16le",
+		"TRANSCRIBER_LANGUAGE="+language,
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the plugin stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the plugin stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the plugin stderr: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the plugin: %w", err)
+	}
+
+	stream := &ExecStream{
+		cmd:     cmd,
+		stdin:   stdin,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		locale:  StreamLocale(opts, opts.Language),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		logPluginOutput(stderr)
+	}()
+	go stream.listenForResults(stdout, logged)
+
+	return stream, nil
+}
+
+// logPluginOutput logs the lines the plugin prints on its stderr
+func logPluginOutput(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		logSampler.Printf("exec:stderr", "Plugin: %s", scanner.Text())
+	}
+}
+
+// Results returns a channel that will receive the transcription results
+func (es *ExecStream) Results() <-chan Result {
+	return es.results
+}
+
+// Write sends the PCM to the plugin as a frame
+func (es *ExecStream) Write(buffer []byte) (int, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.closed {
+		return 0, fmt.Errorf("exec stream is closed")
+	}
+	if len(buffer) == 0 {
+		// An empty frame would end the audio
+		return 0, nil
+	}
+	if err := es.writeFrame(buffer); err != nil {
+		return 0, fmt.Errorf("failed to send audio data to the plugin: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// writeFrame writes the data prefixed by its length, the caller holds mu
+func (es *ExecStream) writeFrame(data []byte) error {
+	frame := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	_, err := es.stdin.Write(append(frame, data...))
+	return err
+}
+
+// Close ends the audio with an empty frame and closes the stdin of the
+// plugin, then waits for its last results and its exit
+func (es *ExecStream) Close() error {
+	es.mu.Lock()
+	if es.closed {
+		es.mu.Unlock()
+		return nil
+	}
+	es.closed = true
+	if err := es.writeFrame(nil); err != nil {
+		log.Printf("Warning: failed to end the plugin audio: %v", err)
+	}
+	es.stdin.Close()
+	es.mu.Unlock()
+
+	select {
+	case <-es.exited:
+	case <-time.After(execFlushTimeout):
+		log.Printf("Warning: timed out waiting for the plugin to exit, killing it")
+		es.cmd.Process.Kill()
+		<-es.exited
+	}
+	<-es.done
+	if es.err != nil {
+		return fmt.Errorf("plugin failed: %w", es.err)
+	}
+	return nil
+}
+
+// listenForResults maps the JSON lines of the plugin to results until it
+// closes its stdout, then waits for its exit and closes the results channel.
+// Its stderr is logged until logged is closed
+func (es *ExecStream) listenForResults(stdout io.Reader, logged <-chan struct{}) {
+	defer close(es.done)
+	defer close(es.results)
+	defer func() {
+		// Wait closes the pipes, once they are read
+		<-logged
+		es.err = es.cmd.Wait()
+		close(es.exited)
+	}()
+
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var response execResult
+			if jsonErr := json.Unmarshal(line, &response); jsonErr != nil {
+				logSampler.Printf("exec:unmarshal", "Failed to unmarshal the plugin result: %v", jsonErr)
+			} else if result, ok := response.result(es.locale); ok {
+				if !sendResult(es.ctx, es.results, result, "exec") && es.ctx.Err() != nil {
+					es.cmd.Process.Kill()
+					io.Copy(ioutil.Discard, reader)
+					return
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Failed to read the plugin results: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// result maps the result of the plugin, false when it has no text
+func (r *execResult) result(locale string) (Result, bool) {
+	if r.Error != "" {
+		return ErrorResult(locale, fmt.Errorf("%s", r.Error)), true
+	}
+	if strings.TrimSpace(r.Text) == "" && r.Segment == 0 {
+		return Result{}, false
+	}
+	return Result{
+		Text:       strings.TrimSpace(r.Text),
+		Final:      r.Final,
+		Confidence: r.Confidence,
+		Language:   r.Language,
+		Segment:    r.Segment,
+		Words:      r.Words,
+	}, true
+}
+
+// Executable returns the command of the plugin
+func (t *ExecTranscriber) Executable() string {
+	return t.command
+}
+
+// NewExecTranscriber creates a new instance of the transcribe.Service that runs
+// the plugin command (with sh -c) for every stream
+func NewExecTranscriber(ctx context.Context, command string) (Service, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		return nil, fmt.Errorf("sh not found to run the plugin: %w", err)
+	}
+
+	log.Printf("Exec transcriber initialized with plugin: %s", command)
+	return &ExecTranscriber{
+		command: command,
+		ctx:     ctx,
+	}, nil
+}
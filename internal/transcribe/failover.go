@@ -0,0 +1,181 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// failoverReplay is the amount of the latest audio, 5s of the 48kHz 16-bit
+// mono PCM, written again to the next vendor when one fails mid-stream, the
+// results of the audio the failed vendor got last may never have come
+const failoverReplay = 5 * PCMBytesPerSecond
+
+// FailoverTranscriber is the implementation of the transcribe.Service that
+// transcribes with the first vendor of a chain, and goes on with the next
+// one when the creation of the stream or a write of the audio fails
+type FailoverTranscriber struct {
+	names    []string
+	services []Service
+}
+
+// FailoverStream implements the transcribe.Stream interface,
+// it writes the audio to the stream of the current vendor of the chain
+type FailoverStream struct {
+	transcriber *FailoverTranscriber
+	opts        StreamOptions
+	locale      string
+	results     chan Result
+	mu          sync.Mutex
+	current     int    // Index of the vendor of stream
+	stream      Stream // Stream of the current vendor
+	replay      []byte // Latest audio written, up to failoverReplay
+	closed      bool
+	forwarders  sync.WaitGroup // The forwarding of the results of the streams of the vendors
+}
+
+// CreateStream creates a new transcription stream
+func (t *FailoverTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates the stream of the first vendor of the chain
+// able to, it fails when every vendor fails
+func (t *FailoverTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	fs := &FailoverStream{
+		transcriber: t,
+		opts:        opts,
+		locale:      StreamLocale(opts, opts.Language),
+		results:     make(chan Result, resultsBuffer),
+		current:     -1,
+	}
+	if err := fs.next(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// next replaces the stream by the one of the next vendor of the chain able
+// to create it, the caller holds mu. The failed stream is closed in the
+// background, its last results are still forwarded
+func (fs *FailoverStream) next() error {
+	if fs.stream != nil {
+		go fs.stream.Close()
+		fs.stream = nil
+	}
+
+	lastErr := fmt.Errorf("no vendor left")
+	for fs.current+1 < len(fs.transcriber.services) {
+		fs.current++
+		name := fs.transcriber.names[fs.current]
+		stream, err := fs.transcriber.services[fs.current].CreateStreamWithOptions(fs.opts)
+		if err != nil {
+			log.Printf("Failover: failed to create the %s stream: %v", name, err)
+			lastErr = err
+			continue
+		}
+
+		fs.stream = stream
+		fs.forwarders.Add(1)
+		go func() {
+			defer fs.forwarders.Done()
+			for result := range stream.Results() {
+				fs.results <- result
+			}
+		}()
+		return nil
+	}
+	return fmt.Errorf("every vendor of %s failed, the last one with: %w", strings.Join(fs.transcriber.names, ">"), lastErr)
+}
+
+// Results returns a channel that will receive the results of the vendors
+func (fs *FailoverStream) Results() <-chan Result {
+	return fs.results
+}
+
+// Write writes the audio to the stream of the current vendor. When it
+// fails, the next vendor of the chain gets the latest audio again and goes
+// on, the write only fails when the chain is exhausted
+func (fs *FailoverStream) Write(buffer []byte) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return 0, fmt.Errorf("failover stream is closed")
+	}
+	if fs.stream == nil {
+		return 0, fmt.Errorf("every vendor of %s failed", strings.Join(fs.transcriber.names, ">"))
+	}
+
+	data := buffer
+	for {
+		_, err := fs.stream.Write(data)
+		if err == nil {
+			break
+		}
+		failed := fs.transcriber.names[fs.current]
+		log.Printf("Failover: %s failed to take the audio: %v", failed, err)
+		if err := fs.next(); err != nil {
+			return 0, err
+		}
+		name := fs.transcriber.names[fs.current]
+		log.Printf("Failover: going on with %s instead of %s", name, failed)
+		sendResult(context.Background(), fs.results, MessageResult(fs.locale, MsgVendorFailover, name), "failover")
+		data = append(fs.replay[:len(fs.replay):len(fs.replay)], buffer...)
+	}
+
+	fs.replay = append(fs.replay, buffer...)
+	if extra := len(fs.replay) - failoverReplay; extra > 0 {
+		fs.replay = append(fs.replay[:0], fs.replay[extra:]...)
+	}
+	return len(buffer), nil
+}
+
+// Close closes the stream of the current vendor and closes the results once
+// those of every vendor of the stream are forwarded
+func (fs *FailoverStream) Close() error {
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		return nil
+	}
+	fs.closed = true
+	stream := fs.stream
+	fs.mu.Unlock()
+
+	var err error
+	if stream != nil {
+		err = stream.Close()
+	}
+	go func() {
+		fs.forwarders.Wait()
+		close(fs.results)
+	}()
+	return err
+}
+
+// Vendors returns the names of the vendors of the chain, in order
+func (t *FailoverTranscriber) Vendors() []string {
+	return append([]string(nil), t.names...)
+}
+
+// Services returns the services of the chain, in the order of Vendors
+func (t *FailoverTranscriber) Services() []Service {
+	return append([]Service(nil), t.services...)
+}
+
+// NewFailoverTranscriber creates a new instance of the transcribe.Service
+// that transcribes with the first service of the chain able to, names are
+// the vendors of the services
+func NewFailoverTranscriber(names []string, services []Service) (Service, error) {
+	if len(services) < 2 || len(names) != len(services) {
+		return nil, fmt.Errorf("a failover chain needs two vendors or more, with their names")
+	}
+
+	log.Printf("Failover transcriber initialized with %s", strings.Join(names, " > "))
+	return &FailoverTranscriber{
+		names:    append([]string(nil), names...),
+		services: append([]Service(nil), services...),
+	}, nil
+}
@@ -0,0 +1,252 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpcASRMethod is the method of the contract of grpcasr.proto
+const grpcASRMethod = "/transcriber.asr.ExternalTranscriber/Transcribe"
+
+// grpcASRFlushTimeout bounds the wait for the last results once the stream is closed
+const grpcASRFlushTimeout = 30 * time.Second
+
+// GRPCTranscriber is the implementation of the transcribe.Service,
+// calling an external ASR service implementing grpcasr.proto
+type GRPCTranscriber struct {
+	conn   *grpc.ClientConn
+	server string
+	token  string // Sent as a bearer token when not empty
+	ctx    context.Context
+}
+
+// GRPCStream implements the transcribe.Stream interface,
+// it streams the PCM to one Transcribe call
+type GRPCStream struct {
+	stream  grpc.ClientStream
+	cancel  context.CancelFunc
+	results chan Result
+	ctx     context.Context
+	locale  string
+	mu      sync.Mutex // Serializes the sends
+	closed  bool
+	done    chan struct{} // Closed when the receiver returns
+	err     error         // Error ending the call, set before done is closed
+}
+
+// The messages of grpcasr.proto, encoded by golang/protobuf from the struct tags
+
+type grpcASRAudioChunk struct {
+	Audio      []byte `protobuf:"bytes,1,opt,name=audio,proto3"`
+	SampleRate int32  `protobuf:"varint,2,opt,name=sample_rate,json=sampleRate,proto3"`
+	Channels   int32  `protobuf:"varint,3,opt,name=channels,proto3"`
+	Language   string `protobuf:"bytes,4,opt,name=language,proto3"`
+}
+
+func (m *grpcASRAudioChunk) Reset()         { *m = grpcASRAudioChunk{} }
+func (m *grpcASRAudioChunk) String() string { return proto.CompactTextString(m) }
+func (*grpcASRAudioChunk) ProtoMessage()    {}
+
+type grpcASRWord struct {
+	Word       string  `protobuf:"bytes,1,opt,name=word,proto3"`
+	Start      float64 `protobuf:"fixed64,2,opt,name=start,proto3"`
+	End        float64 `protobuf:"fixed64,3,opt,name=end,proto3"`
+	Confidence float32 `protobuf:"fixed32,4,opt,name=confidence,proto3"`
+}
+
+func (m *grpcASRWord) Reset()         { *m = grpcASRWord{} }
+func (m *grpcASRWord) String() string { return proto.CompactTextString(m) }
+func (*grpcASRWord) ProtoMessage()    {}
+
+type grpcASRResult struct {
+	Text       string         `protobuf:"bytes,1,opt,name=text,proto3"`
+	Final      bool           `protobuf:"varint,2,opt,name=final,proto3"`
+	Confidence float32        `protobuf:"fixed32,3,opt,name=confidence,proto3"`
+	Language   string         `protobuf:"bytes,4,opt,name=language,proto3"`
+	Segment    int32          `protobuf:"varint,5,opt,name=segment,proto3"`
+	Words      []*grpcASRWord `protobuf:"bytes,6,rep,name=words,proto3"`
+	Error      string         `protobuf:"bytes,7,opt,name=error,proto3"`
+}
+
+func (m *grpcASRResult) Reset()         { *m = grpcASRResult{} }
+func (m *grpcASRResult) String() string { return proto.CompactTextString(m) }
+func (*grpcASRResult) ProtoMessage()    {}
+
+// CreateStream creates a new transcription stream
+func (t *GRPCTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions starts a Transcribe call, its first chunk carries the
+// format of the audio and the language of the options
+func (t *GRPCTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	if t.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+t.token)
+	}
+	desc := &grpc.StreamDesc{StreamName: "Transcribe", ServerStreams: true, ClientStreams: true}
+	stream, err := t.conn.NewStream(ctx, desc, grpcASRMethod)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start the transcription on %s: %w", t.server, err)
+	}
+
+	language := opts.Language
+	if language == "" {
+		language = "auto"
+	}
+	header := &grpcASRAudioChunk{
+		SampleRate: int32(recordingFormat.SampleRate),
+		Channels:   int32(recordingFormat.Channels),
+		Language:   language,
+	}
+	if err := stream.SendMsg(header); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to send the first chunk: %w", err)
+	}
+
+	gs := &GRPCStream{
+		stream:  stream,
+		cancel:  cancel,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		locale:  StreamLocale(opts, opts.Language),
+		done:    make(chan struct{}),
+	}
+	go gs.receiveResults()
+
+	return gs, nil
+}
+
+// Results returns a channel that will receive the transcription results
+func (gs *GRPCStream) Results() <-chan Result {
+	return gs.results
+}
+
+// Write sends the PCM as a chunk
+func (gs *GRPCStream) Write(buffer []byte) (int, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.closed {
+		return 0, fmt.Errorf("grpc stream is closed")
+	}
+	if err := gs.stream.SendMsg(&grpcASRAudioChunk{Audio: buffer}); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close ends the audio, waits for the last results and ends the call
+func (gs *GRPCStream) Close() error {
+	gs.mu.Lock()
+	if gs.closed {
+		gs.mu.Unlock()
+		return nil
+	}
+	gs.closed = true
+	if err := gs.stream.CloseSend(); err != nil {
+		log.Printf("Warning: failed to end the gRPC audio: %v", err)
+	}
+	gs.mu.Unlock()
+
+	select {
+	case <-gs.done:
+	case <-time.After(grpcASRFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last gRPC results")
+	}
+	gs.cancel()
+	return nil
+}
+
+// receiveResults maps the results of the service until the call ends, then
+// closes the results channel
+func (gs *GRPCStream) receiveResults() {
+	defer close(gs.done)
+	defer close(gs.results)
+
+	for {
+		var response grpcASRResult
+		if err := gs.stream.RecvMsg(&response); err != nil {
+			if err != io.EOF && gs.ctx.Err() == nil {
+				log.Printf("gRPC transcription error: %v", err)
+				gs.err = err
+			}
+			return
+		}
+
+		result, ok := response.result(gs.locale)
+		if !ok {
+			continue
+		}
+		if !sendResult(gs.ctx, gs.results, result, "grpc") && gs.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// result maps a result of the service, false when it has no text
+func (r *grpcASRResult) result(locale string) (Result, bool) {
+	if r.Error != "" {
+		return ErrorResult(locale, fmt.Errorf("%s", r.Error)), true
+	}
+	text := strings.TrimSpace(r.Text)
+	if text == "" && r.Segment == 0 {
+		return Result{}, false
+	}
+	result := Result{
+		Text:       text,
+		Final:      r.Final,
+		Confidence: r.Confidence,
+		Language:   r.Language,
+		Segment:    int(r.Segment),
+	}
+	for _, w := range r.Words {
+		result.Words = append(result.Words, Word{
+			Word:       w.Word,
+			Start:      w.Start,
+			End:        w.End,
+			Confidence: w.Confidence,
+		})
+	}
+	return result, true
+}
+
+// NewGRPCTranscriber creates a new instance of the transcribe.Service that calls the
+// external ASR service of server (host:port), over TLS when secure, with the bearer
+// token when it is not empty
+func NewGRPCTranscriber(ctx context.Context, server, token string, secure bool) (Service, error) {
+	if server == "" {
+		return nil, fmt.Errorf("server is required")
+	}
+
+	transport := grpc.WithInsecure()
+	if secure {
+		transport = grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, ""))
+	}
+	conn, err := grpc.Dial(server, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", server, err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Printf("gRPC transcriber initialized with server: %s", server)
+	return &GRPCTranscriber{
+		conn:   conn,
+		server: server,
+		token:  token,
+		ctx:    ctx,
+	}, nil
+}
@@ -4,25 +4,172 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
-	speech "cloud.google.com/go/speech/apiv1"
-	"google.golang.org/api/option"
-	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
 )
 
+// googleMethod is the streaming recognition method of Speech-to-Text v2
+const googleMethod = "/google.cloud.speech.v2.Speech/StreamingRecognize"
+
+// googleDefaultModel is used when GOOGLE_SPEECH_MODEL is not set
+const googleDefaultModel = "long"
+
+// googleLinear16 is the LINEAR16 value of the AudioEncoding enum of ExplicitDecodingConfig
+const googleLinear16 = 1
+
+// googleFlushTimeout bounds the wait for the last results once the stream is closed
+const googleFlushTimeout = 10 * time.Second
+
 // GoogleTranscriber is the implementation of the transcribe.Service,
-// hold a pointer to the Google Speech client
+// using the streaming recognition of Google Speech-to-Text v2 over gRPC
 type GoogleTranscriber struct {
-	speechClient *speech.Client
-	ctx          context.Context
+	conn        *grpc.ClientConn
+	credentials string // Path of the service account file
+	recognizer  string // Default recognizer of the project and location
+	model       string
+	ctx         context.Context
 }
 
 // GoogleTrStream implements the transcribe.Stream interface,
 // it should map one to one with the audio stream coming from the client
 type GoogleTrStream struct {
-	stream  speechpb.Speech_StreamingRecognizeClient
+	stream  grpc.ClientStream
+	cancel  context.CancelFunc
 	results chan Result
+	ctx     context.Context
+	mu      sync.Mutex // Serializes the sends
+	closed  bool
+	done    chan struct{} // Closed when the receiver returns
+}
+
+// The messages of google/cloud/speech/v2/cloud_speech.proto used, encoded by
+// golang/protobuf from the struct tags. The oneofs are encoded as their
+// optional fields
+
+type googleStreamingRecognizeRequest struct {
+	Recognizer      string                            `protobuf:"bytes,3,opt,name=recognizer,proto3"`
+	Audio           []byte                            `protobuf:"bytes,5,opt,name=audio,proto3"`
+	StreamingConfig *googleStreamingRecognitionConfig `protobuf:"bytes,6,opt,name=streaming_config,json=streamingConfig,proto3"`
+}
+
+func (m *googleStreamingRecognizeRequest) Reset()         { *m = googleStreamingRecognizeRequest{} }
+func (m *googleStreamingRecognizeRequest) String() string { return proto.CompactTextString(m) }
+func (*googleStreamingRecognizeRequest) ProtoMessage()    {}
+
+type googleStreamingRecognitionConfig struct {
+	Config            *googleRecognitionConfig            `protobuf:"bytes,1,opt,name=config,proto3"`
+	StreamingFeatures *googleStreamingRecognitionFeatures `protobuf:"bytes,2,opt,name=streaming_features,json=streamingFeatures,proto3"`
+}
+
+func (m *googleStreamingRecognitionConfig) Reset()         { *m = googleStreamingRecognitionConfig{} }
+func (m *googleStreamingRecognitionConfig) String() string { return proto.CompactTextString(m) }
+func (*googleStreamingRecognitionConfig) ProtoMessage()    {}
+
+type googleStreamingRecognitionFeatures struct {
+	InterimResults bool `protobuf:"varint,2,opt,name=interim_results,json=interimResults,proto3"`
+}
+
+func (m *googleStreamingRecognitionFeatures) Reset()         { *m = googleStreamingRecognitionFeatures{} }
+func (m *googleStreamingRecognitionFeatures) String() string { return proto.CompactTextString(m) }
+func (*googleStreamingRecognitionFeatures) ProtoMessage()    {}
+
+type googleRecognitionConfig struct {
+	Features               *googleRecognitionFeatures    `protobuf:"bytes,2,opt,name=features,proto3"`
+	ExplicitDecodingConfig *googleExplicitDecodingConfig `protobuf:"bytes,8,opt,name=explicit_decoding_config,json=explicitDecodingConfig,proto3"`
+	Model                  string                        `protobuf:"bytes,9,opt,name=model,proto3"`
+	LanguageCodes          []string                      `protobuf:"bytes,10,rep,name=language_codes,json=languageCodes,proto3"`
+}
+
+func (m *googleRecognitionConfig) Reset()         { *m = googleRecognitionConfig{} }
+func (m *googleRecognitionConfig) String() string { return proto.CompactTextString(m) }
+func (*googleRecognitionConfig) ProtoMessage()    {}
+
+type googleExplicitDecodingConfig struct {
+	Encoding          int32 `protobuf:"varint,1,opt,name=encoding,proto3"`
+	SampleRateHertz   int32 `protobuf:"varint,2,opt,name=sample_rate_hertz,json=sampleRateHertz,proto3"`
+	AudioChannelCount int32 `protobuf:"varint,3,opt,name=audio_channel_count,json=audioChannelCount,proto3"`
+}
+
+func (m *googleExplicitDecodingConfig) Reset()         { *m = googleExplicitDecodingConfig{} }
+func (m *googleExplicitDecodingConfig) String() string { return proto.CompactTextString(m) }
+func (*googleExplicitDecodingConfig) ProtoMessage()    {}
+
+type googleRecognitionFeatures struct {
+	EnableWordTimeOffsets      bool `protobuf:"varint,2,opt,name=enable_word_time_offsets,json=enableWordTimeOffsets,proto3"`
+	EnableWordConfidence       bool `protobuf:"varint,3,opt,name=enable_word_confidence,json=enableWordConfidence,proto3"`
+	EnableAutomaticPunctuation bool `protobuf:"varint,4,opt,name=enable_automatic_punctuation,json=enableAutomaticPunctuation,proto3"`
+}
+
+func (m *googleRecognitionFeatures) Reset()         { *m = googleRecognitionFeatures{} }
+func (m *googleRecognitionFeatures) String() string { return proto.CompactTextString(m) }
+func (*googleRecognitionFeatures) ProtoMessage()    {}
+
+type googleStreamingRecognizeResponse struct {
+	Results []*googleStreamingRecognitionResult `protobuf:"bytes,6,rep,name=results,proto3"`
+}
+
+func (m *googleStreamingRecognizeResponse) Reset()         { *m = googleStreamingRecognizeResponse{} }
+func (m *googleStreamingRecognizeResponse) String() string { return proto.CompactTextString(m) }
+func (*googleStreamingRecognizeResponse) ProtoMessage()    {}
+
+type googleStreamingRecognitionResult struct {
+	Alternatives []*googleAlternative `protobuf:"bytes,1,rep,name=alternatives,proto3"`
+	IsFinal      bool                 `protobuf:"varint,2,opt,name=is_final,json=isFinal,proto3"`
+	Stability    float32              `protobuf:"fixed32,3,opt,name=stability,proto3"`
+	LanguageCode string               `protobuf:"bytes,6,opt,name=language_code,json=languageCode,proto3"`
+}
+
+func (m *googleStreamingRecognitionResult) Reset()         { *m = googleStreamingRecognitionResult{} }
+func (m *googleStreamingRecognitionResult) String() string { return proto.CompactTextString(m) }
+func (*googleStreamingRecognitionResult) ProtoMessage()    {}
+
+type googleAlternative struct {
+	Transcript string            `protobuf:"bytes,1,opt,name=transcript,proto3"`
+	Confidence float32           `protobuf:"fixed32,2,opt,name=confidence,proto3"`
+	Words      []*googleWordInfo `protobuf:"bytes,3,rep,name=words,proto3"`
+}
+
+func (m *googleAlternative) Reset()         { *m = googleAlternative{} }
+func (m *googleAlternative) String() string { return proto.CompactTextString(m) }
+func (*googleAlternative) ProtoMessage()    {}
+
+type googleWordInfo struct {
+	StartOffset *googleDuration `protobuf:"bytes,1,opt,name=start_offset,json=startOffset,proto3"`
+	EndOffset   *googleDuration `protobuf:"bytes,2,opt,name=end_offset,json=endOffset,proto3"`
+	Word        string          `protobuf:"bytes,3,opt,name=word,proto3"`
+	Confidence  float32         `protobuf:"fixed32,4,opt,name=confidence,proto3"`
+}
+
+func (m *googleWordInfo) Reset()         { *m = googleWordInfo{} }
+func (m *googleWordInfo) String() string { return proto.CompactTextString(m) }
+func (*googleWordInfo) ProtoMessage()    {}
+
+// googleDuration is google.protobuf.Duration
+type googleDuration struct {
+	Seconds int64 `protobuf:"varint,1,opt,name=seconds,proto3"`
+	Nanos   int32 `protobuf:"varint,2,opt,name=nanos,proto3"`
+}
+
+func (m *googleDuration) Reset()         { *m = googleDuration{} }
+func (m *googleDuration) String() string { return proto.CompactTextString(m) }
+func (*googleDuration) ProtoMessage()    {}
+
+// seconds returns the duration in seconds, 0 when it is not set
+func (d *googleDuration) seconds() float64 {
+	if d == nil {
+		return 0
+	}
+	return float64(d.Seconds) + float64(d.Nanos)/1e9
 }
 
 // CreateStream creates a new transcription stream
@@ -30,33 +177,54 @@ func (t *GoogleTranscriber) CreateStream() (Stream, error) {
 	return t.CreateStreamWithOptions(StreamOptions{})
 }
 
-// CreateStreamWithOptions creates a new transcription stream (options are ignored for Google Speech)
+// CreateStreamWithOptions creates a new transcription stream in the language of the options,
+// American English when it is empty or "auto"
 func (t *GoogleTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
-	stream, err := t.speechClient.StreamingRecognize(t.ctx)
+	// The recognizer routes the call to its location
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(t.ctx, "x-goog-request-params", "recognizer="+t.recognizer))
+	desc := &grpc.StreamDesc{StreamName: "StreamingRecognize", ServerStreams: true, ClientStreams: true}
+	stream, err := t.conn.NewStream(ctx, desc, googleMethod)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	// Send the initial configuration message.
-	if err := stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
-			StreamingConfig: &speechpb.StreamingRecognitionConfig{
-				Config: &speechpb.RecognitionConfig{
-					Encoding:          speechpb.RecognitionConfig_LINEAR16,
+	config := &googleStreamingRecognizeRequest{
+		Recognizer: t.recognizer,
+		StreamingConfig: &googleStreamingRecognitionConfig{
+			Config: &googleRecognitionConfig{
+				ExplicitDecodingConfig: &googleExplicitDecodingConfig{
+					Encoding:          googleLinear16,
 					SampleRateHertz:   48000,
-					LanguageCode:      "en-US",
 					AudioChannelCount: 1,
 				},
+				Features: &googleRecognitionFeatures{
+					EnableWordTimeOffsets:      true,
+					EnableWordConfidence:       true,
+					EnableAutomaticPunctuation: true,
+				},
+				Model:         opts.vendorOption("google", "model", t.model),
+				LanguageCodes: []string{languageLocale(opts.Language)},
 			},
+			StreamingFeatures: &googleStreamingRecognitionFeatures{InterimResults: true},
 		},
-	}); err != nil {
+	}
+	if err := stream.SendMsg(config); err != nil {
+		cancel()
 		return nil, err
 	}
 
-	return &GoogleTrStream{
+	st := &GoogleTrStream{
 		stream:  stream,
-		results: make(chan Result),
-	}, nil
+		cancel:  cancel,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		done:    make(chan struct{}),
+	}
+	go st.receiveResults()
+
+	return st, nil
 }
 
 // Results returns a channel that will receive the transcription
@@ -65,62 +233,123 @@ func (st *GoogleTrStream) Results() <-chan Result {
 	return st.results
 }
 
-// Close flushes the recognition stream and
-// pipes the results to the channel
+// Close ends the audio, waits for the last results and ends the call
 func (st *GoogleTrStream) Close() error {
-	if err := st.stream.CloseSend(); err != nil {
-		return err
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
 	}
-	resp, err := st.stream.Recv()
-	if err != nil && err != io.EOF {
-		return err
+	st.closed = true
+	err := st.stream.CloseSend()
+	st.mu.Unlock()
+
+	select {
+	case <-st.done:
+	case <-time.After(googleFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Google Speech results")
 	}
-	if resp == nil {
-		close(st.results)
-		return nil
+	st.cancel()
+	return err
+}
+
+func (st *GoogleTrStream) Write(buffer []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return 0, fmt.Errorf("google stream is closed")
 	}
-	if resp.Error != nil {
-		return fmt.Errorf("(Code: %d) %s", resp.Error.GetCode(), resp.Error.GetMessage())
+	if err := st.stream.SendMsg(&googleStreamingRecognizeRequest{Audio: buffer}); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
 	}
+	return len(buffer), nil
+}
 
-	// This needs to be a Goroutine because our caller may read the results chan
-	// after calling this method.
-	go func() {
-		for _, result := range resp.GetResults() {
-			for _, alt := range result.GetAlternatives() {
-				log.Printf("%s (%.2f)", alt.GetTranscript(), alt.GetConfidence())
-				st.results <- Result{
-					Confidence: alt.GetConfidence(),
-					Text:       alt.GetTranscript(),
-					Final:      result.GetIsFinal(),
-				}
+// receiveResults maps the responses to results until the call ends, then
+// closes the results channel. The interim results are not final
+func (st *GoogleTrStream) receiveResults() {
+	defer close(st.done)
+	defer close(st.results)
+
+	for {
+		var response googleStreamingRecognizeResponse
+		if err := st.stream.RecvMsg(&response); err != nil {
+			if err != io.EOF && st.ctx.Err() == nil {
+				log.Printf("Google Speech recognition error: %v", err)
 			}
+			return
 		}
-		close(st.results)
-	}()
-	return nil
-}
 
-func (st *GoogleTrStream) Write(buffer []byte) (int, error) {
-	if err := st.stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-			AudioContent: buffer,
-		},
-	}); err != nil {
-		return 0, nil
+		for _, r := range response.Results {
+			if len(r.Alternatives) == 0 {
+				continue
+			}
+			alternative := r.Alternatives[0]
+			text := strings.TrimSpace(alternative.Transcript)
+			if text == "" {
+				continue
+			}
+			result := Result{Text: text, Confidence: r.Stability, Final: r.IsFinal, Language: baseLanguage(r.LanguageCode)}
+			if r.IsFinal {
+				result.Confidence = alternative.Confidence
+				for _, w := range alternative.Words {
+					result.Words = append(result.Words, Word{
+						Word:       w.Word,
+						Start:      w.StartOffset.seconds(),
+						End:        w.EndOffset.seconds(),
+						Confidence: w.Confidence,
+					})
+				}
+			}
+			if !sendResult(st.ctx, st.results, result, "google") && st.ctx.Err() != nil {
+				return
+			}
+		}
 	}
-	return len(buffer), nil
 }
 
 // NewGoogleSpeech creates a new intances of the transcribe.Service that uses
-// Google Speech
-func NewGoogleSpeech(ctx context.Context, credentials string) (Service, error) {
-	speechClient, err := speech.NewClient(ctx, option.WithCredentialsFile(credentials))
+// Google Speech-to-Text v2 with the default recognizer of the project of the
+// service account in location (global when empty) and the model (long when empty)
+func NewGoogleSpeech(ctx context.Context, credentialsFile, location, model string) (Service, error) {
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials file: %w", err)
+	}
+	if creds.ProjectID == "" {
+		return nil, fmt.Errorf("no project_id in %s", credentialsFile)
+	}
+	if location == "" {
+		location = "global"
+	}
+	if model == "" {
+		model = googleDefaultModel
+	}
+
+	endpoint := "speech.googleapis.com:443"
+	if location != "global" {
+		endpoint = location + "-speech.googleapis.com:443"
+	}
+	conn, err := grpc.Dial(endpoint,
+		grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")),
+		grpc.WithPerRPCCredentials(oauth.TokenSource{TokenSource: creds.TokenSource}))
 	if err != nil {
 		return nil, err
 	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
 	return &GoogleTranscriber{
-		speechClient: speechClient,
-		ctx:          ctx,
+		conn:        conn,
+		credentials: credentialsFile,
+		recognizer:  fmt.Sprintf("projects/%s/locations/%s/recognizers/_", creds.ProjectID, location),
+		model:       model,
+		ctx:         ctx,
 	}, nil
 }
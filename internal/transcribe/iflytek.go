@@ -34,6 +34,7 @@ type IflyTekStream struct {
 	results     chan Result
 	ctx         context.Context
 	transcriber *IflyTekTranscriber
+	business    XunfeiBusiness // Parameters repeated in every frame
 }
 
 // Xunfei API request/response structures
@@ -50,6 +51,7 @@ type XunfeiCommon struct {
 type XunfeiBusiness struct {
 	Language string `json:"language"`
 	Domain   string `json:"domain"`
+	Accent   string `json:"accent,omitempty"`
 	VAD      int    `json:"vad_eos"`
 	// Removed unsupported fields: Format, SampleRate, Channel, Punctuation, DynamicCorrection
 }
@@ -81,7 +83,8 @@ func (t *IflyTekTranscriber) CreateStream() (Stream, error) {
 	return t.CreateStreamWithOptions(StreamOptions{})
 }
 
-// CreateStreamWithOptions creates a new transcription stream (options are ignored for IflyTek)
+// CreateStreamWithOptions creates a new transcription stream, only the
+// xunfei.domain and xunfei.accent vendor options are used
 func (t *IflyTekTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
 	// Generate authentication URL
 	authURL, err := t.generateAuthURL()
@@ -117,7 +120,8 @@ func (t *IflyTekTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream
 		},
 		Business: XunfeiBusiness{
 			Language: "zh_cn", // Chinese by default
-			Domain:   "iat",
+			Domain:   opts.vendorOption("xunfei", "domain", "iat"),
+			Accent:   opts.vendorOption("xunfei", "accent", ""),
 			VAD:      3000, // Voice activity detection end-of-speech timeout
 		},
 		Data: XunfeiData{
@@ -145,9 +149,10 @@ func (t *IflyTekTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream
 
 	stream := &IflyTekStream{
 		conn:        conn,
-		results:     make(chan Result),
+		results:     make(chan Result, resultsBuffer),
 		ctx:         t.ctx,
 		transcriber: t,
+		business:    config.Business,
 	}
 
 	// Start listening for responses in background
@@ -177,12 +182,8 @@ func (st *IflyTekStream) Close() error {
 		Common: XunfeiCommon{
 			AppID: st.transcriber.appID, // Use the actual AppID from the transcriber
 		},
-		Business: XunfeiBusiness{
-			Language: "zh_cn",
-			Domain:   "iat",
-			VAD:      3000,
-		},
-		Data: endData,
+		Business: st.business,
+		Data:     endData,
 	}
 
 	endBytes, err := json.Marshal(endRequest)
@@ -213,12 +214,8 @@ func (st *IflyTekStream) Write(buffer []byte) (int, error) {
 		Common: XunfeiCommon{
 			AppID: st.transcriber.appID, // Use the actual AppID from the transcriber
 		},
-		Business: XunfeiBusiness{
-			Language: "zh_cn",
-			Domain:   "iat",
-			VAD:      3000,
-		},
-		Data: audioData,
+		Business: st.business,
+		Data:     audioData,
 	}
 
 	requestBytes, err := json.Marshal(request)
@@ -256,13 +253,13 @@ func (st *IflyTekStream) listenForResults() {
 
 			var response XunfeiResponse
 			if err := json.Unmarshal(message, &response); err != nil {
-				log.Printf("Failed to unmarshal response: %v", err)
+				logSampler.Printf("xunfei:unmarshal", "Failed to unmarshal response: %v", err)
 				continue
 			}
 
 			// Check for errors
 			if response.Code != 0 {
-				log.Printf("Xunfei API error: %s", response.Message)
+				logSampler.Printf("xunfei:api-error", "Xunfei API error: %s", response.Message)
 				continue
 			}
 
@@ -276,11 +273,11 @@ func (st *IflyTekStream) listenForResults() {
 				}
 
 				if text != "" {
-					st.results <- Result{
+					sendResult(st.ctx, st.results, Result{
 						Text:       text,
 						Confidence: 0.9, // Xunfei doesn't provide confidence scores in this format
 						Final:      true,
-					}
+					}, "xunfei")
 				}
 			} else if response.Data.Status == 1 { // Partial result
 				text := ""
@@ -291,11 +288,11 @@ func (st *IflyTekStream) listenForResults() {
 				}
 
 				if text != "" {
-					st.results <- Result{
+					sendResult(st.ctx, st.results, Result{
 						Text:       text,
 						Confidence: 0.8, // Partial results have lower confidence
 						Final:      false,
-					}
+					}, "xunfei")
 				}
 			}
 		}
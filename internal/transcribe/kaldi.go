@@ -0,0 +1,244 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// kaldiFlushTimeout bounds the wait for the last results once the stream is closed
+const kaldiFlushTimeout = 10 * time.Second
+
+// kaldiStatuses describes the non-zero statuses of kaldi-gstreamer-server
+var kaldiStatuses = map[int]string{
+	1: "no speech",
+	2: "aborted",
+	9: "no worker available",
+}
+
+// KaldiTranscriber is the implementation of the transcribe.Service,
+// using the WebSocket protocol of kaldi-gstreamer-server
+type KaldiTranscriber struct {
+	serverURL  string // Speech endpoint with the content type of the PCM sent
+	sampleRate int    // Rate of the PCM sent, 48000 or 16000
+	ctx        context.Context
+}
+
+// KaldiStream implements the transcribe.Stream interface,
+// it streams the raw PCM to one kaldi-gstreamer-server connection
+type KaldiStream struct {
+	conn      *websocket.Conn
+	results   chan Result
+	ctx       context.Context
+	resampler *audio.Downsampler // nil when sending 48kHz
+	mu        sync.Mutex         // Serializes the writes to conn
+	closed    bool
+	done      chan struct{} // Closed when the listener returns
+}
+
+// kaldiResponse is a message of kaldi-gstreamer-server
+type kaldiResponse struct {
+	Status       int     `json:"status"`
+	Message      string  `json:"message"`
+	SegmentStart float64 `json:"segment-start"`
+	Result       struct {
+		Final      bool `json:"final"`
+		Hypotheses []struct {
+			Transcript    string   `json:"transcript"`
+			Confidence    *float32 `json:"confidence"`
+			WordAlignment []struct {
+				Word       string  `json:"word"`
+				Start      float64 `json:"start"` // Seconds from the segment start
+				Length     float64 `json:"length"`
+				Confidence float32 `json:"confidence"`
+			} `json:"word-alignment"`
+		} `json:"hypotheses"`
+	} `json:"result"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *KaldiTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream (the language is the one of the server models)
+func (t *KaldiTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial(t.serverURL, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to kaldi-gstreamer-server: HTTP status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to kaldi-gstreamer-server: %w", err)
+	}
+
+	stream := &KaldiStream{
+		conn:    conn,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		done:    make(chan struct{}),
+	}
+	if t.sampleRate != 48000 {
+		stream.resampler = audio.NewDownsampler(48000, t.sampleRate)
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// Results returns a channel that will receive the transcription results
+func (ks *KaldiStream) Results() <-chan Result {
+	return ks.results
+}
+
+// Write sends the raw PCM, resampled when the server expects 16kHz
+func (ks *KaldiStream) Write(buffer []byte) (int, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.closed {
+		return 0, fmt.Errorf("kaldi stream is closed")
+	}
+	pcm := buffer
+	if ks.resampler != nil {
+		pcm = ks.resampler.Process(buffer)
+	}
+	if len(pcm) == 0 {
+		return len(buffer), nil
+	}
+	if err := ks.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close sends the end of the audio, waits for the last results and
+// closes the connection
+func (ks *KaldiStream) Close() error {
+	ks.mu.Lock()
+	if ks.closed {
+		ks.mu.Unlock()
+		return nil
+	}
+	ks.closed = true
+	if err := ks.conn.WriteMessage(websocket.TextMessage, []byte("EOS")); err != nil {
+		log.Printf("Warning: failed to send EOS to kaldi-gstreamer-server: %v", err)
+	}
+	ks.mu.Unlock()
+
+	select {
+	case <-ks.done:
+	case <-time.After(kaldiFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Kaldi results")
+	}
+	return ks.conn.Close()
+}
+
+// listenForResults maps the kaldi-gstreamer-server messages to results until
+// the server closes the connection, then closes the results channel
+func (ks *KaldiStream) listenForResults() {
+	defer close(ks.done)
+	defer close(ks.results)
+
+	for {
+		_, message, err := ks.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Kaldi WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var response kaldiResponse
+		if err := json.Unmarshal(message, &response); err != nil {
+			logSampler.Printf("kaldi:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+		if response.Status != 0 {
+			log.Printf("kaldi-gstreamer-server error: %d %s %s", response.Status, kaldiStatuses[response.Status], response.Message)
+			return
+		}
+
+		result, ok := response.result()
+		if !ok {
+			continue
+		}
+		if !sendResult(ks.ctx, ks.results, result, "kaldi") && ks.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// result maps the best hypothesis of a message, false when it has none.
+// The words are aligned only when the server is configured to
+func (r *kaldiResponse) result() (Result, bool) {
+	if len(r.Result.Hypotheses) == 0 {
+		return Result{}, false
+	}
+	hypothesis := r.Result.Hypotheses[0]
+	text := strings.TrimSpace(hypothesis.Transcript)
+	if text == "" {
+		return Result{}, false
+	}
+
+	result := Result{Text: text, Confidence: 0.5, Final: r.Result.Final}
+	if r.Result.Final {
+		result.Confidence = 0.9
+		if hypothesis.Confidence != nil {
+			result.Confidence = *hypothesis.Confidence
+		}
+	}
+	for _, w := range hypothesis.WordAlignment {
+		result.Words = append(result.Words, Word{
+			Word:       w.Word,
+			Start:      r.SegmentStart + w.Start,
+			End:        r.SegmentStart + w.Start + w.Length,
+			Confidence: w.Confidence,
+		})
+	}
+	return result, true
+}
+
+// kaldiContentType returns the GStreamer caps of mono S16LE PCM at sampleRate
+func kaldiContentType(sampleRate int) string {
+	return fmt.Sprintf("audio/x-raw, layout=(string)interleaved, rate=(int)%d, format=(string)S16LE, channels=(int)1", sampleRate)
+}
+
+// NewKaldiTranscriber creates a new instance of the transcribe.Service that uses the
+// kaldi-gstreamer-server speech endpoint serverURL (e.g. ws://localhost:8888/client/ws/speech).
+// The PCM is sent at sampleRate, 48000 or 16000, announced by the content-type parameter
+// of the URL unless serverURL already has one
+func NewKaldiTranscriber(ctx context.Context, serverURL string, sampleRate int) (Service, error) {
+	if serverURL == "" {
+		return nil, fmt.Errorf("serverURL is required")
+	}
+	if sampleRate == 0 {
+		sampleRate = 48000
+	}
+	if sampleRate != 48000 && sampleRate != 16000 {
+		return nil, fmt.Errorf("unsupported sample rate %d, use 48000 or 16000", sampleRate)
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %s: %w", serverURL, err)
+	}
+	query := u.Query()
+	if query.Get("content-type") == "" {
+		query.Set("content-type", kaldiContentType(sampleRate))
+		u.RawQuery = query.Encode()
+	}
+
+	log.Printf("Kaldi transcriber initialized with server: %s, sample rate: %d", serverURL, sampleRate)
+	return &KaldiTranscriber{
+		serverURL:  u.String(),
+		sampleRate: sampleRate,
+		ctx:        ctx,
+	}, nil
+}
@@ -0,0 +1,43 @@
+package transcribe
+
+import (
+	"sync"
+	"time"
+)
+
+// Marker is a named point of a session, e.g. "decision" or "action item"
+type Marker struct {
+	Name   string    `json:"name"`
+	Offset float64   `json:"offset_seconds"` // Seconds of audio received before the marker
+	Time   time.Time `json:"time"`
+}
+
+// Markers collects the markers inserted during a session. It is shared by
+// the layers of a stream through StreamOptions and is safe for concurrent use
+type Markers struct {
+	mu      sync.Mutex
+	markers []Marker
+}
+
+// Add appends a marker
+func (m *Markers) Add(marker Marker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.markers = append(m.markers, marker)
+}
+
+// List returns the markers in insertion order
+func (m *Markers) List() []Marker {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Marker(nil), m.markers...)
+}
+
+// MarkingStream is implemented by the streams accepting markers, the
+// streams of the live hub
+type MarkingStream interface {
+	Mark(name string) (Marker, error)
+}
@@ -0,0 +1,154 @@
+package transcribe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Codes of the messages the server injects into the results, clients
+// recognize them with Result.Code whatever the language of Result.Text
+const (
+	MsgRecordingSaved        = "recording_saved"        // The recorder saved the audio, its argument is the file name
+	MsgTranscriptionDisabled = "transcription_disabled" // The audio was recorded without transcription
+	MsgTranscriptionError    = "transcription_error"    // Its argument is the error, also in Result.Detail
+	MsgVendorFailover        = "vendor_failover"        // The vendor failed and the next one of the chain goes on, its argument is the name of the next one
+)
+
+// defaultLocale is the language of the messages of the other locales
+const defaultLocale = "en"
+
+// messages holds the translations of the message codes per language
+var messages = map[string]map[string]string{
+	"en": {
+		MsgRecordingSaved:        "Recording saved: %s",
+		MsgTranscriptionDisabled: "Recording saved (transcription disabled)",
+		MsgTranscriptionError:    "Transcription error: %s",
+		MsgVendorFailover:        "Transcription switched to %s",
+	},
+	"zh": {
+		MsgRecordingSaved:        "录音已保存：%s",
+		MsgTranscriptionDisabled: "录音已保存（未启用转写）",
+		MsgTranscriptionError:    "转写出错：%s",
+		MsgVendorFailover:        "转写已切换到 %s",
+	},
+	"fr": {
+		MsgRecordingSaved:        "Enregistrement sauvegardé : %s",
+		MsgTranscriptionDisabled: "Enregistrement sauvegardé (transcription désactivée)",
+		MsgTranscriptionError:    "Erreur de transcription : %s",
+		MsgVendorFailover:        "Transcription basculée vers %s",
+	},
+	"de": {
+		MsgRecordingSaved:        "Aufnahme gespeichert: %s",
+		MsgTranscriptionDisabled: "Aufnahme gespeichert (Transkription deaktiviert)",
+		MsgTranscriptionError:    "Transkriptionsfehler: %s",
+		MsgVendorFailover:        "Transkription auf %s umgeschaltet",
+	},
+	"es": {
+		MsgRecordingSaved:        "Grabación guardada: %s",
+		MsgTranscriptionDisabled: "Grabación guardada (transcripción desactivada)",
+		MsgTranscriptionError:    "Error de transcripción: %s",
+		MsgVendorFailover:        "Transcripción cambiada a %s",
+	},
+	"ja": {
+		MsgRecordingSaved:        "録音を保存しました：%s",
+		MsgTranscriptionDisabled: "録音を保存しました（文字起こしは無効）",
+		MsgTranscriptionError:    "文字起こしエラー：%s",
+		MsgVendorFailover:        "文字起こしを %s に切り替えました",
+	},
+}
+
+// Message returns the text of a message code in the locale (e.g. "fr",
+// "zh-CN"), in English when the locale is not translated
+func Message(locale, code string, args ...interface{}) string {
+	translations, ok := messages[baseLanguage(locale)]
+	if !ok {
+		translations = messages[defaultLocale]
+	}
+	format, ok := translations[code]
+	if !ok {
+		format = messages[defaultLocale][code]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// MessageResult returns the final result carrying a message code
+func MessageResult(locale, code string, args ...interface{}) Result {
+	return Result{
+		Text:       Message(locale, code, args...),
+		Code:       code,
+		Confidence: 1.0,
+		Final:      true,
+	}
+}
+
+// ErrorResult returns the final result reporting a transcription error
+func ErrorResult(locale string, err error) Result {
+	result := MessageResult(locale, MsgTranscriptionError, err)
+	result.Confidence = 0
+	result.Detail = err.Error()
+	return result
+}
+
+// ParseLocale returns the preferred language of an Accept-Language header,
+// "" when it has none
+func ParseLocale(acceptLanguage string) string {
+	best, bestQ := "", -1.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				fmt.Sscanf(value[2:], "%g", &q)
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// StreamLocale returns the locale of the messages of a stream, the
+// language it transcribes when the client sent none
+func StreamLocale(opts StreamOptions, language string) string {
+	if opts.Locale != "" {
+		return opts.Locale
+	}
+	if language != "auto" {
+		return language
+	}
+	return ""
+}
+
+// defaultLocales maps the languages to their most common locale
+var defaultLocales = map[string]string{
+	"en": "en-US", "zh": "zh-CN", "es": "es-ES", "de": "de-DE",
+	"fr": "fr-FR", "ja": "ja-JP", "ko": "ko-KR", "ru": "ru-RU",
+	"pt": "pt-BR", "it": "it-IT", "hi": "hi-IN", "ar": "ar-SA",
+	"nl": "nl-NL", "pl": "pl-PL", "sv": "sv-SE", "tr": "tr-TR",
+}
+
+// languageLocale returns the locale of a language for the vendors requiring
+// one: the locales are kept, en-US for "auto" and the unknown languages
+func languageLocale(language string) string {
+	if strings.Contains(language, "-") {
+		return language
+	}
+	if locale, ok := defaultLocales[baseLanguage(language)]; ok {
+		return locale
+	}
+	return "en-US"
+}
+
+// baseLanguage returns the lowercase language of a locale, "zh" of "zh-CN"
+func baseLanguage(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
@@ -0,0 +1,197 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+// mockDefaultDelay is the delay of the steps without one when MOCK_DELAY is not set
+const mockDefaultDelay = 500 * time.Millisecond
+
+// MockStep is a step of the script of the mock transcriber, sent after its delay
+type MockStep struct {
+	Text  string `json:"text"`
+	Final bool   `json:"final"`
+	Delay string `json:"delay,omitempty"` // e.g. "300ms", the default delay when empty
+	Error string `json:"error,omitempty"` // Sends a transcription error and ends the stream
+}
+
+// mockDefaultScript alternates partial and final results
+var mockDefaultScript = []MockStep{
+	{Text: "Hello"},
+	{Text: "Hello world"},
+	{Text: "Hello world, this is a mock transcription.", Final: true},
+	{Text: "The audio"},
+	{Text: "The audio is received"},
+	{Text: "The audio is received but not transcribed.", Final: true},
+}
+
+// MockTranscriber is the implementation of the transcribe.Service,
+// it plays a script of results while the audio is received, for the
+// development and the tests without credentials
+type MockTranscriber struct {
+	steps       []MockStep
+	delays      []time.Duration
+	createError string // Error of the stream creation, none when empty
+	ctx         context.Context
+}
+
+// MockStream implements the transcribe.Stream interface,
+// it discards the audio and plays the script until it is closed
+type MockStream struct {
+	transcriber *MockTranscriber
+	results     chan Result
+	ctx         context.Context
+	language    string
+	locale      string
+	mu          sync.Mutex
+	closed      bool
+	received    int64         // Bytes of audio received
+	stop        chan struct{} // Closed by Close
+	done        chan struct{} // Closed when the script stops
+}
+
+// CreateStream creates a new transcription stream
+func (t *MockTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream, the results are in the
+// language of the options
+func (t *MockTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	if t.createError != "" {
+		return nil, errors.New(t.createError)
+	}
+
+	language := opts.Language
+	if language == "auto" {
+		language = ""
+	}
+	stream := &MockStream{
+		transcriber: t,
+		results:     make(chan Result, resultsBuffer),
+		ctx:         t.ctx,
+		language:    language,
+		locale:      StreamLocale(opts, opts.Language),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go stream.play()
+
+	return stream, nil
+}
+
+// Results returns a channel that will receive the transcription results
+func (ms *MockStream) Results() <-chan Result {
+	return ms.results
+}
+
+// Write discards the audio
+func (ms *MockStream) Write(buffer []byte) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.closed {
+		return 0, fmt.Errorf("mock stream is closed")
+	}
+	ms.received += int64(len(buffer))
+	return len(buffer), nil
+}
+
+// Close stops the script and waits for the results channel to be closed
+func (ms *MockStream) Close() error {
+	ms.mu.Lock()
+	if ms.closed {
+		ms.mu.Unlock()
+		return nil
+	}
+	ms.closed = true
+	received := ms.received
+	ms.mu.Unlock()
+
+	close(ms.stop)
+	<-ms.done
+	log.Printf("Mock stream closed after %d bytes of audio", received)
+	return nil
+}
+
+// play sends the steps of the script after their delay, again and again,
+// until the stream is closed or an error step, then closes the results channel
+func (ms *MockStream) play() {
+	defer close(ms.done)
+	defer close(ms.results)
+
+	for {
+		for i, step := range ms.transcriber.steps {
+			select {
+			case <-time.After(ms.transcriber.delays[i]):
+			case <-ms.stop:
+				return
+			case <-ms.ctx.Done():
+				return
+			}
+
+			if step.Error != "" {
+				sendResult(ms.ctx, ms.results, ErrorResult(ms.locale, errors.New(step.Error)), "mock")
+				return
+			}
+			result := Result{Text: step.Text, Confidence: 0.5, Final: step.Final, Language: ms.language}
+			if step.Final {
+				result.Confidence = 0.95
+			}
+			if !sendResult(ms.ctx, ms.results, result, "mock") && ms.ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// NewMockTranscriber creates a new instance of the transcribe.Service that plays the
+// script of the JSON file scriptPath, an array of MockStep (a canned script when
+// empty), waiting delay before the steps without one (500ms when 0). The creation of
+// the streams fails with createError when it is not empty
+func NewMockTranscriber(ctx context.Context, scriptPath string, delay time.Duration, createError string) (Service, error) {
+	steps := mockDefaultScript
+	if scriptPath != "" {
+		data, err := ioutil.ReadFile(scriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the mock script: %w", err)
+		}
+		steps = nil
+		if err := json.Unmarshal(data, &steps); err != nil {
+			return nil, fmt.Errorf("invalid mock script %s: %w", scriptPath, err)
+		}
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("mock script %s has no steps", scriptPath)
+		}
+	}
+	if delay <= 0 {
+		delay = mockDefaultDelay
+	}
+
+	delays := make([]time.Duration, len(steps))
+	for i, step := range steps {
+		delays[i] = delay
+		if step.Delay == "" {
+			continue
+		}
+		d, err := time.ParseDuration(step.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay of the step %d of the mock script: %w", i+1, err)
+		}
+		delays[i] = d
+	}
+
+	log.Printf("Mock transcriber initialized with %d steps", len(steps))
+	return &MockTranscriber{
+		steps:       steps,
+		delays:      delays,
+		createError: createError,
+		ctx:         ctx,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// openAIDefaultURL is the base URL of the OpenAI API
+const openAIDefaultURL = "https://api.openai.com/v1"
+
+// openAIDefaultModel is used when OPENAI_TRANSCRIBE_MODEL is not set
+const openAIDefaultModel = "whisper-1"
+
+// OpenAITranscriber is the implementation of the transcribe.Service,
+// posting the recordings of the streams to the OpenAI audio transcription
+// API (or a compatible server) when they are closed
+type OpenAITranscriber struct {
+	*WhisperTranscriber
+}
+
+// openAIConfig is the endpoint a WhisperTranscriber posts its recordings to
+type openAIConfig struct {
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+// runOpenAI posts the audio file to /audio/transcriptions, or to
+// /audio/translations to translate it, and returns the path of the output
+// like run, the output mirrors the log line of the detected language of the
+// Whisper executables
+func (w *WhisperTranscriber) runOpenAI(ctx context.Context, audioPath, language, task, prompt, format string) (string, []byte, error) {
+	fields := map[string]string{
+		"model":           w.openai.model,
+		"response_format": format,
+		"temperature":     "0",
+	}
+	if format == "txt" || format == "json" {
+		// Only the Whisper models return the detected language and the words
+		fields["response_format"] = "json"
+		if strings.HasPrefix(w.openai.model, "whisper") {
+			fields["response_format"] = "verbose_json"
+			if format == "json" && task == TaskTranscribe {
+				fields["timestamp_granularities[]"] = "word"
+			}
+		}
+	}
+	if prompt != "" {
+		fields["prompt"] = prompt
+	}
+	endpoint := "/audio/transcriptions"
+	if task == TaskTranslate {
+		// The translations are always in English, from any language
+		endpoint = "/audio/translations"
+	} else if language != "" && language != "auto" {
+		fields["language"] = baseLanguage(language)
+	}
+
+	content, err := postAudio(ctx, w.openai.baseURL+endpoint, w.openai.apiKey, "file", audioPath, fields)
+	if err != nil {
+		return "", nil, fmt.Errorf("openai transcription request failed: %w", err)
+	}
+	if format == "txt" {
+		return w.writeJSONOutput(audioPath, format, content)
+	}
+	return w.writeOutput(audioPath, format, content, nil)
+}
+
+// NewOpenAITranscriber creates a new instance of the transcribe.Service that uses the
+// OpenAI audio transcription API at baseURL (the OpenAI API when empty) with the model
+// (whisper-1 when empty). The streams are recorded in tempDir like the ones of Whisper
+func NewOpenAITranscriber(ctx context.Context, apiKey, baseURL, model, tempDir, language string, keepWav, keepTxt bool) (Service, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("apiKey is required")
+	}
+	if baseURL == "" {
+		baseURL = openAIDefaultURL
+	}
+	if model == "" {
+		model = openAIDefaultModel
+	}
+	if tempDir == "" {
+		tempDir = "./output"
+	}
+	if language == "" {
+		language = "auto"
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	log.Printf("OpenAI transcriber initialized with API: %s, model: %s, language: %s", baseURL, model, language)
+
+	return &OpenAITranscriber{WhisperTranscriber: &WhisperTranscriber{
+		openai: &openAIConfig{
+			baseURL: strings.TrimSuffix(baseURL, "/"),
+			apiKey:  apiKey,
+			model:   model,
+		},
+		tempDir:  tempDir,
+		language: language,
+		ctx:      ctx,
+		keepWav:  keepWav,
+		keepTxt:  keepTxt,
+	}}, nil
+}
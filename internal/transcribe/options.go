@@ -0,0 +1,56 @@
+package transcribe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxVendorOption bounds the length of the values of StreamOptions.Extra
+const maxVendorOption = 64
+
+// VendorOptions lists the settings of the vendors a session may tune with
+// StreamOptions.Extra, the others are rejected so the clients cannot change
+// the audio format or the callbacks of the vendors
+var VendorOptions = map[string]string{
+	"deepgram.model": "Deepgram model, e.g. nova-3 or nova-2-meeting",
+	"deepgram.tier":  "Deepgram tier of the legacy models, e.g. enhanced",
+	"google.model":   "Google Speech-to-Text v2 model, e.g. latest_short or telephony",
+	"xunfei.domain":  "Xunfei domain, e.g. iat, medical or gov-seat-assistant",
+	"xunfei.accent":  "Xunfei accent, e.g. mandarin or cantonese",
+
+	"recorder.sample_rate": "Sample rate of the recording, dividing 48000, e.g. 16000",
+	"recorder.bits":        "Bits per sample of the recording: 8, 16, 24 or 32",
+	"recorder.channels":    "Channels of the recording: 1 or 2",
+}
+
+// ValidateExtra checks that the settings are known vendor options with
+// short values
+func ValidateExtra(extra map[string]string) error {
+	for key, value := range extra {
+		if _, ok := VendorOptions[key]; !ok {
+			known := make([]string, 0, len(VendorOptions))
+			for k := range VendorOptions {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown vendor option %q (expected %s)", key, strings.Join(known, ", "))
+		}
+		if value == "" || len(value) > maxVendorOption {
+			return fmt.Errorf("the vendor option %s must have 1 to %d characters", key, maxVendorOption)
+		}
+	}
+	if _, err := streamRecordingFormat(StreamOptions{Extra: extra}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// vendorOption returns the setting of the vendor, fallback when the stream
+// does not set it
+func (o StreamOptions) vendorOption(vendor, name, fallback string) string {
+	if value := o.Extra[vendor+"."+name]; value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,152 @@
+package transcribe
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pool limits the streams transcribed at the same time by the services it
+// wraps, the streams over the limit wait for a slot
+type Pool struct {
+	slots   chan struct{} // Holds a token per stream transcribing
+	timeout time.Duration // Wait of the new streams for a slot, forever when not positive
+	waiting int64         // Number of streams waiting for a slot
+}
+
+// PooledTranscriber is the implementation of the transcribe.Service that
+// transcribes the streams of the service it wraps in the slots of a pool
+type PooledTranscriber struct {
+	next Service
+	pool *Pool
+}
+
+// PooledStream implements the transcribe.Stream interface,
+// it holds its slot of the pool until it is closed
+type PooledStream struct {
+	Stream
+	pool     *Pool
+	mu       sync.Mutex
+	held     bool // Whether the stream holds a slot
+	released bool
+}
+
+// batchService is implemented by the services transcribing the audio of
+// their streams when they are closed, like Whisper, they only need a slot
+// while the stream is being closed
+type batchService interface {
+	transcribesOnClose() bool
+}
+
+// transcribesOnClose reports that the recording is transcribed by Close,
+// with the Whisper executable, the Whisper server or the OpenAI API
+func (w *WhisperTranscriber) transcribesOnClose() bool {
+	return true
+}
+
+// CreateStream creates a new transcription stream
+func (t *PooledTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions waits for a slot and creates the stream of the
+// wrapped service. The streams of the services transcribing on close get
+// their slot when they are closed, their recording starts right away
+func (t *PooledTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	if batch, ok := t.next.(batchService); ok && batch.transcribesOnClose() {
+		stream, err := t.next.CreateStreamWithOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return &PooledStream{Stream: stream, pool: t.pool}, nil
+	}
+
+	if err := t.pool.acquire(t.pool.timeout); err != nil {
+		return nil, err
+	}
+	stream, err := t.next.CreateStreamWithOptions(opts)
+	if err != nil {
+		t.pool.release()
+		return nil, err
+	}
+	return &PooledStream{Stream: stream, pool: t.pool, held: true}, nil
+}
+
+// acquire waits for a free slot up to timeout, forever when not positive
+func (p *Pool) acquire(timeout time.Duration) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	waiting := atomic.AddInt64(&p.waiting, 1)
+	defer atomic.AddInt64(&p.waiting, -1)
+	logSampler.Printf("pool:full", "All %d transcription slots are busy, %d stream(s) waiting", cap(p.slots), waiting)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-expired:
+		return fmt.Errorf("the %d transcription slots are still busy after %v", cap(p.slots), timeout)
+	}
+}
+
+// release frees a slot
+func (p *Pool) release() {
+	<-p.slots
+}
+
+// Close closes the wrapped stream and frees its slot. The streams of the
+// services transcribing on close wait for a slot as long as needed, their
+// recording is safe meanwhile
+func (ps *PooledStream) Close() error {
+	ps.mu.Lock()
+	if ps.released {
+		ps.mu.Unlock()
+		return nil
+	}
+	ps.released = true
+	held := ps.held
+	ps.mu.Unlock()
+
+	if !held {
+		ps.pool.acquire(0)
+	}
+	defer ps.pool.release()
+	return ps.Stream.Close()
+}
+
+// Wrap returns the service transcribing the streams of next in the slots of the pool
+func (p *Pool) Wrap(next Service) Service {
+	return &PooledTranscriber{next: next, pool: p}
+}
+
+// Stats returns the number of streams transcribing, of streams waiting for
+// a slot and of slots
+func (p *Pool) Stats() (active, waiting, slots int) {
+	return len(p.slots), int(atomic.LoadInt64(&p.waiting)), cap(p.slots)
+}
+
+// NewPool creates a pool transcribing up to maxStreams streams at the same
+// time, the new streams over the limit wait up to timeout (forever when not
+// positive) for a slot
+func NewPool(maxStreams int, timeout time.Duration) (*Pool, error) {
+	if maxStreams < 1 {
+		return nil, fmt.Errorf("maxStreams must be positive, got %d", maxStreams)
+	}
+
+	log.Printf("Transcription pool initialized with %d slots", maxStreams)
+	return &Pool{
+		slots:   make(chan struct{}, maxStreams),
+		timeout: timeout,
+	}, nil
+}
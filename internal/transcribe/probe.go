@@ -0,0 +1,305 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/oauth2/google"
+)
+
+// probeTimeout bounds a credentials check
+const probeTimeout = 30 * time.Second
+
+// Probe obtains an OAuth token with the service account
+func (t *GoogleTranscriber) Probe(ctx context.Context) error {
+	data, err := ioutil.ReadFile(t.credentials)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	creds, err := google.CredentialsFromJSON(ctx, data, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return fmt.Errorf("invalid credentials file: %w", err)
+	}
+	if _, err := creds.TokenSource.Token(); err != nil {
+		return fmt.Errorf("failed to obtain a token: %w", err)
+	}
+	return nil
+}
+
+// Probe issues a token with the subscription key
+func (a *AzureTranscriber) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	_, err := a.issueToken(ctx)
+	return err
+}
+
+// Probe requests an access token with the API key
+func (b *BaiduTranscriber) Probe(ctx context.Context) error {
+	_, err := b.getAccessToken()
+	return err
+}
+
+// Probe creates a token with the access key
+func (t *AliyunTranscriber) Probe(ctx context.Context) error {
+	_, _, err := t.createToken()
+	return err
+}
+
+// Probe opens a connection and closes it once the signature is accepted
+func (t *TencentTranscriber) Probe(ctx context.Context) error {
+	stream, err := t.CreateStream()
+	if err != nil {
+		return err
+	}
+	return stream.(*TencentStream).conn.Close()
+}
+
+// Probe starts a recognition and closes the connection
+func (t *SpeechmaticsTranscriber) Probe(ctx context.Context) error {
+	stream, err := t.CreateStream()
+	if err != nil {
+		return err
+	}
+	return stream.(*SpeechmaticsStream).conn.Close()
+}
+
+// Probe lists the models of Soniox with the API key
+func (t *SonioxTranscriber) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return probeGet(ctx, "https://api.soniox.com/v1/models", t.apiKey)
+}
+
+// Probe reads the status of kaldi-gstreamer-server, next to its speech endpoint,
+// and fails when no worker is available
+func (t *KaldiTranscriber) Probe(ctx context.Context) error {
+	u, err := url.Parse(t.serverURL)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "speech") + "status"
+	u.RawQuery = ""
+
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = probeTimeout
+	conn, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("handshake returned HTTP status %d", resp.StatusCode)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	var status struct {
+		NumWorkersAvailable int `json:"num_workers_available"`
+	}
+	conn.SetReadDeadline(time.Now().Add(probeTimeout))
+	if err := conn.ReadJSON(&status); err != nil {
+		return fmt.Errorf("failed to read the server status: %w", err)
+	}
+	if status.NumWorkersAvailable == 0 {
+		return fmt.Errorf("no worker available")
+	}
+	return nil
+}
+
+// Probe starts a recognition without audio and waits for the server to end it
+func (t *RivaTranscriber) Probe(ctx context.Context) error {
+	stream, err := t.CreateStream()
+	if err != nil {
+		return err
+	}
+	rs := stream.(*RivaStream)
+	defer rs.cancel()
+	if err := rs.stream.CloseSend(); err != nil {
+		return err
+	}
+	select {
+	case <-rs.done:
+		return rs.err
+	case <-time.After(probeTimeout):
+		return fmt.Errorf("timed out waiting for the Riva server")
+	}
+}
+
+// Probe starts a transcription without audio and waits for the service to end it
+func (t *GRPCTranscriber) Probe(ctx context.Context) error {
+	stream, err := t.CreateStream()
+	if err != nil {
+		return err
+	}
+	gs := stream.(*GRPCStream)
+	defer gs.cancel()
+	if err := gs.stream.CloseSend(); err != nil {
+		return err
+	}
+	select {
+	case <-gs.done:
+		return gs.err
+	case <-time.After(probeTimeout):
+		return fmt.Errorf("timed out waiting for the gRPC service")
+	}
+}
+
+// Probe opens and closes an authenticated WebSocket connection
+func (t *IflyTekTranscriber) Probe(ctx context.Context) error {
+	authURL, err := t.generateAuthURL()
+	if err != nil {
+		return err
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = probeTimeout
+	conn, resp, err := dialer.Dial(authURL, nil)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("handshake returned HTTP status %d", resp.StatusCode)
+		}
+		return err
+	}
+	return conn.Close()
+}
+
+// Probe lists the projects of the API key
+func (t *DeepgramTranscriber) Probe(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, "https://api.deepgram.com/v1/projects", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+t.apiKey)
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("projects request returned HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Probe opens and closes a connection to the vosk-server
+func (t *VoskTranscriber) Probe(ctx context.Context) error {
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = probeTimeout
+	conn, _, err := dialer.Dial(t.serverURL, nil)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Probe probes the vendors of the tee able to, and fails with the first of
+// them failing
+func (t *TeeTranscriber) Probe(ctx context.Context) error {
+	for i, service := range t.services {
+		prober, ok := service.(Prober)
+		if !ok {
+			continue
+		}
+		if err := prober.Probe(ctx); err != nil {
+			return fmt.Errorf("%s: %w", t.names[i], err)
+		}
+	}
+	return nil
+}
+
+// Probe probes the vendors of the chain able to, it fails when all of them
+// fail as the streams would
+func (t *FailoverTranscriber) Probe(ctx context.Context) error {
+	var errs []string
+	for i, service := range t.services {
+		prober, ok := service.(Prober)
+		if !ok {
+			return nil
+		}
+		if err := prober.Probe(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", t.names[i], err))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(errs, ", "))
+}
+
+// Probe runs the Whisper executable or the Coqui STT client, or requests the
+// Whisper server: the health of whisper.cpp, the models of an OpenAI
+// compatible server or the documentation of whisper-asr-webservice. The
+// whisper.cpp model loaded in the process was probed by loading it
+func (t *WhisperTranscriber) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	switch {
+	case t.openai != nil:
+		return probeGet(ctx, t.openai.baseURL+"/models", t.openai.apiKey)
+	case t.coqui != nil:
+		if output, err := exec.CommandContext(ctx, t.coqui.sttPath, "--version").CombinedOutput(); err != nil {
+			return fmt.Errorf("%s --version failed: %w %s", t.coqui.sttPath, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	case t.native != nil:
+		return nil
+	case t.serverAPI == WhisperServerASR:
+		return probeGet(ctx, t.serverURL+"/docs", "")
+	case t.serverURL != "":
+		return probeGet(ctx, t.serverURL+"/health", "")
+	}
+	output, err := exec.CommandContext(ctx, t.whisperPath, "--help").CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(output))
+		if len(msg) > 200 {
+			msg = msg[len(msg)-200:]
+		}
+		return fmt.Errorf("%s --help failed: %w %s", t.whisperPath, err, msg)
+	}
+	return nil
+}
+
+// Executable returns the path of the Whisper executable, or the URL of
+// the Whisper server, or the path of the Coqui STT client, or the path of
+// the whisper.cpp model loaded in the process
+func (t *WhisperTranscriber) Executable() string {
+	if t.coqui != nil {
+		return t.coqui.sttPath
+	}
+	if t.native != nil {
+		return t.modelPath
+	}
+	if t.serverURL != "" {
+		return t.serverURL
+	}
+	return t.whisperPath
+}
+
+// probeGet requests url, authenticated with the bearer token apiKey when
+// not empty, and expects a success
+func probeGet(ctx context.Context, url, apiKey string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned HTTP status %d", url, resp.StatusCode)
+	}
+	return nil
+}
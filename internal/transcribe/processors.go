@@ -0,0 +1,200 @@
+package transcribe
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Processor transforms the results of the vendor streams before they are
+// sent, e.g. to redact or normalize their text
+type Processor interface {
+	Process(result Result) Result
+}
+
+// ProcessorFunc adapts a function to the Processor interface
+type ProcessorFunc func(result Result) Result
+
+// Process calls f
+func (f ProcessorFunc) Process(result Result) Result {
+	return f(result)
+}
+
+// ProcessorOptions configures the built-in processors of NewProcessors
+type ProcessorOptions struct {
+	Replacements string // File of the "replace" processor
+}
+
+var (
+	processorsMu sync.RWMutex
+	processors   []Processor
+)
+
+// SetProcessors applies the chain of processors, in order, to the results of
+// every vendor after their punctuation is restored. It applies to the
+// results sent afterwards
+func SetProcessors(chain []Processor) {
+	processorsMu.Lock()
+	processors = append([]Processor{}, chain...)
+	processorsMu.Unlock()
+}
+
+// process runs the chain on the transcripts, the server messages are kept
+func process(result Result) Result {
+	processorsMu.RLock()
+	chain := processors
+	processorsMu.RUnlock()
+	if result.Code != "" {
+		return result
+	}
+	for _, p := range chain {
+		result = p.Process(result)
+	}
+	return result
+}
+
+// NewProcessors returns the built-in processors named in order: "normalize"
+// collapses the whitespace, "replace" applies the replacements of
+// opts.Replacements and "redact" masks the e-mail addresses, phone and card
+// numbers
+func NewProcessors(names []string, opts ProcessorOptions) ([]Processor, error) {
+	var chain []Processor
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "normalize":
+			chain = append(chain, ProcessorFunc(normalize))
+		case "replace":
+			if opts.Replacements == "" {
+				return nil, fmt.Errorf("the replace processor needs a replacements file")
+			}
+			replacer, err := LoadReplacements(opts.Replacements)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, replacer)
+		case "redact":
+			chain = append(chain, ProcessorFunc(redact))
+		default:
+			return nil, fmt.Errorf("unknown processor %q (expected normalize, replace or redact)", name)
+		}
+	}
+	return chain, nil
+}
+
+// mapText applies f to the text of the result and of its words
+func mapText(result Result, f func(string) string) Result {
+	result.Text = f(result.Text)
+	if len(result.Words) > 0 {
+		words := make([]Word, len(result.Words))
+		for i, word := range result.Words {
+			word.Word = f(word.Word)
+			words[i] = word
+		}
+		result.Words = words
+	}
+	return result
+}
+
+// normalize trims the text and collapses its whitespace
+func normalize(result Result) Result {
+	return mapText(result, func(text string) string {
+		return strings.Join(strings.Fields(text), " ")
+	})
+}
+
+// Redaction patterns, the numbers have at least 7 digits so that the times
+// and the amounts are kept
+var (
+	emailPattern  = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	numberPattern = regexp.MustCompile(`\+?\d(?:[\s().-]*\d){6,}`)
+)
+
+// redactedText replaces the redacted e-mail addresses and numbers
+const redactedText = "[redacted]"
+
+// redact masks the e-mail addresses and the phone and card numbers, the
+// words are redacted one by one
+func redact(result Result) Result {
+	return mapText(result, func(text string) string {
+		text = emailPattern.ReplaceAllString(text, redactedText)
+		return numberPattern.ReplaceAllString(text, redactedText)
+	})
+}
+
+// wordChar matches the characters the whole words of a Replacer start and
+// end with
+var wordChar = regexp.MustCompile(`\w`)
+
+// replacement is a rule of a Replacer
+type replacement struct {
+	pattern *regexp.Regexp
+	to      string
+}
+
+// Replacer is the Processor replacing words and phrases of the text, e.g.
+// the product names the vendors misspell
+type Replacer struct {
+	rules []replacement
+}
+
+// LoadReplacements reads the replacements of a file, one "from => to" per
+// line, the lines starting with # are comments. The phrases are matched as
+// whole words regardless of their case
+func LoadReplacements(path string) (*Replacer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the replacements: %w", err)
+	}
+	defer file.Close()
+
+	r := &Replacer{}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=>", 2)
+		from := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || from == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"from => to\"", path, n)
+		}
+		r.Add(from, strings.TrimSpace(parts[1]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the replacements: %w", err)
+	}
+	return r, nil
+}
+
+// Add replaces the phrase from, matched as whole words regardless of its
+// case, with to
+func (r *Replacer) Add(from, to string) {
+	words := strings.Fields(from)
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	pattern := `(?i)` + strings.Join(words, `\s+`)
+	if wordChar.MatchString(from[:1]) {
+		pattern = `\b` + pattern
+	}
+	if wordChar.MatchString(from[len(from)-1:]) {
+		pattern += `\b`
+	}
+	r.rules = append(r.rules, replacement{pattern: regexp.MustCompile(pattern), to: to})
+}
+
+// Process applies the replacements to the text of the result and of its
+// words, the phrases spanning several words are only replaced in the text
+func (r *Replacer) Process(result Result) Result {
+	return mapText(result, func(text string) string {
+		for _, rule := range r.rules {
+			text = rule.pattern.ReplaceAllLiteralString(text, rule.to)
+		}
+		return text
+	})
+}
@@ -0,0 +1,112 @@
+package transcribe
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var (
+	punctuationMu sync.RWMutex
+	punctuated    map[string]bool // Vendors whose results get their punctuation restored
+)
+
+// questionWords start the English questions ended by a question mark
+var questionWords = map[string]bool{
+	"what": true, "why": true, "how": true, "who": true, "whom": true, "whose": true,
+	"where": true, "when": true, "which": true, "is": true, "are": true, "am": true,
+	"was": true, "were": true, "do": true, "does": true, "did": true, "can": true,
+	"could": true, "would": true, "will": true, "should": true, "shall": true,
+	"may": true, "have": true, "has": true,
+}
+
+// SetPunctuation restores the punctuation and the casing of the results of
+// the vendors, e.g. "baidu" and "vosk" returning lowercase text without
+// punctuation. It applies to the results sent afterwards
+func SetPunctuation(vendors []string) {
+	set := make(map[string]bool)
+	for _, vendor := range vendors {
+		if vendor = strings.ToLower(strings.TrimSpace(vendor)); vendor != "" {
+			set[vendor] = true
+		}
+	}
+	punctuationMu.Lock()
+	punctuated = set
+	punctuationMu.Unlock()
+}
+
+// punctuate restores the punctuation and the casing of the text of the
+// result when it is enabled for the vendor, the server messages are kept
+func punctuate(result Result, vendor string) Result {
+	punctuationMu.RLock()
+	enabled := punctuated[vendor]
+	punctuationMu.RUnlock()
+	if !enabled || result.Code != "" || strings.TrimSpace(result.Text) == "" {
+		return result
+	}
+	result.Text = restorePunctuation(result.Text, result.Language, result.Final)
+	return result
+}
+
+// restorePunctuation capitalizes the sentences of the text and ends the final
+// ones with a period, or a question mark for the English questions. The CJK
+// text is only ended with "。", the text with punctuation only gets its casing
+func restorePunctuation(text, language string, final bool) string {
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	cjk := false
+	for _, r := range runes {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk = true
+			break
+		}
+	}
+	punctuated := strings.ContainsAny(text, ".!?,;。！？，；")
+	last := runes[len(runes)-1]
+	terminate := final && (unicode.IsLetter(last) || unicode.IsNumber(last))
+
+	if cjk {
+		if terminate {
+			text += "。"
+		}
+		return text
+	}
+
+	text = restoreCasing(text)
+	if !terminate || punctuated {
+		return text
+	}
+	words := strings.Fields(strings.ToLower(text))
+	if (language == "" || baseLanguage(language) == "en") && questionWords[words[0]] {
+		return text + "?"
+	}
+	return text + "."
+}
+
+// restoreCasing capitalizes the first letter of the sentences and the
+// pronoun "I", uppercase text is lowered first
+func restoreCasing(text string) string {
+	if strings.ToUpper(text) == text {
+		text = strings.ToLower(text)
+	}
+	words := strings.Fields(text)
+	start := true
+	for i, word := range words {
+		if strings.TrimFunc(word, unicode.IsPunct) == "i" || strings.HasPrefix(word, "i'") {
+			word = strings.Replace(word, "i", "I", 1)
+		}
+		if start {
+			runes := []rune(word)
+			for j, r := range runes {
+				if unicode.IsLetter(r) {
+					runes[j] = unicode.ToUpper(r)
+					break
+				}
+			}
+			word = string(runes)
+		}
+		words[i] = word
+		start = strings.ContainsAny(word[len(word)-1:], ".!?")
+	}
+	return strings.Join(words, " ")
+}
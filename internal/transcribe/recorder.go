@@ -2,15 +2,126 @@ package transcribe
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
+)
+
+// PipelineSampleRate is the rate of the PCM written to the streams, the
+// same as the decoded WebRTC Opus audio
+const PipelineSampleRate = 48000
+
+// PCMBytesPerSecond is the size of one second of the 16-bit mono PCM
+// written to the streams
+const PCMBytesPerSecond = PipelineSampleRate * 2
+
+// recordingFormat is the format of the PCM written to the streams, and by
+// default of the WAV files of the recorder and whisper streams
+var recordingFormat = wav.Format{SampleRate: PipelineSampleRate, Channels: 1, BitsPerSample: 16}
+
+var (
+	recorderMu     sync.RWMutex
+	flushInterval  = time.Second
+	recorderFormat = recordingFormat
 )
 
+// SetRecordingFormat changes the format of the WAV files of the recorder,
+// at a sample rate dividing 48000 (e.g. 8000, 16000 or 48000), with 8, 16,
+// 24 or 32-bit samples, mono or stereo. The sessions can change it with the
+// recorder.sample_rate, recorder.bits and recorder.channels vendor options.
+// It applies to the streams created afterwards
+func SetRecordingFormat(format wav.Format) error {
+	if _, err := newRecordingConverter(format); err != nil {
+		return err
+	}
+	recorderMu.Lock()
+	recorderFormat = format
+	recorderMu.Unlock()
+	return nil
+}
+
+// newRecordingConverter returns the converter of the PCM of the streams to
+// the format
+func newRecordingConverter(format wav.Format) (*audio.Converter, error) {
+	return audio.NewConverter(recordingFormat.SampleRate, format.SampleRate, format.BitsPerSample, format.Channels)
+}
+
+// streamRecordingFormat returns the format of the recording of a stream,
+// the default one changed by its vendor options
+func streamRecordingFormat(opts StreamOptions) (wav.Format, error) {
+	recorderMu.RLock()
+	format := recorderFormat
+	recorderMu.RUnlock()
+	for _, setting := range []struct {
+		name  string
+		value *int
+	}{
+		{"sample_rate", &format.SampleRate},
+		{"bits", &format.BitsPerSample},
+		{"channels", &format.Channels},
+	} {
+		value := opts.vendorOption("recorder", setting.name, "")
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return format, fmt.Errorf("invalid recorder.%s %q", setting.name, value)
+		}
+		*setting.value = n
+	}
+	if _, err := newRecordingConverter(format); err != nil {
+		return format, err
+	}
+	return format, nil
+}
+
+// SetFlushInterval changes how often the recorder and whisper streams sync
+// the audio of their WAV files to the disk, on every write when 0. A crash
+// loses at most the audio of the last interval. It applies to the streams
+// created afterwards
+func SetFlushInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("the flush interval must not be negative, got %v", interval)
+	}
+	recorderMu.Lock()
+	flushInterval = interval
+	recorderMu.Unlock()
+	return nil
+}
+
+// createWAV creates a WAV file of the format, its audio is buffered and
+// synced every flush interval
+func createWAV(path string, format wav.Format) (*wav.File, *wav.Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create WAV file: %w", err)
+	}
+	recorderMu.RLock()
+	buffered := wav.NewFile(file, flushInterval)
+	recorderMu.RUnlock()
+
+	// Write WAV header (will be updated with the sizes on close)
+	writer, err := wav.NewWriter(buffered, format)
+	if err == nil {
+		// The header is on the disk before the audio, for the recovery
+		err = buffered.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path) // Clean up on error
+		return nil, nil, err
+	}
+	return buffered, writer, nil
+}
+
 // RecorderTranscriber is the implementation of the transcribe.Service,
 // it records audio tracks to local WAV files
 type RecorderTranscriber struct {
@@ -23,30 +134,16 @@ type RecorderTranscriber struct {
 // RecorderStream implements the transcribe.Stream interface,
 // it records audio data to a WAV file
 type RecorderStream struct {
-	file     *os.File
-	results  chan Result
-	ctx      context.Context
-	fileName string
-	filePath string
-	mu       sync.Mutex
-	isClosed bool
-}
-
-// WAV file header structure
-type wavHeader struct {
-	ChunkID       [4]byte // "RIFF"
-	ChunkSize     uint32  // File size - 8
-	Format        [4]byte // "WAVE"
-	Subchunk1ID   [4]byte // "fmt "
-	Subchunk1Size uint32  // 16 for PCM
-	AudioFormat   uint16  // 1 for PCM
-	NumChannels   uint16  // 1 for mono
-	SampleRate    uint32  // 48000
-	ByteRate      uint32  // SampleRate * NumChannels * BitsPerSample/8
-	BlockAlign    uint16  // NumChannels * BitsPerSample/8
-	BitsPerSample uint16  // 16
-	Subchunk2ID   [4]byte // "data"
-	Subchunk2Size uint32  // Size of audio data
+	file      *wav.File
+	wav       *wav.Writer
+	converter *audio.Converter
+	results   chan Result
+	ctx       context.Context
+	fileName  string
+	filePath  string
+	locale    string // Language of the result message
+	mu        sync.Mutex
+	isClosed  bool
 }
 
 // CreateStream creates a new recording stream
@@ -54,8 +151,18 @@ func (r *RecorderTranscriber) CreateStream() (Stream, error) {
 	return r.CreateStreamWithOptions(StreamOptions{})
 }
 
-// CreateStreamWithOptions creates a new recording stream (options are ignored for recorder)
+// CreateStreamWithOptions creates a new recording stream, only the locale
+// of the result message and the recorder options are used
 func (r *RecorderTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	format, err := streamRecordingFormat(opts)
+	if err != nil {
+		return nil, err
+	}
+	converter, err := newRecordingConverter(format)
+	if err != nil {
+		return nil, err
+	}
+
 	r.mu.Lock()
 	r.counter++
 	counter := r.counter
@@ -63,7 +170,7 @@ func (r *RecorderTranscriber) CreateStreamWithOptions(opts StreamOptions) (Strea
 
 	// Generate unique filename with timestamp
 	timestamp := time.Now().Format("20060102_150405")
-	fileName := fmt.Sprintf("recording_%s_%03d.wav", timestamp, counter)
+	fileName := fmt.Sprintf("recording_%s_%s%03d.wav", timestamp, sessionTag(opts), counter)
 	filePath := filepath.Join(r.outputDir, fileName)
 
 	// Create output directory if it doesn't exist
@@ -72,121 +179,23 @@ func (r *RecorderTranscriber) CreateStreamWithOptions(opts StreamOptions) (Strea
 	}
 
 	// Create WAV file
-	file, err := os.Create(filePath)
+	file, writer, err := createWAV(filePath, format)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create WAV file: %w", err)
-	}
-
-	// Write WAV header (will be updated later with correct sizes)
-	header := wavHeader{
-		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
-		Format:        [4]byte{'W', 'A', 'V', 'E'},
-		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
-		Subchunk1Size: 16,
-		AudioFormat:   1, // PCM
-		NumChannels:   1, // Mono
-		SampleRate:    48000,
-		BitsPerSample: 16,
-		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
-	}
-
-	// Calculate derived values
-	header.ByteRate = header.SampleRate * uint32(header.NumChannels) * uint32(header.BitsPerSample) / 8
-	header.BlockAlign = header.NumChannels * header.BitsPerSample / 8
-
-	// Write header manually to ensure correct byte order
-	if _, err := file.Write(header.ChunkID[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write ChunkID: %w", err)
-	}
-
-	// Write chunk size (will be updated later)
-	if err := binary.Write(file, binary.LittleEndian, header.ChunkSize); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write ChunkSize: %w", err)
-	}
-
-	// Write format
-	if _, err := file.Write(header.Format[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Format: %w", err)
-	}
-
-	// Write fmt subchunk
-	if _, err := file.Write(header.Subchunk1ID[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk1ID: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.Subchunk1Size); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk1Size: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.AudioFormat); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write AudioFormat: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.NumChannels); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write NumChannels: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.SampleRate); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write SampleRate: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.ByteRate); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write ByteRate: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.BlockAlign); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write BlockAlign: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.BitsPerSample); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write BitsPerSample: %w", err)
-	}
-
-	// Write data subchunk
-	if _, err := file.Write(header.Subchunk2ID[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk2ID: %w", err)
-	}
-
-	// Write Subchunk2Size (will be updated later)
-	if err := binary.Write(file, binary.LittleEndian, header.Subchunk2Size); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk2Size: %w", err)
+		return nil, err
 	}
 
 	stream := &RecorderStream{
-		file:     file,
-		results:  make(chan Result, 1), // Buffered channel to avoid blocking
-		ctx:      r.ctx,
-		fileName: fileName,
-		filePath: filePath,
+		file:      file,
+		wav:       writer,
+		converter: converter,
+		results:   make(chan Result, 1), // Buffered channel to avoid blocking
+		ctx:       r.ctx,
+		fileName:  fileName,
+		filePath:  filePath,
+		locale:    StreamLocale(opts, opts.Language),
 	}
 
-	log.Printf("Started recording to: %s", filePath)
+	log.Printf("Started recording to: %s (%d Hz, %d-bit, %d channels)", filePath, format.SampleRate, format.BitsPerSample, format.Channels)
 	return stream, nil
 }
 
@@ -205,79 +214,18 @@ func (rs *RecorderStream) Close() error {
 	rs.isClosed = true
 	rs.mu.Unlock()
 
-	// Flush any buffered data to disk
-	if err := rs.file.Sync(); err != nil {
-		log.Printf("Warning: failed to sync file: %v", err)
-	}
-
-	// Get current file size
-	fileInfo, err := rs.file.Stat()
-	if err != nil {
-		rs.file.Close()
-		os.Remove(rs.filePath) // Clean up on error
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// Calculate sizes
-	fileSize := uint32(fileInfo.Size())
-
-	// Check if we have enough data for a valid WAV file
-	if fileSize < 44 {
-		rs.file.Close()
-		os.Remove(rs.filePath) // Clean up incomplete file
-		return fmt.Errorf("file too small for WAV header: %d bytes", fileSize)
-	}
-
-	audioDataSize := fileSize - 44 // 44 bytes for WAV header
-
-	// Update chunk size (file size - 8) at position 4
-	chunkSize := fileSize - 8
-
-	// Seek to position 4 (after ChunkID)
-	if _, err := rs.file.Seek(4, 0); err != nil {
-		rs.file.Close()
-		os.Remove(rs.filePath) // Clean up on error
-		return fmt.Errorf("failed to seek to ChunkSize position: %w", err)
-	}
-
-	if err := binary.Write(rs.file, binary.LittleEndian, chunkSize); err != nil {
-		rs.file.Close()
+	// Sync the audio, then the sizes of the WAV header, and close the file
+	if err := rs.file.Finalize(rs.wav); err != nil {
 		os.Remove(rs.filePath) // Clean up on error
-		return fmt.Errorf("failed to update chunk size: %w", err)
-	}
-
-	// Seek to Subchunk2Size position (40 bytes from start)
-	if _, err := rs.file.Seek(40, 0); err != nil {
-		rs.file.Close()
-		os.Remove(rs.filePath) // Clean up on error
-		return fmt.Errorf("failed to seek to Subchunk2Size: %w", err)
-	}
-
-	// Update Subchunk2Size (audio data size)
-	if err := binary.Write(rs.file, binary.LittleEndian, audioDataSize); err != nil {
-		rs.file.Close()
-		os.Remove(rs.filePath) // Clean up on error
-		return fmt.Errorf("failed to update Subchunk2Size: %w", err)
-	}
-
-	// Flush the header updates to disk
-	if err := rs.file.Sync(); err != nil {
-		log.Printf("Warning: failed to sync header updates: %v", err)
-	}
-
-	// Close file
-	if err := rs.file.Close(); err != nil {
-		os.Remove(rs.filePath) // Clean up on error
-		return fmt.Errorf("failed to close file: %w", err)
+		return fmt.Errorf("failed to finalize the WAV file: %w", err)
 	}
+	audioDataSize := rs.wav.Size()
+	fileSize := audioDataSize + wav.HeaderSize
 
 	// Send result with filename
-	rs.results <- Result{
-		Text:       rs.fileName,
-		Confidence: 1.0, // Recording is always successful
-		Final:      true,
-		AudioFile:  rs.filePath,
-	}
+	result := MessageResult(rs.locale, MsgRecordingSaved, rs.fileName)
+	result.AudioFile = rs.filePath
+	sendResult(rs.ctx, rs.results, result, "recorder")
 
 	// Close results channel
 	close(rs.results)
@@ -302,110 +250,15 @@ func (rs *RecorderStream) validateWAVFile() error {
 	}
 	defer file.Close()
 
-	// Read header manually to match how we wrote it
-	var chunkID [4]byte
-	if _, err := file.Read(chunkID[:]); err != nil {
-		return fmt.Errorf("failed to read ChunkID: %w", err)
-	}
-
-	var chunkSize uint32
-	if err := binary.Read(file, binary.LittleEndian, &chunkSize); err != nil {
-		return fmt.Errorf("failed to read ChunkSize: %w", err)
-	}
-
-	var format [4]byte
-	if _, err := file.Read(format[:]); err != nil {
-		return fmt.Errorf("failed to read Format: %w", err)
-	}
-
-	// Skip to fmt subchunk
-	if _, err := file.Seek(12, 0); err != nil {
-		return fmt.Errorf("failed to seek to fmt subchunk: %w", err)
-	}
-
-	var subchunk1ID [4]byte
-	if _, err := file.Read(subchunk1ID[:]); err != nil {
-		return fmt.Errorf("failed to read Subchunk1ID: %w", err)
-	}
-
-	var subchunk1Size uint32
-	if err := binary.Read(file, binary.LittleEndian, &subchunk1Size); err != nil {
-		return fmt.Errorf("failed to read Subchunk1Size: %w", err)
-	}
-
-	var audioFormat uint16
-	if err := binary.Read(file, binary.LittleEndian, &audioFormat); err != nil {
-		return fmt.Errorf("failed to read AudioFormat: %w", err)
-	}
-
-	var numChannels uint16
-	if err := binary.Read(file, binary.LittleEndian, &numChannels); err != nil {
-		return fmt.Errorf("failed to read NumChannels: %w", err)
-	}
-
-	var sampleRate uint32
-	if err := binary.Read(file, binary.LittleEndian, &sampleRate); err != nil {
-		return fmt.Errorf("failed to read SampleRate: %w", err)
-	}
-
-	// Skip ByteRate and BlockAlign
-	if _, err := file.Seek(32, 0); err != nil {
-		return fmt.Errorf("failed to seek to BitsPerSample: %w", err)
-	}
-
-	var bitsPerSample uint16
-	if err := binary.Read(file, binary.LittleEndian, &bitsPerSample); err != nil {
-		return fmt.Errorf("failed to read BitsPerSample: %w", err)
-	}
-
-	// Skip to data subchunk
-	if _, err := file.Seek(36, 0); err != nil {
-		return fmt.Errorf("failed to seek to data subchunk: %w", err)
-	}
-
-	var subchunk2ID [4]byte
-	if _, err := file.Read(subchunk2ID[:]); err != nil {
-		return fmt.Errorf("failed to read Subchunk2ID: %w", err)
-	}
-
-	// Validate RIFF header
-	if string(chunkID[:]) != "RIFF" {
-		return fmt.Errorf("invalid RIFF header: %s", string(chunkID[:]))
-	}
-
-	// Validate WAVE format
-	if string(format[:]) != "WAVE" {
-		return fmt.Errorf("invalid WAVE format: %s", string(format[:]))
-	}
-
-	// Validate fmt subchunk
-	if string(subchunk1ID[:]) != "fmt " {
-		return fmt.Errorf("invalid fmt subchunk: %s", string(subchunk1ID[:]))
-	}
-
-	// Validate data subchunk
-	if string(subchunk2ID[:]) != "data" {
-		return fmt.Errorf("invalid data subchunk: %s", string(subchunk2ID[:]))
-	}
-
-	// Validate audio format (should be PCM = 1)
-	if audioFormat != 1 {
-		return fmt.Errorf("invalid audio format: %d (expected 1 for PCM)", audioFormat)
+	format, dataSize, err := wav.ReadHeader(file)
+	if err != nil {
+		return err
 	}
-
-	// Validate sample rate (should be 48000)
-	if sampleRate != 48000 {
-		return fmt.Errorf("invalid sample rate: %d (expected 48000)", sampleRate)
+	if format != rs.wav.Format() {
+		return fmt.Errorf("invalid format: %+v (expected %+v)", format, rs.wav.Format())
 	}
-
-	// Validate bits per sample (should be 16)
-	if bitsPerSample != 16 {
-		return fmt.Errorf("invalid bits per sample: %d (expected 16)", bitsPerSample)
-	}
-
-	// Validate channels (should be 1 for mono)
-	if numChannels != 1 {
-		return fmt.Errorf("invalid channel count: %d (expected 1)", numChannels)
+	if int64(dataSize) != rs.wav.Size() {
+		return fmt.Errorf("invalid data size: %d (expected %d)", dataSize, rs.wav.Size())
 	}
 
 	log.Printf("WAV file validation passed for %s", rs.fileName)
@@ -426,21 +279,14 @@ func (rs *RecorderStream) Write(buffer []byte) (int, error) {
 		log.Printf("Warning: Odd buffer size %d, audio may be corrupted", len(buffer))
 	}
 
-	// Write audio data directly to file
+	// Write audio data to the file in its format, synced to the disk every
+	// flush interval
 	// Note: We assume the incoming audio is already in the correct format (16-bit PCM, 48kHz, mono)
-	written, err := rs.file.Write(buffer)
-	if err != nil {
-		return written, fmt.Errorf("failed to write audio data: %w", err)
-	}
-
-	// Flush data to disk periodically to ensure it's written
-	if written > 0 {
-		if err := rs.file.Sync(); err != nil {
-			log.Printf("Warning: failed to sync audio data: %v", err)
-		}
+	if _, err := rs.wav.Write(rs.converter.Process(buffer)); err != nil {
+		return 0, fmt.Errorf("failed to write audio data: %w", err)
 	}
 
-	return written, nil
+	return len(buffer), nil
 }
 
 // NewRecorderTranscriber creates a new instance of the transcribe.Service that records
@@ -0,0 +1,155 @@
+package transcribe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
+)
+
+// RecoverRecordings scans dir for WAV files whose header was never finalized,
+// which happens when the server stops in the middle of a session, and repairs
+// their RIFF and data chunk sizes. Files that hold no audio at all are removed.
+// The files modified less than minAge ago are skipped, other replicas sharing
+// the directory may still be writing them. It returns the paths of the
+// repaired files.
+func RecoverRecordings(dir string, minAge time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recordings directory: %w", err)
+	}
+
+	var repaired []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".wav") {
+			continue
+		}
+		if minAge > 0 {
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) < minAge {
+				continue
+			}
+		}
+		filePath := filepath.Join(dir, entry.Name())
+		fixed, err := repairWAVHeader(filePath)
+		if err != nil {
+			log.Printf("Warning: failed to recover %s: %v", filePath, err)
+			continue
+		}
+		if fixed {
+			repaired = append(repaired, filePath)
+		}
+	}
+	return repaired, nil
+}
+
+// repairWAVHeader rewrites the size fields of a WAV file when they don't match
+// the file length, it returns true if the file was repaired
+func repairWAVHeader(filePath string) (bool, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < wav.HeaderSize {
+		return false, fmt.Errorf("file too small for WAV header: %d bytes", info.Size())
+	}
+
+	header := make([]byte, wav.HeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	// Only touch the canonical PCM layout we write ourselves
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" ||
+		string(header[12:16]) != "fmt " || string(header[36:40]) != "data" {
+		return false, nil
+	}
+
+	// Drop a trailing partial sample so the data stays 16-bit aligned
+	audioDataSize := uint32(info.Size()-wav.HeaderSize) &^ 1
+	chunkSize := audioDataSize + wav.HeaderSize - 8
+
+	if binary.LittleEndian.Uint32(header[4:8]) == chunkSize &&
+		binary.LittleEndian.Uint32(header[40:44]) == audioDataSize {
+		return false, nil
+	}
+
+	if audioDataSize == 0 {
+		file.Close()
+		log.Printf("Removing empty orphaned recording: %s", filePath)
+		return false, os.Remove(filePath)
+	}
+
+	if err := file.Truncate(int64(audioDataSize) + wav.HeaderSize); err != nil {
+		return false, fmt.Errorf("failed to truncate file: %w", err)
+	}
+
+	binary.LittleEndian.PutUint32(header[4:8], chunkSize)
+	binary.LittleEndian.PutUint32(header[40:44], audioDataSize)
+	if _, err := file.WriteAt(header[4:8], 4); err != nil {
+		return false, fmt.Errorf("failed to update chunk size: %w", err)
+	}
+	if _, err := file.WriteAt(header[40:44], 40); err != nil {
+		return false, fmt.Errorf("failed to update Subchunk2Size: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		log.Printf("Warning: failed to sync header updates: %v", err)
+	}
+
+	log.Printf("Recovered orphaned recording: %s (Audio: %d bytes)", filePath, audioDataSize)
+	return true, nil
+}
+
+// TranscribeFiles feeds the audio of the given WAV files, one after the other,
+// through a new stream of the service and logs the results
+func TranscribeFiles(service Service, files []string, opts StreamOptions) {
+	for _, filePath := range files {
+		if err := transcribeFile(service, filePath, opts); err != nil {
+			log.Printf("Error transcribing recovered file %s: %v", filePath, err)
+		}
+	}
+}
+
+func transcribeFile(service Service, filePath string, opts StreamOptions) error {
+	// The Whisper recordings may be 16kHz, the streams take the decoded audio
+	pcm, err := audio.DecodeFile(filePath, "ffmpeg", recordingFormat.SampleRate)
+	if err != nil {
+		return err
+	}
+
+	stream, err := service.CreateStreamWithOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	// The results are read while the audio is written, the vendors block
+	// once their results buffer is full
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range stream.Results() {
+			log.Printf("Recovered %s: %v", filepath.Base(filePath), result)
+		}
+	}()
+
+	_, err = io.Copy(stream, bytes.NewReader(pcm))
+	if closeErr := stream.Close(); err == nil {
+		err = closeErr
+	}
+	<-done
+	return err
+}
@@ -0,0 +1,321 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// rivaMethod is the streaming recognition method of riva_asr.proto
+const rivaMethod = "/nvidia.riva.asr.RivaSpeechRecognition/StreamingRecognize"
+
+// rivaLinearPCM is the LINEAR_PCM value of the AudioEncoding enum of Riva
+const rivaLinearPCM = 1
+
+// rivaFlushTimeout bounds the wait for the last results once the stream is closed
+const rivaFlushTimeout = 10 * time.Second
+
+// rivaLanguages maps the languages to the codes of the Riva models
+var rivaLanguages = map[string]string{
+	"en": "en-US", "zh": "zh-CN", "es": "es-US", "de": "de-DE",
+	"fr": "fr-FR", "ja": "ja-JP", "ko": "ko-KR", "ru": "ru-RU",
+	"pt": "pt-BR", "it": "it-IT", "hi": "hi-IN", "ar": "ar-AR",
+}
+
+// RivaTranscriber is the implementation of the transcribe.Service,
+// using the streaming recognition of a NVIDIA Riva server over gRPC
+type RivaTranscriber struct {
+	conn       *grpc.ClientConn
+	server     string
+	model      string
+	sampleRate int // Rate of the PCM sent, 48000 or 16000
+	ctx        context.Context
+}
+
+// RivaStream implements the transcribe.Stream interface,
+// it streams the PCM to one StreamingRecognize call
+type RivaStream struct {
+	stream    grpc.ClientStream
+	cancel    context.CancelFunc
+	results   chan Result
+	ctx       context.Context
+	resampler *audio.Downsampler // nil when sending 48kHz
+	mu        sync.Mutex         // Serializes the sends
+	closed    bool
+	done      chan struct{} // Closed when the receiver returns
+	err       error         // Error ending the call, set before done is closed
+}
+
+// The messages of riva_asr.proto used, encoded by golang/protobuf from the
+// struct tags. The oneof of StreamingRecognizeRequest is encoded as its two
+// optional fields
+
+type rivaStreamingRecognizeRequest struct {
+	StreamingConfig *rivaStreamingRecognitionConfig `protobuf:"bytes,1,opt,name=streaming_config,json=streamingConfig,proto3"`
+	AudioContent    []byte                          `protobuf:"bytes,2,opt,name=audio_content,json=audioContent,proto3"`
+}
+
+func (m *rivaStreamingRecognizeRequest) Reset()         { *m = rivaStreamingRecognizeRequest{} }
+func (m *rivaStreamingRecognizeRequest) String() string { return proto.CompactTextString(m) }
+func (*rivaStreamingRecognizeRequest) ProtoMessage()    {}
+
+type rivaStreamingRecognitionConfig struct {
+	Config         *rivaRecognitionConfig `protobuf:"bytes,1,opt,name=config,proto3"`
+	InterimResults bool                   `protobuf:"varint,2,opt,name=interim_results,json=interimResults,proto3"`
+}
+
+func (m *rivaStreamingRecognitionConfig) Reset()         { *m = rivaStreamingRecognitionConfig{} }
+func (m *rivaStreamingRecognitionConfig) String() string { return proto.CompactTextString(m) }
+func (*rivaStreamingRecognitionConfig) ProtoMessage()    {}
+
+type rivaRecognitionConfig struct {
+	Encoding                   int32  `protobuf:"varint,1,opt,name=encoding,proto3"`
+	SampleRateHertz            int32  `protobuf:"varint,2,opt,name=sample_rate_hertz,json=sampleRateHertz,proto3"`
+	LanguageCode               string `protobuf:"bytes,3,opt,name=language_code,json=languageCode,proto3"`
+	MaxAlternatives            int32  `protobuf:"varint,4,opt,name=max_alternatives,json=maxAlternatives,proto3"`
+	AudioChannelCount          int32  `protobuf:"varint,7,opt,name=audio_channel_count,json=audioChannelCount,proto3"`
+	EnableWordTimeOffsets      bool   `protobuf:"varint,8,opt,name=enable_word_time_offsets,json=enableWordTimeOffsets,proto3"`
+	EnableAutomaticPunctuation bool   `protobuf:"varint,11,opt,name=enable_automatic_punctuation,json=enableAutomaticPunctuation,proto3"`
+	Model                      string `protobuf:"bytes,13,opt,name=model,proto3"`
+}
+
+func (m *rivaRecognitionConfig) Reset()         { *m = rivaRecognitionConfig{} }
+func (m *rivaRecognitionConfig) String() string { return proto.CompactTextString(m) }
+func (*rivaRecognitionConfig) ProtoMessage()    {}
+
+type rivaStreamingRecognizeResponse struct {
+	Results []*rivaStreamingRecognitionResult `protobuf:"bytes,1,rep,name=results,proto3"`
+}
+
+func (m *rivaStreamingRecognizeResponse) Reset()         { *m = rivaStreamingRecognizeResponse{} }
+func (m *rivaStreamingRecognizeResponse) String() string { return proto.CompactTextString(m) }
+func (*rivaStreamingRecognizeResponse) ProtoMessage()    {}
+
+type rivaStreamingRecognitionResult struct {
+	Alternatives []*rivaAlternative `protobuf:"bytes,1,rep,name=alternatives,proto3"`
+	IsFinal      bool               `protobuf:"varint,2,opt,name=is_final,json=isFinal,proto3"`
+	Stability    float32            `protobuf:"fixed32,3,opt,name=stability,proto3"`
+}
+
+func (m *rivaStreamingRecognitionResult) Reset()         { *m = rivaStreamingRecognitionResult{} }
+func (m *rivaStreamingRecognitionResult) String() string { return proto.CompactTextString(m) }
+func (*rivaStreamingRecognitionResult) ProtoMessage()    {}
+
+type rivaAlternative struct {
+	Transcript string          `protobuf:"bytes,1,opt,name=transcript,proto3"`
+	Confidence float32         `protobuf:"fixed32,2,opt,name=confidence,proto3"`
+	Words      []*rivaWordInfo `protobuf:"bytes,3,rep,name=words,proto3"`
+}
+
+func (m *rivaAlternative) Reset()         { *m = rivaAlternative{} }
+func (m *rivaAlternative) String() string { return proto.CompactTextString(m) }
+func (*rivaAlternative) ProtoMessage()    {}
+
+type rivaWordInfo struct {
+	StartTime  int32   `protobuf:"varint,1,opt,name=start_time,json=startTime,proto3"` // Milliseconds
+	EndTime    int32   `protobuf:"varint,2,opt,name=end_time,json=endTime,proto3"`
+	Word       string  `protobuf:"bytes,3,opt,name=word,proto3"`
+	Confidence float32 `protobuf:"fixed32,4,opt,name=confidence,proto3"`
+}
+
+func (m *rivaWordInfo) Reset()         { *m = rivaWordInfo{} }
+func (m *rivaWordInfo) String() string { return proto.CompactTextString(m) }
+func (*rivaWordInfo) ProtoMessage()    {}
+
+// CreateStream creates a new transcription stream
+func (t *RivaTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream in the language of the options,
+// American English when it is empty or "auto"
+func (t *RivaTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	desc := &grpc.StreamDesc{StreamName: "StreamingRecognize", ServerStreams: true, ClientStreams: true}
+	stream, err := t.conn.NewStream(ctx, desc, rivaMethod)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start the Riva recognition on %s: %w", t.server, err)
+	}
+
+	config := &rivaStreamingRecognizeRequest{StreamingConfig: &rivaStreamingRecognitionConfig{
+		Config: &rivaRecognitionConfig{
+			Encoding:                   rivaLinearPCM,
+			SampleRateHertz:            int32(t.sampleRate),
+			LanguageCode:               rivaLanguage(opts.Language),
+			MaxAlternatives:            1,
+			AudioChannelCount:          1,
+			EnableWordTimeOffsets:      true,
+			EnableAutomaticPunctuation: true,
+			Model:                      t.model,
+		},
+		InterimResults: true,
+	}}
+	if err := stream.SendMsg(config); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to send the Riva config: %w", err)
+	}
+
+	rs := &RivaStream{
+		stream:  stream,
+		cancel:  cancel,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		done:    make(chan struct{}),
+	}
+	if t.sampleRate != 48000 {
+		rs.resampler = audio.NewDownsampler(48000, t.sampleRate)
+	}
+	go rs.receiveResults()
+
+	return rs, nil
+}
+
+// rivaLanguage returns the Riva code of the language
+func rivaLanguage(language string) string {
+	if strings.Contains(language, "-") {
+		return language
+	}
+	if code, ok := rivaLanguages[baseLanguage(language)]; ok {
+		return code
+	}
+	return "en-US"
+}
+
+// Results returns a channel that will receive the transcription results
+func (rs *RivaStream) Results() <-chan Result {
+	return rs.results
+}
+
+// Write sends the PCM, resampled when the server expects 16kHz
+func (rs *RivaStream) Write(buffer []byte) (int, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.closed {
+		return 0, fmt.Errorf("riva stream is closed")
+	}
+	pcm := buffer
+	if rs.resampler != nil {
+		pcm = rs.resampler.Process(buffer)
+	}
+	if len(pcm) == 0 {
+		return len(buffer), nil
+	}
+	if err := rs.stream.SendMsg(&rivaStreamingRecognizeRequest{AudioContent: pcm}); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close ends the audio, waits for the last results and ends the call
+func (rs *RivaStream) Close() error {
+	rs.mu.Lock()
+	if rs.closed {
+		rs.mu.Unlock()
+		return nil
+	}
+	rs.closed = true
+	if err := rs.stream.CloseSend(); err != nil {
+		log.Printf("Warning: failed to end the Riva audio: %v", err)
+	}
+	rs.mu.Unlock()
+
+	select {
+	case <-rs.done:
+	case <-time.After(rivaFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Riva results")
+	}
+	rs.cancel()
+	return nil
+}
+
+// receiveResults maps the responses to results until the call ends, then
+// closes the results channel. The interim hypotheses are not final
+func (rs *RivaStream) receiveResults() {
+	defer close(rs.done)
+	defer close(rs.results)
+
+	for {
+		var response rivaStreamingRecognizeResponse
+		if err := rs.stream.RecvMsg(&response); err != nil {
+			if err != io.EOF && rs.ctx.Err() == nil {
+				log.Printf("Riva recognition error: %v", err)
+				rs.err = err
+			}
+			return
+		}
+
+		for _, r := range response.Results {
+			if len(r.Alternatives) == 0 {
+				continue
+			}
+			alternative := r.Alternatives[0]
+			text := strings.TrimSpace(alternative.Transcript)
+			if text == "" {
+				continue
+			}
+			result := Result{Text: text, Confidence: r.Stability, Final: r.IsFinal}
+			if r.IsFinal {
+				result.Confidence = alternative.Confidence
+				for _, w := range alternative.Words {
+					result.Words = append(result.Words, Word{
+						Word:       w.Word,
+						Start:      float64(w.StartTime) / 1000,
+						End:        float64(w.EndTime) / 1000,
+						Confidence: w.Confidence,
+					})
+				}
+			}
+			if !sendResult(rs.ctx, rs.results, result, "riva") && rs.ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// NewRivaTranscriber creates a new instance of the transcribe.Service that uses the
+// Riva server (host:port), over TLS when secure. The PCM is sent at sampleRate, 48000
+// or 16000, and recognized by the model (the default one of the language when empty)
+func NewRivaTranscriber(ctx context.Context, server, model string, sampleRate int, secure bool) (Service, error) {
+	if server == "" {
+		return nil, fmt.Errorf("server is required")
+	}
+	if sampleRate == 0 {
+		sampleRate = 48000
+	}
+	if sampleRate != 48000 && sampleRate != 16000 {
+		return nil, fmt.Errorf("unsupported sample rate %d, use 48000 or 16000", sampleRate)
+	}
+
+	transport := grpc.WithInsecure()
+	if secure {
+		transport = grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, ""))
+	}
+	conn, err := grpc.Dial(server, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Riva at %s: %w", server, err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Printf("Riva transcriber initialized with server: %s, sample rate: %d", server, sampleRate)
+	return &RivaTranscriber{
+		conn:       conn,
+		server:     server,
+		model:      model,
+		sampleRate: sampleRate,
+		ctx:        ctx,
+	}, nil
+}
@@ -1,9 +1,20 @@
 package transcribe
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
 )
 
+// logSampler rate limits the log messages vendors emit for every
+// partial result or response
+var logSampler = logging.NewSampler(10 * time.Second)
+
 // Result is the struct used to serialize the results back to the client
 type Result struct {
 	Text       string  `json:"text"`
@@ -11,20 +22,117 @@ type Result struct {
 	Final      bool    `json:"final"`
 	AudioFile  string  `json:"audio_file,omitempty"`
 	TextFile   string  `json:"text_file,omitempty"`
+	Segment    int     `json:"segment,omitempty"`  // Id of the live segment a partial result revises, an empty text removes it
+	Language   string  `json:"language,omitempty"` // Language detected by the vendor, e.g. "en"
+	Code       string  `json:"code,omitempty"`     // Code of the messages of the server, e.g. MsgTranscriptionError
+	Detail     string  `json:"detail,omitempty"`   // Untranslated detail of a message, e.g. the error
+	Words      []Word  `json:"words,omitempty"`    // Words of the text with their timing, when the vendor provides them
+	Vendor     string  `json:"vendor,omitempty"`   // Vendor of the transcripts, the name given to the tee transcriber when it runs several
+	Speaker    string  `json:"speaker,omitempty"`  // Speaker of most of the words, when the stream is diarized
+	Start      float64 `json:"start,omitempty"`    // Offset of the text in seconds from the start of the session, see TimingTranscriber
+	End        float64 `json:"end,omitempty"`      // Offset of the end of the text, 0 when not timed
+	Duration   float64 `json:"duration,omitempty"` // Seconds of audio from Start to End
+}
+
+// Word is a word of a result with its timing, in seconds from the start
+// of the stream
+type Word struct {
+	Word       string  `json:"word"`
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Confidence float32 `json:"confidence"`
+	Speaker    string  `json:"speaker,omitempty"` // Label of the speaker, e.g. "S1", when the stream is diarized
 }
 
 // StreamOptions contains options for creating a transcription stream
 type StreamOptions struct {
-	Language   string // Language code (e.g., "en", "zh", "auto")
-	Transcribe bool   // Whether to transcribe (if false, just record)
+	Language    string            // Language code (e.g., "en", "zh", "auto")
+	Transcribe  bool              // Whether to transcribe (if false, just record)
+	User        string            // Authenticated user owning the stream
+	Markers     *Markers          // Markers inserted during the session, set by the live hub
+	Completion  *Completion       // How the session ended, set by the ingestion when abnormal
+	Locale      string            // Language of the server messages (e.g. "fr", "zh-CN"), the transcribed language when empty
+	Diarization bool              // Whether to label the speakers of the results, when the vendor or a local diarizer can
+	Task        string            // TaskTranscribe (default) or TaskTranslate to English, Whisper only
+	Prompt      string            // Initial prompt biasing Whisper toward the vocabulary of the session, at most MaxPromptLength characters
+	Extra       map[string]string // Vendor settings keyed "<vendor>.<name>", see VendorOptions
+	SessionID   string            // Identifier of the session, e.g. of its WebRTC connection, in the recording file names and the events
+	Room        string            // Room whose merged transcript the stream joins, none when empty
+	Speaker     string            // Name of the participant in the room transcript
 }
 
+// maxSessionTag bounds the session identifier in the recording file names
+const maxSessionTag = 32
+
+// sessionTag returns the SessionID of opts as a part of a file name followed
+// by an underscore, empty without one
+func sessionTag(opts StreamOptions) string {
+	var tag strings.Builder
+	for _, r := range opts.SessionID {
+		if tag.Len() >= maxSessionTag {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			tag.WriteRune(r)
+		}
+	}
+	if tag.Len() == 0 {
+		return ""
+	}
+	return tag.String() + "_"
+}
+
+// NewSessionID generates a random identifier for a session, the wrapping
+// services identify with it the streams created without a SessionID
+func NewSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ForwardResults forwards the results of the stream wrapped by a service to
+// the returned channel, passing each one to observe first when set. Once
+// the wrapped stream closes its results the channel is closed, then done
+// is called when set
+func ForwardResults(next Stream, observe func(Result), done func()) <-chan Result {
+	results := make(chan Result, resultsBuffer)
+	go func() {
+		for result := range next.Results() {
+			if observe != nil {
+				observe(result)
+			}
+			results <- result
+		}
+		close(results)
+		if done != nil {
+			done()
+		}
+	}()
+	return results
+}
+
+// Tasks of the Whisper streams
+const (
+	TaskTranscribe = "transcribe" // Text in the spoken language
+	TaskTranslate  = "translate"  // English text, from any spoken language
+)
+
+// MaxPromptLength bounds the initial prompt of the Whisper streams, Whisper
+// only keeps its last 224 tokens
+const MaxPromptLength = 1000
+
 // Service is an abstract representation of the transcription service
 type Service interface {
 	CreateStream() (Stream, error)
 	CreateStreamWithOptions(opts StreamOptions) (Stream, error)
 }
 
+// Prober is implemented by the services able to check their credentials
+// or installation without transcribing
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
 // Stream is an abstract representation of a transcription stream
 type Stream interface {
 	io.Writer
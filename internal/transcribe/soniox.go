@@ -0,0 +1,280 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sonioxURL is the realtime transcription WebSocket endpoint of Soniox
+const sonioxURL = "wss://stt-rt.soniox.com/transcribe-websocket"
+
+// sonioxDefaultModel is used when SONIOX_MODEL is not set
+const sonioxDefaultModel = "stt-rt-v3"
+
+// sonioxEndToken is the final token marking an endpoint, the end of an utterance
+const sonioxEndToken = "<end>"
+
+// sonioxFlushTimeout bounds the wait for the last results once the stream is closed
+const sonioxFlushTimeout = 10 * time.Second
+
+// SonioxTranscriber is the implementation of the transcribe.Service,
+// using the Soniox realtime WebSocket API
+type SonioxTranscriber struct {
+	apiKey string
+	model  string
+	ctx    context.Context
+}
+
+// SonioxStream implements the transcribe.Stream interface,
+// it streams the raw PCM to one Soniox session
+type SonioxStream struct {
+	conn    *websocket.Conn
+	results chan Result
+	ctx     context.Context
+	mu      sync.Mutex // Serializes the writes to conn
+	closed  bool
+	done    chan struct{} // Closed when the listener returns
+
+	// The final tokens of the current utterance, owned by the listener
+	final []sonioxToken
+}
+
+// sonioxToken is a piece of a word, with its leading space
+type sonioxToken struct {
+	Text       string  `json:"text"`
+	StartMs    int64   `json:"start_ms"`
+	EndMs      int64   `json:"end_ms"`
+	Confidence float32 `json:"confidence"`
+	IsFinal    bool    `json:"is_final"`
+	Language   string  `json:"language"`
+	Speaker    string  `json:"speaker"` // e.g. "1", when diarized
+}
+
+// sonioxResponse is a message of the realtime API
+type sonioxResponse struct {
+	Tokens       []sonioxToken `json:"tokens"`
+	Finished     bool          `json:"finished"`
+	ErrorCode    int           `json:"error_code"`
+	ErrorMessage string        `json:"error_message"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *SonioxTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream hinted with the language
+// of the options, any language is identified when it is empty or "auto"
+func (t *SonioxTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial(sonioxURL, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to Soniox: HTTP status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to Soniox: %w", err)
+	}
+
+	config := map[string]interface{}{
+		"api_key":                        t.apiKey,
+		"model":                          t.model,
+		"audio_format":                   "pcm_s16le",
+		"sample_rate":                    48000,
+		"num_channels":                   1,
+		"enable_endpoint_detection":      true,
+		"enable_language_identification": true,
+	}
+	if language := baseLanguage(opts.Language); language != "" && language != "auto" {
+		config["language_hints"] = []string{language}
+	}
+	if opts.Diarization {
+		config["enable_speaker_diarization"] = true
+	}
+	if err := conn.WriteJSON(config); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send config: %w", err)
+	}
+
+	stream := &SonioxStream{
+		conn:    conn,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		done:    make(chan struct{}),
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// Results returns a channel that will receive the transcription results
+func (ss *SonioxStream) Results() <-chan Result {
+	return ss.results
+}
+
+// Write sends the raw PCM to Soniox
+func (ss *SonioxStream) Write(buffer []byte) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		return 0, fmt.Errorf("soniox stream is closed")
+	}
+	if err := ss.conn.WriteMessage(websocket.BinaryMessage, buffer); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close ends the audio with an empty message, waits for the last results
+// and closes the connection
+func (ss *SonioxStream) Close() error {
+	ss.mu.Lock()
+	if ss.closed {
+		ss.mu.Unlock()
+		return nil
+	}
+	ss.closed = true
+	if err := ss.conn.WriteMessage(websocket.TextMessage, []byte{}); err != nil {
+		log.Printf("Warning: failed to end the Soniox audio: %v", err)
+	}
+	ss.mu.Unlock()
+
+	select {
+	case <-ss.done:
+	case <-time.After(sonioxFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Soniox results")
+	}
+	return ss.conn.Close()
+}
+
+// listenForResults maps the tokens to results until the session is finished,
+// then closes the results channel. The final tokens are gathered until an
+// endpoint, the non-final ones are sent with them as an interim result
+func (ss *SonioxStream) listenForResults() {
+	defer close(ss.done)
+	defer close(ss.results)
+
+	for {
+		_, message, err := ss.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Soniox WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var response sonioxResponse
+		if err := json.Unmarshal(message, &response); err != nil {
+			logSampler.Printf("soniox:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+		if response.ErrorCode != 0 {
+			log.Printf("Soniox error: %d %s", response.ErrorCode, response.ErrorMessage)
+			return
+		}
+
+		var interim []sonioxToken
+		for _, token := range response.Tokens {
+			switch {
+			case token.Text == sonioxEndToken:
+				if !ss.send(ss.final, true) {
+					return
+				}
+				ss.final = nil
+			case token.IsFinal:
+				ss.final = append(ss.final, token)
+			default:
+				interim = append(interim, token)
+			}
+		}
+		if len(interim) > 0 && !ss.send(append(ss.final[:len(ss.final):len(ss.final)], interim...), false) {
+			return
+		}
+
+		if response.Finished {
+			ss.send(ss.final, true)
+			return
+		}
+	}
+}
+
+// send sends the result of the tokens, if they have any text, and returns
+// false when the stream is done
+func (ss *SonioxStream) send(tokens []sonioxToken, final bool) bool {
+	result, ok := sonioxResult(tokens, final)
+	if !ok {
+		return true
+	}
+	return sendResult(ss.ctx, ss.results, result, "soniox") || ss.ctx.Err() == nil
+}
+
+// sonioxResult joins the tokens into a result, false when they have no text.
+// The tokens starting with a space start the words, the confidence is the
+// mean of the one of the tokens
+func sonioxResult(tokens []sonioxToken, final bool) (Result, bool) {
+	var text strings.Builder
+	for _, token := range tokens {
+		text.WriteString(token.Text)
+	}
+	if strings.TrimSpace(text.String()) == "" {
+		return Result{}, false
+	}
+
+	result := Result{Text: strings.TrimSpace(text.String()), Final: final}
+	var sum float32
+	for _, token := range tokens {
+		sum += token.Confidence
+		if result.Language == "" {
+			result.Language = token.Language
+		}
+		if !final {
+			continue
+		}
+		n := len(result.Words)
+		if n == 0 || strings.HasPrefix(token.Text, " ") {
+			var speaker string
+			if token.Speaker != "" {
+				speaker = "S" + token.Speaker
+			}
+			result.Words = append(result.Words, Word{
+				Word:       strings.TrimSpace(token.Text),
+				Start:      float64(token.StartMs) / 1000,
+				End:        float64(token.EndMs) / 1000,
+				Confidence: token.Confidence,
+				Speaker:    speaker,
+			})
+			continue
+		}
+		w := &result.Words[n-1]
+		w.Word += token.Text
+		w.End = float64(token.EndMs) / 1000
+		if token.Confidence < w.Confidence {
+			w.Confidence = token.Confidence
+		}
+	}
+	result.Confidence = sum / float32(len(tokens))
+	result.Speaker = mainSpeaker(result.Words)
+	return result, true
+}
+
+// NewSonioxTranscriber creates a new instance of the transcribe.Service that uses
+// the Soniox realtime API with the model (stt-rt-v3 when empty)
+func NewSonioxTranscriber(ctx context.Context, apiKey, model string) (Service, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("apiKey is required")
+	}
+	if model == "" {
+		model = sonioxDefaultModel
+	}
+
+	return &SonioxTranscriber{
+		apiKey: apiKey,
+		model:  model,
+		ctx:    ctx,
+	}, nil
+}
@@ -0,0 +1,283 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// speechmaticsDefaultURL is the endpoint of the realtime API used when SPEECHMATICS_URL is not set
+const speechmaticsDefaultURL = "wss://eu2.rt.speechmatics.com/v2"
+
+// speechmaticsFlushTimeout bounds the wait for the last results once the stream is closed
+const speechmaticsFlushTimeout = 10 * time.Second
+
+// speechmaticsLanguages maps the language codes differing from the ones of Speechmatics
+var speechmaticsLanguages = map[string]string{
+	"zh": "cmn",
+}
+
+// SpeechmaticsTranscriber is the implementation of the transcribe.Service,
+// using the Speechmatics realtime WebSocket API
+type SpeechmaticsTranscriber struct {
+	apiKey string
+	url    string
+	ctx    context.Context
+}
+
+// SpeechmaticsStream implements the transcribe.Stream interface,
+// it streams the raw PCM to one Speechmatics session
+type SpeechmaticsStream struct {
+	conn    *websocket.Conn
+	results chan Result
+	ctx     context.Context
+	mu      sync.Mutex // Serializes the writes to conn
+	closed  bool
+	seqNo   int           // Audio messages sent, acknowledged by EndOfStream
+	done    chan struct{} // Closed when the listener returns
+}
+
+// speechmaticsMessage is a message of the realtime API
+type speechmaticsMessage struct {
+	Message  string `json:"message"`
+	Type     string `json:"type"`
+	Reason   string `json:"reason"`
+	Metadata struct {
+		Transcript string `json:"transcript"`
+	} `json:"metadata"`
+	Results []struct {
+		Type         string  `json:"type"` // "word" or "punctuation"
+		StartTime    float64 `json:"start_time"`
+		EndTime      float64 `json:"end_time"`
+		Alternatives []struct {
+			Content    string  `json:"content"`
+			Confidence float32 `json:"confidence"`
+			Language   string  `json:"language"`
+			Speaker    string  `json:"speaker"` // e.g. "S1", "UU" when unknown, when diarized
+		} `json:"alternatives"`
+	} `json:"results"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *SpeechmaticsTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream in the language of the options,
+// English when it is empty or "auto"
+func (t *SpeechmaticsTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial(t.url, http.Header{"Authorization": {"Bearer " + t.apiKey}})
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to Speechmatics: HTTP status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to Speechmatics: %w", err)
+	}
+
+	config := map[string]interface{}{
+		"language":        speechmaticsLanguage(opts.Language),
+		"enable_partials": true,
+		"operating_point": "enhanced",
+	}
+	if opts.Diarization {
+		config["diarization"] = "speaker"
+	}
+	start := map[string]interface{}{
+		"message": "StartRecognition",
+		"audio_format": map[string]interface{}{
+			"type":        "raw",
+			"encoding":    "pcm_s16le",
+			"sample_rate": 48000,
+		},
+		"transcription_config": config,
+	}
+	if err := conn.WriteJSON(start); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start the recognition: %w", err)
+	}
+
+	// No audio may be sent before the recognition started
+	for {
+		var message speechmaticsMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to read the Speechmatics handshake: %w", err)
+		}
+		if message.Message == "RecognitionStarted" {
+			break
+		}
+		if message.Message == "Error" {
+			conn.Close()
+			return nil, fmt.Errorf("Speechmatics error: %s %s", message.Type, message.Reason)
+		}
+	}
+
+	stream := &SpeechmaticsStream{
+		conn:    conn,
+		results: make(chan Result, resultsBuffer),
+		ctx:     t.ctx,
+		done:    make(chan struct{}),
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// speechmaticsLanguage returns the Speechmatics code of the language
+func speechmaticsLanguage(language string) string {
+	language = baseLanguage(language)
+	if language == "" || language == "auto" {
+		return "en"
+	}
+	if code, ok := speechmaticsLanguages[language]; ok {
+		return code
+	}
+	return language
+}
+
+// Results returns a channel that will receive the transcription results
+func (ss *SpeechmaticsStream) Results() <-chan Result {
+	return ss.results
+}
+
+// Write sends the raw PCM to Speechmatics
+func (ss *SpeechmaticsStream) Write(buffer []byte) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		return 0, fmt.Errorf("speechmatics stream is closed")
+	}
+	if err := ss.conn.WriteMessage(websocket.BinaryMessage, buffer); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	ss.seqNo++
+	return len(buffer), nil
+}
+
+// Close ends the audio, waits for the end of the transcript and closes the connection
+func (ss *SpeechmaticsStream) Close() error {
+	ss.mu.Lock()
+	if ss.closed {
+		ss.mu.Unlock()
+		return nil
+	}
+	ss.closed = true
+	end := map[string]interface{}{"message": "EndOfStream", "last_seq_no": ss.seqNo}
+	if err := ss.conn.WriteJSON(end); err != nil {
+		log.Printf("Warning: failed to send EndOfStream to Speechmatics: %v", err)
+	}
+	ss.mu.Unlock()
+
+	select {
+	case <-ss.done:
+	case <-time.After(speechmaticsFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Speechmatics results")
+	}
+	return ss.conn.Close()
+}
+
+// listenForResults maps the transcripts to results until the end of the
+// transcript, then closes the results channel
+func (ss *SpeechmaticsStream) listenForResults() {
+	defer close(ss.done)
+	defer close(ss.results)
+
+	for {
+		_, data, err := ss.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Speechmatics WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var message speechmaticsMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			logSampler.Printf("speechmatics:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+
+		switch message.Message {
+		case "AddPartialTranscript", "AddTranscript":
+			result, ok := message.result()
+			if !ok {
+				continue
+			}
+			if !sendResult(ss.ctx, ss.results, result, "speechmatics") && ss.ctx.Err() != nil {
+				return
+			}
+
+		case "EndOfTranscript":
+			return
+
+		case "Error":
+			log.Printf("Speechmatics error: %s %s", message.Type, message.Reason)
+			return
+
+		case "Warning":
+			logSampler.Printf("speechmatics:warning", "Speechmatics warning: %s %s", message.Type, message.Reason)
+		}
+	}
+}
+
+// result maps a transcript, false when it has no text. The confidence is
+// the mean of the one of its words
+func (m *speechmaticsMessage) result() (Result, bool) {
+	text := strings.TrimSpace(m.Metadata.Transcript)
+	if text == "" {
+		return Result{}, false
+	}
+
+	result := Result{Text: text, Confidence: 0.9, Final: m.Message == "AddTranscript"}
+	var sum float32
+	for _, r := range m.Results {
+		if r.Type != "word" || len(r.Alternatives) == 0 {
+			continue
+		}
+		alternative := r.Alternatives[0]
+		sum += alternative.Confidence
+		if result.Language == "" {
+			result.Language = alternative.Language
+		}
+		speaker := alternative.Speaker
+		if speaker == "UU" {
+			speaker = ""
+		}
+		result.Words = append(result.Words, Word{
+			Word:       alternative.Content,
+			Start:      r.StartTime,
+			End:        r.EndTime,
+			Confidence: alternative.Confidence,
+			Speaker:    speaker,
+		})
+	}
+	result.Speaker = mainSpeaker(result.Words)
+	if len(result.Words) > 0 {
+		result.Confidence = sum / float32(len(result.Words))
+	}
+	return result, true
+}
+
+// NewSpeechmaticsTranscriber creates a new instance of the transcribe.Service that uses
+// the Speechmatics realtime API at url (the EU endpoint when empty)
+func NewSpeechmaticsTranscriber(ctx context.Context, apiKey, url string) (Service, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("apiKey is required")
+	}
+	if url == "" {
+		url = speechmaticsDefaultURL
+	}
+
+	return &SpeechmaticsTranscriber{
+		apiKey: apiKey,
+		url:    url,
+		ctx:    ctx,
+	}, nil
+}
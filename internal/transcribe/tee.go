@@ -0,0 +1,177 @@
+package transcribe
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// TeeTranscriber is the implementation of the transcribe.Service that
+// duplicates the audio of its streams to several vendors at the same time,
+// e.g. to compare them live or to keep a recording while transcribing
+type TeeTranscriber struct {
+	names    []string
+	services []Service
+}
+
+// TeeStream implements the transcribe.Stream interface,
+// it writes the audio to the stream of every vendor and multiplexes
+// their results tagged with the vendor
+type TeeStream struct {
+	names   []string
+	streams []Stream
+	results chan Result
+	mu      sync.Mutex
+	failed  []bool // Vendors whose stream rejected a write, they get no more audio
+	closed  bool
+}
+
+// CreateStream creates a new transcription stream
+func (t *TeeTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a stream of every vendor with the options,
+// it fails when one of them fails
+func (t *TeeTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	streams := make([]Stream, 0, len(t.services))
+	for i, service := range t.services {
+		stream, err := service.CreateStreamWithOptions(opts)
+		if err != nil {
+			for _, s := range streams {
+				s.Close()
+			}
+			return nil, fmt.Errorf("failed to create the %s stream: %w", t.names[i], err)
+		}
+		streams = append(streams, stream)
+	}
+
+	ts := &TeeStream{
+		names:   t.names,
+		streams: streams,
+		results: make(chan Result, resultsBuffer*len(streams)),
+		failed:  make([]bool, len(streams)),
+	}
+	var wg sync.WaitGroup
+	for i := range streams {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ts.forward(i)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		// The results are closed once those of every vendor are forwarded
+		close(ts.results)
+	}()
+
+	return ts, nil
+}
+
+// forward tags the results of the stream of the vendor i until its channel
+// is closed. The segments are renumbered so those of the vendors never clash
+func (ts *TeeStream) forward(i int) {
+	for result := range ts.streams[i].Results() {
+		result.Vendor = ts.names[i]
+		if result.Segment > 0 {
+			result.Segment = (result.Segment-1)*len(ts.streams) + i + 1
+		}
+		// Blocks like the reader of a single vendor would, the vendor stream
+		// applies the backpressure policy to its own channel
+		ts.results <- result
+	}
+}
+
+// Results returns a channel that will receive the results of every vendor
+func (ts *TeeStream) Results() <-chan Result {
+	return ts.results
+}
+
+// Write writes the audio to the stream of every vendor. A vendor failing
+// to take it is dropped, the write only fails when every vendor failed
+func (ts *TeeStream) Write(buffer []byte) (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return 0, fmt.Errorf("tee stream is closed")
+	}
+
+	var lastErr error
+	written := false
+	for i, stream := range ts.streams {
+		if ts.failed[i] {
+			continue
+		}
+		if _, err := stream.Write(buffer); err != nil {
+			log.Printf("Tee: %s stopped taking the audio: %v", ts.names[i], err)
+			ts.failed[i] = true
+			lastErr = err
+			continue
+		}
+		written = true
+	}
+	if !written {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("every vendor failed")
+		}
+		return 0, fmt.Errorf("failed to write the audio to any vendor: %w", lastErr)
+	}
+	return len(buffer), nil
+}
+
+// Close closes the streams of the vendors at the same time, so the flush
+// of the slowest one bounds the wait, and returns their first error
+func (ts *TeeStream) Close() error {
+	ts.mu.Lock()
+	if ts.closed {
+		ts.mu.Unlock()
+		return nil
+	}
+	ts.closed = true
+	ts.mu.Unlock()
+
+	errs := make([]error, len(ts.streams))
+	var wg sync.WaitGroup
+	for i, stream := range ts.streams {
+		wg.Add(1)
+		go func(i int, stream Stream) {
+			defer wg.Done()
+			errs[i] = stream.Close()
+		}(i, stream)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to close the %s stream: %w", ts.names[i], err)
+		}
+	}
+	return nil
+}
+
+// Vendors returns the names of the vendors of the transcriber
+func (t *TeeTranscriber) Vendors() []string {
+	return append([]string(nil), t.names...)
+}
+
+// Services returns the services of the vendors, in the order of Vendors
+func (t *TeeTranscriber) Services() []Service {
+	return append([]Service(nil), t.services...)
+}
+
+// NewTeeTranscriber creates a new instance of the transcribe.Service that
+// transcribes with every service at the same time, names are the vendor tags
+// of their results
+func NewTeeTranscriber(names []string, services []Service) (Service, error) {
+	if len(services) < 2 || len(names) != len(services) {
+		return nil, fmt.Errorf("a tee needs two vendors or more, with their names")
+	}
+
+	log.Printf("Tee transcriber initialized with %s", strings.Join(names, ", "))
+	return &TeeTranscriber{
+		names:    append([]string(nil), names...),
+		services: append([]Service(nil), services...),
+	}, nil
+}
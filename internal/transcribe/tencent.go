@@ -0,0 +1,269 @@
+package transcribe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// tencentHost is the host of the realtime ASR API of Tencent Cloud
+const tencentHost = "asr.cloud.tencent.com"
+
+// tencentSignatureTTL is how long the signature of a connection is valid
+const tencentSignatureTTL = 24 * time.Hour
+
+// tencentFlushTimeout bounds the wait for the last results once the stream is closed
+const tencentFlushTimeout = 10 * time.Second
+
+// tencentEngines maps the languages to the 16kHz engines of Tencent Cloud
+var tencentEngines = map[string]string{
+	"zh": "16k_zh", "en": "16k_en", "ja": "16k_ja", "ko": "16k_ko",
+	"yue": "16k_yue", "vi": "16k_vi", "ms": "16k_ms", "id": "16k_id",
+	"fil": "16k_fil", "th": "16k_th", "pt": "16k_pt", "tr": "16k_tr",
+	"ar": "16k_ar", "es": "16k_es", "hi": "16k_hi", "fr": "16k_fr",
+	"de": "16k_de",
+}
+
+// TencentTranscriber is the implementation of the transcribe.Service,
+// using the realtime ASR WebSocket API of Tencent Cloud
+type TencentTranscriber struct {
+	appID     string
+	secretID  string
+	secretKey string
+	engine    string // Engine of all the streams, by language when empty
+	ctx       context.Context
+}
+
+// TencentStream implements the transcribe.Stream interface,
+// it streams the PCM resampled to 16kHz to one Tencent Cloud connection
+type TencentStream struct {
+	conn      *websocket.Conn
+	results   chan Result
+	ctx       context.Context
+	resampler *audio.Downsampler
+	mu        sync.Mutex // Serializes the writes to conn
+	closed    bool
+	done      chan struct{} // Closed when the listener returns
+}
+
+// tencentResponse is a message of the realtime ASR API
+type tencentResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Final   int    `json:"final"`
+	Result  struct {
+		SliceType    int    `json:"slice_type"` // 0 sentence start, 1 partial, 2 sentence end
+		VoiceTextStr string `json:"voice_text_str"`
+		WordList     []struct {
+			Word      string `json:"word"`
+			StartTime int64  `json:"start_time"` // Milliseconds
+			EndTime   int64  `json:"end_time"`
+		} `json:"word_list"`
+	} `json:"result"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *TencentTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream with the engine of the language of the options
+func (t *TencentTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial(t.streamURL(t.streamEngine(opts.Language)), nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to Tencent Cloud ASR: HTTP status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to connect to Tencent Cloud ASR: %w", err)
+	}
+
+	// The handshake is acknowledged, or refused, by a first message
+	var response tencentResponse
+	if err := conn.ReadJSON(&response); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read the Tencent Cloud ASR handshake: %w", err)
+	}
+	if response.Code != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("Tencent Cloud ASR error: %d %s", response.Code, response.Message)
+	}
+
+	stream := &TencentStream{
+		conn:      conn,
+		results:   make(chan Result, resultsBuffer),
+		ctx:       t.ctx,
+		resampler: audio.NewDownsampler(48000, 16000),
+		done:      make(chan struct{}),
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// streamEngine returns the engine of the language, Mandarin for the unknown ones
+func (t *TencentTranscriber) streamEngine(language string) string {
+	if t.engine != "" {
+		return t.engine
+	}
+	if engine, ok := tencentEngines[baseLanguage(language)]; ok {
+		return engine
+	}
+	return "16k_zh"
+}
+
+// streamURL returns the signed URL of a connection transcribing with the engine
+func (t *TencentTranscriber) streamURL(engine string) string {
+	now := time.Now()
+	params := map[string]string{
+		"secretid":          t.secretID,
+		"timestamp":         strconv.FormatInt(now.Unix(), 10),
+		"expired":           strconv.FormatInt(now.Add(tencentSignatureTTL).Unix(), 10),
+		"nonce":             strconv.Itoa(rand.Intn(1000000000)),
+		"engine_model_type": engine,
+		"voice_id":          hexID(),
+		"voice_format":      "1", // PCM
+		"needvad":           "1",
+		"word_info":         "1",
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + params[k]
+	}
+	path := tencentHost + "/asr/v2/" + t.appID + "?" + strings.Join(pairs, "&")
+
+	mac := hmac.New(sha1.New, []byte(t.secretKey))
+	mac.Write([]byte(path))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return "wss://" + path + "&signature=" + url.QueryEscape(signature)
+}
+
+// Results returns a channel that will receive the transcription results
+func (ts *TencentStream) Results() <-chan Result {
+	return ts.results
+}
+
+// Write sends the PCM resampled to 16kHz to Tencent Cloud
+func (ts *TencentStream) Write(buffer []byte) (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return 0, fmt.Errorf("tencent stream is closed")
+	}
+	pcm := ts.resampler.Process(buffer)
+	if len(pcm) == 0 {
+		return len(buffer), nil
+	}
+	if err := ts.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close sends the end of the audio, waits for the last results and closes the connection
+func (ts *TencentStream) Close() error {
+	ts.mu.Lock()
+	if ts.closed {
+		ts.mu.Unlock()
+		return nil
+	}
+	ts.closed = true
+	if err := ts.conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "end"}`)); err != nil {
+		log.Printf("Warning: failed to send end to Tencent Cloud ASR: %v", err)
+	}
+	ts.mu.Unlock()
+
+	select {
+	case <-ts.done:
+	case <-time.After(tencentFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Tencent Cloud results")
+	}
+	return ts.conn.Close()
+}
+
+// listenForResults maps the Tencent Cloud messages to results until the
+// final one, then closes the results channel
+func (ts *TencentStream) listenForResults() {
+	defer close(ts.done)
+	defer close(ts.results)
+
+	for {
+		_, message, err := ts.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Tencent Cloud WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var response tencentResponse
+		if err := json.Unmarshal(message, &response); err != nil {
+			logSampler.Printf("tencent:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+		if response.Code != 0 {
+			log.Printf("Tencent Cloud ASR error: %d %s", response.Code, response.Message)
+			return
+		}
+		if response.Final == 1 {
+			return
+		}
+
+		text := strings.TrimSpace(response.Result.VoiceTextStr)
+		if text == "" {
+			continue
+		}
+		// Tencent Cloud gives no confidence
+		result := Result{Text: text, Confidence: 0.8}
+		if response.Result.SliceType == 2 {
+			result.Confidence = 0.9
+			result.Final = true
+			for _, w := range response.Result.WordList {
+				result.Words = append(result.Words, Word{
+					Word:       w.Word,
+					Start:      float64(w.StartTime) / 1000,
+					End:        float64(w.EndTime) / 1000,
+					Confidence: result.Confidence,
+				})
+			}
+		}
+		if !sendResult(ts.ctx, ts.results, result, "tencent") && ts.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// NewTencentTranscriber creates a new instance of the transcribe.Service that uses
+// the realtime ASR of Tencent Cloud, with the engine (e.g. "16k_zh") of the language
+// of each stream when engine is empty
+func NewTencentTranscriber(ctx context.Context, appID, secretID, secretKey, engine string) (Service, error) {
+	if appID == "" || secretID == "" || secretKey == "" {
+		return nil, fmt.Errorf("appID, secretID and secretKey are required")
+	}
+
+	return &TencentTranscriber{
+		appID:     appID,
+		secretID:  secretID,
+		secretKey: secretKey,
+		engine:    engine,
+		ctx:       ctx,
+	}, nil
+}
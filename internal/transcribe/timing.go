@@ -0,0 +1,101 @@
+package transcribe
+
+import "sync"
+
+// TimingTranscriber is the implementation of the transcribe.Service that
+// sets the offsets of the results of the service it wraps, in seconds from
+// the start of the session, so the clients can lay them out on a timeline.
+// The results are timed by their words, the others span from the end of the
+// last final result to the audio received when they arrived
+type TimingTranscriber struct {
+	next Service
+}
+
+// TimingStream implements the transcribe.Stream interface,
+// it counts the audio written to the stream it wraps
+type TimingStream struct {
+	next    Stream
+	results chan Result
+
+	mu       sync.Mutex
+	received int // Bytes of audio written
+
+	// Owned by the forwarder
+	lastEnd float64 // End of the last final result
+}
+
+// CreateStream creates a new timed stream
+func (t *TimingTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new timed stream of the wrapped service
+func (t *TimingTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	next, err := t.next.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	ts := &TimingStream{
+		next:    next,
+		results: make(chan Result, resultsBuffer),
+	}
+	go ts.forwardResults()
+	return ts, nil
+}
+
+func (ts *TimingStream) forwardResults() {
+	defer close(ts.results)
+	for result := range ts.next.Results() {
+		ts.results <- ts.time(result)
+	}
+}
+
+// time sets the offsets of the result when the vendor did not, the server
+// messages are not timed
+func (ts *TimingStream) time(result Result) Result {
+	if result.Code != "" || result.End > 0 || result.Text == "" {
+		return result
+	}
+	if len(result.Words) > 0 {
+		result.Start = result.Words[0].Start
+		result.End = result.Words[len(result.Words)-1].End
+	} else {
+		ts.mu.Lock()
+		received := float64(ts.received) / PCMBytesPerSecond
+		ts.mu.Unlock()
+		result.Start, result.End = ts.lastEnd, received
+		if result.End < result.Start {
+			result.End = result.Start
+		}
+	}
+	result.Duration = result.End - result.Start
+	if result.Final && result.End > ts.lastEnd {
+		ts.lastEnd = result.End
+	}
+	return result
+}
+
+// Results returns a channel that will receive the timed results
+func (ts *TimingStream) Results() <-chan Result {
+	return ts.results
+}
+
+// Write writes the audio to the wrapped stream and counts it
+func (ts *TimingStream) Write(buffer []byte) (int, error) {
+	n, err := ts.next.Write(buffer)
+	ts.mu.Lock()
+	ts.received += n
+	ts.mu.Unlock()
+	return n, err
+}
+
+// Close closes the wrapped stream
+func (ts *TimingStream) Close() error {
+	return ts.next.Close()
+}
+
+// NewTimingTranscriber creates a new instance of the transcribe.Service that
+// sets the offsets of the results of next
+func NewTimingTranscriber(next Service) Service {
+	return &TimingTranscriber{next: next}
+}
@@ -0,0 +1,235 @@
+package transcribe
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// vadPreroll is the audio kept before the speech restarts, written to the
+// stream of the next utterance so its first word is not cut
+const vadPreroll = 300 * time.Millisecond
+
+// VADConfig holds the settings of the segmentation at the pauses
+type VADConfig struct {
+	Threshold    float64       // Level of the speech in dBFS, e.g. -40
+	Silence      time.Duration // Pause ending an utterance
+	MinSpeech    time.Duration // Speech of an utterance before a pause can end it
+	MaxUtterance time.Duration // Utterances are cut after it even without a pause
+}
+
+// VADTranscriber is the implementation of the transcribe.Service that
+// segments the streams of the users it is enabled for at their pauses, each
+// utterance is transcribed by its own stream of the service it wraps. The
+// vendors transcribing on close, like Whisper, then send a result per
+// utterance during the session, the recorder saves a file per utterance
+type VADTranscriber struct {
+	next    Service
+	config  VADConfig
+	enabled func(user string) bool
+}
+
+// VADStream implements the transcribe.Stream interface,
+// it writes the audio to the stream of the current utterance and forwards
+// the results of the utterances in order
+type VADStream struct {
+	service Service
+	opts    StreamOptions
+	config  VADConfig
+	vad     *audio.VAD
+	results chan Result
+
+	mu        sync.Mutex
+	closed    bool
+	current   Stream // Stream of the utterance, nil during the pauses
+	preroll   []byte // Last audio of the pause
+	speech    time.Duration
+	silence   time.Duration
+	utterance time.Duration
+	received  time.Duration  // Audio written to the stream
+	closing   sync.WaitGroup // Streams of the ended utterances being closed
+	err       error          // First error closing an utterance
+	forwarded chan struct{}  // Closed once the results of the last utterance are forwarded
+
+	// Owned by the forwarders, which run one after the other
+	segments int // Last segment id forwarded
+}
+
+// CreateStream creates a new transcription stream
+func (t *VADTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates the stream of the first utterance, the
+// streams of the users not enabled are the ones of the wrapped service
+func (t *VADTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	if !t.enabled(opts.User) {
+		return t.next.CreateStreamWithOptions(opts)
+	}
+
+	stream, err := t.next.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	vs := &VADStream{
+		service:   t.next,
+		opts:      opts,
+		config:    t.config,
+		vad:       audio.NewVAD(recordingFormat.SampleRate, t.config.Threshold),
+		results:   make(chan Result, resultsBuffer),
+		forwarded: make(chan struct{}),
+	}
+	close(vs.forwarded)
+	vs.start(stream, 0)
+	return vs, nil
+}
+
+// start makes the stream the one of the current utterance starting at
+// offset in the stream, its results are forwarded once those of the
+// previous utterances are, the caller holds mu
+func (vs *VADStream) start(stream Stream, offset time.Duration) {
+	previous := vs.forwarded
+	forwarded := make(chan struct{})
+	vs.forwarded = forwarded
+	vs.current = stream
+	vs.speech, vs.silence, vs.utterance = 0, 0, 0
+
+	go func() {
+		defer close(forwarded)
+		<-previous
+		base := vs.segments
+		for result := range stream.Results() {
+			// The segments of the utterances never clash
+			if result.Segment > 0 {
+				result.Segment += base
+				if result.Segment > vs.segments {
+					vs.segments = result.Segment
+				}
+			}
+			// The words are timed from the start of the utterance
+			if len(result.Words) > 0 && offset > 0 {
+				words := make([]Word, len(result.Words))
+				for i, word := range result.Words {
+					word.Start += offset.Seconds()
+					word.End += offset.Seconds()
+					words[i] = word
+				}
+				result.Words = words
+			}
+			vs.results <- result
+		}
+	}()
+}
+
+// Results returns a channel that will receive the results of every utterance
+func (vs *VADStream) Results() <-chan Result {
+	return vs.results
+}
+
+// Write writes the audio to the stream of the current utterance, which ends
+// at the first pause once it had enough speech. The audio of the pauses
+// between the utterances is dropped, but for the preroll of the next one
+func (vs *VADStream) Write(buffer []byte) (int, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if vs.closed {
+		return 0, fmt.Errorf("vad stream is closed")
+	}
+
+	frames := vs.vad.Process(buffer)
+	vs.received += time.Duration(len(buffer)) * time.Second / PCMBytesPerSecond
+	if vs.current == nil {
+		speech := false
+		for _, s := range frames {
+			speech = speech || s
+		}
+		vs.preroll = append(vs.preroll, buffer...)
+		if keep := int(vadPreroll.Seconds()*PCMBytesPerSecond) &^ 1; len(vs.preroll) > keep {
+			vs.preroll = append([]byte(nil), vs.preroll[len(vs.preroll)-keep:]...)
+		}
+		if !speech {
+			return len(buffer), nil
+		}
+		stream, err := vs.service.CreateStreamWithOptions(vs.opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create the stream of the utterance: %w", err)
+		}
+		vs.start(stream, vs.received-time.Duration(len(vs.preroll))*time.Second/PCMBytesPerSecond)
+		buffer, vs.preroll = vs.preroll, nil
+	}
+
+	if _, err := vs.current.Write(buffer); err != nil {
+		return 0, err
+	}
+	for _, speech := range frames {
+		vs.utterance += audio.VADFrame
+		if speech {
+			vs.speech += audio.VADFrame
+			vs.silence = 0
+		} else {
+			vs.silence += audio.VADFrame
+		}
+	}
+	if vs.speech >= vs.config.MinSpeech && vs.silence >= vs.config.Silence ||
+		vs.config.MaxUtterance > 0 && vs.utterance >= vs.config.MaxUtterance {
+		vs.end()
+	}
+	return len(buffer), nil
+}
+
+// end closes the stream of the current utterance in the background, the
+// caller holds mu
+func (vs *VADStream) end() {
+	stream := vs.current
+	vs.current = nil
+	vs.closing.Add(1)
+	go func() {
+		defer vs.closing.Done()
+		if err := stream.Close(); err != nil {
+			log.Printf("Warning: failed to close the stream of an utterance: %v", err)
+			vs.mu.Lock()
+			if vs.err == nil {
+				vs.err = err
+			}
+			vs.mu.Unlock()
+		}
+	}()
+}
+
+// Close ends the last utterance, waits for the results of every utterance
+// and returns the first error closing them
+func (vs *VADStream) Close() error {
+	vs.mu.Lock()
+	if vs.closed {
+		vs.mu.Unlock()
+		return nil
+	}
+	vs.closed = true
+	if vs.current != nil {
+		vs.end()
+	}
+	vs.mu.Unlock()
+
+	vs.closing.Wait()
+	vs.mu.Lock()
+	forwarded, err := vs.forwarded, vs.err
+	vs.mu.Unlock()
+	<-forwarded
+	close(vs.results)
+	return err
+}
+
+// NewVADTranscriber creates a new instance of the transcribe.Service that segments
+// the streams of next at their pauses for the users enabled
+func NewVADTranscriber(next Service, config VADConfig, enabled func(user string) bool) (Service, error) {
+	if config.Silence <= 0 {
+		return nil, fmt.Errorf("the silence ending an utterance must be positive, got %v", config.Silence)
+	}
+	if config.Threshold >= 0 {
+		return nil, fmt.Errorf("the speech threshold must be negative dBFS, got %v", config.Threshold)
+	}
+	return &VADTranscriber{next: next, config: config, enabled: enabled}, nil
+}
@@ -0,0 +1,603 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultOutputDir receives the recordings when no output directory is given
+const defaultOutputDir = "recordings"
+
+// SelectVendor selects the appropriate transcription service based on command line arguments
+// and available credentials. Command line arguments take precedence over environment variables.
+//
+// Priority Order (when --vendor is specified):
+// 1. Command line --vendor flag (highest priority)
+// 2. Google Speech (if --google.cred flag provided)
+// 3. Environment variable based selection (fallback)
+//
+// Supported vendors: google, azure, baidu, xunfei, aliyun, tencent, deepgram, speechmatics, soniox, riva, kaldi, vosk, coqui, openai, whisper, recorder, mock, exec, grpc
+//
+// A comma separated list of vendors, e.g. "whisper,google", transcribes with all of them
+// at the same time, their results tagged with the vendor. A chain of vendors, e.g.
+// "azure>whisper>recorder", transcribes with the first one and fails over to the next ones
+func SelectVendor(ctx context.Context, googleCred, vendor, model, output, language string, keepWav, keepTxt bool) (Service, error) {
+	return SelectVendorEnv(ctx, os.Getenv, googleCred, vendor, model, output, language, keepWav, keepTxt)
+}
+
+// SelectVendorEnv is SelectVendor reading the credentials with getenv
+// instead of from the environment, e.g. those of a tenant
+func SelectVendorEnv(ctx context.Context, getenv func(string) string, googleCred, vendor, model, output, language string, keepWav, keepTxt bool) (Service, error) {
+	// A list of vendors transcribes with all of them at the same time
+	if strings.Contains(vendor, ",") {
+		return selectTee(ctx, getenv, googleCred, vendor, model, output, language, keepWav, keepTxt)
+	}
+	// A chain of vendors transcribes with the first one, then the next ones when it fails
+	if strings.Contains(vendor, ">") {
+		return selectFailover(ctx, getenv, googleCred, vendor, model, output, language, keepWav, keepTxt)
+	}
+
+	// If vendor is specified via command line, use it directly
+	if vendor != "" {
+		switch vendor {
+		case "google":
+			if googleCred == "" {
+				return nil, fmt.Errorf("--vendor=google requires --google.cred flag")
+			}
+			tr, err := NewGoogleSpeech(ctx, googleCred, getenv("GOOGLE_SPEECH_LOCATION"), getenv("GOOGLE_SPEECH_MODEL"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Google Speech service: %w", err)
+			}
+			log.Printf("Using Google Speech service (via --vendor flag)")
+			return tr, nil
+
+		case "azure":
+			azureKey := getenv("AZURE_SPEECH_KEY")
+			azureRegion := getenv("AZURE_SPEECH_REGION")
+			if azureKey == "" || azureRegion == "" {
+				return nil, fmt.Errorf("--vendor=azure requires AZURE_SPEECH_KEY and AZURE_SPEECH_REGION environment variables")
+			}
+			tr, err := NewAzureTranscriber(ctx, azureKey, azureRegion)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Azure Speech service: %w", err)
+			}
+			log.Printf("Using Azure Speech service (via --vendor flag, region: %s)", azureRegion)
+			return tr, nil
+
+		case "baidu":
+			baiduAppID := getenv("BAIDU_APP_ID")
+			baiduApiKey := getenv("BAIDU_API_KEY")
+			baiduSecretKey := getenv("BAIDU_SECRET_KEY")
+			if baiduAppID == "" || baiduApiKey == "" || baiduSecretKey == "" {
+				return nil, fmt.Errorf("--vendor=baidu requires BAIDU_APP_ID, BAIDU_API_KEY, and BAIDU_SECRET_KEY environment variables")
+			}
+			tr, err := NewBaiduTranscriber(ctx, baiduAppID, baiduApiKey, baiduSecretKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Baidu Speech service: %w", err)
+			}
+			log.Printf("Using Baidu Speech service (via --vendor flag)")
+			return tr, nil
+
+		case "xunfei":
+			appID := getenv("XUNFEI_APP_ID")
+			apiKey := getenv("XUNFEI_API_KEY")
+			apiSecret := getenv("XUNFEI_API_SECRET")
+			appUrl := getenv("XUNFEI_API_URL")
+			if appID == "" || apiKey == "" || apiSecret == "" {
+				return nil, fmt.Errorf("--vendor=xunfei requires XUNFEI_APP_ID, XUNFEI_API_KEY, and XUNFEI_API_SECRET environment variables")
+			}
+			tr, err := NewIflyTekTranscriber(ctx, appID, apiKey, apiSecret, appUrl)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Xunfei service: %w", err)
+			}
+			log.Printf("Using Xunfei (IflyTek) service (via --vendor flag)")
+			return tr, nil
+
+		case "aliyun":
+			aliyunKeyID := getenv("ALIYUN_ACCESS_KEY_ID")
+			aliyunKeySecret := getenv("ALIYUN_ACCESS_KEY_SECRET")
+			aliyunAppKey := getenv("ALIYUN_NLS_APPKEY")
+			if aliyunKeyID == "" || aliyunKeySecret == "" || aliyunAppKey == "" {
+				return nil, fmt.Errorf("--vendor=aliyun requires ALIYUN_ACCESS_KEY_ID, ALIYUN_ACCESS_KEY_SECRET and ALIYUN_NLS_APPKEY environment variables")
+			}
+			tr, err := NewAliyunTranscriber(ctx, aliyunKeyID, aliyunKeySecret, aliyunAppKey, getenv("ALIYUN_NLS_REGION"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Aliyun NLS service: %w", err)
+			}
+			log.Printf("Using Aliyun NLS service (via --vendor flag)")
+			return tr, nil
+
+		case "tencent":
+			tencentAppID := getenv("TENCENT_APP_ID")
+			tencentSecretID := getenv("TENCENT_SECRET_ID")
+			tencentSecretKey := getenv("TENCENT_SECRET_KEY")
+			if tencentAppID == "" || tencentSecretID == "" || tencentSecretKey == "" {
+				return nil, fmt.Errorf("--vendor=tencent requires TENCENT_APP_ID, TENCENT_SECRET_ID and TENCENT_SECRET_KEY environment variables")
+			}
+			tr, err := NewTencentTranscriber(ctx, tencentAppID, tencentSecretID, tencentSecretKey, getenv("TENCENT_ASR_ENGINE"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Tencent Cloud ASR service: %w", err)
+			}
+			log.Printf("Using Tencent Cloud ASR service (via --vendor flag)")
+			return tr, nil
+
+		case "deepgram":
+			deepgramKey := getenv("DEEPGRAM_API_KEY")
+			if deepgramKey == "" {
+				return nil, fmt.Errorf("--vendor=deepgram requires DEEPGRAM_API_KEY environment variable")
+			}
+			tr, err := NewDeepgramTranscriber(ctx, deepgramKey, getenv("DEEPGRAM_MODEL"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Deepgram service: %w", err)
+			}
+			log.Printf("Using Deepgram service (via --vendor flag)")
+			return tr, nil
+
+		case "speechmatics":
+			speechmaticsKey := getenv("SPEECHMATICS_API_KEY")
+			if speechmaticsKey == "" {
+				return nil, fmt.Errorf("--vendor=speechmatics requires SPEECHMATICS_API_KEY environment variable")
+			}
+			tr, err := NewSpeechmaticsTranscriber(ctx, speechmaticsKey, getenv("SPEECHMATICS_URL"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Speechmatics service: %w", err)
+			}
+			log.Printf("Using Speechmatics service (via --vendor flag)")
+			return tr, nil
+
+		case "soniox":
+			sonioxKey := getenv("SONIOX_API_KEY")
+			if sonioxKey == "" {
+				return nil, fmt.Errorf("--vendor=soniox requires SONIOX_API_KEY environment variable")
+			}
+			tr, err := NewSonioxTranscriber(ctx, sonioxKey, getenv("SONIOX_MODEL"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Soniox service: %w", err)
+			}
+			log.Printf("Using Soniox service (via --vendor flag)")
+			return tr, nil
+
+		case "riva":
+			rivaServer := getenv("RIVA_SERVER")
+			if rivaServer == "" {
+				return nil, fmt.Errorf("--vendor=riva requires RIVA_SERVER environment variable")
+			}
+			rivaRate, _ := strconv.Atoi(getenv("RIVA_SAMPLE_RATE"))
+			tr, err := NewRivaTranscriber(ctx, rivaServer, getenv("RIVA_MODEL"), rivaRate, getenv("RIVA_SSL") == "true")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Riva service: %w", err)
+			}
+			log.Printf("Using Riva service (via --vendor flag)")
+			return tr, nil
+
+		case "kaldi":
+			kaldiURL := getenv("KALDI_SERVER_URL")
+			if kaldiURL == "" {
+				return nil, fmt.Errorf("--vendor=kaldi requires KALDI_SERVER_URL environment variable")
+			}
+			kaldiRate, _ := strconv.Atoi(getenv("KALDI_SAMPLE_RATE"))
+			tr, err := NewKaldiTranscriber(ctx, kaldiURL, kaldiRate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Kaldi service: %w", err)
+			}
+			log.Printf("Using Kaldi service (via --vendor flag)")
+			return tr, nil
+
+		case "vosk":
+			voskModel := getenv("VOSK_MODEL_PATH")
+			voskURL := getenv("VOSK_SERVER_URL")
+			if voskModel == "" && voskURL == "" {
+				return nil, fmt.Errorf("--vendor=vosk requires VOSK_MODEL_PATH (or VOSK_SERVER_URL) environment variable")
+			}
+			tr, err := NewVoskTranscriber(ctx, voskModel, voskURL, getenv("VOSK_SERVER_PATH"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Vosk service: %w", err)
+			}
+			log.Printf("Using Vosk service (via --vendor flag)")
+			return tr, nil
+
+		case "coqui":
+			coquiModel := getenv("COQUI_MODEL_PATH")
+			if coquiModel == "" {
+				return nil, fmt.Errorf("--vendor=coqui requires COQUI_MODEL_PATH environment variable")
+			}
+			outputDir := output
+			if outputDir == "" {
+				outputDir = "./recordings"
+			}
+			tr, err := NewCoquiTranscriber(ctx, coquiModel, getenv("COQUI_SCORER_PATH"), getenv("COQUI_STT_PATH"), outputDir, keepWav, keepTxt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Coqui STT service: %w", err)
+			}
+			log.Printf("Using Coqui STT service (via --vendor flag, output: %s)", outputDir)
+			return tr, nil
+
+		case "openai":
+			openaiKey := getenv("OPENAI_API_KEY")
+			if openaiKey == "" {
+				return nil, fmt.Errorf("--vendor=openai requires OPENAI_API_KEY environment variable")
+			}
+			outputDir := output
+			if outputDir == "" {
+				outputDir = "./recordings"
+			}
+			tr, err := NewOpenAITranscriber(ctx, openaiKey, getenv("OPENAI_BASE_URL"), getenv("OPENAI_TRANSCRIBE_MODEL"), outputDir, language, keepWav, keepTxt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create OpenAI transcription service: %w", err)
+			}
+			log.Printf("Using OpenAI transcription service (via --vendor flag, language: %s, output: %s)", language, outputDir)
+			return tr, nil
+
+		case "whisper":
+			// Use command line arguments for Whisper
+			whisperModelPath := model
+			whisperPath := getenv("WHISPER_PATH")
+			outputDir := output
+			if outputDir == "" {
+				outputDir = "./recordings"
+			}
+
+			// A Whisper server keeps the model loaded between the streams
+			if serverURL := getenv("WHISPER_SERVER_URL"); serverURL != "" {
+				tr, err := NewWhisperServerTranscriber(ctx, serverURL, getenv("WHISPER_SERVER_API"), getenv("WHISPER_SERVER_KEY"), getenv("WHISPER_SERVER_MODEL"), outputDir, language, keepWav, keepTxt)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create Whisper service: %w", err)
+				}
+				log.Printf("Using Whisper service (via --vendor flag, Whisper server: %s, language: %s, output: %s)", serverURL, language, outputDir)
+				return tr, nil
+			}
+			// The whisper.cpp bindings keep the model loaded in the process
+			if cppModel := getenv("WHISPER_CPP_MODEL"); cppModel != "" {
+				tr, err := NewWhisperNativeTranscriber(ctx, cppModel, outputDir, language, keepWav, keepTxt)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create Whisper service: %w", err)
+				}
+				log.Printf("Using Whisper service (via --vendor flag, whisper.cpp model: %s, language: %s, output: %s)", cppModel, language, outputDir)
+				return tr, nil
+			}
+
+			tr, err := NewWhisperTranscriber(ctx, whisperModelPath, whisperPath, outputDir, language, keepWav, keepTxt)
+			if err != nil {
+				// If Whisper is not available, fall back to Recorder service
+				log.Printf("Whisper service not available: %v", err)
+				log.Printf("Falling back to Recorder service")
+				recorderTr, recorderErr := NewRecorderTranscriber(ctx, outputDir)
+				if recorderErr != nil {
+					return nil, fmt.Errorf("failed to create Whisper service: %w, and failed to fallback to Recorder: %w", err, recorderErr)
+				}
+				log.Printf("Using Recorder service (fallback from Whisper, output: %s)", outputDir)
+				return recorderTr, nil
+			}
+			log.Printf("Using Whisper service (via --vendor flag, model: %s, language: %s, output: %s)", model, language, outputDir)
+			return tr, nil
+
+		case "recorder":
+			outputDir := output
+			if outputDir == "" {
+				outputDir = "./recordings"
+			}
+
+			tr, err := NewRecorderTranscriber(ctx, outputDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Recorder service: %w", err)
+			}
+			log.Printf("Using Recorder service (via --vendor flag, output: %s)", outputDir)
+			return tr, nil
+
+		case "exec":
+			command := getenv("EXEC_CMD")
+			if command == "" {
+				return nil, fmt.Errorf("--vendor=exec requires --exec.cmd or the EXEC_CMD environment variable")
+			}
+			tr, err := NewExecTranscriber(ctx, command)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Exec service: %w", err)
+			}
+			log.Printf("Using Exec service (via --vendor flag, plugin: %s)", command)
+			return tr, nil
+
+		case "grpc":
+			server := getenv("GRPC_ASR_SERVER")
+			if server == "" {
+				return nil, fmt.Errorf("--vendor=grpc requires GRPC_ASR_SERVER environment variable")
+			}
+			tr, err := NewGRPCTranscriber(ctx, server, getenv("GRPC_ASR_TOKEN"), getenv("GRPC_ASR_TLS") == "true")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create gRPC service: %w", err)
+			}
+			log.Printf("Using gRPC service (via --vendor flag, server: %s)", server)
+			return tr, nil
+
+		case "mock":
+			var delay time.Duration
+			if value := getenv("MOCK_DELAY"); value != "" {
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid MOCK_DELAY %q: %w", value, err)
+				}
+				delay = d
+			}
+			tr, err := NewMockTranscriber(ctx, getenv("MOCK_SCRIPT"), delay, getenv("MOCK_CREATE_ERROR"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Mock service: %w", err)
+			}
+			log.Printf("Using Mock service (via --vendor flag), the results are scripted")
+			return tr, nil
+
+		default:
+			return nil, fmt.Errorf("unsupported vendor: %s. Supported vendors: google, azure, baidu, xunfei, aliyun, tencent, deepgram, speechmatics, soniox, riva, kaldi, vosk, coqui, openai, whisper, recorder, mock, exec, grpc", vendor)
+		}
+	}
+
+	// Fallback to automatic selection based on environment variables
+	// Check Google Speech first (highest priority)
+	if googleCred != "" {
+		tr, err := NewGoogleSpeech(ctx, googleCred, getenv("GOOGLE_SPEECH_LOCATION"), getenv("GOOGLE_SPEECH_MODEL"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Google Speech service: %w", err)
+		}
+		log.Printf("Using Google Speech service")
+		return tr, nil
+	}
+
+	// Check Azure Speech credentials
+	azureKey := getenv("AZURE_SPEECH_KEY")
+	azureRegion := getenv("AZURE_SPEECH_REGION")
+	if azureKey != "" && azureRegion != "" {
+		tr, err := NewAzureTranscriber(ctx, azureKey, azureRegion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure Speech service: %w", err)
+		}
+		log.Printf("Using Azure Speech service (region: %s)", azureRegion)
+		return tr, nil
+	}
+
+	// Check Baidu Speech credentials
+	baiduAppID := getenv("BAIDU_APP_ID")
+	baiduApiKey := getenv("BAIDU_API_KEY")
+	baiduSecretKey := getenv("BAIDU_SECRET_KEY")
+	if baiduAppID != "" && baiduApiKey != "" && baiduSecretKey != "" {
+		tr, err := NewBaiduTranscriber(ctx, baiduAppID, baiduApiKey, baiduSecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Baidu Speech service: %w", err)
+		}
+		log.Printf("Using Baidu Speech service")
+		return tr, nil
+	}
+
+	// Check Xunfei credentials
+	appID := getenv("XUNFEI_APP_ID")
+	apiKey := getenv("XUNFEI_API_KEY")
+	apiSecret := getenv("XUNFEI_API_SECRET")
+	appUrl := getenv("XUNFEI_API_URL")
+	if appID != "" && apiKey != "" && apiSecret != "" {
+		tr, err := NewIflyTekTranscriber(ctx, appID, apiKey, apiSecret, appUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Xunfei service: %w", err)
+		}
+		log.Printf("Using Xunfei (IflyTek) service")
+		return tr, nil
+	}
+
+	// Check Aliyun NLS credentials
+	aliyunKeyID := getenv("ALIYUN_ACCESS_KEY_ID")
+	aliyunKeySecret := getenv("ALIYUN_ACCESS_KEY_SECRET")
+	aliyunAppKey := getenv("ALIYUN_NLS_APPKEY")
+	if aliyunKeyID != "" && aliyunKeySecret != "" && aliyunAppKey != "" {
+		tr, err := NewAliyunTranscriber(ctx, aliyunKeyID, aliyunKeySecret, aliyunAppKey, getenv("ALIYUN_NLS_REGION"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Aliyun NLS service: %w", err)
+		}
+		log.Printf("Using Aliyun NLS service")
+		return tr, nil
+	}
+
+	// Check Tencent Cloud credentials
+	tencentAppID := getenv("TENCENT_APP_ID")
+	tencentSecretID := getenv("TENCENT_SECRET_ID")
+	tencentSecretKey := getenv("TENCENT_SECRET_KEY")
+	if tencentAppID != "" && tencentSecretID != "" && tencentSecretKey != "" {
+		tr, err := NewTencentTranscriber(ctx, tencentAppID, tencentSecretID, tencentSecretKey, getenv("TENCENT_ASR_ENGINE"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Tencent Cloud ASR service: %w", err)
+		}
+		log.Printf("Using Tencent Cloud ASR service")
+		return tr, nil
+	}
+
+	// Check Deepgram credentials
+	if deepgramKey := getenv("DEEPGRAM_API_KEY"); deepgramKey != "" {
+		tr, err := NewDeepgramTranscriber(ctx, deepgramKey, getenv("DEEPGRAM_MODEL"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Deepgram service: %w", err)
+		}
+		log.Printf("Using Deepgram service")
+		return tr, nil
+	}
+
+	// Check Speechmatics credentials
+	if speechmaticsKey := getenv("SPEECHMATICS_API_KEY"); speechmaticsKey != "" {
+		tr, err := NewSpeechmaticsTranscriber(ctx, speechmaticsKey, getenv("SPEECHMATICS_URL"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Speechmatics service: %w", err)
+		}
+		log.Printf("Using Speechmatics service")
+		return tr, nil
+	}
+
+	// Check Soniox credentials
+	if sonioxKey := getenv("SONIOX_API_KEY"); sonioxKey != "" {
+		tr, err := NewSonioxTranscriber(ctx, sonioxKey, getenv("SONIOX_MODEL"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Soniox service: %w", err)
+		}
+		log.Printf("Using Soniox service")
+		return tr, nil
+	}
+
+	// Check the Riva server
+	if rivaServer := getenv("RIVA_SERVER"); rivaServer != "" {
+		rivaRate, _ := strconv.Atoi(getenv("RIVA_SAMPLE_RATE"))
+		tr, err := NewRivaTranscriber(ctx, rivaServer, getenv("RIVA_MODEL"), rivaRate, getenv("RIVA_SSL") == "true")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Riva service: %w", err)
+		}
+		log.Printf("Using Riva service")
+		return tr, nil
+	}
+
+	// Check the kaldi-gstreamer-server
+	if kaldiURL := getenv("KALDI_SERVER_URL"); kaldiURL != "" {
+		kaldiRate, _ := strconv.Atoi(getenv("KALDI_SAMPLE_RATE"))
+		tr, err := NewKaldiTranscriber(ctx, kaldiURL, kaldiRate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kaldi service: %w", err)
+		}
+		log.Printf("Using Kaldi service")
+		return tr, nil
+	}
+
+	// Check the offline Vosk model or server
+	voskModel := getenv("VOSK_MODEL_PATH")
+	voskURL := getenv("VOSK_SERVER_URL")
+	if voskModel != "" || voskURL != "" {
+		tr, err := NewVoskTranscriber(ctx, voskModel, voskURL, getenv("VOSK_SERVER_PATH"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Vosk service: %w", err)
+		}
+		log.Printf("Using Vosk service")
+		return tr, nil
+	}
+
+	// Check if Whisper is available (try auto-detection even without env vars)
+	whisperModelPath := getenv("WHISPER_MODEL_PATH")
+	whisperPath := getenv("WHISPER_PATH")
+	outputDir := output
+	if outputDir == "" {
+		outputDir = getenv("OUTPUT_PATH")
+		if outputDir == "" {
+			currentDir, err := os.Getwd()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get current working directory: %w", err)
+			}
+			outputDir = currentDir + "/" + defaultOutputDir
+		}
+	}
+
+	if serverURL := getenv("WHISPER_SERVER_URL"); serverURL != "" {
+		tr, err := NewWhisperServerTranscriber(ctx, serverURL, getenv("WHISPER_SERVER_API"), getenv("WHISPER_SERVER_KEY"), getenv("WHISPER_SERVER_MODEL"), outputDir, language, keepWav, keepTxt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Whisper service: %w", err)
+		}
+		log.Printf("Using Whisper service (Whisper server: %s, language: %s)", serverURL, language)
+		return tr, nil
+	}
+	if cppModel := getenv("WHISPER_CPP_MODEL"); cppModel != "" {
+		tr, err := NewWhisperNativeTranscriber(ctx, cppModel, outputDir, language, keepWav, keepTxt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Whisper service: %w", err)
+		}
+		log.Printf("Using Whisper service (whisper.cpp model: %s, language: %s)", cppModel, language)
+		return tr, nil
+	}
+
+	// Check the Coqui STT model, lighter than Whisper on small devices
+	if coquiModel := getenv("COQUI_MODEL_PATH"); coquiModel != "" {
+		tr, err := NewCoquiTranscriber(ctx, coquiModel, getenv("COQUI_SCORER_PATH"), getenv("COQUI_STT_PATH"), outputDir, keepWav, keepTxt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Coqui STT service: %w", err)
+		}
+		log.Printf("Using Coqui STT service (model: %s)", coquiModel)
+		return tr, nil
+	}
+
+	// Try to create Whisper service (will auto-detect if env vars are empty)
+	whisperTr, err := NewWhisperTranscriber(ctx, whisperModelPath, whisperPath, outputDir, language, keepWav, keepTxt)
+	if err == nil {
+		// Whisper service created successfully
+		modelPath := whisperModelPath
+		execPath := whisperPath
+		if modelPath == "" {
+			modelPath = "auto-detected"
+		}
+		if execPath == "" {
+			execPath = "auto-detected"
+		}
+		log.Printf("Using Whisper service (model: %s, executable: %s, language: %s)", modelPath, execPath, language)
+		return whisperTr, nil
+	}
+
+	// If Whisper failed, log the error but continue to next service
+	log.Printf("Whisper service not available: %v", err)
+
+	// Use Recorder service as fallback (no credentials needed)
+	recorderOutputDir := output
+	if recorderOutputDir == "" {
+		recorderOutputDir = getenv("RECORDER_OUTPUT_DIR")
+		if recorderOutputDir == "" {
+			recorderOutputDir = defaultOutputDir
+		}
+	}
+
+	tr, err := NewRecorderTranscriber(ctx, recorderOutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Recorder service: %w", err)
+	}
+	log.Printf("Using Recorder service (output directory: %s)", outputDir)
+	return tr, nil
+}
+
+// selectTee selects every vendor of the comma separated list and tees them,
+// e.g. "whisper,google" or "deepgram,recorder" to keep a recording
+func selectTee(ctx context.Context, getenv func(string) string, googleCred, vendors, model, output, language string, keepWav, keepTxt bool) (Service, error) {
+	var names []string
+	var services []Service
+	for _, name := range strings.Split(vendors, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		service, err := SelectVendorEnv(ctx, getenv, googleCred, name, model, output, language, keepWav, keepTxt)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		services = append(services, service)
+	}
+	tr, err := NewTeeTranscriber(names, services)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vendor list %q: %w", vendors, err)
+	}
+	log.Printf("Using Tee service (via --vendor flag, vendors: %s)", strings.Join(names, ", "))
+	return tr, nil
+}
+
+// selectFailover selects every vendor of the chain, e.g. "azure>whisper>recorder",
+// the streams go on with the next vendor when one fails
+func selectFailover(ctx context.Context, getenv func(string) string, googleCred, chain, model, output, language string, keepWav, keepTxt bool) (Service, error) {
+	var names []string
+	var services []Service
+	for _, name := range strings.Split(chain, ">") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		service, err := SelectVendorEnv(ctx, getenv, googleCred, name, model, output, language, keepWav, keepTxt)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		services = append(services, service)
+	}
+	tr, err := NewFailoverTranscriber(names, services)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vendor chain %q: %w", chain, err)
+	}
+	log.Printf("Using Failover service (via --vendor flag, chain: %s)", strings.Join(names, " > "))
+	return tr, nil
+}
@@ -0,0 +1,292 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+)
+
+// voskSampleRate is the rate of the PCM sent to vosk-server, the one of its
+// models
+const voskSampleRate = 16000
+
+// voskStartTimeout bounds the loading of the model by the local vosk-server,
+// the large models take a while
+const voskStartTimeout = 2 * time.Minute
+
+// voskFlushTimeout bounds the wait for the last result once the stream is closed
+const voskFlushTimeout = 10 * time.Second
+
+// VoskTranscriber is the implementation of the transcribe.Service,
+// using the WebSocket protocol of vosk-server for offline speech recognition
+type VoskTranscriber struct {
+	serverURL string
+	modelPath string    // Model of the local vosk-server, empty for a remote one
+	server    *exec.Cmd // Local vosk-server, nil for a remote one
+	ctx       context.Context
+}
+
+// VoskStream implements the transcribe.Stream interface,
+// it streams the PCM resampled to 16kHz to one vosk-server connection
+type VoskStream struct {
+	conn      *websocket.Conn
+	results   chan Result
+	ctx       context.Context
+	mu        sync.Mutex // Serializes the writes to conn
+	closed    bool
+	done      chan struct{} // Closed when the listener returns
+	resampler *audio.Downsampler
+}
+
+// voskResponse is either a partial or a final result of vosk-server
+type voskResponse struct {
+	Partial string `json:"partial"`
+	Text    string `json:"text"`
+	Result  []struct {
+		Word  string  `json:"word"`
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Conf  float32 `json:"conf"`
+	} `json:"result"`
+}
+
+// CreateStream creates a new transcription stream
+func (t *VoskTranscriber) CreateStream() (Stream, error) {
+	return t.CreateStreamWithOptions(StreamOptions{})
+}
+
+// CreateStreamWithOptions creates a new transcription stream (the language is the one of the model)
+func (t *VoskTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(t.serverURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to vosk-server at %s: %w", t.serverURL, err)
+	}
+
+	config := fmt.Sprintf(`{"config": {"sample_rate": %d, "words": 1}}`, voskSampleRate)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(config)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send config: %w", err)
+	}
+
+	stream := &VoskStream{
+		conn:      conn,
+		results:   make(chan Result, resultsBuffer),
+		ctx:       t.ctx,
+		done:      make(chan struct{}),
+		resampler: audio.NewDownsampler(recordingFormat.SampleRate, voskSampleRate),
+	}
+	go stream.listenForResults()
+
+	return stream, nil
+}
+
+// Results returns a channel that will receive the transcription results
+func (vs *VoskStream) Results() <-chan Result {
+	return vs.results
+}
+
+// Write sends the PCM resampled to 16kHz to vosk-server
+func (vs *VoskStream) Write(buffer []byte) (int, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if vs.closed {
+		return 0, fmt.Errorf("vosk stream is closed")
+	}
+	pcm := vs.resampler.Process(buffer)
+	if len(pcm) == 0 {
+		return len(buffer), nil
+	}
+	if err := vs.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+		return 0, fmt.Errorf("failed to send audio data: %w", err)
+	}
+	return len(buffer), nil
+}
+
+// Close sends the end of the audio, waits for the last result and
+// closes the connection
+func (vs *VoskStream) Close() error {
+	vs.mu.Lock()
+	if vs.closed {
+		vs.mu.Unlock()
+		return nil
+	}
+	vs.closed = true
+	if err := vs.conn.WriteMessage(websocket.TextMessage, []byte(`{"eof": 1}`)); err != nil {
+		log.Printf("Warning: failed to send eof to vosk-server: %v", err)
+	}
+	vs.mu.Unlock()
+
+	select {
+	case <-vs.done:
+	case <-time.After(voskFlushTimeout):
+		log.Printf("Warning: timed out waiting for the last Vosk result")
+	}
+	return vs.conn.Close()
+}
+
+// listenForResults maps the vosk-server messages to results until the
+// connection is closed, then closes the results channel
+func (vs *VoskStream) listenForResults() {
+	defer close(vs.done)
+	defer close(vs.results)
+
+	for {
+		_, message, err := vs.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Vosk WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var response voskResponse
+		if err := json.Unmarshal(message, &response); err != nil {
+			logSampler.Printf("vosk:unmarshal", "Failed to unmarshal response: %v", err)
+			continue
+		}
+
+		result, ok := response.result()
+		if !ok {
+			continue
+		}
+		if !sendResult(vs.ctx, vs.results, result, "vosk") && vs.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// result maps a vosk-server message, false when it has no text. The
+// confidence of a final result is the mean of the one of its words
+func (r *voskResponse) result() (Result, bool) {
+	if text := strings.TrimSpace(r.Partial); text != "" {
+		return Result{Text: text, Confidence: 0.5}, true
+	}
+	text := strings.TrimSpace(r.Text)
+	if text == "" {
+		return Result{}, false
+	}
+
+	result := Result{Text: text, Confidence: 0.9, Final: true}
+	if len(r.Result) > 0 {
+		var sum float32
+		for _, w := range r.Result {
+			sum += w.Conf
+			result.Words = append(result.Words, Word{
+				Word:       w.Word,
+				Start:      w.Start,
+				End:        w.End,
+				Confidence: w.Conf,
+			})
+		}
+		result.Confidence = sum / float32(len(r.Result))
+	}
+	return result, true
+}
+
+// startVoskServer runs the vosk-server script serverPath on modelPath,
+// listening on a free local port, and waits for the model to be loaded
+func startVoskServer(ctx context.Context, serverPath, modelPath string) (*exec.Cmd, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	var cmd *exec.Cmd
+	if strings.HasSuffix(serverPath, ".py") {
+		cmd = exec.CommandContext(ctx, "python3", serverPath)
+	} else {
+		cmd = exec.CommandContext(ctx, serverPath)
+	}
+	cmd.Env = append(os.Environ(),
+		"VOSK_MODEL_PATH="+modelPath,
+		"VOSK_SERVER_INTERFACE=127.0.0.1",
+		"VOSK_SERVER_PORT="+strconv.Itoa(port),
+		"VOSK_SAMPLE_RATE="+strconv.Itoa(voskSampleRate),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("failed to start vosk-server: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(voskStartTimeout)
+	for {
+		if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+			conn.Close()
+			return cmd, "ws://" + address, nil
+		}
+		select {
+		case err := <-exited:
+			return nil, "", fmt.Errorf("vosk-server exited while loading %s: %v", modelPath, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			return nil, "", fmt.Errorf("vosk-server did not start within %v", voskStartTimeout)
+		}
+	}
+}
+
+// findVoskServer returns the vosk-server script found in the PATH
+func findVoskServer() string {
+	for _, name := range []string{"asr_server.py", "vosk-server"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// NewVoskTranscriber creates a new instance of the transcribe.Service that uses Vosk.
+// It connects to the vosk-server at serverURL when given, otherwise it runs the
+// vosk-server serverPath (found in the PATH when empty) on the model modelPath,
+// until ctx is done
+func NewVoskTranscriber(ctx context.Context, modelPath, serverURL, serverPath string) (Service, error) {
+	if serverURL != "" {
+		log.Printf("Vosk transcriber initialized with vosk-server %s", serverURL)
+		return &VoskTranscriber{serverURL: serverURL, ctx: ctx}, nil
+	}
+
+	if modelPath == "" {
+		return nil, fmt.Errorf("modelPath or serverURL is required")
+	}
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("vosk model not found: %w", err)
+	}
+	if serverPath == "" {
+		serverPath = findVoskServer()
+		if serverPath == "" {
+			return nil, fmt.Errorf("vosk-server not found, please set VOSK_SERVER_PATH to its websocket/asr_server.py or VOSK_SERVER_URL")
+		}
+	}
+
+	server, serverURL, err := startVoskServer(ctx, serverPath, modelPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Vosk transcriber initialized with model: %s, vosk-server: %s (%s)", modelPath, serverPath, serverURL)
+
+	return &VoskTranscriber{
+		serverURL: serverURL,
+		modelPath: modelPath,
+		server:    server,
+		ctx:       ctx,
+	}, nil
+}
@@ -2,22 +2,35 @@ package transcribe
 
 import (
 	"context"
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
 )
 
+// whisperSampleRate is the default rate of the recordings of Whisper, the
+// rate of its models, so that it does not resample them itself
+const whisperSampleRate = 16000
+
 // WhisperTranscriber is the implementation of the transcribe.Service,
 // using OpenAI's Whisper model for local speech recognition
 type WhisperTranscriber struct {
-	modelPath   string
+	modelPath   string // Model of the new streams, see SetModel
 	whisperPath string
+	serverURL   string        // Whisper server used instead of whisperPath, see NewWhisperServerTranscriber
+	serverAPI   string        // API of the Whisper server, e.g. WhisperServerCpp
+	openai      *openAIConfig // OpenAI transcription API used instead of whisperPath, see NewOpenAITranscriber
+	coqui       *coquiConfig  // Coqui STT client run instead of whisperPath, see NewCoquiTranscriber
+	native      nativeModel   // whisper.cpp model loaded in the process, see NewWhisperNativeTranscriber
 	tempDir     string
 	language    string // Language code (e.g., "en", "zh", "auto")
 	ctx         context.Context
@@ -25,20 +38,40 @@ type WhisperTranscriber struct {
 	counter     int
 	keepWav     bool
 	keepTxt     bool
+	sampleRate  int // Rate of the recordings, whisperSampleRate when 0, see SetSampleRate
+
+	// Rolling window of the live partial results, see SetRollingWindow
+	rollingWindow  time.Duration
+	rollingStep    time.Duration
+	rollingEnabled func(user string) bool
+
+	// Chunked partial results, see SetChunks
+	chunk        time.Duration
+	chunkEnabled func(user string) bool
+
+	diarizer string // Command labelling the speakers of the diarized recordings, see SetDiarizer
 }
 
 // WhisperStream implements the transcribe.Stream interface,
 // it handles audio processing and transcription using Whisper
 type WhisperStream struct {
 	filePath    string
-	file        *os.File // Store the file handle
+	file        *wav.File // Store the file handle
+	wav         *wav.Writer
+	resampler   *audio.Downsampler // nil when recording the decoded audio as is
 	results     chan Result
 	ctx         context.Context
 	transcriber *WhisperTranscriber
 	language    string // Per-stream language override
+	locale      string // Language of the server messages
 	transcribe  bool   // Whether to transcribe (if false, just record)
+	diarizer    string // Diarizer of the recording, empty when not diarized
+	task        string // TaskTranscribe or TaskTranslate
+	prompt      string // Initial prompt, empty when not set
+	model       string // Model of the executable, the one of the transcriber when the stream was created
 	mu          sync.Mutex
 	isClosed    bool
+	rolling     *rollingWindow // Live partial results, nil when disabled
 }
 
 // WhisperConfig holds configuration for Whisper model
@@ -59,6 +92,11 @@ func (w *WhisperTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream
 	w.mu.Lock()
 	w.counter++
 	streamID := w.counter
+	window, step, enabled := w.rollingWindow, w.rollingStep, w.rollingEnabled
+	chunk, chunkEnabled := w.chunk, w.chunkEnabled
+	diarizer := w.diarizer
+	model := w.modelPath
+	format := w.format()
 	w.mu.Unlock()
 
 	// Use provided language or fall back to transcriber default
@@ -71,7 +109,7 @@ func (w *WhisperTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream
 	transcribe := opts.Transcribe
 
 	// Create temporary file for audio data
-	fileName := fmt.Sprintf("whisper_audio_%d_%s.wav", streamID, time.Now().Format("20060102_150405"))
+	fileName := fmt.Sprintf("whisper_audio_%s%d_%s.wav", sessionTag(opts), streamID, time.Now().Format("20060102_150405"))
 	filePath := filepath.Join(w.tempDir, fileName)
 
 	// Create output directory if it doesn't exist
@@ -80,124 +118,43 @@ func (w *WhisperTranscriber) CreateStreamWithOptions(opts StreamOptions) (Stream
 	}
 
 	// Create WAV file with header
-	file, err := os.Create(filePath)
+	file, writer, err := createWAV(filePath, format)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create WAV file: %w", err)
-	}
-
-	// Write WAV header (will be updated later with correct sizes)
-	header := wavHeader{
-		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
-		Format:        [4]byte{'W', 'A', 'V', 'E'},
-		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
-		Subchunk1Size: 16,
-		AudioFormat:   1, // PCM
-		NumChannels:   1, // Mono
-		SampleRate:    48000,
-		BitsPerSample: 16,
-		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
-	}
-
-	// Calculate derived values
-	header.ByteRate = header.SampleRate * uint32(header.NumChannels) * uint32(header.BitsPerSample) / 8
-	header.BlockAlign = header.NumChannels * header.BitsPerSample / 8
-
-	// Write header manually to ensure correct byte order
-	if _, err := file.Write(header.ChunkID[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write ChunkID: %w", err)
-	}
-
-	// Write chunk size (will be updated later)
-	if err := binary.Write(file, binary.LittleEndian, header.ChunkSize); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write ChunkSize: %w", err)
-	}
-
-	// Write format
-	if _, err := file.Write(header.Format[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Format: %w", err)
-	}
-
-	// Write fmt subchunk
-	if _, err := file.Write(header.Subchunk1ID[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk1ID: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.Subchunk1Size); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk1Size: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.AudioFormat); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write AudioFormat: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.NumChannels); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write NumChannels: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.SampleRate); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write SampleRate: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.ByteRate); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write ByteRate: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.BlockAlign); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write BlockAlign: %w", err)
-	}
-
-	if err := binary.Write(file, binary.LittleEndian, header.BitsPerSample); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write BitsPerSample: %w", err)
-	}
-
-	// Write data subchunk
-	if _, err := file.Write(header.Subchunk2ID[:]); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk2ID: %w", err)
-	}
-
-	// Write Subchunk2Size (will be updated later)
-	if err := binary.Write(file, binary.LittleEndian, header.Subchunk2Size); err != nil {
-		file.Close()
-		os.Remove(filePath) // Clean up on error
-		return nil, fmt.Errorf("failed to write Subchunk2Size: %w", err)
+		return nil, err
 	}
 
 	// Create the stream
 	stream := &WhisperStream{
 		filePath:    filePath,
 		file:        file, // Store the file handle
-		results:     make(chan Result, 10),
+		wav:         writer,
+		results:     make(chan Result, resultsBuffer),
 		ctx:         w.ctx,
 		transcriber: w,
-		language:    language,   // Store per-stream language
+		language:    language, // Store per-stream language
+		locale:      StreamLocale(opts, language),
 		transcribe:  transcribe, // Store transcribe flag
+		task:        TaskTranscribe,
+		prompt:      opts.Prompt,
+		model:       model,
+	}
+	if format.SampleRate != recordingFormat.SampleRate {
+		stream.resampler = audio.NewDownsampler(recordingFormat.SampleRate, format.SampleRate)
+	}
+	if opts.Diarization {
+		stream.diarizer = diarizer
+	}
+	if opts.Task != "" {
+		stream.task = opts.Task
+	}
+
+	if transcribe && window > 0 && step > 0 && enabled != nil && enabled(opts.User) {
+		stream.rolling = newRollingWindow(stream, window, step, false)
+	} else if transcribe && chunk > 0 && chunkEnabled != nil && chunkEnabled(opts.User) {
+		stream.rolling = newRollingWindow(stream, chunk, chunk, true)
 	}
 
-	log.Printf("Whisper stream created: %s (language: %s, transcribe: %v)", fileName, language, transcribe)
+	log.Printf("Whisper stream created: %s (language: %s, task: %s, prompt: %v, transcribe: %v, rolling window: %v)", fileName, language, stream.task, stream.prompt != "", transcribe, stream.rolling != nil)
 	return stream, nil
 }
 
@@ -216,74 +173,21 @@ func (ws *WhisperStream) Close() error {
 	ws.isClosed = true
 	ws.mu.Unlock()
 
-	// Flush any buffered data to disk
-	if err := ws.file.Sync(); err != nil {
-		log.Printf("Warning: failed to sync file: %v", err)
+	// No partial result may follow the final one
+	if ws.rolling != nil {
+		ws.rolling.stop()
 	}
 
-	// Get current file size
-	fileInfo, err := ws.file.Stat()
-	if err != nil {
-		ws.file.Close()
-		os.Remove(ws.filePath) // Clean up on error
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// Calculate sizes
-	fileSize := uint32(fileInfo.Size())
-
-	// Check if we have enough data for a valid WAV file
-	if fileSize < 44 {
-		ws.file.Close()
-		os.Remove(ws.filePath) // Clean up incomplete file
-		return fmt.Errorf("file too small for WAV header: %d bytes", fileSize)
-	}
-
-	audioDataSize := fileSize - 44 // 44 bytes for WAV header
-
-	// Update chunk size (file size - 8) at position 4
-	chunkSize := fileSize - 8
-
-	// Seek to position 4 (after ChunkID)
-	if _, err := ws.file.Seek(4, 0); err != nil {
-		ws.file.Close()
-		os.Remove(ws.filePath) // Clean up on error
-		return fmt.Errorf("failed to seek to ChunkSize position: %w", err)
-	}
-
-	if err := binary.Write(ws.file, binary.LittleEndian, chunkSize); err != nil {
-		ws.file.Close()
-		os.Remove(ws.filePath) // Clean up on error
-		return fmt.Errorf("failed to update chunk size: %w", err)
-	}
-
-	// Seek to Subchunk2Size position (40 bytes from start)
-	if _, err := ws.file.Seek(40, 0); err != nil {
-		ws.file.Close()
-		os.Remove(ws.filePath) // Clean up on error
-		return fmt.Errorf("failed to seek to Subchunk2Size: %w", err)
-	}
-
-	// Update Subchunk2Size (audio data size)
-	if err := binary.Write(ws.file, binary.LittleEndian, audioDataSize); err != nil {
-		ws.file.Close()
-		os.Remove(ws.filePath) // Clean up on error
-		return fmt.Errorf("failed to update Subchunk2Size: %w", err)
-	}
-
-	// Flush the header updates to disk
-	if err := ws.file.Sync(); err != nil {
-		log.Printf("Warning: failed to sync header updates: %v", err)
-	}
-
-	// Close file
-	if err := ws.file.Close(); err != nil {
+	// Sync the audio, then the sizes of the WAV header, and close the file
+	if err := ws.file.Finalize(ws.wav); err != nil {
 		os.Remove(ws.filePath) // Clean up on error
-		return fmt.Errorf("failed to close file: %w", err)
+		return fmt.Errorf("failed to finalize the WAV file: %w", err)
 	}
+	audioDataSize := ws.wav.Size()
+	fileSize := audioDataSize + wav.HeaderSize
 
 	// Check if audio file has content
-	if fileSize == 44 {
+	if audioDataSize == 0 {
 		log.Printf("Warning: Audio file is empty (only header), skipping transcription")
 		// Clean up empty file
 		os.Remove(ws.filePath)
@@ -295,37 +199,45 @@ func (ws *WhisperStream) Close() error {
 	if !ws.transcribe {
 		// Record only mode - just return the audio file info
 		log.Printf("Record only mode - skipping transcription for: %s", ws.filePath)
-		ws.results <- Result{
-			Text:       "Recording saved (transcription disabled)",
-			Confidence: 1.0,
-			Final:      true,
-			AudioFile:  ws.filePath,
-		}
+		result := MessageResult(ws.locale, MsgTranscriptionDisabled)
+		result.AudioFile = ws.filePath
+		sendResult(ws.ctx, ws.results, result, ws.transcriber.vendor())
 		close(ws.results)
 		log.Printf("Recording completed: %s (Size: %d bytes, Audio: %d bytes)", filepath.Base(ws.filePath), fileSize, audioDataSize)
 		return nil
 	}
 
 	// Transcribe audio using Whisper
-	text, textFile, err := ws.transcribeAudio(ws.filePath)
+	text, textFile, language, words, err := ws.transcribeAudio(ws.filePath)
 	if err != nil {
 		log.Printf("Error transcribing audio: %v", err)
 		// Send error result but don't fail the stream
-		ws.results <- Result{
-			Text:       fmt.Sprintf("Transcription error: %v", err),
-			Confidence: 0.0,
-			Final:      true,
-			AudioFile:  ws.filePath,
-		}
+		result := ErrorResult(ws.locale, err)
+		result.AudioFile = ws.filePath
+		sendResult(ws.ctx, ws.results, result, ws.transcriber.vendor())
 	} else {
+		// Label the words with their speakers, the transcription is kept when the diarizer fails
+		var speaker string
+		if ws.diarizer != "" && len(words) > 0 {
+			if turns, err := runDiarizer(ws.ctx, ws.diarizer, ws.filePath); err != nil {
+				log.Printf("Warning: failed to diarize %s: %v", ws.filePath, err)
+			} else {
+				labelSpeakers(words, turns)
+				speaker = mainSpeaker(words)
+			}
+		}
+
 		// Send successful transcription result
-		ws.results <- Result{
+		sendResult(ws.ctx, ws.results, Result{
 			Text:       text,
 			Confidence: 0.9, // Whisper doesn't provide confidence scores
 			Final:      true,
 			AudioFile:  ws.filePath,
 			TextFile:   textFile,
-		}
+			Language:   language,
+			Words:      words,
+			Speaker:    speaker,
+		}, ws.transcriber.vendor())
 	}
 
 	// Clean up temporary file based on retention flags
@@ -354,26 +266,31 @@ func (ws *WhisperStream) Write(buffer []byte) (int, error) {
 	// Log audio data received
 	//log.Printf("Received %d bytes of audio data for file: %s", len(buffer), filepath.Base(ws.filePath))
 
-	// Write audio data directly to the stored file handle
-	written, err := ws.file.Write(buffer)
-	if err != nil {
-		return written, fmt.Errorf("failed to write audio data: %w", err)
+	// Resample the audio to the rate of the recording
+	pcm := buffer
+	if ws.resampler != nil {
+		pcm = ws.resampler.Process(buffer)
+	}
+	// Synced to the disk every flush interval
+	if _, err := ws.wav.Write(pcm); err != nil {
+		return 0, fmt.Errorf("failed to write audio data: %w", err)
 	}
 
-	// Ensure data is written to disk
-	if err := ws.file.Sync(); err != nil {
-		log.Printf("Warning: failed to sync audio data: %v", err)
+	if ws.rolling != nil {
+		ws.rolling.write(pcm)
 	}
 
-	//log.Printf("Wrote %d bytes to audio file: %s", written, filepath.Base(ws.filePath))
-	return written, nil
+	//log.Printf("Wrote %d bytes to audio file: %s", len(pcm), filepath.Base(ws.filePath))
+	return len(buffer), nil
 }
 
 // transcribeAudio runs Whisper on the audio file and returns the transcription
-func (ws *WhisperStream) transcribeAudio(audioPath string) (string, string, error) {
+// and the path of its text file, along with the language it was spoken in and
+// its words when Whisper timed them
+func (ws *WhisperStream) transcribeAudio(audioPath string) (string, string, string, []Word, error) {
 	// Check if Whisper is available
-	if ws.transcriber.whisperPath == "" {
-		return "", "", fmt.Errorf("whisper executable not found, please install whisper-ctranslate2 or set WHISPER_PATH")
+	if ws.transcriber.whisperPath == "" && ws.transcriber.serverURL == "" && ws.transcriber.openai == nil && ws.transcriber.coqui == nil && ws.transcriber.native == nil {
+		return "", "", "", nil, fmt.Errorf("whisper executable not found, please install whisper-ctranslate2 or set WHISPER_PATH")
 	}
 
 	// Use stream's language (which may override transcriber's default)
@@ -383,40 +300,42 @@ func (ws *WhisperStream) transcribeAudio(audioPath string) (string, string, erro
 	}
 
 	log.Printf("Transcribing audio file: %s to output directory: %s (language: %s)", audioPath, ws.transcriber.tempDir, language)
-	// Prepare Whisper command
-	args := []string{
-		"--model", ws.transcriber.modelPath,
-		"--output_dir", ws.transcriber.tempDir,
-		"--output_format", "txt",
-		"--task", "transcribe",
-		"--temperature", "0.0", // Deterministic output
+	// The JSON output has the timestamps of the words, Coqui only outputs text
+	format := "json"
+	if ws.transcriber.coqui != nil {
+		format = "txt"
 	}
-
-	// Add language parameter if specified (not "auto")
-	if language != "" && language != "auto" {
-		args = append(args, "--language", language)
-	}
-
-	// Add the audio file path
-	args = append(args, audioPath)
-
-	// Execute Whisper
-	cmd := exec.CommandContext(ws.ctx, ws.transcriber.whisperPath, args...)
-	// cmd.Dir = ws.transcriber.tempDir // Do not change dir, as audioPath is relative to project root
-
-	// Capture output
-	output, err := cmd.CombinedOutput()
+	outputFile, output, err := ws.transcriber.run(ws.ctx, audioPath, ws.model, language, ws.task, ws.prompt, format)
 	if err != nil {
-		return "", "", fmt.Errorf("whisper execution failed: %w, output: %s", err, string(output))
+		return "", "", "", nil, err
 	}
 
 	// Read the transcription result
-	outputFile := audioPath[:len(audioPath)-4] + ".txt" // Replace .wav with .txt
 	content, err := os.ReadFile(outputFile)
 	if err != nil {
 		// Log the command output if reading the file fails, to help debug why it wasn't created
 		log.Printf("Whisper command output: %s", string(output))
-		return "", "", fmt.Errorf("failed to read transcription output: %w", err)
+		return "", "", "", nil, fmt.Errorf("failed to read transcription output: %w", err)
+	}
+
+	var words []Word
+	if format == "json" {
+		var result whisperServerResponse
+		if err := json.Unmarshal(content, &result); err != nil {
+			return "", "", "", nil, fmt.Errorf("invalid transcription output: %w", err)
+		}
+		if result.Language != "" {
+			output = []byte(fmt.Sprintf("Detected language: %s\n", result.Language))
+		}
+		words = result.words()
+
+		// The text file is kept like the one of the other outputs
+		os.Remove(outputFile)
+		content = []byte(strings.TrimSpace(result.Text) + "\n")
+		outputFile, _, err = ws.transcriber.writeOutput(audioPath, "txt", content, nil)
+		if err != nil {
+			return "", "", "", nil, fmt.Errorf("failed to write transcription output: %w", err)
+		}
 	}
 
 	// Clean up output file based on retention flags
@@ -428,13 +347,167 @@ func (ws *WhisperStream) transcribeAudio(audioPath string) (string, string, erro
 		log.Printf("Keeping TXT file: %s", outputFile)
 	}
 
+	// Whisper logs the language it detected, the requested one otherwise
+	detected := detectedLanguage(output)
+	if detected == "" && language != "" && language != "auto" {
+		detected = language
+	}
+	if ws.task == TaskTranslate {
+		// The text is in English whatever the spoken language
+		detected = "en"
+	}
+
 	// Return transcription text
 	text := string(content)
 	if text == "" {
-		return "", outputFile, fmt.Errorf("transcription result is empty")
+		return "", outputFile, "", nil, fmt.Errorf("transcription result is empty")
+	}
+
+	return text, outputFile, detected, words, nil
+}
+
+// SetModel switches the model of the Whisper executable, a name like
+// "medium" or the path of a model directory. The streams created afterwards
+// transcribe with it, the servers, the APIs and the loaded whisper.cpp
+// model keep their own model
+func (w *WhisperTranscriber) SetModel(model string) error {
+	if w.serverURL != "" || w.openai != nil || w.coqui != nil || w.native != nil {
+		return fmt.Errorf("the model of the %s vendor cannot be switched", w.vendor())
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.modelPath = model
+	return nil
+}
+
+// SetSampleRate changes the rate of the recordings of the new streams,
+// 16000 (the default) so that Whisper, whisper.cpp and Coqui do not resample
+// them and they are 3 times smaller, or 48000 to keep the decoded audio
+func (w *WhisperTranscriber) SetSampleRate(rate int) error {
+	if rate != whisperSampleRate && rate != recordingFormat.SampleRate {
+		return fmt.Errorf("unsupported sample rate %d, use %d or %d", rate, whisperSampleRate, recordingFormat.SampleRate)
+	}
+	w.mu.Lock()
+	w.sampleRate = rate
+	w.mu.Unlock()
+	return nil
+}
+
+// format returns the format of the recordings, it must be called with mu held
+func (w *WhisperTranscriber) format() wav.Format {
+	format := recordingFormat
+	format.SampleRate = whisperSampleRate
+	if w.sampleRate != 0 {
+		format.SampleRate = w.sampleRate
+	}
+	return format
+}
+
+// Model returns the model of the new streams
+func (w *WhisperTranscriber) Model() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.modelPath
+}
+
+// vendor returns the name of the vendor transcribing the streams: coqui,
+// openai or whisper, the Whisper servers included
+func (w *WhisperTranscriber) vendor() string {
+	switch {
+	case w.coqui != nil:
+		return "coqui"
+	case w.openai != nil && w.serverURL == "":
+		return "openai"
+	}
+	return "whisper"
+}
+
+// run executes the Whisper task (TaskTranscribe or TaskTranslate) on the
+// audio file, biased by the initial prompt when set, and returns the path of
+// its output in the format (txt, srt, ...) along with the output of the
+// command. The json output has the word timestamps. The model is the one of
+// the executable, the servers and APIs use their own
+func (w *WhisperTranscriber) run(ctx context.Context, audioPath, model, language, task, prompt, format string) (string, []byte, error) {
+	if task == "" {
+		task = TaskTranscribe
+	}
+	if w.openai != nil {
+		return w.runOpenAI(ctx, audioPath, language, task, prompt, format)
+	}
+	if w.serverURL != "" {
+		return w.runServer(ctx, audioPath, language, task, prompt, format)
+	}
+	if w.coqui != nil {
+		return w.runCoqui(ctx, audioPath, language, task, prompt, format)
+	}
+	if w.native != nil {
+		return w.runNative(ctx, audioPath, language, task, prompt, format)
+	}
+
+	// Prepare Whisper command
+	args := []string{
+		"--model", model,
+		"--output_dir", w.tempDir,
+		"--output_format", format,
+		"--task", task,
+		"--temperature", "0.0", // Deterministic output
+	}
+
+	// Add language parameter if specified (not "auto")
+	if language != "" && language != "auto" {
+		args = append(args, "--language", language)
+	}
+	if format == "json" {
+		args = append(args, "--word_timestamps", "True")
 	}
+	if prompt != "" {
+		args = append(args, "--initial_prompt", prompt)
+	}
+
+	// Add the audio file path
+	args = append(args, audioPath)
 
-	return text, outputFile, nil
+	// Execute Whisper
+	cmd := exec.CommandContext(ctx, w.whisperPath, args...)
+	// cmd.Dir = w.tempDir // Do not change dir, as audioPath is relative to project root
+
+	// Capture output
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", output, fmt.Errorf("whisper execution failed: %w, output: %s", err, string(output))
+	}
+	// Whisper names the output after the audio file
+	base := strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))
+	return filepath.Join(w.tempDir, base+"."+format), output, nil
+}
+
+// detectedLanguagePattern matches the language logged by openai-whisper
+// ("Detected language: English") and whisper-ctranslate2 ("Detected
+// language 'English' with probability 0.98")
+var detectedLanguagePattern = regexp.MustCompile(`Detected language:?\s+'?([A-Za-z][A-Za-z ]*[A-Za-z])`)
+
+// whisperLanguageCodes maps the names of the common languages logged by
+// Whisper to their codes
+var whisperLanguageCodes = map[string]string{
+	"arabic": "ar", "chinese": "zh", "dutch": "nl", "english": "en",
+	"french": "fr", "german": "de", "hindi": "hi", "indonesian": "id",
+	"italian": "it", "japanese": "ja", "korean": "ko", "polish": "pl",
+	"portuguese": "pt", "russian": "ru", "spanish": "es", "swedish": "sv",
+	"thai": "th", "turkish": "tr", "ukrainian": "uk", "vietnamese": "vi",
+}
+
+// detectedLanguage returns the code of the language Whisper detected, its
+// lowercase name when the code is unknown and "" when it logged none
+func detectedLanguage(output []byte) string {
+	match := detectedLanguagePattern.FindSubmatch(output)
+	if match == nil {
+		return ""
+	}
+	name := strings.ToLower(string(match[1]))
+	if code, ok := whisperLanguageCodes[name]; ok {
+		return code
+	}
+	return name
 }
 
 // findWhisperExecutable searches for Whisper executable using "which" command first
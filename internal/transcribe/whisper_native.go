@@ -0,0 +1,207 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
+)
+
+// nativeModel is a whisper.cpp model loaded in the process, it stays in
+// memory between the streams instead of being loaded by every run of the
+// executable. The builds with the whispercpp tag implement it with the cgo
+// bindings of whisper.cpp, see loadNativeModel
+type nativeModel interface {
+	// transcribe decodes the 16kHz mono samples, or translates them to
+	// English, and returns their segments and the detected language
+	transcribe(ctx context.Context, samples []float32, language string, translate bool, prompt string) ([]nativeSegment, string, error)
+}
+
+// nativeSegment is a segment decoded by a nativeModel
+type nativeSegment struct {
+	start, end time.Duration
+	text       string
+	tokens     []nativeToken // Text tokens, with their timestamps
+}
+
+// nativeToken is a text token of a nativeSegment, a word or a part of a
+// word. The first token of a word starts with a space, except in Chinese
+// and Japanese
+type nativeToken struct {
+	text       string
+	start, end time.Duration
+	p          float32 // Probability of the token
+}
+
+// runNative transcribes the audio file with the whisper.cpp model loaded in
+// the process and returns the path of its output like run, the output
+// mirrors the log line of the detected language of the Whisper executables
+func (w *WhisperTranscriber) runNative(ctx context.Context, audioPath, language, task, prompt, format string) (string, []byte, error) {
+	if format != "txt" && format != "srt" && format != "json" {
+		return "", nil, fmt.Errorf("the whisper.cpp bindings do not output %s", format)
+	}
+	samples, err := nativeSamples(audioPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if language == "" {
+		language = "auto"
+	}
+	if language != "auto" {
+		language = baseLanguage(language)
+	}
+
+	segments, detected, err := w.native.transcribe(ctx, samples, language, task == TaskTranslate, prompt)
+	if err != nil {
+		return "", nil, fmt.Errorf("whisper.cpp transcription failed: %w", err)
+	}
+	var output []byte
+	if detected != "" {
+		output = []byte(fmt.Sprintf("Detected language: %s\n", detected))
+	}
+
+	var content []byte
+	switch format {
+	case "txt":
+		content = []byte(nativeText(segments) + "\n")
+	case "srt":
+		content = []byte(nativeSRT(segments))
+	case "json":
+		// The JSON output of the Whisper executables, with the word timestamps
+		result := whisperServerResponse{Text: nativeText(segments), Language: detected}
+		for _, segment := range segments {
+			result.Segments = append(result.Segments, struct {
+				Words []whisperWord `json:"words"`
+			}{nativeWords(segment)})
+		}
+		if content, err = json.Marshal(result); err != nil {
+			return "", output, err
+		}
+	}
+	return w.writeOutput(audioPath, format, content, output)
+}
+
+// nativeSamples reads the recording as the 16kHz samples of the whisper.cpp models
+func nativeSamples(audioPath string) ([]float32, error) {
+	data, err := ioutil.ReadFile(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	format, _, err := wav.ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", audioPath, err)
+	}
+	pcm := audio.NewDownsampler(format.SampleRate, whisperSampleRate).Process(data[wav.HeaderSize:])
+	samples := make([]float32, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float32(int16(binary.LittleEndian.Uint16(pcm[2*i:]))) / 32768
+	}
+	return samples, nil
+}
+
+// nativeText returns the text of the segments, each of them starts with a
+// space like the text of the Whisper executables
+func nativeText(segments []nativeSegment) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteString(segment.text)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// nativeSRT renders the segments as the SubRip output of the Whisper executables
+func nativeSRT(segments []nativeSegment) string {
+	var b strings.Builder
+	for i, segment := range segments {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, srtTimestamp(segment.start), srtTimestamp(segment.end), strings.TrimSpace(segment.text))
+	}
+	return b.String()
+}
+
+// srtTimestamp formats a duration as a HH:MM:SS,mmm timestamp, see parseSRTTime
+func srtTimestamp(d time.Duration) string {
+	ms := int64((d + time.Millisecond/2) / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// nativeWords merges the tokens of the segment into words, the probability
+// of a word is the one of its least probable token
+func nativeWords(segment nativeSegment) []whisperWord {
+	var words []whisperWord
+	for _, token := range segment.tokens {
+		if len(words) == 0 || startsWord(token.text) {
+			words = append(words, whisperWord{
+				Word:        token.text,
+				Start:       token.start.Seconds(),
+				End:         token.end.Seconds(),
+				Probability: token.p,
+			})
+			continue
+		}
+		word := &words[len(words)-1]
+		word.Word += token.text
+		word.End = token.end.Seconds()
+		if token.p < word.Probability {
+			word.Probability = token.p
+		}
+	}
+	return words
+}
+
+// startsWord reports whether a token starts a word: it starts with a space,
+// or with a Chinese or Japanese character, which are not separated by spaces
+func startsWord(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return unicode.IsSpace(r) || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// NewWhisperNativeTranscriber creates a new instance of the transcribe.Service that
+// transcribes with the whisper.cpp model at modelPath (a ggml .bin file) loaded in the
+// process instead of running Whisper for every stream, so that the model is not loaded
+// again when a stream is closed. It requires the build with the whispercpp tag, linked
+// with libwhisper
+func NewWhisperNativeTranscriber(ctx context.Context, modelPath, tempDir, language string, keepWav, keepTxt bool) (Service, error) {
+	if modelPath == "" {
+		return nil, fmt.Errorf("modelPath is required")
+	}
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("whisper.cpp model not found: %w", err)
+	}
+	if tempDir == "" {
+		tempDir = "./output"
+	}
+	if language == "" {
+		language = "auto"
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	model, err := loadNativeModel(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the whisper.cpp model: %w", err)
+	}
+
+	log.Printf("Whisper transcriber initialized with whisper.cpp model: %s, language: %s", modelPath, language)
+
+	return &WhisperTranscriber{
+		modelPath: modelPath,
+		native:    model,
+		tempDir:   tempDir,
+		language:  language,
+		ctx:       ctx,
+		keepWav:   keepWav,
+		keepTxt:   keepTxt,
+	}, nil
+}
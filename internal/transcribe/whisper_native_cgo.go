@@ -0,0 +1,76 @@
+//go:build whispercpp
+// +build whispercpp
+
+package transcribe
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// whisperCppModel is the nativeModel of the whisper.cpp bindings
+type whisperCppModel struct {
+	model whisper.Model
+	mu    sync.Mutex // The contexts of a model share its state, they decode one at a time
+}
+
+// loadNativeModel loads the ggml model at path with the whisper.cpp bindings
+func loadNativeModel(path string) (nativeModel, error) {
+	model, err := whisper.New(path)
+	if err != nil {
+		return nil, err
+	}
+	return &whisperCppModel{model: model}, nil
+}
+
+// transcribe implements nativeModel
+func (m *whisperCppModel) transcribe(ctx context.Context, samples []float32, language string, translate bool, prompt string) ([]nativeSegment, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wctx, err := m.model.NewContext()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := wctx.SetLanguage(language); err != nil {
+		return nil, "", err
+	}
+	wctx.SetTranslate(translate)
+	wctx.SetTokenTimestamps(true)
+	if prompt != "" {
+		wctx.SetInitialPrompt(prompt)
+	}
+
+	// The decoding is aborted before the encoder runs when ctx is done
+	encoderBegin := func() bool {
+		return ctx.Err() == nil
+	}
+	if err := wctx.Process(samples, encoderBegin, nil, nil); err != nil {
+		return nil, "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var segments []nativeSegment
+	for {
+		segment, err := wctx.NextSegment()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		s := nativeSegment{start: segment.Start, end: segment.End, text: segment.Text}
+		for _, token := range segment.Tokens {
+			if wctx.IsText(token) {
+				s.tokens = append(s.tokens, nativeToken{text: token.Text, start: token.Start, end: token.End, p: token.P})
+			}
+		}
+		segments = append(segments, s)
+	}
+	return segments, wctx.DetectedLanguage(), nil
+}
@@ -0,0 +1,11 @@
+//go:build !whispercpp
+// +build !whispercpp
+
+package transcribe
+
+import "fmt"
+
+// loadNativeModel fails in the builds without the whisper.cpp bindings
+func loadNativeModel(path string) (nativeModel, error) {
+	return nil, fmt.Errorf("built without the whisper.cpp bindings, build with -tags whispercpp")
+}
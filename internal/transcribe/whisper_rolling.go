@@ -0,0 +1,321 @@
+package transcribe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio/wav"
+)
+
+// minRollingAudio is the audio the window holds before its first decoding
+const minRollingAudio = time.Second
+
+// SetRollingWindow enables the live partial results of the streams of the
+// users for which enabled returns true. Every step of new audio the last
+// window of the stream is decoded again, the segments two decodings agree
+// on are kept and the others are revised by the next partial results
+// carrying the same segment id. The final result still comes from the
+// decoding of the whole recording on close
+func (w *WhisperTranscriber) SetRollingWindow(window, step time.Duration, enabled func(user string) bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rollingWindow = window
+	w.rollingStep = step
+	w.rollingEnabled = enabled
+}
+
+// SetChunks enables the chunked partial results of the streams of the users
+// for which enabled returns true, when their rolling window is not enabled.
+// Every chunk of new audio is decoded once and its segments are sent as
+// partial results that are not revised, which costs less than the rolling
+// window but may cut words at the edges of the chunks. The final result
+// still comes from the decoding of the whole recording on close
+func (w *WhisperTranscriber) SetChunks(chunk time.Duration, enabled func(user string) bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.chunk = chunk
+	w.chunkEnabled = enabled
+}
+
+// liveSegment is a segment of a decoding of the window, in seconds of the
+// stream
+type liveSegment struct {
+	start, end float64
+	text       string
+}
+
+// rollingWindow decodes the last audio of a stream in the background
+type rollingWindow struct {
+	stream  *WhisperStream
+	window  int     // Bytes of audio decoded
+	step    int     // Bytes of new audio between two decodings
+	chunked bool    // Whether the audio is decoded once, in chunks of step bytes
+	rate    float64 // Bytes of audio per second, the rate of the recording
+
+	mu      sync.Mutex
+	buffer  []byte  // Audio of the window
+	offset  float64 // Seconds of the stream before the window
+	pending int     // Bytes received since the last decoding
+
+	wake   chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+	cancel context.CancelFunc
+
+	// Decoding state, owned by the loop
+	previous  []liveSegment  // Hypothesis of the last decoding
+	committed int            // Segments that left the window
+	sent      map[int]string // Text last sent per segment id
+}
+
+// newRollingWindow starts decoding the audio of the stream, once in chunks
+// of step when chunked
+func newRollingWindow(stream *WhisperStream, window, step time.Duration, chunked bool) *rollingWindow {
+	ctx, cancel := context.WithCancel(stream.ctx)
+	rate := float64(stream.wav.Format().ByteRate())
+	rw := &rollingWindow{
+		stream:  stream,
+		window:  int(window.Seconds() * rate),
+		step:    int(step.Seconds() * rate),
+		chunked: chunked,
+		rate:    rate,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+		cancel:  cancel,
+		sent:    make(map[int]string),
+	}
+	go rw.run(ctx)
+	return rw
+}
+
+// write appends the audio of the recording to the window
+func (rw *rollingWindow) write(pcm []byte) {
+	rw.mu.Lock()
+	rw.buffer = append(rw.buffer, pcm...)
+	rw.pending += len(pcm)
+	ready := rw.pending >= rw.step && len(rw.buffer) >= int(minRollingAudio.Seconds()*rw.rate)
+	rw.mu.Unlock()
+	if ready {
+		select {
+		case rw.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stop ends the decodings, it returns once no result can be sent anymore
+func (rw *rollingWindow) stop() {
+	close(rw.done)
+	rw.cancel()
+	<-rw.exited
+}
+
+func (rw *rollingWindow) run(ctx context.Context) {
+	defer close(rw.exited)
+	for {
+		select {
+		case <-rw.done:
+			return
+		case <-rw.wake:
+		}
+		rw.mu.Lock()
+		buffer := append([]byte(nil), rw.buffer...)
+		offset := rw.offset
+		rw.pending = 0
+		if rw.chunked {
+			// The next chunk starts after this one
+			rw.buffer = nil
+			rw.offset += float64(len(buffer)) / rw.rate
+		}
+		rw.mu.Unlock()
+
+		segments, err := rw.decode(ctx, buffer, offset)
+		if err != nil {
+			if ctx.Err() == nil {
+				logSampler.Printf("whisper:rolling", "Error decoding the rolling window: %v", err)
+			}
+			continue
+		}
+		if rw.chunked {
+			rw.sendChunk(segments)
+			continue
+		}
+		rw.update(segments, offset+float64(len(buffer))/rw.rate)
+	}
+}
+
+// decode runs Whisper on the audio of the window
+func (rw *rollingWindow) decode(ctx context.Context, buffer []byte, offset float64) ([]liveSegment, error) {
+	ws := rw.stream
+	base := strings.TrimSuffix(filepath.Base(ws.filePath), ".wav") + "_window"
+	audioPath := filepath.Join(ws.transcriber.tempDir, base+".wav")
+	file, err := os.Create(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(audioPath)
+	writer, err := wav.NewWriter(file, ws.wav.Format())
+	if err == nil {
+		_, err = writer.Write(buffer)
+	}
+	if err == nil {
+		err = writer.Finalize()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	outputFile, _, err := ws.transcriber.run(ctx, audioPath, ws.model, ws.language, ws.task, ws.prompt, "srt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(outputFile)
+	content, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcription output: %w", err)
+	}
+	segments := parseSRT(string(content))
+	for i := range segments {
+		segments[i].start += offset
+		segments[i].end += offset
+	}
+	return segments, nil
+}
+
+// update sends the segments of a decoding ending at end seconds, and slides
+// the window past the segments agreed on when it is full
+func (rw *rollingWindow) update(segments []liveSegment, end float64) {
+	// The leading segments of two consecutive decodings agreeing are stable
+	agreed := 0
+	for agreed < len(segments) && agreed < len(rw.previous) && sameText(segments[agreed].text, rw.previous[agreed].text) {
+		agreed++
+	}
+
+	for i, segment := range segments {
+		rw.send(rw.committed+i+1, segment.text)
+	}
+	// Remove the segments that disappeared from the hypothesis
+	for id := range rw.sent {
+		if id > rw.committed+len(segments) {
+			rw.send(id, "")
+			delete(rw.sent, id)
+		}
+	}
+	rw.previous = segments
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.buffer) <= rw.window {
+		return
+	}
+	// Slide the window past the agreed segments, or drop its oldest audio
+	// with the segments it holds when nothing was agreed on
+	cut := end - float64(rw.window)/rw.rate
+	keep := 0
+	if agreed > 0 {
+		cut = segments[agreed-1].end
+		keep = agreed
+	} else {
+		for keep < len(segments) && segments[keep].end <= cut {
+			keep++
+		}
+	}
+	drop := int((cut-rw.offset)*rw.rate) &^ 1
+	if drop <= 0 {
+		return
+	}
+	if drop > len(rw.buffer) {
+		drop = len(rw.buffer)
+	}
+	rw.buffer = append([]byte(nil), rw.buffer[drop:]...)
+	rw.offset += float64(drop) / rw.rate
+	for id := range rw.sent {
+		if id <= rw.committed+keep {
+			delete(rw.sent, id)
+		}
+	}
+	rw.committed += keep
+	rw.previous = rw.previous[keep:]
+}
+
+// sendChunk emits the segments of a chunk, they are never revised
+func (rw *rollingWindow) sendChunk(segments []liveSegment) {
+	for _, segment := range segments {
+		rw.committed++
+		sendResult(rw.stream.ctx, rw.stream.results, Result{Text: segment.text, Confidence: 0.5, Segment: rw.committed}, rw.stream.transcriber.vendor())
+	}
+}
+
+// send emits a partial result revising a segment when its text changed
+func (rw *rollingWindow) send(id int, text string) {
+	if previous, ok := rw.sent[id]; ok && previous == text || !ok && text == "" {
+		return
+	}
+	rw.sent[id] = text
+	sendResult(rw.stream.ctx, rw.stream.results, Result{Text: text, Confidence: 0.5, Segment: id}, rw.stream.transcriber.vendor())
+}
+
+// parseSRT returns the segments of a SubRip document
+func parseSRT(content string) []liveSegment {
+	var segments []liveSegment
+	var current *liveSegment
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.Contains(line, "-->"):
+			parts := strings.Split(line, "-->")
+			segments = append(segments, liveSegment{
+				start: parseSRTTime(parts[0]),
+				end:   parseSRTTime(parts[1]),
+			})
+			current = &segments[len(segments)-1]
+		case line == "":
+			current = nil
+		case current != nil:
+			current.text = strings.TrimSpace(current.text + " " + line)
+		}
+	}
+	// Drop the empty segments
+	result := segments[:0]
+	for _, segment := range segments {
+		if segment.text != "" {
+			result = append(result, segment)
+		}
+	}
+	return result
+}
+
+// parseSRTTime parses a HH:MM:SS,mmm timestamp to seconds
+func parseSRTTime(value string) float64 {
+	parts := strings.Split(strings.Replace(strings.TrimSpace(value), ",", ".", 1), ":")
+	seconds := 0.0
+	for _, part := range parts {
+		v, _ := strconv.ParseFloat(part, 64)
+		seconds = seconds*60 + v
+	}
+	return seconds
+}
+
+// sameText compares two segments ignoring the case and the punctuation
+func sameText(a, b string) bool {
+	normalize := func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		}), " ")
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,251 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// APIs of the Whisper servers, see NewWhisperServerTranscriber
+const (
+	WhisperServerCpp    = "whisper.cpp"    // whisper.cpp server, POST /inference
+	WhisperServerOpenAI = "openai"         // OpenAI compatible, e.g. speaches or faster-whisper-server
+	WhisperServerASR    = "asr-webservice" // whisper-asr-webservice, POST /asr
+)
+
+// whisperServerResponse is the JSON response of the Whisper servers, the
+// JSON output of the Whisper executables has the same fields
+type whisperServerResponse struct {
+	Text     string `json:"text"`
+	Language string `json:"language"` // Name or code of the language, e.g. "english" or "en"
+	Segments []struct {
+		Words []whisperWord `json:"words"`
+	} `json:"segments"`
+	Words []whisperWord `json:"words"` // Words of the OpenAI API, outside of the segments
+}
+
+// whisperWord is a word of a JSON response with word timestamps
+type whisperWord struct {
+	Word        string  `json:"word"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float32 `json:"probability"`
+}
+
+// words returns the words of the response, from its segments or outside of them
+func (r *whisperServerResponse) words() []Word {
+	found := r.Words
+	for _, segment := range r.Segments {
+		found = append(found, segment.Words...)
+	}
+	var words []Word
+	for _, w := range found {
+		text := strings.TrimSpace(w.Word)
+		if text == "" {
+			continue
+		}
+		words = append(words, Word{
+			Word:       text,
+			Start:      w.Start,
+			End:        w.End,
+			Confidence: w.Probability,
+		})
+	}
+	return words
+}
+
+// runServer posts the audio file to the Whisper server, which keeps its
+// model loaded between the streams. It returns the path of the output like
+// run, the output mirrors the log line of the detected language of the
+// Whisper executables
+func (w *WhisperTranscriber) runServer(ctx context.Context, audioPath, language, task, prompt, format string) (string, []byte, error) {
+	if w.serverAPI == WhisperServerASR {
+		return w.runASR(ctx, audioPath, language, task, prompt, format)
+	}
+
+	responseFormat := format
+	if format == "txt" || format == "json" {
+		responseFormat = "verbose_json"
+	}
+	if language == "" {
+		language = "auto"
+	}
+
+	fields := map[string]string{
+		"language":        language,
+		"response_format": responseFormat,
+		"temperature":     "0.0",
+		"translate":       fmt.Sprint(task == TaskTranslate),
+	}
+	if prompt != "" {
+		fields["prompt"] = prompt
+	}
+	content, err := postAudio(ctx, w.serverURL+"/inference", "", "file", audioPath, fields)
+	if err != nil {
+		return "", nil, fmt.Errorf("whisper server request failed: %w", err)
+	}
+
+	if format == "txt" {
+		return w.writeJSONOutput(audioPath, format, content)
+	}
+	return w.writeOutput(audioPath, format, content, nil)
+}
+
+// runASR posts the audio file to the /asr endpoint of whisper-asr-webservice
+func (w *WhisperTranscriber) runASR(ctx context.Context, audioPath, language, task, prompt, format string) (string, []byte, error) {
+	query := url.Values{}
+	query.Set("task", task)
+	query.Set("encode", "true")
+	query.Set("output", format)
+	if format == "txt" {
+		query.Set("output", "json")
+	}
+	if format == "json" {
+		query.Set("word_timestamps", "true")
+	}
+	if language != "" && language != "auto" {
+		query.Set("language", baseLanguage(language))
+	}
+	if prompt != "" {
+		query.Set("initial_prompt", prompt)
+	}
+
+	content, err := postAudio(ctx, w.serverURL+"/asr?"+query.Encode(), "", "audio_file", audioPath, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("whisper server request failed: %w", err)
+	}
+	if format == "txt" {
+		return w.writeJSONOutput(audioPath, format, content)
+	}
+	return w.writeOutput(audioPath, format, content, nil)
+}
+
+// writeJSONOutput saves the text of a JSON response of a transcription API
+// like writeOutput, along with the log line of the language it detected
+func (w *WhisperTranscriber) writeJSONOutput(audioPath, format string, content []byte) (string, []byte, error) {
+	var result whisperServerResponse
+	if err := json.Unmarshal(content, &result); err != nil {
+		return "", nil, fmt.Errorf("invalid transcription response: %w", err)
+	}
+	var output []byte
+	if result.Language != "" {
+		output = []byte(fmt.Sprintf("Detected language: %s\n", result.Language))
+	}
+	return w.writeOutput(audioPath, format, []byte(strings.TrimSpace(result.Text)+"\n"), output)
+}
+
+// writeOutput saves the content returned by a transcription API next to
+// the output of the Whisper executables, named after the audio file
+func (w *WhisperTranscriber) writeOutput(audioPath, format string, content, output []byte) (string, []byte, error) {
+	base := strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))
+	outputFile := filepath.Join(w.tempDir, base+"."+format)
+	if err := ioutil.WriteFile(outputFile, content, 0644); err != nil {
+		return "", output, err
+	}
+	return outputFile, output, nil
+}
+
+// postAudio posts the audio file as the field fileField of a multipart form
+// with the fields to url, authenticated with the bearer token apiKey when
+// not empty, and returns the body of the response
+func postAudio(ctx context.Context, url, apiKey, fileField, audioPath string, fields map[string]string) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(fileField, filepath.Base(audioPath))
+	if err != nil {
+		return nil, err
+	}
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(part, audio)
+	audio.Close()
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %d: %s", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+	return content, nil
+}
+
+// NewWhisperServerTranscriber creates a new instance of the transcribe.Service that
+// transcribes with the Whisper server at serverURL speaking api (WhisperServerCpp when
+// empty) instead of running Whisper for every stream. The server may run on another
+// host, e.g. with a GPU. The model is the one the server loaded, except for the OpenAI
+// compatible servers which are asked for model with the optional apiKey
+func NewWhisperServerTranscriber(ctx context.Context, serverURL, api, apiKey, model, tempDir, language string, keepWav, keepTxt bool) (Service, error) {
+	if serverURL == "" {
+		return nil, fmt.Errorf("serverURL is required")
+	}
+	if api == "" {
+		api = WhisperServerCpp
+	}
+	switch api {
+	case WhisperServerCpp, WhisperServerASR:
+	case WhisperServerOpenAI:
+		if model == "" {
+			return nil, fmt.Errorf("the %s Whisper server API requires a model (WHISPER_SERVER_MODEL)", api)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Whisper server API %q, supported: %s, %s, %s", api, WhisperServerCpp, WhisperServerOpenAI, WhisperServerASR)
+	}
+	if tempDir == "" {
+		tempDir = "./output"
+	}
+	if language == "" {
+		language = "auto"
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	log.Printf("Whisper transcriber initialized with %s server: %s, language: %s", api, serverURL, language)
+
+	w := &WhisperTranscriber{
+		serverURL: strings.TrimSuffix(serverURL, "/"),
+		serverAPI: api,
+		tempDir:   tempDir,
+		language:  language,
+		ctx:       ctx,
+		keepWav:   keepWav,
+		keepTxt:   keepTxt,
+	}
+	if api == WhisperServerOpenAI {
+		w.openai = &openAIConfig{baseURL: w.serverURL, apiKey: apiKey, model: model}
+	}
+	return w, nil
+}
@@ -0,0 +1,636 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+)
+
+// Lifetimes of RFC 5766
+const (
+	defaultLifetime    = 10 * time.Minute
+	maxLifetime        = time.Hour
+	permissionLifetime = 5 * time.Minute
+	channelLifetime    = 10 * time.Minute
+	nonceLifetime      = 10 * time.Minute
+)
+
+// maxAllocations bounds the relayed addresses open at once
+const maxAllocations = 1000
+
+// DefaultRealm is the realm of the credentials when none is configured
+const DefaultRealm = "webrtc-transcriber"
+
+var logSampler = logging.NewSampler(10 * time.Second)
+
+// Errors of RFC 5766 missing from pion/stun
+var (
+	err403Forbidden       = stun.ErrorCode{ErrorClass: 4, ErrorNumber: 3, Reason: []byte("Forbidden")}
+	err486AllocationQuota = stun.ErrorCode{ErrorClass: 4, ErrorNumber: 86, Reason: []byte("Allocation Quota Reached")}
+)
+
+// Config holds the TURN server configuration
+type Config struct {
+	Addr     string // UDP listen address, e.g. :3478
+	PublicIP net.IP // Relayed address announced to the clients, detected when nil
+	Realm    string
+	Username string
+	Password string
+	PortMin  int // First port of the relayed addresses, any free port when zero
+	PortMax  int // Last port of the relayed addresses
+	// Port range of the peers, the ICE UDP ports of the transcriber, any
+	// port when zero
+	PeerPortMin int
+	PeerPortMax int
+}
+
+// Server is a TURN server (RFC 5766) over UDP relaying the media of the
+// clients that cannot reach the transcriber directly, and a STUN server for
+// their reflexive candidates. It only relays to the addresses of this host
+// and its ICE ports, the credentials being handed to every logged-in user
+type Server struct {
+	config      Config
+	key         []byte // Long-term credential key, MD5(username:realm:password)
+	secret      []byte // Signs the nonces
+	peers       []net.IP
+	conn        *net.UDPConn
+	mu          sync.Mutex
+	allocations map[string]*allocation // By address of the client
+}
+
+// allocation is a relayed address of a client
+type allocation struct {
+	client      *net.UDPAddr
+	transaction string // Of the Allocate request, answered again when retransmitted
+	relay       *net.UDPConn
+	mu          sync.Mutex
+	expires     time.Time
+	permissions map[string]time.Time // Expiry by IP of the peer
+	channels    map[uint16]*channel
+	bindings    map[string]*channel // By address of the peer
+}
+
+// channel binds a channel number to a peer, the data then travels without
+// the STUN headers
+type channel struct {
+	number  uint16
+	peer    *net.UDPAddr
+	expires time.Time
+}
+
+// NewServer creates a new TURN server
+func NewServer(config Config) *Server {
+	if config.Realm == "" {
+		config.Realm = DefaultRealm
+	}
+	key := md5.Sum([]byte(config.Username + ":" + config.Realm + ":" + config.Password))
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &Server{
+		config:      config,
+		key:         key[:],
+		secret:      secret,
+		peers:       localIPs(config.PublicIP),
+		allocations: make(map[string]*allocation),
+	}
+}
+
+// URL returns the turn: URL the clients reach the server with, on the
+// public IP when configured or the address routing to the Internet
+func (s *Server) URL() (string, error) {
+	addr, err := net.ResolveUDPAddr("udp", s.config.Addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid TURN address: %w", err)
+	}
+	ip := s.config.PublicIP
+	if ip == nil && addr.IP != nil && !addr.IP.IsUnspecified() {
+		ip = addr.IP
+	}
+	if ip == nil {
+		ip = s.localIP(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9})
+	}
+	return fmt.Sprintf("turn:%s?transport=udp", net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port))), nil
+}
+
+// ListenAndServe relays the packets of the clients until the connection
+// fails
+func (s *Server) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid TURN address: %w", err)
+	}
+	s.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer s.conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.expire(done)
+
+	buffer := make([]byte, 65535)
+	for {
+		n, remote, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		s.handle(buffer[:n], remote)
+	}
+}
+
+func (s *Server) handle(packet []byte, remote *net.UDPAddr) {
+	if stun.IsSTUN(packet) {
+		m, err := stun.NewMessage(packet)
+		if err != nil {
+			logSampler.Printf("turn:parse", "Dropping invalid STUN message from %s: %v", remote, err)
+			return
+		}
+		s.handleMessage(m, remote)
+		return
+	}
+	// ChannelData: channel number in 0x4000-0x7FFF, length and data
+	if len(packet) >= 4 && packet[0]&0xC0 == 0x40 {
+		s.handleChannelData(packet, remote)
+	}
+}
+
+func (s *Server) handleMessage(m *stun.Message, remote *net.UDPAddr) {
+	if m.Class == stun.ClassIndication && m.Method == stun.MethodSend {
+		s.handleSend(m, remote)
+		return
+	}
+	if m.Class != stun.ClassRequest {
+		return
+	}
+	if m.Method == stun.MethodBinding {
+		mapped := &stun.XorMappedAddress{XorAddress: stun.XorAddress{IP: remote.IP, Port: remote.Port}}
+		s.reply(m, remote, false, nil, mapped)
+		return
+	}
+	if !s.authenticate(m, remote) {
+		return
+	}
+
+	var attrs []stun.Attribute
+	var failure *stun.ErrorCode
+	switch m.Method {
+	case stun.MethodAllocate:
+		attrs, failure = s.allocate(m, remote)
+	case stun.MethodRefresh:
+		attrs, failure = s.refresh(m, remote)
+	case stun.MethodCreatePermission:
+		failure = s.createPermission(m, remote)
+	case stun.MethodChannelBind:
+		failure = s.channelBind(m, remote)
+	default:
+		failure = &stun.Err400BadRequest
+	}
+	s.reply(m, remote, true, failure, attrs...)
+}
+
+// authenticate checks the long-term credentials of a request, challenging
+// the client with the realm and a nonce when they are missing or wrong
+func (s *Server) authenticate(m *stun.Message, remote *net.UDPAddr) bool {
+	integrity, hasIntegrity := m.GetOneAttribute(stun.AttrMessageIntegrity)
+	username, hasUsername := m.GetOneAttribute(stun.AttrUsername)
+	nonce, hasNonce := m.GetOneAttribute(stun.AttrNonce)
+	switch {
+	case !hasIntegrity || !hasUsername || !hasNonce:
+		s.challenge(m, remote, stun.Err401Unauthorized)
+		return false
+	case !s.validNonce(string(nonce.Value)):
+		s.challenge(m, remote, stun.Err438StaleNonce)
+		return false
+	case string(username.Value) != s.config.Username || !s.validIntegrity(m, integrity):
+		logSampler.Printf("turn:unauthorized", "Rejecting TURN %s with wrong credentials from %s", m.Method, remote)
+		s.challenge(m, remote, stun.Err401Unauthorized)
+		return false
+	}
+	return true
+}
+
+// validIntegrity checks the MESSAGE-INTEGRITY of a request, the HMAC of the
+// message up to it with the length covering it
+func (s *Server) validIntegrity(m *stun.Message, integrity *stun.RawAttribute) bool {
+	raw := make([]byte, integrity.Offset)
+	copy(raw, m.Raw[:integrity.Offset])
+	binary.BigEndian.PutUint16(raw[2:4], uint16(integrity.Offset-20+4+len(integrity.Value)))
+	expected, err := stun.MessageIntegrityCalculateHMAC(s.key, raw)
+	return err == nil && hmac.Equal(expected, integrity.Value)
+}
+
+// newNonce returns a nonce signing its creation time, so that the server
+// keeps no state for the clients not yet authenticated
+func (s *Server) newNonce() string {
+	created := strconv.FormatInt(time.Now().Unix(), 16)
+	return created + "-" + s.sign(created)
+}
+
+func (s *Server) validNonce(nonce string) bool {
+	parts := strings.SplitN(nonce, "-", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return false
+	}
+	created, err := strconv.ParseInt(parts[0], 16, 64)
+	return err == nil && time.Since(time.Unix(created, 0)) < nonceLifetime
+}
+
+func (s *Server) sign(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (s *Server) challenge(m *stun.Message, remote *net.UDPAddr, failure stun.ErrorCode) {
+	s.reply(m, remote, false, &failure, &stun.Realm{Realm: s.config.Realm}, &stun.Nonce{Nonce: s.newNonce()})
+}
+
+// reply answers a request, with a MESSAGE-INTEGRITY when it was
+// authenticated
+func (s *Server) reply(m *stun.Message, remote *net.UDPAddr, authenticated bool, failure *stun.ErrorCode, attrs ...stun.Attribute) {
+	class := stun.ClassSuccessResponse
+	if failure != nil {
+		class = stun.ClassErrorResponse
+		attrs = append([]stun.Attribute{failure}, attrs...)
+	}
+	if authenticated {
+		attrs = append(attrs, &stun.MessageIntegrity{Key: s.key})
+	}
+	response, err := stun.Build(class, m.Method, m.TransactionID, attrs...)
+	if err != nil {
+		logSampler.Printf("turn:build", "Failed to build the TURN %s response: %v", m.Method, err)
+		return
+	}
+	s.conn.WriteToUDP(response.Pack(), remote)
+}
+
+func (s *Server) allocate(m *stun.Message, remote *net.UDPAddr) ([]stun.Attribute, *stun.ErrorCode) {
+	transport, ok := m.GetOneAttribute(stun.AttrRequestedTransport)
+	if !ok || len(transport.Value) == 0 {
+		return nil, &stun.Err400BadRequest
+	}
+	var requested stun.RequestedTransport
+	if err := requested.Unpack(m, transport); err != nil {
+		return nil, &stun.Err442UnsupportedTransportProtocol
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.allocations[remote.String()]; ok {
+		if a.transaction != string(m.TransactionID) {
+			return nil, &stun.Err437AllocationMismatch
+		}
+		return s.allocated(a, remote), nil
+	}
+	if len(s.allocations) >= maxAllocations {
+		return nil, &err486AllocationQuota
+	}
+	relay, err := s.listenRelay()
+	if err != nil {
+		logSampler.Printf("turn:relay", "Failed to open a TURN relayed address: %v", err)
+		return nil, &stun.Err508InsufficentCapacity
+	}
+	a := &allocation{
+		client:      remote,
+		transaction: string(m.TransactionID),
+		relay:       relay,
+		expires:     time.Now().Add(lifetime(m, defaultLifetime)),
+		permissions: make(map[string]time.Time),
+		channels:    make(map[uint16]*channel),
+		bindings:    make(map[string]*channel),
+	}
+	s.allocations[remote.String()] = a
+	go s.receive(a)
+	return s.allocated(a, remote), nil
+}
+
+func (s *Server) allocated(a *allocation, remote *net.UDPAddr) []stun.Attribute {
+	ip := s.config.PublicIP
+	if ip == nil {
+		ip = s.localIP(remote)
+	}
+	a.mu.Lock()
+	remaining := time.Until(a.expires)
+	a.mu.Unlock()
+	return []stun.Attribute{
+		&stun.XorRelayedAddress{XorAddress: stun.XorAddress{IP: ip, Port: a.relay.LocalAddr().(*net.UDPAddr).Port}},
+		&stun.Lifetime{Duration: uint32(remaining / time.Second)},
+		&stun.XorMappedAddress{XorAddress: stun.XorAddress{IP: remote.IP, Port: remote.Port}},
+	}
+}
+
+// lifetime returns the LIFETIME requested by m, bounded by maxLifetime
+func lifetime(m *stun.Message, fallback time.Duration) time.Duration {
+	attr, ok := m.GetOneAttribute(stun.AttrLifetime)
+	if !ok {
+		return fallback
+	}
+	var requested stun.Lifetime
+	if err := requested.Unpack(m, attr); err != nil {
+		return fallback
+	}
+	duration := time.Duration(requested.Duration) * time.Second
+	if duration > maxLifetime {
+		return maxLifetime
+	}
+	if duration > 0 && duration < defaultLifetime {
+		return defaultLifetime
+	}
+	return duration
+}
+
+func (s *Server) refresh(m *stun.Message, remote *net.UDPAddr) ([]stun.Attribute, *stun.ErrorCode) {
+	a := s.allocation(remote)
+	if a == nil {
+		return nil, &stun.Err437AllocationMismatch
+	}
+	duration := lifetime(m, defaultLifetime)
+	if duration == 0 {
+		s.release(a)
+		return []stun.Attribute{&stun.Lifetime{}}, nil
+	}
+	a.mu.Lock()
+	a.expires = time.Now().Add(duration)
+	a.mu.Unlock()
+	return []stun.Attribute{&stun.Lifetime{Duration: uint32(duration / time.Second)}}, nil
+}
+
+func (s *Server) createPermission(m *stun.Message, remote *net.UDPAddr) *stun.ErrorCode {
+	a := s.allocation(remote)
+	if a == nil {
+		return &stun.Err437AllocationMismatch
+	}
+	attrs, ok := m.GetAllAttributes(stun.AttrXORPeerAddress)
+	if !ok {
+		return &stun.Err400BadRequest
+	}
+	var peers []net.IP
+	for _, attr := range attrs {
+		var peer stun.XorPeerAddress
+		if err := peer.Unpack(m, attr); err != nil {
+			return &stun.Err400BadRequest
+		}
+		if !s.allowedPeer(peer.IP) {
+			logSampler.Printf("turn:forbidden", "Rejecting a TURN permission to %s from %s", peer.IP, remote)
+			return &err403Forbidden
+		}
+		peers = append(peers, peer.IP)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ip := range peers {
+		a.permissions[ip.String()] = time.Now().Add(permissionLifetime)
+	}
+	return nil
+}
+
+func (s *Server) channelBind(m *stun.Message, remote *net.UDPAddr) *stun.ErrorCode {
+	a := s.allocation(remote)
+	if a == nil {
+		return &stun.Err437AllocationMismatch
+	}
+	numberAttr, hasNumber := m.GetOneAttribute(stun.AttrChannelNumber)
+	peerAttr, hasPeer := m.GetOneAttribute(stun.AttrXORPeerAddress)
+	if !hasNumber || !hasPeer {
+		return &stun.Err400BadRequest
+	}
+	var number stun.ChannelNumber
+	var peer stun.XorPeerAddress
+	if number.Unpack(m, numberAttr) != nil || peer.Unpack(m, peerAttr) != nil {
+		return &stun.Err400BadRequest
+	}
+	if number.ChannelNumber < 0x4000 || number.ChannelNumber > 0x7FFF {
+		return &stun.Err400BadRequest
+	}
+	if !s.allowedPeer(peer.IP) || !s.allowedPort(peer.Port) {
+		return &err403Forbidden
+	}
+
+	addr := &net.UDPAddr{IP: peer.IP, Port: peer.Port}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	bound, numberBound := a.channels[number.ChannelNumber]
+	other, peerBound := a.bindings[addr.String()]
+	if (numberBound && bound.peer.String() != addr.String()) || (peerBound && other.number != number.ChannelNumber) {
+		return &stun.Err400BadRequest
+	}
+	c := &channel{number: number.ChannelNumber, peer: addr, expires: time.Now().Add(channelLifetime)}
+	a.channels[c.number] = c
+	a.bindings[addr.String()] = c
+	a.permissions[peer.IP.String()] = time.Now().Add(permissionLifetime)
+	return nil
+}
+
+// handleSend relays the data of a Send indication to its peer
+func (s *Server) handleSend(m *stun.Message, remote *net.UDPAddr) {
+	a := s.allocation(remote)
+	if a == nil {
+		return
+	}
+	peerAttr, hasPeer := m.GetOneAttribute(stun.AttrXORPeerAddress)
+	dataAttr, hasData := m.GetOneAttribute(stun.AttrData)
+	if !hasPeer || !hasData {
+		return
+	}
+	var peer stun.XorPeerAddress
+	if err := peer.Unpack(m, peerAttr); err != nil {
+		return
+	}
+	if a.permitted(peer.IP) && s.allowedPort(peer.Port) {
+		a.relay.WriteToUDP(dataAttr.Value, &net.UDPAddr{IP: peer.IP, Port: peer.Port})
+	}
+}
+
+// handleChannelData relays the data of a ChannelData message to the peer of
+// its channel
+func (s *Server) handleChannelData(packet []byte, remote *net.UDPAddr) {
+	a := s.allocation(remote)
+	if a == nil {
+		return
+	}
+	number := binary.BigEndian.Uint16(packet[0:2])
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if 4+length > len(packet) {
+		return
+	}
+	a.mu.Lock()
+	c, ok := a.channels[number]
+	if ok && time.Now().After(c.expires) {
+		ok = false
+	}
+	a.mu.Unlock()
+	if ok {
+		a.relay.WriteToUDP(packet[4:4+length], c.peer)
+	}
+}
+
+// receive sends the packets of the peers to the client until the
+// allocation is released, in a ChannelData message when the peer has a
+// channel and a Data indication otherwise
+func (s *Server) receive(a *allocation) {
+	buffer := make([]byte, 65535)
+	for {
+		n, peer, err := a.relay.ReadFromUDP(buffer[4:])
+		if err != nil {
+			return
+		}
+		if !a.permitted(peer.IP) || !s.allowedPort(peer.Port) {
+			continue
+		}
+		a.mu.Lock()
+		c, ok := a.bindings[peer.String()]
+		if ok && time.Now().After(c.expires) {
+			ok = false
+		}
+		a.mu.Unlock()
+
+		if ok {
+			binary.BigEndian.PutUint16(buffer[0:2], c.number)
+			binary.BigEndian.PutUint16(buffer[2:4], uint16(n))
+			s.conn.WriteToUDP(buffer[:4+n], a.client)
+			continue
+		}
+		indication, err := stun.Build(stun.ClassIndication, stun.MethodData, stun.GenerateTransactionID(),
+			&stun.XorPeerAddress{XorAddress: stun.XorAddress{IP: peer.IP, Port: peer.Port}},
+			&stun.Data{Data: buffer[4 : 4+n]})
+		if err == nil {
+			s.conn.WriteToUDP(indication.Pack(), a.client)
+		}
+	}
+}
+
+// permitted reports whether the allocation has a permission for the peer
+func (a *allocation) permitted(ip net.IP) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	expires, ok := a.permissions[ip.String()]
+	return ok && time.Now().Before(expires)
+}
+
+func (s *Server) allocation(remote *net.UDPAddr) *allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.allocations[remote.String()]
+}
+
+func (s *Server) release(a *allocation) {
+	s.mu.Lock()
+	delete(s.allocations, a.client.String())
+	s.mu.Unlock()
+	a.relay.Close()
+}
+
+// expire releases the allocations not refreshed in time until done is
+// closed
+func (s *Server) expire(done chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			s.mu.Lock()
+			for _, a := range s.allocations {
+				a.relay.Close()
+			}
+			s.allocations = make(map[string]*allocation)
+			s.mu.Unlock()
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, a := range s.allocations {
+				a.mu.Lock()
+				expired := now.After(a.expires)
+				a.mu.Unlock()
+				if expired {
+					delete(s.allocations, id)
+					a.relay.Close()
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// listenRelay opens the UDP port of a relayed address, in the port range
+// when one is configured
+func (s *Server) listenRelay() (*net.UDPConn, error) {
+	if s.config.PortMin == 0 {
+		return net.ListenUDP("udp4", &net.UDPAddr{})
+	}
+	for port := s.config.PortMin; port <= s.config.PortMax; port++ {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no free relay port in %d-%d", s.config.PortMin, s.config.PortMax)
+}
+
+// allowedPeer reports whether the server relays to ip, one of its own
+// addresses
+func (s *Server) allowedPeer(ip net.IP) bool {
+	for _, local := range s.peers {
+		if local.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedPort reports whether the server relays to port, one of the ICE
+// ports when their range is configured
+func (s *Server) allowedPort(port int) bool {
+	if s.config.PeerPortMin == 0 {
+		return port > 0
+	}
+	return port >= s.config.PeerPortMin && port <= s.config.PeerPortMax
+}
+
+// localIP returns the local address routing to the remote
+func (s *Server) localIP(remote *net.UDPAddr) net.IP {
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// localIPs returns the addresses of the interfaces of the host and its
+// public IP, the candidates of the transcriber. The loopback and link-local
+// addresses are left out: the services of the host listening on them must
+// not be reachable through the relay
+func localIPs(public net.IP) []net.IP {
+	var ips []net.IP
+	if candidate(public) {
+		ips = append(ips, public)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && candidate(network.IP) {
+			ips = append(ips, network.IP)
+		}
+	}
+	return ips
+}
+
+// candidate reports whether ip can be the address of an ICE candidate
+func candidate(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
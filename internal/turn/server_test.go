@@ -0,0 +1,336 @@
+package turn
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+var loopback = net.IPv4(127, 0, 0, 1)
+
+// startServer serves the TURN requests on a loopback port until the test
+// ends. The peers of the tests listen on the loopback address, left out by
+// NewServer, so they are allowed with peers
+func startServer(t *testing.T, config Config, peers ...net.IP) (*Server, *net.UDPAddr) {
+	t.Helper()
+	config.Username, config.Password = "transcriber", "secret"
+	s := NewServer(config)
+	if len(peers) > 0 {
+		s.peers = peers
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.conn = conn
+	done := make(chan struct{})
+	go s.expire(done)
+	t.Cleanup(func() {
+		conn.Close()
+		close(done)
+	})
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, remote, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			s.handle(buffer[:n], remote)
+		}
+	}()
+	return s, conn.LocalAddr().(*net.UDPAddr)
+}
+
+// listen opens a UDP socket on the loopback address closed when the test
+// ends
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// rawAttribute packs an attribute pion/stun cannot, REQUESTED-TRANSPORT, or
+// packs without its RFFU bytes, CHANNEL-NUMBER
+type rawAttribute struct {
+	typ   stun.AttrType
+	value []byte
+}
+
+func (a *rawAttribute) Pack(m *stun.Message) error {
+	m.AddAttribute(a.typ, a.value)
+	return nil
+}
+
+func (a *rawAttribute) Unpack(m *stun.Message, raw *stun.RawAttribute) error {
+	return nil
+}
+
+var udpTransport = &rawAttribute{stun.AttrRequestedTransport, []byte{17, 0, 0, 0}}
+
+func channelNumber(number uint16) *rawAttribute {
+	return &rawAttribute{stun.AttrChannelNumber, []byte{byte(number >> 8), byte(number), 0, 0}}
+}
+
+// testClient is a TURN client authenticating with the credentials of
+// startServer
+type testClient struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	server   *net.UDPAddr
+	password string
+	nonce    string
+}
+
+func newClient(t *testing.T, server *net.UDPAddr) *testClient {
+	return &testClient{t: t, conn: listen(t), server: server, password: "secret"}
+}
+
+// read returns the next packet from the server
+func (c *testClient) read() []byte {
+	c.t.Helper()
+	buffer := make([]byte, 65535)
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := c.conn.ReadFromUDP(buffer)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return buffer[:n]
+}
+
+func (c *testClient) send(packet []byte) {
+	c.t.Helper()
+	if _, err := c.conn.WriteToUDP(packet, c.server); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// request sends an authenticated request, once more with the nonce of the
+// server when challenged
+func (c *testClient) request(method stun.Method, transaction []byte, attrs ...stun.Attribute) *stun.Message {
+	c.t.Helper()
+	for {
+		var all []stun.Attribute
+		if c.nonce != "" {
+			all = append(all, &stun.Username{Username: "transcriber"}, &stun.Realm{Realm: DefaultRealm}, &stun.Nonce{Nonce: c.nonce})
+		}
+		all = append(all, attrs...)
+		if c.nonce != "" {
+			key := md5.Sum([]byte("transcriber:" + DefaultRealm + ":" + c.password))
+			all = append(all, &stun.MessageIntegrity{Key: key[:]})
+		}
+		m, err := stun.Build(stun.ClassRequest, method, transaction, all...)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.send(m.Pack())
+		response, err := stun.NewMessage(c.read())
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if !bytes.Equal(response.TransactionID, transaction) {
+			c.t.Fatalf("response to another transaction")
+		}
+		nonce, ok := response.GetOneAttribute(stun.AttrNonce)
+		if c.nonce != "" || !ok || errorCode(response) != 401 {
+			return response
+		}
+		c.nonce = string(nonce.Value)
+	}
+}
+
+// errorCode returns the ERROR-CODE of a response, 0 without one
+func errorCode(m *stun.Message) int {
+	attr, ok := m.GetOneAttribute(stun.AttrErrorCode)
+	if !ok || len(attr.Value) < 4 {
+		return 0
+	}
+	return int(attr.Value[2])*100 + int(attr.Value[3])
+}
+
+func peerAddress(addr *net.UDPAddr) *stun.XorPeerAddress {
+	return &stun.XorPeerAddress{XorAddress: stun.XorAddress{IP: addr.IP, Port: addr.Port}}
+}
+
+// allocate allocates a relayed address and returns it
+func (c *testClient) allocate() *net.UDPAddr {
+	c.t.Helper()
+	response := c.request(stun.MethodAllocate, stun.GenerateTransactionID(), udpTransport)
+	if response.Class != stun.ClassSuccessResponse {
+		c.t.Fatalf("Allocate failed with %d", errorCode(response))
+	}
+	attr, ok := response.GetOneAttribute(stun.AttrXORRelayedAddress)
+	if !ok {
+		c.t.Fatal("no XOR-RELAYED-ADDRESS")
+	}
+	var relayed stun.XorRelayedAddress
+	if err := relayed.Unpack(response, attr); err != nil {
+		c.t.Fatal(err)
+	}
+	return &net.UDPAddr{IP: relayed.IP, Port: relayed.Port}
+}
+
+func TestLocalIPsExcludeLoopbackAndLinkLocal(t *testing.T) {
+	public := net.ParseIP("203.0.113.10")
+	ips := localIPs(public)
+	if len(ips) == 0 || !ips[0].Equal(public) {
+		t.Errorf("localIPs = %v, want the public IP first", ips)
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			t.Errorf("localIPs returned %s", ip)
+		}
+	}
+	if ips := localIPs(loopback); len(ips) > 0 && ips[0].Equal(loopback) {
+		t.Error("loopback public IP allowed")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	_, server := startServer(t, Config{})
+	c := newClient(t, server)
+	transaction := stun.GenerateTransactionID()
+
+	// The first request is challenged
+	response := c.request(stun.MethodAllocate, transaction, udpTransport)
+	if response.Class != stun.ClassSuccessResponse {
+		t.Fatalf("Allocate failed with %d", errorCode(response))
+	}
+	if c.nonce == "" {
+		t.Error("the request without credentials was not challenged")
+	}
+	attr, ok := response.GetOneAttribute(stun.AttrLifetime)
+	var lifetime stun.Lifetime
+	if !ok || lifetime.Unpack(response, attr) != nil || time.Duration(lifetime.Duration)*time.Second < defaultLifetime-5*time.Second {
+		t.Errorf("lifetime of %d s, want %v", lifetime.Duration, defaultLifetime)
+	}
+
+	// A retransmission gets the same relayed address, another Allocate fails
+	relayed := response.Raw
+	if again := c.request(stun.MethodAllocate, transaction, udpTransport); again.Class != stun.ClassSuccessResponse {
+		t.Errorf("retransmitted Allocate failed with %d", errorCode(again))
+	} else if a, _ := again.GetOneAttribute(stun.AttrXORRelayedAddress); a == nil || !bytes.Contains(relayed, a.Value) {
+		t.Error("retransmitted Allocate got another relayed address")
+	}
+	if other := c.request(stun.MethodAllocate, stun.GenerateTransactionID(), udpTransport); errorCode(other) != 437 {
+		t.Errorf("second Allocate = %d, want 437", errorCode(other))
+	}
+
+	// Without REQUESTED-TRANSPORT or the right password
+	if response := newClient(t, server).request(stun.MethodAllocate, stun.GenerateTransactionID()); errorCode(response) != 400 {
+		t.Errorf("Allocate without transport = %d, want 400", errorCode(response))
+	}
+	intruder := newClient(t, server)
+	intruder.password = "wrong"
+	if response := intruder.request(stun.MethodAllocate, stun.GenerateTransactionID(), udpTransport); errorCode(response) != 401 {
+		t.Errorf("Allocate with a wrong password = %d, want 401", errorCode(response))
+	}
+}
+
+func TestCreatePermission(t *testing.T) {
+	_, server := startServer(t, Config{})
+	c := newClient(t, server)
+	c.allocate()
+	for _, ip := range []string{"127.0.0.1", "::1", "169.254.169.254", "fe80::1", "198.51.100.1"} {
+		peer := &net.UDPAddr{IP: net.ParseIP(ip), Port: 50000}
+		response := c.request(stun.MethodCreatePermission, stun.GenerateTransactionID(), peerAddress(peer))
+		if errorCode(response) != 403 {
+			t.Errorf("permission to %s = %d, want 403", ip, errorCode(response))
+		}
+	}
+	if response := newClient(t, server).request(stun.MethodCreatePermission, stun.GenerateTransactionID(),
+		peerAddress(&net.UDPAddr{IP: loopback, Port: 50000})); errorCode(response) != 437 {
+		t.Errorf("permission without allocation = %d, want 437", errorCode(response))
+	}
+}
+
+func TestRelayThroughPermission(t *testing.T) {
+	_, server := startServer(t, Config{}, loopback)
+	c := newClient(t, server)
+	relayed := c.allocate()
+	peer := listen(t)
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	if response := c.request(stun.MethodCreatePermission, stun.GenerateTransactionID(), peerAddress(peerAddr)); response.Class != stun.ClassSuccessResponse {
+		t.Fatalf("CreatePermission failed with %d", errorCode(response))
+	}
+
+	send, err := stun.Build(stun.ClassIndication, stun.MethodSend, stun.GenerateTransactionID(),
+		peerAddress(peerAddr), &stun.Data{Data: []byte("to peer")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send(send.Pack())
+	buffer := make([]byte, 1500)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := peer.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffer[:n]) != "to peer" || from.Port != relayed.Port {
+		t.Errorf("peer received %q from %s", buffer[:n], from)
+	}
+
+	peer.WriteToUDP([]byte("to client"), relayed)
+	indication, err := stun.NewMessage(c.read())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ok := indication.GetOneAttribute(stun.AttrData)
+	if indication.Method != stun.MethodData || !ok || string(data.Value) != "to client" {
+		t.Errorf("client received %s %q", indication.Method, data.Value)
+	}
+}
+
+func TestChannelBind(t *testing.T) {
+	peer := listen(t)
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	// Only the port of the peer is in the ICE port range
+	_, server := startServer(t, Config{PeerPortMin: peerAddr.Port, PeerPortMax: peerAddr.Port}, loopback)
+	c := newClient(t, server)
+	relayed := c.allocate()
+
+	bind := func(number uint16, addr *net.UDPAddr) int {
+		return errorCode(c.request(stun.MethodChannelBind, stun.GenerateTransactionID(),
+			channelNumber(number), peerAddress(addr)))
+	}
+	if code := bind(0x3FFF, peerAddr); code != 400 {
+		t.Errorf("bind of channel 0x3FFF = %d, want 400", code)
+	}
+	if code := bind(0x4000, &net.UDPAddr{IP: loopback, Port: peerAddr.Port + 1}); code != 403 {
+		t.Errorf("bind to a port out of the ICE range = %d, want 403", code)
+	}
+	if code := bind(0x4000, peerAddr); code != 0 {
+		t.Fatalf("ChannelBind failed with %d", code)
+	}
+	if code := bind(0x4001, peerAddr); code != 400 {
+		t.Errorf("second channel to the peer = %d, want 400", code)
+	}
+
+	channelData := []byte{0x40, 0x00, 0, 7}
+	c.send(append(channelData, "to peer"...))
+	buffer := make([]byte, 1500)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := peer.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffer[:n]) != "to peer" {
+		t.Errorf("peer received %q", buffer[:n])
+	}
+
+	peer.WriteToUDP([]byte("to client"), relayed)
+	packet := c.read()
+	if len(packet) < 4 || binary.BigEndian.Uint16(packet) != 0x4000 || string(packet[4:]) != "to client" {
+		t.Errorf("client received %x", packet)
+	}
+}
@@ -0,0 +1,285 @@
+package twilio
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the errors of malformed media messages
+var logSampler = logging.NewSampler(10 * time.Second)
+
+// SignatureHeader carries the signature of the WebSocket URL computed by
+// Twilio with the account auth token
+const SignatureHeader = "X-Twilio-Signature"
+
+// Config holds the Twilio Media Streams configuration
+type Config struct {
+	AuthToken    string // Account auth token validating the request signatures
+	PublicURL    string // Public base URL of the server, the signed URL is built from it
+	Language     string // Default language, overridden by a "language" <Parameter>
+	Authenticate func(key string) (string, bool)
+	Events       events.Publisher // Receives the transcript events, none when nil
+}
+
+// event is a message of the Twilio Media Streams protocol
+type event struct {
+	Event     string `json:"event"`
+	StreamSid string `json:"streamSid"`
+	Start     struct {
+		CallSid          string            `json:"callSid"`
+		Tracks           []string          `json:"tracks"`
+		CustomParameters map[string]string `json:"customParameters"`
+		MediaFormat      struct {
+			Encoding   string `json:"encoding"`
+			SampleRate int    `json:"sampleRate"`
+		} `json:"mediaFormat"`
+	} `json:"start"`
+	Media struct {
+		Track   string `json:"track"`
+		Payload string `json:"payload"`
+	} `json:"media"`
+}
+
+// Transcript is the payload of the event published for every final
+// segment of a track ("twilio.transcript") and once the call ends
+// ("twilio.completed")
+type Transcript struct {
+	Event      string  `json:"event"`
+	CallSid    string  `json:"call_sid"`
+	StreamSid  string  `json:"stream_sid"`
+	User       string  `json:"user,omitempty"`
+	Track      string  `json:"track,omitempty"`
+	Text       string  `json:"text"`
+	Confidence float32 `json:"confidence,omitempty"`
+	Time       string  `json:"time"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 1024,
+}
+
+// MakeHandler returns the WebSocket endpoint of a TwiML <Stream>, the
+// μ-law audio of each track is transcribed in its own stream
+func MakeHandler(service transcribe.Service, config Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validSignature(config, r) {
+			http.Error(w, "Invalid Twilio signature", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Error upgrading Twilio WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		c := &call{
+			service: service,
+			config:  config,
+			tracks:  make(map[string]*track),
+		}
+		defer c.end()
+		for {
+			conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+			var e event
+			if err := conn.ReadJSON(&e); err != nil {
+				return
+			}
+			switch e.Event {
+			case "start":
+				c.start(&e)
+			case "media":
+				c.media(&e)
+			case "stop":
+				return
+			}
+		}
+	})
+}
+
+// validSignature checks the signature of the WebSocket URL, Twilio signs
+// the URL configured in the TwiML so both the https and wss forms of the
+// public URL are accepted
+func validSignature(config Config, r *http.Request) bool {
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	base := strings.TrimSuffix(config.PublicURL, "/")
+	candidates := []string{base + r.URL.RequestURI()}
+	if strings.HasPrefix(base, "http") {
+		candidates = append(candidates, "ws"+strings.TrimPrefix(base, "http")+r.URL.RequestURI())
+	}
+	for _, url := range candidates {
+		mac := hmac.New(sha1.New, []byte(config.AuthToken))
+		mac.Write([]byte(url))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// call is a Twilio media stream, one transcription stream per track
+type call struct {
+	service   transcribe.Service
+	config    Config
+	callSid   string
+	streamSid string
+	user      string
+	language  string
+	tracks    map[string]*track
+	pending   sync.WaitGroup
+	mu        sync.Mutex
+	lines     []string
+}
+
+// track transcribes the audio of one direction of the call
+type track struct {
+	name     string
+	stream   transcribe.Stream
+	resample *audio.Upsampler
+}
+
+func (c *call) start(e *event) {
+	c.callSid = e.Start.CallSid
+	c.streamSid = e.StreamSid
+	c.language = c.config.Language
+	if language := e.Start.CustomParameters["language"]; language != "" {
+		c.language = language
+	}
+	if key := e.Start.CustomParameters["key"]; key != "" && c.config.Authenticate != nil {
+		if user, ok := c.config.Authenticate(key); ok {
+			c.user = user
+		} else {
+			logSampler.Printf("twilio:key", "Ignoring invalid stream key of Twilio call %s", c.callSid)
+		}
+	}
+	if encoding := e.Start.MediaFormat.Encoding; encoding != "" && encoding != "audio/x-mulaw" {
+		logSampler.Printf("twilio:format", "Unexpected Twilio media encoding %s", encoding)
+	}
+	log.Printf("Twilio call %s started (stream %s, tracks %v, language %s)", c.callSid, c.streamSid, e.Start.Tracks, c.language)
+}
+
+func (c *call) media(e *event) {
+	if c.streamSid == "" {
+		return
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Media.Payload)
+	if err != nil {
+		logSampler.Printf("twilio:payload", "Dropping invalid Twilio media payload: %v", err)
+		return
+	}
+	name := e.Media.Track
+	if name == "" {
+		name = "inbound"
+	}
+	t, ok := c.tracks[name]
+	if !ok {
+		if t, err = c.newTrack(name); err != nil {
+			log.Printf("Error creating transcription stream for Twilio call %s: %v", c.callSid, err)
+			return
+		}
+		c.tracks[name] = t
+	}
+	if _, err := t.stream.Write(t.resample.Process(audio.DecodeUlaw(payload))); err != nil {
+		logSampler.Printf("twilio:write", "Error writing to transcription stream: %v", err)
+	}
+}
+
+func (c *call) newTrack(name string) (*track, error) {
+	opts := transcribe.StreamOptions{
+		Language:   c.language,
+		Transcribe: true,
+		User:       c.user,
+		SessionID:  c.callSid,
+	}
+	// The tracks of an owned call are the speakers of a room named after
+	// the call, whose merged transcript interleaves both directions
+	if c.user != "" && c.callSid != "" {
+		opts.Room = c.callSid
+		opts.Speaker = name
+	}
+	stream, err := c.service.CreateStreamWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	t := &track{
+		name:     name,
+		stream:   stream,
+		resample: audio.NewUpsampler(8000, transcribe.PipelineSampleRate),
+	}
+	c.pending.Add(1)
+	go c.forwardResults(t)
+	return t, nil
+}
+
+// forwardResults posts the final segments of a track as they arrive
+func (c *call) forwardResults(t *track) {
+	defer c.pending.Done()
+	for result := range t.stream.Results() {
+		text := strings.TrimSpace(result.Text)
+		if !result.Final || text == "" {
+			continue
+		}
+		c.mu.Lock()
+		c.lines = append(c.lines, "["+t.name+"] "+text)
+		c.mu.Unlock()
+		c.post(Transcript{
+			Event:      "twilio.transcript",
+			Track:      t.name,
+			Text:       text,
+			Confidence: result.Confidence,
+		})
+	}
+}
+
+// end closes the streams and posts the transcript of the whole call
+func (c *call) end() {
+	if c.streamSid == "" {
+		return
+	}
+	for _, t := range c.tracks {
+		t.stream.Close()
+	}
+	c.pending.Wait()
+	log.Printf("Twilio call %s ended", c.callSid)
+	c.post(Transcript{
+		Event: "twilio.completed",
+		Text:  strings.Join(c.lines, "\n"),
+	})
+}
+
+func (c *call) post(t Transcript) {
+	if c.config.Events == nil {
+		return
+	}
+	t.CallSid = c.callSid
+	t.StreamSid = c.streamSid
+	t.User = c.user
+	t.Time = time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	c.config.Events.Publish(events.Event{
+		Type:    t.Event,
+		User:    c.user,
+		Payload: payload,
+	})
+}
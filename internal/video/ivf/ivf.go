@@ -0,0 +1,113 @@
+// Package ivf writes VP8 and VP9 frames to IVF files as they are received,
+// without decoding them
+package ivf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FourCCs of the codecs of the files
+const (
+	VP8 = "VP80"
+	VP9 = "VP90"
+)
+
+// ClockRate is the rate of the timestamps of the frames, the RTP clock rate
+// of the video so that the frames keep the timing of the stream
+const ClockRate = 90000
+
+const (
+	headerSize      = 32
+	frameHeaderSize = 12
+)
+
+// Writer writes the frames of a stream to an IVF file. The header is written
+// with unknown sizes and frame count, Close completes it
+type Writer struct {
+	w      io.WriteSeeker
+	fourcc string
+	width  uint16
+	height uint16
+	frames uint32
+	closed bool
+}
+
+// NewWriter writes the header of a stream of the codec (VP8 or VP9) to w and
+// returns a Writer appending the frames after it
+func NewWriter(w io.WriteSeeker, fourcc string) (*Writer, error) {
+	if fourcc != VP8 && fourcc != VP9 {
+		return nil, fmt.Errorf("unsupported IVF codec %q, expected %s or %s", fourcc, VP8, VP9)
+	}
+	wr := &Writer{w: w, fourcc: fourcc}
+	if _, err := w.Write(wr.header()); err != nil {
+		return nil, fmt.Errorf("failed to write the IVF header: %w", err)
+	}
+	return wr, nil
+}
+
+func (wr *Writer) header() []byte {
+	header := make([]byte, headerSize)
+	copy(header[0:4], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0) // Version
+	binary.LittleEndian.PutUint16(header[6:], headerSize)
+	copy(header[8:12], wr.fourcc)
+	binary.LittleEndian.PutUint16(header[12:], wr.width)
+	binary.LittleEndian.PutUint16(header[14:], wr.height)
+	binary.LittleEndian.PutUint32(header[16:], ClockRate) // Time base denominator
+	binary.LittleEndian.PutUint32(header[20:], 1)         // Time base numerator
+	binary.LittleEndian.PutUint32(header[24:], wr.frames)
+	return header
+}
+
+// SetSize records the dimensions of the video, written to the header by
+// Close
+func (wr *Writer) SetSize(width, height int) {
+	wr.width, wr.height = uint16(width), uint16(height)
+}
+
+// WriteFrame appends a frame presented at pts, in ClockRate units from the
+// beginning of the stream
+func (wr *Writer) WriteFrame(frame []byte, pts uint64) error {
+	if wr.closed {
+		return fmt.Errorf("the IVF writer is closed")
+	}
+	header := make([]byte, frameHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], pts)
+	if _, err := wr.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := wr.w.Write(frame); err != nil {
+		return err
+	}
+	wr.frames++
+	return nil
+}
+
+// Frames returns the number of frames written
+func (wr *Writer) Frames() int {
+	return int(wr.frames)
+}
+
+// Close rewrites the header with the dimensions and the frame count, the
+// file is left at its end
+func (wr *Writer) Close() error {
+	if wr.closed {
+		return nil
+	}
+	wr.closed = true
+	end, err := wr.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := wr.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := wr.w.Write(wr.header()); err != nil {
+		return err
+	}
+	_, err = wr.w.Seek(end, io.SeekStart)
+	return err
+}
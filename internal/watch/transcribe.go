@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// transcribe decodes a dropped file, streams it through the vendor of its
+// folder and writes the final results next to it
+func (w *Watcher) transcribe(j job) error {
+	pcm, err := audio.DecodeFile(j.path, w.config.FFmpegPath, transcribe.PipelineSampleRate)
+	if err != nil {
+		return err
+	}
+	service, err := w.vendors(j.folder.Vendor)
+	if err != nil {
+		return err
+	}
+
+	stream, err := service.CreateStreamWithOptions(transcribe.StreamOptions{
+		Language:   orDefault(j.folder.Language, w.config.Language),
+		Transcribe: true,
+		User:       j.folder.User,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create transcription stream: %w", err)
+	}
+	var lines []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for result := range stream.Results() {
+			if text := strings.TrimSpace(result.Text); result.Final && text != "" {
+				lines = append(lines, text)
+			}
+		}
+	}()
+
+	var writeErr error
+	for len(pcm) > 0 {
+		// Write 100ms chunks like a live stream
+		n := 9600
+		if n > len(pcm) {
+			n = len(pcm)
+		}
+		if _, writeErr = stream.Write(pcm[:n]); writeErr != nil {
+			break
+		}
+		pcm = pcm[n:]
+	}
+	closeErr := stream.Close()
+	<-done
+	if writeErr != nil {
+		return writeErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	base := strings.TrimSuffix(j.path, filepath.Ext(j.path))
+	return writeFile(base+".txt", []byte(strings.Join(lines, "\n")+"\n"))
+}
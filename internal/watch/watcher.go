@@ -0,0 +1,222 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the errors of unreadable folders
+var logSampler = logging.NewSampler(time.Minute)
+
+// Folder is a drop folder and the settings of the files dropped in it
+type Folder struct {
+	Path      string `json:"path"`
+	Language  string `json:"language,omitempty"`  // --language when empty
+	Vendor    string `json:"vendor,omitempty"`    // --vendor when empty
+	User      string `json:"user,omitempty"`      // Owner of the transcripts
+	Recursive bool   `json:"recursive,omitempty"` // Watch the subdirectories too
+}
+
+// LoadFolders reads the JSON list of drop folders of a config file
+func LoadFolders(file string) ([]Folder, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var folders []Folder
+	if err := json.Unmarshal(data, &folders); err != nil {
+		return nil, fmt.Errorf("invalid drop folders file %s: %w", file, err)
+	}
+	for i, f := range folders {
+		if f.Path == "" {
+			return nil, fmt.Errorf("drop folder %d has no path", i+1)
+		}
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("drop folder %s is not a directory", f.Path)
+		}
+	}
+	return folders, nil
+}
+
+// VendorFunc returns the transcription service of a vendor
+type VendorFunc func(vendor string) (transcribe.Service, error)
+
+// Config holds the watcher configuration
+type Config struct {
+	Folders    []Folder
+	Interval   time.Duration // Time between two scans of the folders
+	Language   string        // Default language of the folders
+	FFmpegPath string
+}
+
+// Watcher scans the drop folders for new audio files and transcribes them
+// one after the other, the transcript is written next to the file as
+// <name>.txt, or <name>.error when the transcription failed
+type Watcher struct {
+	config  Config
+	vendors VendorFunc
+	jobs    chan job
+	queued  map[string]bool
+	sizes   map[string]fileState // Files seen growing on the last scan
+	done    chan string
+}
+
+type job struct {
+	path   string
+	folder Folder
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewWatcher creates a new Watcher, vendors resolves the vendor setting
+// of the folders
+func NewWatcher(config Config, vendors VendorFunc) *Watcher {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.Language == "" {
+		config.Language = "auto"
+	}
+	return &Watcher{
+		config:  config,
+		vendors: vendors,
+		jobs:    make(chan job, 1024),
+		queued:  make(map[string]bool),
+		sizes:   make(map[string]fileState),
+		done:    make(chan string, 1024),
+	}
+}
+
+// Run scans the folders until the process exits
+func (w *Watcher) Run() {
+	go w.work()
+	for _, f := range w.config.Folders {
+		log.Printf("Watching drop folder %s (language: %s, vendor: %s)", f.Path, orDefault(f.Language, w.config.Language), orDefault(f.Vendor, "default"))
+	}
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		w.scan()
+		<-ticker.C
+	}
+}
+
+// scan queues the new files that did not change since the last scan, the
+// ones still being copied are picked up later
+func (w *Watcher) scan() {
+	// Forget the files the worker finished
+	for drained := false; !drained; {
+		select {
+		case path := <-w.done:
+			delete(w.queued, path)
+		default:
+			drained = true
+		}
+	}
+
+	seen := make(map[string]fileState)
+	for _, folder := range w.config.Folders {
+		folder := folder
+		err := filepath.Walk(folder.Path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != folder.Path && (!folder.Recursive || strings.HasPrefix(info.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !w.pending(path, info) {
+				return nil
+			}
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			seen[path] = state
+			if previous, ok := w.sizes[path]; !ok || previous != state {
+				return nil
+			}
+			select {
+			case w.jobs <- job{path: path, folder: folder}:
+				w.queued[path] = true
+			default:
+				logSampler.Printf("watch:queue-full", "Drop folder queue is full, %s will be picked up later", path)
+			}
+			return nil
+		})
+		if err != nil {
+			logSampler.Printf("watch:"+folder.Path, "Error scanning drop folder %s: %v", folder.Path, err)
+		}
+	}
+	w.sizes = seen
+}
+
+// pending reports whether the file is an audio file waiting for its
+// transcript
+func (w *Watcher) pending(path string, info os.FileInfo) bool {
+	name := info.Name()
+	if strings.HasPrefix(name, ".") || !audio.IsAudioFile(name) || info.Size() == 0 {
+		return false
+	}
+	if w.queued[path] {
+		return false
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".txt", ".error"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) work() {
+	for j := range w.jobs {
+		started := time.Now()
+		err := w.transcribe(j)
+		base := strings.TrimSuffix(j.path, filepath.Ext(j.path))
+		if err != nil {
+			log.Printf("Error transcribing dropped file %s: %v", j.path, err)
+			if writeErr := writeFile(base+".error", []byte(err.Error()+"\n")); writeErr != nil {
+				log.Printf("Error writing %s.error: %v", base, writeErr)
+			}
+		} else {
+			log.Printf("Transcribed dropped file %s in %s", j.path, time.Since(started).Round(time.Second))
+		}
+		w.done <- j.path
+	}
+}
+
+// writeFile writes data atomically, so the transcript never appears
+// half written in the folder
+func writeFile(path string, data []byte) error {
+	dir, name := filepath.Split(path)
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
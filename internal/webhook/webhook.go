@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/walterfan/webrtc-transcriber/internal/events"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the timestamp,
+// a dot and the body, computed with the shared secret
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+// defaultTemplate is the payload posted when no template file is configured
+const defaultTemplate = `{
+  "event": "transcription.completed",
+  "session": {{json .ID}},
+  "user": {{json .User}},
+  "language": {{json .Language}},
+  "detected_language": {{json .Detected}},
+  "started_at": {{json .StartedAt}},
+  "ended_at": {{json .EndedAt}},
+  "duration_seconds": {{printf "%.1f" .Duration}},
+  "text": {{json .Text}},
+  "audio_file": {{json .AudioFile}},
+  "text_file": {{json .TextFile}}
+}`
+
+// Config holds the webhook delivery configuration
+type Config struct {
+	URLs         []string
+	Secret       string   // HMAC secret, requests are not signed when empty
+	TemplateFile string   // Go template rendering the session.ended JSON payload
+	Events       []string // Event types posted, session.ended by default
+}
+
+// Sink posts events to a webhook URL. The completed sessions are rendered
+// with the payload template, the other events post their Payload
+type Sink struct {
+	url      string
+	secret   []byte
+	events   map[string]bool
+	template *template.Template
+	client   *http.Client
+}
+
+// NewSinks creates one Sink per URL of the configuration, so a retry
+// never posts again to the webhooks that accepted the event
+func NewSinks(cfg Config) ([]events.Sink, error) {
+	text := defaultTemplate
+	if cfg.TemplateFile != "" {
+		content, err := os.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook template: %w", err)
+		}
+		text = string(content)
+	}
+
+	tmpl, err := template.New("payload").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook template: %w", err)
+	}
+
+	types := map[string]bool{}
+	for _, t := range cfg.Events {
+		types[t] = true
+	}
+	if len(types) == 0 {
+		types[events.SessionEnded] = true
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	var sinks []events.Sink
+	for _, url := range cfg.URLs {
+		sinks = append(sinks, &Sink{
+			url:      url,
+			secret:   []byte(cfg.Secret),
+			events:   types,
+			template: tmpl,
+			client:   client,
+		})
+	}
+	return sinks, nil
+}
+
+// Name identifies the sink in the logs and dead letters
+func (s *Sink) Name() string {
+	return "webhook " + s.url
+}
+
+// Send posts the event when its type is configured
+func (s *Sink) Send(e events.Event) error {
+	if !s.events[e.Type] {
+		return nil
+	}
+	var payload []byte
+	if e.Type == events.SessionEnded {
+		session, ok := e.Completed()
+		if !ok {
+			return nil
+		}
+		var rendered bytes.Buffer
+		if err := s.template.Execute(&rendered, session); err != nil {
+			return events.Permanent(fmt.Errorf("failed to render webhook payload: %w", err))
+		}
+		if !json.Valid(rendered.Bytes()) {
+			return events.Permanent(fmt.Errorf("webhook template did not render valid JSON"))
+		}
+		payload = rendered.Bytes()
+	} else {
+		if len(e.Payload) == 0 {
+			return nil
+		}
+		payload = e.Payload
+	}
+
+	if err := s.post(payload); err != nil {
+		return err
+	}
+	log.Printf("Webhook delivered to %s", s.url)
+	return nil
+}
+
+// SignRequest sets the timestamp and signature headers of a request
+// carrying payload
+func SignRequest(req *http.Request, secret, payload []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// post sends one request, the failures not worth retrying are permanent
+func (s *Sink) post(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return events.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(s.secret) > 0 {
+		SignRequest(req, s.secret, payload)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("HTTP status: %d", resp.StatusCode)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return err
+		}
+		return events.Permanent(err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package whip
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/walterfan/webrtc-transcriber/internal/rtc"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// maxOfferSize limits the size of the SDP offers
+const maxOfferSize = 64 << 10
+
+// Authenticator returns the user of a bearer token
+type Authenticator func(token string) (string, bool)
+
+// resource is a WHIP session created by a POST, deleted by a DELETE on its
+// location
+type resource struct {
+	user string
+	peer rtc.PeerConnection
+}
+
+// Directory shares the sessions between the replicas of a cluster, the
+// deletions of the sessions of the other replicas are forwarded to them
+type Directory interface {
+	Register(id, user string)
+	Unregister(id string)
+	// Forward asks the replica of a session to end it, it reports false
+	// when no replica has the session
+	Forward(id, user string) bool
+}
+
+// Handler implements the WebRTC-HTTP Ingestion Protocol (RFC 9725)
+type Handler struct {
+	endpoint     string
+	webrtc       rtc.Service
+	authenticate Authenticator
+	directory    Directory
+	mu           sync.Mutex
+	resources    map[string]*resource
+}
+
+// MakeHandler returns the WHIP endpoint, it must be mounted on both the
+// endpoint path and its subtree (e.g. /whip and /whip/) which holds the
+// session resources. Clients authenticate with an
+// "Authorization: Bearer <token>" header, ?language= selects the
+// transcription language, ?locale= the language of the server messages
+// (Accept-Language by default) and ?transcribe=false only records
+func MakeHandler(endpoint string, webrtcService rtc.Service, authenticate Authenticator) *Handler {
+	return &Handler{
+		endpoint:     endpoint,
+		webrtc:       webrtcService,
+		authenticate: authenticate,
+		resources:    make(map[string]*resource),
+	}
+}
+
+// SetDirectory shares the sessions with the other replicas, it is set
+// before serving
+func (h *Handler) SetDirectory(directory Directory) {
+	h.directory = directory
+}
+
+// ServeHTTP handles the WHIP requests
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Accept-Post", "application/sdp")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	user, valid := h.authenticate(strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")))
+	if !valid {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.endpoint), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r, user)
+	case id != "" && r.Method == http.MethodDelete:
+		h.delete(w, id, user)
+	default:
+		// Trickle ICE and ICE restarts (PATCH) are not supported, the
+		// answer carries all the candidates
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// create answers the SDP offer and returns the location of the resource
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, user string) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/sdp") {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOfferSize))
+	if err != nil || len(offer) == 0 {
+		http.Error(w, "Invalid SDP offer", http.StatusBadRequest)
+		return
+	}
+
+	language := r.URL.Query().Get("language")
+	if language == "" {
+		language = "auto"
+	}
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = transcribe.ParseLocale(r.Header.Get("Accept-Language"))
+	}
+	transcribeAudio := r.URL.Query().Get("transcribe") != "false"
+	log.Printf("Creating WHIP peer connection with language: %s, transcribe: %v", language, transcribeAudio)
+
+	id := newResourceID()
+	peer, err := h.webrtc.CreatePeerConnectionWithOptions(rtc.PeerConnectionOptions{
+		Language:   language,
+		Transcribe: transcribeAudio,
+		User:       user,
+		Locale:     locale,
+		Ingest:     true,
+		OnClosed:   func() { h.remove(id) },
+	})
+	if err != nil {
+		http.Error(w, "Failed to create peer connection", http.StatusInternalServerError)
+		return
+	}
+	answer, err := peer.ProcessOffer(string(offer))
+	if err != nil {
+		peer.Close()
+		http.Error(w, "Failed to process SDP offer", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	h.resources[id] = &resource{user: user, peer: peer}
+	h.mu.Unlock()
+	if h.directory != nil {
+		h.directory.Register(id, user)
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", h.endpoint+"/"+id)
+	w.Header().Set("X-Session-Id", peer.ID()) // In the recording file names and logs
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+// delete ends a session of the user, on this replica or another one
+func (h *Handler) delete(w http.ResponseWriter, id, user string) {
+	if !h.End(id, user) && (h.directory == nil || !h.directory.Forward(id, user)) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// End ends a session of the user on this replica, it reports false when
+// the session is not found
+func (h *Handler) End(id, user string) bool {
+	h.mu.Lock()
+	res, ok := h.resources[id]
+	if ok && res.user == user {
+		delete(h.resources, id)
+	}
+	h.mu.Unlock()
+	if !ok || res.user != user {
+		return false
+	}
+	if h.directory != nil {
+		h.directory.Unregister(id)
+	}
+	res.peer.Close()
+	log.Printf("WHIP session %s ended", id)
+	return true
+}
+
+// remove forgets a session whose connection failed
+func (h *Handler) remove(id string) {
+	h.mu.Lock()
+	res, ok := h.resources[id]
+	delete(h.resources, id)
+	h.mu.Unlock()
+	if ok {
+		if h.directory != nil {
+			h.directory.Unregister(id)
+		}
+		res.peer.Close()
+		log.Printf("WHIP session %s closed", id)
+	}
+}
+
+func newResourceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,263 @@
+package wsaudio
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// forkSubprotocol is the WebSocket subprotocol requested by mod_audio_fork
+const forkSubprotocol = "audio.drachtio.org"
+
+// forkChannels names the channels of a stereo fork, the caller on the left
+var forkChannels = []string{"caller", "callee"}
+
+var forkUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	Subprotocols:    []string{forkSubprotocol},
+}
+
+// forkMetadata holds the fields of the metadata of the fork used by the
+// server, the first text message when the dialplan sets one
+type forkMetadata struct {
+	Language   string `json:"language"`
+	SampleRate int    `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+	UUID       string `json:"uuid"` // Of the FreeSWITCH channel, names the recordings
+}
+
+// forkResult is a transcript sent back to FreeSWITCH, mod_audio_fork fires
+// it as a mod_audio_fork::transcription event and mod_audio_stream as a
+// mod_audio_stream::json event
+type forkResult struct {
+	Type string         `json:"type"` // transcription
+	Data forkTranscript `json:"data"`
+}
+
+type forkTranscript struct {
+	Channel    string  `json:"channel,omitempty"` // caller or callee of a stereo fork
+	Text       string  `json:"text"`
+	Final      bool    `json:"final"`
+	Confidence float32 `json:"confidence,omitempty"`
+}
+
+// RequireKey authenticates the FreeSWITCH audio forks, which send neither
+// cookies nor arbitrary headers: the key is the Bearer token, the Basic
+// password (MOD_AUDIO_BASIC_AUTH_PASSWORD) or the key query parameter
+func RequireKey(authenticate func(key string) (string, bool), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		} else if _, password, ok := r.BasicAuth(); ok {
+			key = password
+		}
+		user, ok := authenticate(key)
+		if key == "" || !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// MakeForkHandler returns an HTTP handler accepting the L16 audio of the
+// FreeSWITCH mod_audio_fork and mod_audio_stream modules: an optional JSON
+// metadata text message followed by binary frames of 16-bit little-endian
+// PCM, mono or stereo (caller and callee interleaved). The format is set
+// by the query parameters rate (8000 by default, as 8k of the modules),
+// channels (1 or 2) and language, or the same fields of the metadata.
+// Each channel is transcribed in its own stream and the results are sent
+// back as {"type":"transcription","data":{...}} messages
+func MakeForkHandler(service transcribe.Service, language string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		metadata := forkMetadata{
+			Language:   query.Get("language"),
+			SampleRate: parseRate(query.Get("rate")),
+			UUID:       query.Get("uuid"),
+		}
+		metadata.Channels, _ = strconv.Atoi(query.Get("channels"))
+
+		conn, err := forkUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Error upgrading audio fork WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(maxFrameSize)
+
+		f := &fork{
+			client:   client{conn: conn},
+			service:  service,
+			user:     auth.UserFromContext(r.Context()),
+			metadata: metadata,
+			language: language,
+		}
+		defer f.end()
+		for {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					f.completion.Set(transcribe.CompletionClientLost)
+				}
+				return
+			}
+			if messageType == websocket.TextMessage {
+				// Only the metadata before the audio sets the format
+				if f.streams == nil {
+					json.Unmarshal(data, &f.metadata)
+				}
+				continue
+			}
+			if f.streams == nil && !f.start() {
+				return
+			}
+			f.forward(data)
+		}
+	})
+}
+
+// fork is a FreeSWITCH audio fork, one transcription stream per channel
+type fork struct {
+	client
+	service    transcribe.Service
+	user       string
+	metadata   forkMetadata
+	language   string
+	streams    []transcribe.Stream
+	upsampler  []*audio.Upsampler
+	pending    []byte // Odd trailing bytes of the last frame
+	completion transcribe.Completion
+	results    sync.WaitGroup
+}
+
+// start creates the streams of the channels once the format is known, it
+// reports false when the format is not supported
+func (f *fork) start() bool {
+	m := &f.metadata
+	if m.SampleRate == 0 {
+		m.SampleRate = 8000
+	}
+	if m.Channels == 0 {
+		m.Channels = 1
+	}
+	if m.Language == "" {
+		m.Language = f.language
+	}
+	if m.SampleRate < 0 || m.SampleRate > transcribe.PipelineSampleRate || transcribe.PipelineSampleRate%m.SampleRate != 0 {
+		f.fail(fmt.Errorf("unsupported sample rate %d, use a divisor of %d", m.SampleRate, transcribe.PipelineSampleRate))
+		return false
+	}
+	if m.Channels != 1 && m.Channels != 2 {
+		f.fail(fmt.Errorf("unsupported channel count %d", m.Channels))
+		return false
+	}
+	log.Printf("Audio fork of %s started with %d Hz, %d channels, language: %s", m.UUID, m.SampleRate, m.Channels, m.Language)
+
+	f.streams = []transcribe.Stream{}
+	for i := 0; i < m.Channels; i++ {
+		opts := transcribe.StreamOptions{
+			Language:   m.Language,
+			Transcribe: true,
+			User:       f.user,
+			Completion: &f.completion,
+			SessionID:  m.UUID,
+		}
+		channel := ""
+		if m.Channels == 2 {
+			channel = forkChannels[i]
+			// Both sides of an owned call are merged in a room named after it
+			if f.user != "" && m.UUID != "" {
+				opts.Room = m.UUID
+				opts.Speaker = channel
+			}
+		}
+		stream, err := f.service.CreateStreamWithOptions(opts)
+		if err != nil {
+			log.Printf("Error creating audio fork stream: %v", err)
+			continue
+		}
+		f.streams = append(f.streams, stream)
+		f.upsampler = append(f.upsampler, audio.NewUpsampler(m.SampleRate, transcribe.PipelineSampleRate))
+		f.results.Add(1)
+		go f.forwardResults(stream, channel)
+	}
+	return len(f.streams) == m.Channels
+}
+
+// forward splits a frame of interleaved PCM between the channel streams
+func (f *fork) forward(data []byte) {
+	if len(f.streams) == 0 {
+		return
+	}
+	channels := len(f.streams)
+	frameSize := 2 * channels
+	data = append(f.pending, data...)
+	n := len(data) / frameSize
+	for c, stream := range f.streams {
+		samples := make([]int16, n)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(data[i*frameSize+2*c:]))
+		}
+		if _, err := stream.Write(f.upsampler[c].Process(samples)); err != nil {
+			logSampler.Printf("wsaudio:write", "Error writing to transcription stream: %v", err)
+		}
+	}
+	f.pending = append([]byte(nil), data[n*frameSize:]...)
+}
+
+// forwardResults sends the results of a channel back to FreeSWITCH
+func (f *fork) forwardResults(stream transcribe.Stream, channel string) {
+	defer f.results.Done()
+	for result := range stream.Results() {
+		text := strings.TrimSpace(result.Text)
+		if text == "" || result.Code != "" {
+			continue
+		}
+		f.write(&forkResult{Type: "transcription", Data: forkTranscript{
+			Channel:    channel,
+			Text:       text,
+			Final:      result.Final,
+			Confidence: result.Confidence,
+		}})
+	}
+}
+
+// end closes the streams and waits for their last results
+func (f *fork) end() {
+	for _, stream := range f.streams {
+		if err := stream.Close(); err != nil {
+			log.Printf("Error closing audio fork stream: %v", err)
+		}
+	}
+	if f.streams != nil {
+		f.results.Wait()
+		log.Printf("Audio fork of %s ended", f.metadata.UUID)
+	}
+}
+
+// parseRate parses the sample rate of the query, e.g. 16000 or 16k as the
+// modules name them, 0 when empty
+func parseRate(value string) int {
+	multiplier := 1
+	if strings.HasSuffix(value, "k") {
+		value, multiplier = strings.TrimSuffix(value, "k"), 1000
+	}
+	rate, _ := strconv.Atoi(value)
+	return rate * multiplier
+}
@@ -0,0 +1,260 @@
+package wsaudio
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/walterfan/webrtc-transcriber/internal/audio"
+	"github.com/walterfan/webrtc-transcriber/internal/auth"
+	"github.com/walterfan/webrtc-transcriber/internal/logging"
+	"github.com/walterfan/webrtc-transcriber/internal/rtc"
+	"github.com/walterfan/webrtc-transcriber/internal/transcribe"
+)
+
+// logSampler rate limits the errors of misbehaving clients
+var logSampler = logging.NewSampler(10 * time.Second)
+
+const (
+	maxFrameSize     = 1 << 20
+	handshakeTimeout = 10 * time.Second
+	idleTimeout      = 60 * time.Second
+)
+
+// Audio formats of the binary frames
+const (
+	FormatPCM  = "pcm"  // 16-bit little-endian PCM
+	FormatOpus = "opus" // One Opus packet per frame
+)
+
+// Header is the first (text) message of a client, it describes the audio
+// of the following binary frames
+type Header struct {
+	Format      string            `json:"format"`      // pcm (default) or opus
+	SampleRate  int               `json:"sample_rate"` // PCM rate dividing 48000, 16000 by default
+	Channels    int               `json:"channels"`    // PCM channels: 1 (default) or 2, downmixed
+	Language    string            `json:"language,omitempty"`
+	Transcribe  *bool             `json:"transcribe,omitempty"`     // Whether to transcribe (default: true)
+	Locale      string            `json:"locale,omitempty"`         // Language of the server messages, Accept-Language when empty
+	Diarization bool              `json:"diarization,omitempty"`    // Whether to label the speakers of the results
+	Task        string            `json:"task,omitempty"`           // transcribe (default) or translate to English, Whisper only
+	Prompt      string            `json:"prompt,omitempty"`         // Initial prompt of Whisper
+	Options     map[string]string `json:"vendor_options,omitempty"` // Vendor settings, e.g. {"deepgram.model": "nova-3"}
+}
+
+// message is a text message of the server, or the "end" message of the
+// client
+type message struct {
+	Type  string `json:"type"` // ready, result, error, end
+	Error string `json:"error,omitempty"`
+	*transcribe.Result
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// MakeHandler returns an HTTP handler streaming raw audio from WebSocket
+// clients into the transcription service. The client sends a Header, waits
+// for {"type":"ready"}, streams binary frames and sends {"type":"end"} (or
+// closes) when done. Results are sent as {"type":"result",...} messages
+// and the server closes the connection after the last one
+func MakeHandler(service transcribe.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Error upgrading audio WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(maxFrameSize)
+
+		c := &client{conn: conn}
+		conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+		header, decode, err := readHeader(conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		language := header.Language
+		if language == "" {
+			language = "auto"
+		}
+		transcribeAudio := header.Transcribe == nil || *header.Transcribe
+		log.Printf("Creating audio WebSocket stream with format: %s, language: %s, transcribe: %v", header.Format, language, transcribeAudio)
+
+		locale := header.Locale
+		if locale == "" {
+			locale = transcribe.ParseLocale(r.Header.Get("Accept-Language"))
+		}
+		completion := &transcribe.Completion{}
+		stream, err := service.CreateStreamWithOptions(transcribe.StreamOptions{
+			Language:    language,
+			Transcribe:  transcribeAudio,
+			User:        auth.UserFromContext(r.Context()),
+			Completion:  completion,
+			Locale:      locale,
+			Diarization: header.Diarization,
+			Task:        header.Task,
+			Prompt:      header.Prompt,
+			Extra:       header.Options,
+		})
+		if err != nil {
+			c.fail(fmt.Errorf("failed to create transcription stream: %w", err))
+			return
+		}
+		if err := c.write(&message{Type: "ready"}); err != nil {
+			stream.Close()
+			return
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for result := range stream.Results() {
+				result := result
+				c.write(&message{Type: "result", Result: &result})
+			}
+		}()
+
+		if lost := c.receive(decode, stream); lost {
+			log.Printf("Audio WebSocket client lost, finalizing its stream")
+			completion.Set(transcribe.CompletionClientLost)
+		}
+		if err := stream.Close(); err != nil {
+			log.Printf("Error closing audio WebSocket stream: %v", err)
+		}
+		<-done
+		c.write(&message{Type: "end"})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	})
+}
+
+// client serializes the writes to the connection
+type client struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+// write sends a JSON message, a message or the messages of the forks
+func (c *client) write(m interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteJSON(m)
+}
+
+func (c *client) fail(err error) {
+	logSampler.Printf("wsaudio:header", "Rejecting audio WebSocket: %v", err)
+	c.write(&message{Type: "error", Error: err.Error()})
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""), time.Now().Add(time.Second))
+}
+
+// receive writes the decoded frames to the stream until the client ends
+// the stream, closes the connection or stays idle. It reports whether the
+// client was lost: idle, or disconnected without a close message
+func (c *client) receive(decode func([]byte) ([]byte, error), stream transcribe.Stream) bool {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+		}
+		if messageType == websocket.TextMessage {
+			var m message
+			if json.Unmarshal(data, &m) == nil && m.Type == "end" {
+				return false
+			}
+			continue
+		}
+
+		pcm, err := decode(data)
+		if err != nil {
+			logSampler.Printf("wsaudio:decode", "Dropping audio frame: %v", err)
+			continue
+		}
+		if _, err := stream.Write(pcm); err != nil {
+			logSampler.Printf("wsaudio:write", "Error writing to transcription stream: %v", err)
+			return false
+		}
+	}
+}
+
+// readHeader reads the header and returns the decoder of its format
+func readHeader(conn *websocket.Conn) (*Header, func([]byte) ([]byte, error), error) {
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if messageType != websocket.TextMessage {
+		return nil, nil, fmt.Errorf("the first message must be a JSON header")
+	}
+	header := &Header{}
+	if err := json.Unmarshal(data, header); err != nil {
+		return nil, nil, fmt.Errorf("invalid header: %v", err)
+	}
+	if header.Task != "" && header.Task != transcribe.TaskTranscribe && header.Task != transcribe.TaskTranslate {
+		return nil, nil, fmt.Errorf("unsupported task %q, use transcribe or translate", header.Task)
+	}
+	if len([]rune(header.Prompt)) > transcribe.MaxPromptLength {
+		return nil, nil, fmt.Errorf("the prompt must have at most %d characters", transcribe.MaxPromptLength)
+	}
+	if err := transcribe.ValidateExtra(header.Options); err != nil {
+		return nil, nil, err
+	}
+
+	switch header.Format {
+	case FormatOpus:
+		decoder, err := rtc.NewOpusDecoder()
+		if err != nil {
+			return nil, nil, err
+		}
+		return header, decoder.Decode, nil
+	case "", FormatPCM:
+		header.Format = FormatPCM
+		if header.SampleRate == 0 {
+			header.SampleRate = 16000
+		}
+		if header.Channels == 0 {
+			header.Channels = 1
+		}
+		if header.SampleRate > transcribe.PipelineSampleRate || transcribe.PipelineSampleRate%header.SampleRate != 0 {
+			return nil, nil, fmt.Errorf("unsupported sample rate %d, use a divisor of %d", header.SampleRate, transcribe.PipelineSampleRate)
+		}
+		if header.Channels != 1 && header.Channels != 2 {
+			return nil, nil, fmt.Errorf("unsupported channel count %d", header.Channels)
+		}
+		return header, newPCMDecoder(header.SampleRate, header.Channels), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported format %q", header.Format)
+}
+
+// newPCMDecoder returns a decoder converting PCM frames to the pipeline
+// format, an odd trailing byte is kept for the next frame
+func newPCMDecoder(sampleRate, channels int) func([]byte) ([]byte, error) {
+	upsampler := audio.NewUpsampler(sampleRate, transcribe.PipelineSampleRate)
+	frameSize := 2 * channels
+	var pending []byte
+	return func(data []byte) ([]byte, error) {
+		data = append(pending, data...)
+		n := len(data) / frameSize
+		samples := make([]int16, n)
+		for i := range samples {
+			sample := int(int16(binary.LittleEndian.Uint16(data[i*frameSize:])))
+			if channels == 2 {
+				sample = (sample + int(int16(binary.LittleEndian.Uint16(data[i*frameSize+2:])))) / 2
+			}
+			samples[i] = int16(sample)
+		}
+		pending = append([]byte(nil), data[n*frameSize:]...)
+		return upsampler.Process(samples), nil
+	}
+}
@@ -37,13 +37,31 @@ function logout() {
     .then(res => res.json());
 }
 
-function startSession(offer, language = 'auto', enableTranscribe = true) {
+// STUN and TURN servers of the server, the public STUN server when unavailable
+const defaultIceServers = [{ urls: 'stun:stun.l.google.com:19302' }];
+
+// ICE configuration of the server: its ICE servers and transport policy
+function fetchIceConfig() {
+  return fetch('/api/ice')
+    .then(res => res.ok ? res.json() : {})
+    .then(config => ({
+      iceServers: config.iceServers && config.iceServers.length ? config.iceServers : defaultIceServers,
+      iceTransportPolicy: config.iceTransportPolicy || 'all'
+    }))
+    .catch(() => ({ iceServers: defaultIceServers, iceTransportPolicy: 'all' }));
+}
+
+function startSession(offer, language = 'auto', enableTranscribe = true, room = null) {
   return fetch('/session', {
     method: 'POST',
     body: JSON.stringify({
       offer,
       language,  // Pass language to server
-      transcribe: enableTranscribe  // Whether to transcribe or just record
+      transcribe: enableTranscribe,  // Whether to transcribe or just record
+      levels: true,  // Live audio levels for the VU meter
+      stats: true,   // Network stats of the connection
+      room: room ? room.id : undefined,    // Room whose merged transcript the session joins
+      name: room ? room.name : undefined   // Name of the speaker in the room
     }),
     headers: {
       'Content-Type': 'application/json'
@@ -93,10 +111,8 @@ function decodeDataChannelPayload(data) {
   }
 }
 
-function setupPeerConnection({ stream, onResult, onSignaling, onStop, language = 'auto', enableTranscribe = true }) {
-  const pc = new RTCPeerConnection({
-    iceServers: [{ urls: 'stun:stun.l.google.com:19302' }]
-  });
+function setupPeerConnection({ stream, iceServers = defaultIceServers, iceTransportPolicy = 'all', onResult, onLevel, onStats, onCommand, onSignaling, onStop, language = 'auto', enableTranscribe = true, room = null }) {
+  const pc = new RTCPeerConnection({ iceServers, iceTransportPolicy });
   const resChan = pc.createDataChannel('results', {
     ordered: true,
     protocol: 'tcp'
@@ -105,9 +121,29 @@ function setupPeerConnection({ stream, onResult, onSignaling, onStop, language =
     // evt.data will be an instance of ArrayBuffer OR Blob
     decodeDataChannelPayload(evt.data).then(strData => {
       const result = JSON.parse(strData);
+      if (result.type === 'level') {
+        onLevel && onLevel(result);
+        return;
+      }
+      if (result.type === 'command') {
+        onCommand && onCommand(result);
+        return;
+      }
+      if (result.type === 'stats') {
+        // The answer lets the server measure the round trip time
+        resChan.send(JSON.stringify({ type: 'pong', ping: result.ping }));
+        onStats && onStats(result);
+        return;
+      }
       onResult(result);
     });
   };
+  // Commands of the session, e.g. { type: 'pause' } or { type: 'language', language: 'fr' }
+  pc.sendCommand = cmd => {
+    if (resChan.readyState === 'open') {
+      resChan.send(JSON.stringify(cmd));
+    }
+  };
 
   // We close everything when the data channel closes
   resChan.onclose = () => {
@@ -119,7 +155,7 @@ function setupPeerConnection({ stream, onResult, onSignaling, onStop, language =
     if (!evt.candidate) {
       // ICE Gathering finished 
       const { sdp: offer } = pc.localDescription;
-      startSession(offer, language, enableTranscribe).then(answer => {
+      startSession(offer, language, enableTranscribe, room).then(answer => {
         onSignaling(offer, answer);
         const rd = new RTCSessionDescription({
           sdp: answer,
@@ -282,6 +318,12 @@ function ProcessingRow({ index, selectable }) {
 }
 
 // Delete file API function
+// Format an offset in seconds from the start of the session as m:ss
+function formatOffset(seconds) {
+  const secs = Math.floor(seconds || 0);
+  return `${Math.floor(secs / 60)}:${(secs % 60).toString().padStart(2, '0')}`;
+}
+
 function deleteFile(filename) {
   return fetch(`/delete/${filename}`, {
     method: 'DELETE'
@@ -592,7 +634,7 @@ function ResultRow({ result, index, onDelete, selectable, selected, onSelect })
             ? e('div', { style: { color: '#9ca3af', fontStyle: 'italic' } }, 'Not transcribed yet')
             : e('div', { style: { fontWeight: '500' } }, result.text),
       !canTranscribe && e('div', { cls: 'is-size-7 has-text-grey', style: { marginTop: '4px' } }, 
-        `Confidence: ${(result.confidence * 100).toFixed(1)}%`
+        `${result.end ? formatOffset(result.start) + '–' + formatOffset(result.end) + ' · ' : ''}${result.vendor ? result.vendor + ' · ' : ''}${result.speaker ? result.speaker + ' · ' : ''}Confidence: ${(result.confidence * 100).toFixed(1)}%`
       )
     ]),
     e('td', { style: { verticalAlign: 'middle' } }, audioUrl 
@@ -897,9 +939,11 @@ function LanguageSelector({ selectedLanguage, onSelect, disabled }) {
   ]);
 }
 
-function SessionStats({ duration, stats }) {
+function SessionStats({ duration, stats, level, network }) {
   const mins = Math.floor(duration / 60).toString().padStart(2, '0');
   const secs = (duration % 60).toString().padStart(2, '0');
+  // Level meter from -60 dBFS to 0
+  const meter = level ? Math.max(0, Math.min(60, level.rms + 60)) : 0;
 
   return e('nav', { cls: 'level is-mobile box' }, [
     e('div', { cls: 'level-item has-text-centered' }, [
@@ -919,6 +963,28 @@ function SessionStats({ duration, stats }) {
         e('p', { cls: 'heading' }, 'Transport'),
         e('p', { cls: 'title is-5' }, stats.transport || '-')
       ])
+    ]),
+    e('div', { cls: 'level-item has-text-centered' }, [
+      e('div', null, [
+        e('p', { cls: 'heading' }, 'Level'),
+        level && level.muted
+          ? e('p', { cls: 'title is-5 has-text-danger' }, 'Mic muted?')
+          : e('p', { cls: 'title is-5' }, level ? `${Math.round(level.rms)} dB` : '-'),
+        e('progress', {
+          cls: `progress is-small ${level && level.peak > -3 ? 'is-danger' : 'is-success'}`,
+          value: meter,
+          max: 60
+        })
+      ])
+    ]),
+    e('div', { cls: 'level-item has-text-centered' }, [
+      e('div', null, [
+        e('p', { cls: 'heading' }, 'Network'),
+        e('p', { cls: `title is-5 ${network && network.fraction_lost > 0.05 ? 'has-text-danger' : ''}` },
+          network ? `${(network.fraction_lost * 100).toFixed(1)}% lost` : '-'),
+        network && e('p', { cls: 'is-size-7' },
+          `jitter ${Math.round(network.jitter_ms)} ms` + (network.rtt_ms ? `, RTT ${Math.round(network.rtt_ms)} ms` : ''))
+      ])
     ])
   ]);
 }
@@ -1200,26 +1266,40 @@ function AppContent() {
   }, [state.active, state.pc]);
 
   function start() {
-    setState(st => ({ ...st, offer: null, answer: null, error: null, recordingDuration: 0, stats: { codec: '-', transport: '-' } }));
+    setState(st => ({ ...st, offer: null, answer: null, error: null, recordingDuration: 0, stats: { codec: '-', transport: '-' }, level: null, network: null, paused: false }));
 
     const audioConstraints = state.selectedDeviceId 
       ? { deviceId: { exact: state.selectedDeviceId } } 
       : true;
 
-    navigator.mediaDevices.getUserMedia({
-      audio: audioConstraints,
-      video: false
-    }).then(stream => {
+    Promise.all([
+      navigator.mediaDevices.getUserMedia({
+        audio: audioConstraints,
+        video: false
+      }),
+      fetchIceConfig()
+    ]).then(([stream, iceConfig]) => {
       const pc = setupPeerConnection({
-        stream, 
+        stream,
+        iceServers: iceConfig.iceServers,
+        iceTransportPolicy: iceConfig.iceTransportPolicy,
         language: state.selectedLanguage,  // Pass selected language
         enableTranscribe: state.enableTranscribe,  // Pass transcribe option
+        room: state.room ? { id: state.room, name: state.speaker } : null,
         onSignaling: (offer, answer) => setState(st => ({ ...st, offer, answer })),
         onResult: (r) => setState(st => ({ 
           ...st, 
           results: [...st.results, r],
           processing: false  // Result received, stop processing
         })),
+        onLevel: (level) => setState(st => ({ ...st, level })),
+        onStats: (network) => setState(st => ({ ...st, network })),
+        onCommand: (reply) => setState(st => ({
+          ...st,
+          paused: reply.paused,
+          selectedLanguage: reply.language || st.selectedLanguage,
+          error: reply.ok ? st.error : new Error(reply.error)
+        })),
         onStop: () => setState(st => ({ ...st, pc: null })),
       });
 
@@ -1229,6 +1309,40 @@ function AppContent() {
     });
   }
 
+  // Follows the merged transcript of the room of the active session
+  React.useEffect(() => {
+    if (!state.active || !state.room) {
+      return;
+    }
+    setState(st => ({ ...st, roomEntries: [] }));
+    const source = new EventSource(`/api/rooms/${encodeURIComponent(state.room)}/events`);
+    source.addEventListener('segment', evt => {
+      const event = JSON.parse(evt.data);
+      if (!event.final || event.code || !event.text) {
+        return;
+      }
+      setState(st => ({
+        ...st,
+        roomEntries: [...st.roomEntries, event].sort((a, b) => a.start_ms - b.start_ms)
+      }));
+    });
+    return () => source.close();
+  }, [state.active, state.room]);
+
+  // Pauses or resumes the transcription of the session
+  function togglePause() {
+    state.pc && state.pc.sendCommand({ type: state.paused ? 'resume' : 'pause' });
+  }
+
+  // Changes the language of an active session, which starts a new segment
+  function selectLanguage(lang) {
+    if (state.active && state.pc) {
+      state.pc.sendCommand({ type: 'language', language: lang });
+      return;
+    }
+    setState(st => ({ ...st, selectedLanguage: lang }));
+  }
+
   function stop() {
     state.stream && state.stream.getAudioTracks().forEach(tr => tr.stop());
     // Set processing to true and record current results count
@@ -1388,6 +1502,27 @@ function AppContent() {
             ])
           ])
         ]),
+        e('div', { cls: 'control ml-5' }, [
+          e('input', {
+            cls: 'input is-small',
+            type: 'text',
+            placeholder: 'Room (optional)',
+            title: 'Participants of the same room share a merged transcript',
+            value: state.room,
+            onChange: evt => setState(st => ({ ...st, room: evt.target.value.trim() })),
+            disabled: state.active || state.processing
+          })
+        ]),
+        state.room && e('div', { cls: 'control' }, [
+          e('input', {
+            cls: 'input is-small',
+            type: 'text',
+            placeholder: 'Your name',
+            value: state.speaker,
+            onChange: evt => setState(st => ({ ...st, speaker: evt.target.value })),
+            disabled: state.active || state.processing
+          })
+        ]),
         // Warning if nothing selected
         !canStart && e('div', { cls: 'control ml-4' }, [
           e('span', { cls: 'tag is-warning is-light' }, [
@@ -1407,6 +1542,17 @@ function AppContent() {
           processing: state.processing
         })
       ]),
+      state.active && e('div', { cls: 'control' }, [
+        e('button', {
+          cls: 'button',
+          onClick: togglePause,
+          title: state.paused ? 'Resume the transcription' : 'Pause the transcription, the audio is replaced by silence',
+          style: { height: '40px', borderRadius: '8px' }
+        }, [
+          e('span', { cls: 'icon' }, e('i', { cls: state.paused ? 'fas fa-play' : 'fas fa-pause' })),
+          e('span', null, state.paused ? 'Resume' : 'Pause')
+        ])
+      ]),
       e('div', { cls: 'control is-expanded' }, [
         e(DeviceSelector, { 
           devices: state.devices, 
@@ -1418,16 +1564,25 @@ function AppContent() {
       e('div', { cls: 'control' }, [
         e(LanguageSelector, { 
           selectedLanguage: state.selectedLanguage, 
-          onSelect: (lang) => setState(st => ({ ...st, selectedLanguage: lang })),
-          disabled: state.active || state.processing
+          onSelect: selectLanguage,
+          disabled: state.processing
         })
       ])
     ]),
     
     // Display Recording Info if active or results exist
-    (state.active || state.recordingDuration > 0) && e(SessionStats, { duration: state.recordingDuration, stats: state.stats }),
+    (state.active || state.recordingDuration > 0) && e(SessionStats, { duration: state.recordingDuration, stats: state.stats, level: state.level, network: state.network }),
 
     state.stream && e(Waveform, { stream: state.stream }),
+
+    state.room && state.roomEntries.length > 0 && e('div', { cls: 'box mt-4' }, [
+      e('h3', { cls: 'title is-6' }, `Room ${state.room}`),
+      ...state.roomEntries.map((entry, i) => e('p', { key: i }, [
+        e('span', { cls: 'has-text-grey mr-2' }, new Date(entry.start_ms).toLocaleTimeString()),
+        e('strong', { cls: 'mr-1' }, `${entry.speaker}:`),
+        entry.text
+      ]))
+    ]),
     
     e('div', { cls: 'mt-5' }, [
       e('div', { style: { display: 'flex', alignItems: 'center', justifyContent: 'space-between', marginBottom: '1rem' } }, [
@@ -1494,6 +1649,9 @@ function AppContent() {
 AppContent.initialState = {
   pc: null,
   stream: null,
+  level: null,  // Last audio level sent by the server
+  network: null,  // Last connection stats sent by the server
+  paused: false,  // Transcription paused by the pause command
   offer: null,
   answer: null,
   error: null,
@@ -1509,7 +1667,10 @@ AppContent.initialState = {
   enableRecord: true,      // Record audio checkbox
   enableTranscribe: true,  // Transcribe audio checkbox
   selectedFiles: [],       // Selected files for transcription
-  transcribingFiles: false // Processing selected files
+  transcribingFiles: false, // Processing selected files
+  room: '',                // Room joined by the session, none when empty
+  speaker: '',             // Name in the room, the username when empty
+  roomEntries: []          // Merged transcript of the room
 };
 
 function App() {